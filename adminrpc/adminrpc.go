@@ -0,0 +1,202 @@
+// Package adminrpc описывает gRPC сервис управления сервером vpnturbo.
+//
+// Сервис описан в admin.proto, и вызовы с protobuf (application/grpc) получают сообщения
+// adminpb. Те же методы принимают JSON (content-subtype "json", т.е. application/grpc+json):
+// на нем работает типизированный клиент этого пакета, которому не нужен сгенерированный код
+package adminrpc
+
+import (
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// ServiceName полное имя gRPC сервиса
+const ServiceName = "vpnturbo.admin.v1.Admin"
+
+// CodecName имя codec, которым кодируются сообщения
+const CodecName = "json"
+
+// DefaultControlSocket путь управляющего Unix сокета сервера, к которому по умолчанию
+// подключается vpnctl. Через сокет доступен тот же REST API, но без токена
+const DefaultControlSocket = "/run/myvpn.sock"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec кодирует сообщения gRPC в JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return CodecName }
+
+// ClientInfo сведения о подключенном клиенте
+type ClientInfo struct {
+	SessionID  string    `json:"session_id"`
+	Peer       string    `json:"peer"`
+	User       string    `json:"user,omitempty"` // пользователь, подтвержденный внешней аутентификацией
+	RemoteAddr string    `json:"remote_addr"`
+	VirtualIP  string    `json:"virtual_ip"`
+	RxPackets  uint64    `json:"rx_packets"`
+	RxBytes    uint64    `json:"rx_bytes"`
+	TxPackets  uint64    `json:"tx_packets"`
+	TxBytes    uint64    `json:"tx_bytes"`
+	LastSeen   time.Time `json:"last_seen"`
+	Connected  time.Time `json:"connected"`
+	// LastHandshake время последнего запроса конфигурации (подключение, переподключение, смена сети)
+	LastHandshake time.Time `json:"last_handshake,omitzero"`
+	DecryptErrors uint64    `json:"decrypt_errors"` // пакеты сессии, не прошедшие проверку подлинности
+	// RTT, jitter и потери по ответам на keepalive. Нули, пока измерений нет
+	// (клиенты старых версий не отвечают sequence keepalive)
+	RTTMs    float64 `json:"rtt_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	Loss     float64 `json:"loss"` // доля keepalive без ответа от 0 до 1
+}
+
+// Status общее состояние сервера
+type Status struct {
+	ListenAddr    string  `json:"listen_addr"`
+	TUNInterface  string  `json:"tun_interface"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Clients       int     `json:"clients"`
+	Peers         int     `json:"peers"`
+}
+
+// Типы событий сессий
+const (
+	EventConnected    = "connected"
+	EventRoamed       = "roamed"
+	EventDisconnected = "disconnected"
+)
+
+// SessionEvent событие жизненного цикла клиентской сессии
+type SessionEvent struct {
+	Type   string     `json:"type"`
+	Time   time.Time  `json:"time"`
+	Client ClientInfo `json:"client"`
+}
+
+// Empty пустое сообщение
+type Empty struct{}
+
+// ListClientsResponse ответ на ListClients
+type ListClientsResponse struct {
+	Clients []ClientInfo `json:"clients"`
+}
+
+// DisconnectClientRequest запрос на разрыв сессии (session ID в hex). Клиент получает
+// Reason, а с BanSeconds > 0 ключ его пира на это время не принимается для новых сессий
+type DisconnectClientRequest struct {
+	SessionID  string `json:"session_id"`
+	Reason     string `json:"reason,omitempty"`
+	BanSeconds int    `json:"ban_seconds,omitempty"`
+}
+
+// Ban запрет новых сессий пира
+type Ban struct {
+	Peer  string    `json:"peer"`
+	Until time.Time `json:"until"`
+}
+
+// ListBansResponse ответ на ListBans
+type ListBansResponse struct {
+	Bans []Ban `json:"bans"`
+}
+
+// ListPeersResponse ответ на ListPeers
+type ListPeersResponse struct {
+	Peers []string `json:"peers"`
+}
+
+// AddPeerRequest запрос на добавление пира. Если ключ (hex) не указан, сервер его генерирует
+type AddPeerRequest struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+// AddPeerResponse имя и ключ добавленного пира
+type AddPeerResponse struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// RevokePeerRequest запрос на отзыв ключа пира
+type RevokePeerRequest struct {
+	Name string `json:"name"`
+}
+
+// PeerRequest запрос к пиру по имени
+type PeerRequest struct {
+	Name string `json:"name"`
+}
+
+// PeerRecord сведения о пире. Status - active, disabled или revoked.
+// Время создания есть только у пиров из базы
+type PeerRecord struct {
+	Name       string    `json:"name"`
+	PublicKey  string    `json:"public_key,omitempty"`
+	AllowedIPs []string  `json:"allowed_ips,omitempty"`
+	Status     string    `json:"status"`
+	Created    time.Time `json:"created,omitzero"`
+	Updated    time.Time `json:"updated,omitzero"`
+}
+
+// RateLimit ограничение скорости клиента в битах в секунду. 0 - без ограничения.
+// Up - от клиента к серверу, Down - от сервера к клиенту
+type RateLimit struct {
+	Up   uint64 `json:"up_bps"`
+	Down uint64 `json:"down_bps"`
+}
+
+// SetPeerLimitRequest запрос на изменение лимита скорости пира
+type SetPeerLimitRequest struct {
+	Name  string    `json:"name"`
+	Limit RateLimit `json:"limit"`
+}
+
+// PeerTOTPRequest запрос на выдачу или удаление секрета TOTP пира
+type PeerTOTPRequest struct {
+	Name string `json:"name"`
+}
+
+// PeerTOTPResponse секрет TOTP пира и otpauth:// URI для приложения-аутентификатора
+type PeerTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// ReloadConfigRequest перезагрузка конфигурации. Без DNS сервер перечитывает конфигурацию
+// (-config, -peers и др.), как по SIGHUP; с DNS (в т.ч. пустым списком) только заменяет
+// DNS серверы, которые передаются клиентам
+type ReloadConfigRequest struct {
+	DNS []string `json:"dns"`
+}
+
+// ReloadConfigResponse изменения, которые применила перезагрузка
+type ReloadConfigResponse struct {
+	Changes []string `json:"changes"`
+}
+
+// StartCaptureRequest запись трафика туннеля в файл pcap на сервере
+type StartCaptureRequest struct {
+	Path string `json:"path"`
+	// LimitBytes максимальный размер файла (0 - без ограничения)
+	LimitBytes int64 `json:"limit_bytes,omitempty"`
+	// Outer записывать и внешние зашифрованные датаграммы
+	Outer bool `json:"outer,omitempty"`
+}
+
+// CaptureStatus состояние записи трафика
+type CaptureStatus struct {
+	Path    string    `json:"path"`
+	Outer   bool      `json:"outer"`
+	Started time.Time `json:"started"`
+	Packets uint64    `json:"packets"`
+	Bytes   int64     `json:"bytes"`
+	Limit   int64     `json:"limit"`
+	// Full запись остановлена, потому что файл достиг Limit
+	Full bool `json:"full"`
+}
@@ -0,0 +1,183 @@
+package adminrpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client типизированный клиент сервиса управления
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// tokenCredentials добавляет bearer токен к каждому вызову
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity разрешает токен без TLS: API рассчитан на loopback или туннель
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// Dial подключается к gRPC API сервера по адресу addr с токеном token
+func Dial(addr, token string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(tokenCredentials(token)),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CodecName)),
+	}, opts...)
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close закрывает соединение
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp)
+}
+
+// Status возвращает состояние сервера
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	resp := new(Status)
+	return resp, c.invoke(ctx, "Status", &Empty{}, resp)
+}
+
+// ListClients возвращает подключенных клиентов
+func (c *Client) ListClients(ctx context.Context) ([]ClientInfo, error) {
+	resp := new(ListClientsResponse)
+	if err := c.invoke(ctx, "ListClients", &Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Clients, nil
+}
+
+// DisconnectClient разрывает сессию клиента
+func (c *Client) DisconnectClient(ctx context.Context, sessionID string) error {
+	return c.invoke(ctx, "DisconnectClient", &DisconnectClientRequest{SessionID: sessionID}, &Empty{})
+}
+
+// KickClient разрывает сессию клиента с причиной и, если ban > 0, банит ключ его пира
+func (c *Client) KickClient(ctx context.Context, sessionID, reason string, ban time.Duration) error {
+	req := &DisconnectClientRequest{SessionID: sessionID, Reason: reason, BanSeconds: int(ban / time.Second)}
+	return c.invoke(ctx, "DisconnectClient", req, &Empty{})
+}
+
+// ListBans возвращает действующие баны пиров
+func (c *Client) ListBans(ctx context.Context) ([]Ban, error) {
+	resp := new(ListBansResponse)
+	if err := c.invoke(ctx, "ListBans", &Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Bans, nil
+}
+
+// LiftBan снимает бан пира
+func (c *Client) LiftBan(ctx context.Context, peer string) error {
+	return c.invoke(ctx, "LiftBan", &PeerRequest{Name: peer}, &Empty{})
+}
+
+// ListPeers возвращает имена пиров
+func (c *Client) ListPeers(ctx context.Context) ([]string, error) {
+	resp := new(ListPeersResponse)
+	if err := c.invoke(ctx, "ListPeers", &Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Peers, nil
+}
+
+// AddPeer добавляет пира. Пустой key - сервер сгенерирует ключ и вернет его
+func (c *Client) AddPeer(ctx context.Context, name, key string) (*AddPeerResponse, error) {
+	resp := new(AddPeerResponse)
+	return resp, c.invoke(ctx, "AddPeer", &AddPeerRequest{Name: name, Key: key}, resp)
+}
+
+// RevokePeer отзывает ключ пира
+func (c *Client) RevokePeer(ctx context.Context, name string) error {
+	return c.invoke(ctx, "RevokePeer", &RevokePeerRequest{Name: name}, &Empty{})
+}
+
+// GetPeer возвращает сведения о пире, включая его состояние
+func (c *Client) GetPeer(ctx context.Context, name string) (*PeerRecord, error) {
+	resp := new(PeerRecord)
+	return resp, c.invoke(ctx, "GetPeer", &PeerRequest{Name: name}, resp)
+}
+
+// DisablePeer временно отключает пира из базы и разрывает его сессии
+func (c *Client) DisablePeer(ctx context.Context, name string) error {
+	return c.invoke(ctx, "DisablePeer", &PeerRequest{Name: name}, &Empty{})
+}
+
+// EnablePeer включает отключенного пира
+func (c *Client) EnablePeer(ctx context.Context, name string) error {
+	return c.invoke(ctx, "EnablePeer", &PeerRequest{Name: name}, &Empty{})
+}
+
+// SetPeerLimit задает лимит скорости для всех сессий пира
+func (c *Client) SetPeerLimit(ctx context.Context, name string, limit RateLimit) error {
+	return c.invoke(ctx, "SetPeerLimit", &SetPeerLimitRequest{Name: name, Limit: limit}, &Empty{})
+}
+
+// EnablePeerTOTP выдает пиру новый секрет TOTP: после этого пир подключается только с кодом
+func (c *Client) EnablePeerTOTP(ctx context.Context, name string) (*PeerTOTPResponse, error) {
+	resp := new(PeerTOTPResponse)
+	return resp, c.invoke(ctx, "EnablePeerTOTP", &PeerTOTPRequest{Name: name}, resp)
+}
+
+// DisablePeerTOTP удаляет секрет TOTP пира
+func (c *Client) DisablePeerTOTP(ctx context.Context, name string) error {
+	return c.invoke(ctx, "DisablePeerTOTP", &PeerTOTPRequest{Name: name}, &Empty{})
+}
+
+// ReloadConfig перезагружает конфигурацию сервера или заменяет DNS серверы клиентов
+func (c *Client) ReloadConfig(ctx context.Context, req *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	resp := new(ReloadConfigResponse)
+	return resp, c.invoke(ctx, "ReloadConfig", req, resp)
+}
+
+// StartCapture начинает запись трафика туннеля в файл pcap на сервере
+func (c *Client) StartCapture(ctx context.Context, req *StartCaptureRequest) error {
+	return c.invoke(ctx, "StartCapture", req, &Empty{})
+}
+
+// StopCapture останавливает запись трафика и возвращает ее итог
+func (c *Client) StopCapture(ctx context.Context) (*CaptureStatus, error) {
+	resp := new(CaptureStatus)
+	return resp, c.invoke(ctx, "StopCapture", &Empty{}, resp)
+}
+
+// GetCapture возвращает состояние текущей записи трафика
+func (c *Client) GetCapture(ctx context.Context) (*CaptureStatus, error) {
+	resp := new(CaptureStatus)
+	return resp, c.invoke(ctx, "GetCapture", &Empty{}, resp)
+}
+
+// WatchSessions подписывается на события сессий. Поток завершается отменой ctx
+func (c *Client) WatchSessions(ctx context.Context) (grpc.ServerStreamingClient[SessionEvent], error) {
+	desc := &grpc.StreamDesc{StreamName: "WatchSessions", ServerStreams: true}
+	stream, err := c.conn.NewStream(ctx, desc, "/"+ServiceName+"/WatchSessions")
+	if err != nil {
+		return nil, err
+	}
+	s := &grpc.GenericClientStream[Empty, SessionEvent]{ClientStream: stream}
+	if err := s.SendMsg(&Empty{}); err != nil {
+		return nil, err
+	}
+	if err := s.CloseSend(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,170 @@
+package adminrpc
+
+//go:generate protoc --go_out=. --go_opt=module=myvpn/adminrpc --go-grpc_out=. --go-grpc_opt=module=myvpn/adminrpc admin.proto
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"myvpn/adminrpc/adminpb"
+)
+
+// isProtoCall сообщает, что вызов кодирует сообщения protobuf (application/grpc или
+// application/grpc+proto): тогда сервис принимает и отдает типы adminpb из admin.proto
+func isProtoCall(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, contentType := range md.Get("content-type") {
+		subtype, ok := strings.CutPrefix(strings.ToLower(contentType), "application/grpc")
+		if !ok {
+			continue
+		}
+		subtype, _, _ = strings.Cut(subtype, ";")
+		return subtype == "" || subtype == "+proto"
+	}
+	return false
+}
+
+// timestamp переводит время в Timestamp (нулевое время - поле не задано)
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// Запросы protobuf в структуры пакета
+
+func emptyFromProto(*adminpb.Empty) *Empty { return &Empty{} }
+
+func disconnectFromProto(req *adminpb.DisconnectClientRequest) *DisconnectClientRequest {
+	return &DisconnectClientRequest{SessionID: req.SessionId, Reason: req.Reason, BanSeconds: int(req.BanSeconds)}
+}
+
+func peerFromProto(req *adminpb.PeerRequest) *PeerRequest { return &PeerRequest{Name: req.Name} }
+
+func addPeerFromProto(req *adminpb.AddPeerRequest) *AddPeerRequest {
+	return &AddPeerRequest{Name: req.Name, Key: req.Key}
+}
+
+func revokePeerFromProto(req *adminpb.RevokePeerRequest) *RevokePeerRequest {
+	return &RevokePeerRequest{Name: req.Name}
+}
+
+func setPeerLimitFromProto(req *adminpb.SetPeerLimitRequest) *SetPeerLimitRequest {
+	return &SetPeerLimitRequest{Name: req.Name, Limit: RateLimit{Up: req.GetLimit().GetUpBps(), Down: req.GetLimit().GetDownBps()}}
+}
+
+func peerTOTPFromProto(req *adminpb.PeerTOTPRequest) *PeerTOTPRequest {
+	return &PeerTOTPRequest{Name: req.Name}
+}
+
+// reloadFromProto: в protobuf пустой список DNS не отличить от отсутствующего, поэтому
+// замену DNS включает replace_dns
+func reloadFromProto(req *adminpb.ReloadConfigRequest) *ReloadConfigRequest {
+	if !req.ReplaceDns {
+		return &ReloadConfigRequest{}
+	}
+	return &ReloadConfigRequest{DNS: append([]string{}, req.Dns...)}
+}
+
+func startCaptureFromProto(req *adminpb.StartCaptureRequest) *StartCaptureRequest {
+	return &StartCaptureRequest{Path: req.Path, LimitBytes: req.LimitBytes, Outer: req.Outer}
+}
+
+// Ответы из структур пакета в protobuf
+
+func emptyToProto(*Empty) *adminpb.Empty { return &adminpb.Empty{} }
+
+func statusToProto(s *Status) *adminpb.Status {
+	return &adminpb.Status{
+		ListenAddr:    s.ListenAddr,
+		TunInterface:  s.TUNInterface,
+		UptimeSeconds: s.UptimeSeconds,
+		Clients:       int32(s.Clients),
+		Peers:         int32(s.Peers),
+	}
+}
+
+func clientToProto(c *ClientInfo) *adminpb.ClientInfo {
+	return &adminpb.ClientInfo{
+		SessionId:     c.SessionID,
+		Peer:          c.Peer,
+		User:          c.User,
+		RemoteAddr:    c.RemoteAddr,
+		VirtualIp:     c.VirtualIP,
+		RxPackets:     c.RxPackets,
+		RxBytes:       c.RxBytes,
+		TxPackets:     c.TxPackets,
+		TxBytes:       c.TxBytes,
+		LastSeen:      timestamp(c.LastSeen),
+		Connected:     timestamp(c.Connected),
+		LastHandshake: timestamp(c.LastHandshake),
+		DecryptErrors: c.DecryptErrors,
+		RttMs:         c.RTTMs,
+		JitterMs:      c.JitterMs,
+		Loss:          c.Loss,
+	}
+}
+
+func listClientsToProto(resp *ListClientsResponse) *adminpb.ListClientsResponse {
+	out := &adminpb.ListClientsResponse{Clients: make([]*adminpb.ClientInfo, 0, len(resp.Clients))}
+	for i := range resp.Clients {
+		out.Clients = append(out.Clients, clientToProto(&resp.Clients[i]))
+	}
+	return out
+}
+
+func listBansToProto(resp *ListBansResponse) *adminpb.ListBansResponse {
+	out := &adminpb.ListBansResponse{Bans: make([]*adminpb.Ban, 0, len(resp.Bans))}
+	for _, ban := range resp.Bans {
+		out.Bans = append(out.Bans, &adminpb.Ban{Peer: ban.Peer, Until: timestamp(ban.Until)})
+	}
+	return out
+}
+
+func listPeersToProto(resp *ListPeersResponse) *adminpb.ListPeersResponse {
+	return &adminpb.ListPeersResponse{Peers: resp.Peers}
+}
+
+func addPeerToProto(resp *AddPeerResponse) *adminpb.AddPeerResponse {
+	return &adminpb.AddPeerResponse{Name: resp.Name, Key: resp.Key}
+}
+
+func peerRecordToProto(r *PeerRecord) *adminpb.PeerRecord {
+	return &adminpb.PeerRecord{
+		Name:       r.Name,
+		PublicKey:  r.PublicKey,
+		AllowedIps: r.AllowedIPs,
+		Status:     r.Status,
+		Created:    timestamp(r.Created),
+		Updated:    timestamp(r.Updated),
+	}
+}
+
+func peerTOTPToProto(resp *PeerTOTPResponse) *adminpb.PeerTOTPResponse {
+	return &adminpb.PeerTOTPResponse{Secret: resp.Secret, Uri: resp.URI}
+}
+
+func reloadToProto(resp *ReloadConfigResponse) *adminpb.ReloadConfigResponse {
+	return &adminpb.ReloadConfigResponse{Changes: resp.Changes}
+}
+
+func captureToProto(s *CaptureStatus) *adminpb.CaptureStatus {
+	return &adminpb.CaptureStatus{
+		Path:    s.Path,
+		Outer:   s.Outer,
+		Started: timestamp(s.Started),
+		Packets: s.Packets,
+		Bytes:   s.Bytes,
+		Limit:   s.Limit,
+		Full:    s.Full,
+	}
+}
+
+func eventToProto(e *SessionEvent) *adminpb.SessionEvent {
+	return &adminpb.SessionEvent{Type: e.Type, Time: timestamp(e.Time), Client: clientToProto(&e.Client)}
+}
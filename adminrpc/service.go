@@ -0,0 +1,128 @@
+package adminrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"myvpn/adminrpc/adminpb"
+)
+
+// Service интерфейс, который реализует сервер
+type Service interface {
+	Status(ctx context.Context, req *Empty) (*Status, error)
+	ListClients(ctx context.Context, req *Empty) (*ListClientsResponse, error)
+	DisconnectClient(ctx context.Context, req *DisconnectClientRequest) (*Empty, error)
+	ListBans(ctx context.Context, req *Empty) (*ListBansResponse, error)
+	LiftBan(ctx context.Context, req *PeerRequest) (*Empty, error)
+	ListPeers(ctx context.Context, req *Empty) (*ListPeersResponse, error)
+	AddPeer(ctx context.Context, req *AddPeerRequest) (*AddPeerResponse, error)
+	RevokePeer(ctx context.Context, req *RevokePeerRequest) (*Empty, error)
+	GetPeer(ctx context.Context, req *PeerRequest) (*PeerRecord, error)
+	DisablePeer(ctx context.Context, req *PeerRequest) (*Empty, error)
+	EnablePeer(ctx context.Context, req *PeerRequest) (*Empty, error)
+	SetPeerLimit(ctx context.Context, req *SetPeerLimitRequest) (*Empty, error)
+	EnablePeerTOTP(ctx context.Context, req *PeerTOTPRequest) (*PeerTOTPResponse, error)
+	DisablePeerTOTP(ctx context.Context, req *PeerTOTPRequest) (*Empty, error)
+	ReloadConfig(ctx context.Context, req *ReloadConfigRequest) (*ReloadConfigResponse, error)
+	StartCapture(ctx context.Context, req *StartCaptureRequest) (*Empty, error)
+	StopCapture(ctx context.Context, req *Empty) (*CaptureStatus, error)
+	GetCapture(ctx context.Context, req *Empty) (*CaptureStatus, error)
+	// WatchSessions отправляет события сессий, пока клиент не отменит вызов
+	WatchSessions(req *Empty, stream grpc.ServerStreamingServer[SessionEvent]) error
+}
+
+// Register регистрирует реализацию сервиса на gRPC сервере
+func Register(s *grpc.Server, srv Service) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// unaryHandler строит обработчик unary метода. Вызов с JSON codec получает структуры
+// пакета, а с protobuf сообщение adminpb переводится в них fromProto, а ответ обратно toProto
+func unaryHandler[Req, Resp, PReq, PResp any](method string, call func(Service, context.Context, *Req) (*Resp, error),
+	fromProto func(*PReq) *Req, toProto func(*Resp) *PResp) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			var req *Req
+			protoCall := isProtoCall(ctx)
+			if protoCall {
+				preq := new(PReq)
+				if err := dec(preq); err != nil {
+					return nil, err
+				}
+				req = fromProto(preq)
+			} else {
+				req = new(Req)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+			}
+			handle := func(ctx context.Context, req interface{}) (interface{}, error) {
+				resp, err := call(srv.(Service), ctx, req.(*Req))
+				if err != nil || !protoCall {
+					return resp, err
+				}
+				return toProto(resp), nil
+			}
+			if interceptor == nil {
+				return handle(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + method}
+			return interceptor(ctx, req, info, handle)
+		},
+	}
+}
+
+// eventStream отправляет события сессий сообщениями adminpb.SessionEvent
+type eventStream struct {
+	grpc.ServerStream
+}
+
+func (s eventStream) Send(event *SessionEvent) error {
+	return s.ServerStream.SendMsg(eventToProto(event))
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Service)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("Status", Service.Status, emptyFromProto, statusToProto),
+		unaryHandler("ListClients", Service.ListClients, emptyFromProto, listClientsToProto),
+		unaryHandler("DisconnectClient", Service.DisconnectClient, disconnectFromProto, emptyToProto),
+		unaryHandler("ListBans", Service.ListBans, emptyFromProto, listBansToProto),
+		unaryHandler("LiftBan", Service.LiftBan, peerFromProto, emptyToProto),
+		unaryHandler("ListPeers", Service.ListPeers, emptyFromProto, listPeersToProto),
+		unaryHandler("AddPeer", Service.AddPeer, addPeerFromProto, addPeerToProto),
+		unaryHandler("RevokePeer", Service.RevokePeer, revokePeerFromProto, emptyToProto),
+		unaryHandler("GetPeer", Service.GetPeer, peerFromProto, peerRecordToProto),
+		unaryHandler("DisablePeer", Service.DisablePeer, peerFromProto, emptyToProto),
+		unaryHandler("EnablePeer", Service.EnablePeer, peerFromProto, emptyToProto),
+		unaryHandler("SetPeerLimit", Service.SetPeerLimit, setPeerLimitFromProto, emptyToProto),
+		unaryHandler("EnablePeerTOTP", Service.EnablePeerTOTP, peerTOTPFromProto, peerTOTPToProto),
+		unaryHandler("DisablePeerTOTP", Service.DisablePeerTOTP, peerTOTPFromProto, emptyToProto),
+		unaryHandler("ReloadConfig", Service.ReloadConfig, reloadFromProto, reloadToProto),
+		unaryHandler("StartCapture", Service.StartCapture, startCaptureFromProto, emptyToProto),
+		unaryHandler("StopCapture", Service.StopCapture, emptyFromProto, captureToProto),
+		unaryHandler("GetCapture", Service.GetCapture, emptyFromProto, captureToProto),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchSessions",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				if isProtoCall(stream.Context()) {
+					if err := stream.RecvMsg(new(adminpb.Empty)); err != nil {
+						return err
+					}
+					return srv.(Service).WatchSessions(&Empty{}, eventStream{stream})
+				}
+				req := new(Empty)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(Service).WatchSessions(req, &grpc.GenericServerStream[Empty, SessionEvent]{ServerStream: stream})
+			},
+		},
+	},
+}
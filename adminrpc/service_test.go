@@ -0,0 +1,166 @@
+package adminrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"myvpn/adminrpc/adminpb"
+)
+
+// testService отвечает фиксированными данными и запоминает последние запросы
+type testService struct {
+	Service // методы, которые тест не вызывает
+
+	connected  time.Time
+	disconnect *DisconnectClientRequest
+	reload     *ReloadConfigRequest
+}
+
+func (t *testService) Status(ctx context.Context, req *Empty) (*Status, error) {
+	return &Status{ListenAddr: ":8080", TUNInterface: "myvpn0", Clients: 2, Peers: 3}, nil
+}
+
+func (t *testService) ListClients(ctx context.Context, req *Empty) (*ListClientsResponse, error) {
+	return &ListClientsResponse{Clients: []ClientInfo{{SessionID: "01", Peer: "alice", RxBytes: 1 << 40, Connected: t.connected}}}, nil
+}
+
+func (t *testService) DisconnectClient(ctx context.Context, req *DisconnectClientRequest) (*Empty, error) {
+	t.disconnect = req
+	return &Empty{}, nil
+}
+
+func (t *testService) ReloadConfig(ctx context.Context, req *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	t.reload = req
+	return &ReloadConfigResponse{Changes: []string{"dns"}}, nil
+}
+
+func (t *testService) WatchSessions(req *Empty, stream grpc.ServerStreamingServer[SessionEvent]) error {
+	return stream.Send(&SessionEvent{Type: EventConnected, Time: t.connected, Client: ClientInfo{SessionID: "01"}})
+}
+
+// serve запускает сервис на bufconn и возвращает соединение к нему
+func serve(t *testing.T, srv Service, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	Register(gs, srv)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient("passthrough:///bufconn", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProtobufCodec(t *testing.T) {
+	srv := &testService{connected: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	client := adminpb.NewAdminClient(serve(t, srv))
+	ctx := context.Background()
+
+	status, err := client.Status(ctx, &adminpb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TunInterface != "myvpn0" || status.Clients != 2 || status.Peers != 3 {
+		t.Errorf("Status = %v", status)
+	}
+
+	clients, err := client.ListClients(ctx, &adminpb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients.Clients) != 1 || clients.Clients[0].RxBytes != 1<<40 || !clients.Clients[0].Connected.AsTime().Equal(srv.connected) {
+		t.Errorf("ListClients = %v", clients)
+	}
+	if clients.Clients[0].LastSeen != nil {
+		t.Errorf("zero LastSeen encoded as %v, want unset", clients.Clients[0].LastSeen)
+	}
+
+	if _, err := client.DisconnectClient(ctx, &adminpb.DisconnectClientRequest{SessionId: "01", Reason: "bye", BanSeconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if *srv.disconnect != (DisconnectClientRequest{SessionID: "01", Reason: "bye", BanSeconds: 60}) {
+		t.Errorf("DisconnectClient got %+v", srv.disconnect)
+	}
+
+	// Без replace_dns - перечитывание конфигурации, с ним - замена DNS (в т.ч. пустым списком)
+	if _, err := client.ReloadConfig(ctx, &adminpb.ReloadConfigRequest{Dns: []string{"1.1.1.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.reload.DNS != nil {
+		t.Errorf("ReloadConfig without replace_dns got DNS %v", srv.reload.DNS)
+	}
+	if _, err := client.ReloadConfig(ctx, &adminpb.ReloadConfigRequest{ReplaceDns: true}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.reload.DNS == nil || len(srv.reload.DNS) != 0 {
+		t.Errorf("ReloadConfig with replace_dns got DNS %#v, want empty list", srv.reload.DNS)
+	}
+
+	stream, err := client.WatchSessions(ctx, &adminpb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventConnected || event.Client.SessionId != "01" || !event.Time.AsTime().Equal(srv.connected) {
+		t.Errorf("WatchSessions = %v", event)
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	srv := &testService{connected: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	client := &Client{conn: serve(t, srv, grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CodecName)))}
+	ctx := context.Background()
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TUNInterface != "myvpn0" || status.Clients != 2 {
+		t.Errorf("Status = %+v", status)
+	}
+
+	clients, err := client.ListClients(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].RxBytes != 1<<40 || !clients[0].Connected.Equal(srv.connected) {
+		t.Errorf("ListClients = %+v", clients)
+	}
+
+	// Пустой список DNS в JSON отличается от отсутствующего
+	resp, err := client.ReloadConfig(ctx, &ReloadConfigRequest{DNS: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.reload.DNS == nil || len(resp.Changes) != 1 {
+		t.Errorf("ReloadConfig got DNS %#v, response %+v", srv.reload.DNS, resp)
+	}
+
+	stream, err := client.WatchSessions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventConnected || event.Client.SessionID != "01" || !event.Time.Equal(srv.connected) {
+		t.Errorf("WatchSessions = %+v", event)
+	}
+}
@@ -1,41 +1,338 @@
 package client
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"go.opentelemetry.io/otel/attribute"
 	"myvpn/internal"
+	"myvpn/internal/bufpool"
 	"myvpn/internal/compress"
+	"myvpn/internal/logging"
+	"myvpn/internal/netstate"
+	"myvpn/internal/pcap"
+	"myvpn/internal/porthop"
+	"myvpn/internal/socks5"
+	"myvpn/internal/tracing"
 	"myvpn/internal/transport"
 )
 
+const (
+	// KeepaliveInterval интервал отправки keepalive пакетов серверу
+	KeepaliveInterval = 30 * time.Second
+	// DeadPeerTimeout время без входящих пакетов, после которого соединение считается потерянным
+	DeadPeerTimeout = 3 * KeepaliveInterval
+	// ReconnectInitialDelay начальная задержка перед переподключением
+	ReconnectInitialDelay = 1 * time.Second
+	// ReconnectMaxDelay максимальная задержка между попытками переподключения
+	ReconnectMaxDelay = 60 * time.Second
+	// NetworkCheckInterval интервал проверки смены локальных адресов (роуминг)
+	NetworkCheckInterval = 2 * time.Second
+	// ConfigRequestInterval интервал повтора запроса конфигурации, пока сервер не ответит
+	ConfigRequestInterval = 2 * time.Second
+	// ConfigRequestAttempts число попыток запроса конфигурации после подключения
+	ConfigRequestAttempts = 5
+	// PathMTUInterval интервал повторного поиска PMTU (путь до сервера может измениться)
+	PathMTUInterval = 10 * time.Minute
+	// MinTUNMTU минимальный MTU TUN интерфейса (IPv4), MinTUNMTU6 - если на интерфейсе есть IPv6
+	MinTUNMTU  = 576
+	MinTUNMTU6 = 1280
+	// DefaultTransportTimeout время на установку сессии через один вид транспорта
+	// перед переходом к следующему (UDP -> TCP -> WSS)
+	DefaultTransportTimeout = 10 * time.Second
+	// MultipathKeepaliveInterval интервал keepalive по каждому пути при multipath: ответы
+	// на keepalive показывают, жив ли путь, по которому сейчас не идут данные
+	MultipathKeepaliveInterval = 5 * time.Second
+	// PathDeadTimeout время без входящих пакетов по пути, после которого пакеты
+	// к серверу перестают через него отправляться
+	PathDeadTimeout = 3 * MultipathKeepaliveInterval
+)
+
+// AutoAddress значение ClientIP и ClientIP6, при котором адреса TUN назначает сервер
+const AutoAddress = "auto"
+
+// Адреса TUN, если сервер не назначил адреса (сервер без пула адресов)
+const (
+	DefaultClientIP  = "10.0.0.2/24"
+	DefaultClientIP6 = "fd00::2/64"
+)
+
+// Режимы multipath
+const (
+	// MultipathBond пакеты распределяются по всем живым путям по очереди
+	MultipathBond = "bond"
+	// MultipathStandby пакеты идут по первому живому пути, остальные держатся в резерве
+	MultipathStandby = "standby"
+)
+
 // VPNClient
 type VPNClient struct {
 	serverAddr   string
-	tun          *TUN
+	tun          Device
 	crypto       *internal.Crypto
 	protocol     *internal.Protocol
-	transport    *transport.UDPTransport
+	transport    atomic.Pointer[transport.UDPTransport] // читается на каждый пакет, поэтому без мьютекса
+	reconnect    chan struct{}
+	migrate      chan struct{} // смена локальной сети: перенести сессию на новый сокет
+	sessionID    uint64
 	socks5Proxy  string
+	socks5Listen string          // адрес SOCKS5 сервера клиента (пусто - TUN)
+	socks5Server *socks5.Server  // SOCKS5 сервер поверх netstack
+	netstack     *NetstackDevice // userspace стек вместо TUN (nil - TUN или Device)
 	routeManager *RouteManager
+	dnsManager   *DNSManager
+	dns          []string // DNS серверы из конфигурации клиента вместо присланных сервером
+	killSwitch   *KillSwitch
+	dnsGuard     *DNSGuard // защита от утечек DNS (nil - выключена)
+	state        *netstate.Store
+	configured   atomic.Bool
+	configReady  chan struct{} // закрывается после применения первой конфигурации сервера
+	readyOnce    sync.Once
+	serverCaps   atomic.Uint64  // возможности сервера из последнего ответа на запрос конфигурации
+	tunWriters   []chan []byte  // очереди записи в multi-queue TUN (пусто при одной очереди)
+	retryAfter   atomic.Int64   // задержка перед переподключением, которую запросил сервер при отказе
+	handshakeAt  atomic.Int64   // время первого запроса конфигурации без ответа (UnixNano, 0 - нет)
+	pathMTU      bool           // поиск PMTU и подстройка MTU TUN
+	compression  compress.Codec // предпочтительный кодек сжатия, CodecNone - auto
+	noCompress   bool           // сжатие выключено (-compress=off)
+	sendCodec    atomic.Uint32  // кодек, согласованный с сервером (до ответа на запрос конфигурации - без сжатия)
+	adaptive     *compress.Adaptive
+	obfuscation  transport.Obfuscation
+	protect      func(fd int) error
+	dscp         int               // DSCP датаграмм к серверу (transport.SetDSCP), 0 - не задан
+	fwmark       uint32            // метка fwmark сокетов к серверу, 0 - не задана
+	hop          *porthop.Schedule // расписание смены порта сервера (nil - port hopping выключен)
+	connGen      atomic.Uint64     // номер подключения, растет при каждом переподключении
+	transports   []string          // виды транспорта в порядке попыток
+	kindIdx      atomic.Int32      // индекс текущего вида транспорта в transports
+	kindTimeout  time.Duration     // время на установку сессии через один вид транспорта
+	established  atomic.Int32      // 1 + индекс вида транспорта последней установленной сессии
+	streamOpts   transport.StreamOptions
+	minMTU       int
+	mtu          int // текущий MTU TUN
+	maxMTU       int // наибольший MTU TUN: с FEC меньше internal.TUNMTU
+	dnsSavedMTU  int // MTU TUN до перехода на DNS туннель (0 - не уменьшался)
+	fec          transport.FEC
+	multipath    []string      // интерфейсы путей до сервера (пусто - один путь)
+	bond         bool          // пакеты распределяются по всем путям (иначе hot standby)
+	pathIdx      atomic.Uint32 // счетчик для чередования путей при bonding
+	mtuMu        sync.Mutex
 	done         chan struct{}
+	ready        chan struct{} // закрывается, когда туннель настроен
+	closed       chan struct{} // закрывается, когда Close восстановил маршруты и DNS
+	closeOnce    sync.Once
 	wg           sync.WaitGroup
-	verbose      bool
 	autoRoutes   bool
+	autoIP       bool                     // адреса TUN назначает сервер
+	ipv6         bool                     // на TUN есть IPv6
+	address      string                   // адреса TUN, назначенные последними
+	pushedRoutes atomic.Pointer[[]string] // маршруты из конфигурации сервера
+	totp         func() (string, error)
+	totpCode     atomic.Pointer[string] // код для следующего запроса конфигурации
+	totpPending  atomic.Bool            // пользователь вводит код
+	configGen    atomic.Uint64          // номер запроса конфигурации: повторы старого запроса прекращаются
+	username     string
+	password     string
+	capture      *pcap.Capture // запись трафика в pcap (nil - выключена)
+	rxPackets    atomic.Uint64 // пакеты от сервера, записанные в TUN
+	rxBytes      atomic.Uint64
+	txPackets    atomic.Uint64 // пакеты из TUN, отправленные серверу
+	txBytes      atomic.Uint64
+	reconnects   atomic.Uint64
+	routesMu     sync.Mutex // маршруты меняются командой управления и при смене сети
+	started      time.Time
+	p2p          bool                // прямой обмен с другими клиентами (-p2p)
+	peers        map[string]*p2pPeer // пиры по адресам внутри VPN
+	peerSessions map[uint64]*p2pPeer // пиры по session ID пары
+	peersMu      sync.RWMutex
 }
 
 // NewVPNClient создает новый VPN клиент
-func NewVPNClient(serverAddr string, key []byte, clientIP string, verbose bool, autoRoutes bool, socks5Proxy string) (*VPNClient, error) {
-	// Создаем TUN интерфейс
-	tun, err := NewTUN(TUNInterfaceName, clientIP)
+func NewVPNClient(cfg Config) (*VPNClient, error) {
+	transports := cfg.Transports
+	if len(transports) == 0 {
+		transports = []string{transport.KindUDP}
+	}
+	kindTimeout := cfg.TransportTimeout
+	if kindTimeout <= 0 {
+		kindTimeout = DefaultTransportTimeout
+	}
+	streamOpts := transport.StreamOptions{
+		TCPAddr:     cfg.TCPAddr,
+		TCPTLS:      cfg.TCPTLS,
+		WSSURL:      cfg.WSSURL,
+		KCPAddr:     cfg.KCPAddr,
+		KCPFEC:      cfg.KCPFEC,
+		GRPCAddr:    cfg.GRPCAddr,
+		GRPCService: cfg.GRPCService,
+		DNSZone:     cfg.DNSTunnelZone,
+		Timeout:     kindTimeout,
+		Mark:        cfg.FwMark,
+		Protect:     cfg.Protect,
+	}
+	if streamOpts.TCPAddr == "" {
+		streamOpts.TCPAddr = cfg.ServerAddr
+	}
+	if err := transport.CheckTLSFingerprint(cfg.TLSFingerprint); err != nil {
+		return nil, err
+	}
+	streamOpts.TLSFingerprint = cfg.TLSFingerprint
+	if cfg.WSSInsecure || cfg.TLSCert != "" || cfg.TLSServerName != "" {
+		streamOpts.TLS = &tls.Config{InsecureSkipVerify: cfg.WSSInsecure, ServerName: cfg.TLSServerName}
+	}
+	if cfg.TLSCert != "" {
+		// Сертификат клиента для серверов, которые пускают только клиентов своего центра сертификации
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		streamOpts.TLS.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.HTTPProxy != "" {
+		proxy, err := transport.ParseHTTPProxy(cfg.HTTPProxy)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(transports, transport.KindTCP) && !slices.Contains(transports, transport.KindWSS) &&
+			!slices.Contains(transports, transport.KindGRPC) {
+			return nil, fmt.Errorf("HTTP proxy requires the tcp, wss or grpc transport")
+		}
+		streamOpts.HTTPProxy = proxy
+	}
+	for _, kind := range transports {
+		if kind != transport.KindUDP && cfg.Socks5Proxy != "" {
+			return nil, fmt.Errorf("transport %s cannot be used with SOCKS5 proxy", kind)
+		}
+		if kind == transport.KindWSS && cfg.WSSURL == "" {
+			return nil, fmt.Errorf("transport wss requires a WebSocket URL")
+		}
+		if kind == transport.KindKCP && cfg.KCPAddr == "" {
+			return nil, fmt.Errorf("transport kcp requires a KCP address")
+		}
+		if kind == transport.KindGRPC && cfg.GRPCAddr == "" {
+			return nil, fmt.Errorf("transport grpc requires a gRPC address")
+		}
+		if kind == transport.KindDNS {
+			if cfg.DNSTunnelZone == "" {
+				return nil, fmt.Errorf("transport dns requires a DNS tunnel zone")
+			}
+			if cfg.DNSLeakProtection != "" && cfg.DNSLeakProtection != DNSProtectOff {
+				// Запросы туннеля идут резолверу мимо TUN, и защита их бы отклоняла
+				return nil, fmt.Errorf("transport dns cannot be used with DNS leak protection")
+			}
+			resolver := cfg.DNSTunnelResolver
+			if resolver == "" {
+				// Адрес берется до того, как клиент поменяет DNS системы на DNS из VPN
+				var err error
+				if resolver, err = transport.SystemResolver(); err != nil {
+					return nil, fmt.Errorf("transport dns requires a resolver: %w", err)
+				}
+			}
+			streamOpts.DNSResolver = transport.DNSResolverAddr(resolver)
+		}
+	}
+	bond := true
+	switch cfg.MultipathMode {
+	case "", MultipathBond:
+	case MultipathStandby:
+		bond = false
+	default:
+		return nil, fmt.Errorf("unknown multipath mode %q (expected bond or standby)", cfg.MultipathMode)
+	}
+	if len(cfg.Multipath) == 1 {
+		return nil, fmt.Errorf("multipath requires at least two interfaces")
+	}
+	if len(cfg.Multipath) > 0 && cfg.Socks5Proxy != "" {
+		return nil, fmt.Errorf("multipath cannot be used with SOCKS5 proxy")
+	}
+	if cfg.P2P && (cfg.Socks5Proxy != "" || len(cfg.Multipath) > 0) {
+		return nil, fmt.Errorf("peer-to-peer cannot be used with SOCKS5 proxy or multipath")
+	}
+	if cfg.FwMark != 0 && cfg.Socks5Proxy != "" {
+		// Соединение прокси с сервером метки не получит и ушло бы в TUN
+		return nil, fmt.Errorf("fwmark cannot be used with SOCKS5 proxy")
+	}
+	if cfg.NetNS != "" {
+		// Маршруты, firewall и DNS клиента настраиваются в его пространстве имен,
+		// а туннель в другом: в нем весь трафик и так идет через TUN
+		if cfg.TUNFD > 0 || cfg.Device != nil {
+			return nil, fmt.Errorf("network namespace cannot be used with a pre-opened TUN descriptor")
+		}
+		if cfg.AutoRoutes || len(cfg.Routes) > 0 || cfg.KillSwitch ||
+			(cfg.DNSLeakProtection != "" && cfg.DNSLeakProtection != DNSProtectOff) || cfg.AcceptDNS || len(cfg.DNS) > 0 {
+			return nil, fmt.Errorf("network namespace cannot be used with routes, kill switch or DNS settings")
+		}
+	}
+	if cfg.Socks5Listen != "" {
+		// Без TUN трафик системы в туннель не попадает: менять ее маршруты, firewall и DNS незачем
+		if cfg.TUNFD > 0 || cfg.Device != nil || cfg.NetNS != "" {
+			return nil, fmt.Errorf("SOCKS5 listener cannot be used with a TUN descriptor or network namespace")
+		}
+		if cfg.AutoRoutes || len(cfg.Routes) > 0 || cfg.KillSwitch ||
+			(cfg.DNSLeakProtection != "" && cfg.DNSLeakProtection != DNSProtectOff) || cfg.AcceptDNS {
+			return nil, fmt.Errorf("SOCKS5 listener cannot be used with routes, kill switch or system DNS settings")
+		}
+	}
+	endpoints, err := streamOpts.Endpoints(transports)
+	if err != nil {
+		return nil, err
+	}
+
+	autoIP := cfg.ClientIP == AutoAddress
+	if cfg.ClientIP6 == AutoAddress && !autoIP {
+		return nil, fmt.Errorf("server-assigned IPv6 address requires server-assigned IPv4 address")
+	}
+	ipv6 := cfg.ClientIP6 != ""
+
+	// Создаем TUN интерфейс. Адреса, назначенные сервером, появятся после подключения
+	queues := cfg.TUNQueues
+	if queues < 1 {
+		queues = 1
+	}
+	clientIP, clientIP6 := cfg.ClientIP, cfg.ClientIP6
+	if autoIP {
+		clientIP, clientIP6 = "", ""
+	}
+	// Маршруты и правила, оставшиеся после аварийного завершения, удаляются до того,
+	// как появятся новые
+	if cfg.StateFile != "" {
+		if err := netstate.Recover(cfg.StateFile); err != nil {
+			return nil, fmt.Errorf("failed to remove leftover network settings: %w", err)
+		}
+	}
+	var tun Device
+	var stack *NetstackDevice
+	switch {
+	case cfg.Device != nil:
+		tun = cfg.Device
+	case cfg.Socks5Listen != "":
+		stack, err = NewNetstackDevice(clientIP, clientIP6)
+		tun = stack
+	case cfg.TUNFD > 0:
+		if queues > 1 {
+			return nil, fmt.Errorf("multiple TUN queues cannot be used with a pre-opened TUN descriptor")
+		}
+		tun, err = NewTUNFromFD(cfg.TUNFD)
+	default:
+		tun, err = NewTUN(TUNInterfaceName, cfg.NetNS, clientIP, clientIP6, queues, cfg.TUNOffload)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TUN interface: %w", err)
 	}
 
 	// применяем шифрование
-	crypto, err := internal.NewCrypto(key)
+	crypto, err := internal.NewCrypto(cfg.Key)
 	if err != nil {
 		tun.Close()
 		return nil, fmt.Errorf("failed to create crypto: %w", err)
@@ -45,70 +342,1190 @@ func NewVPNClient(serverAddr string, key []byte, clientIP string, verbose bool,
 	protocol := internal.NewProtocol(crypto)
 
 	// Создаем менеджер маршрутов только если включена автоматическая настройка
+	// или заданы маршруты для split tunneling
+	autoRoutes := cfg.AutoRoutes || len(cfg.Routes) > 0
 	var routeManager *RouteManager
 	if autoRoutes {
-		routeManager, err = NewRouteManager(TUNInterfaceName, serverAddr)
+		excludeRoutes := cfg.ExcludeRoutes
+		if streamOpts.HTTPProxy != nil {
+			// Соединение с прокси, как и с сервером, должно идти мимо туннеля
+			ips, err := net.LookupIP(streamOpts.HTTPProxy.Hostname())
+			if err != nil {
+				tun.Close()
+				return nil, fmt.Errorf("failed to resolve HTTP proxy: %w", err)
+			}
+			excludeRoutes = slices.Clone(excludeRoutes)
+			for _, ip := range ips {
+				excludeRoutes = append(excludeRoutes, ip.String())
+			}
+		}
+		routeManager, err = NewRouteManager(tun.Name(), cfg.ServerAddr, ipv6, cfg.Routes, cfg.FwMark)
+		if err == nil {
+			err = routeManager.SetExcludeLAN(cfg.ExcludeLAN)
+		}
+		if err == nil {
+			err = routeManager.SetExcludeRoutes(excludeRoutes)
+		}
 		if err != nil {
 			tun.Close()
 			return nil, fmt.Errorf("failed to create route manager: %w", err)
 		}
 	}
 
+	// DNS серверы, присланные сервером, применяются только если это разрешено;
+	// заданные в конфигурации клиента - всегда
+	var dnsManager *DNSManager
+	if cfg.AcceptDNS || len(cfg.DNS) > 0 {
+		dnsManager = NewDNSManager(tun.Name())
+	}
+
+	var killSwitch *KillSwitch
+	if cfg.KillSwitch {
+		// Исключенные из VPN сети достижимы и при kill switch
+		allow := append(slices.Clone(cfg.KillSwitchAllow), cfg.ExcludeRoutes...)
+		killSwitch, err = NewKillSwitch(tun.Name(), cfg.ServerAddr, allow)
+		if err != nil {
+			tun.Close()
+			return nil, fmt.Errorf("failed to create kill switch: %w", err)
+		}
+		if cfg.PortHop.Enabled() {
+			killSwitch.serverPorts = cfg.PortHop
+		}
+		for _, endpoint := range endpoints {
+			addr, err := net.ResolveTCPAddr("tcp", endpoint.Addr)
+			if err != nil {
+				tun.Close()
+				return nil, fmt.Errorf("failed to resolve %s: %w", endpoint.Addr, err)
+			}
+			killSwitch.streams = append(killSwitch.streams, streamEndpoint{network: endpoint.Network, addr: addr})
+		}
+	}
+
+	var dnsGuard *DNSGuard
+	if cfg.DNSLeakProtection != "" && cfg.DNSLeakProtection != DNSProtectOff {
+		if dnsGuard, err = NewDNSGuard(tun.Name(), cfg.DNSLeakProtection); err != nil {
+			tun.Close()
+			return nil, err
+		}
+	}
+
+	var hop *porthop.Schedule
+	if cfg.PortHop.Enabled() {
+		hop = porthop.NewSchedule(cfg.Key, cfg.PortHop, cfg.PortHopInterval)
+	}
+
+	minMTU := MinTUNMTU
+	if ipv6 {
+		// Ядро снимает IPv6 адреса с интерфейса, если MTU меньше 1280
+		minMTU = MinTUNMTU6
+	}
+
+	// С FEC в пакете оставляется место под заголовки избыточных пакетов,
+	// иначе пакеты размером с MTU уходили бы фрагментами
+	maxMTU := internal.TUNMTU
+	if cfg.FEC.Enabled() {
+		maxMTU = max(min(maxMTU, transport.MaxPacketSize-cfg.FEC.Overhead()), minMTU)
+		if err := tun.SetMTU(maxMTU); err != nil {
+			// MTU чужого дескриптора может быть уже подобран тем, кто его открыл
+			if cfg.TUNFD == 0 && cfg.Device == nil {
+				tun.Close()
+				return nil, err
+			}
+			logTUN.Warn("Failed to set MTU of pre-opened TUN", logging.Err(err))
+		}
+	}
+
+	var socks5Server *socks5.Server
+	if stack != nil {
+		socks5Server = socks5.NewServer(socks5.ServerOptions{Dial: stack.DialContext})
+	}
+
+	var state *netstate.Store
+	if cfg.StateFile != "" {
+		if state, err = netstate.Open(cfg.StateFile); err != nil {
+			tun.Close()
+			return nil, err
+		}
+		if routeManager != nil {
+			routeManager.state = state
+		}
+		if killSwitch != nil {
+			killSwitch.state = state
+		}
+		if dnsGuard != nil {
+			dnsGuard.state = state
+		}
+	}
+
 	return &VPNClient{
-		serverAddr:   serverAddr,
+		serverAddr:   cfg.ServerAddr,
 		tun:          tun,
 		crypto:       crypto,
 		protocol:     protocol,
-		socks5Proxy:  socks5Proxy,
+		socks5Proxy:  cfg.Socks5Proxy,
+		socks5Listen: cfg.Socks5Listen,
+		netstack:     stack,
+		socks5Server: socks5Server,
 		routeManager: routeManager,
+		dnsManager:   dnsManager,
+		dns:          cfg.DNS,
+		killSwitch:   killSwitch,
+		dnsGuard:     dnsGuard,
+		state:        state,
+		reconnect:    make(chan struct{}, 1),
+		configReady:  make(chan struct{}),
+		autoIP:       autoIP,
+		ipv6:         ipv6,
+		totp:         cfg.TOTP,
+		username:     cfg.Username,
+		password:     cfg.Password,
+		capture:      cfg.Capture,
+		migrate:      make(chan struct{}, 1),
+		sessionID:    rand.Uint64(),
 		done:         make(chan struct{}),
-		verbose:      verbose,
+		ready:        make(chan struct{}),
+		closed:       make(chan struct{}),
+		started:      time.Now(),
 		autoRoutes:   autoRoutes,
+		pathMTU:      cfg.PathMTUDiscovery && cfg.Socks5Proxy == "",
+		minMTU:       minMTU,
+		mtu:          maxMTU,
+		maxMTU:       maxMTU,
+		fec:          cfg.FEC,
+		multipath:    cfg.Multipath,
+		bond:         bond,
+		compression:  cfg.Compression,
+		noCompress:   cfg.DisableCompression,
+		adaptive:     compress.NewAdaptive(),
+		obfuscation:  cfg.Obfuscation,
+		dscp:         cfg.DSCP,
+		fwmark:       cfg.FwMark,
+		protect:      cfg.Protect,
+		hop:          hop,
+		transports:   transports,
+		kindTimeout:  kindTimeout,
+		streamOpts:   streamOpts,
+		p2p:          cfg.P2P,
+		peers:        make(map[string]*p2pPeer),
+		peerSessions: make(map[uint64]*p2pPeer),
 	}, nil
 }
 
-// Connect подключается к VPN серверу и начинает обмен пакетами
+// Connect подключается к VPN серверу и начинает обмен пакетами.
+// При потере связи транспорт пересоздается с экспоненциальной задержкой,
+// TUN интерфейс и маршруты при этом остаются на месте
 func (c *VPNClient) Connect() error {
-	// Создаем UDP транспорт
+	var socksListener net.Listener
+	if c.socks5Listen != "" {
+		ln, err := net.Listen("tcp", c.socks5Listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", c.socks5Listen, err)
+		}
+		socksListener = ln
+	}
 	if c.socks5Proxy != "" {
-		log.Printf("Connecting to %s via SOCKS5 proxy at %s", c.serverAddr, c.socks5Proxy)
+		logTransport.Info("Connecting via SOCKS5 proxy", "server", c.serverAddr, "proxy", c.socks5Proxy)
+	}
+	udpTransport, err := c.dial()
+	for err != nil && c.nextTransport(false) {
+		logTransport.Warn("Transport failed, trying next", logging.Err(err), "next", c.ActiveTransport())
+		udpTransport, err = c.dial()
 	}
-	udpTransport, err := transport.NewUDPTransport(":0", c.serverAddr, 30*time.Second, c.crypto, c.socks5Proxy)
 	if err != nil {
+		if socksListener != nil {
+			socksListener.Close()
+		}
 		return fmt.Errorf("failed to create UDP transport: %w", err)
 	}
 
-	c.transport = udpTransport
-	log.Printf("Connected to VPN server at %s", c.serverAddr)
-	log.Printf("TUN interface: %s", c.tun.Name())
+	c.setTransport(udpTransport)
+	c.requestConfig(udpTransport)
+	logClient.Info("Connected to VPN server", "server", c.serverAddr, "transport", c.ActiveTransport())
+	if paths := udpTransport.Paths(); len(paths) > 0 {
+		mode := MultipathStandby
+		if c.bond {
+			mode = MultipathBond
+		}
+		logTransport.Info("Multipath enabled", "mode", mode, "paths", len(paths)+1)
+	}
+	if c.netstack == nil {
+		logTUN.Info("TUN interface created", "name", c.tun.Name(), "queues", c.tun.Queues())
+	}
+
+	// Запускаем по горутине чтения из TUN и отправки на сервер на каждую очередь,
+	// а при нескольких очередях - еще и горутины записи в TUN
+	for q := 0; q < c.tun.Queues(); q++ {
+		c.wg.Add(1)
+		go c.handleTunToServer(q)
+	}
+	if c.tun.Queues() > 1 {
+		c.tunWriters = make([]chan []byte, c.tun.Queues())
+		for q := range c.tunWriters {
+			c.tunWriters[q] = make(chan []byte, transport.BatchSize)
+			c.wg.Add(1)
+			go c.writeTunQueue(q, c.tunWriters[q])
+		}
+	}
+
+	// Запускаем горутину, которая читает от сервера и переподключается при потере связи
+	c.wg.Add(1)
+	go c.superviseConnection()
+
+	// Адреса, маршруты и MTU может прислать сервер, поэтому дальше ждем его ответ
+	c.waitConfig()
+
+	// Kill switch включаем до смены маршрутов, чтобы не было окна для утечек.
+	// Он остается включенным во время переподключений
+	if c.killSwitch != nil {
+		if err := c.killSwitch.Enable(); err != nil {
+			return fmt.Errorf("failed to enable kill switch: %w", err)
+		}
+		logNet.Info("Kill switch enabled: traffic outside VPN is blocked")
+	}
 
 	// Настраиваем маршрутизацию всего трафика через VPN
 	if c.autoRoutes && c.routeManager != nil {
+		if routes := c.pushedRoutes.Load(); routes != nil && !c.routeManager.SplitTunnel() {
+			if err := c.routeManager.SetSplitRoutes(*routes); err != nil {
+				logNet.Warn("Ignoring routes pushed by server", logging.Err(err))
+			}
+		}
 		if err := c.routeManager.SetupRoutes(); err != nil {
-			log.Printf("Warning: failed to setup routes: %v", err)
-			log.Println("You may need to configure routes manually")
+			logNet.Warn("Failed to setup routes, configure them manually", logging.Err(err))
+		} else if c.routeManager.SplitTunnel() {
+			logNet.Info("Routes configured: selected networks go through VPN")
 		} else {
-			log.Println("✓ Routes configured: all traffic now goes through VPN")
+			logNet.Info("Routes configured: all traffic goes through VPN")
 		}
 	}
+	c.enableDNSGuard()
 
-	// Запускаем горутину для чтения из TUN и отправки на сервер
-	c.wg.Add(1)
-	go c.handleTunToServer()
+	if socksListener != nil {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			if err := c.socks5Server.Serve(socksListener); err != nil && !errors.Is(err, net.ErrClosed) {
+				logClient.Error("SOCKS5 server stopped", logging.Err(err))
+			}
+		}()
+		logClient.Info("SOCKS5 server started: applications connect through it instead of TUN", "addr", socksListener.Addr())
+	}
 
-	// Запускаем горутину для чтения от сервера и записи в TUN
+	// Следим за сменой сети (Wi-Fi -> LTE), чтобы продолжить сессию с нового адреса
 	c.wg.Add(1)
-	go c.handleServerToTun()
+	go c.watchNetworkChanges()
+	close(c.ready)
 
 	// Ждем завершения
 	c.wg.Wait()
-	log.Println("Disconnected from VPN server")
+	logClient.Info("Disconnected from VPN server")
 
 	return nil
 }
 
-// handleTunToServer читает пакеты из TUN и отправляет на сервер
-func (c *VPNClient) handleTunToServer() {
+// dial создает новый UDP транспорт до сервера текущим видом транспорта.
+// Все транспорты клиента используют один session ID, поэтому сервер
+// продолжает ту же сессию после переподключения или смены адреса
+func (c *VPNClient) dial() (*transport.UDPTransport, error) {
+	addr := c.dialAddr()
+	var relay *transport.StreamRelay
+	if kind := c.ActiveTransport(); kind != transport.KindUDP {
+		// Датаграммы уходят на локальный сокет релея, а он передает их через TCP, WSS или KCP
+		var err error
+		if relay, err = transport.DialStream(kind, c.streamOpts); err != nil {
+			return nil, err
+		}
+		addr = relay.Addr().String()
+	}
+
+	multipath := len(c.multipath) > 0 && relay == nil
+	keepalive := KeepaliveInterval
+	if multipath {
+		keepalive = MultipathKeepaliveInterval
+	}
+	t, err := transport.NewUDPTransport(":0", addr, keepalive, c.crypto, c.socks5Proxy)
+	if err != nil {
+		if relay != nil {
+			relay.Close()
+		}
+		return nil, err
+	}
+	if relay != nil {
+		t.SetRelay(relay)
+	}
+	t.SetSessionID(c.sessionID)
+	if c.fwmark != 0 {
+		if err := t.SetMark(c.fwmark); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	if c.protect != nil {
+		if err := t.Protect(c.protect); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	if multipath {
+		if err := c.bindPaths(t, addr); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	// Через TCP, WSS и KCP потери восстанавливает сам поток, FEC нужен только UDP
+	if c.ActiveTransport() == transport.KindUDP {
+		if err := t.SetSessionFEC(c.sessionID, c.fec); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	t.SetControlHandler(c.handleControl)
+	t.SetDisconnectHandler(c.handleDisconnect)
+	t.SetObfuscation(c.obfuscation)
+	if c.dscp != 0 {
+		if err := t.SetDSCP(c.dscp); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	c.setCapture(t)
+	// Пока неизвестно, что сервер шифрует keepalive, принимаем и открытые ответы старых версий
+	t.SetLegacyKeepalive(c.serverCaps.Load()&internal.CapAuthKeepalive == 0)
+	return t, nil
+}
+
+// setCapture включает запись датаграмм транспорта, если она нужна
+func (c *VPNClient) setCapture(t *transport.UDPTransport) {
+	if c.capture != nil && c.capture.Outer() {
+		t.SetCapture(c.capture.Datagram)
+	}
+}
+
+// bindPaths привязывает транспорт к первому доступному интерфейсу multipath и добавляет
+// ему пути через остальные. Интерфейс, к которому не удалось привязаться (его нет),
+// пропускается: оставшиеся пути продолжают работать
+func (c *VPNClient) bindPaths(t *transport.UDPTransport, addr string) error {
+	bound := false
+	for _, iface := range c.multipath {
+		if !bound {
+			if err := t.BindToDevice(iface); err != nil {
+				logTransport.Warn("Multipath path skipped", "interface", iface, logging.Err(err))
+				continue
+			}
+			bound = true
+			continue
+		}
+		// Keepalive пути запускает AddPath, после того как путь получит общий счетчик
+		p, err := transport.NewUDPTransport(":0", addr, 0, c.crypto, "")
+		if err != nil {
+			logTransport.Warn("Multipath path skipped", "interface", iface, logging.Err(err))
+			continue
+		}
+		if err := p.BindToDevice(iface); err != nil {
+			logTransport.Warn("Multipath path skipped", "interface", iface, logging.Err(err))
+			p.Close()
+			continue
+		}
+		if c.fwmark != 0 {
+			if err := p.SetMark(c.fwmark); err != nil {
+				logTransport.Warn("Multipath path skipped", "interface", iface, logging.Err(err))
+				p.Close()
+				continue
+			}
+		}
+		if c.protect != nil {
+			if err := p.Protect(c.protect); err != nil {
+				logTransport.Warn("Multipath path skipped", "interface", iface, logging.Err(err))
+				p.Close()
+				continue
+			}
+		}
+		p.SetControlHandler(c.handleControl)
+		p.SetDisconnectHandler(c.handleDisconnect)
+		p.SetObfuscation(c.obfuscation)
+		if c.dscp != 0 {
+			if err := p.SetDSCP(c.dscp); err != nil {
+				logTransport.Warn("Multipath path skipped", "interface", iface, logging.Err(err))
+				p.Close()
+				continue
+			}
+		}
+		c.setCapture(p)
+		t.AddPath(p)
+	}
+	if !bound {
+		return errors.New("no multipath interface is available")
+	}
+	// Пакеты разных путей приходят вперемешку, окну нужен запас на обгон
+	if c.bond {
+		t.SetReplayWindow(c.sessionID, transport.MultipathWindowSize)
+	}
+	return nil
+}
+
+// ActiveTransport возвращает текущий вид транспорта (udp, tcp или wss)
+func (c *VPNClient) ActiveTransport() string {
+	return c.transports[c.kindIdx.Load()]
+}
+
+// Done возвращает канал, который закрывается, когда клиент остановлен: вызовом Close,
+// командой управления или из-за ошибки TUN
+func (c *VPNClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Ready возвращает канал, который закрывается, когда туннель настроен: сервер прислал
+// конфигурацию, маршруты и kill switch применены
+func (c *VPNClient) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Alive возвращает ошибку, если путь данных клиента сломан: клиент закрыт
+// или TUN интерфейс исчез или опущен. Потеря связи с сервером ошибкой не считается:
+// клиент переподключается сам
+func (c *VPNClient) Alive() error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("client is closed")
+	default:
+	}
+	iface, err := net.InterfaceByName(c.tun.Name())
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", c.tun.Name(), err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", c.tun.Name())
+	}
+	return nil
+}
+
+// RTT возвращает задержку и потери текущей сессии, измеренные по ответам на keepalive
+func (c *VPNClient) RTT() transport.RTTStats {
+	t := c.currentTransport()
+	if t == nil {
+		return transport.RTTStats{}
+	}
+	rtt, _ := t.RTT(c.sessionID)
+	return rtt
+}
+
+// nextTransport переходит к следующему виду транспорта. Если текущий последний,
+// при wrap возвращается к первому, иначе возвращает false
+func (c *VPNClient) nextTransport(wrap bool) bool {
+	next := int(c.kindIdx.Load()) + 1
+	if next == len(c.transports) {
+		if !wrap {
+			return false
+		}
+		next = 0
+	}
+	c.kindIdx.Store(int32(next))
+	return true
+}
+
+// establishTimer срабатывает, если сессия не установлена за kindTimeout
+// (nil, если переходить к другому виду транспорта некуда)
+func (c *VPNClient) establishTimer() <-chan time.Time {
+	if len(c.transports) < 2 {
+		return nil
+	}
+	return time.After(c.kindTimeout)
+}
+
+// dialAddr возвращает адрес сервера для нового транспорта: при port hopping
+// порт берется из расписания
+func (c *VPNClient) dialAddr() string {
+	if c.hop == nil {
+		return c.serverAddr
+	}
+	host, _, err := net.SplitHostPort(c.serverAddr)
+	if err != nil {
+		return c.serverAddr
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.hop.Port(time.Now())))
+}
+
+// hopTimer срабатывает в момент следующей смены порта (nil без port hopping
+// и для потоковых видов транспорта: порт меняется только у UDP)
+func (c *VPNClient) hopTimer() <-chan time.Time {
+	if c.hop == nil || c.ActiveTransport() != transport.KindUDP {
+		return nil
+	}
+	return time.After(time.Until(c.hop.Next(time.Now())))
+}
+
+// hopTransport создает транспорт на новый порт сервера взамен old без задержки
+// переподключения. Новый сокет нужен, чтобы conntrack на сервере завел новую запись
+// для REDIRECT. Возвращает nil, если создать транспорт не удалось
+func (c *VPNClient) hopTransport(old *transport.UDPTransport) *transport.UDPTransport {
+	t, err := c.dial()
+	if err != nil {
+		logTransport.Warn("Port hop failed", logging.Err(err))
+		return nil
+	}
+	t.SetSequence(old.Sequence())
+	if size := old.PathMTU(); size > 0 {
+		t.SetPathMTU(size)
+		for _, p := range t.Paths() {
+			p.SetPathMTU(size)
+		}
+	}
+	logTransport.Debug("Port hop", "remote", t.RemoteAddr())
+	return t
+}
+
+// requestConfig запрашивает у сервера конфигурацию (DNS и т.п.),
+// повторяя запрос, пока не придет ответ
+func (c *VPNClient) requestConfig(t *transport.UDPTransport) {
+	c.configured.Store(false)
+	// Пока сервер не сообщил свои кодеки, пакеты отправляются без сжатия
+	c.sendCodec.Store(uint32(compress.CodecNone))
+	req := internal.ConfigRequest{
+		Version:      internal.ProtocolVersion,
+		Capabilities: internal.Capabilities,
+	}
+	if !c.noCompress {
+		req.Codecs = compress.SupportedNames()
+	}
+	if c.fec.Enabled() && c.ActiveTransport() == transport.KindUDP {
+		req.FEC = c.fec.String()
+	}
+	req.AssignIP = c.autoIP
+	req.Bond = c.bond && len(t.Paths()) > 0
+	// Пробить NAT можно только с UDP сокета, который сам видит сервер
+	req.P2P = c.p2p && c.ActiveTransport() == transport.KindUDP && len(t.Paths()) == 0
+	if code := c.totpCode.Swap(nil); code != nil {
+		req.TOTP = *code
+	}
+	req.Username, req.Password = c.username, c.password
+	msg, err := internal.EncodeControl(internal.ControlConfigRequest, req)
+	if err != nil {
+		logClient.Error("Failed to encode config request", logging.Err(err))
+		return
+	}
+
+	c.handshakeAt.CompareAndSwap(0, time.Now().UnixNano())
+	gen := c.configGen.Add(1)
+	go func() {
+		for i := 0; i < ConfigRequestAttempts && !c.configured.Load() && c.configGen.Load() == gen; i++ {
+			if err := t.WriteControl(msg, t.RemoteAddr(), c.sessionID); err != nil {
+				return
+			}
+			select {
+			case <-c.done:
+				return
+			case <-time.After(ConfigRequestInterval):
+			}
+		}
+	}()
+}
+
+// handleControl обрабатывает управляющие сообщения от сервера
+func (c *VPNClient) handleControl(msg []byte, _ *net.UDPAddr, sessionID uint64) {
+	if sessionID != c.sessionID {
+		// Другие клиенты (P2P) управляющих сообщений не отправляют
+		return
+	}
+	msgType, body, err := internal.DecodeControl(msg)
+	if err != nil {
+		logClient.Warn("Invalid control message from server", logging.Err(err))
+		return
+	}
+
+	switch msgType {
+	case internal.ControlConfig:
+		var cfg internal.ClientConfig
+		if err := json.Unmarshal(body, &cfg); err != nil {
+			logClient.Warn("Invalid config from server", logging.Err(err))
+			return
+		}
+		// Кодек выбираем на каждый ответ: после переподключения сервер мог смениться
+		codec := compress.CodecNone
+		if !c.noCompress {
+			preferred := c.compression
+			if preferred == compress.CodecNone && c.ActiveTransport() == transport.KindDNS {
+				// Через DNS каждый байт дорог: zstd сжимает сильнее LZ4, а CPU хватает с запасом
+				preferred = compress.CodecZstd
+			}
+			codec = compress.Negotiate(preferred, cfg.Codecs)
+		}
+		if c.sendCodec.Swap(uint32(codec)) != uint32(codec) {
+			logClient.Debug("Compression codec for packets to server", "codec", codec.String())
+		}
+		c.serverCaps.Store(cfg.Capabilities)
+		if t := c.currentTransport(); t != nil {
+			t.SetLegacyKeepalive(cfg.Capabilities&internal.CapAuthKeepalive == 0)
+		}
+		if c.configured.Swap(true) {
+			return
+		}
+		c.traceHandshake(nil)
+		logClient.Debug("Server capabilities", "version", cfg.Version, "capabilities", internal.FormatCapabilities(cfg.Capabilities))
+		if idx := c.kindIdx.Load(); c.established.Swap(idx+1) != idx+1 {
+			logClient.Info("Session established", logging.Session(c.sessionID), "transport", c.transports[idx])
+		}
+		c.applyConfig(cfg)
+	case internal.ControlReject:
+		var reject internal.Reject
+		if err := json.Unmarshal(body, &reject); err != nil {
+			logClient.Warn("Invalid reject from server", logging.Err(err))
+			return
+		}
+		if reject.TOTP && c.totp != nil {
+			// Повторы запроса без кода не нужны. Отказы на уже отправленные повторы
+			// приходят, пока пользователь вводит код, и не вызывают второй запрос кода
+			c.configGen.Add(1)
+			if c.totpPending.CompareAndSwap(false, true) {
+				go c.enterTOTP(reject.Reason)
+			}
+			return
+		}
+		retryAfter := time.Duration(reject.RetryAfter) * time.Second
+		if retryAfter < ReconnectInitialDelay {
+			retryAfter = ReconnectInitialDelay
+		}
+		// Сервер присылает отказ на каждый пакет, реагируем только на первый
+		if c.retryAfter.Swap(int64(retryAfter)) == 0 {
+			c.traceHandshake(errors.New(reject.Reason))
+			logClient.Warn("Server rejected connection", "reason", reject.Reason, "retry_in", retryAfter)
+			if reject.TOTP {
+				logClient.Error("Server requires a TOTP code: run the client with -totp")
+			}
+			if reject.MinVersion > internal.ProtocolVersion {
+				logClient.Error("Server requires a newer protocol version: upgrade the client",
+					"min_version", reject.MinVersion, "version", internal.ProtocolVersion)
+			}
+			c.requestReconnect()
+		}
+	case internal.ControlPeer:
+		c.handlePeer(body)
+	case internal.ControlDisconnect:
+		var notice internal.Reject
+		if err := json.Unmarshal(body, &notice); err != nil {
+			logClient.Warn("Invalid disconnect from server", logging.Err(err))
+			return
+		}
+		retryAfter := max(time.Duration(notice.RetryAfter)*time.Second, ReconnectInitialDelay)
+		if c.retryAfter.Swap(int64(retryAfter)) == 0 {
+			logClient.Warn("Server closed the session", "reason", notice.Reason, "retry_in", retryAfter)
+			c.requestReconnect()
+		}
+	default:
+		logClient.Debug("Unknown control message from server", "type", msgType)
+	}
+}
+
+// traceHandshake пишет спан handshake от первого запроса конфигурации до ответа сервера
+func (c *VPNClient) traceHandshake(err error) {
+	start := c.handshakeAt.Swap(0)
+	if start == 0 {
+		return
+	}
+	tracing.Record("client.handshake", time.Unix(0, start), err,
+		attribute.String("session", fmt.Sprintf("%016x", c.sessionID)),
+		attribute.String("transport", c.ActiveTransport()))
+}
+
+// enterTOTP запрашивает у пользователя код TOTP и повторяет запрос конфигурации с ним
+func (c *VPNClient) enterTOTP(reason string) {
+	defer c.totpPending.Store(false)
+	logClient.Info("Server requires second factor", "reason", reason)
+	code, err := c.totp()
+	if err != nil {
+		logClient.Error("Failed to read TOTP code", logging.Err(err))
+		return
+	}
+	c.totpCode.Store(&code)
+	if t := c.currentTransport(); t != nil {
+		c.requestConfig(t)
+	}
+}
+
+// handleDisconnect переподключается, когда сервер закрыл сессию (например, остановлен):
+// ждать DeadPeerTimeout незачем
+func (c *VPNClient) handleDisconnect(_ *net.UDPAddr, sessionID uint64) {
+	if sessionID != c.sessionID {
+		return
+	}
+	logClient.Info("Server closed the session")
+	c.requestReconnect()
+}
+
+// waitConfig ждет первую конфигурацию сервера. Если сервер не ответил, клиент продолжает
+// с адресами по умолчанию, а конфигурация применится, когда придет
+func (c *VPNClient) waitConfig() {
+	select {
+	case <-c.configReady:
+		return
+	case <-c.done:
+		return
+	case <-time.After(ConfigRequestAttempts * ConfigRequestInterval):
+	}
+	logClient.Warn("No configuration from server yet, continuing without it")
+	if c.autoIP {
+		c.setAddress("", "")
+	}
+}
+
+// applyConfig применяет конфигурацию, полученную от сервера
+func (c *VPNClient) applyConfig(cfg internal.ClientConfig) {
+	defer c.readyOnce.Do(func() { close(c.configReady) })
+
+	if c.autoIP {
+		c.setAddress(cfg.IP, cfg.IP6)
+	}
+	if cfg.MTU > 0 {
+		c.limitMTU(cfg.MTU)
+	}
+	c.transportMTU()
+	if c.p2p && !cfg.P2P {
+		logClient.Warn("Server does not allow peer-to-peer, traffic to other clients goes via server")
+	}
+	if len(cfg.Routes) > 0 {
+		routes := cfg.Routes
+		c.pushedRoutes.Store(&routes)
+	}
+	dns := cfg.DNS
+	if len(c.dns) > 0 {
+		dns = c.dns
+	}
+	if c.dnsManager != nil && len(dns) > 0 {
+		if err := c.dnsManager.Apply(dns); err != nil {
+			logNet.Warn("Failed to apply DNS servers", logging.Err(err))
+		} else {
+			logNet.Info("DNS configured", "dns", dns)
+		}
+	}
+	if c.netstack != nil && len(dns) > 0 {
+		c.netstack.SetDNS(dns)
+	}
+	if c.dnsGuard != nil {
+		if err := c.dnsGuard.SetResolvers(dns); err != nil {
+			logNet.Warn("Failed to update DNS leak protection", logging.Err(err))
+		}
+	}
+}
+
+// enableDNSGuard включает защиту от утечек DNS и проверяет, что запросы к DNS серверам
+// уходят через TUN. Защита работает, только когда через VPN идет весь трафик: в split
+// режиме DNS сервер локальной сети или провайдера - обычный выбор пользователя
+func (c *VPNClient) enableDNSGuard() {
+	if c.dnsGuard == nil {
+		return
+	}
+	if c.routeManager == nil || c.routeManager.SplitTunnel() {
+		logNet.Warn("DNS leak protection requires all traffic to go through VPN, not enabled")
+		return
+	}
+	if err := c.dnsGuard.Enable(); err != nil {
+		logNet.Warn("Failed to enable DNS leak protection", logging.Err(err))
+		return
+	}
+	logNet.Info("DNS leak protection enabled: DNS queries outside VPN are blocked")
+
+	var servers []string
+	if c.dnsManager != nil {
+		servers = c.dnsManager.Servers()
+	}
+	leaks, err := dnsLeaks(c.tun.Name(), servers)
+	if err != nil {
+		logNet.Warn("Failed to verify DNS routing", logging.Err(err))
+	}
+	if len(leaks) > 0 {
+		logNet.Warn("DNS servers are reachable only outside VPN, their queries are blocked", "dns", leaks)
+	} else if err == nil {
+		logNet.Info("DNS queries go through VPN")
+	}
+}
+
+// setAddress назначает TUN адреса, выданные сервером. Сервер без пула адресов
+// их не присылает, тогда используются DefaultClientIP и DefaultClientIP6
+func (c *VPNClient) setAddress(ip, ip6 string) {
+	if ip == "" {
+		ip, ip6 = DefaultClientIP, DefaultClientIP6
+	}
+	if !c.ipv6 {
+		ip6 = ""
+	}
+
+	c.mtuMu.Lock()
+	defer c.mtuMu.Unlock()
+	address := strings.TrimSpace(ip + " " + ip6)
+	if address == c.address {
+		return
+	}
+	if err := c.tun.SetAddress(ip, ip6); err != nil {
+		logTUN.Warn("Failed to assign address", "address", address, logging.Err(err))
+		return
+	}
+	c.address = address
+	logTUN.Info("Tunnel address assigned", "address", address)
+}
+
+// limitMTU ограничивает MTU TUN значением, которое прислал сервер
+func (c *VPNClient) limitMTU(mtu int) {
+	c.mtuMu.Lock()
+	defer c.mtuMu.Unlock()
+	c.maxMTU = max(min(mtu, c.maxMTU), c.minMTU)
+	if c.mtu <= c.maxMTU {
+		return
+	}
+	if err := c.tun.SetMTU(c.maxMTU); err != nil {
+		logTUN.Warn("Failed to set MTU", logging.Err(err))
+		return
+	}
+	c.mtu = c.maxMTU
+	logTUN.Info("Tunnel MTU set by server", "mtu", c.mtu)
+}
+
+// transportMTU уменьшает MTU TUN до минимального, пока сессия идет через DNS туннель:
+// пакет уходит несколькими запросами, и потеря любого из них теряет весь пакет.
+// После перехода на другой транспорт MTU возвращается
+func (c *VPNClient) transportMTU() {
+	c.mtuMu.Lock()
+	defer c.mtuMu.Unlock()
+	dns := c.ActiveTransport() == transport.KindDNS
+	mtu := c.minMTU
+	switch {
+	case dns && c.mtu == c.minMTU:
+		return
+	case !dns && c.dnsSavedMTU == 0:
+		return
+	case !dns:
+		mtu = min(c.dnsSavedMTU, c.maxMTU)
+	}
+	if err := c.tun.SetMTU(mtu); err != nil {
+		logTUN.Warn("Failed to set MTU", logging.Err(err))
+		return
+	}
+	if dns {
+		c.dnsSavedMTU = c.mtu
+	} else {
+		c.dnsSavedMTU = 0
+	}
+	c.mtu = mtu
+	logTUN.Info("Tunnel MTU set for transport", "transport", c.ActiveTransport(), "mtu", mtu)
+}
+
+// watchNetworkChanges периодически сравнивает набор локальных адресов и при изменении
+// обновляет маршрут к серверу и переносит сессию на новый транспорт, чтобы пакеты ушли с нового адреса
+func (c *VPNClient) watchNetworkChanges() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(NetworkCheckInterval)
+	defer ticker.Stop()
+
+	last := c.localAddrsFingerprint()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		current := c.localAddrsFingerprint()
+		if current == last {
+			continue
+		}
+		last = current
+
+		logNet.Info("Local network addresses changed, migrating session")
+		if c.routeManager != nil {
+			c.routesMu.Lock()
+			err := c.routeManager.RefreshServerRoute()
+			lanErr := c.routeManager.RefreshLANRoutes()
+			excludeErr := c.routeManager.RefreshExcludeRoutes()
+			c.routesMu.Unlock()
+			if err != nil {
+				logNet.Warn("Failed to refresh server route", logging.Err(err))
+			}
+			if lanErr != nil {
+				logNet.Warn("Failed to refresh LAN routes", logging.Err(lanErr))
+			}
+			if excludeErr != nil {
+				logNet.Warn("Failed to refresh excluded routes", logging.Err(excludeErr))
+			}
+		}
+		select {
+		case c.migrate <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// localAddrsFingerprint возвращает отсортированный список адресов всех интерфейсов, кроме TUN
+func (c *VPNClient) localAddrsFingerprint() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+
+	var addrs []string
+	for _, iface := range ifaces {
+		if iface.Name == c.tun.Name() || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaceAddrs {
+			addrs = append(addrs, iface.Name+"="+addr.String())
+		}
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
+// currentTransport возвращает активный транспорт (nil во время переподключения)
+func (c *VPNClient) currentTransport() *transport.UDPTransport {
+	return c.transport.Load()
+}
+
+// setTransport заменяет активный транспорт и возвращает предыдущий
+func (c *VPNClient) setTransport(t *transport.UDPTransport) *transport.UDPTransport {
+	old := c.transport.Swap(t)
+	if old != t {
+		c.dropPeers()
+	}
+	return old
+}
+
+// requestReconnect просит superviseConnection пересоздать транспорт
+func (c *VPNClient) requestReconnect() {
+	select {
+	case c.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+// superviseConnection читает пакеты от сервера и пересоздает транспорт,
+// если чтение завершилось ошибкой или сервер перестал отвечать
+func (c *VPNClient) superviseConnection() {
+	defer c.wg.Done()
+
+	watchdog := time.NewTicker(KeepaliveInterval)
+	defer watchdog.Stop()
+
+	hopped := false
+	for {
+		t := c.currentTransport()
+		readDone := make(chan error, 1)
+		go func() {
+			readDone <- c.handleServerToTun(t)
+		}()
+		// Пути multipath читаются до закрытия транспорта, их ошибки не означают потерю сессии
+		for _, p := range t.Paths() {
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				c.handleServerToTun(p)
+			}()
+		}
+		// После смены порта поиск PMTU продолжает прежний цикл: путь до сервера тот же.
+		// Через TCP/WSS/KCP PMTU искать незачем: пакеты идут на локальный релей
+		if c.pathMTU && !hopped && c.ActiveTransport() == transport.KindUDP {
+			go c.pathMTULoop(c.connGen.Load())
+		}
+		hop := c.hopTimer()
+		var establish <-chan time.Time
+		if !c.configured.Load() {
+			establish = c.establishTimer()
+		}
+		hopped = false
+		fallback := false
+		migrated := false
+
+	wait:
+		for {
+			select {
+			case <-c.done:
+				<-readDone
+				return
+			case err := <-readDone:
+				logTransport.Warn("Failed to receive packet from server", logging.Err(err))
+				break wait
+			case <-c.reconnect:
+				break wait
+			case <-c.migrate:
+				migrated = true
+				break wait
+			case <-hop:
+				hopped = true
+				break wait
+			case <-t.RelayDone():
+				logTransport.Warn("Connection to server closed", "transport", c.ActiveTransport())
+				break wait
+			case <-establish:
+				if !c.configured.Load() {
+					fallback = true
+					break wait
+				}
+			case <-watchdog.C:
+				if time.Since(lastReceive(t)) > DeadPeerTimeout {
+					logTransport.Warn("No packets from server", "timeout", DeadPeerTimeout)
+					break wait
+				}
+			}
+		}
+
+		// Закрываем старый транспорт, это завершает handleServerToTun
+		c.setTransport(nil)
+		t.Close()
+		select {
+		case <-readDone:
+		default:
+		}
+
+		if hopped {
+			if next := c.hopTransport(t); next != nil {
+				c.setTransport(next)
+				c.requestConfig(next)
+				continue
+			}
+			hopped = false
+		}
+
+		if migrated {
+			// Сессия не потеряна: новый сокет продолжает ее с тем же session ID и счетчиком
+			// сразу, без задержки переподключения. Сервер узнает новый адрес по первому пакету
+			next, err := c.dial()
+			if err == nil {
+				next.SetSequence(t.Sequence())
+				c.connGen.Add(1)
+				c.setTransport(next)
+				c.requestConfig(next)
+				logClient.Info("Session migrated to the new network", logging.Session(c.sessionID))
+				continue
+			}
+			logClient.Warn("Session migration failed", logging.Err(err))
+		}
+
+		if fallback {
+			// Сессия не установилась: сразу пробуем следующий вид транспорта
+			failed := c.ActiveTransport()
+			c.nextTransport(true)
+			logTransport.Warn("No session within timeout, falling back", "transport", failed, "timeout", c.kindTimeout, "next", c.ActiveTransport())
+			next, err := c.dial()
+			if err == nil {
+				next.SetSequence(t.Sequence())
+				c.connGen.Add(1)
+				c.setTransport(next)
+				c.requestConfig(next)
+				continue
+			}
+			logTransport.Warn("Transport failed", "transport", c.ActiveTransport(), logging.Err(err))
+		} else {
+			// Сессия потеряна: начинаем снова с предпочтительного вида транспорта
+			c.kindIdx.Store(0)
+		}
+
+		next := c.reconnectWithBackoff(t.Sequence())
+		if next == nil {
+			return
+		}
+		c.connGen.Add(1)
+		c.setTransport(next)
+		c.requestConfig(next)
+		c.reconnects.Add(1)
+		logClient.Info("Reconnected to VPN server", "server", c.serverAddr, "transport", c.ActiveTransport())
+	}
+}
+
+// pathMTULoop ищет PMTU до сервера сразу после подключения и затем раз в PathMTUInterval,
+// пока не произошло переподключение (смена порта при port hopping им не считается).
+// Ответы на пробы читает handleServerToTun
+func (c *VPNClient) pathMTULoop(gen uint64) {
+	for {
+		if t := c.currentTransport(); t != nil {
+			c.discoverPathMTU(t)
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(PathMTUInterval):
+		}
+		if c.connGen.Load() != gen {
+			return
+		}
+	}
+}
+
+// discoverPathMTU ищет PMTU и подгоняет под него MTU TUN и размер пакетов транспорта.
+// MTU не поднимается выше internal.TUNMTU: под него рассчитаны буферы
+func (c *VPNClient) discoverPathMTU(t *transport.UDPTransport) {
+	size, err := t.DiscoverPathMTU()
+	if c.currentTransport() != t {
+		return // транспорт заменили, пока шел поиск
+	}
+
+	c.mtuMu.Lock()
+	defer c.mtuMu.Unlock()
+	if err != nil {
+		logTUN.Warn("Path MTU discovery failed, keeping MTU", "mtu", c.mtu, logging.Err(err))
+		return
+	}
+	t.SetPathMTU(size)
+	for _, p := range t.Paths() {
+		p.SetPathMTU(size)
+	}
+
+	mtu := size - transport.DatagramOverhead - c.fec.Overhead()
+	if mtu > c.maxMTU {
+		mtu = c.maxMTU
+	}
+	if mtu < c.minMTU {
+		mtu = c.minMTU
+	}
+	if mtu == c.mtu {
+		return
+	}
+	if err := c.tun.SetMTU(mtu); err != nil {
+		logTUN.Warn("Failed to set MTU", logging.Err(err))
+		return
+	}
+	c.mtu = mtu
+	logTUN.Info("Tunnel MTU set from path MTU", "path_mtu", size, "mtu", mtu)
+}
+
+// reconnectWithBackoff пытается создать новый транспорт, удваивая задержку между
+// попытками (с jitter) до ReconnectMaxDelay. Возвращает nil, если клиент закрывается
+func (c *VPNClient) reconnectWithBackoff(sequence uint64) *transport.UDPTransport {
+	delay := ReconnectInitialDelay
+	for {
+		// Jitter: случайная задержка в диапазоне [delay/2, delay)
+		wait := delay/2 + rand.N(delay/2)
+		if retryAfter := time.Duration(c.retryAfter.Swap(0)); retryAfter > wait {
+			wait = retryAfter
+		}
+		logClient.Info("Reconnecting", "server", c.serverAddr, "in", wait.Round(time.Millisecond))
+
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(wait):
+		}
+
+		t, err := c.dial()
+		if err == nil {
+			// Продолжаем нумерацию, иначе сервер отбросит пакеты как повторные
+			t.SetSequence(sequence)
+			return t
+		}
+		logClient.Warn("Reconnect failed", logging.Err(err))
+		// Следующая попытка идет через следующий вид транспорта
+		c.nextTransport(true)
+
+		delay *= 2
+		if delay > ReconnectMaxDelay {
+			delay = ReconnectMaxDelay
+		}
+	}
+}
+
+// handleTunToServer читает пакеты из очереди TUN и отправляет на сервер
+func (c *VPNClient) handleTunToServer(queue int) {
 	defer c.wg.Done()
 
 	packet := make([]byte, internal.TUNMTU)
@@ -116,25 +1533,21 @@ func (c *VPNClient) handleTunToServer() {
 	for {
 		select {
 		case <-c.done:
-			log.Println("handleTunToServer: done signal received")
+			logTUN.Debug("TUN reader stopped", "queue", queue)
 			return
 		default:
 		}
 
-		// Устанавливаем deadline для возможности прерывания
-		// Используем SetReadDeadline через файловый дескриптор TUN
-		// Для TUN интерфейса используем прямое чтение с проверкой done канала
-		// через неблокирующее чтение
-		n, err := c.tun.Read(packet)
+		n, err := c.tun.ReadQueue(queue, packet)
 		if err != nil {
 			select {
 			case <-c.done:
 				return
 			default:
 				if err != io.EOF {
-					log.Printf("Error reading from TUN: %v", err)
+					logTUN.Error("Failed to read from TUN", logging.Err(err))
 				} else {
-					log.Println("TUN interface closed (EOF)")
+					logTUN.Info("TUN interface closed")
 				}
 				c.Close()
 				return
@@ -142,111 +1555,284 @@ func (c *VPNClient) handleTunToServer() {
 		}
 
 		if n > 0 {
-			if c.verbose {
-				log.Printf("Read %d bytes from TUN, sending to server", n)
+			if c.capture != nil {
+				c.capture.Inner(packet[:n])
+			}
+			t := c.currentTransport()
+			if t == nil {
+				// Идет переподключение, пакет отбрасываем
+				continue
+			}
+			if logging.DebugEnabled() {
+				logTUN.Debug("Sending packet from TUN to server", "bytes", n)
 			}
 			// Отправляем пакет на сервер через UDP транспорт
-			if err := c.sendPacketUDP(packet[:n]); err != nil {
-				log.Printf("Error sending packet to server: %v", err)
-				c.Close()
-				return
+			if err := c.sendPacketUDP(t, packet[:n]); err != nil {
+				logTransport.Warn("Failed to send packet to server", logging.Err(err))
+				c.requestReconnect()
+				continue
 			}
+			c.txPackets.Add(1)
+			c.txBytes.Add(uint64(n))
 		}
 	}
 }
 
 // sendPacketUDP отправляет пакет через UDP транспорт
-func (c *VPNClient) sendPacketUDP(packet []byte) error {
-	// Сжимаем пакет (опционально)
-	compressed, isCompressed, err := compress.Compress(packet)
+func (c *VPNClient) sendPacketUDP(t *transport.UDPTransport, packet []byte) error {
+	// Сжимаем пакет, если сжатие этого соединения окупается. Write не сохраняет данные,
+	// поэтому буфер возвращается в пул сразу после отправки
+	buf := bufpool.GetDatagram()
+	defer bufpool.PutDatagram(buf)
+	span := tracing.PacketSpan("compress", len(packet))
+	compressed, codec, err := c.adaptive.Compress(buf[:0], compress.Codec(c.sendCodec.Load()), internal.ConnHash(packet), packet)
+	span.End()
 	if err != nil {
 		return fmt.Errorf("compression failed: %w", err)
 	}
 
-	// Отправляем через UDP транспорт, который сам зашифрует данные и добавит AAD заголовки
-	_, err = c.transport.Write(compressed, isCompressed)
+	// Отправляем через UDP транспорт, который сам зашифрует данные и добавит AAD заголовки.
+	// DSCP берется из исходного пакета: после сжатия его не прочитать
+	dscp := internal.PacketDSCP(packet)
+	if p := c.directPeer(t, packet); p != nil {
+		if err := p.send(compressed, codec); err == nil {
+			return nil
+		}
+		// Прямой путь не принял пакет, он уходит через сервер
+	}
+	paths := t.Paths()
+	if len(paths) == 0 {
+		_, err = t.WriteDSCP(compressed, codec, dscp)
+		return err
+	}
+
+	// При multipath пакет, который не ушел по выбранному пути (интерфейс пропал),
+	// отправляется по остальным. Ошибка возвращается, только если не ушел ни по одному
+	n := len(paths) + 1
+	first := c.firstPath(t, paths)
+	for i := 0; i < n; i++ {
+		if _, err = pathAt(t, paths, (first+i)%n).WriteDSCP(compressed, codec, dscp); err == nil {
+			return nil
+		}
+	}
 	return err
 }
 
-// handleServerToTun читает пакеты от сервера и записывает в TUN
-func (c *VPNClient) handleServerToTun() {
-	defer c.wg.Done()
+// firstPath возвращает номер пути (0 - сам транспорт, дальше t.Paths()), по которому
+// отправляется пакет. При bonding пути чередуются, в режиме standby берется первый
+// по порядку. Пути, по которым дольше PathDeadTimeout ничего не приходило, пропускаются
+func (c *VPNClient) firstPath(t *transport.UDPTransport, paths []*transport.UDPTransport) int {
+	n := len(paths) + 1
+	start := 0
+	if c.bond {
+		start = int(c.pathIdx.Add(1) % uint32(n))
+	}
+	for i := 0; i < n; i++ {
+		idx := (start + i) % n
+		if time.Since(pathAt(t, paths, idx).LastReceive()) < PathDeadTimeout {
+			return idx
+		}
+	}
+	return start
+}
+
+// pathAt возвращает путь с номером i: 0 - сам транспорт, дальше paths
+func pathAt(t *transport.UDPTransport, paths []*transport.UDPTransport, i int) *transport.UDPTransport {
+	if i == 0 {
+		return t
+	}
+	return paths[i-1]
+}
+
+// lastReceive возвращает время последнего пакета от сервера по любому из путей транспорта
+func lastReceive(t *transport.UDPTransport) time.Time {
+	last := t.LastReceive()
+	for _, p := range t.Paths() {
+		if recv := p.LastReceive(); recv.After(last) {
+			last = recv
+		}
+	}
+	return last
+}
 
+// handleServerToTun читает пакеты от сервера и записывает в TUN.
+// Возвращает ошибку чтения транспорта; nil означает закрытие клиента
+func (c *VPNClient) handleServerToTun(t *transport.UDPTransport) error {
 	// Буфер должен быть достаточного размера для данных после шифрования + флаг сжатия
-	// MaxPacketSize в транспорте = 1467 байт (это максимальный размер данных без UDP заголовка)
+	// MaxPacketSize в транспорте = 1454 байта (это максимальный размер данных без UDP заголовка)
 	buf := make([]byte, transport.MaxPacketSize)
+	// Буфер распакованных пакетов тоже переиспользуется
+	decompressed := make([]byte, 0, bufpool.DatagramSize)
 
 	for {
 		select {
 		case <-c.done:
-			log.Println("handleServerToTun: done signal received")
-			return
+			return nil
 		default:
-			// Читаем из UDP транспорта
-			n, isCompressed, _, err := c.transport.Read(buf)
-			if err != nil {
-				select {
-				case <-c.done:
-					return
-				default:
-					log.Printf("Error receiving packet from server: %v", err)
-					c.Close()
-					return
-				}
+		}
+
+		// Читаем из UDP транспорта
+		n, codec, addr, session, err := t.ReadSession(buf)
+		if err != nil {
+			select {
+			case <-c.done:
+				return nil
+			default:
+			}
+			// Ошибка сокета означает потерю транспорта, остальные (MAC, replay) касаются одного пакета
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				return err
+			}
+			if logging.DebugEnabled() {
+				logTransport.Debug("Dropped packet from server", logging.Err(err))
 			}
+			continue
+		}
 
-			if n > 0 {
-				packet := buf[:n]
+		if n > 0 {
+			packet := buf[:n]
 
-				// Распаковываем если нужно
-				if isCompressed {
-					packet, err = compress.Decompress(packet, true)
-					if err != nil {
-						log.Printf("Error decompressing packet: %v", err)
-						continue
-					}
+			// Распаковываем если нужно
+			if codec != compress.CodecNone {
+				span := tracing.PacketSpan("decompress", len(packet))
+				packet, err = compress.DecompressTo(decompressed[:0], packet, codec)
+				span.End()
+				if cap(packet) > cap(decompressed) {
+					decompressed = packet[:0]
+				}
+				if err != nil {
+					logTransport.Warn("Failed to decompress packet", logging.Err(err))
+					continue
 				}
+			}
 
-				if len(packet) > 0 {
-					if c.verbose {
-						log.Printf("Received %d bytes from server, writing to TUN", len(packet))
-					}
-					// Записываем пакет в TUN
-					if _, err := c.tun.Write(packet); err != nil {
-						log.Printf("Error writing packet to TUN: %v", err)
-						c.Close()
-						return
+			if len(packet) > 0 && session != c.sessionID && !c.acceptPeer(session, addr, packet) {
+				if logging.DebugEnabled() {
+					logTransport.Debug("Dropped packet from peer", "session", session)
+				}
+				continue
+			}
+			if len(packet) > 0 {
+				if logging.DebugEnabled() {
+					logTUN.Debug("Writing packet from server to TUN", "bytes", len(packet))
+				}
+				c.rxPackets.Add(1)
+				c.rxBytes.Add(uint64(len(packet)))
+				if c.capture != nil {
+					c.capture.Inner(packet)
+				}
+				if len(c.tunWriters) > 0 {
+					// Поток целиком попадает в одну очередь, буфер переиспользуется - копируем
+					// в буфер из пула, который writeTunQueue возвращает после записи
+					packets := c.tunWriters[internal.FlowHash(packet)%uint32(len(c.tunWriters))]
+					select {
+					case packets <- append(bufpool.GetDatagram()[:0], packet...):
+					case <-c.done:
+						return nil
 					}
+					continue
+				}
+				// Записываем пакет в TUN
+				span := tracing.PacketSpan("tun.write", len(packet))
+				_, err := c.tun.WriteQueue(0, packet)
+				span.End()
+				if err != nil {
+					logTUN.Error("Failed to write packet to TUN", logging.Err(err))
+					c.Close()
+					return nil
 				}
 			}
 		}
 	}
 }
 
+// writeTunQueue записывает в очередь TUN пакеты, которые раздает handleServerToTun
+func (c *VPNClient) writeTunQueue(queue int, packets <-chan []byte) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case packet := <-packets:
+			span := tracing.PacketSpan("tun.write", len(packet))
+			_, err := c.tun.WriteQueue(queue, packet)
+			span.End()
+			bufpool.PutDatagram(packet)
+			if err != nil {
+				logTUN.Error("Failed to write packet to TUN", logging.Err(err))
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
 // Close закрывает соединение и TUN интерфейс
 func (c *VPNClient) Close() error {
-	select {
-	case <-c.done:
-		// Уже закрыто
-		return nil
-	default:
+	first := false
+	c.closeOnce.Do(func() {
 		close(c.done)
+		first = true
+	})
+	if !first {
+		// Уже закрыто или закрывается: ждем, пока первый вызов восстановит маршруты и DNS
+		<-c.closed
+		return nil
 	}
+	defer close(c.closed)
 
 	var errs []error
 
+	// Сообщаем серверу об отключении, чтобы он сразу освободил сессию
+	if t := c.currentTransport(); t != nil && c.serverCaps.Load()&internal.CapDisconnect != 0 {
+		if err := t.WriteDisconnect(t.RemoteAddr(), c.sessionID); err != nil {
+			logTransport.Debug("Failed to send disconnect to server", logging.Err(err))
+		}
+	}
+
+	// Восстанавливаем DNS конфигурацию
+	if c.dnsManager != nil {
+		if err := c.dnsManager.Restore(); err != nil {
+			logNet.Warn("Failed to restore DNS", logging.Err(err))
+			errs = append(errs, fmt.Errorf("failed to restore DNS: %w", err))
+		}
+	}
+
 	// Восстанавливаем старые маршруты
 	if c.routeManager != nil {
-		if err := c.routeManager.RestoreRoutes(); err != nil {
-			log.Printf("Warning: failed to restore routes: %v", err)
+		c.routesMu.Lock()
+		err := c.routeManager.RestoreRoutes()
+		c.routesMu.Unlock()
+		if err != nil {
+			logNet.Warn("Failed to restore routes", logging.Err(err))
 			errs = append(errs, fmt.Errorf("failed to restore routes: %w", err))
 		} else {
-			log.Println("✓ Routes restored to original state")
+			logNet.Info("Routes restored to original state")
+		}
+	}
+
+	if c.killSwitch != nil {
+		if err := c.killSwitch.Disable(); err != nil {
+			logNet.Warn("Failed to disable kill switch", logging.Err(err))
+			errs = append(errs, err)
 		}
 	}
 
-	if c.transport != nil {
-		if err := c.transport.Close(); err != nil {
+	if c.dnsGuard != nil {
+		if err := c.dnsGuard.Disable(); err != nil {
+			logNet.Warn("Failed to disable DNS leak protection", logging.Err(err))
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.state.Close(); err != nil {
+		logNet.Warn("Network settings left in state file", logging.Err(err))
+	}
+
+	if t := c.setTransport(nil); t != nil {
+		if err := t.Close(); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -257,6 +1843,11 @@ func (c *VPNClient) Close() error {
 		}
 	}
 
+	// После стека: соединения, которые еще устанавливаются, он уже прервал
+	if c.socks5Server != nil {
+		c.socks5Server.Close()
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("errors closing client: %v", errs)
 	}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"time"
+
+	"myvpn/internal/compress"
+	"myvpn/internal/pcap"
+	"myvpn/internal/porthop"
+	"myvpn/internal/transport"
+)
+
+// Config параметры VPN клиента
+type Config struct {
+	// ServerAddr адрес VPN сервера (host:port)
+	ServerAddr string
+	// Key ключ шифрования (32 байта)
+	Key []byte
+	// ClientIP IPv4 адрес TUN интерфейса. AutoAddress - адрес назначает сервер
+	ClientIP string
+	// ClientIP6 IPv6 адрес TUN интерфейса, пустая строка отключает IPv6.
+	// AutoAddress (только вместе с ClientIP = AutoAddress) - адрес назначает сервер
+	ClientIP6 string
+	// AutoRoutes включает автоматическую настройку маршрутов
+	AutoRoutes bool
+	// Routes список CIDR для split tunneling. Если пуст, в VPN уходит весь трафик
+	Routes []string
+	// ExcludeLAN какие локальные сети идут мимо VPN, когда через него идет весь трафик:
+	// ExcludeLANOff, ExcludeLANSubnets или ExcludeLANAll (пустая строка - ExcludeLANSubnets)
+	ExcludeLAN string
+	// ExcludeRoutes сети (CIDR или адреса), которые всегда идут мимо VPN через шлюз системы,
+	// в т.ч. при туннелировании всего трафика. При kill switch они разрешены
+	ExcludeRoutes []string
+	// Socks5Proxy адрес SOCKS5 прокси (Xray) для UDP трафика, пустая строка - напрямую
+	Socks5Proxy string
+	// KillSwitch блокирует весь исходящий трафик мимо VPN
+	KillSwitch bool
+	// KillSwitchAllow дополнительные сети (CIDR или IP), разрешенные при включенном kill switch
+	KillSwitchAllow []string
+	// DNSLeakProtection защита от утечек DNS при туннелировании всего трафика: DNSProtectOff,
+	// DNSProtectTUN или DNSProtectStrict (пустая строка - DNSProtectOff)
+	DNSLeakProtection string
+	// AcceptDNS разрешает применять DNS серверы, присланные сервером
+	AcceptDNS bool
+	// DNS серверы для туннеля вместо присланных сервером. Пустой список - присланные сервером
+	DNS []string
+	// TUNQueues число очередей TUN (IFF_MULTI_QUEUE). 0 или 1 - одна очередь
+	TUNQueues int
+	// TUNOffload IFF_VNET_HDR с TSO и checksum offload на TUN (-tun-offload)
+	TUNOffload bool
+	// TUNFD дескриптор TUN, который открыл и настроил другой процесс (0 - открыть
+	// /dev/net/tun самому). С ним TUNQueues и TUNOffload не используются, а адреса
+	// и MTU интерфейса клиент меняет, только если у него есть CAP_NET_ADMIN (-tun-fd)
+	TUNFD int
+	// NetNS сетевое пространство имен для TUN: имя из `ip netns add` или путь к файлу
+	// пространства имен (/proc/<pid>/ns/net). Интерфейс, его адреса и маршрут по умолчанию
+	// живут в нем, а сокеты к серверу - в пространстве имен клиента. Пусто - TUN рядом
+	// с клиентом (-netns)
+	NetNS string
+	// Device устройство, через которое идут пакеты вместо TUN (например, PacketDevice
+	// приложения iOS). nil - TUN; с ним TUNFD, TUNQueues и TUNOffload не используются
+	Device Device
+	// Socks5Listen адрес локального SOCKS5 сервера (например, 127.0.0.1:1080). С ним клиент
+	// не создает TUN: соединения приложений превращает в пакеты туннеля userspace стек,
+	// поэтому права root не нужны. Пусто - TUN (-socks5-listen)
+	Socks5Listen string
+	// Protect вызывается с дескриптором каждого сокета к серверу, чтобы его пакеты
+	// не попали обратно в туннель без fwmark и маршрута к серверу (VpnService.protect
+	// на Android). nil - не вызывается
+	Protect func(fd int) error
+	// Compression предпочтительный кодек для сжатия пакетов к серверу. CodecNone - auto:
+	// первый кодек, который поддерживает сервер
+	Compression compress.Codec
+	// DisableCompression выключает сжатие в обе стороны: клиент не сжимает пакеты
+	// и не предлагает кодеки серверу
+	DisableCompression bool
+	// PathMTUDiscovery включает поиск PMTU пробами и подстройку MTU TUN интерфейса под путь до сервера
+	PathMTUDiscovery bool
+	// Obfuscation выравнивание размеров пакетов к серверу и пакеты-пустышки
+	Obfuscation transport.Obfuscation
+	// DSCP внешних датаграмм: 1-63 - фиксированное значение, transport.DSCPCopy - копия
+	// DSCP внутреннего пакета, 0 - не менять
+	DSCP int
+	// P2P обмениваться пакетами с другими клиентами напрямую, когда сервер их знакомит:
+	// NAT пробивается пакетами через сокет UDP транспорта (-p2p)
+	P2P bool
+	// FwMark метка fwmark сокетов к серверу (0 - выключено). С меткой трафик направляется
+	// в VPN правилами `ip rule` и отдельной таблицей маршрутов вместо маршрута к серверу
+	// через шлюз (-fwmark)
+	FwMark uint32
+	// PortHop диапазон портов сервера для port hopping (нулевой - выключено).
+	// Порт в ServerAddr при этом не используется
+	PortHop porthop.Range
+	// PortHopInterval период смены порта (0 - porthop.DefaultInterval)
+	PortHopInterval time.Duration
+	// FEC группы Рида-Соломона для UDP транспорта: к каждым FEC.Data пакетам добавляется
+	// FEC.Parity избыточных, в обе стороны (сервер включает FEC по запросу клиента). Нулевой - выключено
+	FEC transport.FEC
+	// Transports виды транспорта в порядке попыток (transport.KindUDP, KindTCP, KindWSS, KindKCP,
+	// KindGRPC, KindDNS). Пустой список - только UDP
+	Transports []string
+	// TransportTimeout время на установку сессии через один вид транспорта, после которого
+	// клиент переходит к следующему (0 - DefaultTransportTimeout)
+	TransportTimeout time.Duration
+	// TCPAddr адрес TCP порта сервера (host:port). Пустая строка - ServerAddr
+	TCPAddr string
+	// TCPTLS подключаться к TCP порту сервера через TLS (сервер с -tcp-tls)
+	TCPTLS bool
+	// WSSURL адрес WebSocket сервера (wss://host/path)
+	WSSURL string
+	// WSSInsecure отключает проверку TLS сертификата сервера для WSS, gRPC и TCP с TLS
+	WSSInsecure bool
+	// HTTPProxy HTTP прокси для транспортов TCP, WSS и gRPC (http://[user:pass@]host:port или
+	// https://...): соединение с сервером открывается методом CONNECT. Пусто - напрямую
+	HTTPProxy string
+	// TLSCert и TLSKey пути к сертификату и ключу клиента для TLS транспортов, если сервер
+	// проверяет сертификаты клиентов (пустые - без сертификата)
+	TLSCert string
+	TLSKey  string
+	// TLSServerName имя сервера (SNI) в ClientHello TLS транспортов вместо имени из адреса,
+	// например имя сайта, который не блокируется. Сертификат сервера проверяется по нему
+	TLSServerName string
+	// TLSFingerprint ClientHello какого браузера повторяют TLS транспорты: chrome, firefox,
+	// safari, edge, ios или go - без маскировки (пусто - transport.DefaultTLSFingerprint)
+	TLSFingerprint string
+	// KCPAddr UDP адрес KCP порта сервера (host:port)
+	KCPAddr string
+	// KCPFEC параметры FEC для KCP, должны совпадать с серверными
+	KCPFEC transport.FEC
+	// GRPCAddr адрес gRPC сервера (host:port) для транспорта grpc
+	GRPCAddr string
+	// GRPCService имя сервиса gRPC, должно совпадать с серверным (пустая строка -
+	// transport.DefaultGRPCService)
+	GRPCService string
+	// DNSTunnelZone зона сервера для транспорта dns, как у сервера (например, t.example.com)
+	DNSTunnelZone string
+	// DNSTunnelResolver адрес резолвера (host[:port]) для транспорта dns. Пустая строка -
+	// DNS сервер системы из /etc/resolv.conf
+	DNSTunnelResolver string
+	// Multipath сетевые интерфейсы (например, wlan0 и wwan0), через которые UDP транспорт
+	// одновременно держит пути до сервера. Пустой список - один путь по таблице маршрутизации
+	Multipath []string
+	// MultipathMode режим использования путей: MultipathBond или MultipathStandby
+	// (пустая строка - MultipathBond)
+	MultipathMode string
+	// TOTP запрашивает у пользователя одноразовый код, когда сервер требует второй фактор
+	// (nil - клиент кодов не вводит)
+	TOTP func() (string, error)
+	// Username и Password учетные данные для сервера с внешней проверкой (RADIUS, LDAP)
+	Username string
+	Password string
+	// Capture запись трафика туннеля в pcap: внутренние IP пакеты и, если она создана
+	// с outer, датаграммы UDP транспорта (nil - выключена)
+	Capture *pcap.Capture
+	// StateFile файл, в который записываются добавленные маршруты, правила и таблицы
+	// nftables: после аварийного завершения следующий запуск удаляет их (пусто - выключено)
+	StateFile string
+}
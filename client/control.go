@@ -0,0 +1,221 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"myvpn/internal"
+)
+
+// DefaultControlSocket путь управляющего Unix сокета клиента, к которому по умолчанию
+// подключаются команды управления
+const DefaultControlSocket = "/run/myvpn-client.sock"
+
+// DefaultStateFile файл состояния клиента с добавленными маршрутами и правилами
+const DefaultStateFile = "/run/myvpn-client.state"
+
+// Состояния подключения
+const (
+	// StateConnecting сервер еще не прислал конфигурацию
+	StateConnecting = "connecting"
+	// StateConnected туннель работает
+	StateConnected = "connected"
+	// StateReconnecting связь с сервером потеряна, идет переподключение
+	StateReconnecting = "reconnecting"
+	// StateClosed клиент остановлен
+	StateClosed = "closed"
+)
+
+// Status состояние клиента
+type Status struct {
+	State         string   `json:"state"`
+	Server        string   `json:"server"`
+	Transport     string   `json:"transport"` // текущий вид транспорта (udp, tcp, wss, kcp)
+	SessionID     string   `json:"session_id"`
+	Interface     string   `json:"interface"`
+	Address       string   `json:"address,omitempty"` // адреса TUN через пробел
+	MTU           int      `json:"mtu"`
+	Routes        []string `json:"routes,omitempty"` // сети split tunneling (пусто - весь трафик)
+	Capabilities  string   `json:"capabilities"`     // возможности, общие с сервером
+	UptimeSeconds float64  `json:"uptime_seconds"`
+	// Peers другие клиенты, с которыми сервер познакомил этот (-p2p)
+	Peers []PeerStatus `json:"peers,omitempty"`
+}
+
+// PeerStatus другой клиент и путь к нему
+type PeerStatus struct {
+	IPs      []string `json:"ips"`      // адреса клиента внутри VPN
+	Endpoint string   `json:"endpoint"` // внешний адрес клиента
+	Direct   bool     `json:"direct"`   // пакеты идут напрямую (иначе через сервер)
+}
+
+// Stats счетчики трафика и качество связи клиента
+type Stats struct {
+	RxPackets  uint64 `json:"rx_packets"` // пакеты от сервера, записанные в TUN
+	RxBytes    uint64 `json:"rx_bytes"`
+	TxPackets  uint64 `json:"tx_packets"` // пакеты из TUN, отправленные серверу
+	TxBytes    uint64 `json:"tx_bytes"`
+	Reconnects uint64 `json:"reconnects"`
+	// RTT, jitter и потери по ответам сервера на keepalive (нули, пока измерений нет)
+	RTTMs    float64 `json:"rtt_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	Loss     float64 `json:"loss"`
+}
+
+// SetRoutesRequest запрос на замену маршрутов: сети split tunneling
+// (пусто - весь трафик через VPN)
+type SetRoutesRequest struct {
+	Routes []string `json:"routes"`
+}
+
+// Status возвращает состояние клиента
+func (c *VPNClient) Status() Status {
+	st := Status{
+		State:         StateConnected,
+		Server:        c.serverAddr,
+		Transport:     c.ActiveTransport(),
+		SessionID:     fmt.Sprintf("%016x", c.sessionID),
+		Interface:     c.tun.Name(),
+		Capabilities:  internal.FormatCapabilities(c.serverCaps.Load()),
+		UptimeSeconds: time.Since(c.started).Seconds(),
+	}
+	select {
+	case <-c.done:
+		st.State = StateClosed
+	default:
+		if !c.configured.Load() {
+			st.State = StateConnecting
+		} else if c.currentTransport() == nil {
+			st.State = StateReconnecting
+		}
+	}
+	c.mtuMu.Lock()
+	st.Address, st.MTU = c.address, c.mtu
+	c.mtuMu.Unlock()
+	if c.routeManager != nil {
+		c.routesMu.Lock()
+		st.Routes = c.routeManager.Routes()
+		c.routesMu.Unlock()
+	}
+	st.Peers = c.peerStatus()
+	return st
+}
+
+// Stats возвращает счетчики трафика и качество связи
+func (c *VPNClient) Stats() Stats {
+	st := Stats{
+		RxPackets:  c.rxPackets.Load(),
+		RxBytes:    c.rxBytes.Load(),
+		TxPackets:  c.txPackets.Load(),
+		TxBytes:    c.txBytes.Load(),
+		Reconnects: c.reconnects.Load(),
+	}
+	if rtt := c.RTT(); rtt.Samples > 0 {
+		st.RTTMs = float64(rtt.RTT) / float64(time.Millisecond)
+		st.JitterMs = float64(rtt.Jitter) / float64(time.Millisecond)
+		st.Loss = rtt.Loss
+	}
+	return st
+}
+
+// Reconnect пересоздает транспорт до сервера, как при потере связи
+func (c *VPNClient) Reconnect() {
+	logClient.Info("Reconnect requested")
+	c.requestReconnect()
+}
+
+// SetRoutes заменяет маршруты работающего туннеля сетями split tunneling
+// (пусто - весь трафик через VPN). Маршруты, присланные сервером, при этом не используются
+func (c *VPNClient) SetRoutes(routes []string) error {
+	if !c.autoRoutes || c.routeManager == nil {
+		return errors.New("routes are not managed by the client (-auto-routes=false)")
+	}
+	select {
+	case <-c.ready:
+	default:
+		return errors.New("tunnel is not configured yet")
+	}
+	c.routesMu.Lock()
+	defer c.routesMu.Unlock()
+	if err := c.routeManager.ReplaceRoutes(routes); err != nil {
+		return err
+	}
+	if len(routes) == 0 {
+		logNet.Info("Routes replaced: all traffic goes through VPN")
+	} else {
+		logNet.Info("Routes replaced: selected networks go through VPN", "routes", routes)
+	}
+	return nil
+}
+
+// ServeControl принимает команды управления на Unix сокете path. Токен не нужен:
+// доступ ограничен правами на файл сокета (только владелец, обычно root).
+// Блокируется до ошибки приема соединений
+func (c *VPNClient) ServeControl(path string) error {
+	// Сокет, оставшийся после аварийного завершения, мешает bind
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	defer os.Remove(path)
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to restrict control socket permissions: %w", err)
+	}
+
+	logClient.Info("Control socket listening", "path", path)
+	return http.Serve(ln, c.controlMux())
+}
+
+func (c *VPNClient) controlMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Status())
+	})
+	mux.HandleFunc("GET /api/v1/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Stats())
+	})
+	mux.HandleFunc("POST /api/v1/reconnect", func(w http.ResponseWriter, r *http.Request) {
+		c.Reconnect()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/v1/down", func(w http.ResponseWriter, r *http.Request) {
+		logClient.Info("Shutdown requested via control socket")
+		w.WriteHeader(http.StatusNoContent)
+		// Закрываем после ответа: Close восстанавливает маршруты и DNS и может занять время
+		go c.Close()
+	})
+	mux.HandleFunc("PUT /api/v1/routes", func(w http.ResponseWriter, r *http.Request) {
+		var req SetRoutesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if err := c.SetRoutes(req.Routes); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Status())
+	})
+	return mux
+}
+
+// writeJSON отправляет ответ в формате JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError отправляет ошибку в формате {"error": "..."}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
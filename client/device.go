@@ -0,0 +1,105 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// packetDeviceQueue число пакетов от приложения, ожидающих отправки серверу
+const packetDeviceQueue = 256
+
+// Device через него клиент обменивается IP пакетами с системой: TUN интерфейс
+// или приложение, которое само читает и пишет пакеты (PacketDevice)
+type Device interface {
+	Name() string
+	// Queues число очередей: ReadQueue и WriteQueue вызываются с номерами от 0 до Queues()-1
+	Queues() int
+	ReadQueue(queue int, packet []byte) (int, error)
+	WriteQueue(queue int, packet []byte) (int, error)
+	// SetAddress и SetMTU меняют настройки интерфейса. Ошибка не останавливает клиент
+	SetAddress(ip, ip6 string) error
+	SetMTU(mtu int) error
+	Close() error
+}
+
+// PacketDevice Device без интерфейса в системе: пакеты передает и принимает приложение
+// (NEPacketTunnelFlow на iOS). Адреса и MTU задает оно же
+type PacketDevice struct {
+	name      string
+	write     func(packet []byte) error
+	in        chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPacketDevice создает PacketDevice с именем name для журнала и статуса. write получает
+// пакеты от сервера; пакет действителен только до возврата из write
+func NewPacketDevice(name string, write func(packet []byte) error) *PacketDevice {
+	return &PacketDevice{
+		name:  name,
+		write: write,
+		in:    make(chan []byte, packetDeviceQueue),
+		done:  make(chan struct{}),
+	}
+}
+
+// Inject передает клиенту пакет, который приложение прочитало из системы. Пакет копируется.
+// Если клиент не успевает отправлять пакеты, пакет отбрасывается, как при переполнении TUN
+func (d *PacketDevice) Inject(packet []byte) error {
+	select {
+	case <-d.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case d.in <- append([]byte(nil), packet...):
+	default:
+	}
+	return nil
+}
+
+func (d *PacketDevice) Name() string {
+	return d.name
+}
+
+func (d *PacketDevice) Queues() int {
+	return 1
+}
+
+// ReadQueue ждет пакет от приложения. После Close возвращает io.EOF
+func (d *PacketDevice) ReadQueue(queue int, packet []byte) (int, error) {
+	select {
+	case p := <-d.in:
+		return copy(packet, p), nil
+	case <-d.done:
+		return 0, io.EOF
+	}
+}
+
+func (d *PacketDevice) WriteQueue(queue int, packet []byte) (int, error) {
+	select {
+	case <-d.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	if err := d.write(packet); err != nil {
+		return 0, err
+	}
+	return len(packet), nil
+}
+
+// SetAddress адреса PacketDevice задает приложение
+func (d *PacketDevice) SetAddress(ip, ip6 string) error {
+	return errors.ErrUnsupported
+}
+
+// SetMTU MTU PacketDevice задает приложение
+func (d *PacketDevice) SetMTU(mtu int) error {
+	return errors.ErrUnsupported
+}
+
+func (d *PacketDevice) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return nil
+}
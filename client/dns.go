@@ -0,0 +1,149 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	// resolvConfPath путь к конфигурации системного резолвера
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// DNSManager применяет DNS серверы, полученные от VPN сервера, и восстанавливает
+// исходную конфигурацию при отключении. Использует systemd-resolved (resolvectl),
+// если он запущен, иначе переписывает /etc/resolv.conf
+type DNSManager struct {
+	mu           sync.Mutex
+	tunInterface string
+	applied      []string
+	useResolved  bool
+	backup       []byte
+	backupLink   string
+}
+
+// NewDNSManager создает новый менеджер DNS
+func NewDNSManager(tunInterface string) *DNSManager {
+	return &DNSManager{
+		tunInterface: tunInterface,
+	}
+}
+
+// Apply направляет DNS запросы на заданные серверы. Повторный вызов
+// с тем же списком ничего не делает
+func (dm *DNSManager) Apply(servers []string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if len(servers) == 0 || strings.Join(servers, ",") == strings.Join(dm.applied, ",") {
+		return nil
+	}
+
+	if dm.applied == nil {
+		dm.useResolved = systemdResolvedActive()
+	}
+
+	var err error
+	if dm.useResolved {
+		err = dm.applyResolved(servers)
+	} else {
+		err = dm.applyResolvConf(servers)
+	}
+	if err != nil {
+		return err
+	}
+
+	dm.applied = servers
+	return nil
+}
+
+// Servers возвращает примененные DNS серверы (пусто - DNS не менялся)
+func (dm *DNSManager) Servers() []string {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.applied
+}
+
+// Restore восстанавливает DNS конфигурацию, которая была до Apply
+func (dm *DNSManager) Restore() error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if dm.applied == nil {
+		return nil
+	}
+	dm.applied = nil
+
+	if dm.useResolved {
+		cmd := exec.Command("resolvectl", "revert", dm.tunInterface)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("resolvectl revert failed: %w (output: %s)", err, string(output))
+		}
+		return nil
+	}
+
+	// /etc/resolv.conf мог быть симлинком (resolvconf, NetworkManager)
+	os.Remove(resolvConfPath)
+	if dm.backupLink != "" {
+		return os.Symlink(dm.backupLink, resolvConfPath)
+	}
+	return os.WriteFile(resolvConfPath, dm.backup, 0644)
+}
+
+// applyResolved настраивает DNS для TUN интерфейса через systemd-resolved.
+// Домен "~." направляет в VPN все запросы, а не только для отдельных зон
+func (dm *DNSManager) applyResolved(servers []string) error {
+	commands := [][]string{
+		append([]string{"dns", dm.tunInterface}, servers...),
+		{"domain", dm.tunInterface, "~."},
+		{"default-route", dm.tunInterface, "true"},
+	}
+	for _, args := range commands {
+		cmd := exec.Command("resolvectl", args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("resolvectl %s failed: %w (output: %s)", args[0], err, string(output))
+		}
+	}
+	return nil
+}
+
+// applyResolvConf сохраняет текущий /etc/resolv.conf и записывает новый
+func (dm *DNSManager) applyResolvConf(servers []string) error {
+	if dm.applied == nil {
+		if target, err := os.Readlink(resolvConfPath); err == nil {
+			dm.backupLink = target
+		} else {
+			data, err := os.ReadFile(resolvConfPath)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to backup %s: %w", resolvConfPath, err)
+			}
+			dm.backup = data
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by myvpn-client, restored on disconnect\n")
+	for _, server := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+
+	os.Remove(resolvConfPath)
+	if err := os.WriteFile(resolvConfPath, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", resolvConfPath, err)
+	}
+	return nil
+}
+
+// systemdResolvedActive проверяет, управляет ли DNS systemd-resolved
+func systemdResolvedActive() bool {
+	if _, err := exec.LookPath("resolvectl"); err != nil {
+		return false
+	}
+	if _, err := os.Stat("/run/systemd/resolve"); err != nil {
+		return false
+	}
+	return exec.Command("resolvectl", "status").Run() == nil
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"myvpn/internal/netlink"
+	"myvpn/internal/netstate"
+	"myvpn/internal/nft"
+)
+
+// Режимы защиты от утечек DNS
+const (
+	// DNSProtectOff DNS запросы не ограничиваются
+	DNSProtectOff = "off"
+	// DNSProtectTUN DNS запросы (порты 53 и 853) уходят только через TUN
+	DNSProtectTUN = "tun"
+	// DNSProtectStrict DNS запросы уходят только через TUN и только к DNS серверам,
+	// присланным сервером или заданным в конфигурации клиента
+	DNSProtectStrict = "strict"
+)
+
+// DNSGuardTable имя таблицы nftables (семейство inet) с правилами защиты от утечек DNS
+const DNSGuardTable = "myvpn-dns"
+
+// dnsPorts протоколы и порты DNS: обычный DNS, DNS over TLS и DNS over QUIC
+var dnsPorts = []struct {
+	proto string
+	port  uint16
+}{{"udp", 53}, {"tcp", 53}, {"tcp", 853}, {"udp", 853}}
+
+// DNSGuard не пропускает DNS запросы мимо VPN: пока через VPN идет весь трафик, DNS
+// сервер локальной сети или провайдера не должен видеть, какие имена разрешает клиент.
+// Запросы к локальному резолверу (systemd-resolved, dnsmasq) разрешены: его собственные
+// запросы наружу проходят те же правила
+type DNSGuard struct {
+	mu           sync.Mutex
+	tunInterface string
+	strict       bool
+	resolvers    []string
+	enabled      bool // таблица DNSGuardTable установлена
+	state        *netstate.Store
+}
+
+// NewDNSGuard создает защиту от утечек DNS в режиме mode (DNSProtectTUN или DNSProtectStrict)
+func NewDNSGuard(tunInterface, mode string) (*DNSGuard, error) {
+	switch mode {
+	case DNSProtectTUN, DNSProtectStrict:
+	default:
+		return nil, fmt.Errorf("unknown DNS leak protection mode %q", mode)
+	}
+	return &DNSGuard{
+		tunInterface: tunInterface,
+		strict:       mode == DNSProtectStrict,
+	}, nil
+}
+
+// Enable устанавливает правила для IPv4 и IPv6
+func (g *DNSGuard) Enable() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// Таблица от предыдущего запуска заменяется той же транзакцией
+	if err := nft.ReplaceOutput(DNSGuardTable, g.rules()); err != nil {
+		return err
+	}
+	g.enabled = true
+	g.state.AddNftTable(DNSGuardTable)
+	return nil
+}
+
+// SetResolvers задает DNS серверы, к которым в режиме DNSProtectStrict разрешены запросы,
+// и перестраивает установленные правила
+func (g *DNSGuard) SetResolvers(servers []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if slices.Equal(servers, g.resolvers) {
+		return nil
+	}
+	g.resolvers = slices.Clone(servers)
+	if !g.strict || !g.enabled {
+		return nil
+	}
+	return nft.ReplaceOutput(DNSGuardTable, g.rules())
+}
+
+// Disable удаляет правила защиты
+func (g *DNSGuard) Disable() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.enabled {
+		return nil
+	}
+	err := nft.DeleteTable(DNSGuardTable)
+	g.enabled = false
+	g.state.RemoveNftTable(DNSGuardTable)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to disable DNS leak protection: %w", err)
+	}
+	return nil
+}
+
+// rules возвращает правила защиты: разрешенные запросы пропускаются (дальше их проверяет
+// kill switch в своей таблице), остальные запросы к портам DNS отклоняются
+func (g *DNSGuard) rules() []nft.Rule {
+	allowed := []nft.Rule{{OIf: g.tunInterface}}
+	if g.strict {
+		allowed = nil
+		for _, server := range g.resolvers {
+			if ip, err := netip.ParseAddr(server); err == nil {
+				allowed = append(allowed, nft.Rule{OIf: g.tunInterface, Dst: hostPrefix(ip)})
+			}
+		}
+	}
+
+	rules := []nft.Rule{{OIf: "lo", Verdict: nft.Accept}}
+	for _, port := range dnsPorts {
+		for _, rule := range allowed {
+			rule.Proto, rule.DstPort, rule.Verdict = port.proto, port.port, nft.Accept
+			rules = append(rules, rule)
+		}
+		rules = append(rules, nft.Rule{Proto: port.proto, DstPort: port.port, Verdict: nft.Reject})
+	}
+	return rules
+}
+
+// dnsLeaks проверяет, что запросы к DNS серверам servers уходят через TUN, и возвращает
+// серверы, маршрут к которым идет мимо него. Без servers проверяются серверы
+// из /etc/resolv.conf (кроме локального резолвера)
+func dnsLeaks(tunInterface string, servers []string) ([]string, error) {
+	if len(servers) == 0 {
+		servers = resolvConfServers()
+	}
+	var leaks []string
+	for _, server := range servers {
+		ip, err := netip.ParseAddr(server)
+		if err != nil || ip.IsLoopback() {
+			continue
+		}
+		r, err := netlink.RouteGet(ip)
+		if err != nil {
+			return leaks, fmt.Errorf("failed to get route to %s: %w", server, err)
+		}
+		if r.Dev != tunInterface {
+			leaks = append(leaks, server)
+		}
+	}
+	return leaks, nil
+}
+
+// resolvConfServers возвращает серверы из строк nameserver в /etc/resolv.conf
+func resolvConfServers() []string {
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return nil
+	}
+	var servers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
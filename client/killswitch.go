@@ -0,0 +1,129 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"myvpn/internal/netstate"
+	"myvpn/internal/nft"
+	"myvpn/internal/porthop"
+)
+
+const (
+	// KillSwitchTable имя таблицы nftables (семейство inet), в которой живут правила kill switch
+	KillSwitchTable = "myvpn-killswitch"
+)
+
+// KillSwitch блокирует весь исходящий трафик мимо VPN: разрешены только
+// loopback, TUN интерфейс, зашифрованный UDP поток к серверу и явно указанные сети.
+// Если туннель упадет, трафик не уйдет через физический интерфейс
+type KillSwitch struct {
+	tunInterface string
+	server       *net.UDPAddr
+	serverPorts  porthop.Range    // диапазон портов сервера при port hopping, иначе порт server
+	streams      []streamEndpoint // адреса сервера для запасных видов транспорта (TCP, WSS, KCP)
+	allow        []netip.Prefix
+	enabled      bool // таблица KillSwitchTable установлена
+	state        *netstate.Store
+}
+
+// streamEndpoint адрес сервера запасного вида транспорта
+type streamEndpoint struct {
+	network string // tcp или udp
+	addr    *net.TCPAddr
+}
+
+// NewKillSwitch создает kill switch для сервера serverAddr.
+// allow - дополнительные разрешенные сети (например, адрес Xray сервера в режиме SOCKS5)
+func NewKillSwitch(tunInterface, serverAddr string, allow []string) (*KillSwitch, error) {
+	server, err := net.ResolveUDPAddr("udp", serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve server address: %w", err)
+	}
+
+	var networks []netip.Prefix
+	for _, item := range allow {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() == nil {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+		network, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kill switch allow entry %q: %w", item, err)
+		}
+		networks = append(networks, network.Masked())
+	}
+
+	return &KillSwitch{
+		tunInterface: tunInterface,
+		server:       server,
+		allow:        networks,
+	}, nil
+}
+
+// Enable устанавливает правила для IPv4 и IPv6
+func (ks *KillSwitch) Enable() error {
+	// Таблица от предыдущего запуска (например, после kill -9) заменяется той же транзакцией
+	if err := nft.ReplaceOutput(KillSwitchTable, ks.rules()); err != nil {
+		return err
+	}
+	ks.enabled = true
+	ks.state.AddNftTable(KillSwitchTable)
+	return nil
+}
+
+// Disable удаляет правила kill switch
+func (ks *KillSwitch) Disable() error {
+	if !ks.enabled {
+		return nil
+	}
+	err := nft.DeleteTable(KillSwitchTable)
+	ks.enabled = false
+	ks.state.RemoveNftTable(KillSwitchTable)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to disable kill switch: %w", err)
+	}
+	return nil
+}
+
+// rules возвращает правила kill switch для обоих семейств адресов
+func (ks *KillSwitch) rules() []nft.Rule {
+	rules := []nft.Rule{
+		{OIf: "lo", Verdict: nft.Accept},
+		{OIf: ks.tunInterface, Verdict: nft.Accept},
+		// DHCP и NDP нужны, чтобы физический интерфейс не потерял адрес
+		{Family: nft.FamilyIPv4, Proto: "udp", SrcPort: 68, DstPort: 67, Verdict: nft.Accept},
+		{Family: nft.FamilyIPv6, Proto: "icmpv6", Verdict: nft.Accept},
+	}
+
+	server := ks.server.AddrPort()
+	rule := nft.Rule{Dst: hostPrefix(server.Addr()), Proto: "udp", DstPort: server.Port(), Verdict: nft.Accept}
+	if ks.serverPorts.Enabled() {
+		rule.DstPort, rule.DstPortLast = uint16(ks.serverPorts.First), uint16(ks.serverPorts.Last)
+	}
+	rules = append(rules, rule)
+
+	for _, endpoint := range ks.streams {
+		addr := endpoint.addr.AddrPort()
+		rules = append(rules, nft.Rule{Dst: hostPrefix(addr.Addr()), Proto: endpoint.network, DstPort: addr.Port(), Verdict: nft.Accept})
+	}
+
+	for _, network := range ks.allow {
+		rules = append(rules, nft.Rule{Dst: network, Verdict: nft.Accept})
+	}
+
+	return append(rules, nft.Rule{Verdict: nft.Reject})
+}
+
+// hostPrefix возвращает префикс одного адреса (IPv4 в IPv6 приводится к IPv4)
+func hostPrefix(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen())
+}
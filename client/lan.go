@@ -0,0 +1,132 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"myvpn/internal/netlink"
+)
+
+// Режимы исключения локальной сети из VPN при перехвате default route
+const (
+	// ExcludeLANOff локальные сети не исключаются отдельными маршрутами
+	ExcludeLANOff = "off"
+	// ExcludeLANSubnets подсети адресов физических интерфейсов идут мимо VPN
+	ExcludeLANSubnets = "subnets"
+	// ExcludeLANAll как ExcludeLANSubnets, и еще IPv4 link-local, multicast и broadcast
+	ExcludeLANAll = "all"
+)
+
+// LANRouteMetric метрика маршрутов мимо VPN к локальным сетям. Отличается от метрики
+// маршрутов, которые система добавляет для адресов интерфейсов, поэтому маршруты
+// не совпадают и при удалении не затрагивают системные
+const LANRouteMetric = 50
+
+// lanExtraRoutes сети, которые в режиме ExcludeLANAll направляются в интерфейс
+// default route: mDNS, SSDP и другие multicast протоколы, link-local адреса без DHCP
+var lanExtraRoutes = []netip.Prefix{
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("255.255.255.255/32"),
+}
+
+// SetExcludeLAN задает режим исключения локальной сети (ExcludeLANOff, ExcludeLANSubnets
+// или ExcludeLANAll, пустая строка - ExcludeLANSubnets). Вызывается до SetupRoutes
+func (rm *RouteManager) SetExcludeLAN(mode string) error {
+	switch mode {
+	case "":
+		mode = ExcludeLANSubnets
+	case ExcludeLANOff, ExcludeLANSubnets, ExcludeLANAll:
+	default:
+		return fmt.Errorf("unknown LAN exclusion mode %q", mode)
+	}
+	rm.excludeLAN = mode
+	return nil
+}
+
+// addLANRoutes добавляет маршруты мимо VPN к локальным сетям: принтеры, NAS и SSH
+// в локальной сети остаются доступны, пока весь трафик идет через VPN
+func (rm *RouteManager) addLANRoutes() error {
+	if rm.excludeLAN == ExcludeLANOff || rm.SplitTunnel() {
+		return nil
+	}
+	routes, err := rm.localRoutes()
+	for _, r := range routes {
+		if err := rm.addRoute(r); err != nil {
+			return err
+		}
+		rm.lanRoutes = append(rm.lanRoutes, r)
+	}
+	return err
+}
+
+// deleteLANRoutes снимает маршруты, добавленные addLANRoutes
+func (rm *RouteManager) deleteLANRoutes() {
+	for _, r := range rm.lanRoutes {
+		rm.deleteRoute(r)
+	}
+	rm.lanRoutes = rm.lanRoutes[:0]
+}
+
+// RefreshLANRoutes перестраивает маршруты к локальным сетям после смены сети
+func (rm *RouteManager) RefreshLANRoutes() error {
+	if len(rm.lanRoutes) == 0 && (rm.excludeLAN == ExcludeLANOff || rm.SplitTunnel()) {
+		return nil
+	}
+	rm.deleteLANRoutes()
+	return rm.addLANRoutes()
+}
+
+// localRoutes возвращает маршруты к подсетям адресов всех поднятых интерфейсов, кроме
+// loopback и TUN. IPv6 подсети исключаются, только если IPv6 трафик идет через VPN
+func (rm *RouteManager) localRoutes() ([]netlink.Route, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	var routes []netlink.Route
+	seen := make(map[netip.Prefix]bool)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Name == rm.tunInterface {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ipv6 := ipNet.IP.To4() == nil
+			ones, bits := ipNet.Mask.Size()
+			if (ipv6 && !rm.ipv6) || ones == bits {
+				// Адрес без подсети (PPP, облачные /32): соседей нет
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(ipNet.IP)
+			network := netip.PrefixFrom(ip.Unmap(), ones).Masked()
+			if seen[network] {
+				continue
+			}
+			seen[network] = true
+			routes = append(routes, netlink.Route{Dst: network, Dev: iface.Name, Metric: LANRouteMetric})
+		}
+	}
+
+	if rm.excludeLAN == ExcludeLANAll {
+		iface := rm.oldInterface
+		if iface == "" {
+			var err error
+			if _, iface, err = parseDefaultRoute(false, rm.tunInterface); err != nil {
+				return routes, fmt.Errorf("failed to get interface for link-local and multicast routes: %w", err)
+			}
+		}
+		for _, network := range lanExtraRoutes {
+			routes = append(routes, netlink.Route{Dst: network, Dev: iface, Metric: LANRouteMetric})
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,11 @@
+package client
+
+import "myvpn/internal/logging"
+
+// Логгеры подсистем клиента
+var (
+	logClient    = logging.For("client")
+	logTransport = logging.For(logging.Transport)
+	logTUN       = logging.For(logging.TUN)
+	logNet       = logging.For(logging.NetMgr)
+)
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"myvpn/internal"
+	"myvpn/internal/netstack"
+)
+
+// NetstackDevice Device без интерфейса в системе: пакеты туннеля принимает и отправляет
+// userspace TCP/IP стек, а приложения подключаются к нему через DialContext (SOCKS5 сервер
+// клиента). TUN и права root не нужны
+type NetstackDevice struct {
+	*PacketDevice
+	stack *netstack.Stack
+	dns   atomic.Pointer[[]string] // DNS серверы туннеля для имен в DialContext
+}
+
+// NewNetstackDevice создает устройство с адресами ip и ip6 (CIDR, пустые - нет адреса)
+func NewNetstackDevice(ip, ip6 string) (*NetstackDevice, error) {
+	d := &NetstackDevice{}
+	d.PacketDevice = NewPacketDevice("netstack", func(packet []byte) error {
+		d.stack.Deliver(packet)
+		return nil
+	})
+	d.stack = netstack.New(netstack.Options{
+		Write: func(packet []byte) { d.Inject(packet) },
+		MTU:   internal.TUNMTU,
+	})
+	if err := d.SetAddress(ip, ip6); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SetAddress меняет адреса стека: соединения со старых адресов разрываются
+func (d *NetstackDevice) SetAddress(ip, ip6 string) error {
+	var addrs [2]netip.Addr
+	for i, s := range []string{ip, ip6} {
+		if s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		addrs[i] = prefix.Addr()
+	}
+	d.stack.SetAddresses(addrs[0], addrs[1])
+	return nil
+}
+
+// SetMTU меняет MTU стека: по нему выбирается MSS новых TCP соединений
+func (d *NetstackDevice) SetMTU(mtu int) error {
+	d.stack.SetMTU(mtu)
+	return nil
+}
+
+// SetDNS задает DNS серверы туннеля. Пока их нет, имена разрешает резолвер системы
+func (d *NetstackDevice) SetDNS(servers []string) {
+	d.dns.Store(&servers)
+}
+
+func (d *NetstackDevice) Close() error {
+	d.stack.Close()
+	return d.PacketDevice.Close()
+}
+
+// DialContext открывает TCP или UDP соединение через туннель. Имя в address разрешается
+// через DNS серверы туннеля
+func (d *NetstackDevice) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", address)
+	}
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	for _, addr := range addrs {
+		dst := netip.AddrPortFrom(addr, uint16(port))
+		var conn net.Conn
+		switch {
+		case strings.HasPrefix(network, "tcp"):
+			conn, err = d.stack.DialTCP(ctx, dst)
+		case strings.HasPrefix(network, "udp"):
+			conn, err = d.stack.DialUDP(ctx, dst)
+		default:
+			return nil, fmt.Errorf("unsupported network %q", network)
+		}
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("dial %s: %w", address, firstErr)
+}
+
+// lookup разрешает имя host через DNS туннеля, а без него - резолвером системы
+func (d *NetstackDevice) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	resolver := net.DefaultResolver
+	if servers := d.dns.Load(); servers != nil && len(*servers) > 0 {
+		resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.dialDNS(ctx, network, *servers)
+		}}
+	}
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, nil
+}
+
+// dialDNS соединяется с первым доступным DNS сервером туннеля
+func (d *NetstackDevice) dialDNS(ctx context.Context, network string, servers []string) (net.Conn, error) {
+	err := errors.New("no DNS servers")
+	for _, server := range servers {
+		addr, perr := netip.ParseAddr(server)
+		if perr != nil {
+			err = perr
+			continue
+		}
+		var conn net.Conn
+		if strings.HasPrefix(network, "tcp") {
+			conn, err = d.stack.DialTCP(ctx, netip.AddrPortFrom(addr, 53))
+		} else {
+			conn, err = d.stack.DialUDP(ctx, netip.AddrPortFrom(addr, 53))
+		}
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
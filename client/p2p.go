@@ -0,0 +1,270 @@
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"myvpn/internal"
+	"myvpn/internal/compress"
+	"myvpn/internal/logging"
+	"myvpn/internal/transport"
+)
+
+const (
+	// PunchInterval интервал keepalive другому клиенту, пока NAT не пробит
+	PunchInterval = 500 * time.Millisecond
+	// PunchTimeout сколько клиент пробивает NAT до другого клиента, прежде чем оставить
+	// его пакеты серверу. Сервер познакомит клиентов снова (server.PeerIntroduceInterval)
+	PunchTimeout = 10 * time.Second
+	// PeerKeepaliveInterval интервал keepalive по прямому пути: поддерживает запись в NAT
+	PeerKeepaliveInterval = 15 * time.Second
+	// PeerTimeout через сколько без пакетов от другого клиента прямой путь считается пропавшим
+	PeerTimeout = 3 * PeerKeepaliveInterval
+)
+
+// p2pPeer другой клиент, с которым пакеты идут напрямую, минуя сервер
+type p2pPeer struct {
+	session  uint64
+	ips      []string                    // адреса клиента внутри VPN
+	endpoint atomic.Pointer[net.UDPAddr] // внешний адрес: сначала от сервера, потом с пакетов клиента
+	host     net.IP                      // адрес, к которому добавлен маршрут мимо VPN
+	t        *transport.UDPTransport
+	done     chan struct{} // закрывается, когда пир удален
+}
+
+// handlePeer принимает от сервера внешний адрес другого клиента и ключ пары и начинает
+// пробивать NAT. Вызывается из чтения транспорта, поэтому только запускает горутину
+func (c *VPNClient) handlePeer(body []byte) {
+	var msg internal.PeerEndpoint
+	if err := json.Unmarshal(body, &msg); err != nil {
+		logClient.Warn("Invalid peer endpoint from server", logging.Err(err))
+		return
+	}
+	if msg.Gone {
+		c.peerGone(msg.Session)
+		return
+	}
+	t := c.currentTransport()
+	if !c.p2p || t == nil || len(msg.IPs) == 0 || msg.Session == c.sessionID {
+		return
+	}
+	endpoint, err := net.ResolveUDPAddr("udp", msg.Endpoint)
+	if err != nil {
+		logClient.Warn("Invalid peer endpoint from server", "endpoint", msg.Endpoint, logging.Err(err))
+		return
+	}
+	crypto, err := internal.NewCrypto(msg.Key)
+	if err != nil {
+		logClient.Warn("Invalid peer key from server", logging.Err(err))
+		return
+	}
+
+	p := &p2pPeer{
+		session: msg.Session,
+		ips:     msg.IPs,
+		host:    endpoint.IP,
+		t:       t,
+		done:    make(chan struct{}),
+	}
+	p.endpoint.Store(endpoint)
+	t.AddPeer(p.session, crypto, msg.Initiator)
+
+	// Сервер знакомит пару заново, пока ее пакеты идут через него: прежняя сессия
+	// с этим клиентом заменяется новой
+	c.peersMu.Lock()
+	var replaced []*p2pPeer
+	for _, ip := range p.ips {
+		if old := c.peers[ip]; old != nil && !slices.Contains(replaced, old) {
+			replaced = append(replaced, old)
+		}
+	}
+	for _, old := range replaced {
+		c.unlinkPeerLocked(old)
+	}
+	for _, ip := range p.ips {
+		c.peers[ip] = p
+	}
+	c.peerSessions[p.session] = p
+	c.peersMu.Unlock()
+	for _, old := range replaced {
+		close(old.done)
+	}
+
+	logClient.Debug("Punching NAT to peer", "peer", p.ips, "endpoint", endpoint)
+	c.wg.Add(1)
+	go c.punch(p)
+}
+
+// punch пробивает NAT до другого клиента и поддерживает прямой путь, пока по нему
+// приходят пакеты. Когда путь не установился или пропал, пакеты снова идут через сервер
+func (c *VPNClient) punch(p *p2pPeer) {
+	defer c.wg.Done()
+	defer c.forgetPeer(p)
+
+	if c.routeManager != nil {
+		c.routesMu.Lock()
+		err := c.routeManager.AddHostRoute(p.host)
+		c.routesMu.Unlock()
+		if err != nil {
+			logNet.Warn("Failed to add route to peer", "peer", p.host, logging.Err(err))
+		} else {
+			defer func() {
+				c.routesMu.Lock()
+				c.routeManager.DeleteHostRoute(p.host)
+				c.routesMu.Unlock()
+			}()
+		}
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(PunchInterval)
+	defer ticker.Stop()
+	direct := false
+	for {
+		if err := p.t.WriteKeepalive(p.endpoint.Load(), p.session); err != nil && logging.DebugEnabled() {
+			logTransport.Debug("Failed to send keepalive to peer", "peer", p.ips, logging.Err(err))
+		}
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		case <-c.done:
+			return
+		}
+
+		last := p.t.PeerLastReceive(p.session)
+		switch {
+		case last.IsZero():
+			if time.Since(started) >= PunchTimeout {
+				logClient.Debug("No direct path to peer, traffic stays via server", "peer", p.ips)
+				return
+			}
+		case time.Since(last) >= PeerTimeout:
+			logClient.Info("Direct path to peer lost, traffic goes via server", "peer", p.ips)
+			return
+		case !direct:
+			direct = true
+			ticker.Reset(PeerKeepaliveInterval)
+			logClient.Info("Direct path to peer established", "peer", p.ips, "endpoint", p.endpoint.Load())
+		}
+	}
+}
+
+// forgetPeer удаляет пира из таблиц и его сессию из транспорта
+func (c *VPNClient) forgetPeer(p *p2pPeer) {
+	c.peersMu.Lock()
+	current := c.peerSessions[p.session] == p
+	if current {
+		c.unlinkPeerLocked(p)
+	}
+	c.peersMu.Unlock()
+	if current {
+		close(p.done)
+	}
+	p.t.RemovePeer(p.session)
+}
+
+// peerGone удаляет пира, который отключился от сервера
+func (c *VPNClient) peerGone(session uint64) {
+	c.peersMu.Lock()
+	p := c.peerSessions[session]
+	if p != nil {
+		c.unlinkPeerLocked(p)
+	}
+	c.peersMu.Unlock()
+	if p != nil {
+		logClient.Debug("Peer disconnected from server", "peer", p.ips)
+		close(p.done)
+	}
+}
+
+// unlinkPeerLocked удаляет пира из таблиц. Требует c.peersMu; done закрывает вызывающий
+func (c *VPNClient) unlinkPeerLocked(p *p2pPeer) {
+	for _, ip := range p.ips {
+		if c.peers[ip] == p {
+			delete(c.peers, ip)
+		}
+	}
+	delete(c.peerSessions, p.session)
+}
+
+// dropPeers удаляет всех пиров: после смены транспорта внешний адрес клиента другой,
+// и сервер познакомит клиентов заново
+func (c *VPNClient) dropPeers() {
+	c.peersMu.Lock()
+	peers := make([]*p2pPeer, 0, len(c.peerSessions))
+	for _, p := range c.peerSessions {
+		peers = append(peers, p)
+		c.unlinkPeerLocked(p)
+	}
+	c.peersMu.Unlock()
+	for _, p := range peers {
+		close(p.done)
+	}
+}
+
+// directPeer возвращает пира, которому пакет можно отправить напрямую через транспорт t
+// (nil - пакет идет через сервер)
+func (c *VPNClient) directPeer(t *transport.UDPTransport, packet []byte) *p2pPeer {
+	dst, ok := internal.PacketDestIP(packet)
+	if !ok {
+		return nil
+	}
+	c.peersMu.RLock()
+	p := c.peers[dst.String()]
+	c.peersMu.RUnlock()
+	if p == nil || p.t != t {
+		return nil
+	}
+	if last := t.PeerLastReceive(p.session); last.IsZero() || time.Since(last) >= PeerTimeout {
+		return nil
+	}
+	return p
+}
+
+// peerStatus возвращает пиров и путь к каждому
+func (c *VPNClient) peerStatus() []PeerStatus {
+	c.peersMu.RLock()
+	defer c.peersMu.RUnlock()
+	peers := make([]PeerStatus, 0, len(c.peerSessions))
+	for _, p := range c.peerSessions {
+		last := p.t.PeerLastReceive(p.session)
+		peers = append(peers, PeerStatus{
+			IPs:      p.ips,
+			Endpoint: p.endpoint.Load().String(),
+			Direct:   !last.IsZero() && time.Since(last) < PeerTimeout,
+		})
+	}
+	slices.SortFunc(peers, func(a, b PeerStatus) int { return strings.Compare(a.IPs[0], b.IPs[0]) })
+	return peers
+}
+
+// send отправляет сжатый пакет другому клиенту напрямую
+func (p *p2pPeer) send(data []byte, codec compress.Codec) error {
+	_, err := p.t.WriteTo(data, codec, p.endpoint.Load(), p.session)
+	return err
+}
+
+// acceptPeer проверяет пакет, пришедший в сессии другого клиента, и запоминает адрес,
+// с которого клиент его отправил: NAT мог выдать другой порт, чем видел сервер.
+// Напрямую клиент может отправлять только пакеты со своих адресов
+func (c *VPNClient) acceptPeer(session uint64, addr *net.UDPAddr, packet []byte) bool {
+	c.peersMu.RLock()
+	p := c.peerSessions[session]
+	c.peersMu.RUnlock()
+	if p == nil {
+		return false
+	}
+	src, ok := internal.PacketSourceIP(packet)
+	if !ok || !slices.Contains(p.ips, src.String()) {
+		return false
+	}
+	if old := p.endpoint.Load(); addr != nil && (!old.IP.Equal(addr.IP) || old.Port != addr.Port) {
+		p.endpoint.Store(&net.UDPAddr{IP: slices.Clone(addr.IP), Port: addr.Port})
+	}
+	return true
+}
@@ -1,23 +1,72 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"net"
-	"os/exec"
+	"net/netip"
+	"os"
+	"slices"
 	"strings"
+
+	"myvpn/internal/netlink"
+	"myvpn/internal/netstate"
 )
 
+// halfRoutes маршруты, которые вместе покрывают все адреса семейства и точнее default:
+// они перекрывают default route, не удаляя его
+var halfRoutes = map[bool][2]netip.Prefix{
+	false: {netip.MustParsePrefix("0.0.0.0/1"), netip.MustParsePrefix("128.0.0.0/1")},
+	true:  {netip.MustParsePrefix("::/1"), netip.MustParsePrefix("8000::/1")},
+}
+
+// PolicyRulePriority приоритет правил `ip rule`, которыми трафик направляется в VPN
+// при -fwmark. Правила проверяются раньше правила основной таблицы (32766)
+const PolicyRulePriority = 32000
+
 // RouteManager управляет маршрутизацией через VPN
 type RouteManager struct {
-	tunInterface string
-	serverIP     string
-	oldGateway   string
-	oldInterface string
-	routesAdded  []string
+	tunInterface  string
+	serverIP      netip.Addr
+	oldGateway    netip.Addr
+	oldInterface  string
+	ipv6          bool
+	oldGateway6   netip.Addr
+	oldInterface6 string
+	serverRoute   netlink.Route
+	splitRoutes   []netip.Prefix
+	routesAdded   []netlink.Route
+	hostRoutes    map[netip.Addr]*hostRoute // маршруты мимо VPN к внешним адресам других клиентов (P2P)
+	fwmark        uint32                    // метка сокетов к серверу и номер таблицы VPN (0 - без правил)
+	rulesAdded    []netlink.Rule            // добавленные правила выбора таблицы
+	excludeLAN    string                    // режим исключения локальной сети (ExcludeLANSubnets и т.д.)
+	lanRoutes     []netlink.Route           // маршруты мимо VPN к локальным сетям
+	excludes      []netip.Prefix            // сети, которые всегда идут мимо VPN (-exclude-routes)
+	excludeAdded  []netlink.Route           // маршруты мимо VPN к сетям excludes
+	state         *netstate.Store           // файл состояния для удаления маршрутов после сбоя
+}
+
+// hostRoute маршрут мимо VPN к одному адресу и число тех, кому он нужен
+type hostRoute struct {
+	route netlink.Route
+	refs  int
 }
 
-// NewRouteManager создает новый менеджер маршрутов
-func NewRouteManager(tunInterface, serverAddr string) (*RouteManager, error) {
+// NewRouteManager создает новый менеджер маршрутов.
+// Если ipv6 включен, IPv6 default route также направляется в VPN.
+// Если splitRoutes не пуст, default route не трогается и в VPN направляются только эти сети.
+// Если fwmark задан, маршруты добавляются в таблицу с этим номером, а в нее направляются
+// пакеты без метки fwmark (см. setupPolicyRoutes)
+func NewRouteManager(tunInterface, serverAddr string, ipv6 bool, splitRoutes []string, fwmark uint32) (*RouteManager, error) {
+	if fwmark >= 253 && fwmark <= 255 {
+		// Таблицы default, main и local заняты системой
+		return nil, fmt.Errorf("fwmark %d is a reserved routing table", fwmark)
+	}
+	networks, err := parseNetworks(splitRoutes)
+	if err != nil {
+		return nil, err
+	}
+
 	// Извлекаем IP адрес сервера из адреса
 	host, _, err := net.SplitHostPort(serverAddr)
 	if err != nil {
@@ -31,15 +80,114 @@ func NewRouteManager(tunInterface, serverAddr string) (*RouteManager, error) {
 		return nil, fmt.Errorf("failed to resolve server address: %w", err)
 	}
 
+	serverAddrIP, _ := netip.AddrFromSlice(serverIP.IP)
+
 	return &RouteManager{
 		tunInterface: tunInterface,
-		serverIP:     serverIP.IP.String(),
-		routesAdded:  make([]string, 0),
+		serverIP:     serverAddrIP.Unmap(),
+		ipv6:         ipv6,
+		splitRoutes:  networks,
+		routesAdded:  make([]netlink.Route, 0),
+		hostRoutes:   make(map[netip.Addr]*hostRoute),
+		fwmark:       fwmark,
+		excludeLAN:   ExcludeLANSubnets,
 	}, nil
 }
 
+// SetSplitRoutes задает сети для split tunneling (например, присланные сервером).
+// Вызывается до SetupRoutes
+func (rm *RouteManager) SetSplitRoutes(splitRoutes []string) error {
+	networks, err := parseNetworks(splitRoutes)
+	if err != nil {
+		return err
+	}
+	rm.splitRoutes = networks
+	return nil
+}
+
+// parseNetworks разбирает сети в нотации CIDR
+func parseNetworks(cidrs []string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, cidr := range cidrs {
+		network, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", cidr, err)
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks, nil
+}
+
+// SetExcludeRoutes задает сети (CIDR или адреса), которые идут мимо VPN даже при
+// туннелировании всего трафика. Вызывается до SetupRoutes
+func (rm *RouteManager) SetExcludeRoutes(excludes []string) error {
+	var networks []netip.Prefix
+	for _, item := range excludes {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() == nil {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+		network, err := netip.ParsePrefix(item)
+		if err != nil {
+			return fmt.Errorf("invalid excluded route %q: %w", item, err)
+		}
+		networks = append(networks, network.Masked())
+	}
+	rm.excludes = networks
+	return nil
+}
+
+// Routes возвращает сети split tunneling (пусто - весь трафик через VPN)
+func (rm *RouteManager) Routes() []string {
+	routes := make([]string, 0, len(rm.splitRoutes))
+	for _, network := range rm.splitRoutes {
+		routes = append(routes, network.String())
+	}
+	return routes
+}
+
+// ReplaceRoutes заменяет маршруты работающего туннеля: снимает добавленные и настраивает
+// маршруты заново для сетей splitRoutes (пусто - весь трафик)
+func (rm *RouteManager) ReplaceRoutes(splitRoutes []string) error {
+	if err := rm.SetSplitRoutes(splitRoutes); err != nil {
+		return err
+	}
+	restoreErr := rm.RestoreRoutes()
+	rm.routesAdded = rm.routesAdded[:0]
+	rm.rulesAdded = rm.rulesAdded[:0]
+	rm.serverRoute = netlink.Route{}
+	rm.oldGateway, rm.oldInterface = netip.Addr{}, ""
+	rm.oldGateway6, rm.oldInterface6 = netip.Addr{}, ""
+	if err := rm.SetupRoutes(); err != nil {
+		return err
+	}
+	return restoreErr
+}
+
+// SplitTunnel возвращает true, если в VPN направляются только выбранные сети
+func (rm *RouteManager) SplitTunnel() bool {
+	return len(rm.splitRoutes) > 0
+}
+
 // SetupRoutes настраивает маршрутизацию всего трафика через VPN
+// (или только выбранных сетей в режиме split tunneling). Default route системы
+// не удаляется: весь трафик забирают маршруты 0.0.0.0/1 и 128.0.0.0/1 через TUN,
+// поэтому после аварийного завершения клиента сеть остается рабочей
 func (rm *RouteManager) SetupRoutes() error {
+	// Исключения добавляются первыми: пакеты к ним не попадут в TUN ни на миг
+	if err := rm.addExcludeRoutes(); err != nil {
+		return fmt.Errorf("failed to add excluded routes: %w", err)
+	}
+	if rm.fwmark != 0 {
+		return rm.setupPolicyRoutes()
+	}
+	if rm.SplitTunnel() {
+		return rm.setupSplitRoutes()
+	}
+
 	// Получаем текущий default route
 	if err := rm.getCurrentDefaultRoute(); err != nil {
 		return fmt.Errorf("failed to get current default route: %w", err)
@@ -47,24 +195,150 @@ func (rm *RouteManager) SetupRoutes() error {
 
 	// Добавляем маршрут к VPN серверу через старый шлюз
 	// Это важно чтобы не потерять соединение с VPN после смены default route
-	serverRoute := fmt.Sprintf("%s via %s dev %s", rm.serverIP, rm.oldGateway, rm.oldInterface)
+	serverRoute := hostRouteVia(rm.serverIP, rm.oldGateway, rm.oldInterface)
+	if rm.serverIP.Is6() {
+		// Сервер доступен по IPv6 — маршрут к нему идет через IPv6 шлюз
+		gateway, iface, err := parseDefaultRoute(true, "")
+		if err != nil {
+			return fmt.Errorf("failed to get IPv6 default route for server: %w", err)
+		}
+		serverRoute = hostRouteVia(rm.serverIP, gateway, iface)
+	}
 	if err := rm.addRoute(serverRoute); err != nil {
 		return fmt.Errorf("failed to add server route: %w", err)
 	}
+	rm.serverRoute = serverRoute
 	rm.routesAdded = append(rm.routesAdded, serverRoute)
 
-	// Удаляем старый default route
-	if err := rm.deleteRoute("default"); err != nil {
-		// Игнорируем ошибку если маршрута нет
+	// Весь трафик через VPN маршрутами точнее default
+	if err := rm.addHalfRoutes(false); err != nil {
+		return fmt.Errorf("failed to add default route: %w", err)
+	}
+
+	if rm.ipv6 {
+		if err := rm.setupRoutes6(); err != nil {
+			return fmt.Errorf("failed to setup IPv6 routes: %w", err)
+		}
 	}
 
-	// Добавляем новый default route через VPN
-	defaultRoute := fmt.Sprintf("default dev %s", rm.tunInterface)
-	if err := rm.addRoute(defaultRoute); err != nil {
-		return fmt.Errorf("failed to add default route: %w", err)
+	if err := rm.addLANRoutes(); err != nil {
+		return fmt.Errorf("failed to add LAN routes: %w", err)
+	}
+	return nil
+}
+
+// setupSplitRoutes добавляет маршруты через TUN только для выбранных сетей.
+// Маршрут к серверу через старый шлюз нужен лишь если сервер попадает в одну из этих сетей
+func (rm *RouteManager) setupSplitRoutes() error {
+	for _, network := range rm.splitRoutes {
+		if !network.Contains(rm.serverIP) {
+			continue
+		}
+		gateway, iface, err := parseDefaultRoute(rm.serverIP.Is6(), "")
+		if err != nil {
+			return fmt.Errorf("failed to get default route for server: %w", err)
+		}
+		serverRoute := hostRouteVia(rm.serverIP, gateway, iface)
+		if err := rm.addRoute(serverRoute); err != nil {
+			return fmt.Errorf("failed to add server route: %w", err)
+		}
+		rm.serverRoute = serverRoute
+		rm.routesAdded = append(rm.routesAdded, serverRoute)
+		break
 	}
-	rm.routesAdded = append(rm.routesAdded, defaultRoute)
 
+	for _, network := range rm.splitRoutes {
+		r := netlink.Route{Dst: network, Dev: rm.tunInterface}
+		if err := rm.addRoute(r); err != nil {
+			return err
+		}
+		rm.routesAdded = append(rm.routesAdded, r)
+	}
+
+	return nil
+}
+
+// setupPolicyRoutes направляет трафик в VPN таблицей маршрутов rm.fwmark и правилами
+// `ip rule`, как wg-quick: пакеты без метки ищут маршрут в таблице VPN, а помеченные
+// сокеты клиента - в основной таблице. Маршрут к серверу не нужен и default route основной
+// таблицы не меняется, поэтому зашифрованные пакеты не попадают в TUN, даже когда
+// шлюз системы меняется
+func (rm *RouteManager) setupPolicyRoutes() error {
+	var families []bool // для каких семейств (ipv6) нужны правила
+	if rm.SplitTunnel() {
+		for _, network := range rm.splitRoutes {
+			ipv6 := network.Addr().Is6()
+			r := netlink.Route{Dst: network, Dev: rm.tunInterface, Table: rm.fwmark}
+			if err := rm.addRoute(r); err != nil {
+				return err
+			}
+			rm.routesAdded = append(rm.routesAdded, r)
+			if !slices.Contains(families, ipv6) {
+				families = append(families, ipv6)
+			}
+		}
+	} else {
+		families = []bool{false}
+		if rm.ipv6 {
+			families = append(families, true)
+		}
+		for _, ipv6 := range families {
+			r := netlink.Route{Dst: defaultNetwork(ipv6), Dev: rm.tunInterface, Table: rm.fwmark}
+			if err := rm.addRoute(r); err != nil {
+				return fmt.Errorf("failed to add default route: %w", err)
+			}
+			rm.routesAdded = append(rm.routesAdded, r)
+		}
+	}
+
+	for _, ipv6 := range families {
+		rules := []netlink.Rule{{
+			IPv6:              ipv6,
+			Priority:          PolicyRulePriority + 1,
+			Table:             rm.fwmark,
+			Mark:              rm.fwmark,
+			Invert:            true,
+			SuppressPrefixLen: -1,
+		}}
+		if !rm.SplitTunnel() {
+			// Маршруты основной таблицы, кроме default (локальные сети, другие интерфейсы),
+			// проверяются раньше таблицы VPN
+			rules = append(rules, netlink.Rule{IPv6: ipv6, Priority: PolicyRulePriority, SuppressPrefixLen: 0})
+		}
+		for _, r := range rules {
+			if err := rm.addRule(r); err != nil {
+				return err
+			}
+			rm.rulesAdded = append(rm.rulesAdded, r)
+		}
+	}
+
+	// Маршруты основной таблицы к локальным сетям проверяются раньше таблицы VPN
+	if err := rm.addLANRoutes(); err != nil {
+		return fmt.Errorf("failed to add LAN routes: %w", err)
+	}
+	return nil
+}
+
+// setupRoutes6 направляет весь IPv6 трафик в VPN маршрутами ::/1 и 8000::/1.
+// IPv6 default route у системы может отсутствовать, это не ошибка
+func (rm *RouteManager) setupRoutes6() error {
+	if gateway, iface, err := parseDefaultRoute(true, ""); err == nil {
+		rm.oldGateway6 = gateway
+		rm.oldInterface6 = iface
+	}
+	return rm.addHalfRoutes(true)
+}
+
+// addHalfRoutes добавляет через TUN две половины адресного пространства семейства
+func (rm *RouteManager) addHalfRoutes(ipv6 bool) error {
+	for _, network := range halfRoutes[ipv6] {
+		r := netlink.Route{Dst: network, Dev: rm.tunInterface}
+		if err := rm.addRoute(r); err != nil {
+			return err
+		}
+		rm.routesAdded = append(rm.routesAdded, r)
+	}
 	return nil
 }
 
@@ -72,6 +346,22 @@ func (rm *RouteManager) SetupRoutes() error {
 func (rm *RouteManager) RestoreRoutes() error {
 	var errs []error
 
+	rm.deleteLANRoutes()
+	rm.deleteExcludeRoutes()
+
+	// Маршруты к другим клиентам (P2P)
+	for key, r := range rm.hostRoutes {
+		rm.deleteRoute(r.route)
+		delete(rm.hostRoutes, key)
+	}
+
+	// Правила снимаются раньше маршрутов, чтобы трафик не попадал в пустую таблицу VPN
+	for i := len(rm.rulesAdded) - 1; i >= 0; i-- {
+		if err := rm.deleteRule(rm.rulesAdded[i]); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete rule %s: %w", rm.rulesAdded[i], err))
+		}
+	}
+
 	// Удаляем все добавленные маршруты в обратном порядке
 	for i := len(rm.routesAdded) - 1; i >= 0; i-- {
 		if err := rm.deleteRoute(rm.routesAdded[i]); err != nil {
@@ -79,77 +369,232 @@ func (rm *RouteManager) RestoreRoutes() error {
 		}
 	}
 
-	// Восстанавливаем старый default route если он был
-	if rm.oldInterface != "" {
-		var oldDefaultRoute string
-		if rm.oldGateway != "" {
-			oldDefaultRoute = fmt.Sprintf("default via %s dev %s", rm.oldGateway, rm.oldInterface)
-		} else {
-			oldDefaultRoute = fmt.Sprintf("default dev %s", rm.oldInterface)
-		}
-		if err := rm.addRoute(oldDefaultRoute); err != nil {
-			errs = append(errs, fmt.Errorf("failed to restore default route: %w", err))
+	if len(errs) > 0 {
+		return fmt.Errorf("errors restoring routes: %v", errs)
+	}
+
+	return nil
+}
+
+// RefreshServerRoute перестраивает маршрут к VPN серверу после смены сети:
+// если у системы появился другой шлюз (например, LTE вместо Wi-Fi), маршрут к серверу
+// направляется через него, иначе зашифрованный трафик ушел бы в TUN
+func (rm *RouteManager) RefreshServerRoute() error {
+	if !rm.serverRoute.Dst.IsValid() {
+		return nil
+	}
+
+	gateway, iface, err := parseDefaultRoute(rm.serverRoute.IPv6(), rm.tunInterface)
+	if err != nil {
+		return err
+	}
+
+	newRoute := hostRouteVia(rm.serverIP, gateway, iface)
+	if newRoute == rm.serverRoute {
+		return nil
+	}
+
+	rm.deleteRoute(rm.serverRoute)
+	if err := rm.addRoute(newRoute); err != nil {
+		return err
+	}
+	for i, r := range rm.routesAdded {
+		if r == rm.serverRoute {
+			rm.routesAdded[i] = newRoute
 		}
 	}
+	rm.serverRoute = newRoute
 
-	if len(errs) > 0 {
-		return fmt.Errorf("errors restoring routes: %v", errs)
+	// Маршруты к другим клиентам (AddHostRoute) пойдут через новый шлюз
+	// (в split режиме шлюз каждый раз берется из таблицы)
+	if rm.SplitTunnel() {
+		return nil
+	}
+	if newRoute.IPv6() {
+		rm.oldGateway6, rm.oldInterface6 = gateway, iface
+	} else {
+		rm.oldGateway, rm.oldInterface = gateway, iface
+	}
+	return nil
+}
+
+// AddHostRoute направляет пакеты к адресу ip мимо VPN, через шлюз маршрута по умолчанию
+// до подключения: иначе пакеты к внешнему адресу другого клиента (P2P) ушли бы в TUN.
+// Маршрут снимается, когда DeleteHostRoute вызван столько же раз
+func (rm *RouteManager) AddHostRoute(ip net.IP) error {
+	key, _ := netip.AddrFromSlice(ip)
+	key = key.Unmap()
+	if rm.fwmark != 0 {
+		// Пакеты другим клиентам идут через помеченный сокет и так минуют VPN
+		return nil
+	}
+	if key == rm.serverIP {
+		// К серверу маршрут уже есть, и снимать его вместе с пиром нельзя
+		return nil
+	}
+	if r, ok := rm.hostRoutes[key]; ok {
+		r.refs++
+		return nil
 	}
 
+	ipv6 := key.Is6()
+	gateway, iface := rm.oldGateway, rm.oldInterface
+	if ipv6 {
+		gateway, iface = rm.oldGateway6, rm.oldInterface6
+	}
+	if iface == "" {
+		var err error
+		if gateway, iface, err = parseDefaultRoute(ipv6, rm.tunInterface); err != nil {
+			return err
+		}
+	}
+	r := hostRouteVia(key, gateway, iface)
+	if err := rm.addRoute(r); err != nil {
+		return err
+	}
+	rm.hostRoutes[key] = &hostRoute{route: r, refs: 1}
+	return nil
+}
+
+// addExcludeRoutes направляет сети excludes мимо VPN: маршрутами через текущий шлюз,
+// которые точнее маршрутов через TUN, а при fwmark - маршрутами throw в таблице VPN,
+// после которых поиск маршрута продолжается в основной таблице
+func (rm *RouteManager) addExcludeRoutes() error {
+	gateways := make(map[bool]netlink.Route)
+	for _, network := range rm.excludes {
+		ipv6 := network.Addr().Is6()
+		r := netlink.Route{Dst: network, Table: rm.fwmark, Throw: true}
+		if rm.fwmark == 0 {
+			gw, ok := gateways[ipv6]
+			if !ok {
+				gateway, iface, err := parseDefaultRoute(ipv6, rm.tunInterface)
+				if err != nil {
+					return err
+				}
+				gw = netlink.Route{Gateway: gateway, Dev: iface}
+				gateways[ipv6] = gw
+			}
+			r = netlink.Route{Dst: network, Gateway: gw.Gateway, Dev: gw.Dev}
+		}
+		if err := rm.addRoute(r); err != nil {
+			return err
+		}
+		rm.excludeAdded = append(rm.excludeAdded, r)
+	}
 	return nil
 }
 
+// deleteExcludeRoutes снимает маршруты, добавленные addExcludeRoutes
+func (rm *RouteManager) deleteExcludeRoutes() {
+	for _, r := range rm.excludeAdded {
+		rm.deleteRoute(r)
+	}
+	rm.excludeAdded = rm.excludeAdded[:0]
+}
+
+// RefreshExcludeRoutes направляет исключенные сети через новый шлюз после смены сети
+func (rm *RouteManager) RefreshExcludeRoutes() error {
+	if rm.fwmark != 0 || len(rm.excludeAdded) == 0 {
+		return nil
+	}
+	rm.deleteExcludeRoutes()
+	return rm.addExcludeRoutes()
+}
+
+// DeleteHostRoute снимает маршрут, добавленный AddHostRoute
+func (rm *RouteManager) DeleteHostRoute(ip net.IP) {
+	key, _ := netip.AddrFromSlice(ip)
+	key = key.Unmap()
+	r, ok := rm.hostRoutes[key]
+	if !ok {
+		return
+	}
+	if r.refs--; r.refs == 0 {
+		rm.deleteRoute(r.route)
+		delete(rm.hostRoutes, key)
+	}
+}
+
 // getCurrentDefaultRoute получает текущий default route
 func (rm *RouteManager) getCurrentDefaultRoute() error {
-	cmd := exec.Command("ip", "route", "show", "default")
-	output, err := cmd.Output()
+	gateway, iface, err := parseDefaultRoute(false, "")
 	if err != nil {
 		return err
 	}
+	rm.oldGateway = gateway
+	rm.oldInterface = iface
+	return nil
+}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(lines) == 0 || lines[0] == "" {
-		return fmt.Errorf("no default route found")
+// parseDefaultRoute возвращает шлюз и интерфейс default route для семейства адресов.
+// Маршруты через excludeDev (наш TUN) пропускаются
+func parseDefaultRoute(ipv6 bool, excludeDev string) (netip.Addr, string, error) {
+	routes, err := netlink.DefaultRoutes(ipv6)
+	if err != nil {
+		return netip.Addr{}, "", err
 	}
 
-	// Парсим строку вида "default via 192.168.1.1 dev eth0"
-	parts := strings.Fields(lines[0])
-	for i, part := range parts {
-		if part == "via" && i+1 < len(parts) {
-			rm.oldGateway = parts[i+1]
+	for _, r := range routes {
+		if excludeDev != "" && r.Dev == excludeDev {
+			continue
 		}
-		if part == "dev" && i+1 < len(parts) {
-			rm.oldInterface = parts[i+1]
+		if !r.Gateway.IsValid() || r.Dev == "" {
+			return netip.Addr{}, "", fmt.Errorf("default route without gateway: %s", r)
 		}
+		return r.Gateway, r.Dev, nil
 	}
+	return netip.Addr{}, "", fmt.Errorf("no default route found")
+}
 
-	if rm.oldGateway == "" || rm.oldInterface == "" {
-		return fmt.Errorf("failed to parse default route: %s", lines[0])
-	}
+// hostRouteVia возвращает маршрут к одному адресу через шлюз gateway (пустой - прямо
+// в интерфейс iface)
+func hostRouteVia(ip, gateway netip.Addr, iface string) netlink.Route {
+	return netlink.Route{Dst: netip.PrefixFrom(ip, ip.BitLen()), Gateway: gateway, Dev: iface}
+}
 
-	return nil
+// defaultNetwork возвращает сеть default route семейства адресов
+func defaultNetwork(ipv6 bool) netip.Prefix {
+	if ipv6 {
+		return netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+	}
+	return netip.PrefixFrom(netip.IPv4Unspecified(), 0)
 }
 
 // addRoute добавляет маршрут
-func (rm *RouteManager) addRoute(route string) error {
-	parts := strings.Fields(route)
-	cmd := exec.Command("ip", append([]string{"route", "add"}, parts...)...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		// Игнорируем ошибку "File exists" - маршрут уже существует
-		if !strings.Contains(string(output), "File exists") {
-			return fmt.Errorf("failed to add route %s: %w (output: %s)", route, err, string(output))
+func (rm *RouteManager) addRoute(route netlink.Route) error {
+	// Маршрут, который уже существует, не ошибка. В файл состояния он не записывается:
+	// после сбоя удалять чужой маршрут нельзя
+	if err := netlink.RouteAdd(route); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil
 		}
+		return fmt.Errorf("failed to add route %s: %w", route, err)
 	}
+	rm.state.AddRoute(route)
 	return nil
 }
 
-// deleteRoute удаляет маршрут
-func (rm *RouteManager) deleteRoute(route string) error {
-	parts := strings.Fields(route)
-	cmd := exec.Command("ip", append([]string{"route", "del"}, parts...)...)
-	if err := cmd.Run(); err != nil {
-		// Игнорируем ошибку если маршрута нет
-		return nil
+// deleteRoute удаляет маршрут. Маршрута, которого уже нет, не считается ошибкой
+func (rm *RouteManager) deleteRoute(route netlink.Route) error {
+	netlink.RouteDel(route)
+	rm.state.RemoveRoute(route)
+	return nil
+}
+
+// addRule добавляет правило выбора таблицы маршрутов
+func (rm *RouteManager) addRule(rule netlink.Rule) error {
+	if err := netlink.RuleAdd(rule); err != nil {
+		return fmt.Errorf("failed to add rule %s: %w", rule, err)
+	}
+	rm.state.AddRule(rule)
+	return nil
+}
+
+// deleteRule удаляет правило. Правила, которого уже нет, не считается ошибкой
+func (rm *RouteManager) deleteRule(rule netlink.Rule) error {
+	if err := netlink.RuleDel(rule); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
 	}
+	rm.state.RemoveRule(rule)
 	return nil
 }
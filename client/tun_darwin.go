@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"myvpn/internal/transport"
+)
+
+const (
+	// TUNInterfaceName префикс имен интерфейсов utun
+	TUNInterfaceName = "utun"
+	// utunHeaderSize заголовок пакета utun: семейство адресов в порядке байт сети
+	utunHeaderSize = 4
+	// sysprotoControl и utunOptIfname из <sys/kern_control.h> и <net/if_utun.h>
+	sysprotoControl = 2
+	utunOptIfname   = 2
+)
+
+// TUN интерфейс utun, который открыла система (NEPacketTunnelProvider на iOS и macOS)
+type TUN struct {
+	file *os.File
+	name string
+
+	rbuf []byte // чтение идет из одной горутины
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// NewTUN на macOS и iOS не поддерживается: интерфейс создает NetworkExtension
+// и передает его дескриптор (NewTUNFromFD)
+func NewTUN(name, ns string, clientIP string, clientIP6 string, queues int, offload bool) (*TUN, error) {
+	return nil, errors.New("TUN interface must be passed as a utun descriptor on this system")
+}
+
+// NewTUNFromFD использует дескриптор utun fd, который открыла система. Адреса, MTU
+// и маршруты интерфейса задает NetworkExtension
+func NewTUNFromFD(fd int) (*TUN, error) {
+	name, err := unix.GetsockoptString(fd, sysprotoControl, utunOptIfname)
+	if err != nil {
+		return nil, fmt.Errorf("descriptor %d is not a utun interface: %w", fd, err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		return nil, fmt.Errorf("failed to set TUN descriptor non-blocking: %w", err)
+	}
+	unix.CloseOnExec(fd)
+	return &TUN{
+		file: os.NewFile(uintptr(fd), name),
+		name: name,
+		rbuf: make([]byte, utunHeaderSize+transport.MaxPacketSize),
+		wbuf: make([]byte, 0, utunHeaderSize+transport.MaxPacketSize),
+	}, nil
+}
+
+// SetAddress адреса utun задает NetworkExtension
+func (t *TUN) SetAddress(ip, ip6 string) error {
+	return errors.ErrUnsupported
+}
+
+// SetMTU MTU utun задает NetworkExtension
+func (t *TUN) SetMTU(mtu int) error {
+	return errors.ErrUnsupported
+}
+
+func (t *TUN) Read(packet []byte) (int, error) {
+	return t.ReadQueue(0, packet)
+}
+
+func (t *TUN) Write(packet []byte) (int, error) {
+	return t.WriteQueue(0, packet)
+}
+
+func (t *TUN) Queues() int {
+	return 1
+}
+
+// ReadQueue читает IP пакет без заголовка utun
+func (t *TUN) ReadQueue(queue int, packet []byte) (int, error) {
+	for {
+		n, err := t.file.Read(t.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		if n > utunHeaderSize {
+			return copy(packet, t.rbuf[utunHeaderSize:n]), nil
+		}
+	}
+}
+
+// WriteQueue записывает IP пакет, добавляя заголовок utun с семейством по версии IP
+func (t *TUN) WriteQueue(queue int, packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, nil
+	}
+	family := uint32(unix.AF_INET)
+	if packet[0]>>4 == 6 {
+		family = unix.AF_INET6
+	}
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	buf := append(t.wbuf[:0], 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf, family)
+	buf = append(buf, packet...)
+	t.wbuf = buf
+	if _, err := t.file.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(packet), nil
+}
+
+func (t *TUN) Name() string {
+	return t.name
+}
+
+func (t *TUN) Close() error {
+	return t.file.Close()
+}
+
+func (t *TUN) File() *os.File {
+	return t.file
+}
@@ -0,0 +1,389 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"myvpn/internal"
+	"myvpn/internal/netlink"
+	"myvpn/internal/netns"
+	"myvpn/internal/vnethdr"
+)
+
+const (
+	// TUNInterfaceName имя TUN интерфейса на клиенте
+	TUNInterfaceName = "myvpn0"
+)
+
+// TUN представляет TUN интерфейс на клиенте
+type TUN struct {
+	files []*os.File // по одному дескриптору на очередь
+	name  string
+	netns string // пространство имен интерфейса, пусто - пространство имен процесса
+
+	// С offload (IFF_VNET_HDR) у каждой очереди свой разборщик сегментов
+	// и RawConn для записи пакетов с заголовком
+	vnet []*vnethdr.Reader
+	raws []syscall.RawConn
+}
+
+// NewTUN создает новый TUN интерфейс на клиенте.
+// clientIP6 может быть пустым, тогда IPv6 адрес не назначается. Если пуст и clientIP,
+// интерфейс поднимается без адресов: их назначит SetAddress.
+// При queues > 1 устройство открывается с IFF_MULTI_QUEUE и каждая очередь получает свой дескриптор.
+// offload включает IFF_VNET_HDR, TSO и checksum offload.
+// Если задано сетевое пространство имен ns, интерфейс переносится в него и там получает
+// адреса и маршрут по умолчанию; маршруты пространства имен процесса не меняются
+func NewTUN(name, ns string, clientIP string, clientIP6 string, queues int, offload bool) (*TUN, error) {
+	if queues < 1 || queues > internal.MaxTUNQueues {
+		return nil, fmt.Errorf("invalid number of TUN queues: %d (1-%d)", queues, internal.MaxTUNQueues)
+	}
+
+	tun := &TUN{}
+	for i := 0; i < queues; i++ {
+		file, actualName, err := openQueue(name, queues > 1, offload)
+		if err != nil {
+			tun.Close()
+			return nil, err
+		}
+		if offload {
+			raw, err := file.SyscallConn()
+			if err != nil {
+				file.Close()
+				tun.Close()
+				return nil, err
+			}
+			tun.vnet = append(tun.vnet, vnethdr.NewReader())
+			tun.raws = append(tun.raws, raw)
+		}
+		// Остальные очереди привязываем к интерфейсу, который создала первая
+		// (ядро могло подставить номер в шаблон имени)
+		tun.name = actualName
+		name = actualName
+		tun.files = append(tun.files, file)
+	}
+
+	if ns != "" {
+		if err := tun.moveTo(ns); err != nil {
+			tun.Close()
+			return nil, err
+		}
+	}
+
+	// Настраиваем интерфейс
+	if err := tun.setup(clientIP, clientIP6); err != nil {
+		tun.Close()
+		return nil, fmt.Errorf("failed to setup TUN interface: %w", err)
+	}
+
+	return tun, nil
+}
+
+// NewTUNFromFD использует TUN дескриптор fd, который открыл и настроил другой процесс
+// (привилегированная обертка, Android VpnService). Адреса, MTU и состояние интерфейса
+// не меняются: их задает тот, кто открыл устройство. Offload включается, если дескриптор
+// открыт с IFF_VNET_HDR
+func NewTUNFromFD(fd int) (*TUN, error) {
+	file, name, offload, err := adoptFD(fd)
+	if err != nil {
+		return nil, err
+	}
+	tun := &TUN{files: []*os.File{file}, name: name}
+	if offload {
+		raw, err := file.SyscallConn()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		tun.vnet = []*vnethdr.Reader{vnethdr.NewReader()}
+		tun.raws = []syscall.RawConn{raw}
+	}
+	return tun, nil
+}
+
+// adoptFD проверяет, что fd - дескриптор TUN без заголовка packet info, и возвращает
+// его файл, имя интерфейса и признак IFF_VNET_HDR
+func adoptFD(fd int) (*os.File, string, bool, error) {
+	var ifreq [unix.IFNAMSIZ + 64]byte
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(fd),
+		uintptr(unix.TUNGETIFF),
+		uintptr(unsafe.Pointer(&ifreq[0])),
+	)
+	if errno != 0 {
+		return nil, "", false, fmt.Errorf("descriptor %d is not a TUN device: %v", fd, errno)
+	}
+	flags := *(*uint16)(unsafe.Pointer(&ifreq[unix.IFNAMSIZ]))
+	if flags&unix.IFF_TUN == 0 || flags&unix.IFF_NO_PI == 0 {
+		return nil, "", false, fmt.Errorf("descriptor %d must be a TUN device opened with IFF_TUN|IFF_NO_PI", fd)
+	}
+	offload := flags&unix.IFF_VNET_HDR != 0
+	if offload {
+		if err := unix.IoctlSetInt(fd, unix.TUNSETOFFLOAD, vnethdr.Offload); err != nil {
+			return nil, "", false, fmt.Errorf("failed to enable TUN offload: %w", err)
+		}
+	}
+	// Неблокирующий дескриптор os.NewFile регистрирует в poller'е: без этого Close
+	// не прерывал бы чтение
+	if err := unix.SetNonblock(fd, true); err != nil {
+		return nil, "", false, fmt.Errorf("failed to set TUN descriptor non-blocking: %w", err)
+	}
+	unix.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), "tun-fd"), getInterfaceName(ifreq), offload, nil
+}
+
+// openQueue открывает /dev/net/tun и привязывает дескриптор к интерфейсу name
+func openQueue(name string, multiQueue, offload bool) (*os.File, string, error) {
+	// Открываем файл устройства TUN
+	file, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open TUN device: %w", err)
+	}
+
+	// Настраиваем TUN интерфейс
+	ifreq, err := createInterfaceRequest(name, multiQueue, offload)
+	if err != nil {
+		file.Close()
+		return nil, "", err
+	}
+
+	// Выполняем ioctl для создания интерфейса
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		file.Fd(),
+		uintptr(unix.TUNSETIFF),
+		uintptr(unsafe.Pointer(&ifreq[0])),
+	)
+
+	if errno != 0 {
+		file.Close()
+		return nil, "", fmt.Errorf("failed to create TUN interface: %v", errno)
+	}
+
+	if offload {
+		if err := unix.IoctlSetInt(int(file.Fd()), unix.TUNSETOFFLOAD, vnethdr.Offload); err != nil {
+			file.Close()
+			return nil, "", fmt.Errorf("failed to enable TUN offload: %w", err)
+		}
+	}
+
+	// Получаем реальное имя интерфейса
+	return file, getInterfaceName(ifreq), nil
+}
+
+// createInterfaceRequest создает структуру ifreq для ioctl
+func createInterfaceRequest(name string, multiQueue, vnetHdr bool) ([unix.IFNAMSIZ + 64]byte, error) {
+	var ifr [unix.IFNAMSIZ + 64]byte
+	copy(ifr[:], name)
+	// Устанавливаем флаг IFF_TUN (без IFF_NO_PI для получения чистых IP пакетов)
+	flags := uint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if multiQueue {
+		flags |= unix.IFF_MULTI_QUEUE
+	}
+	if vnetHdr {
+		flags |= unix.IFF_VNET_HDR
+	}
+	*(*uint16)(unsafe.Pointer(&ifr[unix.IFNAMSIZ])) = flags
+	return ifr, nil
+}
+
+// getInterfaceName извлекает имя интерфейса из ifreq
+func getInterfaceName(ifr [unix.IFNAMSIZ + 64]byte) string {
+	// Имя интерфейса находится в первых IFNAMSIZ байтах
+	name := make([]byte, 0, unix.IFNAMSIZ)
+	for i := 0; i < unix.IFNAMSIZ; i++ {
+		if ifr[i] == 0 {
+			break
+		}
+		name = append(name, ifr[i])
+	}
+	return string(name)
+}
+
+// moveTo переносит интерфейс в сетевое пространство имен ns
+func (t *TUN) moveTo(ns string) error {
+	f, err := netns.Open(ns)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := netlink.LinkSetNs(t.name, int(f.Fd())); err != nil {
+		return fmt.Errorf("failed to move TUN interface to network namespace %s: %w", ns, err)
+	}
+	t.netns = ns
+	return nil
+}
+
+// do выполняет настройку интерфейса fn в его пространстве имен
+func (t *TUN) do(fn func() error) error {
+	if t.netns == "" {
+		return fn()
+	}
+	return netns.Do(t.netns, fn)
+}
+
+// setup настраивает TUN интерфейс (IP адрес, MTU, поднимает интерфейс)
+func (t *TUN) setup(clientIP string, clientIP6 string) error {
+	return t.do(func() error {
+		// Настраиваем IP адреса интерфейса
+		if clientIP != "" {
+			ip6 := ""
+			if clientIP6 != "" {
+				ip6 = clientIP6 + "/64"
+			}
+			if err := t.addAddress(clientIP+"/24", ip6); err != nil {
+				return err
+			}
+		}
+
+		// Устанавливаем MTU
+		if err := netlink.LinkSetMTU(t.name, internal.TUNMTU); err != nil {
+			return fmt.Errorf("failed to set MTU: %w", err)
+		}
+
+		// Поднимаем интерфейс
+		if err := netlink.LinkSetUp(t.name); err != nil {
+			return fmt.Errorf("failed to bring interface up: %w", err)
+		}
+		// В новом пространстве имен loopback опущен
+		if t.netns != "" {
+			if err := netlink.LinkSetUp("lo"); err != nil {
+				return fmt.Errorf("failed to bring loopback up: %w", err)
+			}
+		}
+
+		return t.addDefaultRoutes(clientIP6 != "")
+	})
+}
+
+// addDefaultRoutes в отдельном пространстве имен направляет весь трафик в TUN:
+// кроме туннеля, в нем нет других путей наружу. ipv6 добавляет и маршрут IPv6
+func (t *TUN) addDefaultRoutes(ipv6 bool) error {
+	if t.netns == "" {
+		return nil
+	}
+	families := []bool{false}
+	if ipv6 {
+		families = append(families, true)
+	}
+	for _, v6 := range families {
+		r := netlink.Route{Dst: defaultNetwork(v6), Dev: t.name}
+		if err := netlink.RouteAdd(r); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to add default route %s: %w", r, err)
+		}
+	}
+	return nil
+}
+
+// SetAddress заменяет адреса интерфейса. ip и ip6 - адреса с длиной префикса (10.0.0.5/24),
+// пустой ip6 - без IPv6
+func (t *TUN) SetAddress(ip, ip6 string) error {
+	return t.do(func() error {
+		if err := netlink.AddrFlush(t.name); err != nil {
+			return fmt.Errorf("failed to remove old addresses: %w", err)
+		}
+		if err := t.addAddress(ip, ip6); err != nil {
+			return err
+		}
+		return t.addDefaultRoutes(ip6 != "")
+	})
+}
+
+// addAddress добавляет интерфейсу IPv4 и (если ip6 не пуст) IPv6 адрес
+func (t *TUN) addAddress(ip, ip6 string) error {
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil {
+		return fmt.Errorf("invalid IP address %q: %w", ip, err)
+	}
+	if err := netlink.AddrAdd(t.name, prefix); err != nil {
+		return fmt.Errorf("failed to set IP address: %w", err)
+	}
+
+	if ip6 != "" {
+		prefix, err := netip.ParsePrefix(ip6)
+		if err != nil {
+			return fmt.Errorf("invalid IPv6 address %q: %w", ip6, err)
+		}
+		if err := netlink.AddrAdd(t.name, prefix); err != nil {
+			return fmt.Errorf("failed to set IPv6 address: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetMTU меняет MTU интерфейса
+func (t *TUN) SetMTU(mtu int) error {
+	return t.do(func() error {
+		if err := netlink.LinkSetMTU(t.name, mtu); err != nil {
+			return fmt.Errorf("failed to set MTU: %w", err)
+		}
+		return nil
+	})
+}
+
+// Read читает IP пакет из первой очереди TUN интерфейса
+func (t *TUN) Read(packet []byte) (int, error) {
+	return t.ReadQueue(0, packet)
+}
+
+// Write записывает IP пакет в первую очередь TUN интерфейса
+func (t *TUN) Write(packet []byte) (int, error) {
+	return t.WriteQueue(0, packet)
+}
+
+// Queues возвращает число очередей
+func (t *TUN) Queues() int {
+	return len(t.files)
+}
+
+// ReadQueue читает IP пакет из очереди queue
+func (t *TUN) ReadQueue(queue int, packet []byte) (int, error) {
+	if t.vnet != nil {
+		return t.vnet[queue].ReadPacket(t.files[queue], packet)
+	}
+	return t.files[queue].Read(packet)
+}
+
+// WriteQueue записывает IP пакет в очередь queue
+func (t *TUN) WriteQueue(queue int, packet []byte) (int, error) {
+	if t.vnet == nil {
+		return t.files[queue].Write(packet)
+	}
+	var n int
+	var opErr error
+	err := t.raws[queue].Write(func(fd uintptr) bool {
+		n, opErr = vnethdr.Write(int(fd), packet)
+		return opErr != unix.EAGAIN
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, opErr
+}
+
+// Name возвращает имя интерфейса
+func (t *TUN) Name() string {
+	return t.name
+}
+
+// Close закрывает все очереди TUN интерфейса
+func (t *TUN) Close() error {
+	var firstErr error
+	for _, file := range t.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// File возвращает файловый дескриптор первой очереди для использования в select/poll
+func (t *TUN) File() *os.File {
+	return t.files[0]
+}
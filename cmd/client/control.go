@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"myvpn/client"
+	"myvpn/internal/cli"
+	"myvpn/internal/ctlsock"
+)
+
+// controlCommands команды управления работающим клиентом через его управляющий сокет
+func controlCommands() []*cli.Command {
+	commands := []*cli.Command{
+		{Name: "status", Args: "[-json]", Summary: "Connection state, transport, address, RTT and traffic of the running client"},
+		{Name: "reconnect", Summary: "Re-establish the transport to the server"},
+		{Name: "down", Summary: "Stop the running client, restoring routes and DNS"},
+		{Name: "set-routes", Args: "CIDR,...|all", Summary: "Replace split tunneling networks of the running client (all - whole traffic)"},
+	}
+	for _, c := range commands {
+		name := c.Name
+		c.Run = func(args []string) error {
+			return runControl(client.DefaultControlSocket, name, args)
+		}
+	}
+	return commands
+}
+
+// runControl выполняет команду name через управляющий сокет. socket - значение
+// флага -control-socket команды по умолчанию
+func runControl(socket, name string, args []string) error {
+	fs := cli.FlagSet("client", name)
+	fs.StringVar(&socket, "control-socket", socket, "Path to the control socket of the running client")
+	asJSON := fs.Bool("json", false, "Print status as JSON for scripts and tray applications (status)")
+	fs.Parse(args)
+	c := ctlsock.New(socket, "client")
+
+	switch name {
+	case "status":
+		return printStatus(c, *asJSON)
+	case "reconnect":
+		return c.Do(http.MethodPost, "/api/v1/reconnect", nil, nil)
+	case "down":
+		return c.Do(http.MethodPost, "/api/v1/down", nil, nil)
+	case "set-routes":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: client set-routes CIDR,...|all")
+		}
+		req := client.SetRoutesRequest{Routes: []string{}}
+		if fs.Arg(0) != "all" {
+			req.Routes = splitList(fs.Arg(0))
+		}
+		var st client.Status
+		if err := c.Do(http.MethodPut, "/api/v1/routes", req, &st); err != nil {
+			return err
+		}
+		fmt.Printf("Routes: %s\n", formatRoutes(st.Routes))
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q (see 'client help')", name)
+	}
+}
+
+// statusReport состояние и статистика клиента одним объектом (status -json)
+type statusReport struct {
+	client.Status
+	client.Stats
+}
+
+// printStatus выводит состояние клиента текстом или в JSON
+func printStatus(c *ctlsock.Client, asJSON bool) error {
+	var report statusReport
+	if err := c.Do(http.MethodGet, "/api/v1/status", nil, &report.Status); err != nil {
+		return err
+	}
+	if err := c.Do(http.MethodGet, "/api/v1/stats", nil, &report.Stats); err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	st, stats := report.Status, report.Stats
+	rtt := "unknown"
+	if stats.RTTMs > 0 {
+		rtt = fmt.Sprintf("%.1f ms (jitter %.1f ms, loss %.1f%%)", stats.RTTMs, stats.JitterMs, 100*stats.Loss)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "State:\t%s\n", st.State)
+	fmt.Fprintf(w, "Server:\t%s\n", st.Server)
+	fmt.Fprintf(w, "Transport:\t%s\n", st.Transport)
+	fmt.Fprintf(w, "Interface:\t%s (MTU %d)\n", st.Interface, st.MTU)
+	fmt.Fprintf(w, "Address:\t%s\n", st.Address)
+	fmt.Fprintf(w, "Routes:\t%s\n", formatRoutes(st.Routes))
+	fmt.Fprintf(w, "Uptime:\t%s\n", (time.Duration(st.UptimeSeconds) * time.Second).String())
+	fmt.Fprintf(w, "RTT:\t%s\n", rtt)
+	fmt.Fprintf(w, "Received:\t%s (%d packets)\n", ctlsock.FormatBytes(stats.RxBytes), stats.RxPackets)
+	fmt.Fprintf(w, "Sent:\t%s (%d packets)\n", ctlsock.FormatBytes(stats.TxBytes), stats.TxPackets)
+	fmt.Fprintf(w, "Reconnects:\t%d\n", stats.Reconnects)
+	for _, p := range st.Peers {
+		path := "via server"
+		if p.Direct {
+			path = "direct"
+		}
+		fmt.Fprintf(w, "Peer:\t%s at %s (%s)\n", strings.Join(p.IPs, ", "), p.Endpoint, path)
+	}
+	return w.Flush()
+}
+
+// formatRoutes выводит сети split tunneling
+func formatRoutes(routes []string) string {
+	if len(routes) == 0 {
+		return "all traffic"
+	}
+	return strings.Join(routes, ", ")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"myvpn/internal"
+	"myvpn/internal/cli"
+	"myvpn/internal/qrcode"
+)
+
+// qrScale размер модуля QR кода в PNG в пикселях
+const qrScale = 8
+
+// clientConfig файл конфигурации клиента (-config): ключи - имена флагов клиента.
+// Ключ записан прямо в файл, поэтому конфигурацию можно передать QR кодом
+type clientConfig struct {
+	Server          string   `json:"server"`
+	KeyData         string   `json:"key-data,omitempty"`
+	PrivateKeyData  string   `json:"private-key-data,omitempty"`
+	ServerPublicKey string   `json:"server-public-key,omitempty"`
+	IP              string   `json:"ip,omitempty"`
+	DNS             []string `json:"dns,omitempty"`
+}
+
+// clientConfigCommand команда client-config: конфигурация клиента с общим ключом сервера
+// в JSON, QR кодом в терминале или PNG
+func clientConfigCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "client-config",
+		Args:    "-endpoint HOST:PORT -key FILE [flags]",
+		Summary: "Generate a client config with the key inline, as JSON or a QR code",
+		Run: func(args []string) error {
+			fs := cli.FlagSet("server", "client-config")
+			endpoint := fs.String("endpoint", "", "Server address the client connects to (host:port)")
+			keyFile := fs.String("key", "", "Path to the server -key file")
+			ip := fs.String("ip", "auto", "Client tunnel address (auto - assigned by the server)")
+			dns := fs.String("dns", "", "Comma-separated DNS servers for the client (empty - servers pushed by the server)")
+			qr := fs.Bool("qr", false, "Print the config as a QR code to the terminal")
+			pngFile := fs.String("png", "", "Write the config as a QR code PNG image to this file")
+			out := fs.String("out", "", "Write the JSON config to this file with 0600 permissions")
+			force := fs.Bool("force", false, "Overwrite existing -out and -png files")
+			fs.Parse(args)
+			if *endpoint == "" || *keyFile == "" || fs.NArg() > 0 {
+				return errors.New("usage: server client-config -endpoint HOST:PORT -key FILE [-ip IP] [-dns LIST] [-qr] [-png FILE] [-out FILE]")
+			}
+
+			data, err := os.ReadFile(*keyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read key file: %w", err)
+			}
+			key, err := internal.ParseSharedKey(data)
+			if err != nil {
+				return err
+			}
+			cfg, err := newClientConfig(*endpoint, *ip, splitList(*dns))
+			if err != nil {
+				return err
+			}
+			cfg.KeyData = internal.FormatKey(key)
+			return writeClientConfig(cfg, *out, *pngFile, *qr, *force)
+		},
+	}
+}
+
+// newClientConfig проверяет параметры и собирает конфигурацию клиента без ключа
+func newClientConfig(endpoint, ip string, dns []string) (clientConfig, error) {
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		return clientConfig{}, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	if ip != "auto" && net.ParseIP(ip) == nil {
+		return clientConfig{}, fmt.Errorf("invalid client IP %q", ip)
+	}
+	for _, server := range dns {
+		if net.ParseIP(server) == nil {
+			return clientConfig{}, fmt.Errorf("invalid DNS server %q", server)
+		}
+	}
+	cfg := clientConfig{Server: endpoint, DNS: dns}
+	if ip != "auto" {
+		cfg.IP = ip
+	}
+	return cfg, nil
+}
+
+// writeClientConfig выводит конфигурацию: JSON в out или stdout, QR код в терминал (qr)
+// и в PNG файл. Без out JSON выводится, только если не заданы qr и pngFile
+func writeClientConfig(cfg clientConfig, out, pngFile string, qr, force bool) error {
+	compact, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		pretty, err := json.MarshalIndent(cfg, "", "    ")
+		if err != nil {
+			return err
+		}
+		if err := cli.WriteSecretFile(out, append(pretty, '\n'), force); err != nil {
+			return err
+		}
+	} else if !qr && pngFile == "" {
+		fmt.Println(string(compact))
+	}
+	if !qr && pngFile == "" {
+		return nil
+	}
+
+	code, err := qrcode.Encode(compact, qrcode.LevelM)
+	if err != nil {
+		return err
+	}
+	if qr {
+		fmt.Print(code.Terminal())
+	}
+	if pngFile != "" {
+		image, err := code.PNG(qrScale)
+		if err != nil {
+			return err
+		}
+		if err := cli.WriteSecretFile(pngFile, image, force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
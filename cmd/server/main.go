@@ -1,117 +1,728 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
+	"myvpn/adminrpc"
+	"myvpn/internal"
+	"myvpn/internal/auth"
+	"myvpn/internal/cli"
+	"myvpn/internal/compress"
+	"myvpn/internal/config"
+	"myvpn/internal/daemon"
+	"myvpn/internal/dnsfwd"
+	"myvpn/internal/flowexport"
+	"myvpn/internal/logging"
+	"myvpn/internal/metrics"
+	"myvpn/internal/peerdb"
+	"myvpn/internal/pktqueue"
+	"myvpn/internal/porthop"
+	"myvpn/internal/ratelimit"
+	"myvpn/internal/sdnotify"
+	"myvpn/internal/tracing"
+	"myvpn/internal/transport"
+	"myvpn/internal/uring"
 	"myvpn/server"
 )
 
+const (
+	// containerHealthAddr адрес /healthz и /readyz в режиме -container
+	containerHealthAddr = ":8081"
+	// containerShutdownTimeout срок остановки в режиме -container: меньше 30 секунд,
+	// которые Kubernetes по умолчанию ждет до SIGKILL
+	containerShutdownTimeout = 25 * time.Second
+)
+
 func main() {
+	app := &cli.App{
+		Name:    "server",
+		Default: "run",
+		Commands: []*cli.Command{
+			{
+				Name:    "run",
+				Args:    "[flags]",
+				Summary: "Start the VPN server",
+				Run: func(args []string) error {
+					run(args)
+					return nil
+				},
+			},
+			statusCommand(),
+			peersCommand(),
+			clientConfigCommand(),
+			cli.GenKeyCommand("server"),
+			cli.PubKeyCommand("server"),
+			cli.VersionCommand("server"),
+		},
+	}
+	app.Main()
+}
+
+// run запускает VPN сервер и обслуживает клиентов до сигнала остановки
+func run(args []string) {
 	var (
-		listenAddr   = flag.String("addr", "127.0.0.1:8080", "Address to listen on (default localhost for Xray backend)")
-		keyFile      = flag.String("key", "", "Path to encryption key file (32 bytes). If not provided, a random key will be generated")
-		verbose      = flag.Bool("verbose", false, "Enable verbose logging (logs every packet)")
-		pprofAddr    = flag.String("pprof", "127.0.0.1:6060", "Address for pprof HTTP server (empty to disable)")
-		metricsAddr  = flag.String("metrics", "127.0.0.1:6061", "Address for metrics HTTP server (empty to disable)")
+		listenAddr  = flag.String("addr", "127.0.0.1:8080", "Address to listen on, or a comma-separated list of addresses sharing sessions (e.g., :8080,:443; default localhost for Xray backend)")
+		keyFile     = flag.String("key", "", "Path to encryption key file (32 bytes binary, hex or base64; see genkey). If not provided, a random key will be generated")
+		keyData     = flag.String("key-data", "", "Encryption key in hex or base64 instead of -key (e.g., from VPNTURBO_KEY_DATA or a Kubernetes secret)")
+		verbose     = flag.Bool("verbose", false, "Enable verbose logging, logs every packet (same as -log-level debug)")
+		logLevel    = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+		logFormat   = flag.String("log-format", logging.FormatText, "Log format: text or json")
+		logFile     = flag.String("log-file", "", "Write the log to this file instead of stderr, rotating it by size and age")
+		logMaxSize  = flag.Int("log-max-size", logging.DefaultMaxSize>>20, "Rotate -log-file when it exceeds this many megabytes (0 to disable)")
+		logMaxAge   = flag.Duration("log-max-age", logging.DefaultMaxAge, "Rotate -log-file when it is older than this (0 to disable)")
+		logBackups  = flag.Int("log-max-backups", logging.DefaultMaxBackups, "Number of rotated log files to keep (0 to keep all)")
+		pcapFile    = flag.String("pcap", "", "Capture tunnel traffic to this pcap file from startup (also started via the admin API)")
+		pcapLimit   = flag.Int("pcap-limit", 100, "Stop the capture when the pcap file exceeds this many megabytes (0 for unlimited)")
+		pcapOuter   = flag.Bool("pcap-outer", false, "Also capture encrypted outer UDP datagrams, not only inner IP packets")
+		otelAddr    = flag.String("otel-endpoint", "", "OTLP collector address (host:port) for OpenTelemetry traces (empty to disable)")
+		otelProto   = flag.String("otel-protocol", tracing.ProtocolGRPC, "OTLP protocol: grpc or http")
+		otelNoTLS   = flag.Bool("otel-insecure", false, "Connect to the OTLP collector without TLS")
+		otelSample  = flag.Float64("otel-sample", tracing.DefaultSampleRate, "Fraction of packets traced through encryption, compression and TUN writes (0 traces only handshakes)")
+		pprofAddr   = flag.String("pprof", "127.0.0.1:6060", "Address for pprof HTTP server (empty to disable)")
+		metricsAddr = flag.String("metrics", "127.0.0.1:6061", "Address for metrics HTTP server (empty to disable)")
+		healthAddr  = flag.String("health", "", "Address for /healthz and /readyz probes without the metrics server (empty to disable; also served by -metrics)")
+		apiAddr     = flag.String("api", "", "Address for admin REST API (empty to disable)")
+		grpcAddr    = flag.String("grpc", "", "Address for admin gRPC API (empty to disable)")
+		control     = flag.String("control-socket", "", "Path to Unix control socket for vpnctl, e.g. "+adminrpc.DefaultControlSocket+" (empty to disable; access is limited to the socket owner)")
+		apiToken    = flag.String("api-token", "", "Bearer token for admin REST and gRPC APIs (required with -api/-grpc)")
+		idleTimeout = flag.Duration("idle-timeout", server.DefaultIdleTimeout, "Remove client sessions after this period without packets (0 to disable)")
+		maxClients  = flag.Int("max-clients", 0, "Maximum number of concurrent client sessions (0 for unlimited)")
+		dnsServers  = flag.String("dns", "", "Comma-separated DNS servers pushed to clients (e.g., 1.1.1.1,2606:4700:4700::1111)")
+		dnsForward  = flag.String("dns-forward", "", "Comma-separated upstream DNS servers for the built-in DNS forwarder on the server's VPN addresses: host[:port] or https:// DoH URL (empty to disable; pushed to clients when -dns is empty)")
+		dnsCache    = flag.Int("dns-cache", dnsfwd.DefaultCacheSize, "Number of answers the DNS forwarder caches (0 to disable)")
+		pushRoutes  = flag.String("push-routes", "", "Comma-separated CIDRs pushed to clients to route through VPN instead of all traffic (e.g., 10.10.0.0/16)")
+		pushMTU     = flag.Int("push-mtu", 0, "TUN MTU pushed to clients (0 to let clients choose)")
+		firewall    = flag.String("firewall", server.FirewallAuto, "How to set up NAT and forwarding rules: auto (iptables if installed, otherwise nftables), iptables, nftables (a dedicated inet "+server.NftTable+" table), or none (only IP forwarding, NAT is left to the cluster CNI or host)")
+		stateFile   = flag.String("state-file", server.DefaultStateFile, "File recording iptables chains and nftables tables added by the server, so that the next start removes them after a crash (empty to disable)")
+		mssClamp    = flag.String("mss-clamp", "pmtu", "Clamp the MSS of TCP connections through the tunnel: pmtu (to the route MTU), a fixed MSS for IPv4 (536-1460, 20 less for IPv6) or off")
+		rateUp      = flag.String("rate-up", "", "Per-client upload limit, client to server (e.g., 10mbit; empty for unlimited)")
+		rateDown    = flag.String("rate-down", "", "Per-client download limit, server to client (e.g., 10mbit; empty for unlimited)")
+		peerLimits  = flag.String("peer-limits", "", "Comma-separated per-peer limits name=up/down (e.g., alice=10mbit/50mbit)")
+		tunQueues   = flag.Int("tun-queues", 1, "Number of TUN queues (IFF_MULTI_QUEUE), one reader/writer goroutine per queue")
+		tunFD       = flag.Int("tun-fd", 0, "Use this already opened and configured TUN file descriptor instead of creating myvpn0 (passed by a privileged wrapper; 0 to open /dev/net/tun)")
+		tunOffload  = flag.Bool("tun-offload", false, "Enable virtio-net headers with TSO and checksum offload on the TUN device (large TCP segments are split into packets by the server)")
+		netstackOn  = flag.Bool("netstack", false, "Terminate clients' TCP and UDP in a userspace network stack and open ordinary sockets from the server process instead of a TUN device: no root, IP forwarding, NAT or firewall rules needed (ICMP is not forwarded; -firewall and -state-file are ignored)")
+		shards      = flag.Int("listen-shards", 1, "Number of UDP sockets bound to the listen address with SO_REUSEPORT, one reader goroutine each; a session always lands on the same socket")
+		queueSize   = flag.Int("queue-size", 0, "Packets held in each queue between the TUN, the crypto path and the UDP socket (0 for the default of 256 towards clients and 64 per TUN writer)")
+		queuePolicy = flag.String("queue-policy", "block", "What to do when a packet queue is full: block (backpressure), tail-drop, or codel (also drop packets delayed over 5ms for 100ms)")
+		shaping     = flag.Bool("shaping", false, "Schedule packets to clients per client with interactive and bulk classes; -rate-down then delays packets instead of dropping them")
+		ioEngine    = flag.String("io-engine", "std", "Packet I/O engine for the UDP socket and TUN: std (recvmmsg/sendmmsg, read/write) or uring (io_uring batches)")
+		compression = flag.String("compress", "auto", "Compression: off, auto (negotiate with the peer), or preferred codec lz4 or zstd")
+		dscp        = flag.String("dscp", "", "DSCP of UDP datagrams to clients: copy (from the inner packet), 0-63, or a class name such as ef or af41 (empty to leave the default)")
+		obfsPad     = flag.Int("obfs-pad", 0, "Pad encrypted packets to a multiple of this many bytes to hide packet sizes (0 to disable)")
+		obfsCover   = flag.Duration("obfs-cover", 0, "Mean interval between random-size cover packets sent to each client (0 to disable)")
+		p2p         = flag.Bool("p2p", false, "Introduce clients that request peer-to-peer mode to each other (public endpoints and a pair key) so they can punch NAT and exchange packets directly")
+		mesh        = flag.Bool("mesh", false, "Introduce every peer-to-peer client to all others as soon as it connects, forming a mesh with relay through the server as fallback (implies -p2p)")
+		portHop     = flag.String("port-hop", "", "UDP port range redirected to the listen port for clients with port hopping (e.g., 20000-30000)")
+		tcpListen   = flag.String("tcp-listen", "", "Address for TCP fallback transport for clients without UDP (empty to disable)")
+		tcpTLS      = flag.Bool("tcp-tls", false, "Serve -tcp-listen over TLS with the -wss-cert certificate")
+		wssListen   = flag.String("wss-listen", "", "Address for WebSocket over TLS fallback transport (empty to disable)")
+		wssCert     = flag.String("wss-cert", "", "Path to TLS certificate for -wss-listen and -tcp-tls")
+		wssKey      = flag.String("wss-key", "", "Path to TLS private key for -wss-listen and -tcp-tls")
+		clientCA    = flag.String("client-ca", "", "Path to PEM CA certificates; TLS transports then require client certificates signed by them")
+		wssPath     = flag.String("wss-path", server.DefaultWSSPath, "HTTP path of the WebSocket endpoint")
+		fallback    = flag.String("fallback", "", "Website for non-VPN connections to the wss and tcp ports: an http(s):// URL to proxy to or a directory to serve (empty to disable)")
+		kcpListen   = flag.String("kcp-listen", "", "UDP address for KCP transport for lossy links (empty to disable, must differ from -addr)")
+		grpcListen  = flag.String("grpc-listen", "", "Address for gRPC (HTTP/2 over TLS with the -wss-cert certificate) transport that passes CDNs and L7 load balancers (empty to disable)")
+		grpcService = flag.String("grpc-service", transport.DefaultGRPCService, "gRPC service name of -grpc-listen (must match clients)")
+		dnsTunnel   = flag.String("dns-tunnel-listen", "", "UDP address of the authoritative DNS server for -dns-tunnel-zone that carries the dns transport, usually :53 (empty to disable)")
+		dnsZone     = flag.String("dns-tunnel-zone", "", "DNS zone delegated to this server for the dns transport (e.g., t.example.com, must match clients)")
+		kcpFEC      = flag.String("kcp-fec", "10/3", "KCP forward error correction data/parity shards (0/0 to disable, must match clients)")
+		minVersion  = flag.Uint("min-version", 0, "Minimum client protocol version; older clients are rejected (0 accepts clients without version negotiation)")
+		cookieLoad  = flag.Int("cookie-threshold", transport.DefaultCookieThreshold, "Packets per second from unknown sessions above which the server requires a cookie proving the source address (0 to disable)")
+		handshakes  = flag.Float64("handshake-rate", transport.DefaultHandshakeRate, "Packets per second from unknown sessions accepted from one source IP (0 for unlimited)")
+		privateKey  = flag.String("private-key", "", "Path to server X25519 private key file (base64 or hex) for public-key peers")
+		peersFile   = flag.String("peers", "", "Path to JSON file with public-key peers: [{\"name\", \"public_key\", \"allowed_ips\"}] (requires -private-key)")
+		authSpec    = flag.String("auth", "", "External authentication backend URL: radius://SECRET@host:1812, ldap://host:389?dn=uid={user},ou=people,dc=example,dc=com or ldaps://... (empty to disable)")
+		flowAddr    = flag.String("flow-collector", "", "NetFlow/IPFIX collector address (host:port) to export client flows to (empty to disable)")
+		flowProto   = flag.String("flow-protocol", flowexport.ProtocolIPFIX, "Flow export protocol: ipfix or netflow9")
+		flowActive  = flag.Duration("flow-active-timeout", flowexport.DefaultActiveTimeout, "Export long-lived flows at least this often")
+		flowIdle    = flag.Duration("flow-idle-timeout", flowexport.DefaultIdleTimeout, "Consider a flow finished after this period without packets")
+		peerDBPath  = flag.String("peer-db", "", "Path to peer database file; peers added, disabled or revoked via the admin API are stored there (manage offline with 'server peers -peer-db FILE ...')")
+		totpFile    = flag.String("totp-file", "", "Path to JSON file with per-peer TOTP secrets {\"peer\": \"base32 secret\"}; secrets issued via the admin API are saved there")
+		workers     = flag.Int("crypto-workers", runtime.NumCPU(), "Number of goroutines encrypting/decrypting packet batches in parallel (1 to disable)")
+		daemonize   = flag.Bool("daemon", false, "Run in the background detached from the terminal (use with -log-file and -pidfile)")
+		pidFile     = flag.String("pidfile", "", "Write the process ID to this file and remove it on exit")
+		container   = flag.Bool("container", false, "Run in a container (Docker, Kubernetes): pprof off, JSON log, /healthz and /readyz on "+containerHealthAddr+", a key is required and shutdown is limited to "+containerShutdownTimeout.String()+", unless these flags are set explicitly")
+		stopTimeout = flag.Duration("shutdown-timeout", 0, "Exit with an error if the server has not stopped this long after SIGTERM (0 to wait indefinitely)")
+		configFile  = flag.String("config", "", "Path to JSON config file (keys are flag names, command line flags and VPNTURBO_* environment variables take precedence)")
 	)
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+
+	if err := config.ApplyEnv(flag.CommandLine); err != nil {
+		logging.Fatal("Invalid environment variable", logging.Err(err))
+	}
+
+	var cfgFile *config.File
+	if *configFile != "" {
+		var err error
+		if cfgFile, err = config.Load(flag.CommandLine, *configFile); err != nil {
+			logging.Fatal("Failed to load config", logging.Err(err))
+		}
+	}
+	if *container {
+		explicit := make(map[string]bool)
+		flag.CommandLine.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if *daemonize {
+			logging.Fatal("-daemon cannot be used with -container")
+		}
+		if *keyFile == "" && *keyData == "" {
+			// Случайный ключ менялся бы при каждом перезапуске контейнера
+			logging.Fatal("-container requires -key or -key-data")
+		}
+		if !explicit["pprof"] {
+			*pprofAddr = ""
+		}
+		if !explicit["log-format"] {
+			*logFormat = logging.FormatJSON
+		}
+		if !explicit["health"] {
+			*healthAddr = containerHealthAddr
+		}
+		if !explicit["shutdown-timeout"] {
+			*stopTimeout = containerShutdownTimeout
+		}
+	}
+	if *verbose {
+		*logLevel = "debug"
+	}
+	// Фоновый процесс - копия этого с теми же флагами: он сам откроет журнал и PID файл
+	if *daemonize && flag.NArg() == 0 {
+		parent, err := daemon.Detach()
+		if err != nil {
+			logging.Fatal("Failed to start in background", logging.Err(err))
+		}
+		if parent {
+			return
+		}
+	}
+	logOutput := io.Writer(os.Stderr)
+	if *logFile != "" {
+		file, err := logging.OpenRotating(*logFile, int64(*logMaxSize)<<20, *logMaxAge, *logBackups)
+		if err != nil {
+			logging.Fatal("Failed to open log file", logging.Err(err))
+		}
+		defer file.Close()
+		logOutput = file
+	}
+	if err := logging.Setup(logOutput, *logLevel, *logFormat); err != nil {
+		logging.Fatal("Invalid logging options", logging.Err(err))
+	}
+	// Офлайн команды (peers) работают рядом с запущенным сервером и PID файл не трогают
+	if *pidFile != "" && flag.NArg() == 0 {
+		remove, err := daemon.WritePIDFile(*pidFile)
+		if err != nil {
+			logging.Fatal("Failed to write PID file", logging.Err(err))
+		}
+		defer remove()
+	}
+
+	// Команда после флагов - прежний вид командной строки (server -peer-db FILE peers list)
+	if flag.NArg() > 0 {
+		if flag.Arg(0) != "peers" {
+			logging.Fatal("Unknown command", "command", flag.Arg(0))
+		}
+		if err := runPeers(*peerDBPath, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if (*apiAddr != "" || *grpcAddr != "") && *apiToken == "" {
+		logging.Fatal("Admin API requires -api-token")
+	}
+
+	if *otelAddr != "" {
+		shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+			Endpoint:    *otelAddr,
+			Protocol:    *otelProto,
+			Insecure:    *otelNoTLS,
+			ServiceName: "myvpn-server",
+			SampleRate:  *otelSample,
+		})
+		if err != nil {
+			logging.Fatal("Failed to set up tracing", logging.Err(err))
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdown(ctx)
+		}()
+	}
 
 	// Загружаем или генерируем ключ
-	key, err := loadOrGenerateKey(*keyFile)
+	key, err := loadOrGenerateKey(*keyFile, *keyData)
+	if err != nil {
+		logging.Fatal("Failed to load/generate key", logging.Err(err))
+	}
+
+	// Параметры, которые сервер меняет без перезапуска
+	loadReloadable := func() (server.ReloadConfig, error) {
+		rc := server.ReloadConfig{
+			PushRoutes:  splitList(*pushRoutes),
+			PushMTU:     *pushMTU,
+			IdleTimeout: *idleTimeout,
+			MaxClients:  *maxClients,
+		}
+		var err error
+		if rc.DNSServers, err = parseIPList(*dnsServers); err != nil {
+			return rc, fmt.Errorf("invalid -dns value: %w", err)
+		}
+		if rc.DefaultLimit.Up, err = ratelimit.ParseRate(*rateUp); err != nil {
+			return rc, fmt.Errorf("invalid -rate-up value: %w", err)
+		}
+		if rc.DefaultLimit.Down, err = ratelimit.ParseRate(*rateDown); err != nil {
+			return rc, fmt.Errorf("invalid -rate-down value: %w", err)
+		}
+		if rc.PeerLimits, err = parsePeerLimits(*peerLimits); err != nil {
+			return rc, fmt.Errorf("invalid -peer-limits value: %w", err)
+		}
+		if *peersFile != "" {
+			if rc.Peers, err = server.LoadPeers(*peersFile); err != nil {
+				return rc, err
+			}
+		}
+		return rc, nil
+	}
+	reloadable, err := loadReloadable()
+	if err != nil {
+		logging.Fatal("Invalid configuration", logging.Err(err))
+	}
+
+	// По SIGHUP и запросу admin API перечитываются -config, -peers и уровень журнала.
+	// Остальные параметры меняются только перезапуском
+	reload := func() (server.ReloadConfig, error) {
+		if cfgFile != nil {
+			restart, err := cfgFile.Reload(reloadableFlags)
+			if err != nil {
+				return server.ReloadConfig{}, err
+			}
+			if len(restart) > 0 {
+				slog.Warn("Changed options take effect after restart", "options", restart)
+			}
+		}
+		level := *logLevel
+		if *verbose {
+			level = "debug"
+		}
+		l, err := logging.ParseLevel(level)
+		if err != nil {
+			return server.ReloadConfig{}, err
+		}
+		rc, err := loadReloadable()
+		if err != nil {
+			return rc, err
+		}
+		if l != logging.Level() {
+			logging.SetLevel(level)
+			slog.Info("Log level changed", "level", level)
+		}
+		return rc, nil
+	}
+
+	codec, compressionOn, err := compress.ParseMode(*compression)
 	if err != nil {
-		log.Fatalf("Failed to load/generate key: %v", err)
+		logging.Fatal("Invalid -compress value", logging.Err(err))
+	}
+
+	policy, err := pktqueue.ParsePolicy(*queuePolicy)
+	if err != nil {
+		logging.Fatal("Invalid -queue-policy value", logging.Err(err))
+	}
+	mss, err := server.ParseMSSClamp(*mssClamp)
+	if err != nil {
+		logging.Fatal("Invalid -mss-clamp value", logging.Err(err))
+	}
+	if *queueSize < 0 {
+		logging.Fatal("Invalid -queue-size value", "size", *queueSize)
+	}
+
+	var outerDSCP int
+	if *dscp != "" {
+		if outerDSCP, err = transport.ParseDSCP(*dscp); err != nil {
+			logging.Fatal("Invalid -dscp value", logging.Err(err))
+		}
+	}
+
+	hopPorts, err := porthop.ParseRange(*portHop)
+	if err != nil {
+		logging.Fatal("Invalid -port-hop value", logging.Err(err))
+	}
+
+	fec, err := transport.ParseFEC(*kcpFEC)
+	if err != nil {
+		logging.Fatal("Invalid -kcp-fec value", logging.Err(err))
+	}
+
+	switch *ioEngine {
+	case "std":
+	case "uring":
+		if err := uring.Available(); err != nil {
+			logging.Fatal("io_uring is not available, use -io-engine=std", logging.Err(err))
+		}
+	default:
+		logging.Fatal("Invalid -io-engine value: expected std or uring", "value", *ioEngine)
+	}
+
+	if *minVersion > uint(internal.ProtocolVersion) {
+		logging.Fatal("Invalid -min-version value: higher than the protocol version of this server", "version", internal.ProtocolVersion)
+	}
+
+	var staticKey []byte
+	if *privateKey != "" {
+		if staticKey, err = loadPrivateKey(*privateKey); err != nil {
+			logging.Fatal("Failed to load private key", logging.Err(err))
+		}
+	}
+	var totpSecrets map[string]string
+	if *totpFile != "" {
+		if totpSecrets, err = server.LoadTOTPSecrets(*totpFile); err != nil {
+			logging.Fatal("Failed to load TOTP secrets", logging.Err(err))
+		}
+	}
+
+	var authBackend auth.Backend
+	if *authSpec != "" {
+		if authBackend, err = auth.New(*authSpec); err != nil {
+			logging.Fatal("Invalid -auth value", logging.Err(err))
+		}
+	}
+
+	var flows *flowexport.Exporter
+	if *flowAddr != "" {
+		flows, err = flowexport.New(flowexport.Options{
+			Collector:     *flowAddr,
+			Protocol:      *flowProto,
+			ActiveTimeout: *flowActive,
+			IdleTimeout:   *flowIdle,
+		})
+		if err != nil {
+			logging.Fatal("Failed to start flow export", logging.Err(err))
+		}
+		defer flows.Close()
+	}
+
+	var peerDB *peerdb.DB
+	if *peerDBPath != "" {
+		if peerDB, err = peerdb.Open(*peerDBPath); err != nil {
+			logging.Fatal("Failed to open peer database", logging.Err(err))
+		}
+		defer peerDB.Close()
 	}
 
 	// Создаем сервер
-	srv, err := server.NewServer(*listenAddr, key, *verbose)
+	srv, err := server.NewServer(server.Config{
+		ListenAddr:         *listenAddr,
+		Key:                key,
+		DNSServers:         reloadable.DNSServers,
+		DNSForward:         splitList(*dnsForward),
+		DNSCache:           *dnsCache,
+		PushRoutes:         reloadable.PushRoutes,
+		PushMTU:            reloadable.PushMTU,
+		IdleTimeout:        reloadable.IdleTimeout,
+		MaxClients:         reloadable.MaxClients,
+		DefaultLimit:       reloadable.DefaultLimit,
+		PeerLimits:         reloadable.PeerLimits,
+		TUNQueues:          *tunQueues,
+		TUNOffload:         *tunOffload,
+		TUNFD:              *tunFD,
+		Netstack:           *netstackOn,
+		IOURing:            *ioEngine == "uring",
+		ListenShards:       *shards,
+		QueueSize:          *queueSize,
+		QueuePolicy:        policy,
+		MSSClamp:           mss,
+		Firewall:           *firewall,
+		StateFile:          *stateFile,
+		Shaping:            *shaping,
+		Compression:        codec,
+		DisableCompression: !compressionOn,
+		CryptoWorkers:      *workers,
+		Obfuscation:        transport.Obfuscation{PadBucket: *obfsPad, CoverInterval: *obfsCover},
+		DSCP:               outerDSCP,
+		P2P:                *p2p,
+		Mesh:               *mesh,
+		PortHop:            hopPorts,
+		TCPListen:          *tcpListen,
+		TCPTLS:             *tcpTLS,
+		WSSListen:          *wssListen,
+		WSSCert:            *wssCert,
+		WSSKey:             *wssKey,
+		ClientCA:           *clientCA,
+		WSSPath:            *wssPath,
+		Fallback:           *fallback,
+		KCPListen:          *kcpListen,
+		GRPCListen:         *grpcListen,
+		GRPCService:        *grpcService,
+		DNSTunnelListen:    *dnsTunnel,
+		DNSTunnelZone:      *dnsZone,
+		KCPFEC:             fec,
+		MinProtocolVersion: uint8(*minVersion),
+		CookieThreshold:    *cookieLoad,
+		HandshakeRate:      *handshakes,
+		PrivateKey:         staticKey,
+		Peers:              reloadable.Peers,
+		Reload:             reload,
+		TOTPSecrets:        totpSecrets,
+		TOTPFile:           *totpFile,
+		Auth:               authBackend,
+		FlowExport:         flows,
+		PeerDB:             peerDB,
+	})
 	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+		logging.Fatal("Failed to create server", logging.Err(err))
 	}
 
 	// Запускаем сервер
 	if err := srv.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		logging.Fatal("Failed to start server", logging.Err(err))
+	}
+	if *pcapFile != "" {
+		if err := srv.StartCapture(*pcapFile, int64(*pcapLimit)<<20, *pcapOuter); err != nil {
+			srv.Stop()
+			logging.Fatal("Failed to start capture", logging.Err(err))
+		}
 	}
 
 	// Запускаем pprof сервер если указан адрес
 	if *pprofAddr != "" {
 		go func() {
-			log.Printf("Starting pprof server on %s", *pprofAddr)
-			log.Println(http.ListenAndServe(*pprofAddr, nil))
+			slog.Info("Starting pprof server", "addr", *pprofAddr)
+			slog.Error("pprof server failed", logging.Err(http.ListenAndServe(*pprofAddr, nil)))
+		}()
+	}
+
+	// Запускаем admin API если указан адрес
+	if *apiAddr != "" {
+		go func() {
+			slog.Info("Starting admin API", "addr", *apiAddr)
+			if err := http.ListenAndServe(*apiAddr, srv.APIHandler(*apiToken)); err != nil {
+				slog.Error("Admin API failed", logging.Err(err))
+			}
+		}()
+	}
+
+	// Запускаем управляющий сокет для vpnctl если указан путь
+	if *control != "" {
+		defer os.Remove(*control)
+		go func() {
+			if err := srv.ServeControl(*control); err != nil {
+				slog.Error("Control socket failed", logging.Err(err))
+			}
+		}()
+	}
+
+	// Запускаем gRPC API если указан адрес
+	if *grpcAddr != "" {
+		grpcServer := srv.GRPCServer(*apiToken)
+		defer grpcServer.Stop()
+		go func() {
+			slog.Info("Starting admin gRPC API", "addr", *grpcAddr)
+			listener, err := net.Listen("tcp", *grpcAddr)
+			if err != nil {
+				slog.Error("gRPC API failed", logging.Err(err))
+				return
+			}
+			if err := grpcServer.Serve(listener); err != nil {
+				slog.Error("gRPC API failed", logging.Err(err))
+			}
 		}()
 	}
 
 	// Запускаем метрики сервер если указан адрес
 	if *metricsAddr != "" {
-		go startMetricsServer(*metricsAddr)
+		go startMetricsServer(*metricsAddr, srv)
+	}
+
+	// Запускаем проверки состояния для балансировщиков если указан адрес
+	if *healthAddr != "" {
+		go func() {
+			slog.Info("Starting health check server", "addr", *healthAddr)
+			if err := http.ListenAndServe(*healthAddr, srv.HealthHandler()); err != nil {
+				slog.Error("Health check server failed", logging.Err(err))
+			}
+		}()
 	}
 
 	// Обрабатываем сигналы для корректного завершения
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// SIGHUP перечитывает конфигурацию без разрыва сессий
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
 
-	log.Println("VPN server started. Press Ctrl+C to stop.")
-	<-sigChan
+	// Под systemd (Type=notify) сообщаем о готовности и пингуем watchdog, пока путь данных жив
+	if err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		slog.Warn("systemd notification failed", logging.Err(err))
+	}
+	daemon.Ready()
+	stopWatchdog := make(chan struct{})
+	go sdnotify.RunWatchdog(stopWatchdog, func() error { return srv.Liveness().Err() })
+
+	slog.Info("VPN server started. Press Ctrl+C to stop.")
+	for stop := false; !stop; {
+		select {
+		case <-reloads:
+			sdnotify.Notify(sdnotify.Reloading)
+			if _, err := srv.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", logging.Err(err))
+			}
+			sdnotify.Notify(sdnotify.Ready)
+		case <-sigChan:
+			stop = true
+		}
+	}
 
-	log.Println("Shutting down server...")
-	if err := srv.Stop(); err != nil {
-		log.Printf("Error stopping server: %v", err)
+	slog.Info("Shutting down server...")
+	close(stopWatchdog)
+	sdnotify.Notify(sdnotify.Stopping)
+	// Остановка ограничена -shutdown-timeout, повторный сигнал завершает процесс сразу
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Stop() }()
+	var deadline <-chan time.Time
+	if *stopTimeout > 0 {
+		deadline = time.After(*stopTimeout)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			slog.Error("Failed to stop server", logging.Err(err))
+		}
+	case <-deadline:
+		logging.Fatal("Server did not stop in time", "timeout", *stopTimeout)
+	case <-sigChan:
+		logging.Fatal("Forced shutdown")
 	}
 
-	log.Println("Server stopped.")
+	slog.Info("Server stopped.")
 }
 
-// loadOrGenerateKey загружает ключ из файла или генерирует новый
-func loadOrGenerateKey(keyFile string) ([]byte, error) {
-	const keySize = 32 // 32 байта для ChaCha20-Poly1305
+// reloadableFlags флаги, которые перечитываются из -config без перезапуска
+var reloadableFlags = []string{
+	"dns", "push-routes", "push-mtu", "idle-timeout", "max-clients",
+	"rate-up", "rate-down", "peer-limits", "peers", "log-level", "verbose",
+}
 
+// loadOrGenerateKey загружает ключ из файла или из keyData, иначе генерирует новый
+func loadOrGenerateKey(keyFile, keyData string) ([]byte, error) {
+	if keyFile != "" && keyData != "" {
+		return nil, fmt.Errorf("-key and -key-data cannot be used together")
+	}
+	if keyData != "" {
+		return internal.ParseSharedKey([]byte(keyData))
+	}
 	if keyFile != "" {
 		// Загружаем ключ из файла
 		key, err := os.ReadFile(keyFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read key file: %w", err)
 		}
-
-		if len(key) != keySize {
-			return nil, fmt.Errorf("invalid key size: expected %d bytes, got %d", keySize, len(key))
-		}
-
-		return key, nil
+		return internal.ParseSharedKey(key)
 	}
 
 	// Генерируем случайный ключ
-	key := make([]byte, keySize)
+	key := make([]byte, internal.KeySize)
 	if _, err := rand.Read(key); err != nil {
 		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
 
-	log.Println("Generated random encryption key. Save it for client configuration!")
-	log.Printf("Key (hex): %x\n", key)
+	slog.Warn("Generated random encryption key, it changes on every start. Create a persistent one with 'server genkey -out key.bin' and pass it with -key", "key", hex.EncodeToString(key))
 
 	return key, nil
 }
 
-// startMetricsServer запускает HTTP сервер для метрик
-func startMetricsServer(addr string) {
-	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain")
-		fmt.Fprintf(w, "# VPN Server Metrics\n")
-		fmt.Fprintf(w, "# Time: %s\n\n", time.Now().Format(time.RFC3339))
-		// Здесь можно добавить экспорт метрик в формате Prometheus или просто текстовый формат
-		fmt.Fprintf(w, "metrics_endpoint_active 1\n")
-	})
+// loadPrivateKey загружает закрытый ключ X25519 сервера из файла
+func loadPrivateKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+	return internal.ParseKey(string(data))
+}
+
+// parseIPList разбирает список IP адресов через запятую
+func parseIPList(value string) ([]string, error) {
+	var ips []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if net.ParseIP(item) == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", item)
+		}
+		ips = append(ips, item)
+	}
+	return ips, nil
+}
+
+// splitList разбивает список через запятую, пропуская пустые элементы
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePeerLimits разбирает список лимитов вида name=up/down через запятую.
+// Пропущенное направление (например, "alice=/10mbit") не ограничивается
+func parsePeerLimits(value string) (map[string]server.RateLimit, error) {
+	limits := make(map[string]server.RateLimit)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, rates, ok := strings.Cut(item, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected name=up/down, got %q", item)
+		}
+		up, down, _ := strings.Cut(rates, "/")
+
+		var limit server.RateLimit
+		var err error
+		if limit.Up, err = ratelimit.ParseRate(up); err != nil {
+			return nil, err
+		}
+		if limit.Down, err = ratelimit.ParseRate(down); err != nil {
+			return nil, err
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// startMetricsServer запускает HTTP сервер для метрик в формате Prometheus, сводки /stats.json
+// и проверок состояния
+func startMetricsServer(addr string, srv *server.Server) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	health := srv.HealthHandler()
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+	mux.Handle("/stats.json", srv.StatsHandler())
 
-	log.Printf("Starting metrics server on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Printf("Metrics server error: %v", err)
+	slog.Info("Starting metrics server", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Metrics server failed", logging.Err(err))
 	}
 }
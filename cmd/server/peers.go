@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"myvpn/internal"
+	"myvpn/internal/cli"
+	"myvpn/internal/peerdb"
+	"myvpn/server"
+)
+
+const peersUsage = `Usage: server peers -peer-db FILE COMMAND [ARGS]
+
+Commands:
+  list                   List peers and their status
+  add NAME [flags]       Add a peer (prints the generated shared key; with -endpoint
+                         generates X25519 keys, an address and a client config)
+  disable NAME           Stop accepting the peer's key until it is enabled again
+  enable NAME            Accept the key of a disabled peer again
+  revoke NAME            Revoke the peer permanently (its name and key cannot be reused)
+
+The database is locked while the server runs: use the admin API then.`
+
+// peersCommand команда peers: управление базой пиров без запущенного сервера
+func peersCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "peers",
+		Args:    "-peer-db FILE COMMAND",
+		Summary: "Manage the peer database while the server is stopped",
+		Run: func(args []string) error {
+			fs := cli.FlagSet("server", "peers")
+			dbPath := fs.String("peer-db", "", "Path to the peer database file (-peer-db of the server)")
+			fs.Parse(args)
+			return runPeers(*dbPath, fs.Args())
+		},
+	}
+}
+
+// runPeers выполняет команду управления базой пиров
+func runPeers(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(peersUsage)
+	}
+	if dbPath == "" {
+		return errors.New("peers commands require -peer-db")
+	}
+	db, err := peerdb.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		return listPeers(db)
+	case "add":
+		return addPeer(db, args)
+	case "disable", "enable", "revoke":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: peers %s NAME", cmd)
+		}
+		status := map[string]string{
+			"disable": peerdb.StatusDisabled,
+			"enable":  peerdb.StatusActive,
+			"revoke":  peerdb.StatusRevoked,
+		}[cmd]
+		p, err := db.SetStatus(args[0], status)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Peer %q is now %s\n", p.Name, p.Status)
+		return nil
+	default:
+		return fmt.Errorf("unknown peers command %q\n\n%s", cmd, peersUsage)
+	}
+}
+
+// listPeers выводит таблицу пиров
+func listPeers(db *peerdb.DB) error {
+	peers, err := db.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tKEY\tALLOWED IPS\tCREATED")
+	for _, p := range peers {
+		keyType := "shared"
+		if p.PublicKey != "" {
+			keyType = "public " + p.PublicKey
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Name, p.Status, keyType,
+			strings.Join(p.AllowedIPs, ","), p.Created.Local().Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+// addPeer добавляет пира с общим ключом (сгенерированным или заданным) или с открытым ключом
+func addPeer(db *peerdb.DB, args []string) error {
+	fs := flag.NewFlagSet("peers add", flag.ContinueOnError)
+	key := fs.String("key", "", "Shared key in hex (generated if neither -key nor -public-key is set)")
+	publicKey := fs.String("public-key", "", "Peer X25519 public key (base64 or hex) instead of a shared key")
+	allowedIPs := fs.String("allowed-ips", "", "Comma-separated CIDRs the public-key peer may use inside the VPN")
+	certificate := fs.String("certificate", "", "Client certificate name of the peer for TLS transports (default: peer name)")
+	var prov provisioning
+	fs.StringVar(&prov.endpoint, "endpoint", "", "Server address (host:port) for a ready client config: the peer gets a generated X25519 key pair and a free VPN address")
+	fs.StringVar(&prov.serverKey, "private-key", "", "Path to the server -private-key file, required with -endpoint")
+	dns := fs.String("dns", "", "Comma-separated DNS servers for the client config (-endpoint)")
+	fs.StringVar(&prov.out, "out", "", "Client config file to write with -endpoint (default: NAME.json)")
+	fs.BoolVar(&prov.qr, "qr", false, "Also print the client config as a QR code (-endpoint)")
+	fs.StringVar(&prov.png, "png", "", "Also write the client config as a QR code PNG image (-endpoint)")
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("usage: peers add NAME [-key HEX | -public-key KEY -allowed-ips CIDRS | -endpoint HOST:PORT -private-key FILE] [-certificate NAME]")
+	}
+	name := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if name == server.DefaultPeer {
+		return fmt.Errorf("peer name %q is reserved for the -key peer", name)
+	}
+
+	p := peerdb.Peer{Name: name, Certificate: *certificate}
+	if prov.endpoint != "" {
+		if *key != "" || *publicKey != "" {
+			return errors.New("-endpoint generates the peer's keys: -key and -public-key cannot be used with it")
+		}
+		p.AllowedIPs = splitList(*allowedIPs)
+		prov.dns = splitList(*dns)
+		return provisionPeer(db, p, prov)
+	}
+	var generated bool
+	switch {
+	case *publicKey != "":
+		if *key != "" {
+			return errors.New("-key and -public-key are mutually exclusive")
+		}
+		public, err := internal.ParseKey(*publicKey)
+		if err != nil {
+			return fmt.Errorf("invalid -public-key: %w", err)
+		}
+		p.PublicKey = internal.FormatKey(public)
+		p.AllowedIPs = splitList(*allowedIPs)
+		for _, cidr := range p.AllowedIPs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid allowed IP %q: %w", cidr, err)
+			}
+		}
+	case *key != "":
+		raw, err := hex.DecodeString(*key)
+		if err != nil || len(raw) != internal.KeySize {
+			return fmt.Errorf("-key must be %d hex characters", internal.KeySize*2)
+		}
+		p.Key = hex.EncodeToString(raw)
+	default:
+		raw := make([]byte, internal.KeySize)
+		if _, err := rand.Read(raw); err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		p.Key = hex.EncodeToString(raw)
+		generated = true
+	}
+	if _, err := db.Create(p); err != nil {
+		return err
+	}
+	fmt.Printf("Peer %q added\n", name)
+	if generated {
+		fmt.Printf("Key (hex): %s\n", p.Key)
+	}
+	return nil
+}
+
+// provisioning параметры peers add -endpoint
+type provisioning struct {
+	endpoint  string
+	serverKey string // файл закрытого ключа сервера
+	dns       []string
+	out       string
+	qr        bool
+	png       string
+}
+
+// provisionPeer добавляет пира одним шагом: создает пару ключей X25519, выбирает свободный
+// адрес VPN (если не заданы AllowedIPs) и записывает готовую конфигурацию клиента.
+// Закрытый ключ пира есть только в этой конфигурации, поэтому она записывается до пира
+func provisionPeer(db *peerdb.DB, p peerdb.Peer, prov provisioning) error {
+	if prov.serverKey == "" {
+		return errors.New("-endpoint requires -private-key: the client config needs the server public key")
+	}
+	if prov.out == "" {
+		prov.out = p.Name + ".json"
+	}
+	serverPrivate, err := loadPrivateKey(prov.serverKey)
+	if err != nil {
+		return err
+	}
+	serverPublic, err := internal.PublicKey(serverPrivate)
+	if err != nil {
+		return err
+	}
+	private, err := internal.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	public, err := internal.PublicKey(private)
+	if err != nil {
+		return err
+	}
+	p.PublicKey = internal.FormatKey(public)
+
+	for _, cidr := range p.AllowedIPs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid allowed IP %q: %w", cidr, err)
+		}
+	}
+	if len(p.AllowedIPs) == 0 {
+		peers, err := db.List()
+		if err != nil {
+			return err
+		}
+		used := make(map[string]bool)
+		for _, peer := range peers {
+			if peer.Status == peerdb.StatusRevoked {
+				continue
+			}
+			for _, cidr := range peer.AllowedIPs {
+				if ip, network, err := net.ParseCIDR(cidr); err == nil {
+					if ones, bits := network.Mask.Size(); ones == bits {
+						used[ip.String()] = true
+					}
+				}
+			}
+		}
+		ip, ip6, err := server.FreePeerAddress(used)
+		if err != nil {
+			return err
+		}
+		p.AllowedIPs = []string{ip.String() + "/32", ip6.String() + "/128"}
+	}
+
+	// Адрес клиенту назначает сервер из AllowedIPs, поэтому ip в конфигурации не нужен
+	cfg, err := newClientConfig(prov.endpoint, "auto", prov.dns)
+	if err != nil {
+		return err
+	}
+	cfg.PrivateKeyData = internal.FormatKey(private)
+	cfg.ServerPublicKey = internal.FormatKey(serverPublic)
+	if err := writeClientConfig(cfg, prov.out, prov.png, prov.qr, false); err != nil {
+		return err
+	}
+	if _, err := db.Create(p); err != nil {
+		os.Remove(prov.out)
+		if prov.png != "" {
+			os.Remove(prov.png)
+		}
+		return err
+	}
+	fmt.Printf("Peer %q added with address %s\n", p.Name, strings.Join(p.AllowedIPs, ", "))
+	fmt.Printf("Client config written to %s (start the client with -config %s)\n", prov.out, prov.out)
+	return nil
+}
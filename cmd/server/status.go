@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"myvpn/adminrpc"
+	"myvpn/internal/cli"
+	"myvpn/internal/ctlsock"
+)
+
+// statusCommand команда status: состояние работающего сервера через управляющий сокет
+func statusCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "status",
+		Args:    "[-json]",
+		Summary: "Status of the running server over its control socket",
+		Run: func(args []string) error {
+			fs := cli.FlagSet("server", "status")
+			socket := fs.String("control-socket", adminrpc.DefaultControlSocket, "Path to the control socket of the running server")
+			asJSON := fs.Bool("json", false, "Print status as JSON")
+			fs.Parse(args)
+
+			var st adminrpc.Status
+			if err := ctlsock.New(*socket, "server").Do(http.MethodGet, "/api/v1/status", nil, &st); err != nil {
+				return err
+			}
+			if *asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(st)
+			}
+			fmt.Printf("Listen:    %s\n", st.ListenAddr)
+			fmt.Printf("Interface: %s\n", st.TUNInterface)
+			fmt.Printf("Uptime:    %s\n", (time.Duration(st.UptimeSeconds) * time.Second).String())
+			fmt.Printf("Clients:   %d\n", st.Clients)
+			fmt.Printf("Peers:     %d\n", st.Peers)
+			return nil
+		},
+	}
+}
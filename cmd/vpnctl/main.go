@@ -0,0 +1,346 @@
+// vpnctl управляет работающим сервером через его управляющий Unix сокет (-control-socket).
+// Команды повторяют admin REST API
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"myvpn/adminrpc"
+	"myvpn/internal/ctlsock"
+)
+
+const usage = `Usage: vpnctl [-socket PATH] COMMAND [ARGS]
+
+Commands:
+  status                 Server status
+  clients                Connected clients
+  kick ADDR [flags]      Disconnect a client by remote address, virtual IP or session ID
+                         (-ban DURATION also bans its peer key, -reason TEXT is shown to the client)
+  bans                   Active peer bans
+  unban PEER             Lift a peer ban
+  peers list             List peers
+  peers add NAME [KEY]   Add a shared-key peer (KEY in hex, generated if omitted)
+  peers show NAME        Peer status
+  peers disable NAME     Temporarily stop accepting the peer's key
+  peers enable NAME      Accept the key of a disabled peer again
+  peers revoke NAME      Revoke the peer's key and close its sessions
+  reload [-dns LIST]     Reload server configuration like SIGHUP, or only replace
+                         DNS servers pushed to clients with -dns
+  capture start FILE     Capture tunnel traffic on the server to a pcap file
+                         (-limit MB stops it at this size, -outer adds encrypted datagrams)
+  capture stop           Stop the capture
+  capture status         Capture progress
+`
+
+func main() {
+	socket := flag.String("socket", adminrpc.DefaultControlSocket, "Path to the server control socket (-control-socket of the server)")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := ctlsock.New(*socket, "server")
+	if err := run(c, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "vpnctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run выполняет команду
+func run(c *ctlsock.Client, args []string) error {
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "status":
+		var st adminrpc.Status
+		if err := c.Do(http.MethodGet, "/api/v1/status", nil, &st); err != nil {
+			return err
+		}
+		fmt.Printf("Listen:    %s\n", st.ListenAddr)
+		fmt.Printf("Interface: %s\n", st.TUNInterface)
+		fmt.Printf("Uptime:    %s\n", (time.Duration(st.UptimeSeconds) * time.Second).String())
+		fmt.Printf("Clients:   %d\n", st.Clients)
+		fmt.Printf("Peers:     %d\n", st.Peers)
+		if st.Clients == 0 {
+			return nil
+		}
+		fmt.Println()
+		return printClients(c)
+	case "clients":
+		return printClients(c)
+	case "kick":
+		fs := flag.NewFlagSet("kick", flag.ContinueOnError)
+		ban := fs.Duration("ban", 0, "Do not accept new sessions with the client's peer key for this long (e.g., 10m)")
+		reason := fs.String("reason", "", "Reason shown to the client")
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			return fmt.Errorf("usage: vpnctl kick ADDR [-ban DURATION] [-reason TEXT]")
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return kick(c, args[0], *reason, *ban)
+	case "bans":
+		var bans []adminrpc.Ban
+		if err := c.Do(http.MethodGet, "/api/v1/bans", nil, &bans); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PEER\tUNTIL\tREMAINING")
+		for _, b := range bans {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", b.Peer, b.Until.Local().Format(time.DateTime), time.Until(b.Until).Round(time.Second))
+		}
+		return w.Flush()
+	case "unban":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: vpnctl unban PEER")
+		}
+		if err := c.Do(http.MethodDelete, "/api/v1/bans/"+url.PathEscape(args[0]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Ban of peer %q lifted\n", args[0])
+		return nil
+	case "peers":
+		return peers(c, args)
+	case "capture":
+		return capture(c, args)
+	case "reload":
+		fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+		dns := fs.String("dns", "", "Comma-separated DNS servers pushed to clients (empty to stop pushing DNS)")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		dnsSet := false
+		fs.Visit(func(f *flag.Flag) { dnsSet = dnsSet || f.Name == "dns" })
+		if dnsSet {
+			// Пустой, но не nil список: сервер перестает передавать DNS
+			req := adminrpc.ReloadConfigRequest{DNS: append([]string{}, splitList(*dns)...)}
+			if err := c.Do(http.MethodPost, "/api/v1/reload", req, nil); err != nil {
+				return err
+			}
+			fmt.Println("DNS servers updated")
+			return nil
+		}
+		var resp adminrpc.ReloadConfigResponse
+		if err := c.Do(http.MethodPost, "/api/v1/reload", nil, &resp); err != nil {
+			return err
+		}
+		if len(resp.Changes) == 0 {
+			fmt.Println("Configuration reloaded, nothing changed")
+			return nil
+		}
+		fmt.Println("Configuration reloaded:")
+		for _, change := range resp.Changes {
+			fmt.Println("  " + change)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", cmd, usage)
+	}
+}
+
+// printClients выводит таблицу клиентов со статистикой трафика
+func printClients(c *ctlsock.Client) error {
+	clients, err := clients(c)
+	if err != nil {
+		return err
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].VirtualIP < clients[j].VirtualIP })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tPEER\tREMOTE\tVIRTUAL IP\tRX\tTX\tRTT\tJITTER\tLOSS\tDECRYPT ERRORS\tHANDSHAKE\tLAST SEEN")
+	for _, cl := range clients {
+		rtt, jitter, loss := "-", "-", "-"
+		if cl.RTTMs > 0 {
+			rtt, jitter = fmt.Sprintf("%.1fms", cl.RTTMs), fmt.Sprintf("%.1fms", cl.JitterMs)
+			loss = fmt.Sprintf("%.1f%%", 100*cl.Loss)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s (%d pkts)\t%s (%d pkts)\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			cl.SessionID, cl.Peer, cl.RemoteAddr, cl.VirtualIP,
+			ctlsock.FormatBytes(cl.RxBytes), cl.RxPackets, ctlsock.FormatBytes(cl.TxBytes), cl.TxPackets,
+			rtt, jitter, loss, cl.DecryptErrors, ago(cl.LastHandshake), ago(cl.LastSeen))
+	}
+	return w.Flush()
+}
+
+// ago выводит, сколько времени прошло с t
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+// kick разрывает сессию клиента, найденную по адресу, виртуальному IP или ID сессии
+func kick(c *ctlsock.Client, target, reason string, ban time.Duration) error {
+	clients, err := clients(c)
+	if err != nil {
+		return err
+	}
+	var matched []adminrpc.ClientInfo
+	for _, cl := range clients {
+		host, _, _ := net.SplitHostPort(cl.RemoteAddr)
+		if target == cl.SessionID || target == cl.RemoteAddr || target == host || target == cl.VirtualIP {
+			matched = append(matched, cl)
+		}
+	}
+	if len(matched) == 0 {
+		return fmt.Errorf("no client matches %q", target)
+	}
+	query := url.Values{}
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+	if ban > 0 {
+		query.Set("ban", ban.String())
+	}
+	for _, cl := range matched {
+		path := "/api/v1/clients/" + cl.SessionID
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+		if err := c.Do(http.MethodDelete, path, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Client %s (session %s) disconnected\n", cl.RemoteAddr, cl.SessionID)
+		if ban > 0 {
+			fmt.Printf("Peer %q banned for %v\n", cl.Peer, ban)
+		}
+	}
+	return nil
+}
+
+// peers выполняет команды управления пирами
+func peers(c *ctlsock.Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: vpnctl peers list|add|show|disable|enable|revoke")
+	}
+	cmd, args := args[0], args[1:]
+	if cmd == "list" {
+		var names []string
+		if err := c.Do(http.MethodGet, "/api/v1/peers", nil, &names); err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	if len(args) == 0 || (cmd != "add" && len(args) != 1) || len(args) > 2 {
+		return fmt.Errorf("usage: vpnctl peers %s NAME", cmd)
+	}
+	name := args[0]
+	path := "/api/v1/peers/" + url.PathEscape(name)
+	switch cmd {
+	case "add":
+		req := adminrpc.AddPeerRequest{Name: name}
+		if len(args) == 2 {
+			req.Key = args[1]
+		}
+		var resp adminrpc.AddPeerResponse
+		if err := c.Do(http.MethodPost, "/api/v1/peers", req, &resp); err != nil {
+			return err
+		}
+		fmt.Printf("Peer %q added\nKey (hex): %s\n", resp.Name, resp.Key)
+	case "show":
+		var p adminrpc.PeerRecord
+		if err := c.Do(http.MethodGet, path, nil, &p); err != nil {
+			return err
+		}
+		fmt.Printf("Name:   %s\nStatus: %s\n", p.Name, p.Status)
+		if p.PublicKey != "" {
+			fmt.Printf("Public key:  %s\nAllowed IPs: %s\n", p.PublicKey, strings.Join(p.AllowedIPs, ", "))
+		}
+		if !p.Created.IsZero() {
+			fmt.Printf("Created: %s\nUpdated: %s\n", p.Created.Local().Format(time.DateTime), p.Updated.Local().Format(time.DateTime))
+		}
+	case "disable", "enable":
+		if err := c.Do(http.MethodPost, path+"/"+cmd, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Peer %q %sd\n", name, cmd)
+	case "revoke":
+		if err := c.Do(http.MethodDelete, path, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Peer %q revoked\n", name)
+	default:
+		return fmt.Errorf("unknown peers command %q", cmd)
+	}
+	return nil
+}
+
+// capture управляет записью трафика на сервере
+func capture(c *ctlsock.Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: vpnctl capture start|stop|status")
+	}
+	cmd, args := args[0], args[1:]
+	var st adminrpc.CaptureStatus
+	switch cmd {
+	case "start":
+		fs := flag.NewFlagSet("capture start", flag.ContinueOnError)
+		limit := fs.Int("limit", 100, "Stop the capture when the file exceeds this many megabytes (0 for unlimited)")
+		outer := fs.Bool("outer", false, "Also capture encrypted outer UDP datagrams")
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			return fmt.Errorf("usage: vpnctl capture start FILE [-limit MB] [-outer]")
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		req := adminrpc.StartCaptureRequest{Path: args[0], LimitBytes: int64(*limit) << 20, Outer: *outer}
+		if err := c.Do(http.MethodPost, "/api/v1/capture", req, &st); err != nil {
+			return err
+		}
+		fmt.Printf("Capturing to %s on the server\n", st.Path)
+		return nil
+	case "stop":
+		if err := c.Do(http.MethodDelete, "/api/v1/capture", nil, &st); err != nil {
+			return err
+		}
+		fmt.Println("Capture stopped")
+	case "status":
+		if err := c.Do(http.MethodGet, "/api/v1/capture", nil, &st); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown capture command %q", cmd)
+	}
+	fmt.Printf("File:    %s\n", st.Path)
+	fmt.Printf("Packets: %d (%s)\n", st.Packets, ctlsock.FormatBytes(uint64(st.Bytes)))
+	fmt.Printf("Started: %s\n", st.Started.Local().Format(time.DateTime))
+	if st.Full {
+		fmt.Println("Size limit reached, no more packets are written")
+	}
+	return nil
+}
+
+// clients возвращает подключенных клиентов
+func clients(c *ctlsock.Client) ([]adminrpc.ClientInfo, error) {
+	var clients []adminrpc.ClientInfo
+	return clients, c.Do(http.MethodGet, "/api/v1/clients", nil, &clients)
+}
+
+// splitList разбивает список через запятую, пропуская пустые элементы
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,86 @@
+// Package auth проверяет подключения клиентов во внешних системах учетных записей
+// (RADIUS, LDAP) и отправляет им записи учета сессий
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// DefaultTimeout время на ответ backend
+const DefaultTimeout = 5 * time.Second
+
+// ErrDenied backend отказал в доступе (неверные учетные данные).
+// Остальные ошибки означают, что backend недоступен или ответил не по протоколу
+var ErrDenied = errors.New("access denied")
+
+// Request попытка подключения, которую проверяет backend
+type Request struct {
+	Username   string
+	Password   string
+	Peer       string // пир, ключом которого зашифрован запрос
+	SessionID  uint64
+	RemoteAddr string // IP:port клиента
+}
+
+// Backend внешняя проверка подключений
+type Backend interface {
+	// Authenticate возвращает nil, если подключение разрешено, и ErrDenied при отказе
+	Authenticate(ctx context.Context, req Request) error
+}
+
+// Типы записей учета
+const (
+	AccountingStart = "start"
+	AccountingStop  = "stop"
+)
+
+// Record запись учета сессии. Rx - от клиента к серверу, Tx - от сервера к клиенту
+type Record struct {
+	Type       string
+	Username   string
+	SessionID  uint64
+	RemoteAddr string
+	VirtualIP  string
+	RxPackets  uint64
+	RxBytes    uint64
+	TxPackets  uint64
+	TxBytes    uint64
+	Duration   time.Duration
+}
+
+// Accounter backend, который принимает записи учета (начало и конец сессий)
+type Accounter interface {
+	Account(ctx context.Context, rec Record) error
+}
+
+// New создает backend по URL:
+//
+//	radius://SECRET@host:1812?acct_port=1813&nas_id=vpn1
+//	ldap://host:389?dn=uid={user},ou=people,dc=example,dc=com&starttls=true
+//	ldaps://host:636?dn=uid={user},ou=people,dc=example,dc=com
+func New(spec string) (Backend, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth backend URL: %w", err)
+	}
+	switch u.Scheme {
+	case "radius":
+		return newRADIUS(u)
+	case "ldap", "ldaps":
+		return newLDAP(u)
+	default:
+		return nil, fmt.Errorf("unknown auth backend %q (expected radius, ldap or ldaps)", u.Scheme)
+	}
+}
+
+// deadline возвращает срок операции: срок ctx или timeout от текущего момента
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	if d, ok := ctx.Deadline(); ok {
+		return d
+	}
+	return time.Now().Add(timeout)
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Коды BER и LDAP (RFC 4511), которые нужны для простой привязки
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+
+	ldapBindRequest      = 0x60 // [APPLICATION 0] SEQUENCE
+	ldapBindResponse     = 0x61 // [APPLICATION 1] SEQUENCE
+	ldapUnbindRequest    = 0x42 // [APPLICATION 2] NULL
+	ldapExtendedRequest  = 0x77 // [APPLICATION 23] SEQUENCE
+	ldapExtendedResponse = 0x78 // [APPLICATION 24] SEQUENCE
+	ldapSimpleAuth       = 0x80 // [0] OCTET STRING
+	ldapRequestName      = 0x80 // [0] LDAPOID
+
+	ldapVersion            = 3
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+	// ldapStartTLSOID расширенная операция StartTLS (RFC 4511, раздел 4.14)
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+	// ldapMaxMessage ограничение размера ответа сервера
+	ldapMaxMessage = 1 << 16
+)
+
+// ldapBackend проверяет пароли простой привязкой (simple bind) к каталогу LDAP
+// под DN пользователя
+type ldapBackend struct {
+	addr     string
+	host     string
+	dn       string // шаблон DN, {user} заменяется экранированным именем пользователя
+	ldaps    bool
+	startTLS bool
+	timeout  time.Duration
+}
+
+// newLDAP разбирает ldap://host:389?dn=...&starttls=true или ldaps://host:636?dn=...
+func newLDAP(u *url.URL) (*ldapBackend, error) {
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		return nil, errors.New("LDAP backend requires a server address")
+	}
+	ldaps := u.Scheme == "ldaps"
+	if port == "" {
+		port = "389"
+		if ldaps {
+			port = "636"
+		}
+	}
+	dn := u.Query().Get("dn")
+	if !strings.Contains(dn, "{user}") {
+		return nil, errors.New("LDAP backend requires a dn template with {user} (e.g., dn=uid={user},ou=people,dc=example,dc=com)")
+	}
+	var startTLS bool
+	if v := u.Query().Get("starttls"); v != "" {
+		var err error
+		if startTLS, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid starttls value %q", v)
+		}
+	}
+	return &ldapBackend{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		dn:       dn,
+		ldaps:    ldaps,
+		startTLS: startTLS,
+		timeout:  DefaultTimeout,
+	}, nil
+}
+
+// Authenticate выполняет простую привязку под DN пользователя с его паролем
+func (l *ldapBackend) Authenticate(ctx context.Context, req Request) error {
+	// Привязка с пустым паролем - анонимная (RFC 4513, раздел 5.1.2) и всегда успешна
+	if req.Username == "" || req.Password == "" {
+		return ErrDenied
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", l.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to LDAP server %s: %w", l.addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline(ctx, l.timeout))
+
+	if l.ldaps {
+		conn, err = l.handshake(conn)
+		if err != nil {
+			return err
+		}
+	}
+	r := bufio.NewReader(conn)
+	if l.startTLS {
+		msg := ldapMessage(1, berTLV(ldapExtendedRequest, berTLV(ldapRequestName, []byte(ldapStartTLSOID))))
+		if _, err := conn.Write(msg); err != nil {
+			return fmt.Errorf("LDAP StartTLS failed: %w", err)
+		}
+		code, diag, err := readLDAPResult(r, 1, ldapExtendedResponse)
+		if err != nil {
+			return fmt.Errorf("LDAP StartTLS failed: %w", err)
+		}
+		if code != ldapSuccess {
+			return fmt.Errorf("LDAP StartTLS failed: result %d: %s", code, diag)
+		}
+		if conn, err = l.handshake(conn); err != nil {
+			return err
+		}
+		r = bufio.NewReader(conn)
+	}
+
+	dn := strings.ReplaceAll(l.dn, "{user}", escapeDN(req.Username))
+	bind := berTLV(ldapBindRequest, concat(
+		berInt(ldapVersion),
+		berTLV(berOctetString, []byte(dn)),
+		berTLV(ldapSimpleAuth, []byte(req.Password)),
+	))
+	if _, err := conn.Write(ldapMessage(2, bind)); err != nil {
+		return fmt.Errorf("LDAP bind failed: %w", err)
+	}
+	code, diag, err := readLDAPResult(r, 2, ldapBindResponse)
+	if err != nil {
+		return fmt.Errorf("LDAP bind failed: %w", err)
+	}
+	conn.Write(ldapMessage(3, []byte{ldapUnbindRequest, 0}))
+
+	switch code {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return ErrDenied
+	default:
+		return fmt.Errorf("LDAP bind failed: result %d: %s", code, diag)
+	}
+}
+
+// handshake переводит соединение на TLS с проверкой сертификата сервера
+func (l *ldapBackend) handshake(conn net.Conn) (net.Conn, error) {
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: l.host})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("LDAP TLS handshake failed: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// escapeDN экранирует значение атрибута DN (RFC 4514), чтобы имя пользователя
+// не могло изменить структуру DN
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`\,+"<>;=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(value)-1):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ldapMessage заворачивает операцию в LDAPMessage с номером id
+func ldapMessage(id int, op []byte) []byte {
+	return berTLV(berSequence, concat(berInt(id), op))
+}
+
+// readLDAPResult читает ответ на операцию id и возвращает resultCode и diagnosticMessage
+func readLDAPResult(r *bufio.Reader, id int, op byte) (int, string, error) {
+	tag, msg, err := readTLV(r)
+	if err != nil {
+		return 0, "", err
+	}
+	if tag != berSequence {
+		return 0, "", errors.New("malformed LDAP message")
+	}
+	tag, value, msg, err := splitTLV(msg)
+	if err != nil || tag != berInteger || parseInt(value) != id {
+		return 0, "", errors.New("unexpected LDAP message ID")
+	}
+	tag, result, _, err := splitTLV(msg)
+	if err != nil || tag != op {
+		return 0, "", errors.New("unexpected LDAP response")
+	}
+	tag, value, result, err = splitTLV(result)
+	if err != nil || tag != berEnumerated {
+		return 0, "", errors.New("malformed LDAP result")
+	}
+	code := parseInt(value)
+	var diag []byte
+	if _, _, result, err = splitTLV(result); err == nil { // matchedDN
+		_, diag, _, _ = splitTLV(result)
+	}
+	return code, string(diag), nil
+}
+
+// berTLV кодирует элемент BER: тег, длина, содержимое
+func berTLV(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+// berInt кодирует небольшое неотрицательное INTEGER
+func berInt(v int) []byte {
+	if v < 0x80 {
+		return berTLV(berInteger, []byte{byte(v)})
+	}
+	return berTLV(berInteger, []byte{0, byte(v)})
+}
+
+func parseInt(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// readTLV читает из потока один элемент BER
+func readTLV(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(head[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 {
+			return 0, nil, errors.New("unsupported BER length")
+		}
+		var lenBuf [3]byte
+		if _, err := io.ReadFull(r, lenBuf[:size]); err != nil {
+			return 0, nil, err
+		}
+		n = parseInt(lenBuf[:size])
+	}
+	if n > ldapMaxMessage {
+		return 0, nil, errors.New("LDAP message too large")
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return head[0], content, nil
+}
+
+// splitTLV отделяет первый элемент BER от буфера: тег, содержимое и остаток
+func splitTLV(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+	tag, n, off := b[0], int(b[1]), 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < 2+size {
+			return 0, nil, nil, errors.New("unsupported BER length")
+		}
+		n, off = parseInt(b[2:2+size]), 2+size
+	}
+	if len(b) < off+n {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+	return tag, b[off : off+n], b[off+n:], nil
+}
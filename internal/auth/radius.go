@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Коды пакетов RADIUS (RFC 2865, RFC 2866)
+const (
+	radiusAccessRequest      = 1
+	radiusAccessAccept       = 2
+	radiusAccessReject       = 3
+	radiusAccountingRequest  = 4
+	radiusAccountingResponse = 5
+	radiusAccessChallenge    = 11
+)
+
+// Атрибуты RADIUS
+const (
+	attrUserName             = 1
+	attrUserPassword         = 2
+	attrFramedIPAddress      = 8
+	attrCallingStationID     = 31
+	attrNASIdentifier        = 32
+	attrAcctStatusType       = 40
+	attrAcctInputOctets      = 42
+	attrAcctOutputOctets     = 43
+	attrAcctSessionID        = 44
+	attrAcctSessionTime      = 46
+	attrAcctInputPackets     = 47
+	attrAcctOutputPackets    = 48
+	attrAcctInputGigawords   = 52
+	attrAcctOutputGigawords  = 53
+	attrNASPortType          = 61
+	attrMessageAuthenticator = 80
+)
+
+const (
+	radiusHeaderSize = 20
+	radiusMaxPacket  = 4096
+	// radiusRetries сколько раз запрос отправляется, если сервер не отвечает
+	radiusRetries = 3
+	// nasPortVirtual тип порта NAS: виртуальный (VPN)
+	nasPortVirtual = 5
+	// maxPasswordSize наибольшая длина User-Password (RFC 2865)
+	maxPasswordSize = 128
+)
+
+// radiusBackend проверяет пароли через RADIUS (PAP) и отправляет записи учета
+type radiusBackend struct {
+	addr     string
+	acctAddr string
+	secret   []byte
+	nasID    string
+	timeout  time.Duration
+}
+
+// newRADIUS разбирает radius://SECRET@host:port?acct_port=1813&nas_id=...
+func newRADIUS(u *url.URL) (*radiusBackend, error) {
+	secret := u.User.Username()
+	if secret == "" {
+		return nil, errors.New("RADIUS backend requires a shared secret (radius://SECRET@host:port)")
+	}
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		return nil, errors.New("RADIUS backend requires a server address")
+	}
+	if port == "" {
+		port = "1812"
+	}
+	acctPort := u.Query().Get("acct_port")
+	if acctPort == "" {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RADIUS port %q", port)
+		}
+		acctPort = strconv.Itoa(n + 1)
+	}
+	nasID := u.Query().Get("nas_id")
+	if nasID == "" {
+		nasID = "myvpn"
+	}
+	return &radiusBackend{
+		addr:     net.JoinHostPort(host, port),
+		acctAddr: net.JoinHostPort(host, acctPort),
+		secret:   []byte(secret),
+		nasID:    nasID,
+		timeout:  DefaultTimeout,
+	}, nil
+}
+
+// radiusPacket пакет RADIUS при сборке
+type radiusPacket struct {
+	code          byte
+	id            byte
+	authenticator [16]byte
+	attrs         []byte
+}
+
+// add добавляет атрибут (значение до 253 байт)
+func (p *radiusPacket) add(attr byte, value []byte) {
+	p.attrs = append(p.attrs, attr, byte(2+len(value)))
+	p.attrs = append(p.attrs, value...)
+}
+
+func (p *radiusPacket) addString(attr byte, value string) {
+	if value != "" {
+		p.add(attr, []byte(value[:min(len(value), 253)]))
+	}
+}
+
+func (p *radiusPacket) addUint32(attr byte, value uint32) {
+	p.add(attr, binary.BigEndian.AppendUint32(nil, value))
+}
+
+// encode собирает пакет: код, ID, длина, authenticator, атрибуты
+func (p *radiusPacket) encode() []byte {
+	buf := make([]byte, radiusHeaderSize, radiusHeaderSize+len(p.attrs))
+	buf[0], buf[1] = p.code, p.id
+	binary.BigEndian.PutUint16(buf[2:], uint16(radiusHeaderSize+len(p.attrs)))
+	copy(buf[4:], p.authenticator[:])
+	return append(buf, p.attrs...)
+}
+
+// hidePassword шифрует User-Password по RFC 2865 (раздел 5.2)
+func hidePassword(password, secret []byte, authenticator [16]byte) []byte {
+	padded := make([]byte, (len(password)+15)/16*16)
+	copy(padded, password)
+	prev := authenticator[:]
+	for i := 0; i < len(padded); i += 16 {
+		h := md5.New()
+		h.Write(secret)
+		h.Write(prev)
+		b := h.Sum(nil)
+		for j := range 16 {
+			padded[i+j] ^= b[j]
+		}
+		prev = padded[i : i+16]
+	}
+	return padded
+}
+
+// Authenticate отправляет Access-Request с паролем (PAP)
+func (r *radiusBackend) Authenticate(ctx context.Context, req Request) error {
+	if req.Username == "" || req.Password == "" {
+		return ErrDenied
+	}
+	if len(req.Password) > maxPasswordSize {
+		return fmt.Errorf("%w: password too long", ErrDenied)
+	}
+
+	p := &radiusPacket{code: radiusAccessRequest}
+	if _, err := rand.Read(p.authenticator[:]); err != nil {
+		return err
+	}
+	p.id = p.authenticator[0]
+	p.addString(attrUserName, req.Username)
+	p.add(attrUserPassword, hidePassword([]byte(req.Password), r.secret, p.authenticator))
+	p.addString(attrNASIdentifier, r.nasID)
+	p.addUint32(attrNASPortType, nasPortVirtual)
+	p.addString(attrCallingStationID, req.RemoteAddr)
+	p.addString(attrAcctSessionID, fmt.Sprintf("%016x", req.SessionID))
+	// Message-Authenticator (RFC 3579) защищает запрос и ответ от подделки (BlastRADIUS)
+	p.add(attrMessageAuthenticator, make([]byte, 16))
+	packet := p.encode()
+	mac := hmac.New(md5.New, r.secret)
+	mac.Write(packet)
+	copy(packet[len(packet)-16:], mac.Sum(nil))
+
+	resp, err := r.exchange(ctx, r.addr, packet, p.authenticator, true)
+	if err != nil {
+		return err
+	}
+	switch resp[0] {
+	case radiusAccessAccept:
+		return nil
+	case radiusAccessReject:
+		return ErrDenied
+	case radiusAccessChallenge:
+		return fmt.Errorf("%w: RADIUS challenge is not supported", ErrDenied)
+	default:
+		return fmt.Errorf("unexpected RADIUS response code %d", resp[0])
+	}
+}
+
+// Account отправляет Accounting-Request о начале или конце сессии
+func (r *radiusBackend) Account(ctx context.Context, rec Record) error {
+	p := &radiusPacket{code: radiusAccountingRequest}
+	var id [1]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	p.id = id[0]
+
+	status := uint32(1)
+	if rec.Type == AccountingStop {
+		status = 2
+	}
+	p.addUint32(attrAcctStatusType, status)
+	p.addString(attrAcctSessionID, fmt.Sprintf("%016x", rec.SessionID))
+	p.addString(attrUserName, rec.Username)
+	p.addString(attrNASIdentifier, r.nasID)
+	p.addUint32(attrNASPortType, nasPortVirtual)
+	p.addString(attrCallingStationID, rec.RemoteAddr)
+	if ip, err := netip.ParseAddr(rec.VirtualIP); err == nil && ip.Is4() {
+		p.add(attrFramedIPAddress, ip.AsSlice())
+	}
+	if rec.Type == AccountingStop {
+		p.addUint32(attrAcctSessionTime, uint32(rec.Duration/time.Second))
+		p.addUint32(attrAcctInputOctets, uint32(rec.RxBytes))
+		p.addUint32(attrAcctOutputOctets, uint32(rec.TxBytes))
+		p.addUint32(attrAcctInputGigawords, uint32(rec.RxBytes>>32))
+		p.addUint32(attrAcctOutputGigawords, uint32(rec.TxBytes>>32))
+		p.addUint32(attrAcctInputPackets, uint32(rec.RxPackets))
+		p.addUint32(attrAcctOutputPackets, uint32(rec.TxPackets))
+	}
+
+	// Request Authenticator учета - MD5 пакета с нулевым authenticator и секрета (RFC 2866)
+	packet := p.encode()
+	h := md5.New()
+	h.Write(packet)
+	h.Write(r.secret)
+	copy(p.authenticator[:], h.Sum(nil))
+	copy(packet[4:20], p.authenticator[:])
+
+	resp, err := r.exchange(ctx, r.acctAddr, packet, p.authenticator, false)
+	if err != nil {
+		return err
+	}
+	if resp[0] != radiusAccountingResponse {
+		return fmt.Errorf("unexpected RADIUS accounting response code %d", resp[0])
+	}
+	return nil
+}
+
+// exchange отправляет запрос и ждет ответ с тем же ID и верным Response Authenticator,
+// повторяя запрос radiusRetries раз. messageAuth - запрос содержит Message-Authenticator,
+// и ответ без него не принимается
+func (r *radiusBackend) exchange(ctx context.Context, addr string, packet []byte, authenticator [16]byte, messageAuth bool) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RADIUS server %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	end := deadline(ctx, r.timeout*radiusRetries)
+	buf := make([]byte, radiusMaxPacket)
+	for attempt := 0; attempt < radiusRetries && time.Now().Before(end); attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, fmt.Errorf("failed to send RADIUS request: %w", err)
+		}
+		attemptEnd := time.Now().Add(r.timeout)
+		if attemptEnd.After(end) {
+			attemptEnd = end
+		}
+		conn.SetReadDeadline(attemptEnd)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if resp := buf[:n]; r.validResponse(resp, packet[1], authenticator, messageAuth) {
+				return append([]byte(nil), resp...), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response from RADIUS server %s", addr)
+}
+
+// validResponse проверяет ID, длину, Response Authenticator и Message-Authenticator ответа.
+// С messageAuth ответ на Access-Request без Message-Authenticator отклоняется: атакующий
+// на пути мог удалить атрибут, и остался бы только MD5 Response Authenticator (BlastRADIUS)
+func (r *radiusBackend) validResponse(resp []byte, id byte, authenticator [16]byte, messageAuth bool) bool {
+	if len(resp) < radiusHeaderSize || resp[1] != id {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(resp[2:]))
+	if length < radiusHeaderSize || length > len(resp) {
+		return false
+	}
+	resp = resp[:length]
+
+	h := md5.New()
+	h.Write(resp[:4])
+	h.Write(authenticator[:])
+	h.Write(resp[radiusHeaderSize:])
+	h.Write(r.secret)
+	if !hmac.Equal(h.Sum(nil), resp[4:20]) {
+		return false
+	}
+
+	signed := false
+	for attrs := resp[radiusHeaderSize:]; len(attrs) >= 2; {
+		size := int(attrs[1])
+		if size < 2 || size > len(attrs) {
+			return false
+		}
+		if attrs[0] == attrMessageAuthenticator {
+			if size != 18 {
+				return false
+			}
+			offset := length - len(attrs) + 2
+			check := bytes.Clone(resp)
+			copy(check[4:20], authenticator[:])
+			clear(check[offset : offset+16])
+			mac := hmac.New(md5.New, r.secret)
+			mac.Write(check)
+			if !hmac.Equal(mac.Sum(nil), resp[offset:offset+16]) {
+				return false
+			}
+			signed = true
+		}
+		attrs = attrs[size:]
+	}
+	if messageAuth && !signed {
+		switch resp[0] {
+		case radiusAccessAccept, radiusAccessReject, radiusAccessChallenge:
+			return false
+		}
+	}
+	return true
+}
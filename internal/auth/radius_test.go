@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// radiusReply формирует ответ code на запрос req. messageAuth - с Message-Authenticator
+func radiusReply(secret, req []byte, code byte, messageAuth bool) []byte {
+	resp := []byte{code, req[1], 0, 0}
+	resp = append(resp, req[4:20]...)
+	if messageAuth {
+		resp = append(resp, attrMessageAuthenticator, 18)
+		resp = append(resp, make([]byte, 16)...)
+	}
+	binary.BigEndian.PutUint16(resp[2:], uint16(len(resp)))
+	if messageAuth {
+		// HMAC считается с Request Authenticator в заголовке и нулевым атрибутом
+		mac := hmac.New(md5.New, secret)
+		mac.Write(resp)
+		copy(resp[len(resp)-16:], mac.Sum(nil))
+	}
+	h := md5.New()
+	h.Write(resp)
+	h.Write(secret)
+	copy(resp[4:20], h.Sum(nil))
+	return resp
+}
+
+// testRADIUS запускает RADIUS сервер, который отвечает на каждый запрос reply
+func testRADIUS(t *testing.T, reply func(req []byte) []byte) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, radiusMaxPacket)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(reply(buf[:n]), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// TestRADIUSMessageAuthenticator проверяет, что ответ на Access-Request принимается только
+// с Message-Authenticator: без него ответ мог подделать атакующий на пути (BlastRADIUS)
+func TestRADIUSMessageAuthenticator(t *testing.T) {
+	secret := []byte("testing123")
+	for _, tc := range []struct {
+		name        string
+		code        byte
+		messageAuth bool
+		want        error // nil - вход разрешен
+		noResponse  bool
+	}{
+		{"signed accept", radiusAccessAccept, true, nil, false},
+		{"signed reject", radiusAccessReject, true, ErrDenied, false},
+		{"stripped accept", radiusAccessAccept, false, nil, true},
+		{"stripped reject", radiusAccessReject, false, nil, true},
+	} {
+		addr := testRADIUS(t, func(req []byte) []byte {
+			return radiusReply(secret, req, tc.code, tc.messageAuth)
+		})
+		r := &radiusBackend{addr: addr, secret: secret, nasID: "test", timeout: 50 * time.Millisecond}
+		err := r.Authenticate(context.Background(), Request{Username: "alice", Password: "secret", RemoteAddr: "192.0.2.1:1"})
+		switch {
+		case tc.noResponse:
+			if err == nil || errors.Is(err, ErrDenied) {
+				t.Errorf("%s: %v, want the response ignored", tc.name, err)
+			}
+		case tc.want == nil && err != nil, !errors.Is(err, tc.want):
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
@@ -1,3 +1,7 @@
+// Package bufpool пулы буферов для пути пакета. Буфер, полученный Get*, принадлежит
+// вызывающему до возврата Put*; после Put ни буфер, ни слайсы на него использовать нельзя.
+// Функции, которым передан буфер, не сохраняют его после возврата: то, что нужно хранить,
+// они копируют. Буфер, переданный дальше по каналу, возвращает в пул получатель
 package bufpool
 
 import (
@@ -9,86 +13,111 @@ const (
 	TUNMTU = 1500
 	// HeaderSize размер заголовка протокола (5 байт: 4 байта для размера + 1 байт флаги)
 	HeaderSize = 5
-	// NonceSize размер nonce для ChaCha20-Poly1305 (12 байт)
-	NonceSize = 12
-	// Overhead размер дополнительных данных (nonce + tag)
-	Overhead = NonceSize + 16 // 12 байт nonce + 16 байт tag
+	// NonceSize размер nonce для XChaCha20-Poly1305 (24 байта)
+	NonceSize = 24
+	// Overhead размер дополнительных данных потокового протокола (nonce + tag)
+	Overhead = NonceSize + 16 // 24 байта nonce + 16 байт tag
+	// DatagramSize размер буфера приема UDP датаграммы: вмещает пакет с MTU 1500,
+	// MAC cookie и SOCKS5 заголовок
+	DatagramSize = 2048
 )
 
+// Пулы хранят указатели на массивы: Put с []byte упаковывал бы заголовок слайса
+// в interface{} и выделял память на каждый возврат
 var (
 	// PacketPool пул для пакетов размером TUNMTU
 	PacketPool = sync.Pool{
 		New: func() interface{} {
-			return make([]byte, TUNMTU)
+			return new([TUNMTU]byte)
 		},
 	}
 
 	// EncryptedPacketPool пул для зашифрованных пакетов (MTU + overhead)
 	EncryptedPacketPool = sync.Pool{
 		New: func() interface{} {
-			return make([]byte, TUNMTU+Overhead)
+			return new([TUNMTU + Overhead]byte)
+		},
+	}
+
+	// DatagramPool пул для принимаемых UDP датаграмм
+	DatagramPool = sync.Pool{
+		New: func() interface{} {
+			return new([DatagramSize]byte)
 		},
 	}
 
 	// HeaderPool пул для заголовков протокола
 	HeaderPool = sync.Pool{
 		New: func() interface{} {
-			return make([]byte, HeaderSize)
+			return new([HeaderSize]byte)
 		},
 	}
 
 	// NoncePool пул для nonce значений
 	NoncePool = sync.Pool{
 		New: func() interface{} {
-			return make([]byte, NonceSize)
+			return new([NonceSize]byte)
 		},
 	}
 )
 
 // GetPacket получает буфер из пула пакетов
 func GetPacket() []byte {
-	return PacketPool.Get().([]byte)
+	return PacketPool.Get().(*[TUNMTU]byte)[:]
 }
 
 // PutPacket возвращает буфер в пул пакетов
 func PutPacket(buf []byte) {
 	if cap(buf) >= TUNMTU {
-		PacketPool.Put(buf[:TUNMTU])
+		PacketPool.Put((*[TUNMTU]byte)(buf[:TUNMTU]))
 	}
 }
 
 // GetEncryptedPacket получает буфер для зашифрованного пакета
 func GetEncryptedPacket() []byte {
-	return EncryptedPacketPool.Get().([]byte)
+	return EncryptedPacketPool.Get().(*[TUNMTU + Overhead]byte)[:]
 }
 
 // PutEncryptedPacket возвращает буфер зашифрованного пакета в пул
 func PutEncryptedPacket(buf []byte) {
 	if cap(buf) >= TUNMTU+Overhead {
-		EncryptedPacketPool.Put(buf[:TUNMTU+Overhead])
+		EncryptedPacketPool.Put((*[TUNMTU + Overhead]byte)(buf[:TUNMTU+Overhead]))
+	}
+}
+
+// GetDatagram получает буфер для приема UDP датаграммы
+func GetDatagram() []byte {
+	return DatagramPool.Get().(*[DatagramSize]byte)[:]
+}
+
+// PutDatagram возвращает буфер датаграммы в пул. Данные буфера после этого
+// нельзя использовать: все, что нужно сохранить, копируется до возврата
+func PutDatagram(buf []byte) {
+	if cap(buf) >= DatagramSize {
+		DatagramPool.Put((*[DatagramSize]byte)(buf[:DatagramSize]))
 	}
 }
 
 // GetHeader получает буфер заголовка из пула
 func GetHeader() []byte {
-	return HeaderPool.Get().([]byte)
+	return HeaderPool.Get().(*[HeaderSize]byte)[:]
 }
 
 // PutHeader возвращает буфер заголовка в пул
 func PutHeader(buf []byte) {
 	if cap(buf) >= HeaderSize {
-		HeaderPool.Put(buf[:HeaderSize])
+		HeaderPool.Put((*[HeaderSize]byte)(buf[:HeaderSize]))
 	}
 }
 
 // GetNonce получает буфер nonce из пула
 func GetNonce() []byte {
-	return NoncePool.Get().([]byte)
+	return NoncePool.Get().(*[NonceSize]byte)[:]
 }
 
 // PutNonce возвращает буфер nonce в пул
 func PutNonce(buf []byte) {
 	if cap(buf) >= NonceSize {
-		NoncePool.Put(buf[:NonceSize])
+		NoncePool.Put((*[NonceSize]byte)(buf[:NonceSize]))
 	}
 }
@@ -0,0 +1,102 @@
+// Package cli разбирает командную строку бинарников вида program [COMMAND] [flags] [args].
+// Первый аргумент, если это не флаг, выбирает команду; без нее выполняется команда
+// по умолчанию (run), поэтому командные строки из одних флагов работают как раньше
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"myvpn/internal/config"
+)
+
+// Command подкоманда бинарника
+type Command struct {
+	Name string
+	// Args синтаксис аргументов для справки ("[flags] NAME")
+	Args string
+	// Summary описание одной строкой для справки
+	Summary string
+	// Run выполняет команду. args - аргументы после имени команды, флаги команда
+	// разбирает сама
+	Run func(args []string) error
+}
+
+// App набор команд бинарника
+type App struct {
+	// Name имя бинарника в справке
+	Name string
+	// Commands команды в порядке вывода в справке
+	Commands []*Command
+	// Default имя команды, которая выполняется без имени команды. Она разбирает
+	// флаги flag.CommandLine, и в ее справке показывается список команд
+	Default string
+}
+
+// Lookup возвращает команду по имени или nil
+func (a *App) Lookup(name string) *Command {
+	for _, c := range a.Commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Usage выводит список команд
+func (a *App) Usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [COMMAND] [flags] [args]\n\nCommands:\n", a.Name)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range a.Commands {
+		summary := c.Summary
+		if c.Name == a.Default {
+			summary += " (default)"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(c.Name+" "+c.Args), summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun '%s COMMAND -h' for the flags of a command.\n", a.Name)
+}
+
+// Run выполняет команду, выбранную args (аргументы без имени программы)
+func (a *App) Run(args []string) error {
+	name := a.Default
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		a.Usage(os.Stdout)
+		return nil
+	}
+	c := a.Lookup(name)
+	if c == nil {
+		return fmt.Errorf("unknown command %q (see '%s help')", name, a.Name)
+	}
+	return c.Run(args)
+}
+
+// Main выполняет команду из os.Args и завершает процесс с кодом 1, если она вернула ошибку
+func (a *App) Main() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		a.Usage(out)
+		fmt.Fprintf(out, "\nFlags of %s:\n", a.Default)
+		flag.PrintDefaults()
+		fmt.Fprintf(out, "\nEvery flag of %s can also be set with an environment variable, e.g. -log-level with %s.\n",
+			a.Default, config.EnvName("log-level"))
+	}
+	if err := a.Run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", a.Name, err)
+		os.Exit(1)
+	}
+}
+
+// FlagSet создает набор флагов команды. Как и flag.CommandLine, при ошибке разбора
+// он выводит справку и завершает процесс
+func FlagSet(app, command string) *flag.FlagSet {
+	return flag.NewFlagSet(app+" "+command, flag.ExitOnError)
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"myvpn/internal"
+)
+
+// VersionCommand команда version: версия сборки, протокола и поддерживаемые возможности
+func VersionCommand(app string) *Command {
+	return &Command{
+		Name:    "version",
+		Summary: "Print the build and protocol version",
+		Run: func(args []string) error {
+			FlagSet(app, "version").Parse(args)
+			fmt.Printf("%s %s (%s, %s/%s)\n", app, internal.BuildVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+			fmt.Printf("protocol %d, capabilities %s\n", internal.ProtocolVersion, internal.FormatCapabilities(internal.Capabilities))
+			return nil
+		},
+	}
+}
+
+// Форматы ключа genkey
+const (
+	FormatBinary = "binary"
+	FormatHex    = "hex"
+	FormatBase64 = "base64"
+)
+
+// GenKeyCommand команда genkey: случайный общий ключ для -key или закрытый ключ X25519
+// для -private-key. С -out ключ записывается в файл с правами 0600
+func GenKeyCommand(app string) *Command {
+	return &Command{
+		Name:    "genkey",
+		Args:    "[-x25519] [-format F] [-out FILE]",
+		Summary: "Generate a shared key for -key or an X25519 private key for -private-key",
+		Run: func(args []string) error {
+			fs := FlagSet(app, "genkey")
+			x25519 := fs.Bool("x25519", false, "Generate an X25519 private key for -private-key instead of a shared key (public key: pubkey)")
+			format := fs.String("format", "", "Key encoding: binary, hex or base64 (default: hex for a shared key, base64 for -x25519)")
+			out := fs.String("out", "", "Write the key to this file with 0600 permissions instead of stdout (with -x25519 the public key is printed)")
+			force := fs.Bool("force", false, "Overwrite an existing -out file")
+			fs.Parse(args)
+			if fs.NArg() > 0 {
+				return fmt.Errorf("usage: %s genkey %s", app, "[-x25519] [-format F] [-out FILE]")
+			}
+
+			var key []byte
+			if *x25519 {
+				private, err := internal.GeneratePrivateKey()
+				if err != nil {
+					return err
+				}
+				key = private
+				if *format == "" {
+					*format = FormatBase64
+				}
+			} else {
+				key = make([]byte, internal.KeySize)
+				if _, err := rand.Read(key); err != nil {
+					return fmt.Errorf("failed to generate key: %w", err)
+				}
+				if *format == "" {
+					*format = FormatHex
+				}
+			}
+			data, err := encodeKey(key, *format)
+			if err != nil {
+				return err
+			}
+
+			if *out == "" {
+				if *format == FormatBinary && isTerminal(os.Stdout) {
+					return errors.New("refusing to print a binary key to the terminal: use -out FILE, redirect stdout or -format hex")
+				}
+				_, err := os.Stdout.Write(data)
+				return err
+			}
+			if err := WriteSecretFile(*out, data, *force); err != nil {
+				return err
+			}
+			if *x25519 {
+				public, err := internal.PublicKey(key)
+				if err != nil {
+					return err
+				}
+				fmt.Println(internal.FormatKey(public))
+			}
+			return nil
+		},
+	}
+}
+
+// PubKeyCommand команда pubkey: открытый ключ X25519 для закрытого ключа из файла или stdin
+func PubKeyCommand(app string) *Command {
+	return &Command{
+		Name:    "pubkey",
+		Args:    "[FILE]",
+		Summary: "Print the X25519 public key of a private key read from FILE or stdin",
+		Run: func(args []string) error {
+			fs := FlagSet(app, "pubkey")
+			fs.Parse(args)
+			var data []byte
+			var err error
+			switch fs.NArg() {
+			case 0:
+				data, err = io.ReadAll(os.Stdin)
+			case 1:
+				data, err = os.ReadFile(fs.Arg(0))
+			default:
+				return fmt.Errorf("usage: %s pubkey [FILE]", app)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read private key: %w", err)
+			}
+			private, err := internal.ParseKey(string(data))
+			if err != nil {
+				return err
+			}
+			public, err := internal.PublicKey(private)
+			if err != nil {
+				return err
+			}
+			fmt.Println(internal.FormatKey(public))
+			return nil
+		},
+	}
+}
+
+// encodeKey кодирует ключ в формате format. Текстовые форматы заканчиваются переводом строки
+func encodeKey(key []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatBinary:
+		return key, nil
+	case FormatHex:
+		return []byte(hex.EncodeToString(key) + "\n"), nil
+	case FormatBase64:
+		return []byte(base64.StdEncoding.EncodeToString(key) + "\n"), nil
+	}
+	return nil, fmt.Errorf("unknown key format %q: expected binary, hex or base64", format)
+}
+
+// WriteSecretFile записывает ключ или конфигурацию с ключом в path с правами 0600.
+// Существующий файл перезаписывается только с force
+func WriteSecretFile(path string, data []byte, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use -force to overwrite)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	// Права существующего файла OpenFile не меняет
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to restrict permissions of %s: %w", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// isTerminal сообщает, подключен ли f к терминалу
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,146 @@
+package compress
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// AdaptiveMinSamples число сжатых пакетов потока, после которого принимается решение
+	AdaptiveMinSamples = 8
+	// AdaptiveReprobeInterval через сколько снова пробовать сжимать поток, для которого сжатие выключено
+	AdaptiveReprobeInterval = 30 * time.Second
+	// AdaptiveMaxRatio поток, который в среднем сжимается хуже, не сжимается (TLS, видео)
+	AdaptiveMaxRatio = 0.95
+	// AdaptiveBusyMaxRatio порог при высокой загрузке CPU: сжимаются только хорошо сжимаемые потоки
+	AdaptiveBusyMaxRatio = 0.8
+	// AdaptiveBusyLoad загрузка CPU процессом (доля всех ядер), начиная с которой действует AdaptiveBusyMaxRatio
+	AdaptiveBusyLoad = 0.8
+
+	// adaptiveSlots размер таблицы потоков. Потоки с одинаковым слотом вытесняют друг друга
+	adaptiveSlots = 4096
+	// adaptiveAlpha вес нового пакета в скользящем среднем коэффициента сжатия
+	adaptiveAlpha = 0.25
+	// cpuSampleInterval как часто пересчитывается загрузка CPU
+	cpuSampleInterval = time.Second
+)
+
+// flowStats статистика сжатия одного потока
+type flowStats struct {
+	mu        sync.Mutex
+	flow      uint32
+	used      bool
+	ratio     float64   // скользящее среднее коэффициента сжатия
+	samples   int       // число пакетов в среднем, не больше AdaptiveMinSamples
+	skipUntil time.Time // до этого момента поток не сжимается
+}
+
+// Adaptive решает для каждого потока, стоит ли тратить CPU на сжатие. Поток, который
+// почти не сжимается, на AdaptiveReprobeInterval отправляется без сжатия, затем
+// следующий пакет снова сжимается для проверки. При высокой загрузке CPU порог строже
+type Adaptive struct {
+	flows [adaptiveSlots]flowStats
+
+	cpuMu      sync.Mutex
+	cpuSampled time.Time
+	cpuTime    time.Duration
+	cpuLoad    atomic.Uint64 // загрузка в десятитысячных долях всех ядер
+}
+
+// NewAdaptive создает пустую статистику потоков
+func NewAdaptive() *Adaptive {
+	return &Adaptive{}
+}
+
+// Compress сжимает пакет потока flow (хеш 5-tuple) кодеком codec, если сжатие
+// этого потока окупается. Сжатые данные дописываются к dst, как в CompressTo.
+// Возвращает данные и примененный кодек, как CompressCodec
+func (a *Adaptive) Compress(dst []byte, codec Codec, flow uint32, data []byte) ([]byte, Codec, error) {
+	if codec == CodecNone || len(data) < CompressionThreshold {
+		return data, CodecNone, nil
+	}
+
+	slot := &a.flows[flow%adaptiveSlots]
+	now := time.Now()
+	slot.mu.Lock()
+	if !slot.used || slot.flow != flow {
+		slot.flow, slot.used = flow, true
+		slot.ratio, slot.samples, slot.skipUntil = 0, 0, time.Time{}
+	}
+	skip := now.Before(slot.skipUntil)
+	slot.mu.Unlock()
+	if skip {
+		metricAdaptiveSkipped.Inc()
+		return data, CodecNone, nil
+	}
+
+	start := time.Now()
+	compressed, applied, err := CompressTo(dst, codec, data)
+	metricAdaptiveCPU.Add(uint64(time.Since(start).Nanoseconds()))
+	metricAdaptiveAttempts.Inc()
+	if err != nil {
+		return nil, CodecNone, err
+	}
+
+	ratio := 1.0
+	if applied != CodecNone {
+		ratio = float64(len(compressed)-len(dst)) / float64(len(data))
+	}
+	maxRatio := AdaptiveMaxRatio
+	if a.load(now) >= AdaptiveBusyLoad {
+		maxRatio = AdaptiveBusyMaxRatio
+	}
+
+	slot.mu.Lock()
+	if slot.flow == flow {
+		if slot.samples == 0 {
+			slot.ratio = ratio
+		} else {
+			slot.ratio += adaptiveAlpha * (ratio - slot.ratio)
+		}
+		if slot.samples < AdaptiveMinSamples {
+			slot.samples++
+		}
+		// Среднее не сбрасывается, поэтому после паузы хватает одного плохого пакета,
+		// чтобы снова выключить сжатие
+		if slot.samples >= AdaptiveMinSamples && slot.ratio > maxRatio {
+			slot.skipUntil = now.Add(AdaptiveReprobeInterval)
+			metricAdaptiveDisabled.Inc()
+		}
+	}
+	slot.mu.Unlock()
+
+	return compressed, applied, nil
+}
+
+// Load возвращает последнюю измеренную загрузку CPU процессом (доля всех ядер)
+func (a *Adaptive) Load() float64 {
+	return float64(a.cpuLoad.Load()) / 10000
+}
+
+// load пересчитывает загрузку CPU не чаще раза в cpuSampleInterval
+func (a *Adaptive) load(now time.Time) float64 {
+	if !a.cpuMu.TryLock() {
+		return a.Load()
+	}
+	defer a.cpuMu.Unlock()
+	if now.Sub(a.cpuSampled) < cpuSampleInterval {
+		return a.Load()
+	}
+
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return a.Load()
+	}
+	cpuTime := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	if !a.cpuSampled.IsZero() {
+		wall := now.Sub(a.cpuSampled) * time.Duration(runtime.NumCPU())
+		a.cpuLoad.Store(uint64(float64(cpuTime-a.cpuTime) / float64(wall) * 10000))
+		metricAdaptiveLoad.Set(int64(a.Load() * 100))
+	}
+	a.cpuSampled, a.cpuTime = now, cpuTime
+	return a.Load()
+}
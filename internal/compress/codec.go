@@ -0,0 +1,157 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codec алгоритм сжатия. ID кодека передается в заголовке пакета транспорта,
+// поэтому получатель распаковывает данные независимо от своих настроек
+type Codec byte
+
+const (
+	// CodecNone данные не сжаты
+	CodecNone Codec = 0
+	// CodecLZ4 LZ4 фрейм: быстрое сжатие
+	CodecLZ4 Codec = 1
+	// CodecZstd Zstandard: заметно лучше сжимает текст при сравнимой скорости
+	CodecZstd Codec = 2
+)
+
+// String возвращает имя кодека
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecLZ4:
+		return "lz4"
+	case CodecZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// SupportedCodecs кодеки, которые умеет распаковывать эта сборка, в порядке предпочтения
+// для режима auto (LZ4 дешевле по CPU)
+var SupportedCodecs = []Codec{CodecLZ4, CodecZstd}
+
+// SupportedNames возвращает имена поддерживаемых кодеков для согласования при handshake
+func SupportedNames() []string {
+	names := make([]string, len(SupportedCodecs))
+	for i, codec := range SupportedCodecs {
+		names[i] = codec.String()
+	}
+	return names
+}
+
+// Negotiate выбирает кодек для отправки пиру, который умеет распаковывать peer (имена кодеков).
+// Предпочтительный кодек берется, если пир его поддерживает, иначе первый общий из SupportedCodecs
+// (для preferred = CodecNone, режим auto, сразу первый общий).
+// Если общих кодеков нет (старый пир), возвращает CodecNone
+func Negotiate(preferred Codec, peer []string) Codec {
+	known := make(map[Codec]bool, len(peer))
+	for _, name := range peer {
+		if codec, err := ParseCodec(name); err == nil {
+			known[codec] = true
+		}
+	}
+	if known[preferred] {
+		return preferred
+	}
+	for _, codec := range SupportedCodecs {
+		if known[codec] {
+			return codec
+		}
+	}
+	return CodecNone
+}
+
+// ParseCodec разбирает имя кодека (lz4, zstd)
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "lz4":
+		return CodecLZ4, nil
+	case "zstd":
+		return CodecZstd, nil
+	}
+	return CodecNone, fmt.Errorf("unknown compression codec %q (expected lz4 or zstd)", name)
+}
+
+// ParseMode разбирает значение флага -compress: off, auto или имя кодека.
+// Возвращает предпочтительный кодек (CodecNone для auto) и false, если сжатие выключено
+func ParseMode(mode string) (Codec, bool, error) {
+	switch mode {
+	case "off":
+		return CodecNone, false, nil
+	case "auto", "":
+		return CodecNone, true, nil
+	}
+	codec, err := ParseCodec(mode)
+	if err != nil {
+		return CodecNone, false, fmt.Errorf("unknown compression mode %q (expected off, auto, lz4 or zstd)", mode)
+	}
+	return codec, true, nil
+}
+
+// CompressCodec сжимает данные кодеком codec и возвращает примененный кодек.
+// Если пакет слишком мал или сжатие не дало эффекта, возвращает исходные данные и CodecNone
+func CompressCodec(codec Codec, data []byte) ([]byte, Codec, error) {
+	return CompressTo(nil, codec, data)
+}
+
+// CompressTo работает как CompressCodec, но дописывает сжатые данные к dst. Если емкости
+// dst хватает (например, буфер из bufpool), память не выделяется. dst не должен
+// пересекаться с data
+func CompressTo(dst []byte, codec Codec, data []byte) ([]byte, Codec, error) {
+	if len(data) < CompressionThreshold {
+		// Не сжимаем маленькие пакеты
+		return data, CodecNone, nil
+	}
+
+	var frame []byte
+	var err error
+	switch codec {
+	case CodecLZ4:
+		frame, err = compressLZ4(dst, data)
+	case CodecZstd:
+		frame = compressZstd(dst, data)
+	default:
+		return data, CodecNone, nil
+	}
+	if err != nil {
+		return nil, CodecNone, err
+	}
+
+	// Проверяем, действительно ли сжатие помогло
+	ratio := float64(len(frame)-len(dst)) / float64(len(data))
+	if ratio >= CompressionRatioThreshold {
+		// Сжатие не дало значительного эффекта
+		return data, CodecNone, nil
+	}
+
+	return frame, codec, nil
+}
+
+// Decompress распаковывает данные, сжатые кодеком codec
+func Decompress(data []byte, codec Codec) ([]byte, error) {
+	return DecompressTo(nil, data, codec)
+}
+
+// DecompressTo работает как Decompress, но дописывает распакованные данные к dst.
+// Данные без сжатия возвращаются как есть. dst не должен пересекаться с data
+func DecompressTo(dst, data []byte, codec Codec) ([]byte, error) {
+	if codec == CodecNone {
+		return data, nil
+	}
+	if len(data) == 0 {
+		return nil, errors.New("compressed data is empty")
+	}
+
+	switch codec {
+	case CodecLZ4:
+		return decompressLZ4(dst, data)
+	case CodecZstd:
+		return decompressZstd(dst, data)
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", byte(codec))
+}
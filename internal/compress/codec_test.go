@@ -0,0 +1,58 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"myvpn/internal/bufpool"
+)
+
+// benchPacket пакет размером с MTU с текстом HTTP ответа: такие пакеты сжимаются
+var benchPacket = bytes.Repeat([]byte("<tr><td class=\"name\">vpnturbo</td><td class=\"size\">1024</td></tr>\n"), 22)[:1400]
+
+func BenchmarkCompress(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchPacket)))
+	for b.Loop() {
+		if _, ok, err := Compress(benchPacket); err != nil || !ok {
+			b.Fatalf("Compress: ok %v, err %v", ok, err)
+		}
+	}
+}
+
+func BenchmarkCompressTo(b *testing.B) {
+	for _, codec := range []Codec{CodecLZ4, CodecZstd} {
+		b.Run(codec.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(benchPacket)))
+			for b.Loop() {
+				buf := bufpool.GetDatagram()
+				if _, applied, err := CompressTo(buf[:0], codec, benchPacket); err != nil || applied != codec {
+					b.Fatalf("CompressTo: codec %v, err %v", applied, err)
+				}
+				bufpool.PutDatagram(buf)
+			}
+		})
+	}
+}
+
+func BenchmarkDecompressTo(b *testing.B) {
+	for _, codec := range []Codec{CodecLZ4, CodecZstd} {
+		b.Run(codec.String(), func(b *testing.B) {
+			compressed, applied, err := CompressCodec(codec, benchPacket)
+			if err != nil || applied != codec {
+				b.Fatalf("CompressCodec: codec %v, err %v", applied, err)
+			}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(benchPacket)))
+			for b.Loop() {
+				buf := bufpool.GetDatagram()
+				out, err := DecompressTo(buf[:0], compressed, codec)
+				if err != nil || len(out) != len(benchPacket) {
+					b.Fatalf("DecompressTo: %d bytes, err %v", len(out), err)
+				}
+				bufpool.PutDatagram(buf)
+			}
+		})
+	}
+}
@@ -2,8 +2,9 @@ package compress
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/pierrec/lz4/v4"
 )
@@ -17,114 +18,90 @@ const (
 
 // Compress сжимает данные используя LZ4, возвращает сжатые данные и флаг сжатия
 func Compress(data []byte) ([]byte, bool, error) {
-	if len(data) < CompressionThreshold {
-		// Не сжимаем маленькие пакеты
-		return data, false, nil
-	}
-
-	var buf bytes.Buffer
-	writer := lz4.NewWriter(&buf)
-
-	if _, err := writer.Write(data); err != nil {
-		return nil, false, fmt.Errorf("failed to compress: %w", err)
-	}
-
-	if err := writer.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close compressor: %w", err)
-	}
-
-	compressed := buf.Bytes()
-
-	// Проверяем, действительно ли сжатие помогло
-	ratio := float64(len(compressed)) / float64(len(data))
-	if ratio >= CompressionRatioThreshold {
-		// Сжатие не дало значительного эффекта
-		return data, false, nil
-	}
-
-	return compressed, true, nil
+	compressed, codec, err := CompressCodec(CodecLZ4, data)
+	return compressed, codec != CodecNone, err
 }
 
-// Decompress распаковывает данные используя LZ4
-func Decompress(data []byte, compressed bool) ([]byte, error) {
-	if !compressed {
-		return data, nil
+// Кодеры LZ4 переиспользуются: создание Writer и Reader на каждый пакет стоит
+// нескольких выделений памяти
+var (
+	lz4Writers = sync.Pool{
+		New: func() interface{} {
+			e := &lz4Encoder{}
+			e.writer = lz4.NewWriter(&e.out)
+			return e
+		},
 	}
-
-	reader := lz4.NewReader(bytes.NewReader(data))
-
-	var buf bytes.Buffer
-	if _, err := buf.ReadFrom(reader); err != nil {
-		return nil, fmt.Errorf("failed to decompress: %w", err)
+	lz4Readers = sync.Pool{
+		New: func() interface{} {
+			d := &lz4Decoder{}
+			d.reader = lz4.NewReader(&d.in)
+			return d
+		},
 	}
+)
 
-	return buf.Bytes(), nil
+// lz4Encoder Writer вместе с приемником, который дописывает фрейм к заданному слайсу
+type lz4Encoder struct {
+	out    appendWriter
+	writer *lz4.Writer
 }
 
-// CompressWithPool сжимает данные используя пул буферов
-func CompressWithPool(data []byte, getBuf func() []byte, putBuf func([]byte)) ([]byte, bool, error) {
-	if len(data) < CompressionThreshold {
-		return data, false, nil
-	}
-
-	buf := getBuf()
-	defer putBuf(buf)
-
-	var compressed bytes.Buffer
-	writer := lz4.NewWriter(&compressed)
+// lz4Decoder Reader вместе с источником
+type lz4Decoder struct {
+	in     bytes.Reader
+	reader *lz4.Reader
+}
 
-	if _, err := writer.Write(data); err != nil {
-		return nil, false, fmt.Errorf("failed to compress: %w", err)
-	}
+// appendWriter дописывает все записанное к buf
+type appendWriter struct {
+	buf []byte
+}
 
-	if err := writer.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close compressor: %w", err)
-	}
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
 
-	result := compressed.Bytes()
+// compressLZ4 сжимает данные в LZ4 фрейм и дописывает его к dst
+func compressLZ4(dst, data []byte) ([]byte, error) {
+	e := lz4Writers.Get().(*lz4Encoder)
+	defer lz4Writers.Put(e)
+	e.out.buf = dst
+	defer func() { e.out.buf = nil }()
+	e.writer.Reset(&e.out)
 
-	// Проверяем коэффициент сжатия
-	ratio := float64(len(result)) / float64(len(data))
-	if ratio >= CompressionRatioThreshold {
-		return data, false, nil
+	if _, err := e.writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress: %w", err)
 	}
 
-	// Копируем результат в буфер из пула если возможно
-	if cap(buf) >= len(result) {
-		copy(buf, result)
-		return buf[:len(result)], true, nil
+	if err := e.writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close compressor: %w", err)
 	}
 
-	// Иначе возвращаем новый буфер
-	return result, true, nil
+	return e.out.buf, nil
 }
 
-// DecompressWithPool распаковывает данные используя пул буферов
-func DecompressWithPool(data []byte, compressed bool, getBuf func() []byte, putBuf func([]byte)) ([]byte, error) {
-	if !compressed {
-		return data, nil
+// decompressLZ4 распаковывает LZ4 фрейм и дописывает данные к dst
+func decompressLZ4(dst, data []byte) ([]byte, error) {
+	d := lz4Readers.Get().(*lz4Decoder)
+	defer lz4Readers.Put(d)
+	d.in.Reset(data)
+	defer d.in.Reset(nil)
+	d.reader.Reset(&d.in)
+
+	out := dst
+	for {
+		if len(out) == cap(out) {
+			out = append(out, 0)[:len(out)]
+		}
+		n, err := d.reader.Read(out[len(out):cap(out)])
+		out = out[:len(out)+n]
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
 	}
-
-	reader := lz4.NewReader(bytes.NewReader(data))
-
-	buf := getBuf()
-	defer putBuf(buf)
-
-	var result bytes.Buffer
-	if _, err := result.ReadFrom(reader); err != nil {
-		return nil, fmt.Errorf("failed to decompress: %w", err)
-	}
-
-	decompressed := result.Bytes()
-	if len(decompressed) == 0 {
-		return nil, errors.New("decompressed data is empty")
-	}
-
-	// Копируем в буфер из пула если возможно
-	if cap(buf) >= len(decompressed) {
-		copy(buf, decompressed)
-		return buf[:len(decompressed)], nil
-	}
-
-	return decompressed, nil
 }
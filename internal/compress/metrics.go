@@ -0,0 +1,14 @@
+package compress
+
+import (
+	"myvpn/internal/metrics"
+)
+
+// Метрики адаптивного сжатия
+var (
+	metricAdaptiveAttempts = metrics.NewCounter("myvpn_compression_attempts_total", "Packets passed to the compressor by adaptive compression")
+	metricAdaptiveSkipped  = metrics.NewCounter("myvpn_compression_skipped_total", "Packets sent uncompressed because compression of their flow does not pay off")
+	metricAdaptiveDisabled = metrics.NewCounter("myvpn_compression_flows_disabled_total", "Times compression was switched off for a flow until the next re-probe")
+	metricAdaptiveCPU      = metrics.NewCounter("myvpn_compression_cpu_nanoseconds_total", "Time spent compressing packets")
+	metricAdaptiveLoad     = metrics.NewGauge("myvpn_compression_cpu_load_percent", "Process CPU load (percent of all cores) used to pick the compression threshold")
+)
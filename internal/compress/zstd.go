@@ -0,0 +1,51 @@
+package compress
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMaxDecodedSize ограничивает размер распакованного пакета, чтобы "zip-бомба"
+// не заняла память (фрагментированный пакет не больше 64 КБ)
+const zstdMaxDecodedSize = 64 << 10
+
+// Кодер и декодер создаются один раз: EncodeAll и DecodeAll безопасны для параллельных вызовов
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		encoder, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(zstd.MinWindowSize),
+		)
+		if err != nil {
+			panic(fmt.Sprintf("zstd encoder: %v", err))
+		}
+		return encoder
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		decoder, err := zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(0),
+			zstd.WithDecoderMaxMemory(zstdMaxDecodedSize),
+		)
+		if err != nil {
+			panic(fmt.Sprintf("zstd decoder: %v", err))
+		}
+		return decoder
+	})
+)
+
+// compressZstd сжимает данные в zstd фрейм и дописывает его к dst
+func compressZstd(dst, data []byte) []byte {
+	return zstdEncoder().EncodeAll(data, dst)
+}
+
+// decompressZstd распаковывает zstd фрейм и дописывает данные к dst
+func decompressZstd(dst, data []byte) ([]byte, error) {
+	decoded, err := zstdDecoder().DecodeAll(data, dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	return decoded, nil
+}
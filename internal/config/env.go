@@ -0,0 +1,41 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvPrefix префикс переменных окружения с параметрами: флаг -peer-db задается
+// переменной VPNTURBO_PEER_DB
+const EnvPrefix = "VPNTURBO_"
+
+// EnvName возвращает имя переменной окружения флага
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ApplyEnv присваивает флагам значения переменных окружения VPNTURBO_*. Флаги, явно
+// заданные в командной строке, имеют приоритет, а файл конфигурации (ApplyFile) не меняет
+// флаги из окружения. Списки задаются через запятую, как в командной строке. Переменные
+// без соответствующего флага пропускаются: окружение может быть общим для сервера и клиента
+func ApplyEnv(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value for %s: %w", name, setErr)
+		}
+	})
+	return err
+}
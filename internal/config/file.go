@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// File файл конфигурации, примененный к флагам, который можно перечитать (SIGHUP)
+type File struct {
+	fs       *flag.FlagSet
+	path     string
+	explicit map[string]bool        // флаги из командной строки
+	values   map[string]interface{} // значения из файла при последней загрузке
+}
+
+// ApplyFile загружает JSON файл конфигурации и присваивает значения флагам.
+// Ключи файла совпадают с именами флагов (например, "server", "route").
+// Флаги, явно заданные в командной строке, имеют приоритет над файлом.
+// Массивы объединяются через запятую, поэтому "route": ["10.0.0.0/8"] эквивалентно -route 10.0.0.0/8
+func ApplyFile(fs *flag.FlagSet, path string) error {
+	_, err := Load(fs, path)
+	return err
+}
+
+// Load применяет файл конфигурации к флагам, как ApplyFile, и запоминает его для Reload
+func Load(fs *flag.FlagSet, path string) (*File, error) {
+	f := &File{fs: fs, path: path, explicit: make(map[string]bool)}
+	fs.Visit(func(fl *flag.Flag) {
+		f.explicit[fl.Name] = true
+	})
+
+	values, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	for name, raw := range values {
+		if f.explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, formatValue(raw)); err != nil {
+			return nil, fmt.Errorf("invalid value for %q in config file: %w", name, err)
+		}
+	}
+	f.values = values
+	return f, nil
+}
+
+// Reload перечитывает файл и заново присваивает флаги names: флаг, которого больше нет
+// в файле, возвращается к значению по умолчанию. Остальные флаги не меняются, а их имена,
+// если значение в файле изменилось, возвращаются в restart. При ошибке флаги остаются прежними
+func (f *File) Reload(names []string) (restart []string, err error) {
+	values, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	reloadable := make(map[string]bool, len(names))
+	for _, name := range names {
+		reloadable[name] = true
+	}
+	changed := make(map[string]bool)
+	for _, m := range []map[string]interface{}{values, f.values} {
+		for name := range m {
+			if !reloadable[name] && !f.explicit[name] && !reflect.DeepEqual(values[name], f.values[name]) {
+				changed[name] = true
+			}
+		}
+	}
+	for name := range changed {
+		restart = append(restart, name)
+	}
+	sort.Strings(restart)
+
+	previous := make(map[string]string)
+	for _, name := range names {
+		fl := f.fs.Lookup(name)
+		if fl == nil || f.explicit[name] {
+			continue
+		}
+		value := fl.DefValue
+		if raw, ok := values[name]; ok {
+			value = formatValue(raw)
+		}
+		previous[name] = fl.Value.String()
+		if err := f.fs.Set(name, value); err != nil {
+			for name, value := range previous {
+				f.fs.Set(name, value)
+			}
+			return nil, fmt.Errorf("invalid value for %q in config file: %w", name, err)
+		}
+	}
+	f.values = values
+	return restart, nil
+}
+
+// read загружает файл и проверяет, что все его ключи - известные флаги
+func (f *File) read() (map[string]interface{}, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Числа читаются как json.Number: через float64 1000000 превратился бы в "1e+06"
+	var values map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", f.path, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("failed to parse config file %s: unexpected data after the top-level object", f.path)
+	}
+	for name := range values {
+		if f.fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown option %q in config file %s", name, f.path)
+		}
+	}
+	return values, nil
+}
+
+// formatValue преобразует JSON значение в строковое представление флага
+func formatValue(raw interface{}) string {
+	switch v := raw.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, formatValue(item))
+		}
+		return strings.Join(items, ",")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
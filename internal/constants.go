@@ -2,12 +2,14 @@ package internal
 
 const (
 	// TUNMTU максимальный размер передаваемой единицы (MTU)
-	// Уменьшен до 1420 чтобы после шифрования (+28 байт overhead) и добавления флага сжатия (+1 байт)
-	// пакет не превышал MaxPacketSize в UDP транспорте (1467 байт)
-	// 1420 + 28 + 1 = 1449 < 1467
+	// Уменьшен до 1420 чтобы после шифрования (+16 байт tag) и добавления ID кодека сжатия (+1 байт)
+	// пакет не превышал MaxPacketSize в UDP транспорте (1454 байта)
+	// 1420 + 16 + 1 = 1437 < 1454
 	TUNMTU = 1420
 	// HeaderSize размер заголовка протокола (4 байта для размера пакета + 1 байт флаги)
 	HeaderSize = 5
 	// FlagCompressed флаг сжатия в заголовке (бит 0)
 	FlagCompressed = 0x01
+	// MaxTUNQueues максимальное число очередей multi-queue TUN (ограничение ядра Linux)
+	MaxTUNQueues = 256
 )
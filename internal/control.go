@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+const (
+	// ControlConfigRequest запрос клиентом конфигурации у сервера
+	ControlConfigRequest = 0x01
+	// ControlConfig конфигурация, которую сервер отправляет клиенту
+	ControlConfig = 0x02
+	// ControlReject сервер отказывает в создании сессии
+	ControlReject = 0x03
+	// ControlDisconnect сервер разорвал сессию по команде администратора (тело - Reject)
+	ControlDisconnect = 0x04
+	// ControlPeer сервер знакомит клиента с другим клиентом для прямого обмена пакетами (тело - PeerEndpoint)
+	ControlPeer = 0x05
+)
+
+// ConfigRequest запрос конфигурации. Заодно клиент сообщает, какие кодеки сжатия он умеет
+// распаковывать, и сервер выбирает кодек для пакетов к нему
+type ConfigRequest struct {
+	// Version версия протокола клиента (0 - клиент без согласования версий)
+	Version uint8 `json:"version,omitempty"`
+	// Capabilities биты возможностей клиента (CapCompression и т.д.)
+	Capabilities uint64 `json:"caps,omitempty"`
+	// Codecs имена поддерживаемых кодеков сжатия (lz4, zstd). Пустой список - клиент без согласования
+	Codecs []string `json:"codecs,omitempty"`
+	// FEC параметры FEC для пакетов сервера к клиенту ("10/3"). Пустая строка - без FEC
+	FEC string `json:"fec,omitempty"`
+	// Bond клиент распределяет пакеты по нескольким путям (multipath) и просит сервер
+	// отвечать по всем путям, с которых приходят пакеты сессии
+	Bond bool `json:"bond,omitempty"`
+	// AssignIP клиент просит назначить ему адреса внутри VPN
+	AssignIP bool `json:"assign_ip,omitempty"`
+	// TOTP одноразовый код второго фактора, если сервер его требует
+	TOTP string `json:"totp,omitempty"`
+	// Username и Password учетные данные для внешней проверки (RADIUS, LDAP), если сервер ее требует
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// P2P клиент готов обмениваться пакетами с другими клиентами напрямую, через свой сокет
+	// к серверу (сервер сообщает ему их внешние адреса)
+	P2P bool `json:"p2p,omitempty"`
+}
+
+// Reject причина отказа сервера в подключении
+type Reject struct {
+	// Reason человекочитаемая причина
+	Reason string `json:"reason"`
+	// RetryAfter через сколько секунд имеет смысл повторить попытку
+	RetryAfter int `json:"retry_after,omitempty"`
+	// MinVersion минимальная версия протокола, которую принимает сервер,
+	// если отказ вызван слишком старой версией клиента
+	MinVersion uint8 `json:"min_version,omitempty"`
+	// TOTP сервер требует код TOTP, а в запросе его нет или он неверен
+	TOTP bool `json:"totp,omitempty"`
+}
+
+// ClientConfig параметры, которые сервер передает клиенту при подключении
+type ClientConfig struct {
+	// Version версия протокола сервера
+	Version uint8 `json:"version,omitempty"`
+	// Capabilities биты возможностей сервера
+	Capabilities uint64 `json:"caps,omitempty"`
+	// DNS список DNS серверов, которые клиент должен использовать
+	DNS []string `json:"dns,omitempty"`
+	// Codecs кодеки сжатия, которые умеет распаковывать сервер
+	Codecs []string `json:"codecs,omitempty"`
+	// IP и IP6 адреса клиента внутри VPN с длиной префикса (10.0.0.5/24), если клиент их запросил
+	IP  string `json:"ip,omitempty"`
+	IP6 string `json:"ip6,omitempty"`
+	// MTU TUN интерфейса клиента (0 - клиент выбирает сам)
+	MTU int `json:"mtu,omitempty"`
+	// Routes сети, которые клиент направляет в VPN, если у него нет своих маршрутов
+	// (пустой список - весь трафик)
+	Routes []string `json:"routes,omitempty"`
+	// P2P сервер принял запрос прямого обмена и будет знакомить клиента с другими клиентами
+	P2P bool `json:"p2p,omitempty"`
+}
+
+// PeerEndpoint другой клиент, с которым клиент может обмениваться пакетами напрямую (P2P).
+// Сервер отправляет его обоим клиентам пары, когда пересылает пакеты между ними
+type PeerEndpoint struct {
+	// IPs адреса другого клиента внутри VPN
+	IPs []string `json:"ips"`
+	// Endpoint внешний адрес другого клиента (ip:port), каким его видит сервер
+	Endpoint string `json:"endpoint"`
+	// Session и Key session ID и ключ пакетов между клиентами. Сервер выдает новые
+	// при каждом знакомстве пары, поэтому счетчик пакетов с ключом не повторяется
+	Session uint64 `json:"session"`
+	Key     []byte `json:"key"`
+	// Initiator различает стороны пары: задает направление в nonce (у другого клиента false)
+	Initiator bool `json:"initiator,omitempty"`
+	// Gone другой клиент отключился: сессию Session нужно удалить, остальные поля пустые
+	Gone bool `json:"gone,omitempty"`
+}
+
+// EncodeControl кодирует управляющее сообщение: 1 байт тип + JSON тело
+func EncodeControl(msgType byte, body interface{}) ([]byte, error) {
+	msg := []byte{msgType}
+	if body == nil {
+		return msg, nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return append(msg, data...), nil
+}
+
+// DecodeControl разбирает управляющее сообщение на тип и JSON тело
+func DecodeControl(msg []byte) (byte, []byte, error) {
+	if len(msg) == 0 {
+		return 0, nil, errors.New("empty control message")
+	}
+	return msg[0], msg[1:], nil
+}
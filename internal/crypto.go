@@ -2,20 +2,18 @@ package internal
 
 import (
 	"crypto/cipher"
-	"crypto/rand"
 	"errors"
-	"io"
 	"golang.org/x/crypto/chacha20poly1305"
-	"myvpn/internal/bufpool"
 )
 
 const (
-	// KeySize размер ключа для ChaCha20-Poly1305 (32 байта)
+	// KeySize размер ключа для XChaCha20-Poly1305 (32 байта)
 	KeySize = chacha20poly1305.KeySize
-	// NonceSize размер nonce для ChaCha20-Poly1305 (12 байт)
-	NonceSize = chacha20poly1305.NonceSize
-	// Overhead размер дополнительных данных (nonce + tag)
-	Overhead = NonceSize + 16 // 12 байт nonce + 16 байт tag
+	// NonceSize размер nonce для XChaCha20-Poly1305 (24 байта)
+	NonceSize = chacha20poly1305.NonceSizeX
+	// Overhead размер дополнительных данных (tag). Nonce не передается: отправитель
+	// и получатель выводят его из заголовка пакета
+	Overhead = 16 // 16 байт tag
 	// MaxPacketSize максимальный размер пакета (MTU + overhead)
 	MaxPacketSize = TUNMTU + Overhead
 )
@@ -31,7 +29,7 @@ func NewCrypto(key []byte) (*Crypto, error) {
 		return nil, errors.New("invalid key size")
 	}
 
-	aead, err := chacha20poly1305.New(key)
+	aead, err := chacha20poly1305.NewX(key)
 	if err != nil {
 		return nil, err
 	}
@@ -39,42 +37,35 @@ func NewCrypto(key []byte) (*Crypto, error) {
 	return &Crypto{aead: aead}, nil
 }
 
-// Encrypt шифрует данные и возвращает nonce + зашифрованные данные + tag
-func (c *Crypto) Encrypt(plaintext []byte, aad []byte) ([]byte, error) {
-	// Получаем nonce из пула
-	nonce := bufpool.GetNonce()
-	defer bufpool.PutNonce(nonce)
-
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
-	}
-
-	// Шифруем данные
-	ciphertext := c.aead.Seal(nil, nonce, plaintext, aad)
+// Encrypt шифрует данные и возвращает зашифрованные данные + tag.
+// Nonce не должен повторяться для одного ключа, поэтому вызывающий строит его
+// из счетчика, а не из случайных байт
+func (c *Crypto) Encrypt(nonce []byte, plaintext []byte, aad []byte) ([]byte, error) {
+	return c.Seal(nil, nonce, plaintext, aad)
+}
 
-	// Выделяем результат (не из пула, так как возвращаем его)
-	result := make([]byte, NonceSize+len(ciphertext))
-	copy(result[:NonceSize], nonce)
-	copy(result[NonceSize:], ciphertext)
+// Decrypt дешифрует данные (encrypted_data + tag)
+func (c *Crypto) Decrypt(nonce []byte, ciphertext []byte, aad []byte) ([]byte, error) {
+	return c.Open(nil, nonce, ciphertext, aad)
+}
 
-	return result, nil
+// Seal шифрует данные и дописывает шифротекст с tag к dst, как cipher.AEAD.Seal.
+// Если емкости dst хватает, память не выделяется. Для шифрования на месте dst = plaintext[:0]
+func (c *Crypto) Seal(dst, nonce, plaintext, aad []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		return nil, errors.New("invalid nonce size")
+	}
+	return c.aead.Seal(dst, nonce, plaintext, aad), nil
 }
 
-// Decrypt дешифрует данные (nonce + encrypted_data + tag)
-func (c *Crypto) Decrypt(ciphertext []byte, aad []byte) ([]byte, error) {
+// Open дешифрует данные и дописывает открытый текст к dst, как cipher.AEAD.Open.
+// Если емкости dst хватает, память не выделяется. Для дешифрования на месте dst = ciphertext[:0]
+func (c *Crypto) Open(dst, nonce, ciphertext, aad []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		return nil, errors.New("invalid nonce size")
+	}
 	if len(ciphertext) < Overhead {
 		return nil, errors.New("ciphertext too short")
 	}
-
-	// Извлекаем nonce
-	nonce := ciphertext[:NonceSize]
-	encryptedData := ciphertext[NonceSize:]
-
-	// Дешифруем данные
-	plaintext, err := c.aead.Open(nil, nonce, encryptedData, aad)
-	if err != nil {
-		return nil, err
-	}
-
-	return plaintext, nil
+	return c.aead.Open(dst, nonce, ciphertext, aad)
 }
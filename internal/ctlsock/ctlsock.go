@@ -0,0 +1,95 @@
+// Package ctlsock клиент REST API на управляющем Unix сокете сервера или клиента
+// (-control-socket). Им пользуются vpnctl и команды status бинарников
+package ctlsock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RequestTimeout время на один запрос
+const RequestTimeout = 10 * time.Second
+
+// Client отправляет запросы на управляющий сокет
+type Client struct {
+	http *http.Client
+	peer string
+}
+
+// New создает клиент сокета socket. peer - кто слушает сокет (server, client),
+// для сообщения об ошибке подключения
+func New(socket, peer string) *Client {
+	return &Client{
+		peer: peer,
+		http: &http.Client{
+			Timeout: RequestTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Do отправляет запрос с телом body (JSON, если не nil) и декодирует ответ в out (если не nil).
+// Ответ с ошибкой {"error": "..."} возвращается как ошибка с этим текстом
+func (c *Client) Do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://myvpn"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the %s (is it running with -control-socket?): %w", c.peer, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("%s returned %s", c.peer, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// FormatBytes выводит размер в удобных единицах
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
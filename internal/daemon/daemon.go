@@ -0,0 +1,144 @@
+// Package daemon запускает процесс в фоне (-daemon) и ведет PID файл (-pidfile)
+// для init скриптов систем без systemd
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// StartTimeout сколько родительский процесс ждет готовности фонового. Клиент готов,
+// когда сервер прислал конфигурацию, а сервер может быть недоступен: тогда родитель
+// завершается успешно, а фоновый процесс продолжает подключаться
+const StartTimeout = 30 * time.Second
+
+// envChild отмечает процесс, запущенный Detach, readyFD - дескриптор канала готовности в нем
+const (
+	envChild = "MYVPN_DAEMON_CHILD"
+	readyFD  = 3
+)
+
+var (
+	// child процесс запущен Detach, и дескриптор readyFD - канал готовности
+	child     bool
+	readyOnce sync.Once
+)
+
+// Detach перезапускает текущую программу с теми же аргументами в новой сессии без
+// терминала и ждет, пока она сообщит о готовности (Ready) или завершится.
+// Возвращает true в родительском процессе, который после этого должен выйти,
+// и false в фоновом процессе
+func Detach() (bool, error) {
+	if os.Getenv(envChild) != "" {
+		os.Unsetenv(envChild)
+		child = true
+		return false, nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return false, fmt.Errorf("failed to find executable: %w", err)
+	}
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer null.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), envChild+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, null, null
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return false, fmt.Errorf("failed to start daemon: %w", err)
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	// Фоновый процесс пишет байт, когда готов, а при выходе канал закрывается
+	result := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		if _, err := r.Read(b[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("daemon exited during startup (see -log-file)")
+			}
+			result <- err
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err := <-result:
+		return true, err
+	case <-time.After(StartTimeout):
+		fmt.Fprintf(os.Stderr, "daemon (pid %d) is still starting\n", pid)
+		return true, nil
+	}
+}
+
+// Ready сообщает родительскому процессу Detach, что фоновый процесс запущен.
+// Вне фонового режима ничего не делает
+func Ready() {
+	if !child {
+		return
+	}
+	readyOnce.Do(func() {
+		ready := os.NewFile(readyFD, "daemon-ready")
+		ready.Write([]byte{1})
+		ready.Close()
+	})
+}
+
+// WritePIDFile записывает PID процесса в path и возвращает функцию, которая удаляет файл.
+// Если файл указывает на работающий процесс, возвращает ошибку; файл, оставшийся после
+// аварийного завершения, перезаписывается
+func WritePIDFile(path string) (func(), error) {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && running(pid) {
+			return nil, fmt.Errorf("already running with pid %d (%s)", pid, path)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create PID file directory: %w", err)
+	}
+	// Пишем во временный файл и переименовываем, чтобы init скрипт не прочитал пустой файл
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	pid := os.Getpid()
+	return func() {
+		// Не удаляем файл, который уже перезаписал другой процесс
+		if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) == strconv.Itoa(pid) {
+			os.Remove(path)
+		}
+	}, nil
+}
+
+// running сообщает, существует ли процесс pid
+func running(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,80 @@
+package dnsfwd
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCacheTTL дольше этого ответ не хранится, даже если TTL записей больше
+const maxCacheTTL = time.Hour
+
+// cacheEntry ответ в кэше
+type cacheEntry struct {
+	resp    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// cache ответы вышестоящих серверов до истечения TTL их записей
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]cacheEntry
+}
+
+func newCache(size int) *cache {
+	return &cache{size: size, entries: make(map[string]cacheEntry, size)}
+}
+
+// get возвращает ответ на запрос q с уменьшенными на время хранения TTL (nil - нет в кэше)
+func (c *cache) get(q query) []byte {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[q.key()]
+	if ok && !now.Before(e.expires) {
+		delete(c.entries, q.key())
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return withTTL(e.resp, q.id, uint32(now.Sub(e.stored)/time.Second))
+}
+
+// put сохраняет ответ на запрос q, если у него есть записи с ненулевым TTL
+func (c *cache) put(q query, resp []byte) {
+	ttl, ok := minTTL(resp)
+	if !ok || ttl == 0 {
+		return
+	}
+	now := time.Now()
+	e := cacheEntry{
+		resp:    append([]byte(nil), resp...),
+		stored:  now,
+		expires: now.Add(min(time.Duration(ttl)*time.Second, maxCacheTTL)),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		c.evictLocked(now)
+	}
+	c.entries[q.key()] = e
+}
+
+// evictLocked освобождает место: удаляет истекшие ответы, а если их нет - любой
+func (c *cache) evictLocked(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) < c.size {
+		return
+	}
+	for key := range c.entries {
+		delete(c.entries, key)
+		return
+	}
+}
@@ -0,0 +1,272 @@
+// Package dnsfwd небольшой DNS прокси для клиентов VPN: принимает запросы по UDP и TCP
+// на адресе сервера внутри туннеля и пересылает их вышестоящим серверам (обычный DNS
+// или DNS over HTTPS), по желанию кэшируя ответы. Сервер может передать клиентам резолвер,
+// который доступен только через туннель, без отдельного dnsmasq
+package dnsfwd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"myvpn/internal/logging"
+	"myvpn/internal/metrics"
+)
+
+// Значения по умолчанию
+const (
+	// DefaultTimeout время ожидания ответа одного вышестоящего сервера
+	DefaultTimeout = 3 * time.Second
+	// DefaultCacheSize число ответов в кэше по умолчанию
+	DefaultCacheSize = 1024
+)
+
+const (
+	// maxMessageSize наибольший размер DNS сообщения (TCP, DoH)
+	maxMessageSize = 65535
+	// tcpIdleTimeout TCP соединение клиента закрывается после стольких секунд без запросов
+	tcpIdleTimeout = 10 * time.Second
+)
+
+var logger = logging.For("dns")
+
+// Метрики DNS прокси
+var (
+	metricQueries   = metrics.NewCounter("myvpn_dns_queries_total", "DNS queries received from clients")
+	metricCacheHits = metrics.NewCounter("myvpn_dns_cache_hits_total", "DNS queries answered from the cache")
+	metricFailures  = metrics.NewCounter("myvpn_dns_upstream_failures_total", "DNS queries no upstream server answered")
+)
+
+// Options параметры прокси
+type Options struct {
+	// Upstreams вышестоящие серверы в порядке попыток: host:port (без порта - 53) для
+	// обычного DNS или https://host/path для DNS over HTTPS
+	Upstreams []string
+	// CacheSize сколько ответов хранить в кэше (0 - без кэша)
+	CacheSize int
+	// Timeout время ожидания ответа одного сервера (0 - DefaultTimeout)
+	Timeout time.Duration
+}
+
+// Forwarder DNS прокси
+type Forwarder struct {
+	upstreams []upstream
+	cache     *cache
+	timeout   time.Duration
+
+	mu        sync.Mutex
+	conns     []net.PacketConn
+	listeners []net.Listener
+	clients   map[net.Conn]struct{} // TCP соединения клиентов: закрываются вместе с прокси
+	wg        sync.WaitGroup
+}
+
+// New создает прокси. Сокеты открывает Listen
+func New(opts Options) (*Forwarder, error) {
+	if len(opts.Upstreams) == 0 {
+		return nil, errors.New("no upstream DNS servers")
+	}
+	f := &Forwarder{timeout: opts.Timeout, clients: make(map[net.Conn]struct{})}
+	if f.timeout <= 0 {
+		f.timeout = DefaultTimeout
+	}
+	for _, addr := range opts.Upstreams {
+		u, err := newUpstream(addr, f.timeout)
+		if err != nil {
+			return nil, err
+		}
+		f.upstreams = append(f.upstreams, u)
+	}
+	if opts.CacheSize > 0 {
+		f.cache = newCache(opts.CacheSize)
+	}
+	return f, nil
+}
+
+// Listen принимает запросы по UDP и TCP на адресе addr (host:port)
+func (f *Forwarder) Listen(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	f.mu.Lock()
+	f.conns = append(f.conns, conn)
+	f.listeners = append(f.listeners, ln)
+	f.mu.Unlock()
+
+	f.wg.Add(2)
+	go f.serveUDP(conn)
+	go f.serveTCP(ln)
+	return nil
+}
+
+// Close закрывает сокеты и ждет завершения обработчиков
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	for _, ln := range f.listeners {
+		ln.Close()
+	}
+	for conn := range f.clients {
+		conn.Close()
+	}
+	f.conns, f.listeners = nil, nil
+	f.mu.Unlock()
+	f.wg.Wait()
+	return nil
+}
+
+// serveUDP отвечает на запросы UDP сокета. Каждый запрос обрабатывается в своей горутине:
+// медленный вышестоящий сервер не задерживает остальные запросы
+func (f *Forwarder) serveUDP(conn net.PacketConn) {
+	defer f.wg.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Warn("DNS UDP read failed", logging.Err(err))
+			}
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			resp := f.answer(query)
+			if resp == nil {
+				return
+			}
+			if limit := udpLimit(query); len(resp) > limit {
+				resp = truncated(resp)
+			}
+			conn.WriteTo(resp, addr)
+		}()
+	}
+}
+
+// serveTCP принимает TCP соединения клиентов
+func (f *Forwarder) serveTCP(ln net.Listener) {
+	defer f.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Warn("DNS TCP accept failed", logging.Err(err))
+			}
+			return
+		}
+		f.mu.Lock()
+		f.clients[conn] = struct{}{}
+		f.mu.Unlock()
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.handleTCP(conn)
+			f.mu.Lock()
+			delete(f.clients, conn)
+			f.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handleTCP отвечает на запросы одного TCP соединения (сообщения с 2-байтной длиной)
+func (f *Forwarder) handleTCP(conn net.Conn) {
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		resp := f.answer(query)
+		if resp == nil {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(f.timeout))
+		if err := writeTCPMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// answer возвращает ответ на запрос: из кэша или от первого ответившего вышестоящего
+// сервера. Если не ответил ни один, возвращается SERVFAIL. nil - запрос не разобрать
+func (f *Forwarder) answer(query []byte) []byte {
+	q, ok := parseQuery(query)
+	if !ok {
+		return nil
+	}
+	metricQueries.Inc()
+	if f.cache != nil {
+		if resp := f.cache.get(q); resp != nil {
+			metricCacheHits.Inc()
+			return resp
+		}
+	}
+
+	var lastErr error
+	for _, u := range f.upstreams {
+		resp, err := u.exchange(query)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(resp) < headerSize || binary.BigEndian.Uint16(resp) != q.id {
+			lastErr = fmt.Errorf("%s: response does not match the query", u)
+			continue
+		}
+		if f.cache != nil {
+			f.cache.put(q, resp)
+		}
+		return resp
+	}
+	metricFailures.Inc()
+	if logging.DebugEnabled() {
+		logger.Debug("No upstream DNS server answered", "name", q.name, logging.Err(lastErr))
+	}
+	return serverFailure(query)
+}
+
+// readTCPMessage читает DNS сообщение с 2-байтной длиной
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage записывает DNS сообщение с 2-байтной длиной
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// hostPort добавляет к адресу порт по умолчанию, если его нет
+func hostPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
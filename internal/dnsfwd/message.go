@@ -0,0 +1,170 @@
+package dnsfwd
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// headerSize размер заголовка DNS сообщения
+	headerSize = 12
+	// minUDPSize размер ответа по UDP для клиента без EDNS
+	minUDPSize = 512
+)
+
+// query вопрос запроса: по нему ищется ответ в кэше
+type query struct {
+	id       uint16
+	name     string // в нижнем регистре
+	qtype    dnsmessage.Type
+	class    dnsmessage.Class
+	checking bool // бит CD: клиент сам проверяет DNSSEC, ответ может отличаться
+}
+
+// key ключ кэша
+func (q query) key() string {
+	var b strings.Builder
+	b.WriteString(q.name)
+	b.WriteByte('/')
+	b.WriteString(q.qtype.String())
+	b.WriteByte('/')
+	b.WriteString(q.class.String())
+	if q.checking {
+		b.WriteString("/cd")
+	}
+	return b.String()
+}
+
+// parseQuery разбирает запрос с одним вопросом
+func parseQuery(msg []byte) (query, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || h.Response || h.OpCode != 0 {
+		return query{}, false
+	}
+	questions, err := p.AllQuestions()
+	if err != nil || len(questions) != 1 {
+		return query{}, false
+	}
+	q := questions[0]
+	return query{
+		id:       h.ID,
+		name:     strings.ToLower(q.Name.String()),
+		qtype:    q.Type,
+		class:    q.Class,
+		checking: h.CheckingDisabled,
+	}, true
+}
+
+// udpLimit возвращает наибольший размер ответа, который клиент принимает по UDP:
+// размер из EDNS (OPT) или 512 байт
+func udpLimit(msg []byte) int {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return minUDPSize
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return minUDPSize
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return minUDPSize
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return minUDPSize
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return minUDPSize
+		}
+		if h.Type == dnsmessage.TypeOPT {
+			return max(int(h.Class), minUDPSize)
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return minUDPSize
+		}
+	}
+}
+
+// truncated возвращает ответ без записей с флагом TC: клиент повторит запрос по TCP
+func truncated(resp []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return resp[:headerSize]
+	}
+	questions, _ := p.AllQuestions()
+	h.Truncated = true
+	msg := dnsmessage.Message{Header: h, Questions: questions}
+	out, err := msg.Pack()
+	if err != nil {
+		return resp[:headerSize]
+	}
+	return out
+}
+
+// serverFailure возвращает ответ SERVFAIL на запрос
+func serverFailure(query []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	questions, _ := p.AllQuestions()
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeServerFailure
+	msg := dnsmessage.Message{Header: h, Questions: questions}
+	out, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// minTTL возвращает наименьший TTL записей ответа (кроме OPT). ok = false, если ответ
+// нельзя кэшировать: ошибка сервера, обрезанный ответ или ответ без записей
+func minTTL(resp []byte) (ttl uint32, ok bool) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return 0, false
+	}
+	if msg.Truncated || (msg.RCode != dnsmessage.RCodeSuccess && msg.RCode != dnsmessage.RCodeNameError) {
+		return 0, false
+	}
+	first := true
+	for _, section := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities} {
+		for _, r := range section {
+			if first || r.Header.TTL < ttl {
+				ttl, first = r.Header.TTL, false
+			}
+		}
+	}
+	return ttl, !first
+}
+
+// withTTL возвращает копию ответа с идентификатором id и TTL записей, уменьшенными
+// на elapsed секунд
+func withTTL(resp []byte, id uint16, elapsed uint32) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil
+	}
+	for _, section := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range section {
+			if section[i].Header.Type == dnsmessage.TypeOPT {
+				// В TTL записи OPT расширенный код ответа и флаги
+				continue
+			}
+			section[i].Header.TTL -= min(section[i].Header.TTL, elapsed)
+		}
+	}
+	out, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	binary.BigEndian.PutUint16(out, id)
+	return out
+}
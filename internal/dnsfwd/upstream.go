@@ -0,0 +1,133 @@
+package dnsfwd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dnsMessageType тип содержимого DNS over HTTPS (RFC 8484)
+const dnsMessageType = "application/dns-message"
+
+// upstream вышестоящий DNS сервер
+type upstream interface {
+	exchange(query []byte) ([]byte, error)
+	String() string
+}
+
+// newUpstream создает вышестоящий сервер по адресу: https://... - DNS over HTTPS,
+// иначе обычный DNS (host или host:port)
+func newUpstream(addr string, timeout time.Duration) (upstream, error) {
+	if strings.HasPrefix(addr, "https://") {
+		if _, err := url.Parse(addr); err != nil {
+			return nil, fmt.Errorf("invalid DNS over HTTPS URL %q: %w", addr, err)
+		}
+		return &dohUpstream{url: addr, client: &http.Client{Timeout: timeout}}, nil
+	}
+	addr = hostPort(addr, "53")
+	if _, err := net.ResolveUDPAddr("udp", addr); err != nil {
+		return nil, fmt.Errorf("invalid upstream DNS server %q: %w", addr, err)
+	}
+	return &dnsUpstream{addr: addr, timeout: timeout}, nil
+}
+
+// dnsUpstream обычный DNS: запрос по UDP, по TCP - если ответ обрезан
+type dnsUpstream struct {
+	addr    string
+	timeout time.Duration
+}
+
+func (u *dnsUpstream) String() string { return u.addr }
+
+func (u *dnsUpstream) exchange(query []byte) ([]byte, error) {
+	resp, err := u.exchangeUDP(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) > 2 && resp[2]&0x02 != 0 {
+		// TC: ответ не поместился в датаграмму
+		return u.exchangeTCP(query)
+	}
+	return resp, nil
+}
+
+func (u *dnsUpstream) exchangeUDP(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", u.addr, u.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(u.timeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Датаграммы с чужим идентификатором (запоздавшие или поддельные) пропускаются
+		if n >= headerSize && binary.BigEndian.Uint16(buf) == binary.BigEndian.Uint16(query) {
+			return buf[:n], nil
+		}
+	}
+}
+
+func (u *dnsUpstream) exchangeTCP(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", u.addr, u.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(u.timeout))
+	if err := writeTCPMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+// dohUpstream DNS over HTTPS (RFC 8484, POST)
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *dohUpstream) String() string { return u.url }
+
+func (u *dohUpstream) exchange(query []byte) ([]byte, error) {
+	// Идентификатор 0 рекомендован для DoH: одинаковые запросы кэшируются HTTP кэшами
+	id := binary.BigEndian.Uint16(query)
+	body := append([]byte(nil), query...)
+	binary.BigEndian.PutUint16(body, 0)
+
+	req, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+	httpResp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP status %s", u.url, httpResp.Status)
+	}
+	resp, err := io.ReadAll(io.LimitReader(httpResp.Body, maxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < headerSize {
+		return nil, fmt.Errorf("%s: short response", u.url)
+	}
+	binary.BigEndian.PutUint16(resp, id)
+	return resp, nil
+}
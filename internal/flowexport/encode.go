@@ -0,0 +1,211 @@
+package flowexport
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// maxMessageSize размер сообщения экспорта, чтобы оно не фрагментировалось
+const maxMessageSize = 1400
+
+// templateInterval период повторной отправки шаблонов: коллектор, запущенный позже
+// экспортера, иначе не сможет разобрать записи
+const templateInterval = time.Minute
+
+// Шаблоны записей
+const (
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+// Information elements (номера полей совпадают в NetFlow v9 и IPFIX)
+const (
+	fieldBytes       = 1
+	fieldPackets     = 2
+	fieldProtocol    = 4
+	fieldSrcPort     = 7
+	fieldSrcIPv4     = 8
+	fieldDstPort     = 11
+	fieldDstIPv4     = 12
+	fieldLastUptime  = 21 // NetFlow v9 LAST_SWITCHED, мс от запуска
+	fieldFirstUptime = 22 // NetFlow v9 FIRST_SWITCHED
+	fieldSrcIPv6     = 27
+	fieldDstIPv6     = 28
+	fieldDirection   = 61  // 0 - ingress (от клиента), 1 - egress (к клиенту)
+	fieldStartMs     = 152 // IPFIX flowStartMilliseconds
+	fieldEndMs       = 153 // IPFIX flowEndMilliseconds
+)
+
+type field struct {
+	id, size uint16
+}
+
+// encoder собирает сообщения NetFlow v9 или IPFIX. Используется из одной горутины
+type encoder struct {
+	ipfix        bool
+	domainID     uint32
+	started      time.Time
+	sequence     uint32 // NetFlow v9: номер сообщения, IPFIX: число отправленных записей
+	templateSent time.Time
+	templates    map[uint16][]field
+}
+
+func newEncoder(protocol string, domainID uint32) *encoder {
+	ipfix := protocol == ProtocolIPFIX
+	times := []field{{fieldFirstUptime, 4}, {fieldLastUptime, 4}}
+	if ipfix {
+		times = []field{{fieldStartMs, 8}, {fieldEndMs, 8}}
+	}
+	common := []field{
+		{fieldSrcPort, 2}, {fieldDstPort, 2}, {fieldProtocol, 1}, {fieldDirection, 1},
+		{fieldBytes, 8}, {fieldPackets, 8},
+	}
+	v4 := append([]field{{fieldSrcIPv4, 4}, {fieldDstIPv4, 4}}, common...)
+	v6 := append([]field{{fieldSrcIPv6, 16}, {fieldDstIPv6, 16}}, common...)
+	return &encoder{
+		ipfix:    ipfix,
+		domainID: domainID,
+		started:  time.Now(),
+		templates: map[uint16][]field{
+			templateIPv4: append(v4, times...),
+			templateIPv6: append(v6, times...),
+		},
+	}
+}
+
+// templateDue сообщает, что пора повторить шаблоны
+func (e *encoder) templateDue(now time.Time) bool {
+	return now.Sub(e.templateSent) >= templateInterval
+}
+
+// encode собирает одно сообщение из первых записей records и возвращает его
+// и число вошедших записей. Шаблоны добавляются, если их пора повторить
+func (e *encoder) encode(records []record, now time.Time) ([]byte, int) {
+	headerSize := 20
+	if e.ipfix {
+		headerSize = 16
+	}
+	msg := make([]byte, headerSize, maxMessageSize)
+	count := 0 // NetFlow v9 считает в заголовке и записи шаблонов
+
+	if e.templateDue(now) {
+		msg, count = e.appendTemplates(msg), len(e.templates)
+		e.templateSent = now
+	}
+
+	// Записи группируются в наборы по шаблону: IPv4, затем IPv6
+	n := 0
+	for _, id := range []uint16{templateIPv4, templateIPv6} {
+		size := e.recordSize(id)
+		setStart := -1
+		for i := n; i < len(records); i++ {
+			if templateOf(records[i].key) != id {
+				continue
+			}
+			if setStart < 0 {
+				if len(msg)+4+size > maxMessageSize {
+					break
+				}
+				setStart = len(msg)
+				msg = append(msg, 0, 0, 0, 0)
+			} else if len(msg)+size > maxMessageSize {
+				break
+			}
+			msg = e.appendRecord(msg, records[i])
+			// Переставляем вошедшую запись в начало, чтобы вернуть их число
+			records[n], records[i] = records[i], records[n]
+			n++
+			count++
+		}
+		if setStart >= 0 {
+			binary.BigEndian.PutUint16(msg[setStart:], id)
+			binary.BigEndian.PutUint16(msg[setStart+2:], uint16(len(msg)-setStart))
+		}
+	}
+
+	if e.ipfix {
+		binary.BigEndian.PutUint16(msg[0:], 10)
+		binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+		binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[8:], e.sequence)
+		binary.BigEndian.PutUint32(msg[12:], e.domainID)
+		e.sequence += uint32(n)
+	} else {
+		binary.BigEndian.PutUint16(msg[0:], 9)
+		binary.BigEndian.PutUint16(msg[2:], uint16(count))
+		binary.BigEndian.PutUint32(msg[4:], e.uptime(now))
+		binary.BigEndian.PutUint32(msg[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[12:], e.sequence)
+		binary.BigEndian.PutUint32(msg[16:], e.domainID)
+		e.sequence++
+	}
+	return msg, n
+}
+
+func (e *encoder) appendTemplates(msg []byte) []byte {
+	setID := uint16(0)
+	if e.ipfix {
+		setID = 2
+	}
+	start := len(msg)
+	msg = binary.BigEndian.AppendUint16(msg, setID)
+	msg = append(msg, 0, 0)
+	for _, id := range []uint16{templateIPv4, templateIPv6} {
+		fields := e.templates[id]
+		msg = binary.BigEndian.AppendUint16(msg, id)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(fields)))
+		for _, f := range fields {
+			msg = binary.BigEndian.AppendUint16(msg, f.id)
+			msg = binary.BigEndian.AppendUint16(msg, f.size)
+		}
+	}
+	binary.BigEndian.PutUint16(msg[start+2:], uint16(len(msg)-start))
+	return msg
+}
+
+func (e *encoder) appendRecord(msg []byte, r record) []byte {
+	if r.key.ipv6 {
+		msg = append(msg, r.key.src[:]...)
+		msg = append(msg, r.key.dst[:]...)
+	} else {
+		msg = append(msg, r.key.src[:4]...)
+		msg = append(msg, r.key.dst[:4]...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, r.key.sport)
+	msg = binary.BigEndian.AppendUint16(msg, r.key.dport)
+	var direction byte
+	if r.key.egress {
+		direction = 1
+	}
+	msg = append(msg, r.key.proto, direction)
+	msg = binary.BigEndian.AppendUint64(msg, r.bytes)
+	msg = binary.BigEndian.AppendUint64(msg, r.packets)
+	if e.ipfix {
+		msg = binary.BigEndian.AppendUint64(msg, uint64(r.first.UnixMilli()))
+		msg = binary.BigEndian.AppendUint64(msg, uint64(r.last.UnixMilli()))
+	} else {
+		msg = binary.BigEndian.AppendUint32(msg, e.uptime(r.first))
+		msg = binary.BigEndian.AppendUint32(msg, e.uptime(r.last))
+	}
+	return msg
+}
+
+func (e *encoder) recordSize(id uint16) int {
+	size := 0
+	for _, f := range e.templates[id] {
+		size += int(f.size)
+	}
+	return size
+}
+
+// uptime время в мс от запуска экспортера (sysUptime NetFlow v9)
+func (e *encoder) uptime(t time.Time) uint32 {
+	return uint32(t.Sub(e.started).Milliseconds())
+}
+
+func templateOf(key flowKey) uint16 {
+	if key.ipv6 {
+		return templateIPv6
+	}
+	return templateIPv4
+}
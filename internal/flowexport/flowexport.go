@@ -0,0 +1,262 @@
+// Package flowexport собирает внутренние IP пакеты клиентов в потоки (5-tuple и направление)
+// и экспортирует их коллектору по NetFlow v9 или IPFIX
+package flowexport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"myvpn/internal/logging"
+	"myvpn/internal/metrics"
+)
+
+// Протоколы экспорта
+const (
+	ProtocolNetFlow9 = "netflow9"
+	ProtocolIPFIX    = "ipfix"
+)
+
+// Значения по умолчанию
+const (
+	// DefaultActiveTimeout длинный поток экспортируется частями не реже этого интервала
+	DefaultActiveTimeout = time.Minute
+	// DefaultIdleTimeout поток без пакетов дольше этого времени считается завершенным
+	DefaultIdleTimeout = 15 * time.Second
+	// MaxFlows максимальное число одновременно отслеживаемых потоков. Пакеты новых потоков
+	// сверх него не учитываются, чтобы поток мусорных пакетов не занял всю память
+	MaxFlows = 1 << 16
+)
+
+// scanInterval период проверки таймаутов потоков
+const scanInterval = time.Second
+
+var logger = logging.For("flow")
+
+// Метрики экспорта потоков
+var (
+	metricExported = metrics.NewCounter("myvpn_flow_records_exported_total", "Flow records sent to the collector")
+	metricErrors   = metrics.NewCounter("myvpn_flow_export_errors_total", "Flow export packets that could not be sent to the collector")
+	metricDropped  = metrics.NewCounter("myvpn_flow_table_full_drops_total", "Packets not accounted because the flow table was full")
+	metricActive   = metrics.NewGauge("myvpn_flow_active", "Flows currently tracked")
+)
+
+// Options параметры экспорта
+type Options struct {
+	// Collector адрес коллектора (host:port, UDP)
+	Collector string
+	// Protocol ProtocolNetFlow9 или ProtocolIPFIX (пустая строка - IPFIX)
+	Protocol string
+	// ActiveTimeout и IdleTimeout таймауты потоков (0 - значения по умолчанию)
+	ActiveTimeout time.Duration
+	IdleTimeout   time.Duration
+	// DomainID Source ID NetFlow v9 или Observation Domain ID IPFIX
+	DomainID uint32
+}
+
+// flowKey поток: 5-tuple внутреннего пакета и направление
+type flowKey struct {
+	src, dst [16]byte
+	sport    uint16
+	dport    uint16
+	proto    uint8
+	ipv6     bool
+	egress   bool // от сервера к клиенту (пакет прочитан из TUN)
+}
+
+// flow счетчики потока
+type flow struct {
+	packets uint64
+	bytes   uint64
+	first   time.Time
+	last    time.Time
+}
+
+// record завершенный (или выгружаемый по active timeout) поток
+type record struct {
+	key flowKey
+	flow
+}
+
+// Exporter учет потоков и их экспорт. Record безопасен для вызова из нескольких горутин
+type Exporter struct {
+	conn    *net.UDPConn
+	encoder *encoder
+	active  time.Duration
+	idle    time.Duration
+
+	mu    sync.Mutex
+	flows map[flowKey]*flow
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New подключается к коллектору и запускает экспорт потоков
+func New(opts Options) (*Exporter, error) {
+	protocol := strings.ToLower(opts.Protocol)
+	switch protocol {
+	case "":
+		protocol = ProtocolIPFIX
+	case ProtocolNetFlow9, ProtocolIPFIX:
+	default:
+		return nil, fmt.Errorf("unknown flow export protocol %q (netflow9, ipfix)", opts.Protocol)
+	}
+	addr, err := net.ResolveUDPAddr("udp", opts.Collector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flow collector address: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to flow collector: %w", err)
+	}
+	e := &Exporter{
+		conn:    conn,
+		encoder: newEncoder(protocol, opts.DomainID),
+		active:  opts.ActiveTimeout,
+		idle:    opts.IdleTimeout,
+		flows:   make(map[flowKey]*flow),
+		done:    make(chan struct{}),
+	}
+	if e.active <= 0 {
+		e.active = DefaultActiveTimeout
+	}
+	if e.idle <= 0 {
+		e.idle = DefaultIdleTimeout
+	}
+	e.wg.Add(1)
+	go e.run()
+	logger.Info("Flow export started", "collector", addr.String(), "protocol", protocol)
+	return e, nil
+}
+
+// Record учитывает внутренний IP пакет. egress - пакет идет к клиенту, иначе от клиента
+func (e *Exporter) Record(packet []byte, egress bool) {
+	key, ok := parseKey(packet)
+	if !ok {
+		return
+	}
+	key.egress = egress
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	f := e.flows[key]
+	if f == nil {
+		if len(e.flows) >= MaxFlows {
+			metricDropped.Inc()
+			return
+		}
+		f = &flow{first: now}
+		e.flows[key] = f
+		metricActive.Set(int64(len(e.flows)))
+	}
+	f.packets++
+	f.bytes += uint64(len(packet))
+	f.last = now
+}
+
+// Close экспортирует все текущие потоки и закрывает соединение с коллектором
+func (e *Exporter) Close() error {
+	close(e.done)
+	e.wg.Wait()
+	e.export(e.expire(time.Now(), true))
+	return e.conn.Close()
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(scanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case now := <-ticker.C:
+			e.export(e.expire(now, false))
+		}
+	}
+}
+
+// expire забирает из таблицы потоки, которые закончились по idle или active timeout
+// (все потоки при all)
+func (e *Exporter) expire(now time.Time, all bool) []record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var expired []record
+	for key, f := range e.flows {
+		if all || now.Sub(f.last) >= e.idle || now.Sub(f.first) >= e.active {
+			expired = append(expired, record{key: key, flow: *f})
+			delete(e.flows, key)
+		}
+	}
+	metricActive.Set(int64(len(e.flows)))
+	return expired
+}
+
+// export отправляет потоки коллектору
+func (e *Exporter) export(records []record) {
+	for len(records) > 0 || e.encoder.templateDue(time.Now()) {
+		msg, n := e.encoder.encode(records, time.Now())
+		records = records[n:]
+		if _, err := e.conn.Write(msg); err != nil {
+			metricErrors.Inc()
+			if logging.DebugEnabled() {
+				logger.Debug("Failed to send flow records", logging.Err(err))
+			}
+			continue
+		}
+		metricExported.Add(uint64(n))
+	}
+}
+
+// parseKey извлекает 5-tuple из IPv4 или IPv6 пакета. У TCP, UDP и SCTP берутся порты,
+// у ICMP - тип и код в порту назначения, как принято в NetFlow
+func parseKey(packet []byte) (flowKey, bool) {
+	var key flowKey
+	var l4 []byte
+	if len(packet) < 1 {
+		return key, false
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0x0f) * 4
+		if len(packet) < 20 || ihl < 20 || len(packet) < ihl {
+			return key, false
+		}
+		key.proto = packet[9]
+		copy(key.src[:4], packet[12:16])
+		copy(key.dst[:4], packet[16:20])
+		// Порты есть только в первом фрагменте
+		if binary.BigEndian.Uint16(packet[6:8])&0x1fff == 0 {
+			l4 = packet[ihl:]
+		}
+	case 6:
+		if len(packet) < 40 {
+			return key, false
+		}
+		key.ipv6 = true
+		key.proto = packet[6]
+		copy(key.src[:], packet[8:24])
+		copy(key.dst[:], packet[24:40])
+		l4 = packet[40:]
+	default:
+		return key, false
+	}
+
+	switch key.proto {
+	case 6, 17, 132:
+		if len(l4) >= 4 {
+			key.sport = binary.BigEndian.Uint16(l4[0:2])
+			key.dport = binary.BigEndian.Uint16(l4[2:4])
+		}
+	case 1, 58:
+		if len(l4) >= 2 {
+			key.dport = uint16(l4[0])<<8 | uint16(l4[1])
+		}
+	}
+	return key, true
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// PublicKeySize размер открытого и закрытого ключа X25519
+const PublicKeySize = 32
+
+// staticKeyInfo контекст вывода ключа сессии из общего секрета X25519
+const staticKeyInfo = "myvpn static key v1"
+
+// GeneratePrivateKey создает закрытый ключ X25519
+func GeneratePrivateKey() ([]byte, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	return key.Bytes(), nil
+}
+
+// PublicKey возвращает открытый ключ X25519 для закрытого ключа
+func PublicKey(private []byte) ([]byte, error) {
+	key, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return key.PublicKey().Bytes(), nil
+}
+
+// StaticKey выводит симметричный ключ пары клиент-сервер из закрытого ключа одной стороны
+// и открытого ключа другой (X25519 + HKDF-SHA256). Обе стороны получают один и тот же ключ;
+// server сообщает, чей закрытый ключ передан, чтобы открытые ключи вошли в вывод в одном порядке
+func StaticKey(private, peerPublic []byte, server bool) ([]byte, error) {
+	curve := ecdh.X25519()
+	priv, err := curve.NewPrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	pub, err := curve.NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+
+	serverPub, clientPub := priv.PublicKey().Bytes(), peerPublic
+	if !server {
+		serverPub, clientPub = clientPub, serverPub
+	}
+	info := staticKeyInfo + string(serverPub) + string(clientPub)
+	return hkdf.Key(sha256.New, secret, nil, info, KeySize)
+}
+
+// ParseKey разбирает ключ X25519 в base64 (как у WireGuard) или hex
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != PublicKeySize {
+		key, err = hex.DecodeString(s)
+	}
+	if err != nil || len(key) != PublicKeySize {
+		return nil, fmt.Errorf("invalid key: expected %d bytes in base64 or hex", PublicKeySize)
+	}
+	return key, nil
+}
+
+// ParseSharedKey разбирает общий ключ шифрования (-key): 32 байта в бинарном виде,
+// 64 hex символа или base64. Пробелы и перевод строки вокруг текстовых форм пропускаются
+func ParseSharedKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+	s := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid key: expected %d bytes in binary, hex or base64, got %d bytes", KeySize, len(data))
+}
+
+// FormatKey кодирует ключ X25519 в base64
+func FormatKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
@@ -0,0 +1,141 @@
+// Package logging настраивает журнал сервера и клиента поверх log/slog: уровень,
+// формат (text или json) и логгеры подсистем с атрибутом subsystem
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Подсистемы, которые используют оба бинарника
+const (
+	Transport = "transport"
+	TUN       = "tun"
+	Crypto    = "crypto"
+	NetMgr    = "netmgr"
+)
+
+// Форматы журнала
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	level   slog.LevelVar
+	current atomic.Pointer[slog.Handler]
+)
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(log.Writer(), &slog.HandlerOptions{Level: &level})
+	current.Store(&h)
+}
+
+// ParseLevel разбирает уровень: debug, info, warn или error
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (debug, info, warn, error)", s)
+	}
+	return l, nil
+}
+
+// Setup задает уровень и формат журнала и направляет в него стандартный log.
+// Логгеры подсистем, созданные до вызова, тоже пишут по новым настройкам
+func Setup(w io.Writer, lvl, format string) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: &level}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (text, json)", format)
+	}
+	level.Set(l)
+	current.Store(&h)
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// SetLevel меняет уровень журнала без перезапуска (SIGHUP)
+func SetLevel(lvl string) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level возвращает текущий уровень журнала
+func Level() slog.Level {
+	return level.Level()
+}
+
+// For возвращает логгер подсистемы
+func For(subsystem string) *slog.Logger {
+	return slog.New(&handler{attrs: []slog.Attr{slog.String("subsystem", subsystem)}})
+}
+
+// DebugEnabled сообщает, что включен уровень debug. Нужен в горячих путях, чтобы
+// не собирать атрибуты записи, которая не попадет в журнал
+func DebugEnabled() bool {
+	return level.Level() <= slog.LevelDebug
+}
+
+// Fatal пишет ошибку в журнал и завершает процесс
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Session атрибут с ID сессии в том же виде, что и в admin API
+func Session(id uint64) slog.Attr {
+	return slog.String("session", fmt.Sprintf("%016x", id))
+}
+
+// Err атрибут с ошибкой
+func Err(err error) slog.Attr {
+	return slog.Any("err", err)
+}
+
+// handler передает записи текущему обработчику из Setup, добавляя атрибуты логгера.
+// Группы не поддерживаются: они не используются в проекте
+type handler struct {
+	attrs []slog.Attr
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	// Атрибуты логгера (subsystem) идут перед атрибутами записи, как у slog.Logger.With
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	out.AddAttrs(h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(a)
+		return true
+	})
+	return (*current.Load()).Handle(ctx, out)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *handler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Значения по умолчанию для ротации файла журнала
+const (
+	DefaultMaxSize    = 100 << 20
+	DefaultMaxAge     = 24 * time.Hour
+	DefaultMaxBackups = 7
+)
+
+// backupTimeFormat суффикс имени старого файла. Сортируется по времени как строка
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingFile файл журнала, который переименовывается в path.ВРЕМЯ и открывается заново,
+// когда превышает maxSize байт или становится старше maxAge. Хранится не больше maxBackups
+// старых файлов. Нулевые ограничения отключают соответствующую проверку
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+// OpenRotating открывает файл журнала на дозапись, создавая его при необходимости
+func OpenRotating(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write пишет запись в файл, перед этим меняя файл, если он достиг ограничений.
+// Запись не разбивается между файлами
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.due(len(p)) {
+		if err := f.rotate(); err != nil {
+			// Продолжаем писать в старый файл: потерять журнал хуже, чем превысить размер
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close закрывает файл
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// due сообщает, что перед записью n байт файл пора сменить
+func (f *RotatingFile) due(n int) bool {
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && time.Since(f.opened) >= f.maxAge
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// rotate переименовывает текущий файл, открывает новый и удаляет лишние старые файлы.
+// Старый файл закрывается, только когда новый открыт
+func (f *RotatingFile) rotate() error {
+	base := f.path + "." + time.Now().Format(backupTimeFormat)
+	backup := base
+	for i := 1; fileExists(backup); i++ {
+		backup = fmt.Sprintf("%s-%d", base, i)
+	}
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return f.prune()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// prune удаляет самые старые файлы сверх maxBackups
+func (f *RotatingFile) prune() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.path + ".[0-9]*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-f.maxBackups] {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
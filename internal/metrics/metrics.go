@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Collector пишет произвольные метрики в текстовом формате Prometheus.
+// Используется для метрик с метками (например, по клиентам), которые
+// удобнее собирать в момент запроса
+type Collector func(w io.Writer)
+
+// metric общий интерфейс зарегистрированных метрик
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]metric)
+	collectors []Collector
+)
+
+func register(name string, m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic("metrics: duplicate metric " + name)
+	}
+	registry[name] = m
+}
+
+// Counter монотонно растущий счетчик
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+// NewCounter создает и регистрирует счетчик
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(name, c)
+	return c
+}
+
+// Inc увеличивает счетчик на 1
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add увеличивает счетчик на n
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Load возвращает текущее значение
+func (c *Counter) Load() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) write(w io.Writer) {
+	WriteHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.value.Load())
+}
+
+// Gauge значение, которое может расти и уменьшаться
+type Gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// NewGauge создает и регистрирует gauge
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(name, g)
+	return g
+}
+
+// Set устанавливает значение
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Add изменяет значение на delta
+func (g *Gauge) Add(delta int64) {
+	g.value.Add(delta)
+}
+
+// Load возвращает текущее значение
+func (g *Gauge) Load() int64 {
+	return g.value.Load()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	WriteHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", g.name, g.value.Load())
+}
+
+// gaugeFunc gauge, значение которого вычисляется в момент запроса
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc регистрирует gauge, значение которого возвращает fn
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(name, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	WriteHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %g\n", g.name, g.fn())
+}
+
+// RegisterCollector добавляет Collector, который вызывается при каждом запросе метрик
+func RegisterCollector(c Collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	collectors = append(collectors, c)
+}
+
+// WriteHeader пишет строки HELP и TYPE для метрики
+func WriteHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// Labels форматирует метки в виде {k1="v1",k2="v2"}. Аргументы - пары ключ, значение
+func Labels(kv ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", kv[i], kv[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// WritePrometheus пишет все зарегистрированные метрики в текстовом формате Prometheus
+func WritePrometheus(w io.Writer) {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	extra := append([]Collector(nil), collectors...)
+	registryMu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+	for _, c := range extra {
+		c(w)
+	}
+}
+
+// Handler возвращает HTTP обработчик, отдающий метрики
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w)
+	})
+}
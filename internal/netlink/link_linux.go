@@ -0,0 +1,122 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// linkIndex возвращает индекс интерфейса по имени
+func linkIndex(dev string) (int, error) {
+	iface, err := net.InterfaceByName(dev)
+	if err != nil {
+		return 0, err
+	}
+	return iface.Index, nil
+}
+
+// ifInfomsg заголовок сообщений RTM_*LINK
+func ifInfomsg(index int, flags, change uint32) []byte {
+	b := []byte{unix.AF_UNSPEC, 0, 0, 0}
+	b = binary.NativeEndian.AppendUint32(b, uint32(index))
+	b = binary.NativeEndian.AppendUint32(b, flags)
+	return binary.NativeEndian.AppendUint32(b, change)
+}
+
+// ifAddrmsg заголовок сообщений RTM_*ADDR
+func ifAddrmsg(family, prefixLen, flags uint8, index int) []byte {
+	b := []byte{family, prefixLen, flags, 0}
+	return binary.NativeEndian.AppendUint32(b, uint32(index))
+}
+
+// LinkSetMTU меняет MTU интерфейса dev
+func LinkSetMTU(dev string, mtu int) error {
+	index, err := linkIndex(dev)
+	if err != nil {
+		return err
+	}
+	req := newRequest(unix.RTM_NEWLINK, 0, ifInfomsg(index, 0, 0))
+	req.addUint32(unix.IFLA_MTU, uint32(mtu))
+	_, err = req.execute()
+	return err
+}
+
+// LinkSetUp поднимает интерфейс dev
+func LinkSetUp(dev string) error {
+	index, err := linkIndex(dev)
+	if err != nil {
+		return err
+	}
+	_, err = newRequest(unix.RTM_NEWLINK, 0, ifInfomsg(index, unix.IFF_UP, unix.IFF_UP)).execute()
+	return err
+}
+
+// LinkSetNs переносит интерфейс dev в сетевое пространство имен, открытое как nsFD.
+// Ядро опускает интерфейс и снимает с него адреса
+func LinkSetNs(dev string, nsFD int) error {
+	index, err := linkIndex(dev)
+	if err != nil {
+		return err
+	}
+	req := newRequest(unix.RTM_NEWLINK, 0, ifInfomsg(index, 0, 0))
+	req.addUint32(unix.IFLA_NET_NS_FD, uint32(nsFD))
+	_, err = req.execute()
+	return err
+}
+
+// AddrAdd добавляет интерфейсу dev адрес с длиной префикса (10.0.0.1/24). IPv6 адрес
+// добавляется без DAD: на TUN нет соседей, проверка только задержала бы адрес
+func AddrAdd(dev string, prefix netip.Prefix) error {
+	index, err := linkIndex(dev)
+	if err != nil {
+		return err
+	}
+	addr := prefix.Addr().Unmap()
+	var flags uint8
+	if addr.Is6() {
+		flags = unix.IFA_F_NODAD
+	}
+	req := newRequest(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, ifAddrmsg(family(addr.Is6()), uint8(prefix.Bits()), flags, index))
+	req.addAttr(unix.IFA_LOCAL, addr.AsSlice())
+	req.addAttr(unix.IFA_ADDRESS, addr.AsSlice())
+	_, err = req.execute()
+	return err
+}
+
+// AddrFlush удаляет все адреса интерфейса dev
+func AddrFlush(dev string) error {
+	index, err := linkIndex(dev)
+	if err != nil {
+		return err
+	}
+	msgs, err := newRequest(unix.RTM_GETADDR, unix.NLM_F_DUMP, ifAddrmsg(unix.AF_UNSPEC, 0, 0, 0)).execute()
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWADDR || len(m.Data) < unix.SizeofIfAddrmsg ||
+			int(binary.NativeEndian.Uint32(m.Data[4:])) != index {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return err
+		}
+		req := newRequest(unix.RTM_DELADDR, 0, append([]byte(nil), m.Data[:unix.SizeofIfAddrmsg]...))
+		for _, a := range attrs {
+			if a.Attr.Type == unix.IFA_LOCAL || a.Attr.Type == unix.IFA_ADDRESS {
+				req.addAttr(int(a.Attr.Type), a.Value)
+			}
+		}
+		// Вместе с основным IPv4 адресом ядро удаляет дополнительные из той же подсети
+		if _, err := req.execute(); err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+			return fmt.Errorf("failed to delete address: %w", err)
+		}
+	}
+	return nil
+}
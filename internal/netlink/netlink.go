@@ -0,0 +1,9 @@
+// Package netlink настраивает интерфейсы, адреса, маршруты и правила маршрутизации
+// через rtnetlink, без утилиты ip из iproute2: сервер и клиент работают в минимальных
+// контейнерах, а ошибки ядра возвращаются как syscall.Errno (например, маршрут, который
+// уже есть, дает ошибку, для которой errors.Is(err, os.ErrExist))
+//
+// На других системах функции возвращают ошибку errors.ErrUnsupported: там интерфейс
+// и маршруты настраивает система (например, NetworkExtension на iOS), а типы Route и Rule
+// остаются общими
+package netlink
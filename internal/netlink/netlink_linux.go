@@ -0,0 +1,118 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// seq номер последнего запроса: по нему ответы отличаются от чужих сообщений
+var seq atomic.Uint32
+
+// request сообщение rtnetlink: заголовок семейства (rtmsg, ifaddrmsg и т.д.) и атрибуты
+type request struct {
+	typ   uint16
+	flags uint16
+	data  []byte
+}
+
+func newRequest(typ, flags int, header []byte) *request {
+	return &request{typ: uint16(typ), flags: uint16(flags), data: header}
+}
+
+// addAttr добавляет атрибут, выравнивая его до 4 байт
+func (r *request) addAttr(typ int, value []byte) {
+	r.data = binary.NativeEndian.AppendUint16(r.data, uint16(unix.SizeofRtAttr+len(value)))
+	r.data = binary.NativeEndian.AppendUint16(r.data, uint16(typ))
+	r.data = append(r.data, value...)
+	for len(r.data)%unix.NLMSG_ALIGNTO != 0 {
+		r.data = append(r.data, 0)
+	}
+}
+
+func (r *request) addUint32(typ int, value uint32) {
+	r.addAttr(typ, binary.NativeEndian.AppendUint32(nil, value))
+}
+
+// execute отправляет запрос и возвращает ответные сообщения. Запрос с NLM_F_DUMP читается
+// до NLMSG_DONE, остальные - до подтверждения или ошибки ядра
+func (r *request) execute() ([]syscall.NetlinkMessage, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// NLM_F_DUMP = NLM_F_ROOT|NLM_F_MATCH, а NLM_F_MATCH совпадает с NLM_F_EXCL
+	dump := r.flags&unix.NLM_F_DUMP == unix.NLM_F_DUMP
+	flags := r.flags | unix.NLM_F_REQUEST
+	if !dump {
+		flags |= unix.NLM_F_ACK
+	}
+	n := seq.Add(1)
+	msg := make([]byte, 0, unix.NLMSG_HDRLEN+len(r.data))
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(unix.NLMSG_HDRLEN+len(r.data)))
+	msg = binary.NativeEndian.AppendUint16(msg, r.typ)
+	msg = binary.NativeEndian.AppendUint16(msg, flags)
+	msg = binary.NativeEndian.AppendUint32(msg, n)
+	msg = binary.NativeEndian.AppendUint32(msg, 0)
+	msg = append(msg, r.data...)
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var replies []syscall.NetlinkMessage
+	buf := make([]byte, 32*1024)
+	for {
+		size, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:size])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != n {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return replies, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("short netlink error message")
+				}
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+					return nil, syscall.Errno(errno)
+				}
+				return replies, nil
+			}
+			// Копия: буфер переиспользуется следующим чтением
+			m.Data = append([]byte(nil), m.Data...)
+			replies = append(replies, m)
+		}
+	}
+}
+
+// family возвращает семейство адресов
+func family(ipv6 bool) uint8 {
+	if ipv6 {
+		return unix.AF_INET6
+	}
+	return unix.AF_INET
+}
+
+// attrAddr возвращает адрес из значения атрибута
+func attrAddr(value []byte) netip.Addr {
+	addr, _ := netip.AddrFromSlice(value)
+	return addr
+}
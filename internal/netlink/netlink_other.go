@@ -0,0 +1,33 @@
+//go:build !linux
+
+package netlink
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// Операции netlink есть только в Linux: в остальных системах все они возвращают
+// errors.ErrUnsupported
+
+func LinkSetMTU(dev string, mtu int) error { return errors.ErrUnsupported }
+
+func LinkSetUp(dev string) error { return errors.ErrUnsupported }
+
+func LinkSetNs(dev string, nsFD int) error { return errors.ErrUnsupported }
+
+func AddrAdd(dev string, prefix netip.Prefix) error { return errors.ErrUnsupported }
+
+func AddrFlush(dev string) error { return errors.ErrUnsupported }
+
+func RouteAdd(r Route) error { return errors.ErrUnsupported }
+
+func RouteDel(r Route) error { return errors.ErrUnsupported }
+
+func DefaultRoutes(ipv6 bool) ([]Route, error) { return nil, errors.ErrUnsupported }
+
+func RouteGet(dst netip.Addr) (Route, error) { return Route{}, errors.ErrUnsupported }
+
+func RuleAdd(r Rule) error { return errors.ErrUnsupported }
+
+func RuleDel(r Rule) error { return errors.ErrUnsupported }
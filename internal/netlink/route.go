@@ -0,0 +1,86 @@
+package netlink
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ErrNoRoute маршрута нет в таблице
+var ErrNoRoute = errors.New("no route found")
+
+// Route маршрут
+type Route struct {
+	Dst     netip.Prefix // сеть назначения (длина префикса 0 - default)
+	Gateway netip.Addr   // шлюз (пустой - маршрут прямо в интерфейс)
+	Dev     string       // интерфейс
+	Table   uint32       // таблица маршрутов (0 - main)
+	Metric  uint32
+	Throw   bool // маршрут throw: поиск продолжается со следующего правила `ip rule`
+}
+
+// IPv6 возвращает true для маршрута IPv6
+func (r Route) IPv6() bool {
+	return r.Dst.Addr().Is6()
+}
+
+// String возвращает маршрут в синтаксисе `ip route`
+func (r Route) String() string {
+	var parts []string
+	if r.Throw {
+		parts = append(parts, "throw")
+	}
+	if r.Dst.Bits() == 0 {
+		parts = append(parts, "default")
+	} else {
+		parts = append(parts, r.Dst.String())
+	}
+	if r.Gateway.IsValid() {
+		parts = append(parts, "via", r.Gateway.String())
+	}
+	if r.Dev != "" {
+		parts = append(parts, "dev", r.Dev)
+	}
+	if r.Table != 0 {
+		parts = append(parts, "table", fmt.Sprint(r.Table))
+	}
+	if r.Metric != 0 {
+		parts = append(parts, "metric", fmt.Sprint(r.Metric))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Rule правило выбора таблицы маршрутов (`ip rule`)
+type Rule struct {
+	IPv6              bool
+	Priority          uint32
+	Table             uint32 // 0 - main
+	Mark              uint32 // fwmark пакета (0 - без условия на метку)
+	Invert            bool   // правило для пакетов, не подходящих под условия ("not")
+	SuppressPrefixLen int    // suppress_prefixlength (меньше 0 - не задан)
+}
+
+// String возвращает правило в синтаксисе `ip rule`
+func (r Rule) String() string {
+	var parts []string
+	if r.Invert {
+		parts = append(parts, "not")
+	}
+	if r.Mark != 0 {
+		parts = append(parts, "fwmark", fmt.Sprint(r.Mark))
+	}
+	if r.Table == 0 {
+		parts = append(parts, "table", "main")
+	} else {
+		parts = append(parts, "table", fmt.Sprint(r.Table))
+	}
+	if r.SuppressPrefixLen >= 0 {
+		parts = append(parts, "suppress_prefixlength", fmt.Sprint(r.SuppressPrefixLen))
+	}
+	parts = append(parts, "priority", fmt.Sprint(r.Priority))
+	if r.IPv6 {
+		return "-6 " + strings.Join(parts, " ")
+	}
+	return strings.Join(parts, " ")
+}
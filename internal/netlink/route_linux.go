@@ -0,0 +1,202 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// RouteAdd добавляет маршрут. Если такой маршрут уже есть, ошибка удовлетворяет
+// errors.Is(err, os.ErrExist)
+func RouteAdd(r Route) error {
+	req, err := r.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL)
+	if err != nil {
+		return err
+	}
+	_, err = req.execute()
+	return err
+}
+
+// RouteDel удаляет маршрут
+func RouteDel(r Route) error {
+	req, err := r.request(unix.RTM_DELROUTE, 0)
+	if err != nil {
+		return err
+	}
+	_, err = req.execute()
+	return err
+}
+
+// request формирует сообщение RTM_NEWROUTE или RTM_DELROUTE так же, как `ip route`:
+// при удалении тип, протокол и область маршрута не сравниваются
+func (r Route) request(typ, flags int) (*request, error) {
+	table := r.Table
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	var protocol, scope, rtype uint8 = 0, unix.RT_SCOPE_NOWHERE, 0
+	if typ == unix.RTM_NEWROUTE {
+		protocol, scope, rtype = unix.RTPROT_BOOT, unix.RT_SCOPE_UNIVERSE, unix.RTN_UNICAST
+		if !r.Gateway.IsValid() && !r.Throw {
+			scope = unix.RT_SCOPE_LINK
+		}
+	}
+	if r.Throw {
+		rtype = unix.RTN_THROW
+	}
+	headerTable := uint8(unix.RT_TABLE_UNSPEC)
+	if table < 256 {
+		headerTable = uint8(table)
+	}
+	header := []byte{family(r.IPv6()), uint8(r.Dst.Bits()), 0, 0, headerTable, protocol, scope, rtype, 0, 0, 0, 0}
+
+	req := newRequest(typ, flags, header)
+	if table >= 256 {
+		req.addUint32(unix.RTA_TABLE, table)
+	}
+	if r.Dst.Bits() > 0 {
+		req.addAttr(unix.RTA_DST, r.Dst.Masked().Addr().AsSlice())
+	}
+	if r.Gateway.IsValid() {
+		req.addAttr(unix.RTA_GATEWAY, r.Gateway.AsSlice())
+	}
+	if r.Dev != "" {
+		index, err := linkIndex(r.Dev)
+		if err != nil {
+			return nil, err
+		}
+		req.addUint32(unix.RTA_OIF, uint32(index))
+	}
+	if r.Metric != 0 {
+		req.addUint32(unix.RTA_PRIORITY, r.Metric)
+	}
+	return req, nil
+}
+
+// DefaultRoutes возвращает default routes основной таблицы в порядке, в котором их
+// перечисляет ядро (как `ip route show default`)
+func DefaultRoutes(ipv6 bool) ([]Route, error) {
+	header := []byte{family(ipv6), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	msgs, err := newRequest(unix.RTM_GETROUTE, unix.NLM_F_DUMP, header).execute()
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, m := range msgs {
+		r, rtype, err := parseRoute(m)
+		if err != nil {
+			return nil, err
+		}
+		if r.Table == unix.RT_TABLE_MAIN && r.Dst.Bits() == 0 && rtype == unix.RTN_UNICAST {
+			r.Table = 0
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+// RouteGet возвращает маршрут, по которому ядро отправит пакет на адрес dst
+// (как `ip route get`)
+func RouteGet(dst netip.Addr) (Route, error) {
+	dst = dst.Unmap()
+	header := []byte{family(dst.Is6()), uint8(dst.BitLen()), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	req := newRequest(unix.RTM_GETROUTE, 0, header)
+	req.addAttr(unix.RTA_DST, dst.AsSlice())
+	msgs, err := req.execute()
+	if err != nil {
+		return Route{}, err
+	}
+	for _, m := range msgs {
+		if m.Header.Type == unix.RTM_NEWROUTE {
+			r, _, err := parseRoute(m)
+			return r, err
+		}
+	}
+	return Route{}, ErrNoRoute
+}
+
+// parseRoute разбирает сообщение RTM_NEWROUTE. Dev пуст, если интерфейса у маршрута нет
+// (например, у маршрута с несколькими шлюзами)
+func parseRoute(m syscall.NetlinkMessage) (Route, uint8, error) {
+	if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg {
+		return Route{}, 0, nil
+	}
+	ipv6 := m.Data[0] == unix.AF_INET6
+	dstLen, table, rtype := int(m.Data[1]), uint32(m.Data[4]), m.Data[7]
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return Route{}, 0, err
+	}
+
+	dst := netip.IPv4Unspecified()
+	if ipv6 {
+		dst = netip.IPv6Unspecified()
+	}
+	r := Route{Table: table}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.RTA_DST:
+			dst = attrAddr(a.Value)
+		case unix.RTA_GATEWAY:
+			r.Gateway = attrAddr(a.Value)
+		case unix.RTA_OIF:
+			if iface, err := net.InterfaceByIndex(int(binary.NativeEndian.Uint32(a.Value))); err == nil {
+				r.Dev = iface.Name
+			}
+		case unix.RTA_TABLE:
+			r.Table = binary.NativeEndian.Uint32(a.Value)
+		case unix.RTA_PRIORITY:
+			r.Metric = binary.NativeEndian.Uint32(a.Value)
+		}
+	}
+	r.Dst = netip.PrefixFrom(dst, dstLen)
+	r.Throw = rtype == unix.RTN_THROW
+	return r, rtype, nil
+}
+
+// RuleAdd добавляет правило
+func RuleAdd(r Rule) error {
+	_, err := r.request(unix.RTM_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_EXCL).execute()
+	return err
+}
+
+// RuleDel удаляет правило. Если правила нет, ошибка удовлетворяет
+// errors.Is(err, os.ErrNotExist)
+func RuleDel(r Rule) error {
+	_, err := r.request(unix.RTM_DELRULE, 0).execute()
+	return err
+}
+
+// request формирует сообщение RTM_NEWRULE или RTM_DELRULE (заголовок fib_rule_hdr)
+func (r Rule) request(typ, flags int) *request {
+	table := r.Table
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	headerTable := uint8(unix.RT_TABLE_UNSPEC)
+	if table < 256 {
+		headerTable = uint8(table)
+	}
+	var ruleFlags uint32
+	if r.Invert {
+		ruleFlags = unix.FIB_RULE_INVERT
+	}
+	header := []byte{family(r.IPv6), 0, 0, 0, headerTable, 0, 0, unix.FR_ACT_TO_TBL}
+	header = binary.NativeEndian.AppendUint32(header, ruleFlags)
+
+	req := newRequest(typ, flags, header)
+	req.addUint32(unix.FRA_PRIORITY, r.Priority)
+	if table >= 256 {
+		req.addUint32(unix.FRA_TABLE, table)
+	}
+	if r.Mark != 0 {
+		req.addUint32(unix.FRA_FWMARK, r.Mark)
+	}
+	if r.SuppressPrefixLen >= 0 {
+		req.addUint32(unix.FRA_SUPPRESS_PREFIXLEN, uint32(r.SuppressPrefixLen))
+	}
+	return req
+}
@@ -0,0 +1,21 @@
+// Package netns выполняет операции в именованном сетевом пространстве имен (`ip netns add`)
+// или в пространстве имен контейнера, не переводя в него весь процесс: сокеты транспорта
+// остаются в исходном пространстве имен, а TUN и его маршруты живут в выбранном
+package netns
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Dir каталог именованных пространств имен iproute2
+const Dir = "/var/run/netns"
+
+// Path возвращает путь к файлу пространства имен: имя ищется в Dir, путь
+// (/proc/<pid>/ns/net) возвращается как есть
+func Path(name string) string {
+	if strings.ContainsRune(name, '/') {
+		return name
+	}
+	return filepath.Join(Dir, name)
+}
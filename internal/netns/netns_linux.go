@@ -0,0 +1,54 @@
+package netns
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Do выполняет fn в пространстве имен name. fn работает в отдельном потоке ОС, поэтому
+// остальные горутины процесса пространство имен не меняют. Сокеты, открытые в fn,
+// остаются в пространстве имен name и после возврата
+func Do(name string, fn func() error) error {
+	target, err := unix.Open(Path(name), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace %s: %w", name, os.NewSyscallError("open", err))
+	}
+	defer unix.Close(target)
+
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		origin, err := unix.Open("/proc/thread-self/ns/net", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf("failed to open current network namespace: %w", os.NewSyscallError("open", err))
+			return
+		}
+		defer unix.Close(origin)
+		if err := unix.Setns(target, unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf("failed to enter network namespace %s: %w", name, os.NewSyscallError("setns", err))
+			return
+		}
+		err = fn()
+		// Поток, который не вернулся в исходное пространство имен, остается заблокированным
+		// и завершается вместе с горутиной
+		if unix.Setns(origin, unix.CLONE_NEWNET) == nil {
+			runtime.UnlockOSThread()
+		}
+		errc <- err
+	}()
+	return <-errc
+}
+
+// Open открывает файл пространства имен name, например для netlink.LinkSetNs
+func Open(name string) (*os.File, error) {
+	f, err := os.Open(Path(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network namespace: %w", err)
+	}
+	return f, nil
+}
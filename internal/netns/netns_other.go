@@ -0,0 +1,18 @@
+//go:build !linux
+
+package netns
+
+import (
+	"errors"
+	"os"
+)
+
+// Do: сетевые пространства имен есть только в Linux
+func Do(name string, fn func() error) error {
+	return errors.ErrUnsupported
+}
+
+// Open: сетевые пространства имен есть только в Linux
+func Open(name string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,261 @@
+// Package netstack TCP/IP стек в пространстве пользователя поверх gVisor (gvisor.dev/gvisor/pkg/tcpip):
+// TCP и UDP поверх IPv4 и IPv6. Стек принимает IP пакеты из туннеля (Deliver) и отдает
+// свои через Options.Write, а приложению дает net.Conn (DialTCP, DialUDP). Так клиент
+// работает без TUN и прав root: соединения SOCKS5 превращаются в пакеты туннеля.
+// На сервере стек принимает соединения и датаграммы клиентов на любые адреса
+// (Options.TCPForward, UDPForward), а сервер открывает вместо них обычные сокеты.
+//
+// Пакет связывает туннель со стеком gVisor через link/channel, а соединения стека отдает
+// как gonet.TCPConn и gonet.UDPConn: TCP (SACK, повторы, окна) реализует gVisor
+package netstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	// DefaultMTU MTU стека, пока его не задал SetMTU
+	DefaultMTU = 1420
+	// nicID единственный интерфейс стека - туннель
+	nicID = 1
+	// outputQueue пакетов стека, ожидающих отправки в туннель: сверх них пакеты
+	// отбрасываются, и TCP их повторяет
+	outputQueue = 1024
+	// maxInFlight предел соединений TCPForward, у которых еще идет рукопожатие
+	maxInFlight = 1024
+)
+
+var (
+	// ErrClosed стек или соединение закрыты
+	ErrClosed = errors.New("netstack: closed")
+	// ErrNoAddress у стека нет адреса семейства адреса назначения
+	ErrNoAddress = errors.New("netstack: no local address for this address family")
+)
+
+// Options параметры стека
+type Options struct {
+	// Write получает IP пакеты стека для отправки в туннель. Пакет действителен только
+	// до возврата. Вызывается из одной горутины стека
+	Write func(packet []byte)
+	// Addr4 и Addr6 адреса стека: пакеты на другие адреса отбрасываются, если их не принимают
+	// TCPForward и UDPForward. Нулевой адрес - семейство выключено
+	Addr4 netip.Addr
+	Addr6 netip.Addr
+	// MTU максимальный размер IP пакета (0 - DefaultMTU)
+	MTU int
+	// TCPForward включает прием TCP соединений на любые адреса: на SYN без соединения
+	// стек вызывает TCPForward в отдельной горутине, и обработчик обязан ответить на запрос
+	// Accept или Reject. nil - пакеты на чужие адреса отбрасываются
+	TCPForward func(req *TCPRequest)
+	// UDPForward включает прием UDP на любые адреса: на датаграмму без сокета стек вызывает
+	// UDPForward, и обработчик может открыть сокет потока (Accept) - он получит эту
+	// датаграмму и следующие. Вызывается внутри Deliver и не должен надолго блокироваться.
+	// nil - датаграммы на чужие адреса отбрасываются
+	UDPForward func(req *UDPRequest)
+}
+
+// Stack TCP/IP стек
+type Stack struct {
+	stack *stack.Stack
+	link  *channel.Endpoint
+	write func(packet []byte)
+
+	cancel context.CancelFunc
+	done   chan struct{} // закрывается, когда горутина output завершилась
+
+	mu     sync.Mutex
+	addr4  netip.Addr
+	addr6  netip.Addr
+	closed bool
+}
+
+// New создает стек
+func New(opts Options) *Stack {
+	mtu := opts.MTU
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	s := &Stack{
+		stack: stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+		}),
+		link:  channel.New(outputQueue, uint32(mtu), ""),
+		write: opts.Write,
+		done:  make(chan struct{}),
+	}
+	sack := tcpip.TCPSACKEnabled(true)
+	s.stack.SetTransportProtocolOption(tcp.ProtocolNumber, &sack)
+	if err := s.stack.CreateNIC(nicID, s.link); err != nil {
+		// Новый стек с одним интерфейсом: ошибка - только ошибка программы
+		panic(fmt.Sprintf("netstack: create NIC: %v", err))
+	}
+	s.stack.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		{Destination: header.IPv6EmptySubnet, NIC: nicID},
+	})
+	if opts.TCPForward != nil || opts.UDPForward != nil {
+		// Пакеты на любые адреса принимаются, и ответы уходят с этих адресов
+		s.stack.SetPromiscuousMode(nicID, true)
+		s.stack.SetSpoofing(nicID, true)
+	}
+	if opts.TCPForward != nil {
+		fwd := tcp.NewForwarder(s.stack, 0, maxInFlight, func(r *tcp.ForwarderRequest) {
+			opts.TCPForward(&TCPRequest{req: r})
+		})
+		s.stack.SetTransportProtocolHandler(tcp.ProtocolNumber, fwd.HandlePacket)
+	}
+	if opts.UDPForward != nil {
+		fwd := udp.NewForwarder(s.stack, func(r *udp.ForwarderRequest) {
+			opts.UDPForward(&UDPRequest{req: r})
+		})
+		s.stack.SetTransportProtocolHandler(udp.ProtocolNumber, fwd.HandlePacket)
+	}
+	s.SetAddresses(opts.Addr4, opts.Addr6)
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go s.output(ctx)
+	return s
+}
+
+// SetAddresses меняет адреса стека. Соединения со старых адресов разрываются
+func (s *Stack) SetAddresses(addr4, addr6 netip.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, old := range []netip.Addr{s.addr4, s.addr6} {
+		if old.IsValid() && old != addr4 && old != addr6 {
+			s.stack.RemoveAddress(nicID, tcpipAddr(old))
+		}
+	}
+	for _, addr := range []netip.Addr{addr4, addr6} {
+		if !addr.IsValid() || addr == s.addr4 || addr == s.addr6 {
+			continue
+		}
+		s.stack.AddProtocolAddress(nicID, tcpip.ProtocolAddress{
+			Protocol:          protocol(addr),
+			AddressWithPrefix: tcpipAddr(addr).WithPrefix(),
+		}, stack.AddressProperties{})
+	}
+	s.addr4, s.addr6 = addr4, addr6
+}
+
+// SetMTU меняет MTU: новые TCP соединения выбирают MSS по нему
+func (s *Stack) SetMTU(mtu int) {
+	s.stack.SetNICMTU(nicID, uint32(mtu))
+}
+
+// Close сбрасывает все соединения
+func (s *Stack) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.stack.Close()
+	s.stack.Wait()
+	s.cancel()
+	<-s.done
+	s.link.Close()
+	return nil
+}
+
+// Deliver передает стеку IP пакет из туннеля. Пакет не сохраняется после возврата
+func (s *Stack) Deliver(packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+	var proto tcpip.NetworkProtocolNumber
+	switch packet[0] >> 4 {
+	case 4:
+		proto = ipv4.ProtocolNumber
+	case 6:
+		proto = ipv6.ProtocolNumber
+	default:
+		return
+	}
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return
+	}
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(packet)})
+	s.link.InjectInbound(proto, pkt)
+	pkt.DecRef()
+}
+
+// output передает пакеты стека в Options.Write, пока стек не закрыт
+func (s *Stack) output(ctx context.Context) {
+	defer close(s.done)
+	var buf []byte
+	for {
+		pkt := s.link.ReadContext(ctx)
+		if pkt == nil {
+			return
+		}
+		buf = buf[:0]
+		for _, v := range pkt.AsSlices() {
+			buf = append(buf, v...)
+		}
+		pkt.DecRef()
+		s.write(buf)
+	}
+}
+
+// localAddr проверяет, что у стека есть адрес семейства dst
+func (s *Stack) localAddr(dst netip.Addr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	local := s.addr4
+	if dst.Is6() {
+		local = s.addr6
+	}
+	if !local.IsValid() {
+		return ErrNoAddress
+	}
+	return nil
+}
+
+// fullAddress адрес gVisor для dst на интерфейсе туннеля
+func fullAddress(dst netip.AddrPort) tcpip.FullAddress {
+	return tcpip.FullAddress{NIC: nicID, Addr: tcpipAddr(dst.Addr()), Port: dst.Port()}
+}
+
+func tcpipAddr(addr netip.Addr) tcpip.Address {
+	return tcpip.AddrFromSlice(addr.AsSlice())
+}
+
+// addrPort адрес и порт из идентификатора соединения gVisor
+func addrPort(addr tcpip.Address, port uint16) netip.AddrPort {
+	ip, _ := netip.AddrFromSlice(addr.AsSlice())
+	return netip.AddrPortFrom(ip, port)
+}
+
+// protocol сетевой протокол адреса
+func protocol(addr netip.Addr) tcpip.NetworkProtocolNumber {
+	if addr.Is6() {
+		return ipv6.ProtocolNumber
+	}
+	return ipv4.ProtocolNumber
+}
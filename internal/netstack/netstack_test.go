@@ -0,0 +1,292 @@
+package netstack
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+var (
+	clientAddr4 = netip.MustParseAddr("10.0.0.2")
+	clientAddr6 = netip.MustParseAddr("fd00::2")
+)
+
+// testLink доставляет пакеты одного стека другому в своей горутине. Каждый drop-й пакет
+// теряется, а каждый reorder-й задерживается до следующего (0 - без потерь и перестановок)
+type testLink struct {
+	packets chan []byte
+	drop    int
+	reorder int
+}
+
+func newTestLink(t *testing.T, drop, reorder int) *testLink {
+	l := &testLink{packets: make(chan []byte, 4096), drop: drop, reorder: reorder}
+	t.Cleanup(func() { close(l.packets) })
+	return l
+}
+
+func (l *testLink) write(packet []byte) {
+	select {
+	case l.packets <- bytes.Clone(packet):
+	default:
+	}
+}
+
+func (l *testLink) run(to *Stack) {
+	var held []byte
+	for n := 1; ; n++ {
+		var packet []byte
+		var ok bool
+		if held == nil {
+			packet, ok = <-l.packets
+		} else {
+			// Задержанный пакет уходит после следующего или, если его нет, с опозданием
+			select {
+			case packet, ok = <-l.packets:
+			case <-time.After(10 * time.Millisecond):
+				to.Deliver(held)
+				held = nil
+				continue
+			}
+		}
+		if !ok {
+			return
+		}
+		switch {
+		case l.drop > 0 && n%l.drop == 0:
+		case l.reorder > 0 && n%l.reorder == 0 && held == nil:
+			held = packet
+		default:
+			to.Deliver(packet)
+			if held != nil {
+				to.Deliver(held)
+				held = nil
+			}
+		}
+	}
+}
+
+// newTestPair создает стек клиента с адресами clientAddr4 и clientAddr6 и стек сервера,
+// который принимает соединения на любые адреса через tcp и udp. Стеки связаны
+// каналами с потерями drop и перестановками reorder (см. testLink)
+func newTestPair(t *testing.T, tcp func(*TCPRequest), udp func(*UDPRequest), drop, reorder int) *Stack {
+	t.Helper()
+	up, down := newTestLink(t, drop, reorder), newTestLink(t, drop, reorder)
+	client := New(Options{Write: up.write, Addr4: clientAddr4, Addr6: clientAddr6})
+	server := New(Options{Write: down.write, TCPForward: tcp, UDPForward: udp})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go up.run(server)
+	go down.run(client)
+	return client
+}
+
+// echoTCP принимает соединение и возвращает собеседнику все, что прочитал
+func echoTCP(req *TCPRequest) {
+	conn, err := req.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	io.Copy(conn, conn)
+}
+
+// tcpRoundTrip отправляет data по conn и читает столько же обратно
+func tcpRoundTrip(t *testing.T, conn net.Conn, data []byte) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		errc <- err
+	}()
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("echo differs from the data sent")
+	}
+}
+
+// TestTCPForward проверяет, что сервер принимает соединения на любые адреса IPv4 и IPv6
+// и видит в запросе адреса назначения и клиента
+func TestTCPForward(t *testing.T) {
+	type addrs struct{ local, remote netip.AddrPort }
+	seen := make(chan addrs, 2)
+	client := newTestPair(t, func(req *TCPRequest) {
+		seen <- addrs{req.LocalAddr(), req.RemoteAddr()}
+		echoTCP(req)
+	}, nil, 0, 0)
+
+	for _, dst := range []netip.AddrPort{
+		netip.MustParseAddrPort("198.51.100.7:443"),
+		netip.MustParseAddrPort("[2001:db8::7]:8443"),
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		conn, err := client.DialTCP(ctx, dst)
+		cancel()
+		if err != nil {
+			t.Fatalf("DialTCP %v: %v", dst, err)
+		}
+		got := <-seen
+		if got.local != dst {
+			t.Errorf("request to %v, want %v", got.local, dst)
+		}
+		want := clientAddr4
+		if dst.Addr().Is6() {
+			want = clientAddr6
+		}
+		if got.remote.Addr() != want {
+			t.Errorf("request from %v, want %v", got.remote, want)
+		}
+		if local := conn.LocalAddr().(*net.TCPAddr).AddrPort(); local != got.remote {
+			t.Errorf("client address %v, server saw %v", local, got.remote)
+		}
+		tcpRoundTrip(t, conn, []byte("hello through the stack"))
+		conn.Close()
+	}
+}
+
+// TestTCPReject проверяет, что отклоненное соединение завершается ошибкой у клиента
+func TestTCPReject(t *testing.T) {
+	client := newTestPair(t, func(req *TCPRequest) { req.Reject() }, nil, 0, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.DialTCP(ctx, netip.MustParseAddrPort("198.51.100.7:80"))
+	if err == nil {
+		conn.Close()
+		t.Fatal("DialTCP succeeded on a rejected connection")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("DialTCP waited for the timeout instead of RST: %v", err)
+	}
+}
+
+// TestTCPLossyLink проверяет, что данные доходят целыми и по порядку через канал
+// с потерями и перестановками пакетов
+func TestTCPLossyLink(t *testing.T) {
+	client := newTestPair(t, echoTCP, nil, 13, 7)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	conn, err := client.DialTCP(ctx, netip.MustParseAddrPort("198.51.100.7:443"))
+	cancel()
+	if err != nil {
+		t.Fatalf("DialTCP: %v", err)
+	}
+	defer conn.Close()
+	data := make([]byte, 256<<10)
+	rand.Read(data)
+	tcpRoundTrip(t, conn, data)
+}
+
+// TestTCPCloseWrite проверяет полузакрытие: сервер читает запрос до EOF, после чего
+// отвечает и закрывает соединение, а клиент читает ответ до EOF
+func TestTCPCloseWrite(t *testing.T) {
+	client := newTestPair(t, func(req *TCPRequest) {
+		conn, err := req.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := io.ReadAll(conn)
+		if err != nil {
+			return
+		}
+		conn.Write(append([]byte("reply to "), request...))
+	}, nil, 0, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	conn, err := client.DialTCP(ctx, netip.MustParseAddrPort("198.51.100.7:443"))
+	cancel()
+	if err != nil {
+		t.Fatalf("DialTCP: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn.Write([]byte("request"))
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if string(reply) != "reply to request" {
+		t.Fatalf("reply %q", reply)
+	}
+}
+
+// TestUDPForward проверяет, что сервер получает датаграммы на любые адреса в сокете
+// потока, а его ответы приходят клиенту с адреса назначения
+func TestUDPForward(t *testing.T) {
+	client := newTestPair(t, nil, func(req *UDPRequest) {
+		conn, err := req.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buf := make([]byte, 2048)
+			for {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				conn.Write(buf[:n])
+			}
+		}()
+	}, 0, 0)
+
+	for _, dst := range []netip.AddrPort{
+		netip.MustParseAddrPort("198.51.100.53:53"),
+		netip.MustParseAddrPort("[2001:db8::53]:53"),
+	} {
+		conn, err := client.DialUDP(context.Background(), dst)
+		if err != nil {
+			t.Fatalf("DialUDP %v: %v", dst, err)
+		}
+		buf := make([]byte, 2048)
+		for i, msg := range []string{"first", "second"} {
+			conn.Write([]byte(msg))
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("%v datagram %d: %v", dst, i, err)
+			}
+			if string(buf[:n]) != msg {
+				t.Fatalf("%v datagram %d: echo %q, want %q", dst, i, buf[:n], msg)
+			}
+			if from := addr.(*net.UDPAddr).AddrPort(); from != dst {
+				t.Fatalf("reply from %v, want %v", from, dst)
+			}
+		}
+		conn.Close()
+	}
+}
+
+// TestDialErrors проверяет ошибки без адреса семейства и после Close
+func TestDialErrors(t *testing.T) {
+	s := New(Options{Write: func([]byte) {}, Addr4: clientAddr4})
+	ctx := context.Background()
+	if _, err := s.DialTCP(ctx, netip.MustParseAddrPort("[2001:db8::1]:80")); !errors.Is(err, ErrNoAddress) {
+		t.Errorf("DialTCP IPv6 without an address: %v, want ErrNoAddress", err)
+	}
+	if _, err := s.DialUDP(ctx, netip.MustParseAddrPort("[2001:db8::1]:53")); !errors.Is(err, ErrNoAddress) {
+		t.Errorf("DialUDP IPv6 without an address: %v, want ErrNoAddress", err)
+	}
+	s.Close()
+	if _, err := s.DialTCP(ctx, netip.MustParseAddrPort("192.0.2.1:80")); !errors.Is(err, ErrClosed) {
+		t.Errorf("DialTCP after Close: %v, want ErrClosed", err)
+	}
+}
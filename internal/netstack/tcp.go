@@ -0,0 +1,53 @@
+package netstack
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// DialTCP устанавливает TCP соединение с dst через туннель. Отмена ctx прерывает рукопожатие
+func (s *Stack) DialTCP(ctx context.Context, dst netip.AddrPort) (*gonet.TCPConn, error) {
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	if err := s.localAddr(dst.Addr()); err != nil {
+		return nil, err
+	}
+	return gonet.DialContextTCP(ctx, s.stack, fullAddress(dst), protocol(dst.Addr()))
+}
+
+// TCPRequest входящее соединение, которое ждет решения обработчика TCPForward
+type TCPRequest struct {
+	req *tcp.ForwarderRequest
+}
+
+// LocalAddr адрес назначения соединения
+func (r *TCPRequest) LocalAddr() netip.AddrPort {
+	id := r.req.ID()
+	return addrPort(id.LocalAddress, id.LocalPort)
+}
+
+// RemoteAddr адрес инициатора соединения
+func (r *TCPRequest) RemoteAddr() netip.AddrPort {
+	id := r.req.ID()
+	return addrPort(id.RemoteAddress, id.RemotePort)
+}
+
+// Accept отвечает на SYN и ждет завершения рукопожатия
+func (r *TCPRequest) Accept() (*gonet.TCPConn, error) {
+	var wq waiter.Queue
+	ep, err := r.req.CreateEndpoint(&wq)
+	r.req.Complete(false)
+	if err != nil {
+		return nil, errors.New(err.String())
+	}
+	return gonet.NewTCPConn(&wq, ep), nil
+}
+
+// Reject отклоняет соединение: собеседник получает RST, как от закрытого порта
+func (r *TCPRequest) Reject() {
+	r.req.Complete(true)
+}
@@ -0,0 +1,50 @@
+package netstack
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// DialUDP создает UDP сокет со свободным локальным портом, подключенный к dst
+func (s *Stack) DialUDP(ctx context.Context, dst netip.AddrPort) (*gonet.UDPConn, error) {
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	if err := s.localAddr(dst.Addr()); err != nil {
+		return nil, err
+	}
+	remote := fullAddress(dst)
+	return gonet.DialUDP(s.stack, nil, &remote, protocol(dst.Addr()))
+}
+
+// UDPRequest первая датаграмма UDP потока без сокета стека, которую получил UDPForward
+type UDPRequest struct {
+	req *udp.ForwarderRequest
+}
+
+// LocalAddr адрес назначения потока
+func (r *UDPRequest) LocalAddr() netip.AddrPort {
+	id := r.req.ID()
+	return addrPort(id.LocalAddress, id.LocalPort)
+}
+
+// RemoteAddr адрес отправителя
+func (r *UDPRequest) RemoteAddr() netip.AddrPort {
+	id := r.req.ID()
+	return addrPort(id.RemoteAddress, id.RemotePort)
+}
+
+// Accept открывает сокет потока на адресе назначения, подключенный к отправителю. Сокет
+// получает первую датаграмму и следующие, а его ответы уходят с адреса назначения.
+// Без Accept датаграмма отбрасывается
+func (r *UDPRequest) Accept() (*gonet.UDPConn, error) {
+	var wq waiter.Queue
+	ep, err := r.req.CreateEndpoint(&wq)
+	if err != nil {
+		return nil, errors.New(err.String())
+	}
+	return gonet.NewUDPConn(&wq, ep), nil
+}
@@ -0,0 +1,260 @@
+// Package netstate ведет файл состояния с маршрутами, правилами маршрутизации и цепочками
+// firewall, которые добавил процесс. При штатной остановке процесс снимает их сам, а после
+// аварийного завершения (kill -9, OOM) следующий запуск находит в файле остатки и удаляет
+// их до того, как настраивать сеть заново
+package netstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"syscall"
+
+	"myvpn/internal/logging"
+	"myvpn/internal/netlink"
+	"myvpn/internal/nft"
+)
+
+var logger = logging.For("netstate")
+
+// Chain цепочка iptables и встроенная цепочка, из которой в нее переходят
+type Chain struct {
+	IPv6   bool   `json:"ipv6,omitempty"`
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Parent string `json:"parent"`
+}
+
+// State содержимое файла состояния
+type State struct {
+	PID       int             `json:"pid"`
+	Routes    []netlink.Route `json:"routes,omitempty"`
+	Rules     []netlink.Rule  `json:"rules,omitempty"`
+	Chains    []Chain         `json:"chains,omitempty"`
+	NftTables []string        `json:"nft_tables,omitempty"` // таблицы nftables семейства inet
+}
+
+// empty возвращает true, если в состоянии нет ни одной записи
+func (s *State) empty() bool {
+	return len(s.Routes) == 0 && len(s.Rules) == 0 && len(s.Chains) == 0 && len(s.NftTables) == 0
+}
+
+// Store файл состояния процесса. Методы nil Store ничего не делают
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	state State
+}
+
+// Open создает файл состояния path для текущего процесса. Перед этим остатки
+// из прежнего файла нужно удалить Recover
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, state: State{PID: os.Getpid()}}
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddRoute записывает добавленный маршрут
+func (s *Store) AddRoute(r netlink.Route) {
+	s.update(func(st *State) bool {
+		if slices.Contains(st.Routes, r) {
+			return false
+		}
+		st.Routes = append(st.Routes, r)
+		return true
+	})
+}
+
+// RemoveRoute вычеркивает снятый маршрут
+func (s *Store) RemoveRoute(r netlink.Route) {
+	s.update(func(st *State) bool {
+		n := len(st.Routes)
+		st.Routes = slices.DeleteFunc(st.Routes, func(x netlink.Route) bool { return x == r })
+		return len(st.Routes) != n
+	})
+}
+
+// AddRule записывает добавленное правило выбора таблицы маршрутов
+func (s *Store) AddRule(r netlink.Rule) {
+	s.update(func(st *State) bool {
+		if slices.Contains(st.Rules, r) {
+			return false
+		}
+		st.Rules = append(st.Rules, r)
+		return true
+	})
+}
+
+// RemoveRule вычеркивает снятое правило
+func (s *Store) RemoveRule(r netlink.Rule) {
+	s.update(func(st *State) bool {
+		n := len(st.Rules)
+		st.Rules = slices.DeleteFunc(st.Rules, func(x netlink.Rule) bool { return x == r })
+		return len(st.Rules) != n
+	})
+}
+
+// AddChain записывает созданную цепочку iptables
+func (s *Store) AddChain(c Chain) {
+	s.update(func(st *State) bool {
+		if slices.Contains(st.Chains, c) {
+			return false
+		}
+		st.Chains = append(st.Chains, c)
+		return true
+	})
+}
+
+// RemoveChain вычеркивает удаленную цепочку
+func (s *Store) RemoveChain(c Chain) {
+	s.update(func(st *State) bool {
+		n := len(st.Chains)
+		st.Chains = slices.DeleteFunc(st.Chains, func(x Chain) bool { return x == c })
+		return len(st.Chains) != n
+	})
+}
+
+// AddNftTable записывает созданную таблицу nftables (семейство inet)
+func (s *Store) AddNftTable(name string) {
+	s.update(func(st *State) bool {
+		if slices.Contains(st.NftTables, name) {
+			return false
+		}
+		st.NftTables = append(st.NftTables, name)
+		return true
+	})
+}
+
+// RemoveNftTable вычеркивает удаленную таблицу nftables
+func (s *Store) RemoveNftTable(name string) {
+	s.update(func(st *State) bool {
+		n := len(st.NftTables)
+		st.NftTables = slices.DeleteFunc(st.NftTables, func(x string) bool { return x == name })
+		return len(st.NftTables) != n
+	})
+}
+
+// Close удаляет файл состояния, если в нем не осталось записей. Иначе файл остается,
+// и недоснятое удалит Recover при следующем запуске
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.state.empty() {
+		return fmt.Errorf("%d routes, %d rules, %d chains and %d nftables tables left in %s",
+			len(s.state.Routes), len(s.state.Rules), len(s.state.Chains), len(s.state.NftTables), s.path)
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// update меняет состояние и, если fn вернула true, сохраняет его в файл
+func (s *Store) update(fn func(*State) bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !fn(&s.state) {
+		return
+	}
+	if err := s.saveLocked(); err != nil {
+		logger.Warn("Failed to save network state", "path", s.path, logging.Err(err))
+	}
+}
+
+// saveLocked записывает состояние во временный файл и переименовывает его:
+// после аварийного завершения файл не бывает записан наполовину
+func (s *Store) saveLocked() error {
+	data, err := json.MarshalIndent(&s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// Recover удаляет маршруты, правила и цепочки, записанные в файл состояния path процессом,
+// который завершился, не сняв их, и удаляет файл. Если файла нет, ничего не делает.
+// Если процесс из файла еще работает, возвращает ошибку: его настройки трогать нельзя
+func Recover(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		logger.Warn("Ignoring corrupt state file", "path", path, logging.Err(err))
+		return os.Remove(path)
+	}
+	if st.PID > 0 && st.PID != os.Getpid() && processAlive(st.PID) {
+		return fmt.Errorf("state file %s belongs to running process %d", path, st.PID)
+	}
+
+	if !st.empty() {
+		logger.Warn("Removing network settings left by an unclean shutdown", "pid", st.PID,
+			"routes", len(st.Routes), "rules", len(st.Rules), "chains", len(st.Chains), "nft_tables", len(st.NftTables))
+	}
+	// Сначала правила и цепочки: после этого трафик уже не направляется в маршруты,
+	// которые удаляются следом
+	for i := len(st.Rules) - 1; i >= 0; i-- {
+		if err := netlink.RuleDel(st.Rules[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Failed to remove stale rule", "rule", st.Rules[i].String(), logging.Err(err))
+		}
+	}
+	for i := len(st.Chains) - 1; i >= 0; i-- {
+		removeChain(st.Chains[i])
+	}
+	for _, table := range st.NftTables {
+		if err := nft.DeleteTable(table); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Failed to remove stale nftables table", "table", table, logging.Err(err))
+		}
+	}
+	for i := len(st.Routes) - 1; i >= 0; i-- {
+		// Маршрутов через исчезнувший TUN уже нет, это не ошибка
+		netlink.RouteDel(st.Routes[i])
+	}
+	return os.Remove(path)
+}
+
+// removeChain удаляет переходы в цепочку, ее правила и саму цепочку. Цепочки iptables
+// добавляет только сервер с -firewall=iptables, которому утилита нужна и так
+func removeChain(c Chain) {
+	command := "iptables"
+	if c.IPv6 {
+		command = "ip6tables"
+	}
+	for {
+		if err := exec.Command(command, "-t", c.Table, "-D", c.Parent, "-j", c.Name).Run(); err != nil {
+			break
+		}
+	}
+	exec.Command(command, "-t", c.Table, "-F", c.Name).Run()
+	exec.Command(command, "-t", c.Table, "-X", c.Name).Run()
+}
+
+// processAlive проверяет, что процесс pid существует
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
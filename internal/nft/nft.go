@@ -0,0 +1,44 @@
+// Package nft создает таблицы nftables через netlink, без утилит nft, iptables и ip6tables:
+// клиент работает и в минимальных системах, где их нет. Каждая таблица принадлежит
+// процессу целиком и пересоздается одной транзакцией, поэтому остатки от предыдущего
+// запуска исчезают, а чужие правила не затрагиваются
+//
+// На других системах функции возвращают ошибку errors.ErrUnsupported
+package nft
+
+import "net/netip"
+
+// Family семейство адресов, к которому относится правило
+type Family int
+
+const (
+	// FamilyAny пакеты IPv4 и IPv6
+	FamilyAny Family = iota
+	// FamilyIPv4 только пакеты IPv4
+	FamilyIPv4
+	// FamilyIPv6 только пакеты IPv6
+	FamilyIPv6
+)
+
+// Verdict действие правила
+type Verdict int
+
+const (
+	// Accept пропустить пакет (остальные таблицы его все равно проверяют)
+	Accept Verdict = iota
+	// Reject отклонить пакет: отправителю сразу приходит ICMP port unreachable
+	Reject
+)
+
+// Rule правило цепочки output. Пакет, совпавший со всеми заданными полями, получает
+// Verdict; пустые поля не проверяются
+type Rule struct {
+	OIf         string       // выходной интерфейс
+	Family      Family       // семейство; с Dst задается им
+	Dst         netip.Prefix // сеть назначения
+	Proto       string       // tcp, udp или icmpv6
+	SrcPort     uint16
+	DstPort     uint16
+	DstPortLast uint16 // последний порт диапазона DstPort-DstPortLast (0 - только DstPort)
+	Verdict     Verdict
+}
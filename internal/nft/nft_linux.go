@@ -0,0 +1,161 @@
+package nft
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// protocols номера протоколов для Rule.Proto
+var protocols = map[string]byte{"tcp": unix.IPPROTO_TCP, "udp": unix.IPPROTO_UDP, "icmpv6": unix.IPPROTO_ICMPV6}
+
+// ReplaceOutput пересоздает таблицу inet table с базовой цепочкой output (приоритет filter,
+// политика accept) и правилами rules. Старая таблица удаляется в той же транзакции:
+// если правила не приняты, остается прежняя
+func ReplaceOutput(table string, rules []Rule) error {
+	exprs := make([][]expr.Any, len(rules))
+	for i, rule := range rules {
+		e, err := ruleExprs(rule)
+		if err != nil {
+			return err
+		}
+		exprs[i] = e
+	}
+
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	t := &nftables.Table{Name: table, Family: nftables.TableFamilyINet}
+	// add перед delete: удаление таблицы, которой нет, отменило бы всю транзакцию
+	conn.AddTable(t)
+	conn.DelTable(t)
+	conn.AddTable(t)
+	policy := nftables.ChainPolicyAccept
+	chain := conn.AddChain(&nftables.Chain{
+		Name:     "output",
+		Table:    t,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &policy,
+	})
+	for _, e := range exprs {
+		conn.AddRule(&nftables.Rule{Table: t, Chain: chain, Exprs: e})
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to create nftables table %s: %w", table, err)
+	}
+	return nil
+}
+
+// DeleteTable удаляет таблицу inet table. Если таблицы нет, errors.Is(err, os.ErrNotExist)
+func DeleteTable(table string) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	conn.DelTable(&nftables.Table{Name: table, Family: nftables.TableFamilyINet})
+	if err := conn.Flush(); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("nftables table %s: %w", table, os.ErrNotExist)
+		}
+		return fmt.Errorf("failed to delete nftables table %s: %w", table, err)
+	}
+	return nil
+}
+
+// ruleExprs переводит правило в выражения nftables. Все проверки идут через регистр 1
+func ruleExprs(rule Rule) ([]expr.Any, error) {
+	var exprs []expr.Any
+	match := func(key expr.MetaKey, value []byte) {
+		exprs = append(exprs,
+			&expr.Meta{Key: key, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: value})
+	}
+
+	if rule.OIf != "" {
+		name := make([]byte, unix.IFNAMSIZ)
+		copy(name, rule.OIf)
+		match(expr.MetaKeyOIFNAME, name)
+	}
+
+	family := rule.Family
+	if rule.Dst.IsValid() {
+		family = FamilyIPv4
+		if rule.Dst.Addr().Is6() {
+			family = FamilyIPv6
+		}
+	}
+	switch family {
+	case FamilyIPv4:
+		match(expr.MetaKeyNFPROTO, []byte{unix.NFPROTO_IPV4})
+	case FamilyIPv6:
+		match(expr.MetaKeyNFPROTO, []byte{unix.NFPROTO_IPV6})
+	}
+
+	if rule.Dst.IsValid() {
+		dst := rule.Dst.Masked()
+		addr := dst.Addr().AsSlice()
+		// Адрес назначения: смещение 16 в заголовке IPv4, 24 в заголовке IPv6
+		offset := uint32(16)
+		if family == FamilyIPv6 {
+			offset = 24
+		}
+		exprs = append(exprs, &expr.Payload{
+			DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: uint32(len(addr)),
+		})
+		if bits := dst.Bits(); bits < len(addr)*8 {
+			maskBytes := make([]byte, len(addr))
+			for i := range bits {
+				maskBytes[i/8] |= 0x80 >> (i % 8)
+			}
+			exprs = append(exprs, &expr.Bitwise{
+				SourceRegister: 1, DestRegister: 1, Len: uint32(len(addr)), Mask: maskBytes, Xor: make([]byte, len(addr)),
+			})
+		}
+		exprs = append(exprs, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: addr})
+	}
+
+	if rule.Proto != "" {
+		proto, ok := protocols[rule.Proto]
+		if !ok {
+			return nil, fmt.Errorf("unknown protocol %q", rule.Proto)
+		}
+		match(expr.MetaKeyL4PROTO, []byte{proto})
+	} else if rule.SrcPort != 0 || rule.DstPort != 0 {
+		return nil, errors.New("ports require a protocol")
+	}
+
+	// Порты TCP и UDP: смещение 0 (источник) и 2 (назначение) в транспортном заголовке
+	if rule.SrcPort != 0 {
+		exprs = append(exprs,
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 2},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binary.BigEndian.AppendUint16(nil, rule.SrcPort)})
+	}
+	if rule.DstPort != 0 {
+		exprs = append(exprs, &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2})
+		if rule.DstPortLast > rule.DstPort {
+			exprs = append(exprs, &expr.Range{
+				Op: expr.CmpOpEq, Register: 1,
+				FromData: binary.BigEndian.AppendUint16(nil, rule.DstPort),
+				ToData:   binary.BigEndian.AppendUint16(nil, rule.DstPortLast),
+			})
+		} else {
+			exprs = append(exprs, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binary.BigEndian.AppendUint16(nil, rule.DstPort)})
+		}
+	}
+
+	switch rule.Verdict {
+	case Accept:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictAccept})
+	case Reject:
+		exprs = append(exprs, &expr.Reject{Type: unix.NFT_REJECT_ICMPX_UNREACH, Code: unix.NFT_REJECT_ICMPX_PORT_UNREACH})
+	}
+	return exprs, nil
+}
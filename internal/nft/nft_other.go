@@ -0,0 +1,11 @@
+//go:build !linux
+
+package nft
+
+import "errors"
+
+// nftables есть только в Linux
+
+func ReplaceOutput(table string, rules []Rule) error { return errors.ErrUnsupported }
+
+func DeleteTable(table string) error { return errors.ErrUnsupported }
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"net"
+)
+
+const (
+	// IPv4HeaderSize минимальный размер IPv4 заголовка
+	IPv4HeaderSize = 20
+	// IPv6HeaderSize размер фиксированного IPv6 заголовка
+	IPv6HeaderSize = 40
+)
+
+// IPVersion возвращает версию IP пакета (4 или 6), 0 если пакет некорректный
+func IPVersion(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) >= IPv4HeaderSize {
+			return 4
+		}
+	case 6:
+		if len(packet) >= IPv6HeaderSize {
+			return 6
+		}
+	}
+	return 0
+}
+
+// PacketSourceIP извлекает адрес источника из IPv4/IPv6 пакета
+func PacketSourceIP(packet []byte) (net.IP, bool) {
+	switch IPVersion(packet) {
+	case 4:
+		return net.IPv4(packet[12], packet[13], packet[14], packet[15]), true
+	case 6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, packet[8:24])
+		return ip, true
+	}
+	return nil, false
+}
+
+// PacketDestIP извлекает адрес назначения из IPv4/IPv6 пакета
+func PacketDestIP(packet []byte) (net.IP, bool) {
+	switch IPVersion(packet) {
+	case 4:
+		return net.IPv4(packet[16], packet[17], packet[18], packet[19]), true
+	case 6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, packet[24:40])
+		return ip, true
+	}
+	return nil, false
+}
+
+// PacketDSCP возвращает DSCP пакета (старшие 6 бит TOS или traffic class) или 0,
+// если это не IP пакет
+func PacketDSCP(packet []byte) uint8 {
+	switch IPVersion(packet) {
+	case 4:
+		return packet[1] >> 2
+	case 6:
+		return (packet[0]<<4 | packet[1]>>4) >> 2
+	}
+	return 0
+}
+
+// FlowHash возвращает хэш (FNV-1a) адресов источника и назначения пакета.
+// Пакеты одного потока получают одинаковый хэш, поэтому при раздаче по очередям
+// их порядок сохраняется
+func FlowHash(packet []byte) uint32 {
+	var addrs []byte
+	switch IPVersion(packet) {
+	case 4:
+		addrs = packet[12:20]
+	case 6:
+		addrs = packet[8:40]
+	}
+
+	hash := uint32(2166136261)
+	for _, b := range addrs {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return hash
+}
+
+// ConnHash возвращает хэш (FNV-1a) адресов, протокола и портов пакета. В отличие от FlowHash
+// различает соединения между одной парой адресов. Для протоколов без портов
+// (и IPv6 с extension headers) порты не учитываются
+func ConnHash(packet []byte) uint32 {
+	var addrs []byte
+	var proto byte
+	var l4 []byte
+	switch IPVersion(packet) {
+	case 4:
+		addrs, proto = packet[12:20], packet[9]
+		if ihl := int(packet[0]&0x0f) * 4; ihl >= IPv4HeaderSize && len(packet) >= ihl+4 {
+			l4 = packet[ihl : ihl+4]
+		}
+	case 6:
+		addrs, proto = packet[8:40], packet[6]
+		if len(packet) >= IPv6HeaderSize+4 {
+			l4 = packet[IPv6HeaderSize : IPv6HeaderSize+4]
+		}
+	}
+
+	hash := uint32(2166136261)
+	for _, b := range addrs {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	hash ^= uint32(proto)
+	hash *= 16777619
+	// Порты TCP, UDP и SCTP занимают первые 4 байта заголовка
+	if proto == 6 || proto == 17 || proto == 132 {
+		for _, b := range l4 {
+			hash ^= uint32(b)
+			hash *= 16777619
+		}
+	}
+	return hash
+}
@@ -0,0 +1,222 @@
+// Package pcap записывает трафик туннеля в файл pcap для Wireshark и tcpdump: внутренние
+// IP пакеты как есть, внешние датаграммы протокола - с восстановленными заголовками IP и UDP
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// linkTypeRaw пакеты начинаются с IPv4 или IPv6 заголовка (LINKTYPE_RAW)
+	linkTypeRaw = 101
+	// snapLen максимальный размер записываемого пакета
+	snapLen = 65535
+
+	globalHeaderSize = 24
+	recordHeaderSize = 16
+)
+
+// Writer пишет пакеты в формате pcap
+type Writer struct {
+	w    io.Writer
+	size int64
+}
+
+// NewWriter записывает заголовок файла pcap
+func NewWriter(w io.Writer) (*Writer, error) {
+	var hdr [globalHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, size: globalHeaderSize}, nil
+}
+
+// WritePacket записывает пакет с временем t
+func (w *Writer) WritePacket(t time.Time, packet []byte) error {
+	captured := packet
+	if len(captured) > snapLen {
+		captured = captured[:snapLen]
+	}
+	var hdr [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(captured)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(packet)))
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(captured); err != nil {
+		return err
+	}
+	w.size += int64(recordHeaderSize + len(captured))
+	return nil
+}
+
+// Size возвращает число записанных байт
+func (w *Writer) Size() int64 {
+	return w.size
+}
+
+// UDPDatagram собирает IP пакет с UDP заголовком вокруг payload. IPv6 используется, если
+// один из адресов IPv6. Контрольная сумма UDP не считается (0): для IPv6 Wireshark
+// отметит ее как неверную, на разбор это не влияет
+func UDPDatagram(src, dst *net.UDPAddr, payload []byte) []byte {
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	udpLen := 8 + len(payload)
+
+	var packet []byte
+	if src4 != nil && dst4 != nil {
+		packet = make([]byte, 20, 20+udpLen)
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:], uint16(20+udpLen))
+		packet[8] = 64
+		packet[9] = 17
+		copy(packet[12:16], src4)
+		copy(packet[16:20], dst4)
+		binary.BigEndian.PutUint16(packet[10:], ipv4Checksum(packet))
+	} else {
+		packet = make([]byte, 40, 40+udpLen)
+		packet[0] = 0x60
+		binary.BigEndian.PutUint16(packet[4:], uint16(udpLen))
+		packet[6] = 17
+		packet[7] = 64
+		copy(packet[8:24], src.IP.To16())
+		copy(packet[24:40], dst.IP.To16())
+	}
+
+	var udp [8]byte
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	return append(append(packet, udp[:]...), payload...)
+}
+
+func ipv4Checksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// Status состояние записи
+type Status struct {
+	Path    string    `json:"path"`
+	Outer   bool      `json:"outer"`
+	Started time.Time `json:"started"`
+	Packets uint64    `json:"packets"`
+	Bytes   int64     `json:"bytes"`
+	Limit   int64     `json:"limit"`
+	// Full запись остановлена, потому что файл достиг Limit
+	Full bool `json:"full"`
+}
+
+// Capture запись трафика туннеля в файл с ограничением размера. Методы безопасны
+// для вызова из нескольких горутин
+type Capture struct {
+	mu      sync.Mutex
+	file    *os.File
+	w       *Writer
+	status  Status
+	onLimit func()
+}
+
+// Start создает файл path и начинает запись. limit - максимальный размер файла в байтах
+// (0 - без ограничения), outer - записывать ли и внешние датаграммы (шифротекст).
+// onLimit вызывается один раз, когда файл достиг ограничения
+func Start(path string, limit int64, outer bool, onLimit func()) (*Capture, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	w, err := NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write capture file: %w", err)
+	}
+	return &Capture{
+		file:    file,
+		w:       w,
+		status:  Status{Path: path, Outer: outer, Started: time.Now(), Limit: limit},
+		onLimit: onLimit,
+	}, nil
+}
+
+// Outer сообщает, записываются ли внешние датаграммы
+func (c *Capture) Outer() bool {
+	return c.status.Outer
+}
+
+// Inner записывает внутренний IP пакет
+func (c *Capture) Inner(packet []byte) {
+	c.write(packet)
+}
+
+// Datagram записывает внешнюю датаграмму протокола между local и remote.
+// out - датаграмма отправлена, иначе принята
+func (c *Capture) Datagram(packet []byte, local, remote *net.UDPAddr, out bool) {
+	if local == nil || remote == nil {
+		return
+	}
+	if out {
+		c.write(UDPDatagram(local, remote, packet))
+	} else {
+		c.write(UDPDatagram(remote, local, packet))
+	}
+}
+
+func (c *Capture) write(packet []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil || c.status.Full {
+		return
+	}
+	if c.status.Limit > 0 && c.w.Size()+int64(recordHeaderSize+len(packet)) > c.status.Limit {
+		c.status.Full = true
+		if c.onLimit != nil {
+			go c.onLimit()
+		}
+		return
+	}
+	if err := c.w.WritePacket(time.Now(), packet); err != nil {
+		// Диск заполнен или файл удален: дальше писать бессмысленно
+		c.status.Full = true
+		return
+	}
+	c.status.Packets++
+}
+
+// Status возвращает состояние записи
+func (c *Capture) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.status
+	st.Bytes = c.w.Size()
+	return st
+}
+
+// Close останавливает запись и закрывает файл
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
@@ -0,0 +1,185 @@
+// Package peerdb хранит пиров сервера в файле BoltDB: ключи, разрешенные адреса
+// и состояние (активен, отключен, отозван)
+package peerdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Состояния пира
+const (
+	// StatusActive сервер принимает ключ пира
+	StatusActive = "active"
+	// StatusDisabled ключ временно не принимается, пира можно включить обратно
+	StatusDisabled = "disabled"
+	// StatusRevoked ключ отозван навсегда. Запись остается в базе, чтобы ни имя,
+	// ни ключ нельзя было случайно использовать снова
+	StatusRevoked = "revoked"
+)
+
+// openTimeout сколько ждать блокировку файла базы. Работающий сервер держит ее все время
+const openTimeout = time.Second
+
+var (
+	// ErrNotFound пира нет в базе
+	ErrNotFound = errors.New("peer not found")
+	// ErrExists имя или ключ уже заняты (в том числе отозванным пиром)
+	ErrExists = errors.New("peer already exists")
+	// ErrRevoked отозванного пира нельзя изменить
+	ErrRevoked = errors.New("peer is revoked")
+	// ErrLocked файл базы открыт другим процессом
+	ErrLocked = errors.New("peer database is locked by another process (is the server running? use the admin API)")
+)
+
+var bucketPeers = []byte("peers")
+
+// Peer запись о пире. Задается либо общий ключ Key, либо открытый ключ PublicKey
+type Peer struct {
+	Name string `json:"name"`
+	// Key общий ключ пира в hex
+	Key string `json:"key,omitempty"`
+	// PublicKey открытый ключ X25519 пира в base64 или hex
+	PublicKey string `json:"public_key,omitempty"`
+	// AllowedIPs и Certificate - как в server.PeerConfig
+	AllowedIPs  []string  `json:"allowed_ips,omitempty"`
+	Certificate string    `json:"certificate,omitempty"`
+	Status      string    `json:"status"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// DB база пиров
+type DB struct {
+	bolt *bolt.DB
+}
+
+// Open открывает базу, создавая файл при необходимости
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peer database %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketPeers)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize peer database %s: %w", path, err)
+	}
+	return &DB{bolt: db}, nil
+}
+
+// Close закрывает базу
+func (d *DB) Close() error {
+	return d.bolt.Close()
+}
+
+// List возвращает всех пиров, отсортированных по имени
+func (d *DB) List() ([]Peer, error) {
+	var peers []Peer
+	err := d.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPeers).ForEach(func(k, v []byte) error {
+			var p Peer
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("corrupted record of peer %q: %w", k, err)
+			}
+			peers = append(peers, p)
+			return nil
+		})
+	})
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+	return peers, err
+}
+
+// Get возвращает пира по имени
+func (d *DB) Get(name string) (Peer, error) {
+	var p Peer
+	err := d.bolt.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketPeers).Get([]byte(name))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &p)
+	})
+	return p, err
+}
+
+// Create добавляет активного пира. Имя и ключ не должны совпадать ни с одной записью,
+// включая отозванные
+func (d *DB) Create(p Peer) (Peer, error) {
+	if p.Name == "" {
+		return Peer{}, errors.New("peer name is required")
+	}
+	if (p.Key == "") == (p.PublicKey == "") {
+		return Peer{}, errors.New("peer needs either a shared key or a public key")
+	}
+	now := time.Now().UTC()
+	p.Status, p.Created, p.Updated = StatusActive, now, now
+
+	err := d.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketPeers)
+		if b.Get([]byte(p.Name)) != nil {
+			return fmt.Errorf("%w: name %q is taken", ErrExists, p.Name)
+		}
+		err := b.ForEach(func(k, v []byte) error {
+			var other Peer
+			if err := json.Unmarshal(v, &other); err != nil {
+				return nil
+			}
+			if (p.Key != "" && other.Key == p.Key) || (p.PublicKey != "" && other.PublicKey == p.PublicKey) {
+				return fmt.Errorf("%w: key is already used by peer %q (%s)", ErrExists, other.Name, other.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return put(b, p)
+	})
+	return p, err
+}
+
+// SetStatus меняет состояние пира и возвращает обновленную запись
+func (d *DB) SetStatus(name, status string) (Peer, error) {
+	switch status {
+	case StatusActive, StatusDisabled, StatusRevoked:
+	default:
+		return Peer{}, fmt.Errorf("unknown peer status %q", status)
+	}
+
+	var p Peer
+	err := d.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketPeers)
+		v := b.Get([]byte(name))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		if p.Status == StatusRevoked {
+			return ErrRevoked
+		}
+		p.Status, p.Updated = status, time.Now().UTC()
+		return put(b, p)
+	})
+	return p, err
+}
+
+func put(b *bolt.Bucket, p Peer) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(p.Name), data)
+}
@@ -0,0 +1,259 @@
+// Package pktqueue ограниченные очереди пакетов между горутинами пути пакета (чтение TUN
+// и шифрование, отправка в сокет, запись в TUN) с политикой на случай, когда получатель
+// не успевает: ждать (backpressure), отбрасывать новые пакеты или отбрасывать пакеты,
+// которые слишком долго стоят в очереди (CoDel)
+package pktqueue
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy что делать с пакетом, когда получатель не успевает
+type Policy int
+
+const (
+	// Block отправитель ждет, пока в очереди появится место (backpressure)
+	Block Policy = iota
+	// TailDrop пакет отбрасывается, если очередь полна
+	TailDrop
+	// CoDel как TailDrop, и кроме того при выдаче отбрасываются пакеты, если задержка
+	// в очереди дольше Interval держится выше Target (RFC 8289). Очередь не копит
+	// стоячую задержку, и TCP внутри туннеля раньше узнает о перегрузке
+	CoDel
+)
+
+const (
+	// Target допустимая задержка пакета в очереди для CoDel
+	Target = 5 * time.Millisecond
+	// Interval сколько задержка может быть выше Target, прежде чем CoDel начнет отбрасывать
+	Interval = 100 * time.Millisecond
+)
+
+// ParsePolicy разбирает политику из флага: block, tail-drop или codel
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "block":
+		return Block, nil
+	case "tail-drop":
+		return TailDrop, nil
+	case "codel":
+		return CoDel, nil
+	}
+	return 0, fmt.Errorf("unknown queue policy %q (block, tail-drop or codel)", s)
+}
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case TailDrop:
+		return "tail-drop"
+	case CoDel:
+		return "codel"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+type entry[T any] struct {
+	v  T
+	at time.Time // время постановки в очередь (только для CoDel)
+}
+
+// Queue кольцевой буфер на фиксированное число пакетов. Push можно вызывать из нескольких
+// горутин, Pop и TryPop - из одной
+type Queue[T any] struct {
+	mu     sync.Mutex
+	ring   []entry[T]
+	head   int
+	n      int
+	policy Policy
+	codel  codel
+
+	drop  func(T)
+	drops atomic.Uint64
+
+	ready chan struct{} // в очереди что-то появилось
+	space chan struct{} // в очереди освободилось место (Block)
+}
+
+// New создает очередь на size пакетов. drop вызывается для каждого пакета, который очередь
+// не выдаст (отброшен политикой или отправитель прекратил ждать), например чтобы вернуть
+// буфер в пул
+func New[T any](size int, policy Policy, drop func(T)) *Queue[T] {
+	if size < 1 {
+		size = 1
+	}
+	return &Queue[T]{
+		ring:   make([]entry[T], size),
+		policy: policy,
+		drop:   drop,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// Push ставит v в очередь. С Block ждет места, пока не закрыт done. Возвращает false,
+// если v не поставлен в очередь: тогда он уже передан drop
+func (q *Queue[T]) Push(v T, done <-chan struct{}) bool {
+	for {
+		q.mu.Lock()
+		if q.n < len(q.ring) {
+			e := &q.ring[(q.head+q.n)%len(q.ring)]
+			e.v = v
+			if q.policy == CoDel {
+				e.at = time.Now()
+			}
+			q.n++
+			more := q.n < len(q.ring)
+			q.mu.Unlock()
+			signal(q.ready)
+			if more && q.policy == Block {
+				// Место осталось: будим следующего ждущего отправителя
+				signal(q.space)
+			}
+			return true
+		}
+		q.mu.Unlock()
+
+		if q.policy != Block {
+			q.discard(v)
+			return false
+		}
+		select {
+		case <-q.space:
+		case <-done:
+			q.drop(v)
+			return false
+		}
+	}
+}
+
+// Pop выдает следующий пакет, ожидая его, пока не закрыт done
+func (q *Queue[T]) Pop(done <-chan struct{}) (T, bool) {
+	for {
+		if v, ok := q.TryPop(); ok {
+			return v, true
+		}
+		select {
+		case <-q.ready:
+		case <-done:
+			var zero T
+			return zero, false
+		}
+	}
+}
+
+// TryPop выдает следующий пакет, если он есть, не ожидая
+func (q *Queue[T]) TryPop() (T, bool) {
+	q.mu.Lock()
+	var now time.Time
+	if q.policy == CoDel && q.n > 0 {
+		now = time.Now()
+	}
+	for q.n > 0 {
+		e := q.ring[q.head]
+		q.ring[q.head] = entry[T]{}
+		q.head = (q.head + 1) % len(q.ring)
+		q.n--
+		if q.policy == CoDel && q.codel.shouldDrop(now.Sub(e.at), now, q.n) {
+			q.discard(e.v)
+			continue
+		}
+		q.mu.Unlock()
+		if q.policy == Block {
+			signal(q.space)
+		}
+		return e.v, true
+	}
+	q.mu.Unlock()
+	var zero T
+	return zero, false
+}
+
+// Len возвращает число пакетов в очереди
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Drops возвращает число пакетов, отброшенных политикой очереди
+func (q *Queue[T]) Drops() uint64 {
+	return q.drops.Load()
+}
+
+func (q *Queue[T]) discard(v T) {
+	q.drops.Add(1)
+	q.drop(v)
+}
+
+// signal будит ждущую горутину, не блокируясь, если сигнал уже есть
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// codel состояние CoDel (RFC 8289): решение принимается при выдаче каждого пакета
+type codel struct {
+	firstAbove time.Time // когда задержка выше Target продержится Interval
+	dropNext   time.Time // время следующего отбрасывания в режиме dropping
+	count      int       // отброшено с начала режима dropping
+	lastCount  int
+	dropping   bool
+}
+
+// okToDrop проверяет, что задержка выше Target держится дольше Interval.
+// backlog сколько пакетов осталось в очереди: последний пакет не отбрасывается
+func (c *codel) okToDrop(sojourn time.Duration, now time.Time, backlog int) bool {
+	if sojourn < Target || backlog == 0 {
+		c.firstAbove = time.Time{}
+		return false
+	}
+	if c.firstAbove.IsZero() {
+		c.firstAbove = now.Add(Interval)
+		return false
+	}
+	return !now.Before(c.firstAbove)
+}
+
+// shouldDrop решает, отбросить ли пакет, простоявший в очереди sojourn
+func (c *codel) shouldDrop(sojourn time.Duration, now time.Time, backlog int) bool {
+	ok := c.okToDrop(sojourn, now, backlog)
+	if c.dropping {
+		if !ok {
+			c.dropping = false
+			return false
+		}
+		if now.Before(c.dropNext) {
+			return false
+		}
+		c.count++
+		c.dropNext = controlLaw(c.dropNext, c.count)
+		return true
+	}
+	if !ok {
+		return false
+	}
+	c.dropping = true
+	// Если перегрузка вернулась вскоре после прошлого режима dropping, продолжаем
+	// с прежней частотой отбрасывания, а не начинаем заново
+	if delta := c.count - c.lastCount; delta > 1 && now.Sub(c.dropNext) < 16*Interval {
+		c.count = delta
+	} else {
+		c.count = 1
+	}
+	c.lastCount = c.count
+	c.dropNext = controlLaw(now, c.count)
+	return true
+}
+
+// controlLaw интервал до следующего отбрасывания сокращается как Interval/sqrt(count)
+func controlLaw(t time.Time, count int) time.Time {
+	return t.Add(time.Duration(float64(Interval) / math.Sqrt(float64(count))))
+}
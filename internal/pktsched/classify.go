@@ -0,0 +1,69 @@
+package pktsched
+
+import "myvpn/internal"
+
+// Class класс трафика
+type Class int
+
+const (
+	// Interactive пакеты, для которых важна задержка
+	Interactive Class = iota
+	// Bulk остальной трафик (загрузки, потоковое видео)
+	Bulk
+
+	classes = 2
+)
+
+// InteractiveSize до какого размера датаграммы не TCP считаются интерактивными:
+// голос (RTP), DNS, игры, ICMP
+const InteractiveSize = 256
+
+// Протоколы и флаги, которые смотрит Classify
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoICMPv6 = 58
+	dscpCS5     = 40 // CS5 и выше (VA, EF, CS6, CS7) - голос и управление сетью
+	tcpFIN      = 0x01
+)
+
+// Classify относит IP пакет к классу. Interactive - ICMP, пакеты с DSCP от CS5 (EF и т.д.),
+// TCP без данных (ACK, SYN, RST) и датаграммы не TCP до InteractiveSize байт. Небольшие TCP
+// сегменты с данными остаются Bulk: иначе они обгоняли бы большие сегменты того же соединения
+func Classify(packet []byte) Class {
+	var proto byte
+	var l4 []byte
+	switch internal.IPVersion(packet) {
+	case 4:
+		ihl := int(packet[0]&0x0f) * 4
+		if ihl < 20 || len(packet) < ihl {
+			return Bulk
+		}
+		proto, l4 = packet[9], packet[ihl:]
+	case 6:
+		proto, l4 = packet[6], packet[40:]
+	default:
+		return Bulk
+	}
+	if internal.PacketDSCP(packet) >= dscpCS5 {
+		return Interactive
+	}
+
+	switch proto {
+	case protoICMP, protoICMPv6:
+		return Interactive
+	case protoTCP:
+		if len(l4) < 20 {
+			return Bulk
+		}
+		dataOffset := int(l4[12]>>4) * 4
+		if len(l4) > dataOffset || l4[13]&tcpFIN != 0 {
+			return Bulk
+		}
+		return Interactive
+	}
+	if len(packet) <= InteractiveSize {
+		return Interactive
+	}
+	return Bulk
+}
@@ -0,0 +1,242 @@
+// Package pktsched планировщик отправки пакетов клиентам: у каждого клиента свои очереди
+// двух классов (интерактивный и объемный трафик), клиенты обслуживаются по очереди
+// (deficit round robin по байтам), а интерактивные пакеты идут раньше объемных. Так одна
+// большая загрузка не увеличивает задержку ни у других клиентов, ни у VoIP, DNS и SSH
+// того же клиента. Лимит скорости клиента задерживает его пакеты (shaping), а не отбрасывает
+package pktsched
+
+import (
+	"sync"
+	"time"
+
+	"myvpn/internal/bufpool"
+	"myvpn/internal/ratelimit"
+)
+
+const (
+	// quantum сколько байт клиент может отправить за один проход: не меньше самого
+	// большого пакета, поэтому за проход отправляется хотя бы один пакет
+	quantum = bufpool.DatagramSize
+	// maxBulkSkip после стольких интерактивных пакетов подряд, пока ждут объемные,
+	// отправляется объемный: поток мелких пакетов не может остановить остальной трафик
+	maxBulkSkip = 16
+)
+
+type item[T any] struct {
+	v    T
+	size int
+}
+
+// fifo очередь пакетов одного класса клиента
+type fifo[T any] struct {
+	items []item[T]
+	head  int
+}
+
+func (q *fifo[T]) len() int { return len(q.items) - q.head }
+
+func (q *fifo[T]) peek() item[T] { return q.items[q.head] }
+
+func (q *fifo[T]) push(it item[T]) {
+	if q.head > 0 && len(q.items) == cap(q.items) {
+		// Сдвигаем оставшееся в начало, чтобы не расти без предела
+		n := copy(q.items, q.items[q.head:])
+		clear(q.items[n:])
+		q.items, q.head = q.items[:n], 0
+	}
+	q.items = append(q.items, it)
+}
+
+func (q *fifo[T]) pop() item[T] {
+	it := q.items[q.head]
+	q.items[q.head] = item[T]{}
+	q.head++
+	if q.head == len(q.items) {
+		q.items, q.head = q.items[:0], 0
+	}
+	return it
+}
+
+// flow очереди одного клиента
+type flow[T any] struct {
+	key     uint64
+	queues  [classes]fifo[T]
+	deficit [classes]int
+	n       int               // пакетов во всех классах
+	limit   *ratelimit.Bucket // лимит скорости (nil - без ограничения)
+	until   time.Time         // до этого времени лимит не пропускает следующий пакет
+}
+
+// Scheduler очереди пакетов по клиентам. Push можно вызывать из нескольких горутин,
+// Pop и TryPop - из одной
+type Scheduler[T any] struct {
+	mu       sync.Mutex
+	flows    map[uint64]*flow[T]
+	active   [classes][]*flow[T] // клиенты с пакетами класса, в порядке обслуживания
+	limit    int
+	drop     func(T)
+	bulkSkip int // интерактивных пакетов подряд, пока ждали объемные
+
+	ready chan struct{}
+	timer *time.Timer
+}
+
+// New создает планировщик с очередью на limit пакетов у каждого клиента. drop вызывается
+// для пакетов, которые не поместились в очередь клиента
+func New[T any](limit int, drop func(T)) *Scheduler[T] {
+	if limit < 1 {
+		limit = 1
+	}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &Scheduler[T]{
+		flows: make(map[uint64]*flow[T]),
+		limit: limit,
+		drop:  drop,
+		ready: make(chan struct{}, 1),
+		timer: timer,
+	}
+}
+
+// Push ставит пакет размера size в очередь клиента key. limit - текущий лимит скорости
+// клиента (nil - без ограничения). Возвращает false, если очередь клиента полна:
+// тогда пакет уже передан drop
+func (s *Scheduler[T]) Push(key uint64, class Class, v T, size int, limit *ratelimit.Bucket) bool {
+	s.mu.Lock()
+	f := s.flows[key]
+	if f == nil {
+		f = &flow[T]{key: key}
+		s.flows[key] = f
+	}
+	f.limit = limit
+	if f.n >= s.limit {
+		s.mu.Unlock()
+		s.drop(v)
+		return false
+	}
+	q := &f.queues[class]
+	if q.len() == 0 {
+		s.active[class] = append(s.active[class], f)
+	}
+	q.push(item[T]{v: v, size: size})
+	f.n++
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Pop выдает следующий пакет, ожидая его (или окончания задержки лимита), пока не закрыт done
+func (s *Scheduler[T]) Pop(done <-chan struct{}) (T, bool) {
+	for {
+		v, ok, until := s.next(time.Now())
+		if ok {
+			return v, true
+		}
+		var wake <-chan time.Time
+		if !until.IsZero() {
+			s.timer.Reset(time.Until(until))
+			wake = s.timer.C
+		}
+		select {
+		case <-s.ready:
+		case <-wake:
+		case <-done:
+			var zero T
+			return zero, false
+		}
+		if wake != nil && !s.timer.Stop() {
+			select {
+			case <-s.timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// TryPop выдает следующий пакет, если его можно отправить сейчас
+func (s *Scheduler[T]) TryPop() (T, bool) {
+	v, ok, _ := s.next(time.Now())
+	return v, ok
+}
+
+// next выбирает следующий пакет. Если пакеты есть, но все ждут лимита скорости,
+// возвращает время, когда первый из них можно будет отправить
+func (s *Scheduler[T]) next(now time.Time) (T, bool, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order := [classes]Class{Interactive, Bulk}
+	if s.bulkSkip >= maxBulkSkip {
+		order = [classes]Class{Bulk, Interactive}
+	}
+	var earliest time.Time
+	for _, class := range order {
+		v, ok, until := s.serve(class, now)
+		if ok {
+			if class == Interactive && len(s.active[Bulk]) > 0 {
+				s.bulkSkip++
+			} else {
+				s.bulkSkip = 0
+			}
+			return v, true, time.Time{}
+		}
+		if !until.IsZero() && (earliest.IsZero() || until.Before(earliest)) {
+			earliest = until
+		}
+	}
+	var zero T
+	return zero, false, earliest
+}
+
+// serve выдает пакет класса class по кругу клиентов (deficit round robin)
+func (s *Scheduler[T]) serve(class Class, now time.Time) (T, bool, time.Time) {
+	var earliest time.Time
+	for tries := len(s.active[class]); tries > 0; tries-- {
+		ring := s.active[class]
+		f := ring[0]
+		q := &f.queues[class]
+		head := q.peek()
+		if now.Before(f.until) {
+			if earliest.IsZero() || f.until.Before(earliest) {
+				earliest = f.until
+			}
+			s.active[class] = append(ring[1:], f)
+			continue
+		}
+		if f.deficit[class] < head.size {
+			f.deficit[class] += quantum
+		}
+		if wait := f.limit.Reserve(head.size); wait > 0 {
+			f.until = now.Add(wait)
+			if earliest.IsZero() || f.until.Before(earliest) {
+				earliest = f.until
+			}
+			s.active[class] = append(ring[1:], f)
+			continue
+		}
+
+		q.pop()
+		f.n--
+		f.deficit[class] -= head.size
+		switch {
+		case q.len() == 0:
+			// Клиент выходит из круга класса, неиспользованный остаток не копится
+			f.deficit[class] = 0
+			ring[0] = nil
+			s.active[class] = ring[1:]
+			if f.n == 0 {
+				delete(s.flows, f.key)
+			}
+		case f.deficit[class] < q.peek().size:
+			// Клиент израсходовал свою долю: ход следующего
+			s.active[class] = append(ring[1:], f)
+		}
+		return head.v, true, time.Time{}
+	}
+	var zero T
+	return zero, false, earliest
+}
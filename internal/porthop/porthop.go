@@ -0,0 +1,97 @@
+package porthop
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultInterval период смены порта по умолчанию
+const DefaultInterval = 30 * time.Second
+
+// hopLabel контекст HMAC, чтобы расписание не совпадало с другими производными ключа
+const hopLabel = "myvpn port hopping"
+
+// Range диапазон UDP портов (включительно). Нулевой Range - port hopping выключен
+type Range struct {
+	First int
+	Last  int
+}
+
+// ParseRange разбирает диапазон вида "20000-30000". Пустая строка - выключено
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Range{}, nil
+	}
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return Range{}, fmt.Errorf("invalid port range %q (expected first-last)", s)
+	}
+	a, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(last))
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if a < 1 || b > 65535 || a >= b {
+		return Range{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return Range{First: a, Last: b}, nil
+}
+
+// Enabled сообщает, задан ли диапазон
+func (r Range) Enabled() bool {
+	return r.First > 0
+}
+
+// Size возвращает число портов в диапазоне
+func (r Range) Size() int {
+	return r.Last - r.First + 1
+}
+
+// String возвращает диапазон в формате iptables (first:last)
+func (r Range) String() string {
+	return fmt.Sprintf("%d:%d", r.First, r.Last)
+}
+
+// Schedule расписание смены порта. Порт на каждом интервале выбирается HMAC от ключа
+// и номера интервала, поэтому его знают только владельцы ключа, а наблюдатель
+// видит случайную последовательность портов
+type Schedule struct {
+	key      []byte
+	ports    Range
+	interval time.Duration
+}
+
+// NewSchedule создает расписание для диапазона ports с периодом interval
+func NewSchedule(key []byte, ports Range, interval time.Duration) *Schedule {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hopLabel))
+	return &Schedule{key: mac.Sum(nil), ports: ports, interval: interval}
+}
+
+// Port возвращает порт, действующий в момент now
+func (s *Schedule) Port(now time.Time) int {
+	var epoch [8]byte
+	binary.BigEndian.PutUint64(epoch[:], uint64(now.UnixNano()/int64(s.interval)))
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(epoch[:])
+	sum := mac.Sum(nil)
+	return s.ports.First + int(binary.BigEndian.Uint64(sum)%uint64(s.ports.Size()))
+}
+
+// Next возвращает момент следующей смены порта после now
+func (s *Schedule) Next(now time.Time) time.Time {
+	epoch := now.UnixNano() / int64(s.interval)
+	return time.Unix(0, (epoch+1)*int64(s.interval))
+}
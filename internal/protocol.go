@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -17,29 +18,31 @@ func NewProtocol(crypto *Crypto) *Protocol {
 	return &Protocol{crypto: crypto}
 }
 
-// SendPacket отправляет зашифрованный пакет через writer
+// SendPacket отправляет зашифрованный пакет через writer.
+// В потоковом протоколе нет sequence number, поэтому nonce случайный и передается перед шифротекстом
+// (24 байта XChaCha20 достаточно, чтобы случайные nonce не повторялись).
+// Кадр (заголовок + nonce + шифротекст) собирается в буфере из пула и уходит одной записью;
+// packet после возврата не используется
 func (p *Protocol) SendPacket(writer io.Writer, packet []byte) error {
-	// Шифруем пакет
-	encrypted, err := p.crypto.Encrypt(packet, nil)
-	if err != nil {
-		return err
-	}
+	buf := bufpool.GetDatagram()
+	defer bufpool.PutDatagram(buf)
 
-	// Получаем буфер заголовка из пула
-	sizeBuf := bufpool.GetHeader()
-	defer bufpool.PutHeader(sizeBuf)
-
-	binary.BigEndian.PutUint32(sizeBuf, uint32(len(encrypted)))
-
-	if _, err := writer.Write(sizeBuf); err != nil {
+	frame := buf[:bufpool.HeaderSize+NonceSize]
+	clear(frame[:bufpool.HeaderSize])
+	nonce := frame[bufpool.HeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return err
 	}
 
-	if _, err := writer.Write(encrypted); err != nil {
+	// Шифротекст дописывается за nonce в тот же буфер
+	frame, err := p.crypto.Seal(frame, nonce, packet, nil)
+	if err != nil {
 		return err
 	}
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-bufpool.HeaderSize))
 
-	return nil
+	_, err = writer.Write(frame)
+	return err
 }
 
 // ReceivePacket получает и дешифрует пакет из reader
@@ -54,17 +57,17 @@ func (p *Protocol) ReceivePacket(reader io.Reader) ([]byte, error) {
 
 	size := binary.BigEndian.Uint32(sizeBuf)
 
-	if size > MaxPacketSize {
+	if size > NonceSize+MaxPacketSize {
 		return nil, errors.New("packet size too large")
 	}
 
-	if size == 0 {
-		return nil, errors.New("zero packet size")
+	if size <= NonceSize {
+		return nil, errors.New("packet too short")
 	}
 
 	// Для зашифрованных данных используем пул если размер подходит
 	var encrypted []byte
-	if size <= TUNMTU+Overhead {
+	if size <= NonceSize+TUNMTU+Overhead {
 		buf := bufpool.GetEncryptedPacket()
 		encrypted = buf[:size]
 		defer func() {
@@ -78,7 +81,7 @@ func (p *Protocol) ReceivePacket(reader io.Reader) ([]byte, error) {
 		return nil, err
 	}
 
-	packet, err := p.crypto.Decrypt(encrypted, nil)
+	packet, err := p.crypto.Decrypt(encrypted[:NonceSize], encrypted[NonceSize:], nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,67 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// QuietZone ширина светлой рамки вокруг кода в модулях, которую требует стандарт
+const QuietZone = 4
+
+// terminalQuietZone рамка в терминале: вокруг кода и так обычно пусто, а высота экрана ограничена
+const terminalQuietZone = 2
+
+// Terminal возвращает код для вывода в терминал: два ряда модулей на строку символами
+// полублоков. Цвета задаются явно (черный на белом), поэтому код читается и в темной теме
+func (c *Code) Terminal() string {
+	const (
+		colors = "\x1b[30;47m"
+		reset  = "\x1b[0m"
+	)
+	var b strings.Builder
+	from, to := -terminalQuietZone, c.Size+terminalQuietZone
+	for y := from; y < to; y += 2 {
+		b.WriteString(colors)
+		for x := from; x < to; x++ {
+			top, bottom := c.Dark(x, y), c.Dark(x, y+1) && y+1 < to
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(reset + "\n")
+	}
+	return b.String()
+}
+
+// Image возвращает код картинкой, scale - размер модуля в пикселях
+func (c *Code) Image(scale int) image.Image {
+	size := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Dark(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// PNG возвращает код в формате PNG, scale - размер модуля в пикселях
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
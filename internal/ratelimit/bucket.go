@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// burstDuration сколько трафика (в секундах на полной скорости) может пройти пачкой
+	burstDuration = 0.1
+	// minBurst минимальный размер пачки в байтах, чтобы низкие лимиты не резали одиночные пакеты
+	minBurst = 16 * 1500
+)
+
+// Bucket token bucket для ограничения скорости в байтах.
+// Пакеты сверх лимита отбрасываются (policing), очереди здесь нет
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // байт в секунду
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket создает bucket для скорости bitsPerSecond. Для 0 возвращает nil (без ограничения)
+func NewBucket(bitsPerSecond uint64) *Bucket {
+	if bitsPerSecond == 0 {
+		return nil
+	}
+	rate := float64(bitsPerSecond) / 8
+	burst := rate * burstDuration
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Allow списывает n байт, если хватает токенов. nil bucket пропускает все
+func (b *Bucket) Allow(n int) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Reserve списывает n байт, если хватает токенов, и возвращает 0. Иначе ничего
+// не списывает и возвращает, через сколько токенов станет достаточно (shaping вместо
+// policing: пакет ждет, а не отбрасывается). nil bucket пропускает все
+func (b *Bucket) Reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	need := min(float64(n), b.burst)
+	if b.tokens < need {
+		return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	return 0
+}
+
+// refill начисляет токены за время с прошлого обращения
+func (b *Bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// ParseRate разбирает скорость вида "10mbit", "500k", "1g" или число бит в секунду.
+// Пустая строка и "0" означают отсутствие ограничения
+func ParseRate(value string) (uint64, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "bit"), "bps")
+
+	multiplier := uint64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1000
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1000 * 1000
+	case strings.HasSuffix(s, "g"):
+		multiplier = 1000 * 1000 * 1000
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return uint64(n * float64(multiplier)), nil
+}
+
+// FormatRate форматирует скорость в битах в секунду для логов
+func FormatRate(bitsPerSecond uint64) string {
+	switch {
+	case bitsPerSecond == 0:
+		return "unlimited"
+	case bitsPerSecond >= 1000*1000*1000:
+		return fmt.Sprintf("%gGbit/s", float64(bitsPerSecond)/1e9)
+	case bitsPerSecond >= 1000*1000:
+		return fmt.Sprintf("%gMbit/s", float64(bitsPerSecond)/1e6)
+	case bitsPerSecond >= 1000:
+		return fmt.Sprintf("%gkbit/s", float64(bitsPerSecond)/1e3)
+	}
+	return fmt.Sprintf("%dbit/s", bitsPerSecond)
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// sweepInterval как часто из Keyed удаляются ключи, у которых bucket снова полон
+	sweepInterval = time.Minute
+	// maxKeys сколько ключей Keyed помнит одновременно. Новые ключи сверх этого
+	// не ограничиваются, чтобы поток с разных адресов не съел память
+	maxKeys = 1 << 16
+)
+
+// Keyed token bucket для событий (не байт) с отдельным bucket на каждый ключ,
+// например на IP адрес. События сверх лимита отклоняются
+type Keyed[K comparable] struct {
+	mu        sync.Mutex
+	rate      float64 // событий в секунду
+	burst     float64
+	buckets   map[K]*keyedBucket
+	lastSweep time.Time
+}
+
+// keyedBucket состояние bucket одного ключа
+type keyedBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewKeyed создает лимитер на perSecond событий в секунду для каждого ключа с пачкой до burst.
+// Для perSecond <= 0 возвращает nil (без ограничения)
+func NewKeyed[K comparable](perSecond float64, burst int) *Keyed[K] {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Keyed[K]{
+		rate:      perSecond,
+		burst:     max(float64(burst), 1),
+		buckets:   make(map[K]*keyedBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow списывает одно событие ключа, если хватает токенов. nil лимитер пропускает все
+func (k *Keyed[K]) Allow(key K) bool {
+	if k == nil {
+		return true
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.lastSweep) > sweepInterval {
+		k.sweep(now)
+	}
+
+	b, ok := k.buckets[key]
+	if !ok {
+		if len(k.buckets) >= maxKeys {
+			return true
+		}
+		b = &keyedBucket{tokens: k.burst, last: now}
+		k.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * k.rate
+	if b.tokens > k.burst {
+		b.tokens = k.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep удаляет ключи, bucket которых успел наполниться: они ничем не отличаются от новых
+func (k *Keyed[K]) sweep(now time.Time) {
+	full := time.Duration(k.burst / k.rate * float64(time.Second))
+	for key, b := range k.buckets {
+		if now.Sub(b.last) >= full {
+			delete(k.buckets, key)
+		}
+	}
+	k.lastSweep = now
+}
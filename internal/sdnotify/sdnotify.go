@@ -0,0 +1,95 @@
+// Package sdnotify сообщает systemd о состоянии службы (sd_notify) для Type=notify
+// и пингует watchdog (WatchdogSec=). Вне systemd (нет NOTIFY_SOCKET) вызовы ничего не делают
+package sdnotify
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"myvpn/internal/logging"
+)
+
+// Состояния службы
+const (
+	// Ready служба запущена и обслуживает трафик
+	Ready = "READY=1"
+	// Stopping служба останавливается
+	Stopping = "STOPPING=1"
+	// Reloading служба перечитывает конфигурацию (до следующего Ready)
+	Reloading = "RELOADING=1"
+	// Watchdog служба жива
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify отправляет systemd состояние state (несколько через перевод строки)
+func Notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// Сокет в абстрактном пространстве имен
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// WatchdogInterval возвращает таймаут watchdog службы (WATCHDOG_USEC) или 0, если
+// watchdog выключен или настроен для другого процесса
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog пингует watchdog вдвое чаще таймаута, пока check возвращает nil, до закрытия
+// done. Если check возвращает ошибку, пинги прекращаются и systemd перезапускает службу
+// по таймауту; пинги возобновляются, если путь данных восстановился раньше
+func RunWatchdog(done <-chan struct{}, check func() error) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if err := check(); err != nil {
+			if !failing {
+				slog.Warn("Health check failed, not pinging systemd watchdog", logging.Err(err))
+				failing = true
+			}
+			continue
+		}
+		if failing {
+			slog.Info("Health check recovered, pinging systemd watchdog again")
+			failing = false
+		}
+		if err := Notify(Watchdog); err != nil {
+			slog.Warn("Failed to ping systemd watchdog", logging.Err(err))
+		}
+	}
+}
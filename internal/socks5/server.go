@@ -0,0 +1,258 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"myvpn/internal/logging"
+)
+
+const (
+	// DefaultDialTimeout время на соединение с адресом назначения
+	DefaultDialTimeout = 30 * time.Second
+	// handshakeTimeout время на приветствие и команду клиента
+	handshakeTimeout = 10 * time.Second
+)
+
+var logger = logging.For("socks5")
+
+// ServerOptions параметры сервера
+type ServerOptions struct {
+	// Dial открывает соединение с адресом назначения (host:port; host бывает именем)
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// DialTimeout время на соединение (0 - DefaultDialTimeout)
+	DialTimeout time.Duration
+}
+
+// Server SOCKS5 сервер без аутентификации: поддерживается только CONNECT, поэтому
+// слушать он должен локальный адрес
+type Server struct {
+	dial        func(ctx context.Context, network, address string) (net.Conn, error)
+	dialTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{} // соединения клиентов и назначения: закрываются вместе с сервером
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer создает сервер. Соединения принимает Serve
+func NewServer(opts ServerOptions) *Server {
+	s := &Server{
+		dial:        opts.Dial,
+		dialTimeout: opts.DialTimeout,
+		conns:       make(map[net.Conn]struct{}),
+	}
+	if s.dialTimeout <= 0 {
+		s.dialTimeout = DefaultDialTimeout
+	}
+	return s
+}
+
+// Serve принимает соединения ln до его закрытия или Close сервера
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	s.listeners = append(s.listeners, ln)
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// Close закрывает сокеты и соединения и ждет завершения обработчиков
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.listeners = nil
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// track запоминает соединение, чтобы закрыть его в Close. false - сервер уже закрыт
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// handle обслуживает одно соединение клиента
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	dst, err := s.handshake(conn)
+	if err != nil {
+		logger.Debug("Handshake failed", "client", conn.RemoteAddr(), logging.Err(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.dialTimeout)
+	target, err := s.dial(ctx, "tcp", dst.String())
+	cancel()
+	if err != nil {
+		logger.Debug("Connect failed", "dst", dst, logging.Err(err))
+		WriteReply(conn, replyFor(err), Addr{IP: netip.IPv4Unspecified()})
+		return
+	}
+	if !s.track(target) {
+		target.Close()
+		return
+	}
+	defer s.untrack(target)
+
+	bind := Addr{IP: netip.IPv4Unspecified()}
+	if addr, ok := target.LocalAddr().(*net.TCPAddr); ok {
+		bind = AddrFromAddrPort(addr.AddrPort())
+	}
+	if err := WriteReply(conn, ReplySucceeded, bind); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	Relay(conn, target)
+}
+
+// handshake читает приветствие и команду клиента и возвращает адрес назначения CONNECT.
+// На неподдерживаемые метод и команду отвечает отказом
+func (s *Server) handshake(conn net.Conn) (Addr, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return Addr{}, err
+	}
+	if hdr[0] != Version {
+		return Addr{}, fmt.Errorf("unsupported SOCKS version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return Addr{}, err
+	}
+	method := byte(MethodNoAcceptable)
+	for _, m := range methods {
+		if m == MethodNoAuth {
+			method = MethodNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{Version, method}); err != nil {
+		return Addr{}, err
+	}
+	if method == MethodNoAcceptable {
+		return Addr{}, errors.New("client does not offer no-auth method")
+	}
+
+	var req [3]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return Addr{}, err
+	}
+	if req[0] != Version {
+		return Addr{}, fmt.Errorf("unsupported SOCKS version %d", req[0])
+	}
+	dst, err := ReadAddr(conn)
+	if errors.Is(err, ErrAddressType) {
+		WriteReply(conn, ReplyAddressUnsupported, Addr{IP: netip.IPv4Unspecified()})
+	}
+	if err != nil {
+		return Addr{}, err
+	}
+	if req[1] != CmdConnect {
+		WriteReply(conn, ReplyCommandUnsupported, Addr{IP: netip.IPv4Unspecified()})
+		return Addr{}, fmt.Errorf("unsupported command %d", req[1])
+	}
+	return dst, nil
+}
+
+// replyFor код ответа для ошибки соединения с адресом назначения
+func replyFor(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ETIMEDOUT),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &dnsErr):
+		return ReplyHostUnreachable
+	}
+	return ReplyGeneralFailure
+}
+
+// Relay копирует данные в обе стороны. Конец потока с одной стороны передается другой
+// как half-close; ошибка с любой стороны закрывает оба соединения
+func Relay(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		copyHalf(b, a)
+		close(done)
+	}()
+	copyHalf(a, b)
+	<-done
+}
+
+func copyHalf(dst, src net.Conn) {
+	if _, err := io.Copy(dst, src); err != nil {
+		src.Close()
+		dst.Close()
+		return
+	}
+	closeWrite(dst)
+}
+
+// closeWrite закрывает запись соединения, а если half-close не поддерживается - все соединение
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,138 @@
+// Package socks5 протокол SOCKS5 (RFC 1928): кодирование адресов и ответов и сервер,
+// который принимает CONNECT от локальных приложений и открывает соединения через
+// переданную ему функцию Dial (например, через userspace стек туннеля)
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// Version версия протокола
+const Version = 0x05
+
+// Методы аутентификации
+const (
+	MethodNoAuth       = 0x00
+	MethodNoAcceptable = 0xff
+)
+
+// Команды
+const (
+	CmdConnect      = 0x01
+	CmdBind         = 0x02
+	CmdUDPAssociate = 0x03
+)
+
+// Типы адреса (ATYP)
+const (
+	AtypIPv4   = 0x01
+	AtypDomain = 0x03
+	AtypIPv6   = 0x04
+)
+
+// Коды ответа (REP)
+const (
+	ReplySucceeded          = 0x00
+	ReplyGeneralFailure     = 0x01
+	ReplyNotAllowed         = 0x02
+	ReplyNetworkUnreachable = 0x03
+	ReplyHostUnreachable    = 0x04
+	ReplyConnectionRefused  = 0x05
+	ReplyTTLExpired         = 0x06
+	ReplyCommandUnsupported = 0x07
+	ReplyAddressUnsupported = 0x08
+)
+
+// ErrAddressType неизвестный тип адреса
+var ErrAddressType = errors.New("socks5: unsupported address type")
+
+// Addr адрес SOCKS5: IP или доменное имя и порт
+type Addr struct {
+	// IP адрес; нулевой, если адрес задан именем
+	IP netip.Addr
+	// Host доменное имя, если адрес задан именем
+	Host string
+	Port uint16
+}
+
+// AddrFromAddrPort адрес из IP и порта
+func AddrFromAddrPort(ap netip.AddrPort) Addr {
+	return Addr{IP: ap.Addr().Unmap(), Port: ap.Port()}
+}
+
+// String адрес в виде host:port для net.Dial
+func (a Addr) String() string {
+	host := a.Host
+	if a.IP.IsValid() {
+		host = a.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(a.Port)))
+}
+
+// Append добавляет к b адрес в формате ATYP, ADDR, PORT
+func (a Addr) Append(b []byte) []byte {
+	switch {
+	case a.IP.Is4():
+		ip := a.IP.As4()
+		b = append(append(b, AtypIPv4), ip[:]...)
+	case a.IP.Is6():
+		ip := a.IP.As16()
+		b = append(append(b, AtypIPv6), ip[:]...)
+	default:
+		b = append(append(b, AtypDomain, byte(len(a.Host))), a.Host...)
+	}
+	return binary.BigEndian.AppendUint16(b, a.Port)
+}
+
+// ReadAddr читает адрес в формате ATYP, ADDR, PORT
+func ReadAddr(r io.Reader) (Addr, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return Addr{}, err
+	}
+	var a Addr
+	switch atyp[0] {
+	case AtypIPv4:
+		var ip [4]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return Addr{}, err
+		}
+		a.IP = netip.AddrFrom4(ip)
+	case AtypIPv6:
+		var ip [16]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return Addr{}, err
+		}
+		a.IP = netip.AddrFrom16(ip).Unmap()
+	case AtypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return Addr{}, err
+		}
+		host := make([]byte, n[0])
+		if _, err := io.ReadFull(r, host); err != nil {
+			return Addr{}, err
+		}
+		a.Host = string(host)
+	default:
+		return Addr{}, fmt.Errorf("%w: %d", ErrAddressType, atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return Addr{}, err
+	}
+	a.Port = binary.BigEndian.Uint16(port[:])
+	return a, nil
+}
+
+// WriteReply отправляет ответ на команду: VER, REP, RSV и адрес bind
+func WriteReply(w io.Writer, rep byte, bind Addr) error {
+	_, err := w.Write(bind.Append([]byte{Version, rep, 0x00}))
+	return err
+}
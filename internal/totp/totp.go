@@ -0,0 +1,94 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period шаг TOTP (RFC 6238), как у Google Authenticator и совместимых приложений
+	Period = 30 * time.Second
+	// Digits число цифр кода
+	Digits = 6
+	// Skew на сколько шагов код может отставать или спешить (расхождение часов, ввод вручную)
+	Skew = 1
+
+	// secretSize размер секрета (160 бит, рекомендация RFC 4226)
+	secretSize = 20
+)
+
+// encoding base32 без выравнивания, как в otpauth:// URI
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret создает случайный секрет в base32
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// ParseSecret проверяет и декодирует секрет в base32 (регистр, пробелы и выравнивание не важны)
+func ParseSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid TOTP secret: expected base32")
+	}
+	return key, nil
+}
+
+// Code возвращает код для шага step
+func Code(key []byte, step uint64) string {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(binary.BigEndian.AppendUint64(nil, step))
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// Step возвращает номер шага для момента t
+func Step(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(Period/time.Second)
+}
+
+// Verify проверяет код на момент now с допуском Skew шагов и возвращает шаг, которому
+// он соответствует. Шаги не новее after отклоняются, чтобы один код нельзя было
+// использовать дважды (0 - без ограничения)
+func Verify(key []byte, code string, now time.Time, after uint64) (uint64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(now)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if step <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(Code(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URI возвращает otpauth:// URI для приложений-аутентификаторов (обычно в виде QR кода)
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	v.Set("digits", fmt.Sprint(Digits))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
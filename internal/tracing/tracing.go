@@ -0,0 +1,128 @@
+// Package tracing пишет спаны OpenTelemetry для handshake и этапов обработки пакетов
+// (шифрование, сжатие, запись в TUN) и экспортирует их по OTLP. Спаны пакетов пишутся
+// только для выборки пакетов, чтобы трассировка не замедляла туннель
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Протоколы OTLP
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+)
+
+// DefaultSampleRate доля пакетов, для которых пишутся спаны этапов обработки
+const DefaultSampleRate = 0.001
+
+// Options параметры экспорта трассировки
+type Options struct {
+	// Endpoint адрес OTLP коллектора (host:port)
+	Endpoint string
+	// Protocol ProtocolGRPC или ProtocolHTTP (пустая строка - gRPC)
+	Protocol string
+	// Insecure подключаться к коллектору без TLS
+	Insecure bool
+	// ServiceName имя сервиса в спанах (service.name)
+	ServiceName string
+	// SampleRate доля пакетов от 0 до 1, для которых пишутся спаны этапов обработки.
+	// 0 - только handshake, 1 - каждый пакет
+	SampleRate float64
+}
+
+var (
+	tracer trace.Tracer = noop.NewTracerProvider().Tracer("")
+	// packetEvery спаны пишутся для каждого packetEvery-го пакета (0 - не пишутся)
+	packetEvery atomic.Uint64
+	packets     atomic.Uint64
+	// noopSpan возвращается для пакетов вне выборки
+	noopSpan = trace.SpanFromContext(context.Background())
+)
+
+// Setup настраивает экспорт спанов и возвращает функцию, которая отправляет оставшиеся
+// спаны и останавливает экспорт. Вызывается один раз при запуске
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		return nil, fmt.Errorf("trace sample rate must be between 0 and 1, got %v", opts.SampleRate)
+	}
+	var client otlptrace.Client
+	switch strings.ToLower(opts.Protocol) {
+	case ProtocolGRPC, "":
+		grpcOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		}
+		client = otlptracegrpc.NewClient(grpcOpts...)
+	case ProtocolHTTP:
+		httpOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		client = otlptracehttp.NewClient(httpOpts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q (grpc, http)", opts.Protocol)
+	}
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("myvpn")
+	if opts.SampleRate > 0 {
+		packetEvery.Store(uint64(math.Round(1 / opts.SampleRate)))
+	}
+	return provider.Shutdown, nil
+}
+
+// Start начинает спан, который пишется всегда (handshake и другие редкие события)
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Record пишет уже завершенный спан с началом start. Ошибка err отмечает спан как неудачный
+func Record(name string, start time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := tracer.Start(context.Background(), name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	Fail(span, err)
+	span.End()
+}
+
+// Fail отмечает спан как неудачный, если err не nil
+func Fail(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// PacketSpan начинает спан этапа обработки пакета размером size, если пакет попал
+// в выборку. Для остальных возвращает пустой спан, End которого ничего не стоит
+func PacketSpan(name string, size int) trace.Span {
+	every := packetEvery.Load()
+	if every == 0 || packets.Add(1)%every != 0 {
+		return noopSpan
+	}
+	_, span := tracer.Start(context.Background(), name, trace.WithAttributes(attribute.Int("bytes", size)))
+	return span
+}
@@ -9,16 +9,17 @@ const (
 	DefaultWindowSize = 1024
 )
 
-// AntiReplayWindow implements a sliding window for sequence numbers to prevent replay attacks
+// AntiReplayWindow implements a sliding window for sequence numbers to prevent replay attacks.
+// Sequence numbers are 64-bit and never wrap in practice, so the window only moves forward.
 type AntiReplayWindow struct {
 	mu     sync.Mutex
 	window []bool
-	head   uint32
-	size   uint32
+	head   uint64
+	size   uint64
 }
 
 // NewAntiReplayWindow creates a new AntiReplayWindow
-func NewAntiReplayWindow(size uint32) *AntiReplayWindow {
+func NewAntiReplayWindow(size uint64) *AntiReplayWindow {
 	if size == 0 {
 		size = DefaultWindowSize
 	}
@@ -32,7 +33,7 @@ func NewAntiReplayWindow(size uint32) *AntiReplayWindow {
 // Check verifies if a sequence number is acceptable (not seen before and within the window).
 // If acceptable, it marks the sequence number as seen and returns true.
 // If it's a replay or too old, it returns false.
-func (ar *AntiReplayWindow) Check(seq uint32) bool {
+func (ar *AntiReplayWindow) Check(seq uint64) bool {
 	ar.mu.Lock()
 	defer ar.mu.Unlock()
 
@@ -52,12 +53,12 @@ func (ar *AntiReplayWindow) Check(seq uint32) bool {
 		shift := seq - ar.head
 		if shift >= ar.size {
 			// Shift is larger than window size, clear the whole window
-			for i := uint32(0); i < ar.size; i++ {
+			for i := uint64(0); i < ar.size; i++ {
 				ar.window[i] = false
 			}
 		} else {
 			// Clear bits for the new window positions
-			for i := uint32(1); i <= shift; i++ {
+			for i := uint64(1); i <= shift; i++ {
 				ar.window[(ar.head+i)%ar.size] = false
 			}
 		}
@@ -76,3 +77,41 @@ func (ar *AntiReplayWindow) Check(seq uint32) bool {
 	ar.window[index] = true
 	return true
 }
+
+// Seen reports whether Check would reject seq, without marking it as seen.
+// It lets callers drop replays cheaply before doing expensive work such as decryption.
+func (ar *AntiReplayWindow) Seen(seq uint64) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.head >= ar.size && seq < ar.head-ar.size {
+		return true
+	}
+	if seq > ar.head {
+		return false
+	}
+	return ar.window[seq%ar.size]
+}
+
+// Resize grows the window to size, keeping the state of the sequence numbers it already tracks.
+// Sequence numbers that fell behind the old window are marked as seen: they were rejected
+// before and must stay rejected. The window never shrinks.
+func (ar *AntiReplayWindow) Resize(size uint64) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if size <= ar.size {
+		return
+	}
+	window := make([]bool, size)
+	for i := uint64(0); i < size && i <= ar.head; i++ {
+		seq := ar.head - i
+		seen := true
+		if i < ar.size {
+			seen = ar.window[seq%ar.size]
+		}
+		window[seq%size] = seen
+	}
+	ar.window = window
+	ar.size = size
+}
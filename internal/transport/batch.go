@@ -0,0 +1,314 @@
+package transport
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+
+	"myvpn/internal/bufpool"
+	"myvpn/internal/compress"
+)
+
+// BatchSize максимальное число датаграмм, которые читаются или отправляются одним
+// системным вызовом recvmmsg/sendmmsg
+const BatchSize = 64
+
+// Packet пакет для пакетного ввода-вывода (ReadBatch/WriteBatch)
+type Packet struct {
+	// Data данные пакета. Для ReadBatch это буфер (используется вся емкость),
+	// после чтения он обрезается до длины расшифрованных данных
+	Data      []byte
+	Codec     compress.Codec
+	Addr      *net.UDPAddr
+	SessionID uint64
+	// DSCP внутреннего пакета: при SetDSCP(DSCPCopy) WriteBatch переносит его на датаграмму
+	DSCP uint8
+	// Err ошибка обработки этого пакета (повтор, ошибка дешифровки, слишком большой пакет).
+	// Остальные пакеты пачки при этом обрабатываются
+	Err error
+}
+
+// batchConn общий интерфейс ipv4.PacketConn и ipv6.PacketConn
+// (их Message - один и тот же тип socket.Message)
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batchReader сокет и состояние ReadBatch. У транспорта по одному reader на каждый сокет
+// группы SO_REUSEPORT (см. SetShards), первый - основной сокет транспорта
+type batchReader struct {
+	conn       *net.UDPConn
+	batch      batchConn
+	rbufs      [][]byte       // буферы ReadBatch
+	rmsgs      []ipv4.Message // заголовки recvmmsg
+	segments   []segment      // принятые, но еще не разобранные датаграммы
+	segmentPos int
+	gro        bool // прием с UDP_GRO (включается при первом чтении)
+}
+
+// segment датаграмма, принятая ReadBatch, но еще не разобранная
+type segment struct {
+	buf       []byte
+	addr      *net.UDPAddr
+	recovered bool // восстановлена FEC: без SOCKS5 заголовка
+}
+
+// newBatchConn оборачивает сокет для пакетного ввода-вывода.
+// Dual-stack сокет ([::]) обслуживается через ipv6.PacketConn
+func newBatchConn(conn *net.UDPConn) batchConn {
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(conn)
+	}
+	return ipv6.NewPacketConn(conn)
+}
+
+// init выделяет буферы ReadBatch и включает UDP_GRO, если ядро его поддерживает.
+// GRO включается только здесь: склеенную датаграмму умеет разбирать только ReadBatch
+func (r *batchReader) init(groSupported bool) {
+	bufSize := MaxPacketSize + HeaderSize + 100 + 22 // +100 MAC, +22 SOCKS5 (IPv6)
+	if groSupported && enableGRO(r.conn) == nil {
+		r.gro = true
+		bufSize = maxGSOSize
+	}
+
+	r.rbufs = make([][]byte, BatchSize)
+	r.rmsgs = make([]ipv4.Message, BatchSize)
+	for i := range r.rbufs {
+		r.rbufs[i] = make([]byte, bufSize)
+		r.rmsgs[i].Buffers = r.rbufs[i : i+1]
+		if r.gro {
+			r.rmsgs[i].OOB = make([]byte, unix.CmsgSpace(4))
+		}
+	}
+}
+
+// readSegments читает пачку датаграмм и раскладывает склеенные (GRO) датаграммы на сегменты
+func (r *batchReader) readSegments() error {
+	for i := range r.rmsgs {
+		r.rmsgs[i].N = 0
+		r.rmsgs[i].NN = 0
+		r.rmsgs[i].Addr = nil
+	}
+
+	n, err := r.batch.ReadBatch(r.rmsgs, 0)
+	if err != nil {
+		return err
+	}
+
+	r.segments = r.segments[:0]
+	r.segmentPos = 0
+	for i := 0; i < n; i++ {
+		msg := &r.rmsgs[i]
+		addr, _ := msg.Addr.(*net.UDPAddr)
+		buf := r.rbufs[i][:msg.N]
+
+		size := 0
+		if r.gro {
+			size = groSegmentSize(msg.OOB[:msg.NN])
+		}
+		if size <= 0 {
+			size = len(buf)
+		}
+		for len(buf) > 0 {
+			end := size
+			if end > len(buf) {
+				end = len(buf)
+			}
+			r.segments = append(r.segments, segment{buf: buf[:end], addr: addr})
+			buf = buf[end:]
+		}
+	}
+	return nil
+}
+
+// ReadBatch читает датаграммы одним системным вызовом (recvmmsg, с UDP_GRO - еще и склеенные ядром)
+// и разбирает их так же, как ReadSession. Возвращает число заполненных элементов pkts.
+// Датаграммы, не поместившиеся в pkts, возвращаются следующим вызовом без обращения к сокету.
+// Keepalive и управляющие сообщения дают пакеты с пустыми Data.
+// Не предназначен для конкурентного вызова из нескольких горутин
+func (t *UDPTransport) ReadBatch(pkts []Packet) (int, error) {
+	return t.ReadShard(0, pkts)
+}
+
+// ReadShard то же, что ReadBatch, для сокета shard группы SO_REUSEPORT (см. SetShards).
+// Разные сокеты можно читать параллельно, один сокет - из одной горутины
+func (t *UDPTransport) ReadShard(shard int, pkts []Packet) (int, error) {
+	r := t.readers[shard]
+	if r.rmsgs == nil {
+		r.init(t.groSupported)
+	}
+	if r.segmentPos >= len(r.segments) {
+		if recovered := t.takeRecovered(); len(recovered) > 0 {
+			r.segments, r.segmentPos = recovered, 0
+		} else if err := r.readSegments(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := len(r.segments) - r.segmentPos
+	if n > len(pkts) {
+		n = len(pkts)
+	}
+	segments := r.segments[r.segmentPos : r.segmentPos+n]
+	r.segmentPos += n
+
+	// Каждая датаграмма разбирается в свой элемент pkts, порядок не меняется
+	t.workers.run(n, func(i int) {
+		p := &pkts[i]
+		handle, via := t.handleDatagram, r
+		if segments[i].recovered {
+			handle, via = t.handlePacket, nil
+		}
+		size, codec, from, sessionID, err := handle(segments[i].buf, segments[i].addr, p.Data[:cap(p.Data)], via)
+		p.Data = p.Data[:size]
+		p.Codec = codec
+		p.Addr = from
+		p.SessionID = sessionID
+		p.Err = err
+	})
+	return n, nil
+}
+
+// WriteBatch шифрует пакеты с данными и отправляет их минимальным числом вызовов sendmmsg.
+// Если ядро поддерживает UDP_SEGMENT, подряд идущие пакеты одному адресу склеиваются
+// в один GSO буфер. Пакеты, которые не удалось зашифровать, пропускаются с заполненным Err.
+// Возвращает число отправленных пакетов
+func (t *UDPTransport) WriteBatch(pkts []Packet) (int, error) {
+	msgs := make([]ipv4.Message, 0, len(pkts))
+	segmentSizes := make([]int, 0, len(pkts))
+	counts := make([]int, 0, len(pkts))
+	dscps := make([]uint8, 0, len(pkts))
+	vias := make([]*batchReader, 0, len(pkts)) // сокет каждой датаграммы (см. AddListener)
+	var gsoBufs []*[maxGSOSize]byte            // буферы склеенных датаграмм, возвращаются после отправки
+	gso := t.gso.Load()
+
+	// Sequence numbers выделяются в порядке пачки (у каждой сессии свой счетчик), шифрование идет параллельно.
+	// Пакеты больше допустимого размера пропускаются здесь и уходят фрагментами ниже
+	seqs := make([]uint64, len(pkts))
+	fits := make([]bool, len(pkts))
+	for i := range pkts {
+		if fits[i] = len(pkts[i].Data) <= t.maxPayload(pkts[i].SessionID); fits[i] {
+			seqs[i] = t.reserveSequence(pkts[i].SessionID, 1)
+		}
+	}
+	// Пакеты шифруются в буферы из пула, которые возвращаются после отправки пачки
+	sealed := make([][]byte, len(pkts))
+	t.workers.run(len(pkts), func(i int) {
+		p := &pkts[i]
+		if fits[i] {
+			sealed[i], p.Err = t.sealPacketSeq(bufpool.GetDatagram(), PacketTypeData, p.Data, p.Codec, p.SessionID, seqs[i])
+		}
+	})
+
+	fragmented := 0
+	for i := range pkts {
+		p := &pkts[i]
+		if p.Err != nil {
+			continue
+		}
+		if sealed[i] == nil {
+			if _, p.Err = t.writeFragments(p.Data, p.Codec, p.Addr, p.SessionID, t.outerDSCP(p.DSCP)); p.Err == nil {
+				fragmented++
+			}
+			continue
+		}
+		datagram, dst := t.frame(sealed[i], p.Addr)
+		dscp := t.outerDSCP(p.DSCP)
+		via := t.sender(p.SessionID)
+
+		// В GSO буфере все сегменты одного размера, кроме последнего, который может быть меньше.
+		// DSCP у всех сегментов общий
+		if last := len(msgs) - 1; gso && last >= 0 {
+			buf := msgs[last].Buffers[0]
+			size := segmentSizes[last]
+			if sameAddr(msgs[last].Addr.(*net.UDPAddr), dst) && dscps[last] == dscp && vias[last] == via &&
+				len(buf)%size == 0 && len(datagram) <= size &&
+				counts[last] < maxGSOSegments && len(buf)+len(datagram) <= maxGSOSize {
+				if counts[last] == 1 {
+					// Вторая датаграмма: первая переносится в GSO буфер, дальше append не выделяет память
+					gsoBuf := gsoBuffers.Get().(*[maxGSOSize]byte)
+					gsoBufs = append(gsoBufs, gsoBuf)
+					buf = append(gsoBuf[:0], buf...)
+				}
+				msgs[last].Buffers[0] = append(buf, datagram...)
+				counts[last]++
+				continue
+			}
+		}
+
+		msgs = append(msgs, ipv4.Message{Buffers: [][]byte{datagram}, Addr: dst})
+		segmentSizes = append(segmentSizes, len(datagram))
+		counts = append(counts, 1)
+		dscps = append(dscps, dscp)
+		vias = append(vias, via)
+	}
+
+	for i := range msgs {
+		msgs[i].OOB = control(segmentSizes[i], counts[i], dscps[i], msgs[i].Addr.(*net.UDPAddr))
+	}
+
+	sent := fragmented
+	defer func() {
+		for i, packet := range sealed {
+			if packet != nil && pkts[i].Err == nil {
+				t.captured(packet, pkts[i].Addr, true)
+				t.protect(packet, pkts[i].Addr)
+			}
+			bufpool.PutDatagram(packet)
+		}
+		for _, buf := range gsoBufs {
+			gsoBuffers.Put(buf)
+		}
+	}()
+	for i := 0; i < len(msgs); {
+		// Одним вызовом уходят подряд идущие датаграммы одного сокета
+		end := i + 1
+		for end < len(msgs) && vias[end] == vias[i] {
+			end++
+		}
+		n, err := vias[i].batch.WriteBatch(msgs[i:end], 0)
+		if err != nil && gso && isGSOError(err) {
+			// Драйвер не поддерживает GSO: отключаем и досылаем оставшееся по одной датаграмме
+			t.gso.Store(false)
+			var plain []ipv4.Message
+			var plainDSCP []uint8
+			var plainVias []*batchReader
+			for j := i; j < len(msgs); j++ {
+				split := splitGSO(msgs[j], segmentSizes[j])
+				plain = append(plain, split...)
+				for range split {
+					plainDSCP = append(plainDSCP, dscps[j])
+					plainVias = append(plainVias, vias[j])
+				}
+			}
+			msgs, dscps, vias, gso = plain, plainDSCP, plainVias, false
+			segmentSizes = make([]int, len(plain))
+			counts = make([]int, len(plain))
+			for j := range plain {
+				segmentSizes[j] = len(plain[j].Buffers[0])
+				counts[j] = 1
+				plain[j].OOB = control(segmentSizes[j], 1, dscps[j], plain[j].Addr.(*net.UDPAddr))
+			}
+			i = 0
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+		for j := i; j < i+n; j++ {
+			metricPacketsSent.Add(uint64(counts[j]))
+			metricBytesSent.Add(uint64(len(msgs[j].Buffers[0])))
+			sent += counts[j]
+		}
+		i += n
+	}
+	return sent, nil
+}
+
+// sameAddr сравнивает UDP адреса
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
@@ -0,0 +1,26 @@
+package transport
+
+import "net"
+
+// CaptureFunc получает датаграммы протокола без SOCKS5 заголовка, отправленные (out)
+// и принятые сокетом с адресом local от remote. Вызывается синхронно, в том числе
+// из нескольких горутин ReadBatch
+type CaptureFunc func(packet []byte, local, remote *net.UDPAddr, out bool)
+
+// SetCapture включает запись внешних датаграмм (nil - выключает). Можно вызывать
+// во время работы транспорта
+func (t *UDPTransport) SetCapture(f CaptureFunc) {
+	if f == nil {
+		t.capture.Store(nil)
+		return
+	}
+	t.capture.Store(&f)
+}
+
+// captured передает датаграмму обработчику записи, если он задан
+func (t *UDPTransport) captured(packet []byte, remote *net.UDPAddr, out bool) {
+	if f := t.capture.Load(); f != nil {
+		local, _ := t.conn.LocalAddr().(*net.UDPAddr)
+		(*f)(packet, local, remote, out)
+	}
+}
@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+const (
+	// PacketTypeCookie пакет клиента с cookie: тип (1) + cookie (16) + исходная датаграмма
+	PacketTypeCookie = 0x0B
+	// PacketTypeCookieReply ответ сервера с cookie для адреса клиента:
+	// тип (1) + session ID (8) + cookie (16)
+	PacketTypeCookieReply = 0x0C
+
+	// CookieRotation период смены секрета cookie. Cookie действителен до двух периодов
+	CookieRotation = 2 * time.Minute
+	// DefaultCookieThreshold число пакетов неизвестных сессий в секунду,
+	// выше которого сервер требует cookie
+	DefaultCookieThreshold = 1000
+
+	cookieSize      = 16
+	cookieReplySize = 1 + 8 + cookieSize
+)
+
+// cookieChecker защищает сервер от потока пакетов с поддельных адресов. Под нагрузкой
+// пакет неизвестной сессии расшифровывается, только если к нему приложен cookie - MAC адреса
+// отправителя на секрете сервера. Без него сервер отвечает cookie, а клиент повторяет пакет
+// с ним. Получить cookie может только тот, кто принимает пакеты на адресе отправителя.
+//
+// Ключ новой сессии сервер узнает, только расшифровав ее пакет (с несколькими ключами -
+// перебором), поэтому ответ с cookie не шифруется. Чтобы поддельный ответ не мешал клиенту,
+// пакет с неверным cookie обрабатывается как пакет без cookie, а не отбрасывается
+type cookieChecker struct {
+	threshold int64
+	window    atomic.Int64 // секунда, за которую считаются пакеты (Unix)
+	count     atomic.Int64 // пакеты неизвестных сессий за эту секунду
+
+	mu      sync.RWMutex
+	secret  [32]byte
+	prev    [32]byte // предыдущий секрет: выданные при нем cookie еще действительны
+	rotated time.Time
+}
+
+// clientCookie cookie, полученный клиентом от сервера
+type clientCookie struct {
+	mac      [cookieSize]byte
+	received time.Time
+}
+
+// SetCookieThreshold включает на серверном транспорте проверку cookie для пакетов
+// неизвестных сессий, когда их приходит больше threshold в секунду.
+// 0 выключает проверку. Вызывается до начала обмена пакетами
+func (t *UDPTransport) SetCookieThreshold(threshold int) error {
+	if threshold <= 0 {
+		t.cookies = nil
+		return nil
+	}
+	c := &cookieChecker{threshold: int64(threshold)}
+	if err := c.rotate(time.Now()); err != nil {
+		return err
+	}
+	t.cookies = c
+	return nil
+}
+
+// underLoad учитывает пакет неизвестной сессии и сообщает, превышен ли порог
+func (c *cookieChecker) underLoad() bool {
+	now := time.Now().Unix()
+	if window := c.window.Load(); window != now && c.window.CompareAndSwap(window, now) {
+		c.count.Store(0)
+	}
+	return c.count.Add(1) > c.threshold
+}
+
+// rotate меняет секрет, если прошел CookieRotation
+func (c *cookieChecker) rotate(now time.Time) error {
+	c.mu.RLock()
+	fresh := now.Sub(c.rotated) < CookieRotation
+	c.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.rotated) < CookieRotation {
+		return nil
+	}
+	c.prev = c.secret
+	if _, err := rand.Read(c.secret[:]); err != nil {
+		return fmt.Errorf("failed to generate cookie secret: %w", err)
+	}
+	c.rotated = now
+	return nil
+}
+
+// cookieMAC вычисляет cookie адреса addr на секрете secret
+func cookieMAC(secret []byte, addr *net.UDPAddr) [cookieSize]byte {
+	h, _ := blake2s.New128(secret)
+	h.Write(addr.IP.To16())
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(addr.Port)))
+	var mac [cookieSize]byte
+	copy(mac[:], h.Sum(nil))
+	return mac
+}
+
+// issue возвращает текущий cookie адреса
+func (c *cookieChecker) issue(addr *net.UDPAddr) [cookieSize]byte {
+	c.rotate(time.Now())
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return cookieMAC(c.secret[:], addr)
+}
+
+// valid проверяет cookie адреса по текущему и предыдущему секрету
+func (c *cookieChecker) valid(mac []byte, addr *net.UDPAddr) bool {
+	c.rotate(time.Now())
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cur, prev := cookieMAC(c.secret[:], addr), cookieMAC(c.prev[:], addr)
+	return subtle.ConstantTimeCompare(mac, cur[:]) == 1 || subtle.ConstantTimeCompare(mac, prev[:]) == 1
+}
+
+// unwrapCookie снимает cookie с пакета клиента и возвращает исходную датаграмму
+// и результат проверки cookie
+func (t *UDPTransport) unwrapCookie(buf []byte, addr *net.UDPAddr) ([]byte, bool, error) {
+	if !t.server {
+		return nil, false, errors.New("unexpected cookie packet")
+	}
+	if len(buf) < 1+cookieSize+HeaderSize {
+		return nil, false, errors.New("cookie packet too short")
+	}
+	valid := t.cookies != nil && t.cookies.valid(buf[1:1+cookieSize], addr)
+	if !valid && t.cookies != nil {
+		metricCookieInvalid.Inc()
+	}
+	return buf[1+cookieSize:], valid, nil
+}
+
+// replyCookie отправляет клиенту cookie его адреса через сокет via, принявший пакет. Ответ
+// не длиннее пакета, на который отвечает, поэтому сервер нельзя использовать для усиления
+// атаки на чужой адрес
+func (t *UDPTransport) replyCookie(packet []byte, addr *net.UDPAddr, sessionID uint64, via *batchReader) {
+	if len(packet) < cookieReplySize {
+		return
+	}
+	mac := t.cookies.issue(addr)
+
+	reply := make([]byte, 1+8, cookieReplySize)
+	reply[0] = PacketTypeCookieReply
+	binary.BigEndian.PutUint64(reply[1:], sessionID)
+	if via == nil {
+		via = t.readers[0]
+	}
+	t.writeVia(via, append(reply, mac[:]...), addr, 0)
+	metricCookieReplies.Inc()
+}
+
+// handleCookieReply сохраняет cookie из ответа сервера. Следующие пакеты клиент отправляет
+// с ним, пока сервер не ответит на них или cookie не устареет
+func (t *UDPTransport) handleCookieReply(buf []byte) error {
+	if t.server || len(buf) != cookieReplySize {
+		return errors.New("unexpected cookie reply")
+	}
+	if binary.BigEndian.Uint64(buf[1:]) != t.sessionID {
+		return errors.New("cookie reply for another session")
+	}
+	cookie := &clientCookie{received: time.Now()}
+	copy(cookie.mac[:], buf[9:])
+	t.cookie.Store(cookie)
+	return nil
+}
+
+// wrapCookie добавляет к пакету клиента cookie сервера, если он есть и не устарел
+func (t *UDPTransport) wrapCookie(packet []byte) []byte {
+	cookie := t.cookie.Load()
+	if cookie == nil {
+		return packet
+	}
+	if time.Since(cookie.received) > CookieRotation {
+		t.cookie.CompareAndSwap(cookie, nil)
+		return packet
+	}
+	wrapped := make([]byte, 0, 1+cookieSize+len(packet))
+	wrapped = append(wrapped, PacketTypeCookie)
+	wrapped = append(wrapped, cookie.mac[:]...)
+	return append(wrapped, packet...)
+}
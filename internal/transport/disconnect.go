@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"myvpn/internal/compress"
+)
+
+// DisconnectMaxAge максимальный возраст сообщения об отключении. Пакет отключения несет время
+// отправки: после переподключения anti-replay окно новое, и без метки времени перехваченный
+// пакет можно было бы повторить, чтобы снова разорвать сессию
+const DisconnectMaxAge = 30 * time.Second
+
+// disconnectSize размер тела пакета отключения (время отправки, UnixNano)
+const disconnectSize = 8
+
+// DisconnectHandler вызывается, когда другая сторона сообщила об отключении сессии
+type DisconnectHandler func(addr *net.UDPAddr, sessionID uint64)
+
+// SetDisconnectHandler задает обработчик сообщений об отключении.
+// Обработчик вызывается синхронно из Read, поэтому не должен блокироваться
+func (t *UDPTransport) SetDisconnectHandler(h DisconnectHandler) {
+	t.onHangup = h
+}
+
+// WriteDisconnect сообщает другой стороне, что сессия sessionID закрывается
+func (t *UDPTransport) WriteDisconnect(addr *net.UDPAddr, sessionID uint64) error {
+	if addr == nil {
+		return fmt.Errorf("remote address not set")
+	}
+	stamp := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	_, err := t.writePacket(PacketTypeDisconnect, stamp, compress.CodecNone, addr, sessionID, 0)
+	return err
+}
+
+// handleDisconnect проверяет время отправки расшифрованного пакета отключения
+// и передает его обработчику
+func (t *UDPTransport) handleDisconnect(body []byte, addr *net.UDPAddr, sessionID uint64) error {
+	if len(body) != disconnectSize {
+		return fmt.Errorf("invalid disconnect packet size: %d", len(body))
+	}
+	age := time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(body))))
+	if age > DisconnectMaxAge || age < -DisconnectMaxAge {
+		return fmt.Errorf("stale disconnect packet (sent %v ago)", age.Round(time.Second))
+	}
+	if t.onHangup != nil {
+		t.onHangup(addr, sessionID)
+	}
+	return nil
+}
@@ -0,0 +1,898 @@
+package transport
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"myvpn/internal/logging"
+	"myvpn/internal/ratelimit"
+)
+
+// DNS туннель - транспорт на крайний случай, для сетей, где наружу выпускают только запросы
+// к DNS резолверу. Сервер - авторитетный DNS сервер зоны (NS записи зоны указывают на него),
+// и резолвер сети передает ему запросы к именам в ней.
+//
+// Датаграммы к серверу кодируются в имя TXT запроса: <base32>.<зона>, где в base32
+// заголовок (сессия, номер запроса, номер пакета, номер фрагмента и их число) и фрагмент
+// датаграммы. Номер запроса делает каждое имя новым, поэтому резолвер не отвечает из кэша.
+// Датаграммы к клиенту - фрагменты в строках TXT ответа. Сервер отвечает только на запросы,
+// поэтому клиент держит у него несколько запросов без данных (dnsPollers), а сервер отвечает
+// на них, как только есть пакеты к клиенту, или через dnsPollHold
+const (
+	// dnsQueryHeader заголовок в имени запроса: сессия (4), номер запроса (2), номер пакета (2),
+	// номер фрагмента и число фрагментов (0 - запрос без данных)
+	dnsQueryHeader = 10
+	// dnsRecordHeader заголовок фрагмента в ответе: номер пакета (2), номер фрагмента,
+	// число фрагментов и длина (2)
+	dnsRecordHeader = 6
+	// dnsMaxName наибольшая длина имени (без точки в конце) и dnsMaxLabel - метки в нем
+	dnsMaxName  = 253
+	dnsMaxLabel = 63
+	// dnsEDNSSize размер ответа, который клиент объявляет в EDNS0: такой ответ проходит
+	// без фрагментации IP почти везде. Без EDNS0 ответ не больше 512 байт
+	dnsEDNSSize = 1232
+	dnsMinSize  = 512
+	// dnsPollers число запросов без данных, которые клиент держит у сервера
+	dnsPollers = 8
+	// dnsMaxInflight наибольшее число запросов клиента без ответа
+	dnsMaxInflight = 32
+	// dnsPollHold сколько сервер держит запрос без данных для клиента. Меньше таймаута
+	// рекурсивных резолверов (от 1 с), иначе они повторяют запрос или отвечают SERVFAIL
+	dnsPollHold = 500 * time.Millisecond
+	// dnsQueryTimeout через сколько клиент считает запрос без ответа потерянным. Пока
+	// потерянный запрос не забыт, вместо него не уходит новый
+	dnsQueryTimeout = 3 * dnsPollHold
+	// dnsMaxQueue наибольшее число фрагментов в очереди на отправку, лишние пакеты теряются.
+	// Длинная очередь через медленный канал - задержка в секунды, на которую TCP отвечает
+	// повторами, а не снижением скорости
+	dnsMaxQueue = 128
+	// dnsMaxPartial наибольшее число собираемых из фрагментов пакетов
+	dnsMaxPartial = 32
+	// dnsAnswered сколько последних ответов сессии сервер помнит для повторов запросов резолвером
+	dnsAnswered = 64
+	// dnsMaxSessions наибольшее число сессий DNS туннеля на сервере: у каждой свой UDP сокет
+	// к серверу VPN, а номер сессии в запросе ничем не подтвержден
+	dnsMaxSessions = 1024
+	// dnsHandshakeTimeout сколько живет сессия, которой сервер VPN еще ни разу не ответил
+	// (пакеты не прошли проверку подлинности), вместо StreamIdleTimeout
+	dnsHandshakeTimeout = 10 * time.Second
+)
+
+// dnsEncoding base32 без заглавных букв: резолверы меняют регистр имен (0x20), поэтому
+// имя декодируется после приведения к нижнему регистру
+var dnsEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// dnsFragment фрагмент датаграммы: count фрагментов с номерами 0..count-1
+type dnsFragment struct {
+	pkt   uint16
+	idx   uint8
+	count uint8
+	data  []byte
+}
+
+// dnsSplit делит датаграмму на фрагменты не больше size байт
+func dnsSplit(pkt uint16, p []byte, size int) ([]dnsFragment, error) {
+	count := (len(p) + size - 1) / size
+	if count > math.MaxUint8 {
+		return nil, fmt.Errorf("packet too large for DNS tunnel: %d bytes", len(p))
+	}
+	count = max(count, 1)
+	frags := make([]dnsFragment, 0, count)
+	for i := range count {
+		chunk := p[i*size : min((i+1)*size, len(p))]
+		frags = append(frags, dnsFragment{pkt: pkt, idx: uint8(i), count: uint8(count), data: chunk})
+	}
+	return frags, nil
+}
+
+// dnsReassembler собирает датаграммы из фрагментов. Фрагменты потерянного пакета
+// вытесняются новыми пакетами
+type dnsReassembler struct {
+	partial map[uint16]*dnsPartial
+}
+
+type dnsPartial struct {
+	frags [][]byte
+	got   int
+	at    time.Time
+}
+
+// add добавляет фрагмент и возвращает датаграмму, если она собрана
+func (r *dnsReassembler) add(f dnsFragment) []byte {
+	if f.count <= 1 {
+		return f.data
+	}
+	if f.idx >= f.count {
+		return nil
+	}
+	if r.partial == nil {
+		r.partial = make(map[uint16]*dnsPartial)
+	}
+	p := r.partial[f.pkt]
+	if p == nil || len(p.frags) != int(f.count) {
+		if len(r.partial) >= dnsMaxPartial {
+			r.evict()
+		}
+		p = &dnsPartial{frags: make([][]byte, f.count), at: time.Now()}
+		r.partial[f.pkt] = p
+	}
+	if p.frags[f.idx] != nil {
+		return nil
+	}
+	p.frags[f.idx] = f.data
+	if p.got++; p.got < len(p.frags) {
+		return nil
+	}
+	delete(r.partial, f.pkt)
+	var size int
+	for _, frag := range p.frags {
+		size += len(frag)
+	}
+	packet := make([]byte, 0, size)
+	for _, frag := range p.frags {
+		packet = append(packet, frag...)
+	}
+	return packet
+}
+
+// evict удаляет самый старый незавершенный пакет
+func (r *dnsReassembler) evict() {
+	var oldest uint16
+	var at time.Time
+	for pkt, p := range r.partial {
+		if at.IsZero() || p.at.Before(at) {
+			oldest, at = pkt, p.at
+		}
+	}
+	delete(r.partial, oldest)
+}
+
+// dnsZone приводит зону к виду example.com: нижний регистр, без точек по краям
+func dnsZone(zone string) string {
+	return strings.Trim(strings.ToLower(zone), ".")
+}
+
+// dnsQueryCapacity возвращает, сколько байт датаграммы помещается в имя одного запроса к зоне
+func dnsQueryCapacity(zone string) int {
+	budget := dnsMaxName - len(zone) - 1
+	chars := budget
+	// Метки по dnsMaxLabel символов разделяются точками
+	for chars > 0 && chars+(chars-1)/dnsMaxLabel > budget {
+		chars--
+	}
+	return chars*5/8 - dnsQueryHeader
+}
+
+// dnsQueryName кодирует данные запроса в имя в зоне
+func dnsQueryName(zone string, data []byte) string {
+	encoded := dnsEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > dnsMaxLabel {
+		b.WriteString(encoded[:dnsMaxLabel])
+		b.WriteByte('.')
+		encoded = encoded[dnsMaxLabel:]
+	}
+	b.WriteString(encoded)
+	b.WriteByte('.')
+	b.WriteString(zone)
+	b.WriteByte('.')
+	return b.String()
+}
+
+// dnsParseName возвращает данные из имени запроса. ok = false, если имя не в зоне
+func dnsParseName(name, zone string) (data []byte, ok bool, err error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == zone {
+		return nil, true, nil
+	}
+	prefix, found := strings.CutSuffix(name, "."+zone)
+	if !found {
+		return nil, false, nil
+	}
+	data, err = dnsEncoding.DecodeString(strings.ReplaceAll(prefix, ".", ""))
+	return data, true, err
+}
+
+// dnsTXT делит данные ответа на строки TXT записи (не больше 255 байт)
+func dnsTXT(data []byte) []string {
+	txt := []string{""}
+	if len(data) > 0 {
+		txt = txt[:0]
+	}
+	for len(data) > 0 {
+		n := min(len(data), math.MaxUint8)
+		txt = append(txt, string(data[:n]))
+		data = data[n:]
+	}
+	return txt
+}
+
+// dnsTXTSize возвращает, сколько байт данных помещается в TXT запись, под которую
+// осталось room байт (сверх одной пустой строки)
+func dnsTXTSize(room int) int {
+	if room <= 0 {
+		return 0
+	}
+	return room - room/(math.MaxUint8+1)
+}
+
+// dnsFragmentSize размер фрагментов к клиенту, который помещается в ответ на любой запрос
+// при размере ответа limit: в ответе повторяется вопрос с именем до dnsMaxName символов
+func dnsFragmentSize(limit int) int {
+	const overhead = 12 + (dnsMaxName + 2 + 4) + (2 + 10 + 1) + 11
+	return dnsTXTSize(limit-overhead) - dnsRecordHeader
+}
+
+// SystemResolver возвращает адрес DNS сервера системы из /etc/resolv.conf. Для
+// systemd-resolved (127.0.0.53) берется его вышестоящий сервер: запросы локальному
+// резолверу он сам отправлял бы через туннель
+func SystemResolver() (string, error) {
+	var loopback string
+	for _, path := range []string{"/etc/resolv.conf", "/run/systemd/resolve/resolv.conf"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "nameserver" {
+				continue
+			}
+			ip := net.ParseIP(fields[1])
+			if ip == nil {
+				continue
+			}
+			if !ip.IsLoopback() {
+				return DNSResolverAddr(fields[1]), nil
+			}
+			if loopback == "" {
+				loopback = DNSResolverAddr(fields[1])
+			}
+		}
+	}
+	if loopback != "" {
+		return loopback, nil
+	}
+	return "", errors.New("no DNS server in /etc/resolv.conf")
+}
+
+// DNSResolverAddr добавляет к адресу DNS сервера порт 53, если он не указан
+func DNSResolverAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+}
+
+// dnsStream клиентская сторона DNS туннеля: запросы уходят резолверу opts.DNSResolver
+type dnsStream struct {
+	conn     net.Conn
+	zone     string
+	session  uint32
+	capacity int // байт датаграммы в одном запросе
+
+	mu       sync.Mutex
+	queue    []dnsFragment        // фрагменты к серверу
+	inflight map[uint16]time.Time // DNS ID запросов без ответа и время их отправки
+	seq      uint16               // номер следующего запроса
+	pkt      uint16               // номер следующей датаграммы
+	wake     chan struct{}
+
+	reasm dnsReassembler // только в readLoop
+	in    chan []byte
+	done  chan struct{}
+	once  sync.Once
+}
+
+// dialDNS начинает сессию DNS туннеля через резолвер. Сессия с сервером появляется
+// с первым запросом, поэтому ошибка возможна только при неверных настройках
+func dialDNS(opts StreamOptions) (packetStream, error) {
+	zone := dnsZone(opts.DNSZone)
+	capacity := dnsQueryCapacity(zone)
+	if zone == "" || capacity < 16 {
+		return nil, fmt.Errorf("invalid DNS tunnel zone %q", opts.DNSZone)
+	}
+	dialer := &net.Dialer{Timeout: opts.Timeout, Control: socketControl(opts.Mark, opts.Protect)}
+	conn, err := dialer.Dial("udp", opts.DNSResolver)
+	if err != nil {
+		return nil, err
+	}
+	s := &dnsStream{
+		conn:     conn,
+		zone:     zone,
+		session:  rand.Uint32(),
+		capacity: capacity,
+		inflight: make(map[uint16]time.Time),
+		wake:     make(chan struct{}, 1),
+		in:       make(chan []byte, dnsMaxPartial),
+		done:     make(chan struct{}),
+	}
+	go s.sendLoop()
+	go s.readLoop()
+	return s, nil
+}
+
+func (s *dnsStream) ReadPacket() ([]byte, error) {
+	select {
+	case p := <-s.in:
+		return p, nil
+	case <-s.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// WritePacket ставит фрагменты датаграммы в очередь запросов. Если очередь полна,
+// датаграмма теряется, как при перегрузке UDP
+func (s *dnsStream) WritePacket(p []byte) error {
+	s.mu.Lock()
+	frags, err := dnsSplit(s.pkt, append([]byte(nil), p...), s.capacity)
+	if err != nil || len(s.queue)+len(frags) > dnsMaxQueue {
+		s.mu.Unlock()
+		return nil
+	}
+	s.pkt++
+	s.queue = append(s.queue, frags...)
+	s.mu.Unlock()
+	s.signal()
+	return nil
+}
+
+// SetReadDeadline ничего не делает: пропавший сервер находит keepalive протокола
+func (s *dnsStream) SetReadDeadline(t time.Time) error { return nil }
+
+func (s *dnsStream) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.conn.Close()
+	})
+	return nil
+}
+
+func (s *dnsStream) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendLoop отправляет запросы с фрагментами из очереди и запросы без данных, чтобы
+// у сервера всегда было dnsPollers запросов для ответа
+func (s *dnsStream) sendLoop() {
+	ticker := time.NewTicker(dnsQueryTimeout / 4)
+	defer ticker.Stop()
+	for {
+		for {
+			query := s.nextQuery()
+			if query == nil {
+				break
+			}
+			if _, err := s.conn.Write(query); err != nil {
+				logger.Debug("DNS tunnel query failed", logging.Err(err))
+				break
+			}
+		}
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-ticker.C:
+			s.expire()
+		}
+	}
+}
+
+// nextQuery возвращает следующий запрос (nil - отправлять нечего или слишком много
+// запросов без ответа)
+func (s *dnsStream) nextQuery() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var frag dnsFragment
+	switch {
+	case len(s.queue) > 0 && len(s.inflight) < dnsMaxInflight:
+		frag = s.queue[0]
+		s.queue[0] = dnsFragment{}
+		s.queue = s.queue[1:]
+	case len(s.inflight) < dnsPollers:
+	default:
+		return nil
+	}
+
+	data := make([]byte, dnsQueryHeader, dnsQueryHeader+len(frag.data))
+	binary.BigEndian.PutUint32(data, s.session)
+	binary.BigEndian.PutUint16(data[4:], s.seq)
+	binary.BigEndian.PutUint16(data[6:], frag.pkt)
+	data[8], data[9] = frag.idx, frag.count
+	data = append(data, frag.data...)
+	s.seq++
+
+	id := uint16(rand.Uint32())
+	for _, ok := s.inflight[id]; ok; _, ok = s.inflight[id] {
+		id++
+	}
+	query, err := dnsQuery(id, dnsQueryName(s.zone, data))
+	if err != nil {
+		logger.Debug("Failed to build DNS tunnel query", logging.Err(err))
+		return nil
+	}
+	s.inflight[id] = time.Now()
+	return query
+}
+
+// dnsQuery собирает TXT запрос с EDNS0
+func dnsQuery(id uint16, name string) ([]byte, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(dnsEDNSSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions:   []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}},
+	}
+	return msg.Pack()
+}
+
+// expire забывает запросы без ответа дольше dnsQueryTimeout, чтобы вместо них ушли новые
+func (s *dnsStream) expire() {
+	s.mu.Lock()
+	for id, sent := range s.inflight {
+		if time.Since(sent) > dnsQueryTimeout {
+			delete(s.inflight, id)
+		}
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// readLoop принимает ответы резолвера и собирает из них датаграммы сервера
+func (s *dnsStream) readLoop() {
+	defer s.Close()
+	buf := make([]byte, maxStreamPacket)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			// ICMP port unreachable от резолвера приходит ошибкой чтения UDP сокета
+			logger.Debug("DNS tunnel read failed", logging.Err(err))
+			time.Sleep(dnsPollHold)
+			continue
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || !msg.Response {
+			continue
+		}
+		s.mu.Lock()
+		_, ok := s.inflight[msg.ID]
+		delete(s.inflight, msg.ID)
+		s.mu.Unlock()
+		if !ok {
+			continue // ответ на забытый запрос или чужой пакет
+		}
+		s.signal()
+		if msg.RCode != dnsmessage.RCodeSuccess {
+			logger.Debug("DNS tunnel query failed", "rcode", msg.RCode)
+			continue
+		}
+		for _, answer := range msg.Answers {
+			txt, ok := answer.Body.(*dnsmessage.TXTResource)
+			if !ok {
+				continue
+			}
+			for _, frag := range dnsRecords([]byte(strings.Join(txt.TXT, ""))) {
+				p := s.reasm.add(frag)
+				if p == nil {
+					continue
+				}
+				select {
+				case s.in <- p:
+				case <-s.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// dnsRecords разбирает фрагменты из данных ответа
+func dnsRecords(data []byte) []dnsFragment {
+	var frags []dnsFragment
+	for len(data) >= dnsRecordHeader {
+		size := int(binary.BigEndian.Uint16(data[4:]))
+		if len(data) < dnsRecordHeader+size {
+			break
+		}
+		frags = append(frags, dnsFragment{
+			pkt:   binary.BigEndian.Uint16(data),
+			idx:   data[2],
+			count: data[3],
+			data:  data[dnsRecordHeader : dnsRecordHeader+size],
+		})
+		data = data[dnsRecordHeader+size:]
+	}
+	return frags
+}
+
+// ServeDNS отвечает на DNS запросы к зоне zone, принятые сокетом conn, как ее
+// авторитетный сервер, и пересылает датаграммы сессий DNS туннеля на UDP адрес target
+// (сокет сервера). Запросы к другим именам получают REFUSED. Новые сессии с одного адреса
+// (резолвера) заводятся не чаще sessionRate в секунду с пачкой до двух секунд лимита,
+// как в SetHandshakeLimit (0 - без ограничения). Возвращает ошибку, когда сокет закрыт
+func ServeDNS(conn *net.UDPConn, zone string, target *net.UDPAddr, sessionRate float64) error {
+	srv := &dnsServer{
+		conn:     conn,
+		zone:     dnsZone(zone),
+		target:   target,
+		sessions: make(map[uint32]*dnsSession),
+		limiter:  ratelimit.NewKeyed[netip.Addr](sessionRate, int(2*sessionRate)),
+	}
+	defer srv.closeSessions()
+	buf := make([]byte, maxStreamPacket)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		srv.handle(buf[:n], addr)
+	}
+}
+
+// dnsServer авторитетный DNS сервер зоны туннеля
+type dnsServer struct {
+	conn     *net.UDPConn
+	zone     string
+	target   *net.UDPAddr
+	mu       sync.Mutex
+	sessions map[uint32]*dnsSession
+	limiter  *ratelimit.Keyed[netip.Addr] // новые сессии по адресам отправителей
+}
+
+// dnsRequest запрос, на который сервер еще должен ответить
+type dnsRequest struct {
+	addr     *net.UDPAddr
+	header   dnsmessage.Header
+	question dnsmessage.Question
+	edns     bool
+	limit    int // наибольший размер ответа
+	seq      uint16
+	timer    *time.Timer
+}
+
+// handle разбирает запрос и отвечает на него или передает сессии туннеля
+func (s *dnsServer) handle(packet []byte, addr *net.UDPAddr) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || msg.Response {
+		return
+	}
+	req := &dnsRequest{addr: addr, header: msg.Header, limit: dnsMinSize}
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			req.edns = true
+			req.limit = min(max(int(rr.Header.Class), dnsMinSize), dnsEDNSSize)
+		}
+	}
+	if len(msg.Questions) != 1 {
+		s.reply(req, dnsmessage.RCodeFormatError, nil)
+		return
+	}
+	req.question = msg.Questions[0]
+
+	data, ok, err := dnsParseName(req.question.Name.String(), s.zone)
+	switch {
+	case !ok:
+		s.reply(req, dnsmessage.RCodeRefused, nil)
+		return
+	case data == nil && err == nil:
+		// Запросы к самой зоне (проверки резолвера) получают пустой ответ
+		s.reply(req, dnsmessage.RCodeSuccess, nil)
+		return
+	case err != nil || len(data) < dnsQueryHeader || req.question.Type != dnsmessage.TypeTXT:
+		s.reply(req, dnsmessage.RCodeNameError, nil)
+		return
+	}
+
+	req.seq = binary.BigEndian.Uint16(data[4:])
+	frag := dnsFragment{
+		pkt:   binary.BigEndian.Uint16(data[6:]),
+		idx:   data[8],
+		count: data[9],
+		data:  data[dnsQueryHeader:],
+	}
+	sess := s.session(binary.BigEndian.Uint32(data), addr)
+	if sess == nil {
+		s.reply(req, dnsmessage.RCodeServerFailure, nil)
+		return
+	}
+	sess.query(req, frag)
+}
+
+// session возвращает сессию туннеля, создавая ее и релей на target при первом запросе.
+// nil - новую сессию завести нельзя: сессий dnsMaxSessions или адрес превысил лимит
+func (s *dnsServer) session(id uint32, addr *net.UDPAddr) *dnsSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess := s.sessions[id]; sess != nil {
+		return sess
+	}
+	if len(s.sessions) >= dnsMaxSessions {
+		logger.Debug("DNS tunnel session limit reached", "src", addr)
+		return nil
+	}
+	ip, _ := netip.AddrFromSlice(addr.IP)
+	if !s.limiter.Allow(ip.Unmap()) {
+		metricHandshakeLimited.Inc()
+		return nil
+	}
+	sess := &dnsSession{
+		srv:      s,
+		id:       id,
+		fragSize: dnsFragmentSize(dnsMinSize),
+		answered: make(map[uint16][]byte),
+		created:  time.Now(),
+		in:       make(chan []byte, dnsMaxPartial),
+		done:     make(chan struct{}),
+	}
+	s.sessions[id] = sess
+	go relayStream(sess, s.target, "", nil)
+	return sess
+}
+
+// closeSessions закрывает все сессии, когда сервер остановлен
+func (s *dnsServer) closeSessions() {
+	s.mu.Lock()
+	sessions := make([]*dnsSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.Close()
+	}
+}
+
+// reply отправляет ответ на запрос: data - данные для TXT записи (nil - без записей)
+func (s *dnsServer) reply(req *dnsRequest, rcode dnsmessage.RCode, data []byte) {
+	resp, err := s.response(req, rcode, data)
+	if err != nil {
+		logger.Debug("Failed to build DNS tunnel response", logging.Err(err))
+		return
+	}
+	s.conn.WriteToUDP(resp, req.addr)
+}
+
+// response собирает ответ на запрос
+func (s *dnsServer) response(req *dnsRequest, rcode dnsmessage.RCode, data []byte) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               req.header.ID,
+			Response:         true,
+			Authoritative:    true,
+			RecursionDesired: req.header.RecursionDesired,
+			RCode:            rcode,
+		},
+	}
+	if req.question.Name.Length > 0 {
+		msg.Questions = []dnsmessage.Question{req.question}
+	}
+	if data != nil {
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: req.question.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.TXTResource{TXT: dnsTXT(data)},
+		}}
+	}
+	if req.edns {
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(dnsEDNSSize, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil, err
+		}
+		msg.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+	}
+	return msg.Pack()
+}
+
+// dnsSession серверная сторона сессии DNS туннеля. Для релея это поток датаграмм,
+// как TCP соединение клиента
+type dnsSession struct {
+	srv *dnsServer
+	id  uint32
+
+	mu       sync.Mutex
+	queue    []dnsFragment // фрагменты к клиенту
+	pkt      uint16
+	fragSize int               // размер фрагментов к клиенту по размеру ответа последнего запроса
+	pending  []*dnsRequest     // запросы, которые ждут пакетов к клиенту
+	answered map[uint16][]byte // данные последних ответов по номерам запросов
+	order    []uint16          // номера запросов в answered в порядке ответов
+	reasm    dnsReassembler
+
+	deadline    atomic.Int64 // deadline чтения (UnixNano, 0 - нет)
+	established atomic.Bool  // сервер VPN ответил сессии
+	created     time.Time
+	in          chan []byte
+	done        chan struct{}
+	once        sync.Once
+}
+
+// query обрабатывает запрос сессии: передает фрагмент датаграммы релею и отвечает
+// пакетами к клиенту, а если их нет - держит запрос до dnsPollHold
+func (s *dnsSession) query(req *dnsRequest, frag dnsFragment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data, ok := s.answered[req.seq]; ok {
+		// Резолвер повторил запрос: ответ на первый мог потеряться
+		s.srv.reply(req, dnsmessage.RCodeSuccess, data)
+		return
+	}
+	for _, pending := range s.pending {
+		if pending.seq == req.seq {
+			return
+		}
+	}
+	s.fragSize = dnsFragmentSize(req.limit)
+
+	if frag.count > 0 {
+		if p := s.reasm.add(frag); p != nil {
+			select {
+			case s.in <- p:
+			default:
+			}
+		}
+	}
+	// Запрос с данными ответ не ждет: клиент держит у сервера отдельные запросы без данных
+	if len(s.queue) > 0 || frag.count > 0 {
+		s.answer(req)
+		return
+	}
+	if len(s.pending) >= dnsPollers {
+		// Клиент посчитал потерянными запросы, которые еще ждут: отвечаем на самый старый
+		oldest := s.pending[0]
+		s.pending = s.pending[1:]
+		oldest.timer.Stop()
+		s.answer(oldest)
+	}
+	req.timer = time.AfterFunc(dnsPollHold, func() { s.release(req) })
+	s.pending = append(s.pending, req)
+}
+
+// release отвечает на запрос, который ждал dnsPollHold, если на него еще не ответили
+func (s *dnsSession) release(req *dnsRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, pending := range s.pending {
+		if pending == req {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			s.answer(req)
+			return
+		}
+	}
+}
+
+// answer отвечает на запрос фрагментами из очереди, сколько поместится. Требует s.mu
+func (s *dnsSession) answer(req *dnsRequest) {
+	empty, err := s.srv.response(req, dnsmessage.RCodeSuccess, []byte{})
+	if err != nil {
+		logger.Debug("Failed to build DNS tunnel response", logging.Err(err))
+		return
+	}
+	room := dnsTXTSize(req.limit - len(empty))
+	if len(s.queue) > 0 && dnsRecordHeader+len(s.queue[0].data) > room {
+		// Фрагмент нарезан под ответ большего размера (резолвер сменился и не передает EDNS0)
+		// и не поместится ни в один ответ
+		s.queue[0] = dnsFragment{}
+		s.queue = s.queue[1:]
+	}
+	data := []byte{}
+	for len(s.queue) > 0 && len(data)+dnsRecordHeader+len(s.queue[0].data) <= room {
+		f := s.queue[0]
+		data = binary.BigEndian.AppendUint16(data, f.pkt)
+		data = append(data, f.idx, f.count)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(f.data)))
+		data = append(data, f.data...)
+		s.queue[0] = dnsFragment{}
+		s.queue = s.queue[1:]
+	}
+
+	s.answered[req.seq] = data
+	s.order = append(s.order, req.seq)
+	if len(s.order) > dnsAnswered {
+		delete(s.answered, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.srv.reply(req, dnsmessage.RCodeSuccess, data)
+}
+
+// ReadPacket ждет датаграмму от клиента. Пока сервер VPN не ответил сессии, чтение
+// заканчивается не позже dnsHandshakeTimeout с ее создания: номер сессии может прислать
+// кто угодно, и такая сессия не должна держать сокет StreamIdleTimeout
+func (s *dnsSession) ReadPacket() ([]byte, error) {
+	for {
+		deadline := s.deadline.Load()
+		if !s.established.Load() {
+			if limit := s.created.Add(dnsHandshakeTimeout).UnixNano(); deadline == 0 || deadline > limit {
+				deadline = limit
+			}
+		}
+		var timeout <-chan time.Time
+		if deadline != 0 {
+			timer := time.NewTimer(time.Until(time.Unix(0, deadline)))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case p := <-s.in:
+			return p, nil
+		case <-timeout:
+			// Сессию подтвердили во время ожидания: дальше действует обычный deadline
+			if s.established.Load() && deadline != s.deadline.Load() {
+				continue
+			}
+			return nil, os.ErrDeadlineExceeded
+		case <-s.done:
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+// WritePacket ставит датаграмму в очередь к клиенту и отвечает ею на ждущий запрос.
+// Сервер VPN отвечает только на пакеты, прошедшие проверку подлинности, поэтому первая
+// датаграмма подтверждает сессию
+func (s *dnsSession) WritePacket(p []byte) error {
+	s.established.Store(true)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frags, err := dnsSplit(s.pkt, append([]byte(nil), p...), s.fragSize)
+	if err != nil || len(s.queue)+len(frags) > dnsMaxQueue {
+		return nil
+	}
+	s.pkt++
+	s.queue = append(s.queue, frags...)
+	for len(s.pending) > 0 && len(s.queue) > 0 {
+		req := s.pending[0]
+		s.pending = s.pending[1:]
+		req.timer.Stop()
+		s.answer(req)
+	}
+	return nil
+}
+
+func (s *dnsSession) SetReadDeadline(t time.Time) error {
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
+	}
+	s.deadline.Store(deadline)
+	return nil
+}
+
+// Close завершает сессию: следующий запрос с ее номером начнет новую
+func (s *dnsSession) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.srv.mu.Lock()
+		if s.srv.sessions[s.id] == s {
+			delete(s.srv.sessions, s.id)
+		}
+		s.srv.mu.Unlock()
+	})
+	return nil
+}
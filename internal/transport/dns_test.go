@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"myvpn/internal/ratelimit"
+)
+
+// newTestDNSServer создает DNS сервер туннеля без сокета: сессии релеят на target
+func newTestDNSServer(t *testing.T, sessionRate float64) *dnsServer {
+	t.Helper()
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { target.Close() })
+	srv := &dnsServer{
+		target:   target.LocalAddr().(*net.UDPAddr),
+		sessions: make(map[uint32]*dnsSession),
+		limiter:  ratelimit.NewKeyed[netip.Addr](sessionRate, int(2*sessionRate)),
+	}
+	t.Cleanup(srv.closeSessions)
+	return srv
+}
+
+// TestDNSSessionRateLimit проверяет, что новые сессии с одного адреса ограничены лимитом,
+// а запросы существующих сессий и других адресов проходят
+func TestDNSSessionRateLimit(t *testing.T) {
+	srv := newTestDNSServer(t, 1)
+	resolver := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	for id := uint32(1); id <= 2; id++ {
+		if srv.session(id, resolver) == nil {
+			t.Fatalf("session %d refused within the burst", id)
+		}
+	}
+	if srv.session(3, resolver) != nil {
+		t.Fatal("session 3 created over the rate limit")
+	}
+	if srv.session(1, resolver) == nil {
+		t.Fatal("existing session refused by the rate limit")
+	}
+	if srv.session(3, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}) == nil {
+		t.Fatal("session from another address refused")
+	}
+}
+
+// TestDNSSessionCap проверяет, что сессий не больше dnsMaxSessions
+func TestDNSSessionCap(t *testing.T) {
+	srv := newTestDNSServer(t, 0)
+	resolver := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	// Занятые места - сессии без релея: Close для них не вызывается
+	srv.mu.Lock()
+	for id := uint32(1); id < dnsMaxSessions; id++ {
+		srv.sessions[id] = &dnsSession{}
+	}
+	srv.mu.Unlock()
+	if srv.session(dnsMaxSessions, resolver) == nil {
+		t.Fatal("last session under the cap refused")
+	}
+	if srv.session(dnsMaxSessions+1, resolver) != nil {
+		t.Fatal("session created over dnsMaxSessions")
+	}
+	srv.mu.Lock()
+	for id := uint32(1); id < dnsMaxSessions; id++ {
+		delete(srv.sessions, id)
+	}
+	srv.mu.Unlock()
+}
+
+// TestDNSSessionHandshakeTimeout проверяет, что сессия без ответа сервера VPN заканчивает
+// чтение через dnsHandshakeTimeout, а подтвержденная - по обычному deadline
+func TestDNSSessionHandshakeTimeout(t *testing.T) {
+	newSession := func() *dnsSession {
+		return &dnsSession{
+			created: time.Now().Add(50*time.Millisecond - dnsHandshakeTimeout),
+			in:      make(chan []byte, 1),
+			done:    make(chan struct{}),
+		}
+	}
+
+	sess := newSession()
+	sess.SetReadDeadline(time.Now().Add(StreamIdleTimeout))
+	start := time.Now()
+	if _, err := sess.ReadPacket(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadPacket of an unconfirmed session: %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("unconfirmed session read for %v", elapsed)
+	}
+
+	// Ответ сервера во время ожидания продлевает чтение до обычного deadline
+	sess = newSession()
+	sess.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	time.AfterFunc(20*time.Millisecond, func() { sess.established.Store(true) })
+	start = time.Now()
+	if _, err := sess.ReadPacket(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadPacket of a confirmed session: %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("confirmed session read ended after %v, before its deadline", elapsed)
+	}
+}
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DSCPCopy значение для SetDSCP: DSCP внешней датаграммы копируется из внутреннего
+// пакета (Packet.DSCP в WriteBatch, аргумент WriteDSCP)
+const DSCPCopy = -1
+
+// dscpClasses имена классов DSCP (RFC 4594), которые принимает ParseDSCP
+var dscpClasses = map[string]int{
+	"ef": 46, "va": 44,
+	"af11": 10, "af12": 12, "af13": 14,
+	"af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30,
+	"af41": 34, "af42": 36, "af43": 38,
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+}
+
+// ParseDSCP разбирает значение флага: copy, номер 0-63 или имя класса (ef, af41, cs1 и т.д.)
+func ParseDSCP(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "copy" {
+		return DSCPCopy, nil
+	}
+	if v, ok := dscpClasses[s]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q: expected copy, 0-63 or a class name such as ef or af41", s)
+	}
+	return v, nil
+}
+
+// SetDSCP задает DSCP внешних датаграмм: 0-63 - одно значение для всех датаграмм сокета
+// (IP_TOS/IPV6_TCLASS), DSCPCopy - значение внутреннего пакета, которое передается
+// с каждой датаграммой. Вызывается до начала обмена пакетами, после SetShards
+func (t *UDPTransport) SetDSCP(dscp int) error {
+	if dscp == DSCPCopy {
+		t.dscpCopy = true
+		return nil
+	}
+	tos := dscp << 2
+	for _, r := range t.readers {
+		raw, err := r.conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		var sockErr error
+		if err := raw.Control(func(fd uintptr) {
+			sa, _ := unix.Getsockname(int(fd))
+			if _, inet6 := sa.(*unix.SockaddrInet6); inet6 {
+				if sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos); sockErr != nil {
+					return
+				}
+				// Dual-stack сокет отправляет IPv4 клиентам по настройке IP_TOS. Для сокета
+				// только IPv6 ее может не быть
+				unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+				return
+			}
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+		}); err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("failed to set DSCP: %w", sockErr)
+		}
+	}
+	return nil
+}
+
+// outerDSCP возвращает DSCP, который передается с датаграммой: 0 - по настройке сокета
+func (t *UDPTransport) outerDSCP(inner uint8) uint8 {
+	if !t.dscpCopy {
+		return 0
+	}
+	return inner
+}
+
+// dscpControl формирует управляющее сообщение с TOS для адреса dst. IPv4 адрес (в том числе
+// у dual-stack сокета) требует IP_TOS, IPv6 - IPV6_TCLASS
+func dscpControl(dscp uint8, dst *net.UDPAddr) []byte {
+	oob := make([]byte, unix.CmsgSpace(4))
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level, hdr.Type = unix.IPPROTO_IP, unix.IP_TOS
+	if dst.IP.To4() == nil {
+		hdr.Level, hdr.Type = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+	}
+	hdr.SetLen(unix.CmsgLen(4))
+	binary.NativeEndian.PutUint32(oob[unix.CmsgLen(0):], uint32(dscp)<<2)
+	return oob
+}
+
+// control управляющие сообщения датаграммы: UDP_SEGMENT для GSO буфера из count
+// сегментов и TOS, если DSCP задан
+func control(segmentSize, count int, dscp uint8, dst *net.UDPAddr) []byte {
+	var oob []byte
+	if count > 1 {
+		oob = gsoControl(segmentSize)
+	}
+	if dscp != 0 {
+		oob = append(oob, dscpControl(dscp, dst)...)
+	}
+	return oob
+}
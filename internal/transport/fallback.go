@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fallbackTimeout время на заголовки запроса и простой соединения, которому отвечает сайт
+const fallbackTimeout = 30 * time.Second
+
+// sniffHTTP сообщает, начинается ли соединение с HTTP запроса. Поток клиента VPN
+// начинается с длины первой датаграммы (2 байта), и ее старший байт меньше 'A',
+// а запрос - с метода из заглавных букв. Возвращает соединение, чтение из которого
+// начинается с прочитанного байта
+func sniffHTTP(conn net.Conn) (net.Conn, bool, error) {
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(StreamIdleTimeout))
+	b, err := br.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, false, err
+	}
+	return &bufferedConn{Conn: conn, r: br}, b[0] >= 'A' && b[0] <= 'Z', nil
+}
+
+// serveFallback отвечает на HTTP запросы соединения обработчиком сайта, пока соединение
+// не закрыто
+func serveFallback(conn net.Conn, handler http.Handler) {
+	l := &connListener{conn: &closeNotifyConn{Conn: conn, closed: make(chan struct{})}}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: fallbackTimeout,
+		IdleTimeout:       fallbackTimeout,
+	}
+	srv.Serve(l)
+}
+
+// connListener отдает http.Server одно соединение, а следующий Accept ждет его закрытия
+type connListener struct {
+	conn *closeNotifyConn
+	once sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = l.conn })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.conn.closed
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error   { return nil }
+func (l *connListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+// closeNotifyConn закрывает closed вместе с соединением
+type closeNotifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
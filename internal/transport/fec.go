@@ -0,0 +1,455 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// FEC параметры кода Рида-Соломона: на каждые Data пакетов добавляется Parity
+// избыточных, и любые Parity потерянных из группы восстанавливаются без повторной
+// передачи. Нулевой FEC - без избыточности
+type FEC struct {
+	Data   int
+	Parity int
+}
+
+// ParseFEC разбирает параметры вида "10/3" (данные/избыточные). Пустая строка и "0/0" - выключено
+func ParseFEC(s string) (FEC, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return FEC{}, nil
+	}
+	data, parity, ok := strings.Cut(s, "/")
+	if !ok {
+		return FEC{}, fmt.Errorf("invalid FEC %q (expected data/parity, e.g. 10/3)", s)
+	}
+	d, err := strconv.Atoi(strings.TrimSpace(data))
+	if err != nil {
+		return FEC{}, fmt.Errorf("invalid FEC %q: %w", s, err)
+	}
+	p, err := strconv.Atoi(strings.TrimSpace(parity))
+	if err != nil {
+		return FEC{}, fmt.Errorf("invalid FEC %q: %w", s, err)
+	}
+	if d == 0 && p == 0 {
+		return FEC{}, nil
+	}
+	if d < 1 || p < 1 || d+p > 255 {
+		return FEC{}, fmt.Errorf("invalid FEC %q: need at least 1 data and 1 parity shard, at most 255 in total", s)
+	}
+	return FEC{Data: d, Parity: p}, nil
+}
+
+// Enabled сообщает, добавляются ли избыточные пакеты
+func (f FEC) Enabled() bool {
+	return f.Parity > 0
+}
+
+// String возвращает параметры в формате ParseFEC
+func (f FEC) String() string {
+	return fmt.Sprintf("%d/%d", f.Data, f.Parity)
+}
+
+const (
+	// FECMaxData наибольшее число пакетов данных в группе FEC транспорта: смещения их
+	// sequence передаются в каждом избыточном пакете
+	FECMaxData = 32
+
+	// fecHeaderSize поля избыточного пакета после заголовка: индекс (1) + число пакетов
+	// данных (1) + число избыточных (1) + размер шарда (2)
+	fecHeaderSize = 5
+	// fecLenSize длина датаграммы в начале шарда
+	fecLenSize = 2
+	// fecFlushInterval через сколько неполная группа закрывается избыточными пакетами
+	fecFlushInterval = 20 * time.Millisecond
+	// fecGroupTTL сколько получатель ждет недостающие избыточные пакеты группы
+	fecGroupTTL = time.Second
+	// fecMaxGroups наибольшее число незавершенных групп сессии у получателя
+	fecMaxGroups = 64
+	// fecCacheSize сколько последних принятых пакетов сессии получатель хранит для восстановления
+	fecCacheSize = 512
+)
+
+// Overhead возвращает, сколько байт нужно оставить свободными в пакете данных, чтобы
+// избыточный пакет (заголовки + шард на 2 байта длиннее пакета) поместился в датаграмму
+func (f FEC) Overhead() int {
+	if !f.Enabled() {
+		return 0
+	}
+	return HeaderSize + fecHeaderSize + 2*f.Data + fecLenSize
+}
+
+// fecCodecs кеш кодеров Рида-Соломона по числу шардов: неполные группы
+// кодируются с меньшим числом пакетов данных
+var fecCodecs sync.Map // data<<8 | parity -> reedsolomon.Encoder
+
+// fecCodec возвращает кодер для data пакетов данных и parity избыточных
+func fecCodec(data, parity int) (reedsolomon.Encoder, error) {
+	key := data<<8 | parity
+	if enc, ok := fecCodecs.Load(key); ok {
+		return enc.(reedsolomon.Encoder), nil
+	}
+	enc, err := reedsolomon.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	fecCodecs.Store(key, enc)
+	return enc, nil
+}
+
+// fecShard дополняет датаграмму до шарда: длина (2 байта) + датаграмма + нули
+func fecShard(datagram []byte, size int) []byte {
+	shard := make([]byte, size)
+	binary.BigEndian.PutUint16(shard, uint16(len(datagram)))
+	copy(shard[fecLenSize:], datagram)
+	return shard
+}
+
+// fecEncoder собирает отправленные пакеты сессии в группы и добавляет к каждой
+// группе избыточные пакеты (PacketTypeFEC):
+//
+//	тип (1) | session ID (8) | sequence первого пакета группы (8) |
+//	индекс (1) | пакетов данных (1) | избыточных (1) | размер шарда (2) |
+//	смещения sequence пакетов группы (2 байта на пакет) | шард
+//
+// Избыточные пакеты не шифруются: восстановленные из них пакеты расшифровываются
+// и проверяются как обычные, поэтому подделка приведет лишь к отброшенному пакету
+type fecEncoder struct {
+	mu        sync.Mutex
+	fec       FEC
+	sessionID uint64
+	base      uint64   // sequence первого пакета группы
+	offsets   []int16  // смещения sequence пакетов группы от base
+	group     [][]byte // копии отправленных датаграмм
+	addr      *net.UDPAddr
+	started   time.Time
+}
+
+// add добавляет отправленную датаграмму в группу. Если группа заполнилась,
+// возвращает избыточные пакеты и адрес, куда их отправить
+func (e *fecEncoder) add(packet []byte, addr *net.UDPAddr) ([][]byte, *net.UDPAddr) {
+	seq := binary.BigEndian.Uint64(packet[sequenceOffset:])
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var parity [][]byte
+	var to *net.UDPAddr
+	delta := int64(seq - e.base)
+	if len(e.group) > 0 && (delta < math.MinInt16 || delta > math.MaxInt16) {
+		// Пакеты разошлись по sequence слишком далеко: закрываем группу досрочно
+		parity, to = e.flushLocked()
+	}
+	if len(e.group) == 0 {
+		e.sessionID = binary.BigEndian.Uint64(packet[sessionOffset:])
+		e.base, delta = seq, 0
+		e.started = time.Now()
+	}
+	e.offsets = append(e.offsets, int16(delta))
+	e.group = append(e.group, append([]byte(nil), packet...))
+	e.addr = addr
+	if len(e.group) < e.fec.Data {
+		return parity, to
+	}
+	more, to := e.flushLocked()
+	return append(parity, more...), to
+}
+
+// flush закрывает неполную группу, если она собирается дольше fecFlushInterval
+func (e *fecEncoder) flush(now time.Time) ([][]byte, *net.UDPAddr) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.group) == 0 || now.Sub(e.started) < fecFlushInterval {
+		return nil, nil
+	}
+	return e.flushLocked()
+}
+
+// flushLocked кодирует текущую группу и начинает новую
+func (e *fecEncoder) flushLocked() ([][]byte, *net.UDPAddr) {
+	data, parityCount := len(e.group), e.fec.Parity
+	size := 0
+	for _, datagram := range e.group {
+		size = max(size, fecLenSize+len(datagram))
+	}
+
+	shards := make([][]byte, data+parityCount)
+	for i, datagram := range e.group {
+		shards[i] = fecShard(datagram, size)
+	}
+	for i := data; i < len(shards); i++ {
+		shards[i] = make([]byte, size)
+	}
+
+	var packets [][]byte
+	enc, err := fecCodec(data, parityCount)
+	if err == nil && enc.Encode(shards) == nil {
+		meta := HeaderSize + fecHeaderSize + 2*data
+		for i := 0; i < parityCount; i++ {
+			packet := make([]byte, meta+size)
+			packet[0] = PacketTypeFEC
+			binary.BigEndian.PutUint64(packet[sessionOffset:], e.sessionID)
+			binary.BigEndian.PutUint64(packet[sequenceOffset:], e.base)
+			packet[HeaderSize] = byte(i)
+			packet[HeaderSize+1] = byte(data)
+			packet[HeaderSize+2] = byte(parityCount)
+			binary.BigEndian.PutUint16(packet[HeaderSize+3:], uint16(size))
+			for j, offset := range e.offsets {
+				binary.BigEndian.PutUint16(packet[HeaderSize+fecHeaderSize+2*j:], uint16(offset))
+			}
+			copy(packet[meta:], shards[data+i])
+			packets = append(packets, packet)
+		}
+	}
+
+	e.group, e.offsets = e.group[:0], e.offsets[:0]
+	return packets, e.addr
+}
+
+// fecGroup избыточные пакеты одной группы, принятые получателем
+type fecGroup struct {
+	seqs    []uint64
+	size    int
+	parity  [][]byte // шарды по индексу, nil - еще не пришел
+	created time.Time
+	done    bool
+}
+
+// fecDecoder восстанавливает потерянные пакеты сессии. Заводится при первом
+// избыточном пакете и с этого момента запоминает последние принятые пакеты
+type fecDecoder struct {
+	mu     sync.Mutex
+	cache  map[uint64][]byte // принятые датаграммы по sequence
+	order  []uint64          // кольцо sequence в cache для вытеснения старых
+	pos    int
+	groups map[uint64]*fecGroup
+}
+
+func newFECDecoder() *fecDecoder {
+	return &fecDecoder{
+		cache:  make(map[uint64][]byte, fecCacheSize),
+		order:  make([]uint64, 0, fecCacheSize),
+		groups: make(map[uint64]*fecGroup),
+	}
+}
+
+// remember запоминает принятую датаграмму
+func (d *fecDecoder) remember(seq uint64, datagram []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.cache[seq]; ok {
+		return
+	}
+	if len(d.order) < fecCacheSize {
+		d.order = append(d.order, seq)
+	} else {
+		delete(d.cache, d.order[d.pos])
+		d.order[d.pos] = seq
+		d.pos = (d.pos + 1) % fecCacheSize
+	}
+	d.cache[seq] = append([]byte(nil), datagram...)
+}
+
+// add принимает избыточный пакет и возвращает датаграммы, которые удалось восстановить
+func (d *fecDecoder) add(packet []byte) ([][]byte, error) {
+	if len(packet) < HeaderSize+fecHeaderSize {
+		return nil, fmt.Errorf("FEC packet too short")
+	}
+	base := binary.BigEndian.Uint64(packet[sequenceOffset:])
+	index := int(packet[HeaderSize])
+	data := int(packet[HeaderSize+1])
+	parity := int(packet[HeaderSize+2])
+	size := int(binary.BigEndian.Uint16(packet[HeaderSize+3:]))
+	meta := HeaderSize + fecHeaderSize + 2*data
+	if data < 1 || parity < 1 || data+parity > 256 || index >= parity || size <= fecLenSize || len(packet) != meta+size {
+		return nil, fmt.Errorf("malformed FEC packet")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for id, g := range d.groups {
+		if now.Sub(g.created) > fecGroupTTL {
+			delete(d.groups, id)
+		}
+	}
+
+	g := d.groups[base]
+	if g == nil {
+		if len(d.groups) >= fecMaxGroups {
+			return nil, fmt.Errorf("too many pending FEC groups")
+		}
+		g = &fecGroup{size: size, parity: make([][]byte, parity), created: now}
+		for i := 0; i < data; i++ {
+			offset := int16(binary.BigEndian.Uint16(packet[HeaderSize+fecHeaderSize+2*i:]))
+			g.seqs = append(g.seqs, base+uint64(int64(offset)))
+		}
+		d.groups[base] = g
+	}
+	if g.done || len(g.seqs) != data || len(g.parity) != parity || g.size != size {
+		return nil, nil
+	}
+	g.parity[index] = append([]byte(nil), packet[meta:]...)
+
+	shards := make([][]byte, data+parity)
+	missing, have := 0, 0
+	for i, seq := range g.seqs {
+		datagram, ok := d.cache[seq]
+		if !ok {
+			missing++
+			continue
+		}
+		if fecLenSize+len(datagram) > size {
+			g.done = true
+			return nil, fmt.Errorf("FEC shard size mismatch")
+		}
+		shards[i] = fecShard(datagram, size)
+	}
+	for i, shard := range g.parity {
+		if shard != nil {
+			shards[data+i] = shard
+			have++
+		}
+	}
+	if missing == 0 {
+		g.done = true
+		return nil, nil
+	}
+	if missing > have {
+		return nil, nil // ждем остальные избыточные пакеты
+	}
+
+	g.done = true
+	enc, err := fecCodec(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.ReconstructData(shards); err != nil {
+		metricFECUnrecoverable.Inc()
+		return nil, err
+	}
+	var recovered [][]byte
+	for i, seq := range g.seqs {
+		if _, ok := d.cache[seq]; ok {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(shards[i]))
+		if n > size-fecLenSize {
+			continue
+		}
+		recovered = append(recovered, shards[i][fecLenSize:fecLenSize+n])
+	}
+	return recovered, nil
+}
+
+// SetSessionFEC включает FEC для пакетов, которые транспорт отправляет сессии sessionID
+// (у клиентского транспорта - своей сессии). Каждые fec.Data пакетов данных дополняются
+// fec.Parity избыточными, неполная группа закрывается через fecFlushInterval.
+// Получатель восстанавливает пакеты независимо от своих настроек.
+// Нулевой fec выключает FEC
+func (t *UDPTransport) SetSessionFEC(sessionID uint64, fec FEC) error {
+	if fec.Enabled() && (fec.Data < 1 || fec.Data > FECMaxData) {
+		return fmt.Errorf("FEC group of %d data packets is not supported (max %d)", fec.Data, FECMaxData)
+	}
+	state := t.session(sessionID, true)
+	if !fec.Enabled() {
+		state.fecOut.Store(nil)
+		return nil
+	}
+	state.fecOut.Store(&fecEncoder{fec: fec})
+	t.fecOnce.Do(func() {
+		t.wg.Add(1)
+		go t.fecFlushLoop()
+	})
+	return nil
+}
+
+// protect добавляет отправленный пакет данных в группу FEC его сессии
+// и отправляет избыточные пакеты, когда группа заполнилась
+func (t *UDPTransport) protect(packet []byte, addr *net.UDPAddr) {
+	if packet[0] != PacketTypeData && packet[0] != PacketTypeFragment {
+		return
+	}
+	state := t.session(binary.BigEndian.Uint64(packet[sessionOffset:]), false)
+	if state == nil {
+		return
+	}
+	if enc := state.fecOut.Load(); enc != nil {
+		t.writeParity(enc.add(packet, addr))
+	}
+}
+
+// writeParity отправляет избыточные пакеты группы
+func (t *UDPTransport) writeParity(packets [][]byte, addr *net.UDPAddr) {
+	for _, packet := range packets {
+		if _, err := t.writeRaw(packet, addr); err != nil {
+			return
+		}
+		metricFECParitySent.Inc()
+	}
+}
+
+// fecFlushLoop закрывает неполные группы FEC, чтобы при редких пакетах
+// избыточные пакеты не ждали заполнения группы
+func (t *UDPTransport) fecFlushLoop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(fecFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			for _, state := range t.allSessions() {
+				if enc := state.fecOut.Load(); enc != nil {
+					t.writeParity(enc.flush(now))
+				}
+			}
+		}
+	}
+}
+
+// recoverFEC разбирает избыточный пакет сессии и ставит восстановленные
+// датаграммы в очередь чтения
+func (t *UDPTransport) recoverFEC(state *sessionState, packet []byte, addr *net.UDPAddr) error {
+	dec := state.fecIn.Load()
+	if dec == nil {
+		state.fecIn.CompareAndSwap(nil, newFECDecoder())
+		dec = state.fecIn.Load()
+	}
+	recovered, err := dec.add(packet)
+	if err != nil {
+		return err
+	}
+	if len(recovered) == 0 {
+		return nil
+	}
+	metricFECRecovered.Add(uint64(len(recovered)))
+
+	t.recoveredMu.Lock()
+	defer t.recoveredMu.Unlock()
+	for _, datagram := range recovered {
+		t.recovered = append(t.recovered, segment{buf: datagram, addr: addr, recovered: true})
+	}
+	return nil
+}
+
+// takeRecovered забирает восстановленные датаграммы из очереди
+func (t *UDPTransport) takeRecovered() []segment {
+	t.recoveredMu.Lock()
+	defer t.recoveredMu.Unlock()
+	recovered := t.recovered
+	t.recovered = nil
+	return recovered
+}
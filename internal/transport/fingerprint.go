@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultTLSFingerprint ClientHello TLS транспортов по умолчанию. ClientHello crypto/tls
+// (TLSFingerprintGo) узнается по набору шифров и расширений, поэтому транспорты
+// повторяют ClientHello браузера
+const DefaultTLSFingerprint = "chrome"
+
+// TLSFingerprintGo ClientHello стандартной библиотеки Go, без маскировки
+const TLSFingerprintGo = "go"
+
+// tlsFingerprints ClientHello браузеров из uTLS по именам
+var tlsFingerprints = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"ios":     utls.HelloIOS_Auto,
+}
+
+// CheckTLSFingerprint проверяет имя ClientHello: chrome, firefox, safari, edge, ios или go
+// (пустое - DefaultTLSFingerprint)
+func CheckTLSFingerprint(name string) error {
+	if _, ok := tlsFingerprints[name]; ok || name == "" || name == TLSFingerprintGo {
+		return nil
+	}
+	return fmt.Errorf("unknown TLS fingerprint %q (expected chrome, firefox, safari, edge, ios or go)", name)
+}
+
+// tlsHandshake выполняет рукопожатие TLS поверх conn с ClientHello из o.TLSFingerprint.
+// Браузерный ClientHello берется целиком, кроме ALPN: протоколы из config, потому что
+// сервер WSS и TCP не говорит на HTTP/2, а gRPC - только на нем
+func (o StreamOptions) tlsHandshake(ctx context.Context, conn net.Conn, config *tls.Config) (net.Conn, error) {
+	name := o.TLSFingerprint
+	if name == "" {
+		name = DefaultTLSFingerprint
+	}
+	if name == TLSFingerprintGo {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+
+	id, ok := tlsFingerprints[name]
+	if !ok {
+		return nil, CheckTLSFingerprint(name)
+	}
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = config.NextProtos
+		}
+	}
+	uconn := utls.UClient(conn, utlsConfig(config), utls.HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		return nil, fmt.Errorf("TLS fingerprint %s: %w", name, err)
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return uconn, nil
+}
+
+// negotiatedProtocol возвращает протокол, выбранный сервером по ALPN
+func negotiatedProtocol(conn net.Conn) string {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		return c.ConnectionState().NegotiatedProtocol
+	case *utls.UConn:
+		return c.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
+
+// utlsConfig переносит в настройки uTLS то, что клиент задает в tls.Config: SNI, проверку
+// сертификата сервера, сертификат клиента и ALPN
+func utlsConfig(c *tls.Config) *utls.Config {
+	config := &utls.Config{
+		ServerName:            c.ServerName,
+		InsecureSkipVerify:    c.InsecureSkipVerify,
+		RootCAs:               c.RootCAs,
+		NextProtos:            c.NextProtos,
+		VerifyPeerCertificate: c.VerifyPeerCertificate,
+	}
+	for _, cert := range c.Certificates {
+		config.Certificates = append(config.Certificates, utls.Certificate{
+			Certificate: cert.Certificate,
+			PrivateKey:  cert.PrivateKey,
+			Leaf:        cert.Leaf,
+		})
+	}
+	return config
+}
+
+// tlsCredentials TLS клиента gRPC с ClientHello из StreamOptions.TLSFingerprint
+// вместо credentials.NewTLS
+type tlsCredentials struct {
+	opts   StreamOptions
+	config *tls.Config
+}
+
+// tlsAuthInfo сведения о соединении для gRPC: соединение зашифровано и подлинно
+type tlsAuthInfo struct {
+	credentials.CommonAuthInfo
+}
+
+func (tlsAuthInfo) AuthType() string { return "tls" }
+
+func (c *tlsCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, err := c.opts.tlsHandshake(ctx, rawConn, c.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Как credentials.NewTLS: без HTTP/2 по ALPN соединение не годится для gRPC
+	if proto := negotiatedProtocol(conn); proto != "h2" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("server did not negotiate HTTP/2 over ALPN (got %q)", proto)
+	}
+	return conn, tlsAuthInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}, nil
+}
+
+func (c *tlsCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("TLS fingerprint credentials are client-only")
+}
+
+func (c *tlsCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", ServerName: c.config.ServerName}
+}
+
+func (c *tlsCredentials) Clone() credentials.TransportCredentials {
+	return &tlsCredentials{opts: c.opts, config: c.config.Clone()}
+}
+
+func (c *tlsCredentials) OverrideServerName(name string) error {
+	c.config.ServerName = name
+	return nil
+}
@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testCertificate самоподписанный сертификат сервера vpn.test и пул корней с ним
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vpn.test"},
+		DNSNames:     []string{"vpn.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+// handshakeResult итог рукопожатия с тестовым сервером TLS
+type handshakeResult struct {
+	proto  string   // протокол по ALPN на клиенте
+	hello  []uint16 // шифры из ClientHello, которые видел сервер
+	server error
+}
+
+// testHandshake выполняет рукопожатие клиента opts с сервером crypto/tls, который знает
+// h2 и http/1.1. Соединение - TCP на loopback: в net.Pipe клиент, прервавший рукопожатие,
+// и сервер, еще отправляющий свои сообщения, блокируются на записи
+func testHandshake(t *testing.T, opts StreamOptions, config *tls.Config) (handshakeResult, error) {
+	t.Helper()
+	cert, _ := testCertificate(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	clientConn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	serverConn, err := lis.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+
+	var result handshakeResult
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				result.hello = hello.CipherSuites
+				return nil, nil
+			},
+		})
+		result.server = srv.Handshake()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := opts.tlsHandshake(ctx, clientConn, config)
+	if err != nil {
+		clientConn.Close()
+		<-done
+		return result, err
+	}
+	result.proto = negotiatedProtocol(conn)
+	<-done
+	return result, nil
+}
+
+// isGREASE сообщает, что шифр - GREASE (RFC 8701): такие добавляют браузеры, но не crypto/tls
+func isGREASE(suite uint16) bool {
+	return suite&0x0f0f == 0x0a0a && suite>>8 == suite&0xff
+}
+
+// TestTLSFingerprints проверяет рукопожатие с каждым ClientHello: сервер выбирает протокол
+// из ALPN клиента, а не из ClientHello браузера, и сертификат проверяется
+func TestTLSFingerprints(t *testing.T) {
+	_, roots := testCertificate(t)
+	for _, name := range []string{"", "chrome", "firefox", "safari", "edge", "ios", TLSFingerprintGo} {
+		for _, proto := range []string{"http/1.1", "h2"} {
+			// Корни другого сертификата: рукопожатие проходит только с InsecureSkipVerify
+			config := &tls.Config{ServerName: "vpn.test", RootCAs: roots, InsecureSkipVerify: true, NextProtos: []string{proto}}
+			result, err := testHandshake(t, StreamOptions{TLSFingerprint: name}, config)
+			if err != nil {
+				t.Fatalf("%q %s: %v", name, proto, err)
+			}
+			if result.server != nil {
+				t.Fatalf("%q %s: server: %v", name, proto, result.server)
+			}
+			if result.proto != proto {
+				t.Errorf("%q %s: negotiated %q", name, proto, result.proto)
+			}
+			if grease := slices.ContainsFunc(result.hello, isGREASE); grease != (name != "firefox" && name != TLSFingerprintGo) {
+				t.Errorf("%q: GREASE in ClientHello %v", name, grease)
+			}
+		}
+	}
+}
+
+// TestTLSFingerprintVerify проверяет, что с ClientHello браузера сертификат сервера
+// по-прежнему проверяется
+func TestTLSFingerprintVerify(t *testing.T) {
+	_, roots := testCertificate(t)
+	config := &tls.Config{ServerName: "vpn.test", RootCAs: roots, NextProtos: []string{"http/1.1"}}
+	if _, err := testHandshake(t, StreamOptions{}, config); err == nil {
+		t.Fatal("handshake succeeded with a certificate from an unknown issuer")
+	}
+}
+
+func TestCheckTLSFingerprint(t *testing.T) {
+	for _, name := range []string{"", "chrome", "go", "ios"} {
+		if err := CheckTLSFingerprint(name); err != nil {
+			t.Errorf("CheckTLSFingerprint(%q): %v", name, err)
+		}
+	}
+	if err := CheckTLSFingerprint("opera"); err == nil {
+		t.Error("CheckTLSFingerprint accepted opera")
+	}
+}
@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"myvpn/internal/bufpool"
+	"myvpn/internal/compress"
+)
+
+const (
+	// FragmentTimeout время, за которое должны прийти все фрагменты пакета
+	FragmentTimeout = 5 * time.Second
+	// MaxFragments максимальное число фрагментов одного пакета
+	MaxFragments = 64
+	// MaxFragmentedSize максимальный размер пакета, который можно передать фрагментами
+	MaxFragmentedSize = 65535
+
+	// fragmentHeaderSize заголовок фрагмента в начале зашифрованных данных:
+	// ID пакета (4) + номер фрагмента (1) + число фрагментов (1)
+	fragmentHeaderSize = 6
+	// maxPendingPackets ограничивает число пакетов, собираемых одновременно
+	maxPendingPackets = 256
+)
+
+// fragmentKey идентифицирует собираемый пакет. ID выдает отправитель, поэтому он уникален
+// только в пределах сессии
+type fragmentKey struct {
+	sessionID uint64
+	id        uint32
+}
+
+// pendingPacket пакет, часть фрагментов которого уже пришла
+type pendingPacket struct {
+	parts    [][]byte
+	received int
+	size     int
+	codec    compress.Codec
+	created  time.Time
+}
+
+// reassembler собирает пакеты из фрагментов. Фрагменты попадают сюда уже расшифрованными,
+// поэтому заполнить буфер может только владелец ключа, а незавершенные пакеты
+// удаляются через FragmentTimeout
+type reassembler struct {
+	mu        sync.Mutex
+	pending   map[fragmentKey]*pendingPacket
+	lastSweep time.Time
+}
+
+// newReassembler создает пустой буфер сборки
+func newReassembler() *reassembler {
+	return &reassembler{
+		pending:   make(map[fragmentKey]*pendingPacket),
+		lastSweep: time.Now(),
+	}
+}
+
+// add добавляет расшифрованный фрагмент (с заголовком). Когда пришли все фрагменты,
+// возвращает собранный пакет и true
+func (r *reassembler) add(sessionID uint64, fragment []byte, codec compress.Codec) ([]byte, bool, error) {
+	if len(fragment) < fragmentHeaderSize {
+		return nil, false, fmt.Errorf("fragment too short")
+	}
+	key := fragmentKey{sessionID: sessionID, id: binary.BigEndian.Uint32(fragment)}
+	index, count := int(fragment[4]), int(fragment[5])
+	if count < 2 || count > MaxFragments || index >= count {
+		return nil, false, fmt.Errorf("invalid fragment %d of %d", index, count)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastSweep) >= time.Second {
+		r.sweep(now)
+	}
+
+	p, ok := r.pending[key]
+	if !ok {
+		if len(r.pending) >= maxPendingPackets {
+			return nil, false, fmt.Errorf("too many packets pending reassembly")
+		}
+		p = &pendingPacket{parts: make([][]byte, count), codec: codec, created: now}
+		r.pending[key] = p
+	}
+	if len(p.parts) != count || p.codec != codec {
+		delete(r.pending, key)
+		return nil, false, fmt.Errorf("fragment does not match packet %d", key.id)
+	}
+	if p.parts[index] != nil {
+		return nil, false, nil // дубликат
+	}
+
+	chunk := fragment[fragmentHeaderSize:]
+	if p.size+len(chunk) > MaxFragmentedSize {
+		delete(r.pending, key)
+		return nil, false, fmt.Errorf("reassembled packet too large")
+	}
+	p.parts[index] = append([]byte(nil), chunk...)
+	p.size += len(chunk)
+	p.received++
+	if p.received < count {
+		return nil, false, nil
+	}
+
+	delete(r.pending, key)
+	packet := make([]byte, 0, p.size)
+	for _, part := range p.parts {
+		packet = append(packet, part...)
+	}
+	return packet, true, nil
+}
+
+// sweep удаляет пакеты, которые не собрались за FragmentTimeout. Требует r.mu
+func (r *reassembler) sweep(now time.Time) {
+	r.lastSweep = now
+	for key, p := range r.pending {
+		if now.Sub(p.created) > FragmentTimeout {
+			delete(r.pending, key)
+			metricReassemblyTimeouts.Inc()
+		}
+	}
+}
+
+// writeFragments делит пакет с данными, который не помещается в один пакет транспорта,
+// на фрагменты примерно равного размера и отправляет каждый отдельным зашифрованным пакетом
+func (t *UDPTransport) writeFragments(data []byte, codec compress.Codec, addr *net.UDPAddr, sessionID uint64, dscp uint8) (int, error) {
+	if len(data) > MaxFragmentedSize {
+		return 0, fmt.Errorf("packet too large: %d bytes (max %d)", len(data), MaxFragmentedSize)
+	}
+	chunkMax := t.maxPayload(sessionID) - fragmentHeaderSize
+	count := (len(data) + chunkMax - 1) / chunkMax
+	if count > MaxFragments {
+		return 0, fmt.Errorf("packet too large: %d bytes needs %d fragments (max %d)", len(data), count, MaxFragments)
+	}
+	chunkSize := (len(data) + count - 1) / count
+
+	// Фрагмент и пакет собираются в буферах из пула, общих для всех фрагментов
+	payloadBuf := bufpool.GetDatagram()
+	defer bufpool.PutDatagram(payloadBuf)
+	packetBuf := bufpool.GetDatagram()
+	defer bufpool.PutDatagram(packetBuf)
+
+	id := t.fragmentID.Add(1)
+	for i := 0; i < count; i++ {
+		chunk := data[i*chunkSize : min((i+1)*chunkSize, len(data))]
+		payload := payloadBuf[:fragmentHeaderSize+len(chunk)]
+		binary.BigEndian.PutUint32(payload, id)
+		payload[4] = byte(i)
+		payload[5] = byte(count)
+		copy(payload[fragmentHeaderSize:], chunk)
+
+		packet, err := t.sealPacket(packetBuf, PacketTypeFragment, payload, codec, sessionID)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := t.writeRawDSCP(packet, addr, dscp); err != nil {
+			return 0, err
+		}
+		t.protect(packet, addr)
+	}
+	metricFragmentedPackets.Inc()
+	return len(data), nil
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// DefaultGRPCService имя сервиса gRPC по умолчанию. Путь запросов - /<сервис>/Stream,
+// и его можно выбрать похожим на API сайта за CDN
+const DefaultGRPCService = "myvpn.Tunnel"
+
+// grpcMethod имя метода двунаправленного потока
+const grpcMethod = "Stream"
+
+// grpcStream датаграммы в сообщениях gRPC потока, по одной на сообщение. Сообщение -
+// google.protobuf.BytesValue, поэтому поток не отличить от обычного gRPC API и не нужен
+// сгенерированный код
+type grpcStream struct {
+	stream grpc.Stream
+	cancel context.CancelFunc // nil на сервере: поток завершается возвратом из обработчика
+	conn   *grpc.ClientConn   // nil на сервере
+}
+
+func (s *grpcStream) ReadPacket() ([]byte, error) {
+	var msg wrapperspb.BytesValue
+	if err := s.stream.RecvMsg(&msg); err != nil {
+		return nil, err
+	}
+	return msg.Value, nil
+}
+
+func (s *grpcStream) WritePacket(p []byte) error {
+	return s.stream.SendMsg(wrapperspb.Bytes(p))
+}
+
+// SetReadDeadline ничего не делает: у gRPC потока нет deadline чтения. Пропавшего клиента
+// находит keepalive HTTP/2 (grpcKeepalive), и его поток завершается с ошибкой
+func (s *grpcStream) SetReadDeadline(t time.Time) error { return nil }
+
+func (s *grpcStream) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	s.cancel()
+	return s.conn.Close()
+}
+
+// grpcStreamDesc описание метода потока: и клиент, и сервер отправляют сообщения
+var grpcStreamDesc = grpc.StreamDesc{
+	StreamName:    grpcMethod,
+	ClientStreams: true,
+	ServerStreams: true,
+}
+
+// grpcKeepalive период ping HTTP/2 и время ожидания ответа на него. Ping не дает CDN
+// закрыть соединение, а сервер по нему закрывает соединения пропавших клиентов
+const (
+	grpcKeepalive        = 30 * time.Second
+	grpcKeepaliveTimeout = 15 * time.Second
+)
+
+// NewGRPCServer создает gRPC сервер с TLS, который пересылает датаграммы потоков
+// клиентов на UDP адрес target. Имена из сертификатов клиентов записываются в ids
+// (nil - не записываются). service - имя сервиса (пустая строка - DefaultGRPCService)
+func NewGRPCServer(tlsConfig *tls.Config, service string, target *net.UDPAddr, ids *StreamIdentities) *grpc.Server {
+	if service == "" {
+		service = DefaultGRPCService
+	}
+	desc := grpcStreamDesc
+	desc.Handler = func(_ any, ss grpc.ServerStream) error {
+		var identity string
+		if p, ok := peer.FromContext(ss.Context()); ok {
+			if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				identity = tlsIdentity(&info.State)
+			}
+		}
+		relayStream(&grpcStream{stream: ss}, target, identity, ids)
+		return nil
+	}
+
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: grpcKeepalive, Timeout: grpcKeepaliveTimeout}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: grpcKeepalive / 2, PermitWithoutStream: true}),
+	)
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: service,
+		HandlerType: (*any)(nil),
+		Streams:     []grpc.StreamDesc{desc},
+	}, nil)
+	return srv
+}
+
+// dialGRPC открывает gRPC поток с сервером по HTTP/2 поверх TLS (напрямую или через
+// HTTP прокси). Попытка соединения (TCP, TLS и HTTP/2) ограничена opts.Timeout
+func dialGRPC(opts StreamOptions) (packetStream, error) {
+	host, _, err := net.SplitHostPort(opts.GRPCAddr)
+	if err != nil {
+		return nil, err
+	}
+	config := opts.clientTLS(host)
+	config.NextProtos = []string{"h2"}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout, Control: socketControl(opts.Mark, opts.Protect)}
+	params := grpc.ConnectParams{Backoff: backoff.DefaultConfig, MinConnectTimeout: opts.Timeout}
+	conn, err := grpc.NewClient("passthrough:///"+opts.GRPCAddr,
+		grpc.WithTransportCredentials(&tlsCredentials{opts: opts, config: config}),
+		grpc.WithConnectParams(params),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: grpcKeepalive, Timeout: grpcKeepaliveTimeout}),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			if opts.HTTPProxy != nil {
+				return dialHTTPProxy(opts, addr)
+			}
+			return dialer.DialContext(ctx, "tcp", addr)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	service := opts.GRPCService
+	if service == "" {
+		service = DefaultGRPCService
+	}
+	// Без WaitForReady создание потока ждет первой попытки соединения и возвращает
+	// ее ошибку (сервер недоступен, TLS), а не ждет следующих
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := conn.NewStream(ctx, &grpcStreamDesc, "/"+service+"/"+grpcMethod)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, err
+	}
+	return &grpcStream{stream: stream, cancel: cancel, conn: conn}, nil
+}
@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"net"
+	"net/netip"
+
+	"myvpn/internal/ratelimit"
+)
+
+// DefaultHandshakeRate сколько пакетов неизвестных сессий в секунду сервер принимает
+// с одного IP адреса
+const DefaultHandshakeRate = 10
+
+// SetHandshakeLimit ограничивает на серверном транспорте число пакетов неизвестных сессий
+// (установка сессии) с одного IP адреса: perSecond в секунду и пачку до двух секунд лимита,
+// чтобы клиенты за одним NAT могли подключиться одновременно. 0 - без ограничения.
+// Вызывается до начала обмена пакетами
+func (t *UDPTransport) SetHandshakeLimit(perSecond float64) {
+	t.handshakes = ratelimit.NewKeyed[netip.Addr](perSecond, int(2*perSecond))
+}
+
+// allowHandshake списывает пакет неизвестной сессии из лимита адреса отправителя
+func (t *UDPTransport) allowHandshake(addr *net.UDPAddr) bool {
+	if t.handshakes == nil {
+		return true
+	}
+	ip, _ := netip.AddrFromSlice(addr.IP)
+	if t.handshakes.Allow(ip.Unmap()) {
+		return true
+	}
+	metricHandshakeLimited.Inc()
+	return false
+}
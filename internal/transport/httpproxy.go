@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ParseHTTPProxy разбирает адрес HTTP прокси: http://[user:pass@]host[:port] или
+// https://... для TLS до прокси. Адрес без схемы считается http://
+func ParseHTTPProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		// host:port без схемы url.Parse принимает за схему и путь
+		if u, err = url.Parse("http://" + raw); err != nil {
+			return nil, fmt.Errorf("invalid HTTP proxy address %q: %w", raw, err)
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported HTTP proxy scheme %q (expected http or https)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("HTTP proxy address %q has no host", raw)
+	}
+	return u, nil
+}
+
+// httpProxyAddr адрес прокси host:port, порт по умолчанию зависит от схемы
+func httpProxyAddr(proxy *url.URL) string {
+	port := proxy.Port()
+	if port == "" {
+		port = "80"
+		if proxy.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// dialHTTPProxy открывает туннель к addr через HTTP прокси методом CONNECT.
+// Сокет соединения с прокси получает метку и защиту от захвата в TUN, как прямое соединение
+func dialHTTPProxy(opts StreamOptions, addr string) (net.Conn, error) {
+	proxy := opts.HTTPProxy
+	dialer := &net.Dialer{Timeout: opts.Timeout, Control: socketControl(opts.Mark, opts.Protect)}
+	raw, err := dialer.Dial("tcp", httpProxyAddr(proxy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to HTTP proxy: %w", err)
+	}
+	raw.(*net.TCPConn).SetNoDelay(true)
+	conn := raw
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+	if proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("HTTP proxy TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to HTTP proxy: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("failed to read HTTP proxy response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		raw.Close()
+		return nil, fmt.Errorf("HTTP proxy authentication required (%s)", resp.Status)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		raw.Close()
+		return nil, fmt.Errorf("HTTP proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// Сервер успел ответить вместе с прокси: прочитанное не должно потеряться
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn соединение, чтение из которого начинается с уже прочитанных в буфер данных
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsHandshakeTimeout время на TLS рукопожатие клиента TCP транспорта
+const tlsHandshakeTimeout = 10 * time.Second
+
+// StreamIdentities имена из сертификатов клиентов потоковых транспортов с mTLS по адресам
+// их релеев. Сервер видит такого клиента как UDP адрес релея на loopback и по нему узнает,
+// каким сертификатом клиент подтвердил себя
+type StreamIdentities struct {
+	mu     sync.RWMutex
+	byAddr map[netip.AddrPort]string
+}
+
+// NewStreamIdentities создает пустой реестр
+func NewStreamIdentities() *StreamIdentities {
+	return &StreamIdentities{byAddr: make(map[netip.AddrPort]string)}
+}
+
+// Lookup возвращает имя из сертификата клиента, пакеты которого приходят с адреса addr.
+// false - адрес не релей TLS соединения с сертификатом (обычный UDP клиент)
+func (i *StreamIdentities) Lookup(addr *net.UDPAddr) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	identity, ok := i.byAddr[addr.AddrPort()]
+	return identity, ok
+}
+
+func (i *StreamIdentities) add(addr net.Addr, identity string) netip.AddrPort {
+	key := addr.(*net.UDPAddr).AddrPort()
+	i.mu.Lock()
+	i.byAddr[key] = identity
+	i.mu.Unlock()
+	return key
+}
+
+func (i *StreamIdentities) remove(key netip.AddrPort) {
+	i.mu.Lock()
+	delete(i.byAddr, key)
+	i.mu.Unlock()
+}
+
+// CertIdentity возвращает имя клиента из сертификата: Common Name, а без него
+// первое DNS имя или email из Subject Alternative Name
+func CertIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
+
+// tlsIdentity возвращает имя из проверенного сертификата клиента TLS соединения
+// (пустая строка - сертификата нет)
+func tlsIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	return CertIdentity(state.VerifiedChains[0][0])
+}
+
+// handshakeTLS завершает TLS рукопожатие клиента TCP транспорта и возвращает имя
+// из его сертификата. Для соединения без TLS возвращает пустую строку
+func handshakeTLS(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return "", fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	state := tlsConn.ConnectionState()
+	return tlsIdentity(&state), nil
+}
+
+// LoadClientCAs загружает из PEM файла сертификаты центров, которыми должны быть
+// подписаны сертификаты клиентов
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no PEM certificates found in client CA file")
+	}
+	return pool, nil
+}
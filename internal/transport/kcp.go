@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+const (
+	// kcpWindow размер окна отправки и приема KCP в сегментах
+	kcpWindow = 1024
+	// kcpInterval период внутреннего цикла KCP в миллисекундах
+	kcpInterval = 10
+	// kcpResend быстрый повтор после стольких ACK следующих сегментов
+	kcpResend = 2
+	// kcpMTU размер датаграммы KCP: меньше типичного MTU с запасом на вложенные туннели
+	kcpMTU = 1350
+)
+
+// tuneKCP настраивает сессию на малую задержку при потерях: без ожидания ACK,
+// быстрые повторы и без контроля перегрузки (он принимает потери за перегрузку)
+func tuneKCP(sess *kcp.UDPSession) {
+	sess.SetStreamMode(true)
+	sess.SetWriteDelay(false)
+	sess.SetNoDelay(1, kcpInterval, kcpResend, 1)
+	sess.SetWindowSize(kcpWindow, kcpWindow)
+	sess.SetACKNoDelay(true)
+	sess.SetMtu(kcpMTU)
+}
+
+// dialKCP открывает KCP сессию с сервером. У KCP нет рукопожатия: сессия создается
+// сразу, а недоступность сервера выясняется по отсутствию ответов. Сокет сессии
+// помечается меткой mark (0 - без метки) и передается protect (nil - не передается)
+func dialKCP(addr string, fec FEC, mark uint32, protect func(fd int) error) (packetStream, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if raddr.IP.To4() == nil {
+		network = "udp"
+	}
+	lc := net.ListenConfig{Control: socketControl(mark, protect)}
+	conn, err := lc.ListenPacket(context.Background(), network, ":0")
+	if err != nil {
+		return nil, err
+	}
+	var convid uint32
+	binary.Read(rand.Reader, binary.LittleEndian, &convid)
+	sess, err := kcp.NewConn4(convid, raddr, nil, fec.Data, fec.Parity, true, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tuneKCP(sess)
+	return &tcpStream{conn: sess}, nil
+}
+
+// ListenKCP создает KCP listener. Параметры FEC должны совпадать с клиентскими.
+// Шифрование KCP не нужно: датаграммы протокола уже зашифрованы
+func ListenKCP(addr string, fec FEC) (*kcp.Listener, error) {
+	ln, err := kcp.ListenWithOptions(addr, nil, fec.Data, fec.Parity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// ServeKCP принимает KCP сессии клиентов и пересылает их датаграммы на UDP адрес target.
+// Возвращает ошибку, когда listener закрыт
+func ServeKCP(ln *kcp.Listener, target *net.UDPAddr) error {
+	for {
+		sess, err := ln.AcceptKCP()
+		if err != nil {
+			return err
+		}
+		tuneKCP(sess)
+		go relayStream(&tcpStream{conn: sess}, target, "", nil)
+	}
+}
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"myvpn/internal/compress"
+)
+
+// probeAckSize размер тела ответа на пробу PMTU: sequence пробы (8) + размер пробы (2)
+const probeAckSize = 10
+
+// isServicePacket сообщает, является ли пакет keepalive, пробой PMTU или ответом на них
+func isServicePacket(packetType byte) bool {
+	switch packetType {
+	case PacketTypeKeepalive, PacketTypeKeepaliveAck, PacketTypeProbe, PacketTypeProbeAck:
+		return true
+	}
+	return false
+}
+
+// SetLegacyKeepalive разрешает принимать keepalive и пробы PMTU версий до аутентифицированных
+// keepalive: они передаются открыто, поэтому их можно подделать. Открытые ответы старого
+// сервера при этом тоже считаются признаком живого соединения. По умолчанию выключено
+func (t *UDPTransport) SetLegacyKeepalive(allow bool) {
+	t.legacy.Store(allow)
+	for _, p := range t.paths {
+		p.legacy.Store(allow)
+	}
+}
+
+// handleKeepalive обрабатывает расшифрованный keepalive, пробу PMTU или ответ на них.
+// size - размер датаграммы, seq - ее sequence
+func (t *UDPTransport) handleKeepalive(packetType byte, body []byte, seq uint64, size int, addr *net.UDPAddr, sessionID uint64) error {
+	switch packetType {
+	case PacketTypeKeepalive:
+		// Ответ несет sequence keepalive, чтобы отправитель измерил RTT
+		t.writePacket(PacketTypeKeepaliveAck, binary.BigEndian.AppendUint64(nil, seq), compress.CodecNone, addr, sessionID, 0)
+	case PacketTypeKeepaliveAck:
+		return t.handleKeepaliveAck(body, sessionID)
+	case PacketTypeProbe:
+		ack := binary.BigEndian.AppendUint64(nil, seq)
+		ack = binary.BigEndian.AppendUint16(ack, uint16(size))
+		t.writePacket(PacketTypeProbeAck, ack, compress.CodecNone, addr, sessionID, 0)
+	case PacketTypeProbeAck:
+		if len(body) != probeAckSize {
+			return fmt.Errorf("invalid probe ack size: %d", len(body))
+		}
+		t.deliverProbeAck(binary.BigEndian.Uint64(body), int(binary.BigEndian.Uint16(body[8:])))
+	}
+	return nil
+}
+
+// handleLegacy обрабатывает открытые keepalive и пробы PMTU старых версий
+func (t *UDPTransport) handleLegacy(buf []byte, addr *net.UDPAddr, sessionID uint64) (int, compress.Codec, *net.UDPAddr, uint64, error) {
+	if !t.legacy.Load() {
+		metricMalformed.Inc()
+		return 0, compress.CodecNone, addr, 0, fmt.Errorf("unauthenticated packet of type %d", buf[0])
+	}
+
+	switch buf[0] {
+	case PacketTypeKeepalive:
+		// Отвечаем с тем же session ID и sequence
+		ack := make([]byte, HeaderSize)
+		copy(ack, buf[:HeaderSize])
+		ack[0] = PacketTypeKeepaliveAck
+		t.writeRaw(ack, addr)
+	case PacketTypeKeepaliveAck:
+		t.lastRecv.Store(time.Now().UnixNano())
+	case PacketTypeProbe:
+		ack := make([]byte, HeaderSize+2)
+		copy(ack, buf[:HeaderSize])
+		ack[0] = PacketTypeProbeAck
+		binary.BigEndian.PutUint16(ack[HeaderSize:], uint16(len(buf)))
+		t.writeRaw(ack, addr)
+	case PacketTypeProbeAck:
+		if len(buf) >= HeaderSize+2 {
+			t.deliverProbeAck(binary.BigEndian.Uint64(buf[sequenceOffset:]), int(binary.BigEndian.Uint16(buf[HeaderSize:])))
+		}
+	}
+	return 0, compress.CodecNone, addr, sessionID, nil
+}
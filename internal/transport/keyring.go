@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownSession сессия не привязана ни к одному ключу
+	ErrUnknownSession = errors.New("unknown session")
+	// ErrNoMatchingKey пакет не расшифровывается ни одним ключом
+	ErrNoMatchingKey = errors.New("no matching key")
+)
+
+// Keyring реализует Crypto для сервера с несколькими ключами (по одному на пира).
+// Первый пакет новой сессии проверяется всеми ключами, после чего сессия
+// привязывается к подошедшему ключу. Session ID берется из AAD заголовка.
+// Привязки сессий читаются на каждый пакет без блокировок, а меняются под mu
+type Keyring struct {
+	mu       sync.RWMutex
+	keys     map[string]Crypto
+	sessions sync.Map // session ID -> *keyBinding
+}
+
+// keyBinding ключ, к которому привязана сессия
+type keyBinding struct {
+	name   string
+	crypto Crypto
+}
+
+// NewKeyring создает пустой набор ключей
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string]Crypto),
+	}
+}
+
+// Add добавляет (или заменяет) ключ пира. Сессии пира переходят на новый ключ
+func (k *Keyring) Add(name string, crypto Crypto) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, replaced := k.keys[name]
+	k.keys[name] = crypto
+	if !replaced {
+		return
+	}
+	k.sessions.Range(func(sessionID, value any) bool {
+		if value.(*keyBinding).name == name {
+			k.sessions.Store(sessionID, &keyBinding{name: name, crypto: crypto})
+		}
+		return true
+	})
+}
+
+// Remove отзывает ключ пира и возвращает сессии, которые его использовали
+func (k *Keyring) Remove(name string) ([]uint64, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[name]; !ok {
+		return nil, false
+	}
+	delete(k.keys, name)
+
+	var sessions []uint64
+	k.sessions.Range(func(sessionID, value any) bool {
+		if value.(*keyBinding).name == name {
+			sessions = append(sessions, sessionID.(uint64))
+			k.sessions.Delete(sessionID)
+		}
+		return true
+	})
+	return sessions, true
+}
+
+// Names возвращает отсортированный список имен пиров
+func (k *Keyring) Names() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	names := make([]string, 0, len(k.keys))
+	for name := range k.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has проверяет наличие ключа пира
+func (k *Keyring) Has(name string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	_, ok := k.keys[name]
+	return ok
+}
+
+// SessionPeer возвращает имя пира, к ключу которого привязана сессия
+func (k *Keyring) SessionPeer(sessionID uint64) (string, bool) {
+	value, ok := k.sessions.Load(sessionID)
+	if !ok {
+		return "", false
+	}
+	return value.(*keyBinding).name, true
+}
+
+// ForgetSession отвязывает сессию от ключа
+func (k *Keyring) ForgetSession(sessionID uint64) {
+	k.sessions.Delete(sessionID)
+}
+
+// Seal шифрует пакет ключом сессии из AAD
+func (k *Keyring) Seal(dst, nonce, plaintext, aad []byte) ([]byte, error) {
+	crypto, ok := k.sessionCrypto(aad)
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+	return crypto.Seal(dst, nonce, plaintext, aad)
+}
+
+// Open расшифровывает пакет ключом сессии, а для новой сессии перебирает все ключи.
+// Шифротекст не должен пересекаться с dst: он нужен для следующей попытки
+func (k *Keyring) Open(dst, nonce, ciphertext, aad []byte) ([]byte, error) {
+	if crypto, ok := k.sessionCrypto(aad); ok {
+		return crypto.Open(dst, nonce, ciphertext, aad)
+	}
+	if len(aad) < sequenceOffset {
+		return nil, ErrUnknownSession
+	}
+	sessionID := binary.BigEndian.Uint64(aad[sessionOffset:])
+
+	k.mu.RLock()
+	candidates := make(map[string]Crypto, len(k.keys))
+	for name, crypto := range k.keys {
+		candidates[name] = crypto
+	}
+	k.mu.RUnlock()
+
+	for name, crypto := range candidates {
+		plaintext, err := crypto.Open(dst, nonce, ciphertext, aad)
+		if err != nil {
+			continue
+		}
+		k.mu.Lock()
+		// Ключ мог быть отозван или заменен, пока мы расшифровывали
+		if k.keys[name] == crypto {
+			k.sessions.Store(sessionID, &keyBinding{name: name, crypto: crypto})
+		}
+		k.mu.Unlock()
+		return plaintext, nil
+	}
+	return nil, ErrNoMatchingKey
+}
+
+// sessionCrypto возвращает Crypto, к которому привязана сессия из AAD
+func (k *Keyring) sessionCrypto(aad []byte) (Crypto, bool) {
+	if len(aad) < sequenceOffset {
+		return nil, false
+	}
+	value, ok := k.sessions.Load(binary.BigEndian.Uint64(aad[sessionOffset:]))
+	if !ok {
+		return nil, false
+	}
+	return value.(*keyBinding).crypto, true
+}
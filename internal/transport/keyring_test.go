@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"myvpn/internal"
+	"myvpn/internal/bufpool"
+)
+
+// mutexKeyring привязки сессий под RWMutex, как до перехода Keyring на sync.Map:
+// с ним сравнивается Keyring.Seal в BenchmarkKeyringSeal
+type mutexKeyring struct {
+	mu       sync.RWMutex
+	keys     map[string]Crypto
+	sessions map[uint64]string
+}
+
+func (k *mutexKeyring) Seal(dst, nonce, plaintext, aad []byte) ([]byte, error) {
+	sessionID := binary.BigEndian.Uint64(aad[sessionOffset:])
+	k.mu.RLock()
+	crypto, ok := k.keys[k.sessions[sessionID]]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+	return crypto.Seal(dst, nonce, plaintext, aad)
+}
+
+// newBenchKeyring создает Keyring с одним ключом и привязывает к нему сессию sessionID
+// так же, как сервер: первым пакетом сессии, который расшифровался этим ключом
+func newBenchKeyring(tb testing.TB, sessionID uint64) (*Keyring, Crypto) {
+	tb.Helper()
+	crypto, err := internal.NewCrypto(make([]byte, internal.KeySize))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	k := NewKeyring()
+	k.Add("peer", crypto)
+	aad := benchHeader(sessionID)
+	nonce := make([]byte, internal.NonceSize)
+	sealed, _ := crypto.Seal(nil, nonce, []byte("hello"), aad)
+	if _, err := k.Open(nil, nonce, sealed, aad); err != nil {
+		tb.Fatal(err)
+	}
+	return k, crypto
+}
+
+// benchHeader заголовок пакета с данными сессии sessionID (AAD)
+func benchHeader(sessionID uint64) []byte {
+	header := make([]byte, payloadOffset)
+	header[0] = PacketTypeData
+	binary.BigEndian.PutUint64(header[sessionOffset:], sessionID)
+	return header
+}
+
+// BenchmarkKeyringSeal шифрует пакеты одной сессии из параллельных горутин
+func BenchmarkKeyringSeal(b *testing.B) {
+	keyring, crypto := newBenchKeyring(b, 1)
+	locked := &mutexKeyring{keys: map[string]Crypto{"peer": crypto}, sessions: map[uint64]string{1: "peer"}}
+	for _, bench := range []struct {
+		name string
+		seal func(dst, nonce, plaintext, aad []byte) ([]byte, error)
+	}{
+		{"syncmap", keyring.Seal},
+		{"rwmutex", locked.Seal},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(benchPacketSize)
+			b.RunParallel(func(pb *testing.PB) {
+				aad := benchHeader(1)
+				plaintext := make([]byte, benchPacketSize)
+				dst := bufpool.GetDatagram()
+				defer bufpool.PutDatagram(dst)
+				nonce := make([]byte, internal.NonceSize)
+				for pb.Next() {
+					if _, err := bench.seal(dst[:0], nonce, plaintext, aad); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestKeyringBinding проверяет привязку сессии к ключу первым расшифрованным пакетом
+func TestKeyringBinding(t *testing.T) {
+	keyring, _ := newBenchKeyring(t, 1)
+	if peer, ok := keyring.SessionPeer(1); !ok || peer != "peer" {
+		t.Fatalf("SessionPeer(1) = %q, %v", peer, ok)
+	}
+	nonce := make([]byte, internal.NonceSize)
+	if _, err := keyring.Seal(nil, nonce, []byte("data"), benchHeader(2)); err != ErrUnknownSession {
+		t.Fatalf("Seal for an unbound session: %v, want ErrUnknownSession", err)
+	}
+	if _, err := keyring.Seal(nil, nonce, []byte("data"), benchHeader(1)); err != nil {
+		t.Fatalf("Seal for a bound session: %v", err)
+	}
+}
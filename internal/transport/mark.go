@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// SetMark помечает сокеты транспорта меткой fwmark (SO_MARK). По метке правило
+// `ip rule` направляет зашифрованные пакеты мимо TUN, даже если default route сменился.
+// Требует CAP_NET_ADMIN
+func (t *UDPTransport) SetMark(mark uint32) error {
+	return t.control(socketControl(mark, nil))
+}
+
+// Protect передает дескрипторы сокетов транспорта функции protect, которая выводит их
+// из VPN без прав на fwmark (VpnService.protect на Android)
+func (t *UDPTransport) Protect(protect func(fd int) error) error {
+	return t.control(socketControl(0, protect))
+}
+
+// control применяет fn к сокетам транспорта
+func (t *UDPTransport) control(fn func(network, address string, c syscall.RawConn) error) error {
+	for _, r := range t.readers {
+		raw, err := r.conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		if err := fn("", "", raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// socketControl возвращает функцию Control для net.Dialer и net.ListenConfig, которая
+// помечает сокет меткой mark (0 - без метки) и передает его protect (nil - не передает)
+func socketControl(mark uint32, protect func(fd int) error) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if mark == 0 && protect == nil {
+			return nil
+		}
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			if mark != 0 {
+				if err := setMark(int(fd), mark); err != nil {
+					sockErr = fmt.Errorf("failed to set fwmark %#x: %w", mark, err)
+					return
+				}
+			}
+			if protect != nil {
+				if err := protect(int(fd)); err != nil {
+					sockErr = fmt.Errorf("failed to protect socket: %w", err)
+				}
+			}
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
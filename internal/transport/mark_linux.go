@@ -0,0 +1,8 @@
+package transport
+
+import "golang.org/x/sys/unix"
+
+// setMark устанавливает сокету fd метку fwmark (SO_MARK)
+func setMark(fd int, mark uint32) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(mark))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transport
+
+import "errors"
+
+// setMark: fwmark есть только в Linux
+func setMark(fd int, mark uint32) error {
+	return errors.ErrUnsupported
+}
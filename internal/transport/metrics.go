@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"myvpn/internal/metrics"
+)
+
+// Метрики UDP транспорта
+var (
+	metricPacketsSent        = metrics.NewCounter("myvpn_transport_packets_sent_total", "UDP packets sent, including keepalives and control packets")
+	metricBytesSent          = metrics.NewCounter("myvpn_transport_bytes_sent_total", "UDP payload bytes sent")
+	metricPacketsReceived    = metrics.NewCounter("myvpn_transport_packets_received_total", "UDP packets received")
+	metricBytesReceived      = metrics.NewCounter("myvpn_transport_bytes_received_total", "UDP payload bytes received")
+	metricDecryptFailures    = metrics.NewCounter("myvpn_transport_decrypt_failures_total", "Packets dropped because authentication or decryption failed")
+	metricReplayDrops        = metrics.NewCounter("myvpn_transport_replay_drops_total", "Packets dropped by the anti-replay window")
+	metricMalformed          = metrics.NewCounter("myvpn_transport_malformed_packets_total", "Packets dropped because they were truncated or of unknown type")
+	metricFragmentedPackets  = metrics.NewCounter("myvpn_transport_fragmented_packets_total", "Oversized data packets sent as fragments")
+	metricReassemblyTimeouts = metrics.NewCounter("myvpn_transport_reassembly_timeouts_total", "Fragmented packets dropped because not all fragments arrived in time")
+	metricCoverSent          = metrics.NewCounter("myvpn_transport_cover_packets_sent_total", "Cover packets sent to hide traffic patterns")
+	metricStreamConns        = metrics.NewCounter("myvpn_transport_stream_connections_total", "TCP and WebSocket client connections relayed to the UDP socket")
+	metricFECParitySent      = metrics.NewCounter("myvpn_transport_fec_parity_sent_total", "FEC parity packets sent")
+	metricFECRecovered       = metrics.NewCounter("myvpn_transport_fec_recovered_total", "Lost packets reconstructed from FEC parity packets")
+	metricFECUnrecoverable   = metrics.NewCounter("myvpn_transport_fec_unrecoverable_total", "FEC groups that could not be reconstructed")
+	metricCookieReplies      = metrics.NewCounter("myvpn_transport_cookie_replies_total", "Cookie replies sent instead of decrypting packets of unknown sessions under load")
+	metricHandshakeLimited   = metrics.NewCounter("myvpn_transport_handshake_rate_limited_total", "Packets of unknown sessions dropped by the per-IP handshake rate limit")
+	metricCookieInvalid      = metrics.NewCounter("myvpn_transport_cookie_invalid_total", "Packets with an invalid or expired cookie, handled as packets without one")
+)
+
+// DropCounts принятые пакеты, отброшенные транспортом, по причинам
+type DropCounts struct {
+	DecryptFailures    uint64
+	Replay             uint64
+	Malformed          uint64
+	ReassemblyTimeouts uint64
+	HandshakeLimited   uint64
+}
+
+// Dropped возвращает число отброшенных транспортом пакетов с запуска процесса
+func Dropped() DropCounts {
+	return DropCounts{
+		DecryptFailures:    metricDecryptFailures.Load(),
+		Replay:             metricReplayDrops.Load(),
+		Malformed:          metricMalformed.Load(),
+		ReassemblyTimeouts: metricReassemblyTimeouts.Load(),
+		HandshakeLimited:   metricHandshakeLimited.Load(),
+	}
+}
@@ -0,0 +1,33 @@
+package transport
+
+// MultipathWindowSize размер anti-replay окна сессии, пакеты которой распределяются по нескольким
+// путям. Пакеты быстрого пути обгоняют пакеты медленного на сотни номеров, и с окном
+// по умолчанию отставшие пакеты отбрасывались бы как слишком старые
+const MultipathWindowSize = 16 * DefaultWindowSize
+
+// SetReplayWindow увеличивает anti-replay окно сессии до size
+func (t *UDPTransport) SetReplayWindow(sessionID uint64, size uint64) {
+	t.session(sessionID, true).replay.Resize(size)
+}
+
+// AddPath добавляет клиентскому транспорту дополнительный путь до сервера: транспорт,
+// привязанный к другому интерфейсу. Путь отправляет пакеты со счетчиком транспорта
+// и проверяет ответы его anti-replay окном, поэтому сервер видит пакеты всех путей
+// как одну сессию. Путь должен быть создан без keepalive: его keepalive запускается здесь,
+// после подмены счетчика. Путь закрывается вместе с транспортом
+func (t *UDPTransport) AddPath(p *UDPTransport) {
+	p.own = t.own
+	p.sessionID = t.sessionID
+	p.keepalive = t.keepalive
+	p.legacy.Store(t.legacy.Load())
+	if p.keepalive > 0 {
+		p.wg.Add(1)
+		go p.keepaliveLoop()
+	}
+	t.paths = append(t.paths, p)
+}
+
+// Paths возвращает дополнительные пути транспорта
+func (t *UDPTransport) Paths() []*UDPTransport {
+	return t.paths
+}
@@ -0,0 +1,28 @@
+package transport
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// BindToDevice привязывает сокет транспорта к сетевому интерфейсу (SO_BINDTODEVICE):
+// пакеты уходят через него независимо от маршрута по умолчанию. Требует CAP_NET_RAW
+func (t *UDPTransport) BindToDevice(iface string) error {
+	rawConn, err := t.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind to interface %s: %w", iface, err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transport
+
+import "fmt"
+
+// BindToDevice: SO_BINDTODEVICE есть только в Linux
+func (t *UDPTransport) BindToDevice(iface string) error {
+	return fmt.Errorf("failed to bind to interface %s: only supported on Linux", iface)
+}
@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand/v2"
+	"net"
+	"slices"
+	"time"
+
+	"myvpn/internal/compress"
+)
+
+const (
+	// flagPadded в байте флагов: данные дополнены до размера корзины (см. Obfuscation).
+	// Младшие биты байта флагов занимает ID кодека сжатия
+	flagPadded = 0x80
+	codecMask  = 0x7f
+
+	// padTrailerSize длина дополнения в конце расшифрованных данных (2 байта)
+	padTrailerSize = 2
+)
+
+// Obfuscation параметры маскировки трафика. Наблюдатель на пути видит только размеры
+// и моменты отправки зашифрованных пакетов, и этого часто хватает, чтобы узнать туннель
+// или угадать, что внутри. Выравнивание размеров и пакеты-пустышки размывают эту картину
+type Obfuscation struct {
+	// PadBucket дополняет зашифрованные данные до размера, кратного PadBucket байт
+	// (0 - без дополнения). Получатель снимает дополнение независимо от своих настроек
+	PadBucket int
+	// CoverInterval среднее время между пакетами-пустышками случайного размера (0 - не отправлять).
+	// Фактический интервал случаен: от половины до полутора CoverInterval
+	CoverInterval time.Duration
+}
+
+// SetObfuscation включает маскировку для пакетов, которые отправляет транспорт.
+// У клиентского транспорта сразу запускается отправка пакетов-пустышек,
+// серверу пустышки для клиентов нужно отправлять через WriteCover
+func (t *UDPTransport) SetObfuscation(o Obfuscation) {
+	t.obfs = o
+	if !t.server && o.CoverInterval > 0 {
+		t.wg.Add(1)
+		go t.coverLoop()
+	}
+}
+
+// WriteCover отправляет пакет-пустышку случайного размера. Он зашифрован как обычные данные,
+// поэтому отличить его от них нельзя, а получатель его просто отбрасывает
+func (t *UDPTransport) WriteCover(addr *net.UDPAddr, sessionID uint64) error {
+	if addr == nil {
+		return fmt.Errorf("remote address not set")
+	}
+	payload := make([]byte, mrand.IntN(t.maxPayload(sessionID)-padTrailerSize+1))
+	rand.Read(payload)
+	_, err := t.writePacket(PacketTypeCover, payload, compress.CodecNone, addr, sessionID, 0)
+	if err == nil {
+		metricCoverSent.Inc()
+	}
+	return err
+}
+
+// CoverDelay возвращает случайную задержку до следующей пустышки: от половины до полутора interval
+func CoverDelay(interval time.Duration) time.Duration {
+	return interval/2 + mrand.N(interval)
+}
+
+// coverLoop отправляет серверу пакеты-пустышки через случайные интервалы
+func (t *UDPTransport) coverLoop() {
+	defer t.wg.Done()
+
+	timer := time.NewTimer(CoverDelay(t.obfs.CoverInterval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-timer.C:
+			if addr := t.remoteAddr; addr != nil {
+				t.WriteCover(addr, t.sessionID)
+			}
+			timer.Reset(CoverDelay(t.obfs.CoverInterval))
+		}
+	}
+}
+
+// pad дополняет данные до размера, кратного PadBucket: данные + нули + длина дополнения (2 байта).
+// Дополненные данные записываются в dst, если его емкости хватает.
+// Возвращает false, если дополнение выключено или не помещается в пакет
+func (t *UDPTransport) pad(dst, data []byte, sessionID uint64) ([]byte, bool) {
+	bucket := t.obfs.PadBucket
+	if bucket <= 0 {
+		return data, false
+	}
+	max := t.maxPayload(sessionID)
+	size := len(data) + padTrailerSize
+	if size > max {
+		return data, false
+	}
+	if r := size % bucket; r != 0 {
+		size = min(size+bucket-r, max)
+	}
+
+	padded := slices.Grow(dst[:0], size)[:size]
+	copy(padded, data)
+	clear(padded[len(data) : size-padTrailerSize])
+	binary.BigEndian.PutUint16(padded[size-padTrailerSize:], uint16(size-len(data)-padTrailerSize))
+	return padded, true
+}
+
+// unpad снимает дополнение, добавленное pad
+func unpad(data []byte) ([]byte, error) {
+	if len(data) < padTrailerSize {
+		return nil, fmt.Errorf("padded packet too short")
+	}
+	end := len(data) - padTrailerSize
+	padding := int(binary.BigEndian.Uint16(data[end:]))
+	if padding > end {
+		return nil, fmt.Errorf("invalid padding length %d", padding)
+	}
+	return data[:end-padding], nil
+}
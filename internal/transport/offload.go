@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"sync"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// maxGSOSegments максимальное число датаграмм в одном GSO буфере (UDP_MAX_SEGMENTS в ядре)
+	maxGSOSegments = 64
+	// maxGSOSize максимальный размер GSO/GRO буфера (максимальная UDP датаграмма)
+	maxGSOSize = 65507
+)
+
+// gsoBuffers буферы, в которых WriteBatch склеивает датаграммы одному адресу. Без пула
+// буфер рос бы через append и пачка из 32 пакетов выделяла бы ~200 КБ
+var gsoBuffers = sync.Pool{
+	New: func() interface{} {
+		return new([maxGSOSize]byte)
+	},
+}
+
+// splitGSO разбивает GSO сообщение обратно на отдельные датаграммы
+func splitGSO(msg ipv4.Message, segmentSize int) []ipv4.Message {
+	buf := msg.Buffers[0]
+	var msgs []ipv4.Message
+	for len(buf) > 0 {
+		n := segmentSize
+		if n > len(buf) {
+			n = len(buf)
+		}
+		msgs = append(msgs, ipv4.Message{Buffers: [][]byte{buf[:n]}, Addr: msg.Addr})
+		buf = buf[n:]
+	}
+	return msgs
+}
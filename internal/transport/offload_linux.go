@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// udpOffload проверяет, поддерживает ли ядро UDP_SEGMENT (GSO) и UDP_GRO для сокета
+func udpOffload(conn *net.UDPConn) (gso, gro bool) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+	rawConn.Control(func(fd uintptr) {
+		_, err := unix.GetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_SEGMENT)
+		gso = err == nil
+		_, err = unix.GetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_GRO)
+		gro = err == nil
+	})
+	return gso, gro
+}
+
+// enableGRO включает склейку входящих датаграмм ядром (UDP_GRO)
+func enableGRO(conn *net.UDPConn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_GRO, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// gsoControl формирует управляющее сообщение UDP_SEGMENT с размером сегмента
+func gsoControl(segmentSize int) []byte {
+	oob := make([]byte, unix.CmsgSpace(2))
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level = unix.SOL_UDP
+	hdr.Type = unix.UDP_SEGMENT
+	hdr.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(oob[unix.CmsgLen(0):], uint16(segmentSize))
+	return oob
+}
+
+// groSegmentSize возвращает размер сегмента из управляющего сообщения UDP_GRO
+// или 0, если датаграмма не склеена
+func groSegmentSize(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level != unix.SOL_UDP || msg.Header.Type != unix.UDP_GRO {
+			continue
+		}
+		if len(msg.Data) >= 4 {
+			return int(binary.NativeEndian.Uint32(msg.Data))
+		}
+		if len(msg.Data) >= 2 {
+			return int(binary.NativeEndian.Uint16(msg.Data))
+		}
+	}
+	return 0
+}
+
+// isGSOError проверяет, что отправка не удалась из-за отсутствия GSO у драйвера
+// (ядро возвращает EIO, если сетевая карта не умеет считать контрольные суммы)
+func isGSOError(err error) bool {
+	return errors.Is(err, unix.EIO)
+}
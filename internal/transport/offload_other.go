@@ -0,0 +1,29 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+// udpOffload: UDP_SEGMENT и UDP_GRO есть только в Linux
+func udpOffload(conn *net.UDPConn) (gso, gro bool) {
+	return false, false
+}
+
+func enableGRO(conn *net.UDPConn) error {
+	return errors.ErrUnsupported
+}
+
+func gsoControl(segmentSize int) []byte {
+	return nil
+}
+
+func groSegmentSize(oob []byte) int {
+	return 0
+}
+
+func isGSOError(err error) bool {
+	return false
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// peerSession сессия клиентского транспорта с другим клиентом (P2P): свой ключ, счетчик
+// и anti-replay окно. Пакеты идут через тот же сокет, что и к серверу
+type peerSession struct {
+	state     *sessionState
+	crypto    Crypto
+	initiator bool         // сторона пары: определяет направление в nonce
+	lastRecv  atomic.Int64 // время последнего пакета от другого клиента (UnixNano, 0 - не было)
+}
+
+// AddPeer добавляет клиентскому транспорту сессию sessionID с другим клиентом. Пакеты сессии
+// шифруются crypto и отправляются через сокет транспорта: адрес этого сокета сервер сообщил
+// другому клиенту, и через NAT проходят пакеты именно с него. initiator у сторон пары
+// различается, иначе у пакетов в обе стороны совпало бы направление в nonce
+func (t *UDPTransport) AddPeer(sessionID uint64, crypto Crypto, initiator bool) {
+	t.peers.Store(sessionID, &peerSession{
+		state:     newSessionState(),
+		crypto:    crypto,
+		initiator: initiator,
+	})
+}
+
+// RemovePeer удаляет сессию с другим клиентом
+func (t *UDPTransport) RemovePeer(sessionID uint64) {
+	t.peers.Delete(sessionID)
+}
+
+// PeerLastReceive возвращает время последнего аутентифицированного пакета от другого клиента
+// (нулевое, если пакетов не было или сессии нет)
+func (t *UDPTransport) PeerLastReceive(sessionID uint64) time.Time {
+	p := t.peer(sessionID)
+	if p == nil || p.lastRecv.Load() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, p.lastRecv.Load())
+}
+
+// peer возвращает сессию с другим клиентом (nil - это сессия с сервером)
+func (t *UDPTransport) peer(sessionID uint64) *peerSession {
+	if t.server {
+		return nil
+	}
+	p, _ := t.peers.Load(sessionID)
+	session, _ := p.(*peerSession)
+	return session
+}
+
+// cryptoFor возвращает ключ сессии
+func (t *UDPTransport) cryptoFor(sessionID uint64) Crypto {
+	if p := t.peer(sessionID); p != nil {
+		return p.crypto
+	}
+	return t.crypto
+}
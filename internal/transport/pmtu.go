@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"myvpn/internal"
+)
+
+const (
+	// ProbeTimeout время ожидания ответа на PMTU пробу
+	ProbeTimeout = time.Second
+	// ProbeAttempts число попыток для каждого размера пробы (проба или ответ могут просто потеряться)
+	ProbeAttempts = 2
+	// DatagramOverhead накладные расходы транспорта на пакет: заголовок + флаг сжатия + tag
+	DatagramOverhead = HeaderSize + CompressionFlagSize + internal.Overhead
+
+	// Границы поиска размера датаграммы (UDP payload) для IPv4 и IPv6.
+	// Снизу - минимальный MTU, который обязан пропускать любой путь (576 и 1280 байт),
+	// сверху - MTU Ethernet 1500 без IP и UDP заголовков
+	minProbeSize4 = 576 - 20 - 8
+	maxProbeSize4 = 1500 - 20 - 8
+	minProbeSize6 = 1280 - 40 - 8
+	maxProbeSize6 = 1500 - 40 - 8
+)
+
+// probeAck ответ на PMTU пробу: sequence пробы и размер, который дошел до сервера
+type probeAck struct {
+	seq  uint64
+	size int
+}
+
+// ErrNoProbeReply сервер не ответил даже на пробу минимального размера
+// (старая версия сервера или потеря связи)
+var ErrNoProbeReply = errors.New("no reply to path MTU probes")
+
+// DiscoverPathMTU ищет двоичным поиском наибольший размер датаграммы, который доходит
+// до сервера без фрагментации. Пробы отправляются с DF, сервер отвечает на каждую дошедшую.
+// Ответы приходят через Read, поэтому кто-то должен читать транспорт.
+// Возвращает размер UDP payload
+func (t *UDPTransport) DiscoverPathMTU() (int, error) {
+	if t.isSocks5 {
+		return 0, errors.New("path MTU discovery is not supported via SOCKS5")
+	}
+	if t.remoteAddr == nil {
+		return 0, fmt.Errorf("remote address not set")
+	}
+	if err := t.setDontFragment(); err != nil {
+		return 0, fmt.Errorf("failed to set DF: %w", err)
+	}
+
+	lo, hi := minProbeSize4, maxProbeSize4
+	if t.remoteAddr.IP.To4() == nil {
+		lo, hi = minProbeSize6, maxProbeSize6
+	}
+
+	if !t.probeSize(lo) {
+		return 0, ErrNoProbeReply
+	}
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if t.probeSize(mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, nil
+}
+
+// SetPathMTU ограничивает размер отправляемых пакетов найденным размером датаграммы
+func (t *UDPTransport) SetPathMTU(size int) {
+	t.maxData.Store(int64(size - DatagramOverhead))
+}
+
+// PathMTU возвращает размер датаграммы, заданный SetPathMTU (0 - не задан)
+func (t *UDPTransport) PathMTU() int {
+	if n := t.maxData.Load(); n > 0 {
+		return int(n) + DatagramOverhead
+	}
+	return 0
+}
+
+// maxPayload возвращает наибольший размер данных для одного пакета сессии
+// (с FEC - за вычетом места под заголовки избыточных пакетов)
+func (t *UDPTransport) maxPayload(sessionID uint64) int {
+	max := MaxPacketSize
+	if n := t.maxData.Load(); n > 0 && n < MaxPacketSize {
+		max = int(n)
+	}
+	if state := t.session(sessionID, false); state != nil {
+		if enc := state.fecOut.Load(); enc != nil {
+			max -= enc.fec.Overhead()
+		}
+	}
+	return max
+}
+
+// probeSize отправляет пробы размером size, пока одна из них не подтвердится
+func (t *UDPTransport) probeSize(size int) bool {
+	for i := 0; i < ProbeAttempts; i++ {
+		ok, err := t.probe(size)
+		if err != nil {
+			// EMSGSIZE: ядро уже знает, что путь уже, пробу не отправить
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// probe отправляет одну пробу и ждет ответа не дольше ProbeTimeout
+func (t *UDPTransport) probe(size int) (bool, error) {
+	seq := t.own.reserve(1)
+	packet, err := t.seal(nil, PacketTypeProbe, make([]byte, size-DatagramOverhead), 0, t.sessionID, seq)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := t.writeRaw(packet, t.remoteAddr); err != nil {
+		return false, err
+	}
+
+	timeout := time.NewTimer(ProbeTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-t.done:
+			return false, errors.New("transport closed")
+		case <-timeout.C:
+			return false, nil
+		case ack := <-t.probeAcks:
+			// Ответы на прошлые пробы, пришедшие после таймаута, пропускаем
+			if ack.seq == seq {
+				return ack.size == size, nil
+			}
+		}
+	}
+}
+
+// deliverProbeAck передает ответ на пробу с sequence seq ожидающему probe
+func (t *UDPTransport) deliverProbeAck(seq uint64, size int) {
+	select {
+	case t.probeAcks <- probeAck{seq: seq, size: size}:
+	default:
+	}
+}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDontFragment включает DF для всех пакетов сокета (IP_PMTUDISC_PROBE: DF ставится,
+// но закэшированный ядром PMTU не ограничивает отправку, иначе пробы больше него не уйдут)
+func (t *UDPTransport) setDontFragment() error {
+	rawConn, err := t.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var err4, err6 error
+	err = rawConn.Control(func(fd uintptr) {
+		// Для dual-stack сокета нужны обе опции: IPv4 действует на адреса вида ::ffff:a.b.c.d
+		err4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		err6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE)
+	})
+	if err != nil {
+		return err
+	}
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transport
+
+import "errors"
+
+// setDontFragment: управление DF для поиска PMTU реализовано только для Linux
+func (t *UDPTransport) setDontFragment() error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"myvpn/internal/compress"
+)
+
+const (
+	// rttWindow число последних keepalive, по которым считаются потери
+	rttWindow = 32
+	// rttLossTimeout keepalive без ответа дольше этого времени считается потерянным
+	rttLossTimeout = 5 * time.Second
+)
+
+// RTTStats задержка и потери сессии, измеренные по ответам на keepalive
+type RTTStats struct {
+	// RTT сглаженное время кругового пути (SRTT, RFC 6298)
+	RTT time.Duration
+	// Jitter среднее отклонение RTT от сглаженного (RTTVAR, RFC 6298)
+	Jitter time.Duration
+	// Loss доля keepalive без ответа среди последних rttWindow
+	Loss float64
+	// Samples число принятых ответов. 0 - измерений еще нет
+	Samples uint64
+}
+
+// rttProbe отправленный keepalive
+type rttProbe struct {
+	seq   uint64
+	sent  time.Time
+	acked bool
+}
+
+// rttTracker сопоставляет ответы на keepalive с отправленными keepalive по sequence
+type rttTracker struct {
+	mu      sync.Mutex
+	probes  [rttWindow]rttProbe
+	next    int
+	srtt    time.Duration
+	rttvar  time.Duration
+	samples uint64
+}
+
+// sent запоминает keepalive с sequence seq
+func (r *rttTracker) sent(seq uint64, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.probes[r.next%rttWindow] = rttProbe{seq: seq, sent: now}
+	r.next++
+}
+
+// acked учитывает ответ на keepalive с sequence seq. Ответы на неизвестные
+// и уже подтвержденные keepalive пропускаются
+func (r *rttTracker) acked(seq uint64, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.probes {
+		p := &r.probes[i]
+		if p.seq != seq || p.sent.IsZero() || p.acked {
+			continue
+		}
+		p.acked = true
+		sample := now.Sub(p.sent)
+		if r.samples == 0 {
+			r.srtt, r.rttvar = sample, sample/2
+		} else {
+			r.rttvar = (3*r.rttvar + (r.srtt - sample).Abs()) / 4
+			r.srtt = (7*r.srtt + sample) / 8
+		}
+		r.samples++
+		return
+	}
+}
+
+// stats возвращает текущие значения. Keepalive, ответ на которые еще может прийти,
+// в потерях не учитываются
+func (r *rttTracker) stats(now time.Time) RTTStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sent, lost int
+	for _, p := range r.probes {
+		switch {
+		case p.sent.IsZero():
+		case p.acked:
+			sent++
+		case now.Sub(p.sent) >= rttLossTimeout:
+			sent++
+			lost++
+		}
+	}
+	st := RTTStats{RTT: r.srtt, Jitter: r.rttvar, Samples: r.samples}
+	if sent > 0 {
+		st.Loss = float64(lost) / float64(sent)
+	}
+	return st
+}
+
+// sendKeepalive отправляет keepalive и запоминает его sequence для измерения RTT
+func (t *UDPTransport) sendKeepalive(addr *net.UDPAddr, sessionID uint64) error {
+	state := t.session(sessionID, true)
+	seq := state.reserve(1)
+	packet, err := t.sealPacketSeq(nil, PacketTypeKeepalive, nil, compress.CodecNone, sessionID, seq)
+	if err != nil {
+		return err
+	}
+	state.rtt.sent(seq, time.Now())
+	_, err = t.writeRaw(packet, addr)
+	return err
+}
+
+// handleKeepaliveAck учитывает ответ на keepalive. Тело ответа - sequence keepalive;
+// старые версии отвечают без тела, такие ответы для RTT не используются
+func (t *UDPTransport) handleKeepaliveAck(body []byte, sessionID uint64) error {
+	if len(body) == 0 {
+		return nil
+	}
+	if len(body) != 8 {
+		return fmt.Errorf("invalid keepalive ack size: %d", len(body))
+	}
+	if state := t.session(sessionID, false); state != nil {
+		state.rtt.acked(binary.BigEndian.Uint64(body), time.Now())
+	}
+	return nil
+}
+
+// WriteKeepalive отправляет keepalive клиенту серверного транспорта, чтобы измерить
+// RTT и потери сессии. Клиент должен поддерживать эхо sequence в ответе (internal.CapRTT)
+func (t *UDPTransport) WriteKeepalive(addr *net.UDPAddr, sessionID uint64) error {
+	if addr == nil {
+		return fmt.Errorf("remote address not set")
+	}
+	return t.sendKeepalive(addr, sessionID)
+}
+
+// RTT возвращает задержку и потери сессии sessionID. Клиентский транспорт обслуживает
+// одну сессию и sessionID не использует
+func (t *UDPTransport) RTT(sessionID uint64) (RTTStats, bool) {
+	state := t.session(sessionID, false)
+	if state == nil {
+		return RTTStats{}, false
+	}
+	return state.rtt.stats(time.Now()), true
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// sessionState состояние одной сессии: счетчик отправленных пакетов и anti-replay окно
+// для принятых. У каждой сессии свое пространство sequence number, поэтому клиенты
+// серверного транспорта не мешают друг другу
+type sessionState struct {
+	sequence atomic.Uint64 // следующее значение счетчика (sequence в заголовке)
+	replay   *AntiReplayWindow
+	fecOut   atomic.Pointer[fecEncoder]  // FEC для отправляемых пакетов (nil - выключен)
+	fecIn    atomic.Pointer[fecDecoder]  // восстановление принятых пакетов (после первого избыточного)
+	rtt      rttTracker                  // RTT и потери по ответам на keepalive
+	via      atomic.Pointer[batchReader] // сокет последнего пакета сессии, если адресов несколько (AddListener)
+}
+
+// PacketSessionID возвращает session ID из заголовка датаграммы без расшифровки.
+// Session ID передается открыто (он входит в AAD, поэтому подменить его нельзя) и не связан
+// с адресом клиента, так что балансировщик нагрузки перед несколькими серверами может
+// направлять пакеты сессии на один сервер, даже когда у клиента меняется IP:port
+func PacketSessionID(datagram []byte) (uint64, bool) {
+	if len(datagram) < HeaderSize {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(datagram[sessionOffset:]), true
+}
+
+// newSessionState создает состояние сессии со счетчиком, начинающимся с initialSequence
+func newSessionState() *sessionState {
+	s := &sessionState{replay: NewAntiReplayWindow(0)}
+	s.sequence.Store(initialSequence())
+	return s
+}
+
+// reserve выделяет n последовательных значений счетчика и возвращает первое.
+// Счетчик атомарный: горутины, отправляющие пакеты одной сессии, не ждут друг друга
+func (s *sessionState) reserve(n int) uint64 {
+	return s.sequence.Add(uint64(n)) - uint64(n)
+}
+
+// session возвращает состояние сессии. Клиентский транспорт обслуживает сессию с сервером
+// и сессии с другими клиентами (AddPeer), серверный ведет таблицу по session ID.
+// При create = false для неизвестной сессии возвращается nil: состояние заводится
+// только для проверенных пакетов и отправки.
+// Поиск не берет блокировок, sessionsMu нужен только для создания и удаления
+func (t *UDPTransport) session(sessionID uint64, create bool) *sessionState {
+	if !t.server {
+		if p := t.peer(sessionID); p != nil {
+			return p.state
+		}
+		return t.own
+	}
+
+	if state, ok := t.sessions.Load(sessionID); ok || !create {
+		state, _ := state.(*sessionState)
+		return state
+	}
+
+	t.sessionsMu.Lock()
+	defer t.sessionsMu.Unlock()
+	if state, ok := t.sessions.Load(sessionID); ok {
+		return state.(*sessionState)
+	}
+	state := newSessionState()
+	if seq, ok := t.forgotten[sessionID]; ok && seq > state.sequence.Load() {
+		state.sequence.Store(seq)
+	}
+	delete(t.forgotten, sessionID)
+	t.sessions.Store(sessionID, state)
+	return state
+}
+
+// allSessions возвращает состояния всех сессий транспорта
+func (t *UDPTransport) allSessions() []*sessionState {
+	if !t.server {
+		return []*sessionState{t.own}
+	}
+	var states []*sessionState
+	t.sessions.Range(func(_, state any) bool {
+		states = append(states, state.(*sessionState))
+		return true
+	})
+	return states
+}
+
+// ForgetSession удаляет счетчик и anti-replay окно сессии серверного транспорта.
+// Вызывается, когда сервер удаляет клиента
+func (t *UDPTransport) ForgetSession(sessionID uint64) {
+	t.sessionsMu.Lock()
+	defer t.sessionsMu.Unlock()
+
+	value, ok := t.sessions.LoadAndDelete(sessionID)
+	if !ok {
+		return
+	}
+	state := value.(*sessionState)
+
+	// Счетчик сессии, которая вернется с тем же ID, начнется с initialSequence().
+	// Если старый счетчик еще не отстал от него (сессия удалена в ту же секунду), запоминаем его,
+	// чтобы nonce не повторились. Устаревшие записи больше не нужны
+	floor := initialSequence()
+	for id, seq := range t.forgotten {
+		if seq < floor {
+			delete(t.forgotten, id)
+		}
+	}
+	if seq := state.sequence.Load(); seq >= floor {
+		t.forgotten[sessionID] = seq
+	}
+}
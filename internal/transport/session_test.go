@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"sync"
+	"testing"
+
+	"myvpn/internal/compress"
+)
+
+// mutexSessions таблица сессий на мьютексах, как до перехода на sync.Map и атомарный
+// счетчик: с ней сравнивается reserveSequence в BenchmarkReserveSequence
+type mutexSessions struct {
+	mu       sync.RWMutex
+	sessions map[uint64]*mutexSession
+}
+
+type mutexSession struct {
+	mu       sync.Mutex
+	sequence uint64
+}
+
+func (m *mutexSessions) reserve(sessionID uint64, n int) uint64 {
+	m.mu.RLock()
+	s := m.sessions[sessionID]
+	m.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := s.sequence
+	s.sequence += uint64(n)
+	return seq
+}
+
+// BenchmarkReserveSequence выделяет sequence одной сессии из параллельных горутин
+func BenchmarkReserveSequence(b *testing.B) {
+	b.Run("atomic", func(b *testing.B) {
+		_, server := newBenchPair(b)
+		server.reserveSequence(1, 1)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				server.reserveSequence(1, 1)
+			}
+		})
+	})
+	b.Run("mutex", func(b *testing.B) {
+		m := &mutexSessions{sessions: map[uint64]*mutexSession{1: {}}}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				m.reserve(1, 1)
+			}
+		})
+	})
+}
+
+// BenchmarkUDPTransportWriteParallel отправляет пакеты одной сессии из параллельных горутин
+func BenchmarkUDPTransportWriteParallel(b *testing.B) {
+	client, _ := newBenchPair(b)
+	b.ReportAllocs()
+	b.SetBytes(benchPacketSize)
+	b.RunParallel(func(pb *testing.PB) {
+		packet := make([]byte, benchPacketSize)
+		for pb.Next() {
+			if _, err := client.Write(packet, compress.CodecNone); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// TestReserveSequenceParallel проверяет, что параллельные горутины получают разные sequence
+func TestReserveSequenceParallel(t *testing.T) {
+	_, server := newBenchPair(t)
+	const goroutines, perGoroutine = 8, 1000
+	seqs := make(chan uint64, goroutines*perGoroutine)
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perGoroutine {
+				seqs <- server.reserveSequence(1, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(seqs)
+	seen := make(map[uint64]bool, goroutines*perGoroutine)
+	for seq := range seqs {
+		if seen[seq] {
+			t.Fatalf("sequence %d reserved twice", seq)
+		}
+		seen[seq] = true
+	}
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// listenUDP открывает UDP сокет с SO_REUSEPORT. Опция должна быть установлена до bind:
+// только тогда на тот же адрес можно привязать еще сокеты (SetShards)
+func listenUDP(local *net.UDPAddr) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sockErr != nil {
+					return
+				}
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp", local.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// AddListener открывает серверному транспорту сокет на еще одном адресе (другой порт или
+// семейство адресов). Таблица сессий общая: сессия может перейти на другой адрес, как при
+// роуминге, а пакеты к клиенту уходят через сокет, на который пришел его последний пакет.
+// Вызывается до SetShards и начала обмена пакетами
+func (t *UDPTransport) AddListener(addr string) error {
+	if !t.server {
+		return fmt.Errorf("listen addresses are only supported by the server transport")
+	}
+	local, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve listen address %s: %w", addr, err)
+	}
+	conn, err := listenUDP(local)
+	if err != nil {
+		return fmt.Errorf("failed to listen UDP on %s: %w", addr, err)
+	}
+	if err := setUDPOptions(conn); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to set UDP options: %w", err)
+	}
+	t.readers = append(t.readers, &batchReader{conn: conn, batch: newBatchConn(conn)})
+	t.listeners++
+	return nil
+}
+
+// SetShards открывает на каждом адресе транспорта еще сокеты с SO_REUSEPORT, всего n на адрес.
+// Ядро раздает датаграммы между сокетами адреса, а BPF программа выбирает сокет по session ID
+// из заголовка, поэтому все пакеты сессии приходят в один сокет, даже если адрес клиента
+// меняется. Каждый сокет читается своим ReadShard. Вызывается до начала обмена пакетами
+func (t *UDPTransport) SetShards(n int) error {
+	if n <= 1 || len(t.readers) > t.listeners {
+		return nil
+	}
+	for _, root := range t.readers[:t.listeners] {
+		local := root.conn.LocalAddr().(*net.UDPAddr)
+		for i := 1; i < n; i++ {
+			conn, err := listenUDP(local)
+			if err != nil {
+				return fmt.Errorf("failed to open listener shard: %w", err)
+			}
+			if err := setUDPOptions(conn); err != nil {
+				conn.Close()
+				return fmt.Errorf("failed to set UDP options: %w", err)
+			}
+			t.readers = append(t.readers, &batchReader{conn: conn, batch: newBatchConn(conn)})
+		}
+		if err := attachShardFilter(root.conn, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shards возвращает число сокетов транспорта на всех адресах (см. SetShards, AddListener)
+func (t *UDPTransport) Shards() int {
+	return len(t.readers)
+}
+
+// sender возвращает сокет для пакетов сессии: тот, на который пришел ее последний пакет,
+// если у транспорта несколько адресов, иначе основной
+func (t *UDPTransport) sender(sessionID uint64) *batchReader {
+	if t.listeners > 1 {
+		if state := t.session(sessionID, false); state != nil {
+			if r := state.via.Load(); r != nil {
+				return r
+			}
+		}
+	}
+	return t.readers[0]
+}
+
+// shardFilter BPF программа SO_ATTACH_REUSEPORT_CBPF: номер сокета - младшие 32 бита
+// session ID по модулю n. Пакет с MAC cookie (PacketTypeCookie) сдвинут на размер cookie.
+// Для пакета короче заголовка программа возвращает 0 (основной сокет)
+func shardFilter(n int) ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: PacketTypeCookie, SkipFalse: 2},
+		bpf.LoadAbsolute{Off: 1 + cookieSize + sessionOffset + 4, Size: 4},
+		bpf.Jump{Skip: 1},
+		bpf.LoadAbsolute{Off: sessionOffset + 4, Size: 4},
+		bpf.ALUOpConstant{Op: bpf.ALUOpMod, Val: uint32(n)},
+		bpf.RetA{},
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+// attachShardFilter: программы BPF для групп SO_REUSEPORT есть только в Linux
+func attachShardFilter(conn *net.UDPConn, n int) error {
+	return errors.ErrUnsupported
+}
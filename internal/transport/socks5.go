@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"myvpn/internal/logging"
+	"myvpn/internal/socks5"
+)
+
+const (
+	// socks5Timeout время на подключение к прокси и UDP ASSOCIATE
+	socks5Timeout = 10 * time.Second
+	// socks5KeepAliveIdle, socks5KeepAliveInterval и socks5KeepAliveCount - TCP keepalive
+	// управляющего соединения: без него NAT между клиентом и прокси забывает простаивающее
+	// соединение, а прокси с ним закрывает и UDP relay
+	socks5KeepAliveIdle     = 15 * time.Second
+	socks5KeepAliveInterval = 5 * time.Second
+	socks5KeepAliveCount    = 3
+	// socks5RetryMax предельная пауза между попытками заново выполнить UDP ASSOCIATE
+	socks5RetryMax = 30 * time.Second
+)
+
+// socks5Addr кодирует адрес в формате SOCKS5: ATYP + ADDR + PORT
+func socks5Addr(addr *net.UDPAddr) []byte {
+	return socks5.AddrFromAddrPort(addr.AddrPort()).Append(nil)
+}
+
+// socks5HeaderLen возвращает длину SOCKS5 заголовка UDP датаграммы (RSV, FRAG, адрес)
+func socks5HeaderLen(buf []byte) (int, error) {
+	if len(buf) < 5 {
+		return 0, fmt.Errorf("truncated SOCKS5 UDP packet")
+	}
+	if buf[2] != 0 {
+		// Фрагментацию UDP relay (RFC 1928, раздел 7) клиент не поддерживает
+		return 0, fmt.Errorf("fragmented SOCKS5 UDP packet")
+	}
+	var offset int
+	switch buf[3] {
+	case socks5.AtypIPv4:
+		offset = 4 + net.IPv4len + 2
+	case socks5.AtypIPv6:
+		offset = 4 + net.IPv6len + 2
+	case socks5.AtypDomain:
+		offset = 5 + int(buf[4]) + 2
+	default:
+		return 0, fmt.Errorf("unsupported SOCKS5 atyp: %d", buf[3])
+	}
+	if len(buf) < offset {
+		return 0, fmt.Errorf("truncated SOCKS5 UDP payload")
+	}
+	return offset, nil
+}
+
+// readSocks5Reply читает ответ SOCKS5 сервера на команду и возвращает BND.ADDR:BND.PORT.
+// Адрес может быть IPv4, IPv6 или именем: имя разрешается в адрес
+func readSocks5Reply(conn net.Conn) (*net.UDPAddr, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != socks5.Version {
+		return nil, fmt.Errorf("unexpected SOCKS version %d in reply", header[0])
+	}
+	if header[1] != socks5.ReplySucceeded {
+		return nil, fmt.Errorf("socks5 request rejected with code %d", header[1])
+	}
+	bind, err := socks5.ReadAddr(conn)
+	if err != nil {
+		return nil, err
+	}
+	if bind.Host == "" {
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(bind.IP, bind.Port)), nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", bind.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve SOCKS5 bind address: %w", err)
+	}
+	return addr, nil
+}
+
+// setupSocks5 выполняет SOCKS5 handshake и UDP Associate через прокси и следит
+// за управляющим соединением: прокси закрывает UDP relay вместе с ним
+func (t *UDPTransport) setupSocks5(socks5Proxy string) error {
+	t.isSocks5 = true
+	t.socks5Proxy = socks5Proxy
+	t.socks5Remote = t.remoteAddr
+	// RSV(2) + FRAG(1) + адрес назначения
+	t.socks5Header = append([]byte{0x00, 0x00, 0x00}, socks5Addr(t.remoteAddr)...)
+
+	conn, relay, err := socks5Associate(socks5Proxy)
+	if err != nil {
+		return err
+	}
+	t.socks5Mu.Lock()
+	t.socks5Conn = conn
+	t.socks5Mu.Unlock()
+	t.socks5UDP.Store(relay)
+
+	t.wg.Add(1)
+	go t.socks5Loop(conn)
+	return nil
+}
+
+// socks5Associate подключается к прокси и запрашивает UDP ASSOCIATE. Возвращает
+// управляющее соединение и адрес UDP relay
+func socks5Associate(socks5Proxy string) (net.Conn, *net.UDPAddr, error) {
+	// 1. Подключаемся к SOCKS5 по TCP
+	dialer := &net.Dialer{
+		Timeout: socks5Timeout,
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     socks5KeepAliveIdle,
+			Interval: socks5KeepAliveInterval,
+			Count:    socks5KeepAliveCount,
+		},
+	}
+	conn, err := dialer.Dial("tcp", socks5Proxy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("socks5 proxy dial failed: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(socks5Timeout))
+
+	// 2. Отправляем SOCKS5 Handshake (Version 5, 1 Method: No Auth)
+	if _, err := conn.Write([]byte{socks5.Version, 0x01, socks5.MethodNoAuth}); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("socks5 handshake failed: %w", err)
+	}
+	response := make([]byte, 2)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("socks5 auth negotiation failed: %w", err)
+	}
+	if response[0] != socks5.Version || response[1] != socks5.MethodNoAuth {
+		conn.Close()
+		return nil, nil, fmt.Errorf("socks5 auth negotiation failed: version %d, method %#x", response[0], response[1])
+	}
+
+	// 3. Отправляем запрос UDP Associate
+	// cmd=0x03 (UDP Associate), rsv=0x00, atyp=0x01 (IPv4), dst.addr=0.0.0.0, dst.port=0
+	udpAssocReq := []byte{socks5.Version, socks5.CmdUDPAssociate, 0x00, socks5.AtypIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := conn.Write(udpAssocReq); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("socks5 UDP associate request failed: %w", err)
+	}
+
+	// 4. Читаем ответ (где Xray открыл UDP порт для нас: IPv4, IPv6 или имя)
+	relay, err := readSocks5Reply(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("socks5 UDP associate rejected: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	// Если Xray вернул 0.0.0.0 или ::, шлем на тот же IP, что и TCP прокси
+	if relay.IP.IsUnspecified() {
+		relay.IP = conn.RemoteAddr().(*net.TCPAddr).IP
+	}
+	return conn, relay, nil
+}
+
+// socks5Loop ждет разрыва управляющего соединения и заново выполняет UDP ASSOCIATE,
+// пока транспорт не закрыт. Пока relay нет, датаграммы теряются, а сессию
+// восстанавливают keepalive и переподключение клиента
+func (t *UDPTransport) socks5Loop(conn net.Conn) {
+	defer t.wg.Done()
+	for {
+		// По управляющему соединению после ответа данные не идут: чтение ждет его закрытия
+		_, err := io.Copy(io.Discard, conn)
+		if t.Closed() {
+			return
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		logger.Warn("SOCKS5 control connection lost, re-associating", "proxy", t.socks5Proxy, logging.Err(err))
+		conn.Close()
+
+		if conn = t.socks5Reassociate(); conn == nil {
+			return
+		}
+	}
+}
+
+// socks5Reassociate повторяет UDP ASSOCIATE с растущей паузой. nil - транспорт закрыт
+func (t *UDPTransport) socks5Reassociate() net.Conn {
+	delay := time.Second
+	for {
+		conn, relay, err := socks5Associate(t.socks5Proxy)
+		if err == nil {
+			t.socks5Mu.Lock()
+			if t.Closed() {
+				t.socks5Mu.Unlock()
+				conn.Close()
+				return nil
+			}
+			t.socks5Conn = conn
+			t.socks5Mu.Unlock()
+			t.socks5UDP.Store(relay)
+			logger.Info("SOCKS5 UDP associate restored", "proxy", t.socks5Proxy, "relay", relay)
+			return conn
+		}
+		logger.Warn("SOCKS5 re-associate failed", "proxy", t.socks5Proxy, "retry", delay, logging.Err(err))
+		select {
+		case <-t.done:
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, socks5RetryMax)
+	}
+}
+
+// closeSocks5 закрывает управляющее соединение SOCKS5
+func (t *UDPTransport) closeSocks5() {
+	t.socks5Mu.Lock()
+	defer t.socks5Mu.Unlock()
+	if t.socks5Conn != nil {
+		t.socks5Conn.Close()
+	}
+}
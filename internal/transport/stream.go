@@ -0,0 +1,533 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"myvpn/internal/logging"
+)
+
+// logger журнал транспорта
+var logger = logging.For(logging.Transport)
+
+// Виды транспорта клиента. TCP и WSS - запасные варианты для сетей, где UDP заблокирован:
+// датаграммы протокола передаются через потоковое соединение без изменений.
+// KCP - надежный поток поверх UDP для каналов с большими потерями (мобильная сеть, спутник):
+// потери восстанавливаются FEC и быстрыми повторами, а не откатом окна, как у TCP.
+// gRPC - датаграммы в сообщениях двунаправленного потока HTTP/2: проходит через CDN
+// и L7 балансировщики, которые пропускают только HTTP.
+// DNS - датаграммы в запросах и ответах DNS через резолвер сети: последний вариант
+// для сетей, откуда выходит только DNS. Скорость - единицы-десятки КБ/с
+const (
+	KindUDP  = "udp"
+	KindTCP  = "tcp"
+	KindWSS  = "wss"
+	KindKCP  = "kcp"
+	KindGRPC = "grpc"
+	KindDNS  = "dns"
+)
+
+const (
+	// StreamIdleTimeout закрывает потоковое соединение, по которому долго нет пакетов
+	// (клиент ушел, не закрыв соединение). Клиент шлет keepalive чаще
+	StreamIdleTimeout = 5 * time.Minute
+
+	// streamLenSize длина датаграммы перед ней в TCP потоке
+	streamLenSize = 2
+	// maxStreamPacket максимальный размер датаграммы в потоке
+	maxStreamPacket = 65535
+)
+
+// ParseKinds разбирает список видов транспорта через запятую (udp, tcp, wss, kcp, grpc, dns)
+func ParseKinds(list string) ([]string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(list, ",") {
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case "":
+			continue
+		case KindUDP, KindTCP, KindWSS, KindKCP, KindGRPC, KindDNS:
+			kinds = append(kinds, kind)
+		default:
+			return nil, fmt.Errorf("unknown transport %q (expected udp, tcp, wss, kcp, grpc or dns)", kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return nil, errors.New("no transports given")
+	}
+	return kinds, nil
+}
+
+// packetStream поток датаграмм поверх TCP, WebSocket, KCP, gRPC или DNS
+type packetStream interface {
+	ReadPacket() ([]byte, error)
+	WritePacket(p []byte) error
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// tcpStream датаграммы в TCP с 2-байтовой длиной перед каждой
+type tcpStream struct {
+	conn net.Conn
+}
+
+func (s *tcpStream) ReadPacket() ([]byte, error) {
+	var size [streamLenSize]byte
+	if _, err := io.ReadFull(s.conn, size[:]); err != nil {
+		return nil, err
+	}
+	p := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(s.conn, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *tcpStream) WritePacket(p []byte) error {
+	if len(p) > maxStreamPacket {
+		return fmt.Errorf("packet too large for stream: %d bytes", len(p))
+	}
+	frame := make([]byte, streamLenSize+len(p))
+	binary.BigEndian.PutUint16(frame, uint16(len(p)))
+	copy(frame[streamLenSize:], p)
+	_, err := s.conn.Write(frame)
+	return err
+}
+
+func (s *tcpStream) SetReadDeadline(t time.Time) error { return s.conn.SetReadDeadline(t) }
+func (s *tcpStream) Close() error                      { return s.conn.Close() }
+
+// wsStream датаграммы в бинарных сообщениях WebSocket, по одной на сообщение
+type wsStream struct {
+	conn *websocket.Conn
+}
+
+func (s *wsStream) ReadPacket() ([]byte, error) {
+	var p []byte
+	if err := websocket.Message.Receive(s.conn, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *wsStream) WritePacket(p []byte) error        { return websocket.Message.Send(s.conn, p) }
+func (s *wsStream) SetReadDeadline(t time.Time) error { return s.conn.SetReadDeadline(t) }
+func (s *wsStream) Close() error                      { return s.conn.Close() }
+
+// StreamOptions параметры подключения клиента через TCP, WSS, KCP, gRPC или DNS
+type StreamOptions struct {
+	// TCPAddr адрес TCP порта сервера (host:port)
+	TCPAddr string
+	// TCPTLS подключаться к TCP порту через TLS
+	TCPTLS bool
+	// WSSURL адрес WebSocket сервера (wss://host/path)
+	WSSURL string
+	// TLS настройки TLS для WSS и TCP с TLS, в т.ч. сертификат клиента и SNI
+	// (nil - проверка сертификата сервера по системным корням)
+	TLS *tls.Config
+	// TLSFingerprint ClientHello браузера, который повторяют WSS, gRPC и TCP с TLS: chrome,
+	// firefox, safari, edge, ios или go - crypto/tls (пусто - DefaultTLSFingerprint)
+	TLSFingerprint string
+	// KCPAddr UDP адрес KCP порта сервера (host:port)
+	KCPAddr string
+	// KCPFEC параметры FEC для KCP, должны совпадать с серверными
+	KCPFEC FEC
+	// GRPCAddr адрес gRPC сервера (host:port), соединение всегда через TLS
+	GRPCAddr string
+	// GRPCService имя сервиса gRPC, должно совпадать с серверным (пустая строка - DefaultGRPCService)
+	GRPCService string
+	// DNSZone зона, для которой сервер - авторитетный DNS сервер (например, t.example.com)
+	DNSZone string
+	// DNSResolver адрес резолвера (host:port), через который идут запросы DNS туннеля
+	DNSResolver string
+	// Timeout время на установку соединения
+	Timeout time.Duration
+	// Mark метка fwmark сокета соединения с сервером (0 - без метки)
+	Mark uint32
+	// Protect вызывается с дескриптором сокета соединения до подключения (nil - не вызывается)
+	Protect func(fd int) error
+	// HTTPProxy HTTP прокси, через который TCP, WSS и gRPC подключаются методом CONNECT
+	// (nil - напрямую). KCP идет по UDP и прокси не использует
+	HTTPProxy *url.URL
+}
+
+// Endpoint адрес сервера, к которому подключается потоковый вид транспорта
+type Endpoint struct {
+	Network string // tcp или udp
+	Addr    string // host:port
+}
+
+// Endpoints возвращает адреса сервера, к которым подключаются потоковые виды транспорта из kinds.
+// С HTTP прокси TCP, WSS и gRPC подключаются к нему, а не к серверу
+func (o StreamOptions) Endpoints(kinds []string) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, kind := range kinds {
+		switch {
+		case o.HTTPProxy != nil && (kind == KindTCP || kind == KindWSS || kind == KindGRPC):
+			endpoints = append(endpoints, Endpoint{Network: "tcp", Addr: httpProxyAddr(o.HTTPProxy)})
+		case kind == KindTCP:
+			endpoints = append(endpoints, Endpoint{Network: "tcp", Addr: o.TCPAddr})
+		case kind == KindKCP:
+			endpoints = append(endpoints, Endpoint{Network: "udp", Addr: o.KCPAddr})
+		case kind == KindGRPC:
+			endpoints = append(endpoints, Endpoint{Network: "tcp", Addr: o.GRPCAddr})
+		case kind == KindDNS:
+			// Запросы уходят резолверу, а не серверу
+			endpoints = append(endpoints, Endpoint{Network: "udp", Addr: o.DNSResolver})
+		case kind == KindWSS:
+			u, err := url.Parse(o.WSSURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid WebSocket URL: %w", err)
+			}
+			port := u.Port()
+			if port == "" {
+				port = "443"
+			}
+			endpoints = append(endpoints, Endpoint{Network: "tcp", Addr: net.JoinHostPort(u.Hostname(), port)})
+		}
+	}
+	return endpoints, nil
+}
+
+// StreamRelay пересылает датаграммы UDP транспорта через потоковое соединение с сервером.
+// Транспорт отправляет пакеты на локальный UDP сокет релея (Addr), поэтому весь
+// протокол (шифрование, сессии, keepalive) работает без изменений
+type StreamRelay struct {
+	local  *net.UDPConn
+	stream packetStream
+	peer   atomic.Pointer[net.UDPAddr] // адрес сокета транспорта
+	done   chan struct{}
+	once   sync.Once
+}
+
+// DialStream подключается к серверу видом транспорта kind (tcp, wss, kcp, grpc или dns) и запускает релей
+func DialStream(kind string, opts StreamOptions) (*StreamRelay, error) {
+	var stream packetStream
+	switch kind {
+	case KindTCP:
+		conn, err := dialTCP(opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", opts.TCPAddr, err)
+		}
+		stream = &tcpStream{conn: conn}
+	case KindWSS:
+		conn, err := dialWebSocket(opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", opts.WSSURL, err)
+		}
+		stream = &wsStream{conn: conn}
+	case KindKCP:
+		var err error
+		if stream, err = dialKCP(opts.KCPAddr, opts.KCPFEC, opts.Mark, opts.Protect); err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", opts.KCPAddr, err)
+		}
+	case KindGRPC:
+		var err error
+		if stream, err = dialGRPC(opts); err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", opts.GRPCAddr, err)
+		}
+	case KindDNS:
+		var err error
+		if stream, err = dialDNS(opts); err != nil {
+			return nil, fmt.Errorf("failed to start DNS tunnel via %s: %w", opts.DNSResolver, err)
+		}
+	default:
+		return nil, fmt.Errorf("transport %q is not a stream", kind)
+	}
+
+	local, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("failed to create local relay socket: %w", err)
+	}
+
+	r := &StreamRelay{local: local, stream: stream, done: make(chan struct{})}
+	go r.toStream()
+	go r.fromStream()
+	return r, nil
+}
+
+// dialTCP устанавливает TCP соединение с сервером (напрямую или через HTTP прокси),
+// при TCPTLS - поверх TLS
+func dialTCP(opts StreamOptions) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if opts.HTTPProxy != nil {
+		conn, err = dialHTTPProxy(opts, opts.TCPAddr)
+	} else {
+		dialer := &net.Dialer{Timeout: opts.Timeout, Control: socketControl(opts.Mark, opts.Protect)}
+		if conn, err = dialer.Dial("tcp", opts.TCPAddr); err == nil {
+			conn.(*net.TCPConn).SetNoDelay(true)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !opts.TCPTLS {
+		return conn, nil
+	}
+
+	host, _, _ := net.SplitHostPort(opts.TCPAddr)
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+	tlsConn, err := opts.tlsHandshake(context.Background(), conn, opts.clientTLS(host))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// dialWebSocket устанавливает WebSocket соединение (напрямую или через HTTP прокси)
+// с таймаутом. TLS рукопожатие - tlsHandshake, с ClientHello браузера
+func dialWebSocket(opts StreamOptions) (*websocket.Conn, error) {
+	u, err := url.Parse(opts.WSSURL)
+	if err != nil {
+		return nil, err
+	}
+	origin := "https://" + u.Host
+	config, err := websocket.NewConfig(opts.WSSURL, origin)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	var conn net.Conn
+	if opts.HTTPProxy != nil {
+		conn, err = dialHTTPProxy(opts, addr)
+	} else {
+		dialer := &net.Dialer{Timeout: opts.Timeout, Control: socketControl(opts.Mark, opts.Protect)}
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+	if u.Scheme == "wss" {
+		tlsConn, err := opts.tlsHandshake(context.Background(), conn, opts.clientTLS(u.Hostname()))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+// clientTLS возвращает настройки TLS соединения с сервером host. SNI - TLS.ServerName, если
+// задано (например, имя разрешенного сайта), иначе host. ALPN http/1.1, как у браузера,
+// открывающего WebSocket: ClientHello без ALPN выдает клиент не браузера
+func (o StreamOptions) clientTLS(host string) *tls.Config {
+	config := &tls.Config{}
+	if o.TLS != nil {
+		config = o.TLS.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+	return config
+}
+
+// Addr возвращает адрес локального сокета, на который транспорт должен отправлять пакеты
+func (r *StreamRelay) Addr() *net.UDPAddr {
+	return r.local.LocalAddr().(*net.UDPAddr)
+}
+
+// toStream пересылает пакеты транспорта на сервер
+func (r *StreamRelay) toStream() {
+	defer r.Close()
+	buf := make([]byte, maxStreamPacket)
+	for {
+		n, from, err := r.local.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		r.peer.Store(from)
+		if err := r.stream.WritePacket(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// fromStream пересылает пакеты сервера транспорту
+func (r *StreamRelay) fromStream() {
+	defer r.Close()
+	for {
+		p, err := r.stream.ReadPacket()
+		if err != nil {
+			return
+		}
+		if peer := r.peer.Load(); peer != nil {
+			r.local.WriteToUDP(p, peer)
+		}
+	}
+}
+
+// Close закрывает соединение с сервером и локальный сокет
+func (r *StreamRelay) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+		r.stream.Close()
+		r.local.Close()
+	})
+	return nil
+}
+
+// SetRelay привязывает к транспорту потоковый релей: он закрывается вместе с транспортом
+func (t *UDPTransport) SetRelay(r *StreamRelay) {
+	t.relay = r
+}
+
+// RelayDone закрывается, когда соединение релея с сервером разорвано
+// (nil, если транспорт работает напрямую по UDP)
+func (t *UDPTransport) RelayDone() <-chan struct{} {
+	if t.relay == nil {
+		return nil
+	}
+	return t.relay.done
+}
+
+// ServeTCP принимает TCP соединения клиентов и пересылает их датаграммы на UDP адрес target
+// (сокет сервера). ln может быть TLS listener: тогда имена из сертификатов клиентов
+// записываются в ids (nil - не записываются). Соединениям, которые начинаются с HTTP
+// запроса (браузер, активное зондирование), отвечает fallback (nil - они закрываются
+// как поток с неверными датаграммами). Возвращает ошибку, когда listener закрыт
+func ServeTCP(ln net.Listener, target *net.UDPAddr, ids *StreamIdentities, fallback http.Handler) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		raw := conn
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			raw = tlsConn.NetConn()
+		}
+		if tcp, ok := raw.(*net.TCPConn); ok {
+			tcp.SetNoDelay(true)
+		}
+		go func() {
+			identity, err := handshakeTLS(conn)
+			if err != nil {
+				logger.Warn("Stream relay: TLS handshake failed", "remote", conn.RemoteAddr(), logging.Err(err))
+				conn.Close()
+				return
+			}
+			if fallback != nil {
+				sniffed, isHTTP, err := sniffHTTP(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				if isHTTP {
+					serveFallback(sniffed, fallback)
+					return
+				}
+				conn = sniffed
+			}
+			relayStream(&tcpStream{conn: conn}, target, identity, ids)
+		}()
+	}
+}
+
+// WebSocketHandler возвращает HTTP обработчик, который пересылает датаграммы
+// WebSocket клиентов на UDP адрес target. Имена из сертификатов клиентов
+// записываются в ids (nil - не записываются)
+func WebSocketHandler(target *net.UDPAddr, ids *StreamIdentities) http.Handler {
+	// websocket.Server без Handshake не требует заголовок Origin: клиент - не браузер
+	return websocket.Server{Handler: func(conn *websocket.Conn) {
+		conn.PayloadType = websocket.BinaryFrame
+		relayStream(&wsStream{conn: conn}, target, tlsIdentity(conn.Request().TLS), ids)
+	}}
+}
+
+// relayStream пересылает датаграммы между потоком клиента и UDP сокетом сервера.
+// Для каждого соединения заводится свой UDP сокет, поэтому сервер видит клиента
+// как отдельный адрес на loopback и отвечает ему как обычному UDP клиенту.
+// Если клиент предъявил сертификат, его имя записывается в ids по адресу сокета
+func relayStream(stream packetStream, target *net.UDPAddr, identity string, ids *StreamIdentities) {
+	defer stream.Close()
+
+	udp, err := net.DialUDP("udp", nil, target)
+	if err != nil {
+		logger.Error("Stream relay: failed to connect", "target", target, logging.Err(err))
+		return
+	}
+	defer udp.Close()
+	metricStreamConns.Inc()
+
+	if identity != "" && ids != nil {
+		key := ids.add(udp.LocalAddr(), identity)
+		defer ids.remove(key)
+	}
+
+	go func() {
+		defer stream.Close()
+		buf := make([]byte, maxStreamPacket)
+		for {
+			n, err := udp.Read(buf)
+			if err != nil {
+				return
+			}
+			if err := stream.WritePacket(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		stream.SetReadDeadline(time.Now().Add(StreamIdleTimeout))
+		p, err := stream.ReadPacket()
+		if err != nil {
+			return
+		}
+		if _, err := udp.Write(p); err != nil {
+			return
+		}
+	}
+}
+
+// StreamTarget возвращает адрес, на который релей сервера отправляет датаграммы
+// для UDP сокета, слушающего listenAddr (неуказанный адрес заменяется на loopback)
+func StreamTarget(listenAddr string) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		addr.IP = net.IPv4(127, 0, 0, 1)
+	}
+	return addr, nil
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// udpEcho запускает UDP сокет, который возвращает датаграммы отправителю, - сервер VPN
+// для релея потоков
+func udpEcho(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxStreamPacket)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// streamEcho отправляет датаграмму через релей и ждет ее обратно
+func streamEcho(t *testing.T, relay *StreamRelay) {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, relay.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	packet := []byte("datagram through the stream")
+	buf := make([]byte, maxStreamPacket)
+	// Первую датаграмму gRPC может не успеть отправить до установки потока: повторяем
+	for range 20 {
+		conn.Write(packet)
+		conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err == nil {
+			if !bytes.Equal(buf[:n], packet) {
+				t.Fatalf("echo %q, want %q", buf[:n], packet)
+			}
+			return
+		}
+	}
+	t.Fatal("no echo through the stream")
+}
+
+// TestDialStreamTLSFingerprint проверяет TLS транспорты с ClientHello браузера и crypto/tls
+// против серверов из этого пакета: ALPN http/1.1 для TCP и WSS, h2 для gRPC
+func TestDialStreamTLSFingerprint(t *testing.T) {
+	cert, roots := testCertificate(t)
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+	target := udpEcho(t)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go ServeTCP(ln, target, nil, nil)
+
+	wss := httptest.NewUnstartedServer(WebSocketHandler(target, nil))
+	wss.TLS = serverTLS.Clone()
+	wss.StartTLS()
+	t.Cleanup(wss.Close)
+
+	grpcLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcSrv := NewGRPCServer(serverTLS.Clone(), "", target, nil)
+	go grpcSrv.Serve(grpcLn)
+	t.Cleanup(grpcSrv.Stop)
+
+	for _, fingerprint := range []string{"chrome", "firefox", TLSFingerprintGo} {
+		for _, kind := range []string{KindTCP, KindWSS, KindGRPC} {
+			opts := StreamOptions{
+				TCPAddr:        ln.Addr().String(),
+				TCPTLS:         true,
+				WSSURL:         "wss://" + wss.Listener.Addr().String() + "/",
+				GRPCAddr:       grpcLn.Addr().String(),
+				TLS:            &tls.Config{ServerName: "vpn.test", RootCAs: roots},
+				TLSFingerprint: fingerprint,
+				Timeout:        5 * time.Second,
+			}
+			relay, err := DialStream(kind, opts)
+			if err != nil {
+				t.Fatalf("%s %s: %v", fingerprint, kind, err)
+			}
+			streamEcho(t, relay)
+			relay.Close()
+		}
+	}
+}
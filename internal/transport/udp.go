@@ -3,35 +3,79 @@ package transport
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
+	"slices"
 	"syscall"
 	"sync"
+	"sync/atomic"
 	"time"
 	"golang.org/x/sys/unix"
+	"myvpn/internal"
+	"myvpn/internal/bufpool"
+	"myvpn/internal/compress"
+	"myvpn/internal/ratelimit"
+	"myvpn/internal/tracing"
 )
 
 const (
 	// PacketTypeData обычный пакет данных
 	PacketTypeData = 0x01
-	// PacketTypeKeepalive keepalive пакет
+	// PacketTypeKeepalive зашифрованный keepalive пакет (без данных)
 	PacketTypeKeepalive = 0x02
-	// PacketTypeKeepaliveAck ответ на keepalive
+	// PacketTypeKeepaliveAck зашифрованный ответ на keepalive
 	PacketTypeKeepaliveAck = 0x03
-
-	// HeaderSize размер заголовка UDP пакета (1 байт тип + 4 байта sequence)
-	HeaderSize = 5
-	// CompressionFlagSize размер флага сжатия (1 байт)
+	// PacketTypeControl зашифрованное управляющее сообщение (конфигурация и т.п.)
+	PacketTypeControl = 0x04
+	// PacketTypeProbe зашифрованная проба PMTU, дополненная до проверяемого размера
+	PacketTypeProbe = 0x05
+	// PacketTypeProbeAck зашифрованный ответ на пробу PMTU с размером дошедшей пробы
+	PacketTypeProbeAck = 0x06
+	// PacketTypeFragment фрагмент пакета с данными, который не поместился в один пакет транспорта
+	PacketTypeFragment = 0x07
+	// PacketTypeCover пакет-пустышка для маскировки трафика, получатель его отбрасывает
+	PacketTypeCover = 0x08
+	// PacketTypeFEC избыточный пакет группы FEC, из которого восстанавливаются потерянные пакеты
+	PacketTypeFEC = 0x09
+	// PacketTypeDisconnect зашифрованное сообщение о том, что сторона закрывает сессию
+	PacketTypeDisconnect = 0x0A
+
+	// HeaderSize размер заголовка UDP пакета (1 байт тип + 8 байт session ID + 8 байт sequence)
+	HeaderSize = 17
+	// CompressionFlagSize размер поля с ID кодека сжатия (1 байт)
 	CompressionFlagSize = 1
-	// MaxPacketSize максимальный размер UDP пакета (MTU 1500 - IP header 20 - UDP header 8 - наш header 5)
+	// MaxPacketSize максимальный размер UDP пакета (MTU 1500 - IP header 20 - UDP header 8 - наш header 17)
 	// Это максимальный размер данных которые можно отправить через Write() до добавления UDP заголовка
 	// Флаг сжатия уже включен в данные, передаваемые в Write()
 	MaxPacketSize = 1500 - 20 - 8 - HeaderSize - 1
 )
 
-// Crypto interface for encrypting and decrypting packets with AAD
+// Смещения полей в заголовке пакета
+const (
+	sessionOffset  = 1
+	sequenceOffset = sessionOffset + 8
+	flagsOffset    = HeaderSize
+	// payloadOffset начало шифротекста
+	payloadOffset = flagsOffset + CompressionFlagSize
+)
+
+// Направление пакета в nonce. Клиент и сервер шифруют одним ключом, поэтому
+// без направления пакеты с одинаковым счетчиком получили бы одинаковый nonce
+const (
+	directionToServer = 0x00
+	directionToClient = 0x01
+)
+
+// ControlHandler обрабатывает расшифрованное управляющее сообщение
+type ControlHandler func(msg []byte, addr *net.UDPAddr, sessionID uint64)
+
+// Crypto interface for encrypting and decrypting packets with AAD.
+// Seal и Open дописывают результат к dst, как cipher.AEAD, и не выделяют память,
+// если емкости dst хватает
 type Crypto interface {
-	Encrypt(plaintext []byte, aad []byte) ([]byte, error)
-	Decrypt(ciphertext []byte, aad []byte) ([]byte, error)
+	Seal(dst, nonce, plaintext, aad []byte) ([]byte, error)
+	Open(dst, nonce, ciphertext, aad []byte) ([]byte, error)
 }
 
 // UDPTransport представляет UDP транспорт для VPN
@@ -39,19 +83,60 @@ type UDPTransport struct {
 	conn       *net.UDPConn
 	remoteAddr *net.UDPAddr
 	localAddr  *net.UDPAddr
-	sequence   uint32
-	seqMutex   sync.Mutex
+	sessionID  uint64
+	server     bool // серверный транспорт (без удаленного адреса): определяет направление в nonce
 	keepalive  time.Duration
 	done       chan struct{}
 	wg         sync.WaitGroup
 	crypto     Crypto
-	replay     *AntiReplayWindow
+	lastRecv   atomic.Int64 // время последнего принятого пакета (UnixNano)
+	onControl  ControlHandler
+	onHangup   DisconnectHandler
+	onAuthFail func(sessionID uint64)
+	capture    atomic.Pointer[CaptureFunc]
+	probeAcks  chan probeAck // ответы на PMTU пробы
+	maxData    atomic.Int64  // ограничение размера данных по найденному PMTU (0 - MaxPacketSize)
+	legacy     atomic.Bool   // принимать открытые keepalive и пробы старых версий
+	fragments  *reassembler
+	fragmentID atomic.Uint32
+	obfs       Obfuscation
+	relay      *StreamRelay    // TCP/WSS релей, через который идут датаграммы (nil - напрямую)
+	paths      []*UDPTransport // дополнительные пути через другие интерфейсы (multipath)
+
+	// FEC: восстановленные датаграммы, ожидающие чтения
+	fecOnce     sync.Once
+	recovered   []segment
+	recoveredMu sync.Mutex
+
+	// Защита от потока новых сессий: cookie под нагрузкой (проверка на сервере и cookie,
+	// полученный клиентом) и лимит пакетов неизвестных сессий с одного IP
+	cookies    *cookieChecker
+	cookie     atomic.Pointer[clientCookie]
+	handshakes *ratelimit.Keyed[netip.Addr]
+
+	// Счетчики и anti-replay окна: у клиента одна сессия, у сервера - по сессии на клиента
+	own        *sessionState
+	sessions   sync.Map          // session ID -> *sessionState, читается без блокировок
+	forgotten  map[uint64]uint64 // счетчики недавно удаленных сессий (см. ForgetSession)
+	sessionsMu sync.Mutex        // создание и удаление сессий
+	peers      sync.Map          // session ID -> *peerSession: сессии клиента с другими клиентами (P2P)
+
+	// Пакетный ввод-вывод (recvmmsg/sendmmsg) и UDP offload
+	readers      []*batchReader // по сокету группы SO_REUSEPORT каждого адреса, первый - основной
+	listeners    int            // адресов серверного транспорта (AddListener)
+	gso          atomic.Bool    // отправка с UDP_SEGMENT
+	groSupported bool
+	dscpCopy     bool        // DSCP датаграмм копируется из внутренних пакетов (SetDSCP)
+	workers      *workerPool // параллельное шифрование пачек (nil - в вызывающей горутине)
 
 	// SOCKS5 Поддержка
 	isSocks5     bool
-	socks5Conn   net.Conn       // TCP соединение для контроля SOCKS5 (должно жить)
-	socks5UDP    *net.UDPAddr   // Реальный адрес куда нужно слать UDP данные Xray 
-	socks5Remote *net.UDPAddr   // Конечный адрес VPN сервера куда Xray должен переслать пакет
+	socks5Proxy  string                      // адрес прокси для повторного UDP ASSOCIATE
+	socks5Mu     sync.Mutex                  // socks5Conn: заменяется при повторном UDP ASSOCIATE
+	socks5Conn   net.Conn                    // TCP соединение для контроля SOCKS5 (должно жить)
+	socks5UDP    atomic.Pointer[net.UDPAddr] // Реальный адрес куда нужно слать UDP данные Xray
+	socks5Remote *net.UDPAddr                // Конечный адрес VPN сервера куда Xray должен переслать пакет
+	socks5Header []byte                      // Готовый SOCKS5 UDP заголовок (RSV, FRAG, ATYP, DST.ADDR, DST.PORT)
 }
 
 // NewUDPTransport создает новый UDP транспорт с поддержкой опционального SOCKS5 прокси
@@ -69,7 +154,7 @@ func NewUDPTransport(localAddr, remoteAddr string, keepaliveInterval time.Durati
 		}
 	}
 
-	conn, err := net.ListenUDP("udp", local)
+	conn, err := listenUDP(local)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen UDP: %w", err)
 	}
@@ -80,6 +165,7 @@ func NewUDPTransport(localAddr, remoteAddr string, keepaliveInterval time.Durati
 		return nil, fmt.Errorf("failed to set UDP options: %w", err)
 	}
 
+	batch := newBatchConn(conn)
 	transport := &UDPTransport{
 		conn:       conn,
 		remoteAddr: remote,
@@ -87,60 +173,28 @@ func NewUDPTransport(localAddr, remoteAddr string, keepaliveInterval time.Durati
 		keepalive:  keepaliveInterval,
 		done:       make(chan struct{}),
 		crypto:     crypto,
-		replay:     NewAntiReplayWindow(0),
-	}
+		readers:    []*batchReader{{conn: conn, batch: batch}},
+		listeners:  1,
+		server:     remote == nil,
+		own:        newSessionState(),
+		forgotten:  make(map[uint64]uint64),
+		probeAcks:  make(chan probeAck, 4),
+		fragments:  newReassembler(),
+	}
+	transport.lastRecv.Store(time.Now().UnixNano())
+	gso, gro := udpOffload(conn)
+	transport.gso.Store(gso)
+	transport.groSupported = gro
 
 	// Настройка SOCKS5 UDP Associate
 	if socks5Proxy != "" {
 		if remote == nil {
+			conn.Close()
 			return nil, fmt.Errorf("remote address must be explicitly set when using SOCKS5")
 		}
-		transport.isSocks5 = true
-		transport.socks5Remote = remote
-
-		// 1. Подключаемся к SOCKS5 по TCP
-		socksConn, err := net.DialTimeout("tcp", socks5Proxy, 10*time.Second)
-		if err != nil {
-			return nil, fmt.Errorf("socks5 proxy dial failed: %w", err)
-		}
-		transport.socks5Conn = socksConn
-
-		// 2. Отправляем SOCKS5 Handshake (Version 5, 1 Method: No Auth)
-		if _, err := socksConn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
-			return nil, fmt.Errorf("socks5 handshake failed: %w", err)
-		}
-		
-		response := make([]byte, 2)
-		if _, err := socksConn.Read(response); err != nil || response[1] != 0x00 {
-			return nil, fmt.Errorf("socks5 auth negotiation failed: %v", err)
-		}
-
-		// 3. Отправляем запрос UDP Associate
-		// cmd=0x03 (UDP Associate), rsv=0x00, atyp=0x01 (IPv4), dst.addr=0.0.0.0, dst.port=0
-		udpAssocReq := []byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
-		if _, err := socksConn.Write(udpAssocReq); err != nil {
-			return nil, fmt.Errorf("socks5 UDP associate request failed: %w", err)
-		}
-
-		// 4. Читаем ответ сокета (где Xray открыл UDP порт для нас)
-		assocResp := make([]byte, 10)
-		if _, err := socksConn.Read(assocResp); err != nil || assocResp[1] != 0x00 {
-			return nil, fmt.Errorf("socks5 UDP associate rejected: %v", err)
-		}
-
-		// Парсим выданый нам IP:PORT прокси-сервера для отправки UDP
-		bndIP := net.IPv4(assocResp[4], assocResp[5], assocResp[6], assocResp[7])
-		bndPort := int(binary.BigEndian.Uint16(assocResp[8:10]))
-		
-		transport.socks5UDP = &net.UDPAddr{
-			IP:   bndIP,
-			Port: bndPort,
-		}
-
-		// Если Xray вернул 0.0.0.0, шлем на тот же IP, что и TCP прокси
-		if bndIP.IsUnspecified() {
-			proxyHost, _, _ := net.SplitHostPort(socks5Proxy)
-			transport.socks5UDP.IP = net.ParseIP(proxyHost)
+		if err := transport.setupSocks5(socks5Proxy); err != nil {
+			transport.Close()
+			return nil, err
 		}
 	}
 
@@ -153,202 +207,511 @@ func NewUDPTransport(localAddr, remoteAddr string, keepaliveInterval time.Durati
 	return transport, nil
 }
 
-// setUDPOptions настраивает UDP сокет для оптимизации производительности
+// setUDPOptions настраивает UDP сокет для оптимизации производительности.
+// Используем RawConn, а не conn.File(): File переводит сокет в блокирующий режим,
+// и recvmmsg начинает ждать заполнения всей пачки
 func setUDPOptions(conn *net.UDPConn) error {
-	file, err := conn.File()
+	rawConn, err := conn.SyscallConn()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	fd := int(file.Fd())
 
-	// Увеличиваем буферы приема и отправки
-	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_RCVBUF, 4*1024*1024); err != nil {
-		return err
-	}
-	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_SNDBUF, 4*1024*1024); err != nil {
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		// Увеличиваем буферы приема и отправки
+		if sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_RCVBUF, 4*1024*1024); sockErr != nil {
+			return
+		}
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_SNDBUF, 4*1024*1024)
+	})
+	if err != nil {
 		return err
 	}
+	return sockErr
+}
 
-	// Включаем reuse port для балансировки нагрузки (если поддерживается)
-	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEADDR, 1)
-	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+// Write отправляет данные через UDP (предварительно зашифровав их вместе с AAD флагом сжатия)
+// codec (алгоритм, которым сжаты data) передается в AAD для защиты заголовков
+func (t *UDPTransport) Write(data []byte, codec compress.Codec) (int, error) {
+	if t.remoteAddr == nil {
+		return 0, fmt.Errorf("remote address not set")
+	}
+	return t.WriteTo(data, codec, t.remoteAddr, t.sessionID)
+}
 
-	return nil
+// WriteTo отправляет данные конкретному адресу от имени сессии sessionID.
+// Используется сервером, у которого один транспорт на всех клиентов
+func (t *UDPTransport) WriteTo(data []byte, codec compress.Codec, addr *net.UDPAddr, sessionID uint64) (int, error) {
+	return t.writePacket(PacketTypeData, data, codec, addr, sessionID, 0)
 }
 
-// Write отправляет данные через UDP (предварительно зашифровав их вместе с AAD флагом сжатия)
-// isCompressed передается в AAD для защиты заголовков
-func (t *UDPTransport) Write(data []byte, isCompressed bool) (int, error) {
+// WriteDSCP работает как Write, но с DSCP внутреннего пакета: при SetDSCP(DSCPCopy)
+// он переносится на датаграмму
+func (t *UDPTransport) WriteDSCP(data []byte, codec compress.Codec, dscp uint8) (int, error) {
 	if t.remoteAddr == nil {
 		return 0, fmt.Errorf("remote address not set")
 	}
+	return t.writePacket(PacketTypeData, data, codec, t.remoteAddr, t.sessionID, t.outerDSCP(dscp))
+}
 
-	if len(data) > MaxPacketSize {
-		return 0, fmt.Errorf("packet too large: %d bytes (max %d)", len(data), MaxPacketSize)
+// WriteControl отправляет зашифрованное управляющее сообщение
+func (t *UDPTransport) WriteControl(msg []byte, addr *net.UDPAddr, sessionID uint64) error {
+	if addr == nil {
+		return fmt.Errorf("remote address not set")
 	}
+	_, err := t.writePacket(PacketTypeControl, msg, compress.CodecNone, addr, sessionID, 0)
+	return err
+}
 
-	// Получаем sequence number
-	t.seqMutex.Lock()
-	seq := t.sequence
-	t.sequence++
-	t.seqMutex.Unlock()
+// SetCryptoWorkers задает число горутин, которые шифруют и расшифровывают пакеты
+// в ReadBatch/WriteBatch. 0 или 1 - все выполняется в вызывающей горутине.
+// Вызывается до начала обмена пакетами
+func (t *UDPTransport) SetCryptoWorkers(n int) {
+	t.workers.close()
+	t.workers = newWorkerPool(n)
+}
 
-	// Формируем AAD (6 байт): тип (1) + sequence (4) + compressFlag (1)
-	aad := make([]byte, HeaderSize+1)
-	aad[0] = PacketTypeData
-	binary.BigEndian.PutUint32(aad[1:5], seq)
-	if isCompressed {
-		aad[5] = 0x01
-	} else {
-		aad[5] = 0x00
+// SetControlHandler задает обработчик управляющих сообщений.
+// Обработчик вызывается синхронно из Read, поэтому не должен блокироваться
+func (t *UDPTransport) SetControlHandler(h ControlHandler) {
+	t.onControl = h
+}
+
+// SetDecryptFailureHandler задает обработчик пакетов известных сессий, которые не удалось
+// расшифровать. Вызывается синхронно, в том числе из нескольких горутин ReadBatch
+func (t *UDPTransport) SetDecryptFailureHandler(h func(sessionID uint64)) {
+	t.onAuthFail = h
+}
+
+// writePacket шифрует и отправляет пакет заданного типа. dscp - DSCP датаграммы
+// (0 - по настройке сокета)
+func (t *UDPTransport) writePacket(packetType byte, data []byte, codec compress.Codec, addr *net.UDPAddr, sessionID uint64, dscp uint8) (int, error) {
+	if packetType == PacketTypeData && len(data) > t.maxPayload(sessionID) {
+		return t.writeFragments(data, codec, addr, sessionID, dscp)
 	}
 
-	encrypted, err := t.crypto.Encrypt(data, aad)
+	// Пакет шифруется в буфер из пула: writeRaw и FEC копируют все, что сохраняют
+	buf := bufpool.GetDatagram()
+	defer bufpool.PutDatagram(buf)
+	packet, err := t.sealPacket(buf, packetType, data, codec, sessionID)
 	if err != nil {
 		return 0, err
 	}
 
-	// Собираем финальный пакет: AAD + encrypted
-	packet := make([]byte, len(aad)+len(encrypted))
-	copy(packet[:len(aad)], aad)
-	copy(packet[len(aad):], encrypted)
+	n, err := t.writeRawDSCP(packet, addr, dscp)
+	if err != nil {
+		return 0, err
+	}
+	t.protect(packet, addr)
 
-	var n int
-	
-	if t.isSocks5 {
-		// SOCKS5 UDP пакет требует префикс
-		// +-----+------+------+----------+----------+----------+
-		// | RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
-		// +-----+------+------+----------+----------+----------+
-		// |  2  |   1  |   1  | Variable |     2    | Variable |
-		// +-----+------+------+----------+----------+----------+
-		socksHeader := make([]byte, 10)
-		socksHeader[0] = 0x00 // RSV
-		socksHeader[1] = 0x00 // RSV
-		socksHeader[2] = 0x00 // FRAG
-		socksHeader[3] = 0x01 // ATYP (IPv4)
-		copy(socksHeader[4:8], t.socks5Remote.IP.To4())
-		binary.BigEndian.PutUint16(socksHeader[8:10], uint16(t.socks5Remote.Port))
-
-		fullPacket := append(socksHeader, packet...)
-		n, err = t.conn.WriteToUDP(fullPacket, t.socks5UDP)
-		// Корректируем длину для логики возврата
-		if err == nil {
-			n -= 10
+	if n > HeaderSize+1 {
+		return len(data), nil
+	}
+	return 0, nil
+}
+
+// sealPacket формирует зашифрованный пакет: заголовок с флагом сжатия (AAD) + шифротекст.
+// Пакет записывается с начала dst: если емкости хватает, память не выделяется (nil - выделить)
+func (t *UDPTransport) sealPacket(dst []byte, packetType byte, data []byte, codec compress.Codec, sessionID uint64) ([]byte, error) {
+	if max := t.maxPayload(sessionID); len(data) > max {
+		return nil, fmt.Errorf("packet too large: %d bytes (max %d)", len(data), max)
+	}
+	return t.sealPacketSeq(dst, packetType, data, codec, sessionID, t.reserveSequence(sessionID, 1))
+}
+
+// initialSequence возвращает начальное значение счетчика пакетов: текущее время в секундах
+// в старших 32 битах. Так после перезапуска счетчик продолжается с большего значения
+// и nonce с тем же ключом и session ID не повторяются. 64-битный счетчик на практике
+// не переполняется: даже при 10^9 пакетов в секунду запаса хватит на сотни лет
+func initialSequence() uint64 {
+	return uint64(time.Now().Unix()) << 32
+}
+
+// reserveSequence выделяет n последовательных значений счетчика сессии и возвращает первое
+func (t *UDPTransport) reserveSequence(sessionID uint64, n int) uint64 {
+	return t.session(sessionID, true).reserve(n)
+}
+
+// packetNonce строит nonce XChaCha20-Poly1305 из session ID, направления и 64-битного счетчика:
+// session ID (8) + направление (1) + нули (7) + счетчик (8).
+// Счетчик у каждого направления монотонный, поэтому nonce никогда не повторяется.
+// Nonce берется из bufpool: вызывающий возвращает его через bufpool.PutNonce
+func packetNonce(sessionID uint64, direction byte, counter uint64) []byte {
+	nonce := bufpool.GetNonce()
+	binary.BigEndian.PutUint64(nonce, sessionID)
+	nonce[8] = direction
+	clear(nonce[9:16])
+	binary.BigEndian.PutUint64(nonce[16:], counter)
+	return nonce
+}
+
+// sendDirection возвращает направление пакетов, которые транспорт отправляет в сессию.
+// В сессии с другим клиентом инициатор пары отправляет пакеты как клиент, другая сторона - как сервер
+func (t *UDPTransport) sendDirection(sessionID uint64) byte {
+	if p := t.peer(sessionID); p != nil && !p.initiator {
+		return directionToClient
+	}
+	if t.server {
+		return directionToClient
+	}
+	return directionToServer
+}
+
+// recvDirection возвращает направление пакетов, которые принимает транспорт.
+// Отраженный обратно собственный пакет не пройдет проверку: nonce получится другим
+func (t *UDPTransport) recvDirection(sessionID uint64) byte {
+	if p := t.peer(sessionID); p != nil && !p.initiator {
+		return directionToServer
+	}
+	if t.server {
+		return directionToServer
+	}
+	return directionToClient
+}
+
+// sealPacketSeq шифрует пакет с заранее выделенным значением счетчика и записывает его в dst
+func (t *UDPTransport) sealPacketSeq(dst []byte, packetType byte, data []byte, codec compress.Codec, sessionID uint64, counter uint64) ([]byte, error) {
+	if max := t.maxPayload(sessionID); len(data) > max {
+		return nil, fmt.Errorf("packet too large: %d bytes (max %d)", len(data), max)
+	}
+
+	flags := byte(codec)
+	if t.obfs.PadBucket > 0 {
+		scratch := bufpool.GetDatagram()
+		defer bufpool.PutDatagram(scratch)
+		if padded, ok := t.pad(scratch, data, sessionID); ok {
+			data = padded
+			flags |= flagPadded
 		}
-	} else {
-		n, err = t.conn.WriteToUDP(packet, t.remoteAddr)
 	}
+	return t.seal(dst, packetType, data, flags, sessionID, counter)
+}
 
+// seal шифрует пакет без проверки размера и дополнения (нужно пробам PMTU точного размера)
+// и записывает его в dst
+func (t *UDPTransport) seal(dst []byte, packetType byte, data []byte, flags byte, sessionID uint64, counter uint64) ([]byte, error) {
+	// AAD (18 байт): тип (1) + session ID (8) + sequence (8) + кодек сжатия (1).
+	// Seal дописывает шифротекст сразу за ним в ту же память
+	packet := slices.Grow(dst[:0], payloadOffset+len(data)+internal.Overhead)[:payloadOffset]
+	header := packet[:payloadOffset]
+	header[0] = packetType
+	binary.BigEndian.PutUint64(header[sessionOffset:], sessionID)
+	binary.BigEndian.PutUint64(header[sequenceOffset:], counter)
+	header[flagsOffset] = flags
+
+	nonce := packetNonce(sessionID, t.sendDirection(sessionID), counter)
+	defer bufpool.PutNonce(nonce)
+	span := tracing.PacketSpan("transport.encrypt", len(data))
+	packet, err := t.cryptoFor(sessionID).Seal(packet, nonce, data, header)
+	span.End()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	return packet, nil
+}
 
-	if n > len(aad) {
-		return len(data), nil
+// writeRaw отправляет готовый пакет, при необходимости оборачивая его в SOCKS5 UDP заголовок.
+// Возвращает число отправленных байт без учета SOCKS5 заголовка
+func (t *UDPTransport) writeRaw(packet []byte, addr *net.UDPAddr) (int, error) {
+	return t.writeRawDSCP(packet, addr, 0)
+}
+
+// writeRawDSCP работает как writeRaw, но с DSCP датаграммы (0 - по настройке сокета)
+func (t *UDPTransport) writeRawDSCP(packet []byte, addr *net.UDPAddr, dscp uint8) (int, error) {
+	id, _ := PacketSessionID(packet)
+	if !t.server && t.peer(id) == nil {
+		packet = t.wrapCookie(packet)
 	}
-	return 0, nil
+	return t.writeVia(t.sender(id), packet, addr, dscp)
+}
+
+// writeVia отправляет датаграмму через сокет r
+func (t *UDPTransport) writeVia(r *batchReader, packet []byte, addr *net.UDPAddr, dscp uint8) (int, error) {
+	metricPacketsSent.Inc()
+	metricBytesSent.Add(uint64(len(packet)))
+
+	datagram, dst := t.frame(packet, addr)
+	var n int
+	var err error
+	if dscp != 0 {
+		n, _, err = r.conn.WriteMsgUDP(datagram, dscpControl(dscp, dst), dst)
+	} else {
+		n, err = r.conn.WriteToUDP(datagram, dst)
+	}
+	if err != nil {
+		return 0, err
+	}
+	t.captured(packet, addr, true)
+	// Корректируем длину для логики возврата
+	return n - (len(datagram) - len(packet)), nil
+}
+
+// frame возвращает датаграмму и адрес, куда ее нужно отправить.
+// В режиме SOCKS5 пакет уходит на UDP relay Xray с префиксом:
+// +-----+------+------+----------+----------+----------+
+// | RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+// +-----+------+------+----------+----------+----------+
+// |  2  |   1  |   1  | Variable |     2    | Variable |
+// +-----+------+------+----------+----------+----------+
+func (t *UDPTransport) frame(packet []byte, addr *net.UDPAddr) ([]byte, *net.UDPAddr) {
+	if !t.isSocks5 {
+		return packet, addr
+	}
+	return append(append([]byte{}, t.socks5Header...), packet...), t.socks5UDP.Load()
 }
 
 // Read читает данные из UDP и расшифровывает
 // Возвращает (расшифрованные_данные, флаг_сжатия, caller_addr, error)
-func (t *UDPTransport) Read(data []byte) (int, bool, *net.UDPAddr, error) {
-	buf := make([]byte, MaxPacketSize+HeaderSize+100+10) // +100 MAC, +10 SOCKS5
+func (t *UDPTransport) Read(data []byte) (int, compress.Codec, *net.UDPAddr, error) {
+	n, codec, addr, _, err := t.ReadSession(data)
+	return n, codec, addr, err
+}
+
+// ReadSession работает как Read, но дополнительно возвращает session ID отправителя.
+// Session ID аутентифицирован (входит в AAD) только для пакетов с данными (n > 0)
+func (t *UDPTransport) ReadSession(data []byte) (int, compress.Codec, *net.UDPAddr, uint64, error) {
+	if recovered := t.takeRecovered(); len(recovered) > 0 {
+		// Читаем по одной датаграмме, остальные возвращаем в очередь
+		t.recoveredMu.Lock()
+		t.recovered = append(recovered[1:], t.recovered...)
+		t.recoveredMu.Unlock()
+		return t.handlePacket(recovered[0].buf, recovered[0].addr, data, nil)
+	}
+
+	// Датаграмма разбирается синхронно, и все, что из нее сохраняется (FEC, фрагменты,
+	// управляющие сообщения), копируется, поэтому буфер сразу возвращается в пул
+	buf := bufpool.GetDatagram()
+	defer bufpool.PutDatagram(buf)
 	n, addr, err := t.conn.ReadFromUDP(buf)
 	if err != nil {
-		return 0, false, addr, err
+		return 0, compress.CodecNone, addr, 0, err
 	}
+	return t.handleDatagram(buf[:n], addr, data, t.readers[0])
+}
+
+// handleDatagram разбирает принятую датаграмму: снимает SOCKS5 заголовок, проверяет replay,
+// расшифровывает и отвечает на keepalive. Данные пакета копируются в data.
+// via - сокет, принявший датаграмму (nil - пакет восстановлен FEC)
+func (t *UDPTransport) handleDatagram(buf []byte, addr *net.UDPAddr, data []byte, via *batchReader) (int, compress.Codec, *net.UDPAddr, uint64, error) {
+	n := len(buf)
+	metricPacketsReceived.Inc()
+	metricBytesReceived.Add(uint64(n))
 
 	// Снятие SOCKS5 заголовка с входящего UDP пакета
 	offset := 0
 	if t.isSocks5 {
-		if n < 10 {
-			return 0, false, addr, fmt.Errorf("truncated SOCKS5 UDP packet")
-		}
-		// Пропускаем RSV(2), FRAG(1)
-		atyp := buf[3]
-		if atyp == 0x01 { // IPv4
-			offset = 10
-		} else if atyp == 0x03 { // Domain
-			domainLen := int(buf[4])
-			offset = 5 + domainLen + 2
-		} else if atyp == 0x04 { // IPv6
-			offset = 22
-		} else {
-			return 0, false, addr, fmt.Errorf("unsupported SOCKS5 atyp: %d", atyp)
+		var err error
+		if offset, err = socks5HeaderLen(buf); err != nil {
+			metricMalformed.Inc()
+			return 0, compress.CodecNone, addr, 0, err
 		}
-		
-		if n < offset {
-			return 0, false, addr, fmt.Errorf("truncated SOCKS5 UDP payload")
-		}
-		
 		buf = buf[offset:]
 		n -= offset
 		addr = t.socks5Remote // Подменяем отправителя на целевой VPN сервер
 	}
+	t.captured(buf[:n], addr, false)
 
-	// Если удаленный адрес еще не установлен, устанавливаем его (кроме случаев когда это сервер и клиент новый)
-	// В сервере мы не можем менять remoteAddr на лету так просто, поэтому это ок только для клиента
-	if t.remoteAddr == nil {
+	// Если удаленный адрес еще не установлен, устанавливаем его и запускаем keepalive.
+	// Это нужно только клиенту: у серверного транспорта (keepalive = 0) один сокет на всех
+	// клиентов, и его датаграммы могут разбираться параллельно (ReadBatch)
+	if t.keepalive > 0 && t.remoteAddr == nil {
 		t.remoteAddr = addr
-		// Запускаем keepalive после установки адреса
-		if t.keepalive > 0 {
-			t.wg.Add(1)
-			go t.keepaliveLoop()
-		}
+		t.wg.Add(1)
+		go t.keepaliveLoop()
 	}
 
+	return t.handlePacket(buf[:n], addr, data, via)
+}
+
+// handlePacket разбирает пакет протокола (датаграмму без SOCKS5 заголовка)
+func (t *UDPTransport) handlePacket(buf []byte, addr *net.UDPAddr, data []byte, via *batchReader) (int, compress.Codec, *net.UDPAddr, uint64, error) {
+	n := len(buf)
 	if n < HeaderSize {
-		return 0, false, addr, fmt.Errorf("packet too short")
+		metricMalformed.Inc()
+		return 0, compress.CodecNone, addr, 0, fmt.Errorf("packet too short")
+	}
+
+	// Пакет с верным cookie принимается и от неизвестной сессии, даже когда сервер под нагрузкой
+	admitted := false
+	if buf[0] == PacketTypeCookie {
+		inner, valid, err := t.unwrapCookie(buf[:n], addr)
+		if err != nil {
+			metricMalformed.Inc()
+			return 0, compress.CodecNone, addr, 0, err
+		}
+		buf, n, admitted = inner, len(inner), valid
+	}
+	if buf[0] == PacketTypeCookieReply {
+		if err := t.handleCookieReply(buf[:n]); err != nil {
+			return 0, compress.CodecNone, addr, 0, err
+		}
+		return 0, compress.CodecNone, addr, 0, nil
 	}
 
 	packetType := buf[0]
-	seq := binary.BigEndian.Uint32(buf[1:5])
+	sessionID := binary.BigEndian.Uint64(buf[sessionOffset:])
+	seq := binary.BigEndian.Uint64(buf[sequenceOffset:])
 
-	// Обрабатываем keepalive пакеты
-	if packetType == PacketTypeKeepalive {
-		// Отправляем ACK
-		ack := make([]byte, HeaderSize)
-		ack[0] = PacketTypeKeepaliveAck
-		binary.BigEndian.PutUint32(ack[1:5], seq)
-		t.conn.WriteToUDP(ack, addr)
-		return 0, false, addr, nil // Не возвращаем данные для keepalive
+	// Keepalive и пробы старых версий передаются открыто и короче любого зашифрованного пакета
+	// (открытую пробу можно отличить только по ошибке расшифровки, см. ниже)
+	if isServicePacket(packetType) && n < payloadOffset+internal.Overhead {
+		return t.handleLegacy(buf[:n], addr, sessionID)
 	}
 
-	if packetType == PacketTypeKeepaliveAck {
-		return 0, false, addr, nil // Игнорируем ACK
+	// Избыточные пакеты FEC не шифруются: восстановленные из них пакеты проверяются как обычные.
+	// Их принимаем только для известных сессий
+	if packetType == PacketTypeFEC {
+		state := t.session(sessionID, false)
+		if state == nil {
+			return 0, compress.CodecNone, addr, 0, fmt.Errorf("FEC packet for unknown session")
+		}
+		if err := t.recoverFEC(state, buf[:n], addr); err != nil {
+			metricMalformed.Inc()
+			return 0, compress.CodecNone, addr, 0, err
+		}
+		return 0, compress.CodecNone, addr, sessionID, nil
 	}
 
-	if packetType != PacketTypeData {
-		return 0, false, addr, fmt.Errorf("unknown packet type: %d", packetType)
+	if packetType != PacketTypeData && packetType != PacketTypeControl && packetType != PacketTypeFragment && packetType != PacketTypeCover && packetType != PacketTypeDisconnect && !isServicePacket(packetType) {
+		metricMalformed.Inc()
+		return 0, compress.CodecNone, addr, 0, fmt.Errorf("unknown packet type: %d", packetType)
 	}
 
-	if n < HeaderSize+1 {
-		return 0, false, addr, fmt.Errorf("packet too short for compression flag")
+	if n < payloadOffset {
+		metricMalformed.Inc()
+		return 0, compress.CodecNone, addr, 0, fmt.Errorf("packet too short for compression flag")
 	}
 
-	// Проверяем Anti-Replay окно
-	if !t.replay.Check(seq) {
-		return 0, false, addr, fmt.Errorf("replay attack detected, seq: %d", seq)
+	// Проверяем Anti-Replay окно сессии до расшифровки, чтобы не тратить на повторы время.
+	// Окно новой сессии заводится только после успешной расшифровки
+	state := t.session(sessionID, false)
+	if state == nil && t.cookies != nil && !admitted && t.cookies.underLoad() {
+		// Под нагрузкой не расшифровываем пакеты неизвестных сессий без cookie: адрес мог быть подделан.
+		// Ошибку не возвращаем, чтобы поток таких пакетов не засыпал лог
+		t.replyCookie(buf[:n], addr, sessionID, via)
+		return 0, compress.CodecNone, addr, 0, nil
+	}
+	if state == nil && !t.allowHandshake(addr) {
+		return 0, compress.CodecNone, addr, 0, nil
+	}
+	if state != nil && state.replay.Seen(seq) {
+		metricReplayDrops.Inc()
+		return 0, compress.CodecNone, addr, 0, fmt.Errorf("replay attack detected, seq: %d", seq)
 	}
 
 	aad := buf[:HeaderSize+1]
-	isCompressed := aad[5] == 0x01
-	encrypted := buf[HeaderSize+1 : n]
-
-	decrypted, err := t.crypto.Decrypt(encrypted, aad)
+	flags := aad[flagsOffset]
+	codec := compress.Codec(flags & codecMask)
+	encrypted := buf[payloadOffset:n]
+
+	// Расшифровываем сразу в буфер вызывающего, если он вмещает пакет. Управляющее сообщение
+	// обработчик может сохранить, поэтому под него память выделяется. Остальные пакеты
+	// расшифровываются во временный буфер из пула
+	var dst []byte
+	switch {
+	case packetType == PacketTypeControl:
+	case len(encrypted)-internal.Overhead <= len(data):
+		dst = data[:0]
+	default:
+		scratch := bufpool.GetDatagram()
+		defer bufpool.PutDatagram(scratch)
+		dst = scratch[:0]
+	}
+	nonce := packetNonce(sessionID, t.recvDirection(sessionID), seq)
+	span := tracing.PacketSpan("transport.decrypt", len(encrypted))
+	decrypted, err := t.cryptoFor(sessionID).Open(dst, nonce, encrypted, aad)
+	span.End()
+	bufpool.PutNonce(nonce)
 	if err != nil {
-		return 0, false, addr, err
+		if packetType == PacketTypeProbe && t.legacy.Load() {
+			return t.handleLegacy(buf[:n], addr, sessionID)
+		}
+		metricDecryptFailures.Inc()
+		if state != nil && t.onAuthFail != nil {
+			t.onAuthFail(sessionID)
+		}
+		return 0, compress.CodecNone, addr, 0, err
+	}
+
+	// Отмечаем sequence. Повтор мог прийти параллельно (ReadBatch), поэтому проверяем еще раз
+	if state == nil {
+		state = t.session(sessionID, true)
+	}
+	if !state.replay.Check(seq) {
+		metricReplayDrops.Inc()
+		return 0, compress.CodecNone, addr, 0, fmt.Errorf("replay attack detected, seq: %d", seq)
+	}
+	if t.listeners > 1 && via != nil {
+		// Клиент принимает ответы только с адреса, на который отправлял
+		state.via.Store(via)
+	}
+	// Время последнего пакета обновляется только для проверенных пакетов,
+	// иначе поддельные пакеты продлевали бы жизнь пропавшему соединению.
+	// Пакеты других клиентов не говорят о том, что жив сервер
+	if p := t.peer(sessionID); p != nil {
+		p.lastRecv.Store(time.Now().UnixNano())
+	} else {
+		t.lastRecv.Store(time.Now().UnixNano())
+		if t.cookie.Load() != nil {
+			// Сервер ответил, значит сессия у него уже есть и cookie больше не нужен
+			t.cookie.Store(nil)
+		}
+	}
+	if dec := state.fecIn.Load(); dec != nil && (packetType == PacketTypeData || packetType == PacketTypeFragment) {
+		dec.remember(seq, buf[:n])
+	}
+
+	if flags&flagPadded != 0 {
+		if decrypted, err = unpad(decrypted); err != nil {
+			metricMalformed.Inc()
+			return 0, compress.CodecNone, addr, 0, err
+		}
+	}
+
+	if packetType == PacketTypeCover {
+		return 0, compress.CodecNone, addr, sessionID, nil
+	}
+
+	if isServicePacket(packetType) {
+		if err := t.handleKeepalive(packetType, decrypted, seq, n, addr, sessionID); err != nil {
+			metricMalformed.Inc()
+			return 0, compress.CodecNone, addr, 0, err
+		}
+		return 0, compress.CodecNone, addr, sessionID, nil
+	}
+
+	if packetType == PacketTypeFragment {
+		packet, complete, err := t.fragments.add(sessionID, decrypted, codec)
+		if err != nil {
+			metricMalformed.Inc()
+			return 0, compress.CodecNone, addr, 0, err
+		}
+		if !complete {
+			return 0, compress.CodecNone, addr, sessionID, nil
+		}
+		decrypted = packet
+	}
+
+	if packetType == PacketTypeDisconnect {
+		if err := t.handleDisconnect(decrypted, addr, sessionID); err != nil {
+			return 0, compress.CodecNone, addr, 0, err
+		}
+		return 0, compress.CodecNone, addr, sessionID, nil
+	}
+
+	if packetType == PacketTypeControl {
+		if t.onControl != nil {
+			t.onControl(decrypted, addr, sessionID)
+		}
+		return 0, compress.CodecNone, addr, sessionID, nil
 	}
 
 	if len(decrypted) > len(data) {
-		return 0, false, addr, fmt.Errorf("buffer too small: need %d bytes", len(decrypted))
+		return 0, compress.CodecNone, addr, 0, fmt.Errorf("buffer too small: need %d bytes", len(decrypted))
 	}
 
 	copy(data, decrypted)
-	return len(decrypted), isCompressed, addr, nil
+	return len(decrypted), codec, addr, sessionID, nil
 }
 
 // SetRemoteAddr устанавливает удаленный адрес
@@ -360,6 +723,28 @@ func (t *UDPTransport) SetRemoteAddr(addr *net.UDPAddr) {
 	}
 }
 
+// SetSessionID задает session ID, которым помечаются пакеты, отправляемые через Write
+func (t *UDPTransport) SetSessionID(id uint64) {
+	t.sessionID = id
+}
+
+// LastReceive возвращает время последнего принятого аутентифицированного пакета (включая keepalive)
+// или время создания транспорта, если пакетов еще не было
+func (t *UDPTransport) LastReceive() time.Time {
+	return time.Unix(0, t.lastRecv.Load())
+}
+
+// Sequence возвращает следующее значение счетчика пакетов для отправки
+func (t *UDPTransport) Sequence() uint64 {
+	return t.own.sequence.Load()
+}
+
+// SetSequence задает следующее значение счетчика пакетов. Используется при переподключении,
+// чтобы новые пакеты не попали под anti-replay окно сервера
+func (t *UDPTransport) SetSequence(seq uint64) {
+	t.own.sequence.Store(seq)
+}
+
 // RemoteAddr возвращает удаленный адрес
 func (t *UDPTransport) RemoteAddr() *net.UDPAddr {
 	return t.remoteAddr
@@ -386,30 +771,21 @@ func (t *UDPTransport) keepaliveLoop() {
 				continue
 			}
 
-			t.seqMutex.Lock()
-			seq := t.sequence
-			t.sequence++
-			t.seqMutex.Unlock()
-
-			packet := make([]byte, HeaderSize)
-			packet[0] = PacketTypeKeepalive
-			binary.BigEndian.PutUint32(packet[1:5], seq)
-
-			if t.isSocks5 {
-				// Упаковка в SOCKS5 заголовок
-				socksHeader := make([]byte, 10)
-				socksHeader[3] = 0x01
-				copy(socksHeader[4:8], t.socks5Remote.IP.To4())
-				binary.BigEndian.PutUint16(socksHeader[8:10], uint16(t.socks5Remote.Port))
-				fullPacket := append(socksHeader, packet...)
-				t.conn.WriteToUDP(fullPacket, t.socks5UDP)
-			} else {
-				t.conn.WriteToUDP(packet, t.remoteAddr)
-			}
+			t.sendKeepalive(t.remoteAddr, t.sessionID)
 		}
 	}
 }
 
+// Closed сообщает, что транспорт закрыт
+func (t *UDPTransport) Closed() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close закрывает транспорт
 func (t *UDPTransport) Close() error {
 	select {
@@ -419,12 +795,26 @@ func (t *UDPTransport) Close() error {
 		close(t.done)
 	}
 
-	if t.socks5Conn != nil {
-		t.socks5Conn.Close()
-	}
+	t.closeSocks5()
 
 	t.wg.Wait()
-	return t.conn.Close()
+	t.workers.close()
+	err := t.conn.Close()
+	for _, r := range t.readers[1:] {
+		r.conn.Close()
+	}
+	for _, r := range t.readers {
+		if c, ok := r.batch.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	if t.relay != nil {
+		t.relay.Close()
+	}
+	for _, p := range t.paths {
+		p.Close()
+	}
+	return err
 }
 
 // Conn возвращает UDP соединение для использования в других местах
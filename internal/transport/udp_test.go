@@ -0,0 +1,189 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"myvpn/internal"
+	"myvpn/internal/compress"
+)
+
+// benchPacketSize размер пакета с данными в бенчмарках: полный пакет TUN с MTU 1400
+const benchPacketSize = 1400
+
+// benchReadChunk сколько датаграмм отправляется до чтения: столько помещается
+// в буфер приема сокета, и ни одна не теряется
+const benchReadChunk = 64
+
+// newBenchPair создает клиентский и серверный транспорты на loopback с общим ключом.
+// Клиент отправляет пакеты сессии 1 на сервер
+func newBenchPair(tb testing.TB) (client, server *UDPTransport) {
+	tb.Helper()
+	crypto, err := internal.NewCrypto(make([]byte, internal.KeySize))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server, err = NewUDPTransport("127.0.0.1:0", "", 0, crypto, "")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { server.Close() })
+	client, err = NewUDPTransport("127.0.0.1:0", server.Conn().LocalAddr().String(), 0, crypto, "")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	client.SetSessionID(1)
+	return client, server
+}
+
+// readData читает с сервера следующий пакет с данными (фрагменты собираются)
+func readData(tb testing.TB, server *UDPTransport, buf []byte) int {
+	server.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, _, _, err := server.Read(buf)
+		if err != nil {
+			tb.Fatalf("Read: %v", err)
+		}
+		if n > 0 {
+			return n
+		}
+	}
+}
+
+func BenchmarkUDPTransportWrite(b *testing.B) {
+	client, _ := newBenchPair(b)
+	packet := make([]byte, benchPacketSize)
+	b.ReportAllocs()
+	b.SetBytes(benchPacketSize)
+	for b.Loop() {
+		if _, err := client.Write(packet, compress.CodecNone); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUDPTransportWriteFragmented отправляет пакеты, которые после уменьшения
+// Path MTU уходят двумя фрагментами
+func BenchmarkUDPTransportWriteFragmented(b *testing.B) {
+	client, _ := newBenchPair(b)
+	client.SetPathMTU(1280)
+	packet := make([]byte, benchPacketSize)
+	b.ReportAllocs()
+	b.SetBytes(benchPacketSize)
+	for b.Loop() {
+		if _, err := client.Write(packet, compress.CodecNone); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkRead измеряет прием пакетов: отправка каждой порции идет при остановленном таймере
+func benchmarkRead(b *testing.B, pathMTU int) {
+	client, server := newBenchPair(b)
+	if pathMTU > 0 {
+		client.SetPathMTU(pathMTU)
+	}
+	packet := make([]byte, benchPacketSize)
+	buf := make([]byte, 2048)
+	// Первый пакет заводит сессию на сервере
+	client.Write(packet, compress.CodecNone)
+	readData(b, server, buf)
+
+	b.ReportAllocs()
+	b.SetBytes(benchPacketSize)
+	b.ResetTimer()
+	for done := 0; done < b.N; {
+		chunk := min(benchReadChunk, b.N-done)
+		b.StopTimer()
+		for range chunk {
+			if _, err := client.Write(packet, compress.CodecNone); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		for range chunk {
+			if n := readData(b, server, buf); n != benchPacketSize {
+				b.Fatalf("Read returned %d bytes, want %d", n, benchPacketSize)
+			}
+		}
+		done += chunk
+	}
+}
+
+func BenchmarkUDPTransportRead(b *testing.B) {
+	benchmarkRead(b, 0)
+}
+
+// BenchmarkUDPTransportReadFragmented принимает пакеты из двух фрагментов и собирает их
+func BenchmarkUDPTransportReadFragmented(b *testing.B) {
+	benchmarkRead(b, 1280)
+}
+
+// BenchmarkUDPTransportWriteBatch отправляет с сервера пачки по 32 пакета одному клиенту
+func BenchmarkUDPTransportWriteBatch(b *testing.B) {
+	client, server := newBenchPair(b)
+	addr := client.Conn().LocalAddr().(*net.UDPAddr)
+	pkts := make([]Packet, 32)
+	data := make([]byte, benchPacketSize)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(pkts) * benchPacketSize))
+	for b.Loop() {
+		for i := range pkts {
+			pkts[i] = Packet{Data: data, Addr: addr, SessionID: 1}
+		}
+		if n, err := server.WriteBatch(pkts); err != nil || n != len(pkts) {
+			b.Fatalf("WriteBatch sent %d of %d: %v", n, len(pkts), err)
+		}
+	}
+}
+
+// TestUDPTransportFragments проверяет, что пакет больше Path MTU доходит целиком
+func TestUDPTransportFragments(t *testing.T) {
+	client, server := newBenchPair(t)
+	client.SetPathMTU(576)
+	packet := make([]byte, benchPacketSize)
+	for i := range packet {
+		packet[i] = byte(i)
+	}
+	if _, err := client.Write(packet, compress.CodecNone); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	n := readData(t, server, buf)
+	if !bytes.Equal(buf[:n], packet) {
+		t.Fatalf("reassembled %d bytes differ from the %d sent", n, len(packet))
+	}
+}
+
+// TestUDPTransportWriteBatch проверяет, что пакеты пачки, склеенные в GSO буферы,
+// доходят по отдельности и по порядку
+func TestUDPTransportWriteBatch(t *testing.T) {
+	client, server := newBenchPair(t)
+	addr := client.Conn().LocalAddr().(*net.UDPAddr)
+	pkts := make([]Packet, 8)
+	for i := range pkts {
+		// Склеиваются датаграммы одного размера, последняя может быть короче
+		size := 1000
+		if i == len(pkts)-1 {
+			size = 900
+		}
+		pkts[i] = Packet{Data: bytes.Repeat([]byte{byte(i)}, size), Addr: addr, SessionID: 1}
+	}
+	if n, err := server.WriteBatch(pkts); err != nil || n != len(pkts) {
+		t.Fatalf("WriteBatch sent %d of %d: %v", n, len(pkts), err)
+	}
+	buf := make([]byte, 2048)
+	for i := range pkts {
+		client.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, _, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if !bytes.Equal(buf[:n], pkts[i].Data) {
+			t.Fatalf("packet %d: got %d bytes, want %d bytes of %d", i, n, len(pkts[i].Data), i)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+
+	"myvpn/internal/uring"
+)
+
+// UseIOURing переводит пакетный ввод-вывод (ReadBatch/ReadShard/WriteBatch) на io_uring:
+// пачка recvmsg/sendmsg отправляется ядру одним io_uring_enter вместо recvmmsg/sendmmsg.
+// Вызывается до начала обмена пакетами, после SetShards
+func (t *UDPTransport) UseIOURing() error {
+	for _, r := range t.readers {
+		batch, err := newURingBatch(r.conn)
+		if err != nil {
+			return err
+		}
+		if c, ok := r.batch.(io.Closer); ok {
+			c.Close()
+		}
+		r.batch = batch
+	}
+	return nil
+}
+
+// newURingBatch создает очереди io_uring для сокета
+func newURingBatch(conn *net.UDPConn) (*uringBatch, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var inet6 bool
+	if err := raw.Control(func(fd uintptr) {
+		sa, _ := unix.Getsockname(int(fd))
+		_, inet6 = sa.(*unix.SockaddrInet6)
+	}); err != nil {
+		return nil, err
+	}
+	ring, err := uring.NewConn(raw, BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up io_uring: %w", err)
+	}
+	return &uringBatch{conn: ring, inet6: inet6}, nil
+}
+
+// uringBatch batchConn поверх io_uring
+type uringBatch struct {
+	conn  *uring.Conn
+	inet6 bool // сокет AF_INET6 (в том числе dual-stack): адреса IPv4 передаются как v4-mapped
+
+	rd  msgBufs // ReadBatch вызывается из одной горутины
+	wr  msgBufs
+	wmu sync.Mutex
+}
+
+// msgBufs заголовки сообщений пачки, переиспользуемые между вызовами
+type msgBufs struct {
+	hdrs  []unix.Msghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrAny
+	sizes []int
+}
+
+// prepare заполняет заголовки для ms: буферы, OOB и место под адрес
+func (m *msgBufs) prepare(ms []ipv4.Message) {
+	total := 0
+	for _, msg := range ms {
+		total += len(msg.Buffers)
+	}
+	// Заголовки ссылаются на iovs, поэтому емкость выделяется заранее
+	m.iovs = slices.Grow(m.iovs[:0], total)
+	if len(m.hdrs) < len(ms) {
+		m.hdrs = make([]unix.Msghdr, len(ms))
+		m.names = make([]unix.RawSockaddrAny, len(ms))
+		m.sizes = make([]int, len(ms))
+	}
+	for i, msg := range ms {
+		h := &m.hdrs[i]
+		*h = unix.Msghdr{Name: (*byte)(unsafe.Pointer(&m.names[i])), Namelen: unix.SizeofSockaddrAny}
+		start := len(m.iovs)
+		for _, buf := range msg.Buffers {
+			var iov unix.Iovec
+			if len(buf) > 0 {
+				iov.Base = &buf[0]
+			}
+			iov.SetLen(len(buf))
+			m.iovs = append(m.iovs, iov)
+		}
+		if len(m.iovs) > start {
+			h.Iov = &m.iovs[start]
+			h.SetIovlen(len(m.iovs) - start)
+		}
+		if len(msg.OOB) > 0 {
+			h.Control = &msg.OOB[0]
+			h.SetControllen(len(msg.OOB))
+		}
+	}
+}
+
+// Close освобождает очереди io_uring. Сокет закрывается отдельно и раньше
+func (b *uringBatch) Close() error {
+	return b.conn.Close()
+}
+
+func (b *uringBatch) ReadBatch(ms []ipv4.Message, _ int) (int, error) {
+	m := &b.rd
+	m.prepare(ms)
+	n, err := b.conn.Recvmsg(m.hdrs[:len(ms)], m.sizes[:len(ms)])
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		ms[i].N = m.sizes[i]
+		ms[i].NN = int(m.hdrs[i].Controllen)
+		ms[i].Flags = int(m.hdrs[i].Flags)
+		ms[i].Addr = udpAddrFromRaw(&m.names[i])
+	}
+	return n, nil
+}
+
+func (b *uringBatch) WriteBatch(ms []ipv4.Message, _ int) (int, error) {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+
+	m := &b.wr
+	m.prepare(ms)
+	for i := range ms {
+		addr, _ := ms[i].Addr.(*net.UDPAddr)
+		if addr == nil {
+			m.hdrs[i].Name, m.hdrs[i].Namelen = nil, 0
+			continue
+		}
+		m.hdrs[i].Namelen = putRawAddr(&m.names[i], addr, b.inet6)
+	}
+	return b.conn.Sendmsg(m.hdrs[:len(ms)])
+}
+
+// putRawAddr записывает адрес в sockaddr семейства сокета и возвращает его длину
+func putRawAddr(sa *unix.RawSockaddrAny, addr *net.UDPAddr, inet6 bool) uint32 {
+	if !inet6 {
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		*sa4 = unix.RawSockaddrInet4{Family: unix.AF_INET}
+		copy(sa4.Addr[:], addr.IP.To4())
+		putPort(&sa4.Port, addr.Port)
+		return unix.SizeofSockaddrInet4
+	}
+	sa6 := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+	*sa6 = unix.RawSockaddrInet6{Family: unix.AF_INET6}
+	copy(sa6.Addr[:], addr.IP.To16())
+	putPort(&sa6.Port, addr.Port)
+	return unix.SizeofSockaddrInet6
+}
+
+// putPort записывает порт в порядке байт сети
+func putPort(dst *uint16, port int) {
+	p := (*[2]byte)(unsafe.Pointer(dst))
+	p[0], p[1] = byte(port>>8), byte(port)
+}
+
+// udpAddrFromRaw разбирает адрес отправителя из sockaddr
+func udpAddrFromRaw(sa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch sa.Addr.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&sa4.Port))
+		return &net.UDPAddr{IP: net.IPv4(sa4.Addr[0], sa4.Addr[1], sa4.Addr[2], sa4.Addr[3]), Port: int(p[0])<<8 | int(p[1])}
+	case unix.AF_INET6:
+		sa6 := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&sa6.Port))
+		return &net.UDPAddr{IP: slices.Clone(sa6.Addr[:]), Port: int(p[0])<<8 | int(p[1])}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transport
+
+import "errors"
+
+// UseIOURing на других системах недоступен: io_uring есть только в Linux
+func (t *UDPTransport) UseIOURing() error {
+	return errors.New("io_uring is only supported on Linux")
+}
@@ -0,0 +1,83 @@
+package transport
+
+import "sync"
+
+// workerPool выполняет шифрование и дешифрование пачки пакетов на фиксированном
+// числе горутин. Каждая задача пишет результат в свой элемент пачки, поэтому порядок
+// пакетов (и, значит, порядок внутри каждого пира) сохраняется
+type workerPool struct {
+	workers int
+	jobs    chan func() // без буфера: принятая задача всегда будет выполнена
+	stop    chan struct{}
+}
+
+// newWorkerPool запускает n горутин. При n <= 1 пул не создается и все выполняется на месте
+func newWorkerPool(n int) *workerPool {
+	if n <= 1 {
+		return nil
+	}
+	p := &workerPool{
+		workers: n,
+		jobs:    make(chan func()),
+		stop:    make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				select {
+				case job := <-p.jobs:
+					job()
+				case <-p.stop:
+					return
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// run вызывает fn(i) для i в [0, n), разбивая диапазон на непрерывные куски по числу
+// горутин, и ждет завершения. nil пул выполняет все в вызывающей горутине
+func (p *workerPool) run(n int, fn func(i int)) {
+	if p == nil || n < 2 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	chunks := p.workers
+	if chunks > n {
+		chunks = n
+	}
+	size := (n + chunks - 1) / chunks
+
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		job := func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				fn(i)
+			}
+		}
+		// После остановки пула (транспорт закрыт) доделываем работу сами
+		select {
+		case p.jobs <- job:
+		case <-p.stop:
+			job()
+		}
+	}
+	wg.Wait()
+}
+
+// close останавливает горутины пула
+func (p *workerPool) close() {
+	if p != nil {
+		close(p.stop)
+	}
+}
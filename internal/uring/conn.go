@@ -0,0 +1,226 @@
+//go:build linux
+
+package uring
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Conn пакетный ввод-вывод через io_uring для дескриптора, которым управляет Go runtime
+// (UDP сокет, очередь TUN). Чтение и запись идут через разные очереди и могут выполняться
+// параллельно; параллельные чтения (или записи) выполняются по очереди
+type Conn struct {
+	raw syscall.RawConn
+	rd  half
+	wr  half
+}
+
+// half очередь одного направления
+type half struct {
+	mu   sync.Mutex
+	ring *Ring
+	// nowait false, если дескриптор не поддерживает RWF_NOWAIT: тогда без ожидания
+	// операции выполняются за счет O_NONBLOCK
+	nowait bool
+	iovs   []unix.Iovec // iovec для WritePrefixed
+}
+
+// NewConn создает очереди на entries операций для дескриптора raw
+func NewConn(raw syscall.RawConn, entries uint32) (*Conn, error) {
+	rd, err := New(entries)
+	if err != nil {
+		return nil, err
+	}
+	wr, err := New(entries)
+	if err != nil {
+		rd.Close()
+		return nil, err
+	}
+	return &Conn{
+		raw: raw,
+		rd:  half{ring: rd, nowait: true},
+		wr:  half{ring: wr, nowait: true},
+	}, nil
+}
+
+// Available проверяет, что ядро поддерживает io_uring и его не запрещает seccomp
+func Available() error {
+	r, err := New(1)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// Close освобождает очереди. Дескриптор закрывается отдельно и раньше:
+// это прерывает ожидание готовности в Read/Write
+func (c *Conn) Close() error {
+	c.rd.mu.Lock()
+	defer c.rd.mu.Unlock()
+	c.wr.mu.Lock()
+	defer c.wr.mu.Unlock()
+	return errors.Join(c.rd.ring.Close(), c.wr.ring.Close())
+}
+
+// Read читает до len(bufs) пакетов (read(2) на каждый буфер) одним io_uring_enter.
+// Ждет, пока будет прочитан хотя бы один пакет. Прочитанные буферы переставляются
+// в начало bufs, их длины записываются в sizes. Возвращает число прочитанных пакетов
+func (c *Conn) Read(bufs [][]byte, sizes []int) (int, error) {
+	h := &c.rd
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := min(len(bufs), len(sizes), h.ring.Entries())
+	done := 0
+	err := h.run(c.raw.Read, n, false, func(fd int, nowait bool) {
+		for i := 0; i < n; i++ {
+			h.ring.PrepRead(i, fd, bufs[i], nowait)
+		}
+	}, func(i int, res int32) {
+		// Операции не связаны (короткое чтение разорвало бы цепочку), поэтому между
+		// прочитанными пакетами могут быть EAGAIN: переставляем буферы без пропусков
+		bufs[done], bufs[i] = bufs[i], bufs[done]
+		sizes[done] = int(res)
+		done++
+	})
+	return done, err
+}
+
+// Write записывает пакеты (write(2) на каждый) одним io_uring_enter.
+// Ждет, пока будет записан хотя бы один пакет. Возвращает число записанных пакетов
+// (всегда префикс bufs)
+func (c *Conn) Write(bufs [][]byte) (int, error) {
+	h := &c.wr
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := min(len(bufs), h.ring.Entries())
+	done := 0
+	err := h.run(c.raw.Write, n, true, func(fd int, nowait bool) {
+		for i := 0; i < n; i++ {
+			h.ring.PrepWrite(i, fd, bufs[i], nowait)
+		}
+	}, func(int, int32) { done++ })
+	return done, err
+}
+
+// WritePrefixed то же, что Write, но перед каждым пакетом записывает prefix одним writev:
+// так к пакетам добавляется заголовок без копирования
+func (c *Conn) WritePrefixed(prefix []byte, bufs [][]byte) (int, error) {
+	h := &c.wr
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := min(len(bufs), h.ring.Entries())
+	if len(h.iovs) < 2*n {
+		h.iovs = make([]unix.Iovec, 2*h.ring.Entries())
+	}
+	for i := 0; i < n; i++ {
+		iov := h.iovs[2*i : 2*i+2]
+		iov[0] = unix.Iovec{Base: &prefix[0]}
+		iov[0].SetLen(len(prefix))
+		iov[1] = unix.Iovec{}
+		if len(bufs[i]) > 0 {
+			iov[1].Base = &bufs[i][0]
+		}
+		iov[1].SetLen(len(bufs[i]))
+	}
+	done := 0
+	err := h.run(c.raw.Write, n, true, func(fd int, nowait bool) {
+		for i := 0; i < n; i++ {
+			h.ring.PrepWritev(i, fd, h.iovs[2*i:2*i+2], nowait)
+		}
+	}, func(int, int32) { done++ })
+	return done, err
+}
+
+// Recvmsg принимает до len(msgs) сообщений одним io_uring_enter. Ждет хотя бы одно
+// сообщение. Возвращает префикс msgs: число принятых сообщений, их размеры в sizes
+func (c *Conn) Recvmsg(msgs []unix.Msghdr, sizes []int) (int, error) {
+	h := &c.rd
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := min(len(msgs), len(sizes), h.ring.Entries())
+	done := 0
+	err := h.run(c.raw.Read, n, true, func(fd int, _ bool) {
+		for i := 0; i < n; i++ {
+			h.ring.PrepRecvmsg(i, fd, &msgs[i])
+		}
+	}, func(i int, res int32) {
+		sizes[i] = int(res)
+		done++
+	})
+	return done, err
+}
+
+// Sendmsg отправляет сообщения одним io_uring_enter. Ждет, пока будет отправлено
+// хотя бы одно. Возвращает число отправленных сообщений (префикс msgs)
+func (c *Conn) Sendmsg(msgs []unix.Msghdr) (int, error) {
+	h := &c.wr
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := min(len(msgs), h.ring.Entries())
+	done := 0
+	err := h.run(c.raw.Write, n, true, func(fd int, _ bool) {
+		for i := 0; i < n; i++ {
+			h.ring.PrepSendmsg(i, fd, &msgs[i])
+		}
+	}, func(int, int32) { done++ })
+	return done, err
+}
+
+// run выполняет пачку из n операций, пока хотя бы одна не завершится успешно.
+// Если все операции вернули EAGAIN, горутина ждет готовности дескриптора в poller'е Go.
+// Ошибка возвращается, только если ни одна операция не выполнена
+func (h *half) run(wait func(func(fd uintptr) bool) error, n int, link bool, prep func(fd int, nowait bool), ok func(i int, res int32)) error {
+	if n == 0 {
+		return nil
+	}
+	var opErr error
+	err := wait(func(fd uintptr) bool {
+		for {
+			prep(int(fd), h.nowait)
+			if link {
+				h.ring.Link(n)
+			}
+			done := 0
+			var first syscall.Errno
+			if opErr = h.ring.Submit(n, func(i int, res int32) {
+				if res >= 0 {
+					ok(i, res)
+					done++
+				} else if first == 0 {
+					first = syscall.Errno(-res)
+				}
+			}); opErr != nil {
+				return true
+			}
+			switch {
+			case done > 0:
+				return true
+			case first == unix.EOPNOTSUPP && h.nowait:
+				// RWF_NOWAIT не поддерживается: повторяем, полагаясь на O_NONBLOCK
+				h.nowait = false
+				continue
+			case first == unix.EAGAIN:
+				return false
+			}
+			opErr = first
+			return true
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if opErr != nil {
+		return fmt.Errorf("io_uring: %w", opErr)
+	}
+	return nil
+}
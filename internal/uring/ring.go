@@ -0,0 +1,271 @@
+//go:build linux
+
+// Package uring минимальная обертка над io_uring для пакетного ввода-вывода: пачка
+// чтений или записей одного дескриптора отправляется ядру одним вызовом io_uring_enter.
+// Операции выполняются без ожидания (MSG_DONTWAIT, RWF_NOWAIT), а готовности дескриптора
+// ждет Go runtime, поэтому поток не блокируется в ядре, а Close дескриптора работает как обычно
+package uring
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Коды операций и флаги из linux/io_uring.h
+const (
+	opWritev  = 2
+	opSendmsg = 9
+	opRecvmsg = 10
+	opRead    = 22
+	opWrite   = 23
+
+	sqeIOLink = 1 << 2 // следующая операция выполняется, только если эта успешна
+
+	enterGetEvents = 1 << 0
+
+	offSQRing = 0
+	offCQRing = 0x8000000
+	offSQEs   = 0x10000000
+
+	rwfNowait = 0x00000008 // RWF_NOWAIT
+)
+
+// params struct io_uring_params
+type params struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        sqringOffsets
+	cqOff        cqringOffsets
+}
+
+type sqringOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type cqringOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// sqe struct io_uring_sqe
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+// cqe struct io_uring_cqe
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// Ring очередь io_uring. Не безопасна для параллельного использования: у каждого
+// дескриптора (очереди TUN, сокета) своя очередь или своя блокировка
+type Ring struct {
+	fd      int
+	sqRing  []byte
+	cqRing  []byte
+	sqeMem  []byte
+	entries uint32
+	results []int32 // результаты пачки по номерам операций
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                unsafe.Pointer
+	sqes                   unsafe.Pointer
+	cqHead, cqTail, cqMask *uint32
+	cqes                   unsafe.Pointer
+}
+
+// New создает очередь на entries операций (степень двойки, не больше 4096)
+func New(entries uint32) (*Ring, error) {
+	var p params
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &Ring{fd: int(fd), entries: p.sqEntries, results: make([]int32, p.sqEntries)}
+
+	var err error
+	if r.sqRing, err = unix.Mmap(r.fd, offSQRing, int(p.sqOff.array+p.sqEntries*4), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to map io_uring submission queue: %w", err)
+	}
+	if r.cqRing, err = unix.Mmap(r.fd, offCQRing, int(p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(cqe{}))), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to map io_uring completion queue: %w", err)
+	}
+	if r.sqeMem, err = unix.Mmap(r.fd, offSQEs, int(p.sqEntries*uint32(unsafe.Sizeof(sqe{}))), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to map io_uring entries: %w", err)
+	}
+
+	sq := unsafe.Pointer(&r.sqRing[0])
+	r.sqHead = (*uint32)(unsafe.Add(sq, p.sqOff.head))
+	r.sqTail = (*uint32)(unsafe.Add(sq, p.sqOff.tail))
+	r.sqMask = (*uint32)(unsafe.Add(sq, p.sqOff.ringMask))
+	r.sqArray = unsafe.Add(sq, p.sqOff.array)
+	r.sqes = unsafe.Pointer(&r.sqeMem[0])
+	cq := unsafe.Pointer(&r.cqRing[0])
+	r.cqHead = (*uint32)(unsafe.Add(cq, p.cqOff.head))
+	r.cqTail = (*uint32)(unsafe.Add(cq, p.cqOff.tail))
+	r.cqMask = (*uint32)(unsafe.Add(cq, p.cqOff.ringMask))
+	r.cqes = unsafe.Add(cq, p.cqOff.cqes)
+	return r, nil
+}
+
+// Entries возвращает размер очереди: больше операций за один Submit не помещается
+func (r *Ring) Entries() int {
+	return int(r.entries)
+}
+
+// Close освобождает очередь
+func (r *Ring) Close() error {
+	for _, mem := range [][]byte{r.sqeMem, r.cqRing, r.sqRing} {
+		if mem != nil {
+			unix.Munmap(mem)
+		}
+	}
+	r.sqeMem, r.cqRing, r.sqRing = nil, nil, nil
+	return unix.Close(r.fd)
+}
+
+// entry возвращает i-ю операцию текущей пачки, заполненную нулями
+func (r *Ring) entry(i int) *sqe {
+	tail := atomic.LoadUint32(r.sqTail) + uint32(i)
+	index := tail & *r.sqMask
+	*(*uint32)(unsafe.Add(r.sqArray, index*4)) = index
+	e := (*sqe)(unsafe.Add(r.sqes, uintptr(index)*unsafe.Sizeof(sqe{})))
+	*e = sqe{}
+	return e
+}
+
+// Link связывает n подготовленных операций (IOSQE_IO_LINK): после первой неудачной
+// остальные отменяются, поэтому успешные операции образуют префикс пачки.
+// Для read/write не подходит: короткое чтение тоже разрывает цепочку
+func (r *Ring) Link(n int) {
+	tail := atomic.LoadUint32(r.sqTail)
+	for i := 0; i < n-1; i++ {
+		e := (*sqe)(unsafe.Add(r.sqes, uintptr((tail+uint32(i))&*r.sqMask)*unsafe.Sizeof(sqe{})))
+		e.flags |= sqeIOLink
+	}
+}
+
+// Submit отправляет n подготовленных операций и ждет их завершения. result вызывается
+// для каждой операции по порядку пачки с числом байт или отрицательным кодом ошибки
+func (r *Ring) Submit(n int, result func(i int, res int32)) error {
+	if n == 0 {
+		return nil
+	}
+	if n > int(r.entries) {
+		return errors.New("io_uring batch is larger than the queue")
+	}
+	atomic.StoreUint32(r.sqTail, atomic.LoadUint32(r.sqTail)+uint32(n))
+
+	toSubmit := uint32(n)
+	for {
+		ready := atomic.LoadUint32(r.cqTail) - atomic.LoadUint32(r.cqHead)
+		if toSubmit == 0 && ready >= uint32(n) {
+			break
+		}
+		submitted, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), uintptr(n), enterGetEvents, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		toSubmit -= uint32(submitted)
+	}
+
+	head := atomic.LoadUint32(r.cqHead)
+	for i := 0; i < n; i++ {
+		c := (*cqe)(unsafe.Add(r.cqes, uintptr((head+uint32(i))&*r.cqMask)*unsafe.Sizeof(cqe{})))
+		r.results[c.userData] = c.res
+	}
+	atomic.StoreUint32(r.cqHead, head+uint32(n))
+	for i, res := range r.results[:n] {
+		result(i, res)
+	}
+	return nil
+}
+
+// PrepRead готовит i-ю операцию пачки: read(2) в buf без ожидания
+func (r *Ring) PrepRead(i int, fd int, buf []byte, nowait bool) {
+	r.prepRW(i, opRead, fd, buf, nowait)
+}
+
+// PrepWrite готовит i-ю операцию пачки: write(2) из buf без ожидания
+func (r *Ring) PrepWrite(i int, fd int, buf []byte, nowait bool) {
+	r.prepRW(i, opWrite, fd, buf, nowait)
+}
+
+func (r *Ring) prepRW(i int, op uint8, fd int, buf []byte, nowait bool) {
+	e := r.entry(i)
+	e.opcode = op
+	e.fd = int32(fd)
+	if len(buf) > 0 {
+		e.addr = uint64(uintptr(unsafe.Pointer(&buf[0])))
+	}
+	e.len = uint32(len(buf))
+	if nowait {
+		e.opFlags = rwfNowait
+	}
+	e.userData = uint64(i)
+}
+
+// PrepWritev готовит i-ю операцию пачки: writev(2) из iovs без ожидания
+func (r *Ring) PrepWritev(i int, fd int, iovs []unix.Iovec, nowait bool) {
+	e := r.entry(i)
+	e.opcode = opWritev
+	e.fd = int32(fd)
+	e.addr = uint64(uintptr(unsafe.Pointer(&iovs[0])))
+	e.len = uint32(len(iovs))
+	if nowait {
+		e.opFlags = rwfNowait
+	}
+	e.userData = uint64(i)
+}
+
+// PrepRecvmsg готовит i-ю операцию пачки: recvmsg(2) с MSG_DONTWAIT
+func (r *Ring) PrepRecvmsg(i int, fd int, msg *unix.Msghdr) {
+	r.prepMsg(i, opRecvmsg, fd, msg)
+}
+
+// PrepSendmsg готовит i-ю операцию пачки: sendmsg(2) с MSG_DONTWAIT
+func (r *Ring) PrepSendmsg(i int, fd int, msg *unix.Msghdr) {
+	r.prepMsg(i, opSendmsg, fd, msg)
+}
+
+func (r *Ring) prepMsg(i int, op uint8, fd int, msg *unix.Msghdr) {
+	e := r.entry(i)
+	e.opcode = op
+	e.fd = int32(fd)
+	e.addr = uint64(uintptr(unsafe.Pointer(msg)))
+	e.len = 1
+	e.opFlags = unix.MSG_DONTWAIT
+	e.userData = uint64(i)
+}
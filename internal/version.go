@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// Version версия сборки. Задается при сборке:
+// go build -ldflags "-X myvpn/internal.Version=1.4.0" ./cmd/server
+var Version = "dev"
+
+// BuildVersion возвращает Version, а для сборок без нее - ревизию git из сведений о сборке
+func BuildVersion() string {
+	if Version != "dev" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Version
+	}
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if revision == "" {
+		return Version
+	}
+	return Version + "-" + revision + modified
+}
+
+// ProtocolVersion версия протокола этой сборки. Клиенты и серверы обмениваются версиями
+// при запросе конфигурации. Клиенты, которые не сообщают версию (до появления
+// согласования), считаются клиентами версии 0
+const ProtocolVersion uint8 = 2
+
+// AuthKeepaliveVersion версия протокола, с которой keepalive и пробы PMTU шифруются.
+// Сервер с минимальной версией не ниже этой не принимает открытые keepalive
+const AuthKeepaliveVersion uint8 = 2
+
+// Возможности протокола: биты, которыми стороны сообщают, что умеют обрабатывать.
+// Новую возможность сторона использует, только если ее бит есть у другой стороны
+const (
+	// CapCompression согласование кодеков сжатия
+	CapCompression uint64 = 1 << iota
+	// CapObfuscation дополнение пакетов и пакеты-пустышки
+	CapObfuscation
+	// CapFragments фрагментация пакетов больше PMTU
+	CapFragments
+	// CapPMTU пробы PMTU
+	CapPMTU
+	// CapFEC избыточные пакеты FEC
+	CapFEC
+	// CapMultipath bonding нескольких путей
+	CapMultipath
+	// CapDisconnect сообщение об отключении (transport.PacketTypeDisconnect)
+	CapDisconnect
+	// CapAuthKeepalive зашифрованные keepalive и пробы PMTU
+	CapAuthKeepalive
+	// CapRTT ответ на keepalive несет его sequence, и сервер сам шлет keepalive для измерения RTT
+	CapRTT
+)
+
+// Capabilities возможности, которые поддерживает эта сборка
+const Capabilities = CapCompression | CapObfuscation | CapFragments | CapPMTU | CapFEC | CapMultipath | CapDisconnect | CapAuthKeepalive | CapRTT
+
+// capabilityNames имена возможностей для логов, по порядку битов
+var capabilityNames = []string{"compress", "obfs", "fragments", "pmtu", "fec", "multipath", "disconnect", "auth-keepalive", "rtt"}
+
+// FormatCapabilities возвращает имена возможностей через запятую, неизвестные биты пропускаются
+func FormatCapabilities(caps uint64) string {
+	var names []string
+	for i, name := range capabilityNames {
+		if caps&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
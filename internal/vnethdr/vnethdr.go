@@ -0,0 +1,273 @@
+//go:build linux
+
+// Package vnethdr заголовок virtio-net TUN устройства с IFF_VNET_HDR. С TSO ядро отдает
+// в TUN один большой TCP сегмент (до 64 КБ) вместо нескольких пакетов по MTU, а с checksum
+// offload не досчитывает контрольную сумму. Segmenter раскладывает такой сегмент обратно
+// на обычные IP пакеты с готовыми контрольными суммами, которые можно отправить в туннель
+package vnethdr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Size размер struct virtio_net_hdr
+	Size = 10
+	// MaxPacket размер буфера чтения из TUN: заголовок и сегмент до 64 КБ
+	MaxPacket = Size + 65535
+
+	flagNeedsCsum = 1 // VIRTIO_NET_HDR_F_NEEDS_CSUM
+
+	gsoNone  = 0 // VIRTIO_NET_HDR_GSO_NONE
+	gsoTCPv4 = 1 // VIRTIO_NET_HDR_GSO_TCPV4
+	gsoTCPv6 = 4 // VIRTIO_NET_HDR_GSO_TCPV6
+	gsoECN   = 0x80
+
+	tcpFlagFIN = 0x01
+	tcpFlagPSH = 0x08
+	tcpFlagCWR = 0x80
+)
+
+// Offload флаги TUNSETOFFLOAD: контрольные суммы и TSO для IPv4 и IPv6
+const Offload = unix.TUN_F_CSUM | unix.TUN_F_TSO4 | unix.TUN_F_TSO6
+
+// empty заголовок записываемых пакетов: без GSO, контрольная сумма готова
+var empty [Size]byte
+
+// header struct virtio_net_hdr (порядок байт хоста)
+type header struct {
+	flags      uint8
+	gsoType    uint8
+	hdrLen     uint16
+	gsoSize    uint16
+	csumStart  uint16
+	csumOffset uint16
+}
+
+func decode(b []byte) header {
+	return header{
+		flags:      b[0],
+		gsoType:    b[1],
+		hdrLen:     binary.NativeEndian.Uint16(b[2:]),
+		gsoSize:    binary.NativeEndian.Uint16(b[4:]),
+		csumStart:  binary.NativeEndian.Uint16(b[6:]),
+		csumOffset: binary.NativeEndian.Uint16(b[8:]),
+	}
+}
+
+// Segmenter раскладывает пакет, прочитанный из TUN вместе с заголовком, на IP пакеты
+type Segmenter struct {
+	pkt     []byte // пакет без заголовка virtio-net
+	single  bool   // пакет без GSO, еще не выдан
+	ipv4    bool
+	l4      int // начало TCP заголовка
+	hdrLen  int // IP и TCP заголовки
+	gsoSize int
+	pos     int // начало данных следующего сегмента
+	index   int
+}
+
+// Reset начинает разбор пакета buf (заголовок virtio-net и IP пакет). Сегменты
+// ссылаются на buf, поэтому он не меняется, пока Next не вернет false
+func (s *Segmenter) Reset(buf []byte) error {
+	*s = Segmenter{}
+	if len(buf) < Size {
+		return errors.New("packet shorter than virtio-net header")
+	}
+	h := decode(buf)
+	pkt := buf[Size:]
+
+	if h.gsoType == gsoNone {
+		if h.flags&flagNeedsCsum != 0 {
+			start, field := int(h.csumStart), int(h.csumStart)+int(h.csumOffset)
+			if field+2 > len(pkt) {
+				return fmt.Errorf("checksum offset %d outside of %d byte packet", field, len(pkt))
+			}
+			// В поле уже лежит сумма псевдозаголовка, досчитываем остальное
+			binary.BigEndian.PutUint16(pkt[field:], ^fold(sum(pkt[start:], 0)))
+		}
+		s.pkt, s.single = pkt, true
+		return nil
+	}
+
+	switch h.gsoType &^ gsoECN {
+	case gsoTCPv4:
+		s.ipv4 = true
+	case gsoTCPv6:
+	default:
+		return fmt.Errorf("unsupported GSO type %d", h.gsoType)
+	}
+	s.l4 = int(h.csumStart)
+	if s.l4+20 > len(pkt) || h.gsoSize == 0 {
+		return errors.New("malformed GSO packet")
+	}
+	s.hdrLen = s.l4 + int(pkt[s.l4+12]>>4)*4
+	if s.hdrLen > len(pkt) || (s.ipv4 && s.l4 < 20) || (!s.ipv4 && s.l4 < 40) {
+		return errors.New("malformed GSO packet")
+	}
+	s.pkt = pkt
+	s.gsoSize = int(h.gsoSize)
+	s.pos = s.hdrLen
+	return nil
+}
+
+// Next записывает в dst следующий IP пакет и возвращает его размер.
+// false - пакеты закончились (или следующий не помещается в dst)
+func (s *Segmenter) Next(dst []byte) (int, bool) {
+	if s.single {
+		s.single = false
+		if len(s.pkt) > len(dst) {
+			return 0, false
+		}
+		return copy(dst, s.pkt), true
+	}
+	if s.gsoSize == 0 || s.pos >= len(s.pkt) {
+		return 0, false
+	}
+	end := min(s.pos+s.gsoSize, len(s.pkt))
+	size := s.hdrLen + end - s.pos
+	if size > len(dst) {
+		return 0, false
+	}
+	seg := dst[:size]
+	copy(seg, s.pkt[:s.hdrLen])
+	copy(seg[s.hdrLen:], s.pkt[s.pos:end])
+	last := end == len(s.pkt)
+
+	if s.ipv4 {
+		binary.BigEndian.PutUint16(seg[2:], uint16(size))
+		binary.BigEndian.PutUint16(seg[4:], binary.BigEndian.Uint16(s.pkt[4:])+uint16(s.index))
+		binary.BigEndian.PutUint16(seg[10:], 0)
+		ihl := int(seg[0]&0x0f) * 4
+		binary.BigEndian.PutUint16(seg[10:], ^fold(sum(seg[:ihl], 0)))
+	} else {
+		binary.BigEndian.PutUint16(seg[4:], uint16(size-40))
+	}
+
+	tcp := seg[s.l4:]
+	binary.BigEndian.PutUint32(tcp[4:], binary.BigEndian.Uint32(s.pkt[s.l4+4:])+uint32(s.pos-s.hdrLen))
+	if !last {
+		tcp[13] &^= tcpFlagFIN | tcpFlagPSH
+	}
+	if s.index > 0 {
+		tcp[13] &^= tcpFlagCWR
+	}
+	binary.BigEndian.PutUint16(tcp[16:], 0)
+	binary.BigEndian.PutUint16(tcp[16:], ^fold(sum(tcp, s.pseudoHeader(seg, len(tcp)))))
+
+	s.pos = end
+	s.index++
+	return size, true
+}
+
+// pseudoHeader сумма псевдозаголовка TCP сегмента длиной length
+func (s *Segmenter) pseudoHeader(seg []byte, length int) uint32 {
+	var acc uint32
+	if s.ipv4 {
+		acc = sum(seg[12:20], 0)
+	} else {
+		acc = sum(seg[8:40], 0)
+	}
+	return acc + unix.IPPROTO_TCP + uint32(length)
+}
+
+// sum добавляет к acc сумму 16-битных слов b (RFC 1071)
+func sum(b []byte, acc uint32) uint32 {
+	for len(b) >= 2 {
+		acc += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		acc += uint32(b[0]) << 8
+	}
+	return acc
+}
+
+// fold сворачивает сумму в 16 бит
+func fold(acc uint32) uint16 {
+	for acc > 0xffff {
+		acc = acc>>16 + acc&0xffff
+	}
+	return uint16(acc)
+}
+
+// Reader читает из TUN пакеты с заголовком virtio-net и выдает их по одному IP пакету.
+// У каждой очереди TUN свой Reader
+type Reader struct {
+	buf []byte
+	seg Segmenter
+}
+
+// NewReader создает Reader с буфером на MaxPacket байт
+func NewReader() *Reader {
+	return &Reader{buf: make([]byte, MaxPacket)}
+}
+
+// ReadPacket записывает в packet следующий IP пакет, при необходимости читая из src
+func (r *Reader) ReadPacket(src io.Reader, packet []byte) (int, error) {
+	for {
+		if size, ok := r.seg.Next(packet); ok {
+			return size, nil
+		}
+		if err := r.fill(src); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// ReadBatch записывает в bufs IP пакеты, оставшиеся от прочитанного сегмента, или,
+// если их нет, читает из src новый. Длины пакетов записываются в sizes
+func (r *Reader) ReadBatch(src io.Reader, bufs [][]byte, sizes []int) (int, error) {
+	for {
+		n := 0
+		for n < len(bufs) && n < len(sizes) {
+			size, ok := r.seg.Next(bufs[n])
+			if !ok {
+				break
+			}
+			sizes[n] = size
+			n++
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if err := r.fill(src); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// fill читает из src пакет с заголовком и начинает его разбор
+func (r *Reader) fill(src io.Reader) error {
+	n, err := src.Read(r.buf)
+	if err != nil {
+		return err
+	}
+	return r.seg.Reset(r.buf[:n])
+}
+
+// Write записывает в дескриптор TUN пакет с пустым заголовком virtio-net одним writev
+func Write(fd int, packet []byte) (int, error) {
+	iov := [2]unix.Iovec{{Base: &empty[0]}, {}}
+	iov[0].SetLen(Size)
+	if len(packet) > 0 {
+		iov[1].Base = &packet[0]
+		iov[1].SetLen(len(packet))
+	}
+	n, _, errno := unix.Syscall(unix.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iov[0])), 2)
+	if errno != 0 {
+		return 0, errno
+	}
+	return max(int(n)-Size, 0), nil
+}
+
+// Header возвращает пустой заголовок virtio-net для записи пакетов (writev). Не изменяется
+func Header() []byte {
+	return empty[:]
+}
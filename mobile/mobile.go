@@ -0,0 +1,388 @@
+// Package mobile встраивает клиент в приложение Android или iOS через `gomobile bind`.
+// Приложение открывает TUN через VpnService.Builder или NEPacketTunnelProvider (адреса,
+// маршруты и DNS задает оно), передает дескриптор в Start или пакеты через StartPackets
+// и получает состояние через Callback. Клиент в этом режиме не запускает внешних команд
+// и не меняет маршруты, firewall и DNS системы. На Android сокеты к серверу выводятся
+// из VPN через Protector (VpnService.protect), на iOS это делает сама NetworkExtension
+package mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"myvpn/client"
+	"myvpn/internal"
+	"myvpn/internal/compress"
+	"myvpn/internal/logging"
+	"myvpn/internal/porthop"
+	"myvpn/internal/transport"
+)
+
+// statePollInterval период проверки состояния клиента для Callback.OnStateChanged
+const statePollInterval = time.Second
+
+// Protector выводит сокет из VPN: реализация вызывает VpnService.protect(fd)
+type Protector interface {
+	Protect(fd int) bool
+}
+
+// Callback события клиента. Методы вызываются из горутин клиента, не из главного потока
+type Callback interface {
+	// OnStateChanged сообщает новое состояние: connecting, connected, reconnecting или closed
+	OnStateChanged(state string)
+	// OnLog передает строку журнала клиента
+	OnLog(line string)
+}
+
+// PacketFlow принимает пакеты от сервера для системы: реализация вызывает
+// NEPacketTunnelFlow.writePackets. Пакет действителен только до возврата из WritePacket
+type PacketFlow interface {
+	WritePacket(packet []byte)
+}
+
+// Config настройки клиента. Ключи JSON совпадают с флагами клиента командной строки,
+// поэтому подходит и конфигурация из QR кода `server client-config`
+type Config struct {
+	Server          string `json:"server"`
+	KeyData         string `json:"key-data"` // общий ключ в hex или base64
+	PrivateKeyData  string `json:"private-key-data"`
+	ServerPublicKey string `json:"server-public-key"`
+	IP              string `json:"ip"`  // адрес TUN, который назначило приложение
+	IP6             string `json:"ip6"` // пусто - без IPv6
+	Transports      string `json:"transports"`
+	TCPAddr         string `json:"tcp-addr"`
+	TCPTLS          bool   `json:"tcp-tls"`
+	WSSURL          string `json:"wss-url"`
+	WSSInsecure     bool   `json:"wss-insecure"`
+	HTTPProxy       string `json:"http-proxy"`
+	TLSSNI          string `json:"tls-sni"`
+	TLSFingerprint  string `json:"tls-fingerprint"`
+	KCPAddr         string `json:"kcp-addr"`
+	KCPFEC          string `json:"kcp-fec"`
+	GRPCAddr        string `json:"grpc-addr"`
+	GRPCService     string `json:"grpc-service"`
+	DNSTunnelZone   string `json:"dns-tunnel-zone"`
+	DNSResolver     string `json:"dns-tunnel-resolver"`
+	FEC             string `json:"fec"`
+	Compress        string `json:"compress"`
+	ObfsPad         int    `json:"obfs-pad"`
+	PortHop         string `json:"port-hop"`
+	User            string `json:"user"`
+	Password        string `json:"password"`
+	LogLevel        string `json:"log-level"`
+}
+
+var (
+	mu      sync.Mutex
+	running *tunnel
+)
+
+// tunnel работающий клиент и горутина, сообщающая о его состоянии
+type tunnel struct {
+	client *client.VPNClient
+	device *client.PacketDevice // nil, если пакеты идут через дескриптор TUN
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewConfig возвращает пустые настройки для заполнения полями из приложения
+func NewConfig() *Config {
+	return &Config{}
+}
+
+// ParseConfig разбирает настройки в JSON
+func ParseConfig(configJSON string) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal([]byte(configJSON), cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Start подключается к серверу по настройкам configJSON и передает пакеты через
+// дескриптор TUN tunFD, который возвращает VpnService.Builder.establish().detachFd().
+// Дескриптор закрывает клиент при Stop. Одновременно работает только один клиент
+func Start(configJSON string, tunFD int, protector Protector, callback Callback) error {
+	if protector == nil {
+		return errors.New("protector is required")
+	}
+	cfg, err := ParseConfig(configJSON)
+	if err != nil {
+		return err
+	}
+	return StartWithConfig(cfg, tunFD, protector, callback)
+}
+
+// StartWithConfig как Start, но с настройками cfg. protector может быть nil, если система
+// сама выводит сокеты клиента из VPN (NetworkExtension на iOS). На iOS tunFD дескриптор
+// utun из packetFlow провайдера
+func StartWithConfig(cfg *Config, tunFD int, protector Protector, callback Callback) error {
+	if tunFD <= 0 {
+		return fmt.Errorf("invalid TUN descriptor %d", tunFD)
+	}
+	return start(cfg, callback, func(clientCfg *client.Config) {
+		clientCfg.TUNFD = tunFD
+		if protector != nil {
+			clientCfg.Protect = func(fd int) error {
+				if !protector.Protect(fd) {
+					return errors.New("VpnService.protect failed")
+				}
+				return nil
+			}
+		}
+	})
+}
+
+// StartPackets подключается к серверу без дескриптора TUN: пакеты от сервера передаются
+// в flow, а пакеты из системы приложение передает через InjectPacket. Подходит для
+// NEPacketTunnelFlow.readPackets и writePackets на iOS
+func StartPackets(cfg *Config, flow PacketFlow, callback Callback) error {
+	if flow == nil {
+		return errors.New("packet flow is required")
+	}
+	return start(cfg, callback, func(clientCfg *client.Config) {
+		clientCfg.Device = client.NewPacketDevice("packet-flow", func(packet []byte) error {
+			flow.WritePacket(packet)
+			return nil
+		})
+	})
+}
+
+// InjectPacket передает клиенту IP пакет из системы после StartPackets. Пакет копируется
+func InjectPacket(packet []byte) error {
+	mu.Lock()
+	t := running
+	mu.Unlock()
+	if t == nil || t.device == nil {
+		return errors.New("client is not running in packet mode")
+	}
+	return t.device.Inject(packet)
+}
+
+// start запускает клиент с настройками cfg. setup задает, откуда клиент берет пакеты
+func start(cfg *Config, callback Callback, setup func(*client.Config)) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if running != nil {
+		return errors.New("client is already running")
+	}
+	if cfg == nil || callback == nil {
+		return errors.New("config and callback are required")
+	}
+
+	clientCfg, err := cfg.clientConfig()
+	if err != nil {
+		return err
+	}
+	setup(&clientCfg)
+
+	level := cfg.LogLevel
+	if level == "" {
+		level = "info"
+	}
+	if err := logging.Setup(logWriter{callback}, level, logging.FormatText); err != nil {
+		return err
+	}
+
+	c, err := client.NewVPNClient(clientCfg)
+	if err != nil {
+		return err
+	}
+	t := &tunnel{client: c, stop: make(chan struct{}), done: make(chan struct{})}
+	t.device, _ = clientCfg.Device.(*client.PacketDevice)
+	running = t
+	go func() {
+		if err := c.Connect(); err != nil {
+			callback.OnLog("Connection failed: " + err.Error())
+			c.Close()
+		}
+	}()
+	go t.watch(callback)
+	return nil
+}
+
+// Stop отключается от сервера и закрывает дескриптор TUN. Без работающего клиента
+// ничего не делает
+func Stop() error {
+	mu.Lock()
+	t := running
+	running = nil
+	mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	err := t.client.Close()
+	close(t.stop)
+	<-t.done
+	return err
+}
+
+// Status возвращает состояние и статистику клиента одним объектом JSON с полями
+// команды `client status -json`. Без работающего клиента возвращает состояние closed
+func Status() string {
+	mu.Lock()
+	t := running
+	mu.Unlock()
+	report := struct {
+		client.Status
+		client.Stats
+	}{Status: client.Status{State: client.StateClosed}}
+	if t != nil {
+		report.Status, report.Stats = t.client.Status(), t.client.Stats()
+	}
+	data, _ := json.Marshal(report)
+	return string(data)
+}
+
+// Reconnect пересоздает транспорт до сервера, например после смены сети в ConnectivityManager
+func Reconnect() {
+	mu.Lock()
+	t := running
+	mu.Unlock()
+	if t != nil {
+		t.client.Reconnect()
+	}
+}
+
+// watch сообщает callback о смене состояния клиента до его остановки
+func (t *tunnel) watch(callback Callback) {
+	defer close(t.done)
+	ticker := time.NewTicker(statePollInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		if state := t.client.Status().State; state != last {
+			last = state
+			callback.OnStateChanged(state)
+		}
+		select {
+		case <-t.stop:
+			callback.OnStateChanged(client.StateClosed)
+			return
+		case <-t.client.Done():
+			// Клиент остановился сам (ошибка TUN или подключения): освобождаем место для Start
+			mu.Lock()
+			if running == t {
+				running = nil
+			}
+			mu.Unlock()
+			callback.OnStateChanged(client.StateClosed)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// clientConfig переводит настройки приложения в настройки клиента. Все, что требует
+// прав root или внешних команд, выключено
+func (cfg *Config) clientConfig() (client.Config, error) {
+	if cfg.Server == "" {
+		return client.Config{}, errors.New("server address is required")
+	}
+	if cfg.IP == "" || cfg.IP == client.AutoAddress {
+		return client.Config{}, errors.New("ip is required: the TUN address is set by the app before the client starts")
+	}
+	key, err := cfg.key()
+	if err != nil {
+		return client.Config{}, err
+	}
+	mode := cfg.Compress
+	if mode == "" {
+		mode = "auto"
+	}
+	codec, compressionOn, err := compress.ParseMode(mode)
+	if err != nil {
+		return client.Config{}, fmt.Errorf("invalid compress: %w", err)
+	}
+	kinds := cfg.Transports
+	if kinds == "" {
+		kinds = transport.KindUDP
+	}
+	transports, err := transport.ParseKinds(kinds)
+	if err != nil {
+		return client.Config{}, fmt.Errorf("invalid transports: %w", err)
+	}
+	kcpFEC := cfg.KCPFEC
+	if kcpFEC == "" {
+		kcpFEC = "10/3"
+	}
+	fec, err := transport.ParseFEC(kcpFEC)
+	if err != nil {
+		return client.Config{}, fmt.Errorf("invalid kcp-fec: %w", err)
+	}
+	udpFEC, err := transport.ParseFEC(cfg.FEC)
+	if err != nil {
+		return client.Config{}, fmt.Errorf("invalid fec: %w", err)
+	}
+	hop, err := porthop.ParseRange(cfg.PortHop)
+	if err != nil {
+		return client.Config{}, fmt.Errorf("invalid port-hop: %w", err)
+	}
+
+	return client.Config{
+		ServerAddr:         cfg.Server,
+		Key:                key,
+		ClientIP:           strings.Split(cfg.IP, "/")[0],
+		ClientIP6:          strings.Split(cfg.IP6, "/")[0],
+		Compression:        codec,
+		DisableCompression: !compressionOn,
+		Obfuscation:        transport.Obfuscation{PadBucket: cfg.ObfsPad},
+		PortHop:            hop,
+		PortHopInterval:    porthop.DefaultInterval,
+		FEC:                udpFEC,
+		Transports:         transports,
+		TCPAddr:            cfg.TCPAddr,
+		TCPTLS:             cfg.TCPTLS,
+		WSSURL:             cfg.WSSURL,
+		WSSInsecure:        cfg.WSSInsecure,
+		HTTPProxy:          cfg.HTTPProxy,
+		TLSServerName:      cfg.TLSSNI,
+		TLSFingerprint:     cfg.TLSFingerprint,
+		KCPAddr:            cfg.KCPAddr,
+		KCPFEC:             fec,
+		GRPCAddr:           cfg.GRPCAddr,
+		GRPCService:        cfg.GRPCService,
+		DNSTunnelZone:      cfg.DNSTunnelZone,
+		DNSTunnelResolver:  cfg.DNSResolver,
+		Username:           cfg.User,
+		Password:           cfg.Password,
+	}, nil
+}
+
+// key возвращает ключ сессии: общий ключ или выведенный из закрытого ключа клиента
+// и открытого ключа сервера
+func (cfg *Config) key() ([]byte, error) {
+	if cfg.PrivateKeyData == "" {
+		key, err := internal.ParseSharedKey([]byte(cfg.KeyData))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key-data: %w", err)
+		}
+		return key, nil
+	}
+	if cfg.ServerPublicKey == "" {
+		return nil, errors.New("private-key-data requires server-public-key")
+	}
+	private, err := internal.ParseKey(cfg.PrivateKeyData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private-key-data: %w", err)
+	}
+	server, err := internal.ParseKey(cfg.ServerPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server-public-key: %w", err)
+	}
+	return internal.StaticKey(private, server, false)
+}
+
+// logWriter передает строки журнала в Callback.OnLog
+type logWriter struct {
+	callback Callback
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.callback.OnLog(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
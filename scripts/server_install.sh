@@ -124,8 +124,11 @@ Description=MyVPN Server (over Xray-core)
 After=network.target xray.service
 
 [Service]
+Type=notify
 ExecStart=$OPT_DIR/myvpn-server -key $OPT_DIR/vpn.key -addr 127.0.0.1:8080
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
+WatchdogSec=30
 User=root
 
 [Install]
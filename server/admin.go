@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"myvpn/adminrpc"
+	"myvpn/internal"
+	"myvpn/internal/logging"
+	"myvpn/internal/peerdb"
+	"myvpn/internal/ratelimit"
+)
+
+// DefaultPeer имя пира для ключа, заданного флагом -key
+const DefaultPeer = "default"
+
+// ClientInfo сведения о подключенном клиенте для admin API
+type ClientInfo = adminrpc.ClientInfo
+
+// Status общее состояние сервера
+type Status = adminrpc.Status
+
+// RateLimit ограничение скорости клиента в битах в секунду
+type RateLimit = adminrpc.RateLimit
+
+// Clients возвращает сведения о всех клиентских сессиях
+func (s *Server) Clients() []ClientInfo {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	infos := make([]ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		infos = append(infos, s.clientInfo(client))
+	}
+	return infos
+}
+
+// Info возвращает сведения о клиенте
+func (c *Client) Info() ClientInfo {
+	return ClientInfo{
+		SessionID:     fmt.Sprintf("%016x", c.sessionID),
+		Peer:          c.peer,
+		User:          c.User(),
+		RemoteAddr:    c.RemoteAddr().String(),
+		VirtualIP:     c.VirtualIP(),
+		RxPackets:     c.rxPackets.Load(),
+		RxBytes:       c.rxBytes.Load(),
+		TxPackets:     c.txPackets.Load(),
+		TxBytes:       c.txBytes.Load(),
+		LastSeen:      time.Unix(0, c.lastSeen.Load()),
+		Connected:     c.connected,
+		LastHandshake: unixTime(c.handshake.Load()),
+		DecryptErrors: c.authErrors.Load(),
+	}
+}
+
+// clientInfo возвращает сведения о клиенте вместе с RTT и потерями его сессии
+func (s *Server) clientInfo(client *Client) ClientInfo {
+	info := client.Info()
+	if s.transport == nil {
+		return info
+	}
+	if rtt, ok := s.transport.RTT(client.sessionID); ok && rtt.Samples > 0 {
+		info.RTTMs = milliseconds(rtt.RTT)
+		info.JitterMs = milliseconds(rtt.Jitter)
+		info.Loss = rtt.Loss
+	}
+	return info
+}
+
+// milliseconds переводит длительность в миллисекунды с дробной частью
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// unixTime переводит время в UnixNano в time.Time (0 - нулевое время)
+func unixTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Status возвращает состояние сервера
+func (s *Server) Status() Status {
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+
+	return Status{
+		ListenAddr:    s.listenAddr,
+		TUNInterface:  s.tun.Name(),
+		UptimeSeconds: time.Since(s.startTime).Seconds(),
+		Clients:       clients,
+		Peers:         len(s.keyring.Names()),
+	}
+}
+
+// DisconnectClient удаляет сессию клиента. Возвращает false, если сессия не найдена
+func (s *Server) DisconnectClient(sessionID uint64) bool {
+	s.clientsMu.Lock()
+	client, ok := s.clients[sessionID]
+	if ok {
+		s.removeClientLocked(client)
+	}
+	s.clientsMu.Unlock()
+
+	if ok {
+		s.keyring.ForgetSession(sessionID)
+		s.transport.ForgetSession(sessionID)
+		s.publish(adminrpc.EventDisconnected, client)
+		logServer.Info("Client disconnected", "remote", client.RemoteAddr(), logging.Session(sessionID))
+	}
+	return ok
+}
+
+// removeClientLocked удаляет клиента из таблиц сервера. Требует s.clientsMu
+func (s *Server) removeClientLocked(client *Client) {
+	delete(s.clients, client.sessionID)
+	delete(s.params, client.sessionID)
+	s.pool.release(client.sessionID)
+	for ip, c := range s.clientsByIP {
+		if c == client {
+			delete(s.clientsByIP, ip)
+		}
+	}
+	if s.peerClients[client.peer] == client {
+		delete(s.peerClients, client.peer)
+	}
+	client.Close()
+	s.forgetIntroductions(client.sessionID)
+}
+
+// Peers возвращает имена пиров, чьи ключи принимает сервер
+func (s *Server) Peers() []string {
+	return s.keyring.Names()
+}
+
+// AddPeer добавляет ключ пира
+func (s *Server) AddPeer(name string, key []byte) error {
+	if name == "" {
+		return errors.New("peer name is required")
+	}
+	if s.keyring.Has(name) || s.keyPeer(name) != nil {
+		return fmt.Errorf("peer %q already exists", name)
+	}
+	crypto, err := internal.NewCrypto(key)
+	if err != nil {
+		return err
+	}
+	if s.peerDB != nil {
+		if _, err := s.peerDB.Create(peerdb.Peer{Name: name, Key: hex.EncodeToString(key)}); err != nil {
+			return err
+		}
+	}
+	s.keyring.Add(name, crypto)
+	logAdmin.Info("Peer added", "peer", name)
+	return nil
+}
+
+// RevokePeer отзывает ключ пира и разрывает все его сессии. Пир из базы помечается
+// отозванным, и его имя и ключ больше нельзя использовать. Возвращает false, если пир не найден
+func (s *Server) RevokePeer(name string) (bool, error) {
+	var stored bool
+	if s.peerDB != nil {
+		_, err := s.peerDB.SetStatus(name, peerdb.StatusRevoked)
+		if err != nil && !errors.Is(err, peerdb.ErrNotFound) && !errors.Is(err, peerdb.ErrRevoked) {
+			return false, err
+		}
+		stored = err == nil
+	}
+	sessions, ok := s.keyring.Remove(name)
+	if !ok && !stored {
+		return false, nil
+	}
+	for _, sessionID := range sessions {
+		s.DisconnectClient(sessionID)
+	}
+	logAdmin.Info("Peer revoked", "peer", name, "sessions_closed", len(sessions))
+	return true, nil
+}
+
+// parsePeerKey декодирует hex ключ пира, а для пустой строки генерирует новый
+func parsePeerKey(hexKey string) ([]byte, error) {
+	if hexKey == "" {
+		key := make([]byte, internal.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return key, nil
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != internal.KeySize {
+		return nil, fmt.Errorf("key must be %d hex characters", internal.KeySize*2)
+	}
+	return key, nil
+}
+
+// DNSServers возвращает DNS серверы, которые передаются клиентам
+func (s *Server) DNSServers() []string {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.clientDNS()
+}
+
+// SetDNSServers заменяет DNS серверы для клиентов. Применяется при следующем запросе конфигурации
+func (s *Server) SetDNSServers(servers []string) {
+	s.configMu.Lock()
+	s.dnsServers = servers
+	s.configMu.Unlock()
+	logAdmin.Info("DNS servers for clients updated", "dns", servers)
+}
+
+// limitFor возвращает лимит скорости для сессий пира
+func (s *Server) limitFor(peer string) RateLimit {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	if limit, ok := s.peerLimits[peer]; ok {
+		return limit
+	}
+	return s.defaultLimit
+}
+
+// PeerLimit возвращает лимит скорости пира
+func (s *Server) PeerLimit(name string) (RateLimit, bool) {
+	if !s.keyring.Has(name) {
+		return RateLimit{}, false
+	}
+	return s.limitFor(name), true
+}
+
+// SetPeerLimit задает лимит скорости пира и применяет его к уже подключенным сессиям.
+// Возвращает false, если пир не найден
+func (s *Server) SetPeerLimit(name string, limit RateLimit) bool {
+	if !s.keyring.Has(name) {
+		return false
+	}
+
+	s.configMu.Lock()
+	s.peerLimits[name] = limit
+	s.configMu.Unlock()
+
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		if client.peer == name {
+			client.setLimit(limit)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	logAdmin.Info("Peer rate limit set", "peer", name,
+		"up", ratelimit.FormatRate(limit.Up), "down", ratelimit.FormatRate(limit.Down))
+	return true
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"myvpn/adminrpc"
+	"myvpn/internal/peerdb"
+	"myvpn/internal/totp"
+)
+
+// APIHandler возвращает HTTP обработчик admin API.
+// Все запросы должны содержать заголовок "Authorization: Bearer <token>"
+func (s *Server) APIHandler(token string) http.Handler {
+	mux := s.apiMux()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// apiMux маршруты admin API без проверки токена
+func (s *Server) apiMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/status", s.apiStatus)
+	mux.HandleFunc("GET /api/v1/clients", s.apiListClients)
+	mux.HandleFunc("DELETE /api/v1/clients/{session}", s.apiDisconnectClient)
+	mux.HandleFunc("GET /api/v1/bans", s.apiListBans)
+	mux.HandleFunc("DELETE /api/v1/bans/{peer}", s.apiLiftBan)
+	mux.HandleFunc("GET /api/v1/peers", s.apiListPeers)
+	mux.HandleFunc("POST /api/v1/peers", s.apiAddPeer)
+	mux.HandleFunc("GET /api/v1/peers/{name}", s.apiGetPeer)
+	mux.HandleFunc("DELETE /api/v1/peers/{name}", s.apiRevokePeer)
+	mux.HandleFunc("POST /api/v1/peers/{name}/disable", s.apiDisablePeer)
+	mux.HandleFunc("POST /api/v1/peers/{name}/enable", s.apiEnablePeer)
+	mux.HandleFunc("GET /api/v1/peers/{name}/limit", s.apiGetPeerLimit)
+	mux.HandleFunc("PUT /api/v1/peers/{name}/limit", s.apiSetPeerLimit)
+	mux.HandleFunc("POST /api/v1/peers/{name}/totp", s.apiEnableTOTP)
+	mux.HandleFunc("DELETE /api/v1/peers/{name}/totp", s.apiDisableTOTP)
+	mux.HandleFunc("POST /api/v1/reload", s.apiReload)
+	mux.HandleFunc("GET /api/v1/capture", s.apiGetCapture)
+	mux.HandleFunc("POST /api/v1/capture", s.apiStartCapture)
+	mux.HandleFunc("DELETE /api/v1/capture", s.apiStopCapture)
+	return mux
+}
+
+func (s *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Status())
+}
+
+func (s *Server) apiListClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Clients())
+}
+
+func (s *Server) apiDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.ParseUint(r.PathValue("session"), 16, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+	var ban time.Duration
+	if v := r.URL.Query().Get("ban"); v != "" {
+		if ban, err = time.ParseDuration(v); err != nil || ban < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid ban duration")
+			return
+		}
+	}
+	if !s.KickClient(sessionID, r.URL.Query().Get("reason"), ban) {
+		writeAPIError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiListBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Bans())
+}
+
+func (s *Server) apiLiftBan(w http.ResponseWriter, r *http.Request) {
+	if !s.LiftBan(r.PathValue("peer")) {
+		writeAPIError(w, http.StatusNotFound, "peer is not banned")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiListPeers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Peers())
+}
+
+// addPeerRequest тело запроса на добавление пира. Если ключ не указан, он генерируется
+type addPeerRequest struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+func (s *Server) apiAddPeer(w http.ResponseWriter, r *http.Request) {
+	var req addPeerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	key, err := parsePeerKey(req.Key)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.AddPeer(req.Name, key); err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, addPeerRequest{Name: req.Name, Key: hex.EncodeToString(key)})
+}
+
+func (s *Server) apiRevokePeer(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.RevokePeer(r.PathValue("name"))
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "peer not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiGetPeer(w http.ResponseWriter, r *http.Request) {
+	record, err := s.PeerRecord(r.PathValue("name"))
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+func (s *Server) apiDisablePeer(w http.ResponseWriter, r *http.Request) {
+	if err := s.DisablePeer(r.PathValue("name")); err != nil {
+		writePeerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiEnablePeer(w http.ResponseWriter, r *http.Request) {
+	if err := s.EnablePeer(r.PathValue("name")); err != nil {
+		writePeerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writePeerError отправляет ошибку операции с пиром с подходящим HTTP статусом
+func writePeerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, peerdb.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, "peer not found")
+	case errors.Is(err, peerdb.ErrRevoked):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errNoPeerDB):
+		writeAPIError(w, http.StatusNotImplemented, err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *Server) apiGetPeerLimit(w http.ResponseWriter, r *http.Request) {
+	limit, ok := s.PeerLimit(r.PathValue("name"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "peer not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, limit)
+}
+
+func (s *Server) apiSetPeerLimit(w http.ResponseWriter, r *http.Request) {
+	var limit RateLimit
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if !s.SetPeerLimit(r.PathValue("name"), limit) {
+		writeAPIError(w, http.StatusNotFound, "peer not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, limit)
+}
+
+func (s *Server) apiEnableTOTP(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.keyring.Has(name) {
+		writeAPIError(w, http.StatusNotFound, "peer not found")
+		return
+	}
+	secret, err := s.EnableTOTP(name)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, adminrpc.PeerTOTPResponse{Secret: secret, URI: totp.URI(TOTPIssuer, name, secret)})
+}
+
+func (s *Server) apiDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.DisableTOTP(r.PathValue("name"))
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "TOTP is not enabled for this peer")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiReload(w http.ResponseWriter, r *http.Request) {
+	var req adminrpc.ReloadConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.DNS == nil {
+		changes, err := s.Reload()
+		switch {
+		case errors.Is(err, errNoReload):
+			writeAPIError(w, http.StatusNotImplemented, err.Error())
+		case err != nil:
+			writeAPIError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSON(w, http.StatusOK, adminrpc.ReloadConfigResponse{Changes: changes})
+		}
+		return
+	}
+	for _, server := range req.DNS {
+		if net.ParseIP(server) == nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid DNS server "+strconv.Quote(server))
+			return
+		}
+	}
+	s.SetDNSServers(req.DNS)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiGetCapture(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.Capture()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, errNoCapture.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *Server) apiStartCapture(w http.ResponseWriter, r *http.Request) {
+	var req adminrpc.StartCaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.LimitBytes < 0 {
+		writeAPIError(w, http.StatusBadRequest, "limit must not be negative")
+		return
+	}
+	if err := s.StartCapture(req.Path, req.LimitBytes, req.Outer); err != nil {
+		if errors.Is(err, errCaptureRunning) {
+			writeAPIError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	st, _ := s.Capture()
+	writeJSON(w, http.StatusCreated, st)
+}
+
+func (s *Server) apiStopCapture(w http.ResponseWriter, r *http.Request) {
+	st, err := s.StopCapture()
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// writeJSON отправляет ответ в формате JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError отправляет ошибку в формате {"error": "..."}
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"myvpn/adminrpc"
+	"myvpn/internal"
+	"myvpn/internal/auth"
+	"myvpn/internal/logging"
+)
+
+// AuthRetryAfter через сколько клиент, не прошедший внешнюю проверку, может повторить попытку.
+// Повторы с тем же паролем бесполезны и могут заблокировать учетную запись в каталоге
+const AuthRetryAfter = time.Minute
+
+// authenticate проверяет имя и пароль из запроса конфигурации во внешнем backend
+// и при успехе выдает сессии конфигурацию. Проверка идет по сети, поэтому выполняется
+// в отдельной горутине и не задерживает пакеты других клиентов. Повторы запроса,
+// пока проверка не закончилась, отбрасываются
+func (s *Server) authenticate(req internal.ConfigRequest, addr *net.UDPAddr, sessionID uint64) {
+	s.authMu.Lock()
+	if s.authPending[sessionID] {
+		s.authMu.Unlock()
+		return
+	}
+	s.authPending[sessionID] = true
+	s.authMu.Unlock()
+
+	go func() {
+		defer func() {
+			s.authMu.Lock()
+			delete(s.authPending, sessionID)
+			s.authMu.Unlock()
+		}()
+
+		peer, _ := s.keyring.SessionPeer(sessionID)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*auth.DefaultTimeout)
+		defer cancel()
+		err := s.auth.Authenticate(ctx, auth.Request{
+			Username:   req.Username,
+			Password:   req.Password,
+			Peer:       peer,
+			SessionID:  sessionID,
+			RemoteAddr: addr.String(),
+		})
+		if err != nil {
+			metricAuthFailures.Inc()
+			reject := internal.Reject{Reason: "authentication failed", RetryAfter: int(AuthRetryAfter / time.Second)}
+			if !errors.Is(err, auth.ErrDenied) {
+				reject = internal.Reject{Reason: "authentication backend unavailable", RetryAfter: int(RejectRetryAfter / time.Second)}
+			}
+			logAuth.Warn("Authentication failed", "user", req.Username, "peer", peer, logging.Session(sessionID), "remote", addr, logging.Err(err))
+			s.reject(addr, sessionID, reject)
+			return
+		}
+		logAuth.Info("User authenticated", "user", req.Username, "peer", peer, logging.Session(sessionID))
+		s.configure(req, addr, sessionID, req.Username)
+	}()
+}
+
+// account отправляет в backend запись учета о начале или конце сессии клиента
+func (s *Server) account(eventType string, sessionID uint64, info ClientInfo) {
+	if s.accounter == nil {
+		return
+	}
+	var recType string
+	switch eventType {
+	case adminrpc.EventConnected:
+		recType = auth.AccountingStart
+	case adminrpc.EventDisconnected:
+		recType = auth.AccountingStop
+	default:
+		return
+	}
+
+	user := info.User
+	if user == "" {
+		user = info.Peer
+	}
+	rec := auth.Record{
+		Type:       recType,
+		Username:   user,
+		SessionID:  sessionID,
+		RemoteAddr: info.RemoteAddr,
+		VirtualIP:  info.VirtualIP,
+		RxPackets:  info.RxPackets,
+		RxBytes:    info.RxBytes,
+		TxPackets:  info.TxPackets,
+		TxBytes:    info.TxBytes,
+		Duration:   time.Since(info.Connected),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*auth.DefaultTimeout)
+		defer cancel()
+		if err := s.accounter.Account(ctx, rec); err != nil {
+			metricAccountingFailures.Inc()
+			logAuth.Warn("Failed to send accounting", "type", recType, "session", info.SessionID, logging.Err(err))
+		}
+	}()
+}
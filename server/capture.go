@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"os"
+
+	"myvpn/adminrpc"
+	"myvpn/internal/pcap"
+)
+
+// CaptureStatus состояние записи трафика для admin API
+type CaptureStatus = adminrpc.CaptureStatus
+
+var (
+	// errCaptureRunning запись уже идет
+	errCaptureRunning = errors.New("capture is already running")
+	// errNoCapture запись не идет
+	errNoCapture = errors.New("capture is not running")
+)
+
+// StartCapture начинает запись пакетов туннеля в файл pcap: внутренних IP пакетов
+// клиентов, а с outer - и зашифрованных датаграмм. Запись останавливается сама, когда
+// файл достигает limit байт (0 - без ограничения)
+func (s *Server) StartCapture(path string, limit int64, outer bool) error {
+	if path == "" {
+		return errors.New("capture file path is required")
+	}
+	if s.capture.Load() != nil {
+		return errCaptureRunning
+	}
+	var c *pcap.Capture
+	c, err := pcap.Start(path, limit, outer, func() {
+		logServer.Warn("Capture size limit reached", "path", path, "limit", limit)
+		s.stopCapture(c)
+	})
+	if err != nil {
+		return err
+	}
+	if !s.capture.CompareAndSwap(nil, c) {
+		c.Close()
+		os.Remove(path)
+		return errCaptureRunning
+	}
+	if outer {
+		s.transport.SetCapture(c.Datagram)
+	}
+	logServer.Info("Capture started", "path", path, "limit", limit, "outer", outer)
+	return nil
+}
+
+// StopCapture останавливает запись и возвращает ее итог
+func (s *Server) StopCapture() (CaptureStatus, error) {
+	c := s.capture.Load()
+	if c == nil || !s.stopCapture(c) {
+		return CaptureStatus{}, errNoCapture
+	}
+	return captureStatus(c), nil
+}
+
+// Capture возвращает состояние текущей записи
+func (s *Server) Capture() (CaptureStatus, bool) {
+	c := s.capture.Load()
+	if c == nil {
+		return CaptureStatus{}, false
+	}
+	return captureStatus(c), true
+}
+
+// stopCapture останавливает запись c, если она еще текущая
+func (s *Server) stopCapture(c *pcap.Capture) bool {
+	if !s.capture.CompareAndSwap(c, nil) {
+		return false
+	}
+	if c.Outer() {
+		s.transport.SetCapture(nil)
+	}
+	if err := c.Close(); err != nil {
+		logServer.Warn("Failed to close capture file", "err", err)
+	}
+	st := c.Status()
+	logServer.Info("Capture stopped", "path", st.Path, "packets", st.Packets, "bytes", st.Bytes)
+	return true
+}
+
+func captureStatus(c *pcap.Capture) CaptureStatus {
+	st := c.Status()
+	return CaptureStatus{
+		Path:    st.Path,
+		Outer:   st.Outer,
+		Started: st.Started,
+		Packets: st.Packets,
+		Bytes:   st.Bytes,
+		Limit:   st.Limit,
+		Full:    st.Full,
+	}
+}
@@ -1,59 +1,258 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"myvpn/adminrpc"
 	"myvpn/internal"
+	"myvpn/internal/auth"
+	"myvpn/internal/bufpool"
 	"myvpn/internal/compress"
+	"myvpn/internal/dnsfwd"
+	"myvpn/internal/logging"
+	"myvpn/internal/netstate"
+	"myvpn/internal/peerdb"
+	"myvpn/internal/porthop"
+	"myvpn/internal/flowexport"
+	"myvpn/internal/metrics"
+	"myvpn/internal/pcap"
+	"myvpn/internal/pktqueue"
+	"myvpn/internal/pktsched"
+	"myvpn/internal/ratelimit"
+	"myvpn/internal/tracing"
 	"myvpn/internal/transport"
 )
 
-// Client представляет клиентское соединение (UDP)
+// Client представляет клиентское соединение (UDP).
+// Клиент идентифицируется session ID, поэтому его адрес может меняться (роуминг)
 type Client struct {
+	sessionID  uint64
 	remoteAddr *net.UDPAddr
-	crypto     *internal.Crypto
+	virtualIP  string
+	addrMu     sync.RWMutex
+	rxPackets  atomic.Uint64
+	rxBytes    atomic.Uint64
+	txPackets  atomic.Uint64
+	txBytes    atomic.Uint64
+	lastSeen   atomic.Int64 // время последнего пакета от клиента (UnixNano)
+	handshake  atomic.Int64 // время последнего запроса конфигурации (UnixNano, 0 - не было)
+	authErrors atomic.Uint64
+	upLimit    atomic.Pointer[ratelimit.Bucket]
+	downLimit  atomic.Pointer[ratelimit.Bucket]
+	codec      atomic.Uint32 // кодек сжатия пакетов к клиенту, согласованный при запросе конфигурации
+	bond       atomic.Bool   // клиент распределяет пакеты по нескольким путям (multipath)
+	p2p        atomic.Bool   // клиент обменивается пакетами с другими клиентами напрямую
+	paths      []clientPath  // пути клиента с bonding, защищены addrMu
+	pathIdx    atomic.Uint32 // счетчик для чередования путей
+	peer       string
+	user       string    // пользователь, подтвержденный внешней аутентификацией (защищено addrMu)
+	connected  time.Time // время подключения
 	tun        *TUN
 	done       chan struct{}
 	wg         sync.WaitGroup
-	verbose    bool
 }
 
-// NewClient создает новый клиент для UDP
-func NewClient(remoteAddr *net.UDPAddr, crypto *internal.Crypto, tun *TUN, verbose bool) *Client {
-	return &Client{
+// NewClient создает новый клиент для UDP. peer - имя ключа, которым аутентифицирована сессия
+func NewClient(sessionID uint64, remoteAddr *net.UDPAddr, peer string, tun *TUN) *Client {
+	c := &Client{
+		sessionID:  sessionID,
 		remoteAddr: remoteAddr,
-		crypto:     crypto,
+		peer:       peer,
 		tun:        tun,
 		done:       make(chan struct{}),
-		verbose:    verbose,
 	}
+	c.connected = time.Now()
+	c.lastSeen.Store(c.connected.UnixNano())
+	return c
 }
 
 // Handle обрабатывает клиентское соединение (для UDP это просто маркер)
 func (c *Client) Handle() error {
-	log.Printf("New client connected from %s", c.remoteAddr)
+	logServer.Info("New client connected", "remote", c.remoteAddr)
 	// Для UDP клиенты обрабатываются централизованно в сервере
 	return nil
 }
 
-// SendPacket отправляет пакет клиенту через UDP транспорт
-func (c *Client) SendPacket(transport *transport.UDPTransport, packet []byte) error {
+// RemoteAddr возвращает текущий адрес клиента
+func (c *Client) RemoteAddr() *net.UDPAddr {
+	c.addrMu.RLock()
+	defer c.addrMu.RUnlock()
+	return c.remoteAddr
+}
+
+// VirtualIP возвращает виртуальный IP клиента внутри VPN
+func (c *Client) VirtualIP() string {
+	c.addrMu.RLock()
+	defer c.addrMu.RUnlock()
+	return c.virtualIP
+}
+
+// setVirtualIP запоминает виртуальный IP клиента
+func (c *Client) setVirtualIP(ip string) {
+	c.addrMu.Lock()
+	c.virtualIP = ip
+	c.addrMu.Unlock()
+}
+
+// rebind обновляет адрес клиента, возвращает true если адрес изменился.
+// Клиент с bonding не переезжает, а добавляет путь: тогда true означает новый путь
+func (c *Client) rebind(addr *net.UDPAddr) bool {
+	c.addrMu.Lock()
+	defer c.addrMu.Unlock()
+	if c.bond.Load() {
+		return c.addPathLocked(addr)
+	}
+	if c.remoteAddr.IP.Equal(addr.IP) && c.remoteAddr.Port == addr.Port {
+		return false
+	}
+	c.remoteAddr = addr
+	return true
+}
+
+// addPathLocked отмечает пакет с пути addr и убирает пути, с которых дольше PathTimeout
+// не было пакетов. Возвращает true для нового пути. Требует c.addrMu
+func (c *Client) addPathLocked(addr *net.UDPAddr) bool {
+	now := time.Now()
+	found := false
+	paths := c.paths[:0]
+	for _, p := range c.paths {
+		if p.addr.IP.Equal(addr.IP) && p.addr.Port == addr.Port {
+			p.lastSeen = now
+			found = true
+		}
+		if now.Sub(p.lastSeen) < PathTimeout {
+			paths = append(paths, p)
+		}
+	}
+	if !found {
+		paths = append(paths, clientPath{addr: addr, lastSeen: now})
+	}
+	c.paths = paths
+	c.remoteAddr = addr
+	return !found
+}
+
+// setBond включает или выключает отправку пакетов клиенту по всем его путям
+func (c *Client) setBond(bond bool) {
+	c.addrMu.Lock()
+	defer c.addrMu.Unlock()
+	c.bond.Store(bond)
+	c.paths = nil
+	if bond {
+		c.paths = append(c.paths, clientPath{addr: c.remoteAddr, lastSeen: time.Now()})
+	}
+}
+
+// sendAddr возвращает адрес для очередного пакета к клиенту. С bonding пакеты
+// чередуются по путям, с которых недавно приходили пакеты, иначе идут на текущий адрес
+func (c *Client) sendAddr() *net.UDPAddr {
+	if !c.bond.Load() {
+		return c.RemoteAddr()
+	}
+	c.addrMu.RLock()
+	defer c.addrMu.RUnlock()
+	n := uint32(len(c.paths))
+	if n == 0 {
+		return c.remoteAddr
+	}
+	start := c.pathIdx.Add(1)
+	for i := uint32(0); i < n; i++ {
+		p := c.paths[(start+i)%n]
+		if time.Since(p.lastSeen) < PathTimeout {
+			return p.addr
+		}
+	}
+	return c.remoteAddr
+}
+
+// setLimit заменяет ограничители скорости клиента
+func (c *Client) setLimit(limit RateLimit) {
+	c.upLimit.Store(ratelimit.NewBucket(limit.Up))
+	c.downLimit.Store(ratelimit.NewBucket(limit.Down))
+}
+
+// allowUpload проверяет лимит скорости для пакета от клиента
+func (c *Client) allowUpload(n int) bool {
+	return c.upLimit.Load().Allow(n)
+}
+
+// setCodec задает кодек сжатия пакетов к клиенту
+func (c *Client) setCodec(codec compress.Codec) {
+	c.codec.Store(uint32(codec))
+}
+
+// User возвращает пользователя, подтвержденного внешней аутентификацией
+func (c *Client) User() string {
+	c.addrMu.RLock()
+	defer c.addrMu.RUnlock()
+	return c.user
+}
+
+// apply применяет к клиенту параметры, согласованные с его сессией
+func (c *Client) apply(params sessionParams) {
+	c.addrMu.Lock()
+	c.user = params.user
+	c.addrMu.Unlock()
+	if !params.issued.IsZero() {
+		c.handshake.Store(params.issued.UnixNano())
+	}
+	c.setCodec(params.codec)
+	c.p2p.Store(params.p2p)
+	if c.bond.Load() != params.bond {
+		c.setBond(params.bond)
+	}
+}
+
+// preparePacket проверяет лимит скорости, сжимает пакет согласованным с клиентом кодеком
+// (если сжатие этого соединения окупается) и готовит его к отправке. Возвращает false, если пакет отброшен лимитом. Данные всегда
+// копируются в буфер из bufpool, поэтому буфер packet можно сразу переиспользовать. Data готового
+// пакета принадлежит отправителю, который возвращает его в пул (bufpool.PutDatagram) после WriteBatch.
+// С shaping лимит здесь не проверяется: его применяет планировщик, задерживая пакет
+func (c *Client) preparePacket(packet []byte, adaptive *compress.Adaptive, shaping bool) (transport.Packet, bool, error) {
+	if !shaping && !c.downLimit.Load().Allow(len(packet)) {
+		metricRateLimitDown.Inc()
+		return transport.Packet{}, false, nil
+	}
+
 	// Сжимаем пакет (опционально)
-	compressed, isCompressed, err := compress.Compress(packet)
+	buf := bufpool.GetDatagram()
+	span := tracing.PacketSpan("compress", len(packet))
+	compressed, codec, err := adaptive.Compress(buf[:0], compress.Codec(c.codec.Load()), internal.ConnHash(packet), packet)
+	span.End()
 	if err != nil {
-		return fmt.Errorf("compression failed: %w", err)
+		bufpool.PutDatagram(buf)
+		return transport.Packet{}, false, fmt.Errorf("compression failed: %w", err)
+	}
+	metricCompressIn.Add(uint64(len(packet)))
+	metricCompressOut.Add(uint64(len(compressed)))
+	if codec == compress.CodecNone {
+		compressed = append(buf[:0], packet...)
 	}
 
-	// Устанавливаем удаленный адрес и отправляем (транспорт сам зашифрует)
-	transport.SetRemoteAddr(c.remoteAddr)
-	_, err = transport.Write(compressed, isCompressed)
-	return err
-}
+	c.txPackets.Add(1)
+	c.txBytes.Add(uint64(len(packet)))
 
+	// Отправляем на текущий адрес клиента или очередной путь (транспорт сам зашифрует)
+	return transport.Packet{
+		Data:      compressed,
+		Codec:     codec,
+		Addr:      c.sendAddr(),
+		SessionID: c.sessionID,
+		DSCP:      internal.PacketDSCP(packet),
+	}, true, nil
+}
 
 // Close закрывает клиентское соединение
 func (c *Client) Close() error {
@@ -67,53 +266,381 @@ func (c *Client) Close() error {
 	}
 }
 
+// sessionParams параметры, согласованные с сессией при запросе конфигурации
+type sessionParams struct {
+	codec   compress.Codec // кодек сжатия пакетов к клиенту
+	bond    bool           // клиент распределяет пакеты по нескольким путям
+	p2p     bool           // клиент запросил прямой обмен с другими клиентами, и сервер его знакомит
+	version uint8          // версия протокола клиента
+	caps    uint64         // возможности, общие для клиента и сервера
+	ip      string         // назначенный сервером IPv4 адрес (пусто - клиент выбрал адрес сам)
+	ip6     string         // назначенный сервером IPv6 адрес
+	user    string         // пользователь, подтвержденный внешней аутентификацией
+	issued  time.Time      // время запроса конфигурации
+}
+
+// clientPath путь клиента с bonding: адрес, с которого приходят пакеты сессии
+type clientPath struct {
+	addr     *net.UDPAddr
+	lastSeen time.Time
+}
+
 // Server представляет VPN сервер
 type Server struct {
 	listenAddr     string
+	listenAddrs    []string // адреса UDP сокетов, первый - основной
 	tun            *TUN
-	crypto         *internal.Crypto
+	keyring        *transport.Keyring
+	keyPeers       atomic.Pointer[keyPeerSet] // пиры с открытыми ключами (заменяются при перезагрузке)
+	filePeers      map[string]bool            // имена пиров из Config.Peers (защищено reloadMu)
+	privateKey     []byte                     // закрытый ключ X25519 сервера (nil - нет)
+	peerDB         *peerdb.DB                 // база пиров (nil - пиры API хранятся только в памяти)
+	reload         func() (ReloadConfig, error)
+	reloadMu       sync.Mutex // перезагрузки идут по одной
 	transport      *transport.UDPTransport
 	networkManager *NetworkManager
-	clients        map[string]*Client
+	clients        map[uint64]*Client
 	clientsByIP    map[string]*Client
+	peerClients    map[string]*Client // пир с открытым ключом -> клиент, которому идут пакеты в его AllowedIPs
 	clientsMu      sync.RWMutex
+	params         map[uint64]sessionParams // параметры, согласованные с сессиями, в т.ч. еще без клиента
+	minVersion     uint8                    // минимальная версия протокола клиентов
+	cookieLoad     int                      // порог пакетов неизвестных сессий для cookie (0 - выключено)
+	handshakeRate  float64                  // лимит пакетов неизвестных сессий с одного IP (0 - без ограничения)
+	pool           *addressPool             // виртуальные адреса, которые сервер назначает клиентам
+	pushRoutes     []string                 // сети, которые клиенты направляют в VPN (защищено configMu)
+	pushMTU        int                      // MTU TUN клиентов (0 - не передается; защищено configMu)
+	dnsServers     []string
+	dnsForwarder   *dnsfwd.Forwarder // встроенный DNS прокси (nil - выключен)
+	bans           map[string]time.Time // пир -> окончание бана (защищено configMu)
+	configMu       sync.RWMutex
+	totp           *totpState // секреты TOTP пиров (защищено configMu)
+	auth           auth.Backend
+	accounter      auth.Accounter // учет сессий (nil - backend без учета или не задан)
+	authMu         sync.Mutex
+	authPending    map[uint64]bool // сессии, которые сейчас проходят внешнюю проверку
+	idleTimeout    atomic.Int64    // time.Duration, 0 - не удалять неактивные сессии
+	maxClients     atomic.Int64
+	cryptoWorkers  int
+	ioURing        bool
+	listenShards   int
+	compression    compress.Codec // предпочтительный кодек, CodecNone - auto
+	compressionOff bool
+	adaptive       *compress.Adaptive // статистика сжатия соединений к клиентам
+	obfuscation    transport.Obfuscation
+	dscp           int // DSCP датаграмм к клиентам (transport.SetDSCP), 0 - не задан
+	p2p            bool
+	mesh           bool
+	introductions  map[peerPair]introduction // пары клиентов, которых сервер познакомил (защищено introMu)
+	introMu        sync.Mutex
+	portHop        porthop.Range
+	streams        streamConfig
+	identities     *transport.StreamIdentities // имена из сертификатов клиентов TLS транспортов (nil - без mTLS)
+	tcpListener    net.Listener
+	kcpListener    net.Listener
+	wssServer      *http.Server
+	grpcServer     *grpc.Server
+	dnsConn        *net.UDPConn // сокет DNS туннеля
+	outgoing       *pktqueue.Queue[transport.Packet]     // пакеты к клиентам, ожидающие отправки пачкой
+	sched          *pktsched.Scheduler[transport.Packet] // очереди по клиентам вместо outgoing (-shaping)
+	tunWriters     []*pktqueue.Queue[[]byte]             // очереди записи в TUN (пусто при одной очереди без io_uring)
+	defaultLimit   RateLimit
+	peerLimits     map[string]RateLimit
+	events         *eventHub
+	capture        atomic.Pointer[pcap.Capture] // запись трафика в pcap (nil - выключена)
+	flows          *flowexport.Exporter         // учет потоков клиентов (nil - выключен)
+	tunErrors      atomic.Int32                 // ошибки чтения и записи TUN подряд
+	udpErrors      atomic.Int32                 // ошибки чтения UDP сокета подряд
+	startTime      time.Time
+	traffic        trafficSampler // счетчики трафика за последнюю минуту для /stats.json
 	done           chan struct{}
 	wg             sync.WaitGroup
-	verbose        bool
 }
 
 // NewServer создает новый VPN сервер
-func NewServer(listenAddr string, key []byte, verbose bool) (*Server, error) {
+func NewServer(cfg Config) (*Server, error) {
 	// Создаем TUN интерфейс
-	tun, err := NewTUN(TUNInterfaceName)
+	queues := cfg.TUNQueues
+	if queues < 1 {
+		queues = 1
+	}
+	var tun *TUN
+	var err error
+	if cfg.Netstack {
+		if err := checkNetstackConfig(cfg); err != nil {
+			return nil, err
+		}
+		tun = NewNetstackTUN()
+	} else if cfg.TUNFD > 0 {
+		if queues > 1 {
+			return nil, fmt.Errorf("multiple TUN queues cannot be used with a pre-opened TUN descriptor")
+		}
+		tun, err = NewTUNFromFD(cfg.TUNFD)
+	} else {
+		tun, err = NewTUN(TUNInterfaceName, queues, cfg.TUNOffload)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TUN interface: %w", err)
 	}
+	if cfg.IOURing {
+		if err := tun.UseIOURing(); err != nil {
+			tun.Close()
+			return nil, err
+		}
+	}
 
-	// Создаем криптографию
-	crypto, err := internal.NewCrypto(key)
+	// Создаем криптографию. Ключ из -key становится пиром DefaultPeer,
+	// остальные пиры добавляются через admin API
+	crypto, err := internal.NewCrypto(cfg.Key)
 	if err != nil {
 		tun.Close()
 		return nil, fmt.Errorf("failed to create crypto: %w", err)
 	}
+	keyring := transport.NewKeyring()
+	keyring.Add(DefaultPeer, crypto)
 
-	// Создаем менеджер сетевых настроек
-	networkManager, err := NewNetworkManager(TUNInterfaceName)
+	// Пиры из базы добавляются к пирам из конфигурации, отключенные остаются без ключа в keyring
+	sharedPeers, publicPeers, disabled, err := dbPeers(cfg.PeerDB)
+	if err != nil {
+		tun.Close()
+		return nil, fmt.Errorf("failed to load peer database: %w", err)
+	}
+	for name, crypto := range sharedPeers {
+		if name == DefaultPeer {
+			tun.Close()
+			return nil, fmt.Errorf("peer database: peer name %q is reserved", name)
+		}
+		keyring.Add(name, crypto)
+	}
+
+	// Пиры с открытыми ключами: ключ каждого выводится из закрытого ключа сервера.
+	// Пиров из конфигурации можно менять перезагрузкой, поэтому их имена запоминаются
+	keyPeers, err := loadKeyPeers(cfg.PrivateKey, cfg.Peers)
+	if err != nil {
+		tun.Close()
+		return nil, err
+	}
+	filePeers := make(map[string]bool, len(keyPeers))
+	for name := range keyPeers {
+		filePeers[name] = true
+	}
+	dbKeyPeers, err := loadKeyPeers(cfg.PrivateKey, publicPeers)
+	if err != nil {
+		tun.Close()
+		return nil, err
+	}
+	if keyPeers == nil {
+		keyPeers = make(map[string]*keyPeer)
+	}
+	for name, peer := range dbKeyPeers {
+		if keyPeers[name] != nil {
+			tun.Close()
+			return nil, fmt.Errorf("duplicate peer name %q", name)
+		}
+		keyPeers[name] = peer
+	}
+	for name, peer := range keyPeers {
+		if sharedPeers[name] != nil {
+			tun.Close()
+			return nil, fmt.Errorf("duplicate peer name %q", name)
+		}
+		if !disabled[name] {
+			keyring.Add(name, peer.crypto)
+		}
+	}
+	if cfg.PrivateKey != nil {
+		if err := logPublicKey(cfg.PrivateKey); err != nil {
+			tun.Close()
+			return nil, err
+		}
+	}
+
+	accounter, _ := cfg.Auth.(auth.Accounter)
+
+	totpSecrets, err := newTOTPState(cfg.TOTPSecrets, cfg.TOTPFile)
+	if err != nil {
+		tun.Close()
+		return nil, err
+	}
+
+	peerLimits := make(map[string]RateLimit, len(cfg.PeerLimits))
+	for name, limit := range cfg.PeerLimits {
+		peerLimits[name] = limit
+	}
+
+	// С несколькими очередями запись в TUN идет из отдельной горутины на каждую очередь.
+	// С io_uring - даже с одной очередью: горутина записи собирает пакеты в пачки
+	outgoingSize, tunQueueSize := 4*transport.BatchSize, transport.BatchSize
+	if cfg.QueueSize > 0 {
+		outgoingSize, tunQueueSize = cfg.QueueSize, cfg.QueueSize
+	}
+	var tunWriters []*pktqueue.Queue[[]byte]
+	if queues > 1 || cfg.IOURing {
+		tunWriters = make([]*pktqueue.Queue[[]byte], queues)
+		for i := range tunWriters {
+			tunWriters[i] = pktqueue.New(tunQueueSize, cfg.QueuePolicy, func(packet []byte) {
+				metricQueueDropUp.Inc()
+				bufpool.PutDatagram(packet)
+			})
+		}
+	}
+	dropOutgoing := func(p transport.Packet) {
+		metricQueueDropDown.Inc()
+		bufpool.PutDatagram(p.Data)
+	}
+	outgoing := pktqueue.New(outgoingSize, cfg.QueuePolicy, dropOutgoing)
+	var sched *pktsched.Scheduler[transport.Packet]
+	if cfg.Shaping {
+		size := 2 * transport.BatchSize
+		if cfg.QueueSize > 0 {
+			size = cfg.QueueSize
+		}
+		sched = pktsched.New(size, dropOutgoing)
+	}
+
+	if cfg.Firewall == FirewallNone && cfg.PortHop.Enabled() {
+		tun.Close()
+		return nil, fmt.Errorf("port hopping requires firewall rules and cannot be used with firewall %s", FirewallNone)
+	}
+
+	// Создаем менеджер сетевых настроек. Пакеты userspace стека ядро не пересылает,
+	// поэтому с ним менеджера нет
+	var networkManager *NetworkManager
+	if !cfg.Netstack {
+		networkManager, err = NewNetworkManager(tun.Name(), cfg.Firewall)
+		if err != nil {
+			tun.Close()
+			return nil, fmt.Errorf("failed to create network manager: %w", err)
+		}
+		networkManager.mssClamp = cfg.MSSClamp
+		if !tun.HasIPv6() {
+			// Без IPv6 адреса на TUN NAT66 и правила ip6tables не нужны
+			networkManager.externalInterface6 = ""
+		}
+		// Правила, оставшиеся после аварийного завершения, удаляются до настройки новых
+		if cfg.StateFile != "" {
+			if err := netstate.Recover(cfg.StateFile); err != nil {
+				tun.Close()
+				return nil, fmt.Errorf("failed to remove leftover network settings: %w", err)
+			}
+			if networkManager.state, err = netstate.Open(cfg.StateFile); err != nil {
+				tun.Close()
+				return nil, err
+			}
+		}
+	}
+
+	network6 := VPNNetwork6
+	if !tun.HasIPv6() {
+		network6 = ""
+	}
+	pool, err := newAddressPool(VPNNetwork, network6)
+	if err != nil {
+		tun.Close()
+		return nil, err
+	}
+	for _, cidr := range cfg.PushRoutes {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			tun.Close()
+			return nil, fmt.Errorf("invalid pushed route %q: %w", cidr, err)
+		}
+	}
+
+	var listenAddrs []string
+	for _, addr := range strings.Split(cfg.ListenAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			listenAddrs = append(listenAddrs, addr)
+		}
+	}
+	if len(listenAddrs) == 0 {
+		tun.Close()
+		return nil, errors.New("listen address is required")
+	}
+	dnsForwarder, err := newDNSForwarder(cfg)
 	if err != nil {
 		tun.Close()
-		return nil, fmt.Errorf("failed to create network manager: %w", err)
+		return nil, err
+	}
+	if cfg.DNSTunnelListen != "" && cfg.DNSTunnelZone == "" {
+		tun.Close()
+		return nil, errors.New("DNS tunnel requires a zone")
+	}
+
+	streams := streamConfig{
+		tcp:      cfg.TCPListen,
+		tcpTLS:   cfg.TCPTLS,
+		wss:      cfg.WSSListen,
+		cert:     cfg.WSSCert,
+		key:      cfg.WSSKey,
+		clientCA: cfg.ClientCA,
+		path:     cfg.WSSPath,
+		fallback: cfg.Fallback,
+		kcp:      cfg.KCPListen,
+		grpc:     cfg.GRPCListen,
+		grpcName: cfg.GRPCService,
+		dns:      cfg.DNSTunnelListen,
+		dnsZone:  cfg.DNSTunnelZone,
+		kcpFEC:   cfg.KCPFEC,
+	}
+	var identities *transport.StreamIdentities
+	if cfg.ClientCA != "" {
+		identities = transport.NewStreamIdentities()
 	}
 
-	return &Server{
-		listenAddr:     listenAddr,
+	s := &Server{
+		listenAddr:     cfg.ListenAddr,
+		listenAddrs:    listenAddrs,
 		tun:            tun,
-		crypto:         crypto,
+		keyring:        keyring,
+		filePeers:      filePeers,
+		privateKey:     cfg.PrivateKey,
+		peerDB:         cfg.PeerDB,
+		reload:         cfg.Reload,
+		totp:           totpSecrets,
+		bans:           make(map[string]time.Time),
+		auth:           cfg.Auth,
+		flows:          cfg.FlowExport,
+		accounter:      accounter,
+		authPending:    make(map[uint64]bool),
 		networkManager: networkManager,
-		clients:        make(map[string]*Client),
+		clients:        make(map[uint64]*Client),
 		clientsByIP:    make(map[string]*Client),
+		peerClients:    make(map[string]*Client),
+		params:         make(map[uint64]sessionParams),
+		minVersion:     cfg.MinProtocolVersion,
+		cookieLoad:     cfg.CookieThreshold,
+		handshakeRate:  cfg.HandshakeRate,
+		pool:           pool,
+		pushRoutes:     cfg.PushRoutes,
+		pushMTU:        cfg.PushMTU,
+		dnsServers:     cfg.DNSServers,
+		dnsForwarder:   dnsForwarder,
+		cryptoWorkers:  cfg.CryptoWorkers,
+		ioURing:        cfg.IOURing,
+		listenShards:   cfg.ListenShards,
+		compression:    cfg.Compression,
+		compressionOff: cfg.DisableCompression,
+		adaptive:       compress.NewAdaptive(),
+		obfuscation:    cfg.Obfuscation,
+		dscp:           cfg.DSCP,
+		p2p:            cfg.P2P || cfg.Mesh,
+		mesh:           cfg.Mesh,
+		introductions:  make(map[peerPair]introduction),
+		portHop:        cfg.PortHop,
+		streams:        streams,
+		identities:     identities,
+		outgoing:       outgoing,
+		sched:          sched,
+		tunWriters:     tunWriters,
+		defaultLimit:   cfg.DefaultLimit,
+		peerLimits:     peerLimits,
+		events:         newEventHub(),
 		done:           make(chan struct{}),
-		verbose:        verbose,
-	}, nil
+	}
+	s.keyPeers.Store(newKeyPeerSet(keyPeers))
+	s.idleTimeout.Store(int64(cfg.IdleTimeout))
+	s.maxClients.Store(int64(cfg.MaxClients))
+	return s, nil
 }
 
 // Start запускает сервер
@@ -122,34 +649,554 @@ func (s *Server) Start() error {
 	if err := s.networkManager.Setup(); err != nil {
 		return fmt.Errorf("failed to setup network: %w", err)
 	}
+	s.syncPeerRoutes(nil, s.keyPeers.Load())
 
-	// Создаем UDP транспорт
-	udpTransport, err := transport.NewUDPTransport(s.listenAddr, "", 30*time.Second, s.crypto, "")
+	if s.portHop.Enabled() {
+		if err := s.setupPortHop(); err != nil {
+			s.networkManager.Cleanup()
+			return fmt.Errorf("failed to setup port hopping: %w", err)
+		}
+	}
+
+	// Создаем UDP транспорт. Keepalive шлют клиенты: один транспорт на всех
+	// клиентов не может поддерживать keepalive для каждого, сервер только отвечает ACK
+	udpTransport, err := transport.NewUDPTransport(s.listenAddrs[0], "", 0, s.keyring, "")
 	if err != nil {
 		s.networkManager.Cleanup()
 		return fmt.Errorf("failed to create UDP transport: %w", err)
 	}
 
 	s.transport = udpTransport
-	log.Printf("VPN server listening on %s (UDP)", s.listenAddr)
-	log.Printf("TUN interface: %s", s.tun.Name())
+	// Остальные адреса - сокеты того же транспорта: сессии общие, клиент может перейти
+	// с адреса на адрес, как при роуминге
+	for _, addr := range s.listenAddrs[1:] {
+		if err := s.transport.AddListener(addr); err != nil {
+			s.transport.Close()
+			s.networkManager.Cleanup()
+			return err
+		}
+	}
+	if err := s.transport.SetShards(s.listenShards); err != nil {
+		s.transport.Close()
+		s.networkManager.Cleanup()
+		return err
+	}
+	if s.ioURing {
+		if err := s.transport.UseIOURing(); err != nil {
+			s.transport.Close()
+			s.networkManager.Cleanup()
+			return err
+		}
+	}
+	if s.dscp != 0 {
+		if err := s.transport.SetDSCP(s.dscp); err != nil {
+			s.transport.Close()
+			s.networkManager.Cleanup()
+			return err
+		}
+	}
+	s.transport.SetCryptoWorkers(s.cryptoWorkers)
+	s.transport.SetObfuscation(s.obfuscation)
+	s.startTime = time.Now()
+	s.transport.SetControlHandler(s.handleControl)
+	s.transport.SetDisconnectHandler(s.handleDisconnect)
+	s.transport.SetDecryptFailureHandler(s.handleDecryptFailure)
+	s.transport.SetLegacyKeepalive(s.minVersion < internal.AuthKeepaliveVersion)
+	if err := s.transport.SetCookieThreshold(s.cookieLoad); err != nil {
+		s.transport.Close()
+		s.networkManager.Cleanup()
+		return err
+	}
+	s.transport.SetHandshakeLimit(s.handshakeRate)
+	metrics.RegisterCollector(s.writeMetrics)
+	logTransport.Info("VPN server listening", "addr", s.listenAddr, "transport", "udp")
+
+	if err := s.startStreams(); err != nil {
+		s.transport.Close()
+		s.networkManager.Cleanup()
+		return fmt.Errorf("failed to start stream listeners: %w", err)
+	}
+	if err := s.startDNSForwarder(); err != nil {
+		s.stopStreams()
+		s.transport.Close()
+		s.networkManager.Cleanup()
+		return err
+	}
+	if s.tun.Userspace() {
+		logTUN.Info("Userspace network stack started instead of TUN: client connections leave as server sockets")
+	} else {
+		logTUN.Info("TUN interface created", "name", s.tun.Name(), "queues", s.tun.Queues())
+	}
+
+	// Запускаем по горутине чтения на каждую очередь TUN и горутину отправки клиентам
+	for q := 0; q < s.tun.Queues(); q++ {
+		s.wg.Add(1)
+		go s.handleTunToClients(q)
+	}
+	for q, packets := range s.tunWriters {
+		s.wg.Add(1)
+		go s.writeTunQueue(q, packets)
+	}
+	s.wg.Add(1)
+	go s.sendToClients()
+
+	// Запускаем по горутине чтения от клиентов на каждый сокет. Сессия всегда приходит
+	// в один сокет, поэтому порядок пакетов клиента сохраняется
+	for shard := 0; shard < s.transport.Shards(); shard++ {
+		s.wg.Add(1)
+		go s.handleClientsToTun(shard)
+	}
 
-	// Запускаем горутину для чтения из TUN
+	// Удаляем сессии исчезнувших клиентов. Горутина работает и с выключенным таймаутом:
+	// его можно включить перезагрузкой конфигурации
 	s.wg.Add(1)
-	go s.handleTunToClients()
+	go s.expireIdleClients()
 
-	// Запускаем горутину для чтения от клиентов
+	// Пакеты-пустышки клиентам
+	if s.obfuscation.CoverInterval > 0 {
+		s.wg.Add(1)
+		go s.sendCoverTraffic()
+	}
+
+	// Keepalive клиентам для измерения RTT и потерь
+	s.wg.Add(1)
+	go s.probeRTT()
+
+	// Скорость трафика для /stats.json
 	s.wg.Add(1)
-	go s.handleClientsToTun()
+	go s.sampleTraffic()
 
 	return nil
 }
 
-// handleTunToClients читает пакеты из TUN и отправляет всем клиентам
-func (s *Server) handleTunToClients() {
+// expireIdleClients периодически удаляет клиентов, от которых давно не было пакетов
+func (s *Server) expireIdleClients() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(IdleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		idleTimeout := time.Duration(s.idleTimeout.Load())
+		if idleTimeout == 0 {
+			continue
+		}
+		deadline := time.Now().Add(-idleTimeout).UnixNano()
+		var expired []*Client
+		s.clientsMu.Lock()
+		for _, client := range s.clients {
+			if client.lastSeen.Load() < deadline {
+				s.removeClientLocked(client)
+				expired = append(expired, client)
+			}
+		}
+		// Адреса сессий, которые запросили конфигурацию, но так и не прислали данных
+		s.pool.reclaim(func(sessionID uint64) bool {
+			_, ok := s.clients[sessionID]
+			return ok
+		}, time.Unix(0, deadline))
+		s.clientsMu.Unlock()
+
+		for _, client := range expired {
+			s.keyring.ForgetSession(client.sessionID)
+			s.transport.ForgetSession(client.sessionID)
+			s.publish(adminrpc.EventDisconnected, client)
+			logServer.Info("Client expired after inactivity", "remote", client.RemoteAddr(),
+				logging.Session(client.sessionID), "ip", client.VirtualIP(), "idle_timeout", idleTimeout)
+		}
+	}
+}
+
+// setupPortHop перенаправляет диапазон портов port hopping на порт, который слушает сервер
+func (s *Server) setupPortHop() error {
+	_, portStr, err := net.SplitHostPort(s.listenAddrs[0])
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port == 0 {
+		return fmt.Errorf("port hopping requires an explicit listen port, got %q", s.listenAddrs[0])
+	}
+	return s.networkManager.SetupPortHop(s.portHop, port)
+}
+
+// sendCoverTraffic через случайные интервалы отправляет каждому клиенту пакет-пустышку
+func (s *Server) sendCoverTraffic() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(transport.CoverDelay(s.obfuscation.CoverInterval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C:
+		}
+
+		s.clientsMu.RLock()
+		clients := make([]*Client, 0, len(s.clients))
+		for _, client := range s.clients {
+			clients = append(clients, client)
+		}
+		s.clientsMu.RUnlock()
+
+		for _, client := range clients {
+			if err := s.transport.WriteCover(client.RemoteAddr(), client.sessionID); err != nil {
+				logTransport.Debug("Failed to send cover packet", "remote", client.RemoteAddr(), logging.Err(err))
+			}
+		}
+		timer.Reset(transport.CoverDelay(s.obfuscation.CoverInterval))
+	}
+}
+
+// probeRTT периодически отправляет keepalive клиентам, которые отвечают на них с sequence.
+// Клиенты сами шлют keepalive редко, поэтому задержку сессии измеряет сервер
+func (s *Server) probeRTT() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(RTTProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.clientsMu.RLock()
+		var clients []*Client
+		for sessionID, client := range s.clients {
+			if s.params[sessionID].caps&internal.CapRTT != 0 {
+				clients = append(clients, client)
+			}
+		}
+		s.clientsMu.RUnlock()
+
+		for _, client := range clients {
+			if err := s.transport.WriteKeepalive(client.RemoteAddr(), client.sessionID); err != nil {
+				logTransport.Debug("Failed to send keepalive", "remote", client.RemoteAddr(), logging.Err(err))
+			}
+		}
+	}
+}
+
+// handleControl обрабатывает управляющие сообщения клиентов
+func (s *Server) handleControl(msg []byte, addr *net.UDPAddr, sessionID uint64) {
+	if s.certMismatch(addr, sessionID) {
+		metricCertMismatch.Inc()
+		logCrypto.Warn("Dropped control message: client certificate does not match the session peer", "remote", addr, logging.Session(sessionID))
+		return
+	}
+
+	// Управляющие сообщения аутентифицированы, поэтому тоже считаются активностью
+	s.clientsMu.RLock()
+	client, known := s.clients[sessionID]
+	full := !known && s.full()
+	s.clientsMu.RUnlock()
+	if known {
+		client.lastSeen.Store(time.Now().UnixNano())
+		// Запрос конфигурации - первое, что клиент отправляет после смены сети,
+		// поэтому сессия переносится на новый адрес уже по нему, не дожидаясь данных
+		if client.rebind(addr) {
+			logServer.Info("Client migrated", "ip", client.VirtualIP(), logging.Session(sessionID), "remote", addr)
+			s.publish(adminrpc.EventRoamed, client)
+		}
+	}
+
+	msgType, body, err := internal.DecodeControl(msg)
+	if err != nil {
+		logServer.Warn("Invalid control message", "remote", addr, logging.Err(err))
+		return
+	}
+
+	switch msgType {
+	case internal.ControlConfigRequest:
+		_, span := tracing.Start(context.Background(), "server.handshake",
+			attribute.String("session", fmt.Sprintf("%016x", sessionID)), attribute.String("remote", addr.String()))
+		defer span.End()
+		refuse := func(reject internal.Reject) {
+			tracing.Fail(span, errors.New(reject.Reason))
+			s.reject(addr, sessionID, reject)
+		}
+		if full {
+			refuse(s.fullReject())
+			return
+		}
+		// Старые клиенты присылают запрос без тела, им отправляем пакеты без сжатия
+		var req internal.ConfigRequest
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				logServer.Warn("Invalid config request", "remote", addr, logging.Err(err))
+				return
+			}
+		}
+		span.SetAttributes(attribute.Int("version", int(req.Version)))
+		if req.Version < s.minVersion {
+			refuse(internal.Reject{
+				Reason:     fmt.Sprintf("protocol version %d is not supported (minimum %d)", req.Version, s.minVersion),
+				RetryAfter: int(RejectRetryAfter / time.Second),
+				MinVersion: s.minVersion,
+			})
+			return
+		}
+		if reject := s.checkBan(sessionID); reject != nil {
+			refuse(*reject)
+			return
+		}
+		if reject := s.verifyTOTP(sessionID, req.TOTP); reject != nil {
+			refuse(*reject)
+			return
+		}
+		if s.auth != nil && !s.sessionVerified(sessionID) {
+			// Проверка идет в отдельной горутине, спан заканчивается на ее запуске
+			span.SetAttributes(attribute.Bool("auth_pending", true))
+			s.authenticate(req, addr, sessionID)
+			return
+		}
+		s.configure(req, addr, sessionID, "")
+	default:
+		logServer.Debug("Unknown control message", "type", msgType, "remote", addr)
+	}
+}
+
+// configure согласует параметры сессии по запросу конфигурации и отправляет клиенту
+// конфигурацию. user - пользователь, подтвержденный внешней аутентификацией
+// (пусто - проверки не было или сессия прошла ее раньше)
+func (s *Server) configure(req internal.ConfigRequest, addr *net.UDPAddr, sessionID uint64, user string) {
+	if user == "" {
+		s.clientsMu.RLock()
+		user = s.params[sessionID].user
+		s.clientsMu.RUnlock()
+	}
+	params := sessionParams{
+		codec:   compress.CodecNone,
+		bond:    req.Bond,
+		p2p:     req.P2P && s.p2p,
+		version: req.Version,
+		caps:    req.Capabilities & internal.Capabilities,
+		user:    user,
+		issued:  time.Now(),
+	}
+	if !s.compressionOff {
+		params.codec = compress.Negotiate(s.compression, req.Codecs)
+	}
+	var lease *addressLease
+	if req.AssignIP {
+		var err error
+		if lease, err = s.leaseAddress(sessionID); err != nil {
+			logServer.Warn("Failed to assign address", logging.Session(sessionID), logging.Err(err))
+			s.reject(addr, sessionID, internal.Reject{
+				Reason:     err.Error(),
+				RetryAfter: int(RejectRetryAfter / time.Second),
+			})
+			return
+		}
+		params.ip = lease.ip.String()
+		if lease.ip6 != nil {
+			params.ip6 = lease.ip6.String()
+		}
+	}
+	s.setSessionParams(sessionID, params)
+	s.setSessionFEC(sessionID, req.FEC, addr)
+	cfg := s.clientConfig(lease)
+	cfg.P2P = params.p2p
+	resp, err := internal.EncodeControl(internal.ControlConfig, cfg)
+	if err != nil {
+		logServer.Error("Failed to encode client config", logging.Err(err))
+		return
+	}
+	if err := s.transport.WriteControl(resp, addr, sessionID); err != nil {
+		logTransport.Warn("Failed to send config", "remote", addr, logging.Err(err))
+	}
+}
+
+// handleDecryptFailure считает пакеты сессии, которые не прошли проверку подлинности
+func (s *Server) handleDecryptFailure(sessionID uint64) {
+	s.clientsMu.RLock()
+	client, ok := s.clients[sessionID]
+	s.clientsMu.RUnlock()
+	if ok {
+		client.authErrors.Add(1)
+	}
+}
+
+// handleDisconnect сразу удаляет сессию клиента, который сообщил об отключении,
+// не дожидаясь таймаута неактивности
+func (s *Server) handleDisconnect(addr *net.UDPAddr, sessionID uint64) {
+	logServer.Debug("Session sent disconnect", logging.Session(sessionID), "remote", addr)
+	if s.DisconnectClient(sessionID) {
+		return
+	}
+	// Клиент отключился, не успев отправить данные
+	s.clientsMu.Lock()
+	delete(s.params, sessionID)
+	s.clientsMu.Unlock()
+	s.pool.release(sessionID)
+	s.keyring.ForgetSession(sessionID)
+	s.transport.ForgetSession(sessionID)
+}
+
+// notifyDisconnect сообщает клиентам, что сервер останавливается, чтобы они сразу
+// начали переподключение. Старые клиенты, не знающие этого сообщения, его не получают
+func (s *Server) notifyDisconnect() {
+	s.clientsMu.RLock()
+	var clients []*Client
+	for sessionID, client := range s.clients {
+		if s.params[sessionID].caps&internal.CapDisconnect != 0 {
+			clients = append(clients, client)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	for _, client := range clients {
+		if err := s.transport.WriteDisconnect(client.RemoteAddr(), client.sessionID); err != nil {
+			logTransport.Debug("Failed to send disconnect", "remote", client.RemoteAddr(), logging.Err(err))
+		}
+	}
+}
+
+// full проверяет, достигнут ли лимит клиентов. Требует s.clientsMu
+func (s *Server) full() bool {
+	maxClients := s.maxClients.Load()
+	return maxClients > 0 && int64(len(s.clients)) >= maxClients
+}
+
+// fullReject возвращает отказ из-за лимита клиентов
+func (s *Server) fullReject() internal.Reject {
+	return internal.Reject{
+		Reason:     fmt.Sprintf("server is full (%d clients)", s.maxClients.Load()),
+		RetryAfter: int(RejectRetryAfter / time.Second),
+	}
+}
+
+// reject отправляет клиенту отказ и забывает его сессию
+func (s *Server) reject(addr *net.UDPAddr, sessionID uint64, reject internal.Reject) {
+	metricRejected.Inc()
+	logServer.Debug("Rejected session", logging.Session(sessionID), "remote", addr, "reason", reject.Reason)
+
+	msg, err := internal.EncodeControl(internal.ControlReject, reject)
+	if err != nil {
+		logServer.Error("Failed to encode reject", logging.Err(err))
+		return
+	}
+	if err := s.transport.WriteControl(msg, addr, sessionID); err != nil {
+		logTransport.Debug("Failed to send reject", "remote", addr, logging.Err(err))
+	}
+	s.clientsMu.Lock()
+	delete(s.params, sessionID)
+	s.clientsMu.Unlock()
+	s.pool.release(sessionID)
+	s.keyring.ForgetSession(sessionID)
+	s.transport.ForgetSession(sessionID)
+}
+
+// leaseAddress выдает сессии виртуальные адреса. Адреса, которые клиенты со старыми
+// настройками назначили себе сами, пропускаются
+func (s *Server) leaseAddress(sessionID uint64) (*addressLease, error) {
+	// Пиру с открытым ключом назначаются адреса из его AllowedIPs, иначе он не смог бы ими пользоваться
+	if name, ok := s.keyring.SessionPeer(sessionID); ok {
+		if peer := s.keyPeer(name); peer != nil {
+			if peer.ip == nil {
+				return nil, fmt.Errorf("peer %q has no /32 address in allowed IPs", name)
+			}
+			return s.pool.assign(sessionID, peer.ip, peer.ip6)
+		}
+	}
+
+	// Адреса из AllowedIPs пиров, в том числе их подсетей, другим сессиям не выдаются
+	keyPeers := s.keyPeers.Load()
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	return s.pool.lease(sessionID, func(ip, ip6 net.IP) bool {
+		if keyPeers.reserved(ip, ip6) {
+			return true
+		}
+		client, ok := s.clientsByIP[ip.String()]
+		return ok && client.sessionID != sessionID
+	})
+}
+
+// setSessionParams запоминает параметры, согласованные с сессией. Клиент может появиться
+// только с первым пакетом данных, тогда они применяются при его создании. Пакеты сессии
+// с bonding обгоняют друг друга на разных путях, поэтому ее anti-replay окно увеличивается
+// (обратно оно не уменьшается)
+func (s *Server) setSessionParams(sessionID uint64, params sessionParams) {
+	s.clientsMu.Lock()
+	s.params[sessionID] = params
+	if client, ok := s.clients[sessionID]; ok {
+		client.apply(params)
+	}
+	s.clientsMu.Unlock()
+	if params.bond {
+		s.transport.SetReplayWindow(sessionID, transport.MultipathWindowSize)
+	}
+	logServer.Debug("Session configured", logging.Session(sessionID), "version", params.version,
+		"capabilities", internal.FormatCapabilities(params.caps), "codec", params.codec.String(), "bonding", params.bond)
+}
+
+// setSessionFEC включает FEC для пакетов к сессии, если клиент его запросил.
+// Клиент, не запросивший FEC, получает пакеты без избыточности
+func (s *Server) setSessionFEC(sessionID uint64, value string, addr *net.UDPAddr) {
+	fec, err := transport.ParseFEC(value)
+	if err == nil {
+		err = s.transport.SetSessionFEC(sessionID, fec)
+	}
+	if err != nil {
+		logTransport.Warn("Invalid FEC request", "remote", addr, logging.Err(err))
+		return
+	}
+	if fec.Enabled() {
+		logTransport.Debug("Session uses FEC", logging.Session(sessionID), "fec", fec.String())
+	}
+}
+
+// clientConfig возвращает конфигурацию, которую получают клиенты при подключении.
+// lease - адреса, назначенные клиенту (nil, если клиент выбрал адрес сам)
+func (s *Server) clientConfig(lease *addressLease) internal.ClientConfig {
+	s.configMu.RLock()
+	cfg := internal.ClientConfig{
+		Version:      internal.ProtocolVersion,
+		Capabilities: internal.Capabilities,
+		DNS:          s.clientDNS(),
+		MTU:          s.pushMTU,
+		Routes:       s.pushRoutes,
+	}
+	s.configMu.RUnlock()
+	if !s.compressionOff {
+		cfg.Codecs = compress.SupportedNames()
+	}
+	if lease != nil {
+		bits, bits6 := s.pool.prefixes()
+		cfg.IP = fmt.Sprintf("%s/%d", lease.ip, bits)
+		if lease.ip6 != nil {
+			cfg.IP6 = fmt.Sprintf("%s/%d", lease.ip6, bits6)
+		}
+	}
+	return cfg
+}
+
+// handleTunToClients читает пакеты из очереди TUN и отправляет клиентам
+func (s *Server) handleTunToClients(queue int) {
 	defer s.wg.Done()
 
-	packet := make([]byte, TUNMTU)
+	// С io_uring или offload одним обращением читается пачка пакетов, без них - один пакет
+	batch := 1
+	if s.ioURing || s.tun.Offload() {
+		batch = transport.BatchSize
+	}
+	bufs := make([][]byte, batch)
+	for i := range bufs {
+		bufs[i] = make([]byte, TUNMTU)
+	}
+	sizes := make([]int, batch)
 
 	for {
 		select {
@@ -158,115 +1205,423 @@ func (s *Server) handleTunToClients() {
 		default:
 		}
 
-		n, err := s.tun.Read(packet)
+		n, err := s.tun.ReadQueueBatch(queue, bufs, sizes)
 		if err != nil {
 			select {
 			case <-s.done:
 				return
 			default:
 				if err != io.EOF {
-					log.Printf("Error reading from TUN: %v", err)
+					logTUN.Error("Failed to read from TUN", logging.Err(err))
 				}
+				s.tunErrors.Add(1)
 				continue
 			}
 		}
 
-		if n > 0 {
-			// Проверяем IPv4 заголовок
-			if n >= 20 && packet[0]>>4 == 4 {
-				// Извлекаем Destination IP
-				destIP := net.IPv4(packet[16], packet[17], packet[18], packet[19]).String()
-				
-				s.clientsMu.RLock()
-				client, ok := s.clientsByIP[destIP]
-				s.clientsMu.RUnlock()
-
-				if ok {
-					if err := client.SendPacket(s.transport, packet[:n]); err != nil {
-						if s.verbose {
-							log.Printf("Error sending packet to client %s: %v", client.remoteAddr, err)
-						}
-					}
-				} else {
-					if s.verbose {
-						log.Printf("Dropped packet for unknown virtual IP: %s", destIP)
-					}
-				}
+		if s.tunErrors.Load() != 0 {
+			s.tunErrors.Store(0)
+		}
+
+		for i := 0; i < n; i++ {
+			if sizes[i] > 0 && !s.forwardFromTun(bufs[i][:sizes[i]]) {
+				return
+			}
+		}
+	}
+}
+
+// forwardFromTun передает пакет из TUN клиенту, которому он адресован.
+// Возвращает false, если сервер остановлен
+func (s *Server) forwardFromTun(packet []byte) bool {
+	metricTunPacketsIn.Inc()
+	metricTunBytesIn.Add(uint64(len(packet)))
+	if c := s.capture.Load(); c != nil {
+		c.Inner(packet)
+	}
+	if s.flows != nil {
+		s.flows.Record(packet, true)
+	}
+
+	// Извлекаем Destination IP (IPv4 или IPv6)
+	dst, ok := internal.PacketDestIP(packet)
+	if !ok {
+		return true
+	}
+	destIP := dst.String()
+
+	s.clientsMu.RLock()
+	// Адреса в AllowedIPs пира, в том числе хосты его подсетей, принадлежат пиру
+	var client *Client
+	if owner := s.keyPeers.Load().owner(dst); owner != "" {
+		client, ok = s.peerClients[owner]
+	} else {
+		client, ok = s.clientsByIP[destIP]
+	}
+	var from *Client
+	if ok && client.p2p.Load() {
+		// Пакет между двумя клиентами с прямым обменом: сервер их знакомит
+		if src, found := internal.PacketSourceIP(packet); found {
+			from = s.clientsByIP[src.String()]
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	if !ok {
+		metricUnknownDest.Inc()
+		if logging.DebugEnabled() {
+			logTUN.Debug("Dropped packet for unknown virtual IP", "ip", destIP)
+		}
+		return true
+	}
+
+	if from != nil && from != client && from.p2p.Load() {
+		s.meet(from, client)
+	}
+
+	p, send, err := client.preparePacket(packet, s.adaptive, s.sched != nil)
+	if err != nil {
+		if logging.DebugEnabled() {
+			logTransport.Debug("Failed to prepare packet for client", "remote", client.RemoteAddr(), logging.Err(err))
+		}
+	} else if send && !s.enqueue(client, p, packet) {
+		// Пакет отброшен политикой очереди или сервер останавливается
+		select {
+		case <-s.done:
+			return false
+		default:
+		}
+	}
+	return true
+}
+
+// enqueue ставит пакет к клиенту в очередь отправки. С шейпингом пакет попадает в очередь
+// клиента своего класса (по исходному пакету inner), и лимит скорости клиента его задерживает
+func (s *Server) enqueue(client *Client, p transport.Packet, inner []byte) bool {
+	if s.sched != nil {
+		return s.sched.Push(p.SessionID, pktsched.Classify(inner), p, len(inner), client.downLimit.Load())
+	}
+	return s.outgoing.Push(p, s.done)
+}
+
+// outQueue очередь пакетов к клиентам: общая (pktqueue) или по клиентам (pktsched)
+type outQueue interface {
+	Pop(done <-chan struct{}) (transport.Packet, bool)
+	TryPop() (transport.Packet, bool)
+}
+
+// sendToClients отправляет подготовленные пакеты клиентам. Все, что успело
+// накопиться в очереди, уходит одним вызовом sendmmsg
+func (s *Server) sendToClients() {
+	defer s.wg.Done()
+
+	var queue outQueue = s.outgoing
+	if s.sched != nil {
+		queue = s.sched
+	}
+	batch := make([]transport.Packet, 0, transport.BatchSize)
+	for {
+		p, ok := queue.Pop(s.done)
+		if !ok {
+			return
+		}
+		batch = append(batch[:0], p)
+		for len(batch) < transport.BatchSize {
+			p, ok := queue.TryPop()
+			if !ok {
+				break
+			}
+			batch = append(batch, p)
+		}
+
+		if _, err := s.transport.WriteBatch(batch); err != nil {
+			if logging.DebugEnabled() {
+				logTransport.Debug("Failed to send packets to clients", logging.Err(err))
+			}
+		}
+		for i, p := range batch {
+			if p.Err != nil && logging.DebugEnabled() {
+				logTransport.Debug("Failed to send packet to client", "remote", p.Addr, logging.Err(p.Err))
 			}
+			bufpool.PutDatagram(p.Data)
+			batch[i].Data = nil
 		}
 	}
 }
 
-// handleClientsToTun читает пакеты от клиентов и записывает в TUN
-func (s *Server) handleClientsToTun() {
+// handleClientsToTun читает пакеты от клиентов из сокета shard пачками (recvmmsg) и записывает в TUN
+func (s *Server) handleClientsToTun(shard int) {
 	defer s.wg.Done()
 
-	// MaxPacketSize в транспорте = 1467 байт (это максимальный размер данных без UDP заголовка)
-	buf := make([]byte, transport.MaxPacketSize)
+	// MaxPacketSize в транспорте = 1454 байта (это максимальный размер данных без UDP заголовка)
+	pkts := make([]transport.Packet, transport.BatchSize)
+	for i := range pkts {
+		pkts[i].Data = make([]byte, transport.MaxPacketSize)
+	}
 
 	for {
 		select {
 		case <-s.done:
 			return
 		default:
-			n, isCompressed, remoteAddr, err := s.transport.Read(buf)
-			if err != nil {
-				select {
-				case <-s.done:
-					return
-				default:
-					log.Printf("Error reading from UDP: %v", err)
-					continue
-				}
+		}
+
+		n, err := s.transport.ReadShard(shard, pkts)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+				logTransport.Error("Failed to read from UDP", logging.Err(err))
+				s.udpErrors.Add(1)
+				continue
 			}
+		}
+		if s.udpErrors.Load() != 0 {
+			s.udpErrors.Store(0)
+		}
 
-			if n > 0 {
-				if remoteAddr == nil {
-					continue
-				}
+		for i := range pkts[:n] {
+			s.handleClientPacket(&pkts[i])
+		}
+	}
+}
 
-				packet := buf[:n]
+// handleClientPacket регистрирует клиента по расшифрованному пакету и записывает пакет в TUN
+func (s *Server) handleClientPacket(p *transport.Packet) {
+	if p.Err != nil {
+		logTransport.Warn("Failed to read packet", logging.Err(p.Err))
+		return
+	}
+	if len(p.Data) == 0 || p.Addr == nil {
+		return
+	}
 
-				// Распаковываем если нужно
-				if isCompressed {
-					packet, err = compress.Decompress(packet, true)
-					if err != nil {
-						log.Printf("Error decompressing packet from %s: %v", remoteAddr, err)
-						continue
-					}
-				}
+	remoteAddr, sessionID := p.Addr, p.SessionID
+	packet := p.Data
+
+	// Через TLS транспорт с проверкой сертификатов пир подключается только со своим сертификатом
+	if s.certMismatch(remoteAddr, sessionID) {
+		metricCertMismatch.Inc()
+		if logging.DebugEnabled() {
+			logCrypto.Debug("Dropped packet: client certificate does not match the session peer", "remote", remoteAddr, logging.Session(sessionID))
+		}
+		return
+	}
+
+	// Распаковываем если нужно. Буфер из пула освобождается, когда пакет записан или скопирован
+	if p.Codec != compress.CodecNone {
+		buf := bufpool.GetDatagram()
+		defer bufpool.PutDatagram(buf)
+		var err error
+		span := tracing.PacketSpan("decompress", len(packet))
+		packet, err = compress.DecompressTo(buf[:0], packet, p.Codec)
+		span.End()
+		if err != nil {
+			metricDecompressFail.Inc()
+			logTransport.Warn("Failed to decompress packet", "remote", remoteAddr, logging.Err(err))
+			return
+		}
+	}
 
-				if len(packet) > 0 {
-					// Проверяем IPv4 пакет и извлекаем Source IP (виртуальный IP клиента)
-					if len(packet) >= 20 && packet[0]>>4 == 4 {
-						srcIP := net.IPv4(packet[12], packet[13], packet[14], packet[15]).String()
-						
-						// Регистрируем/обновляем клиента уже ПОСЛЕ успешной дешифровки пакета!
-						clientKey := remoteAddr.String()
-						s.clientsMu.Lock()
-						client, exists := s.clients[clientKey]
-						if !exists {
-							client = NewClient(remoteAddr, s.crypto, s.tun, s.verbose)
-							s.clients[clientKey] = client
-							log.Printf("New client connected from %s with virtual IP %s", remoteAddr, srcIP)
-						}
-						// Обновляем маппинг по IP
-						if s.clientsByIP[srcIP] != client {
-							s.clientsByIP[srcIP] = client
-						}
-						s.clientsMu.Unlock()
-					}
-
-					if s.verbose {
-						log.Printf("Received %d bytes from client %s, writing to TUN", len(packet), remoteAddr)
-					}
-					// Записываем пакет в TUN
-					if _, err := s.tun.Write(packet); err != nil {
-						log.Printf("Error writing packet to TUN: %v", err)
-					}
+	if len(packet) == 0 {
+		return
+	}
+
+	// Извлекаем Source IP (виртуальный IPv4/IPv6 адрес клиента)
+	if src, ok := internal.PacketSourceIP(packet); ok {
+		srcIP := src.String()
+
+		// Регистрируем/обновляем клиента уже ПОСЛЕ успешной дешифровки пакета!
+		// Переносим сессию на новый адрес только по аутентифицированному пакету
+		s.clientsMu.Lock()
+		client, exists := s.clients[sessionID]
+		if !exists && s.full() {
+			s.clientsMu.Unlock()
+			s.reject(remoteAddr, sessionID, s.fullReject())
+			return
+		}
+		params, negotiated := s.params[sessionID]
+		if params.ip != "" && srcIP != params.ip && srcIP != params.ip6 {
+			// Клиент с назначенным адресом не может отправлять пакеты от чужого адреса
+			s.clientsMu.Unlock()
+			metricSpoofed.Inc()
+			if logging.DebugEnabled() {
+				logServer.Debug("Dropped packet with spoofed source", logging.Session(sessionID), "src", srcIP, "assigned", params.ip)
+			}
+			return
+		}
+		var keyPeer string // пир с открытым ключом, которому принадлежит сессия
+		if keyPeers := s.keyPeers.Load(); len(keyPeers.peers) > 0 {
+			if exists {
+				keyPeer = client.peer
+			} else {
+				keyPeer, _ = s.keyring.SessionPeer(sessionID)
+			}
+			if keyPeers.peers[keyPeer] == nil {
+				keyPeer = ""
+			}
+			// Пир с открытым ключом может отправлять пакеты только от адресов из своих AllowedIPs,
+			// а остальные сессии - только от адресов вне AllowedIPs пиров: иначе ответы пиру
+			// ушли бы чужой сессии
+			if owner := keyPeers.owner(src); owner != keyPeer {
+				s.clientsMu.Unlock()
+				metricSpoofed.Inc()
+				if logging.DebugEnabled() {
+					logServer.Debug("Dropped packet with source outside allowed IPs", "peer", keyPeer, logging.Session(sessionID), "src", srcIP, "owner", owner)
 				}
+				return
 			}
 		}
+		if !exists && !negotiated {
+			if peer, _ := s.keyring.SessionPeer(sessionID); s.auth != nil || s.totpRequired(peer) || s.banRemaining(peer) > 0 {
+				// С внешней аутентификацией, TOTP или баном пира данные принимаются только
+				// после запроса конфигурации, прошедшего проверку
+				s.clientsMu.Unlock()
+				return
+			}
+		}
+		if !exists && s.minVersion > 0 && (!negotiated || params.version < s.minVersion) {
+			// Версию клиент сообщает в запросе конфигурации. Пока ее нет, данные не принимаются
+			s.clientsMu.Unlock()
+			if logging.DebugEnabled() {
+				logServer.Debug("Dropped packet: protocol version not negotiated", logging.Session(sessionID), "remote", remoteAddr)
+			}
+			return
+		}
+		if !exists {
+			peer, _ := s.keyring.SessionPeer(sessionID)
+			client = NewClient(sessionID, remoteAddr, peer, s.tun)
+			client.setLimit(s.limitFor(peer))
+			client.apply(params)
+			s.clients[sessionID] = client
+			logServer.Info("New client connected", "remote", remoteAddr, "ip", srcIP, logging.Session(sessionID), "peer", peer)
+		}
+		roamed := exists && client.rebind(remoteAddr)
+		if roamed && client.bond.Load() {
+			logServer.Info("Client added path", "ip", srcIP, logging.Session(sessionID), "remote", remoteAddr)
+		} else if roamed {
+			logServer.Info("Client roamed", "ip", srcIP, logging.Session(sessionID), "remote", remoteAddr)
+		}
+		// Обновляем маппинг по IP
+		if s.clientsByIP[srcIP] != client {
+			s.clientsByIP[srcIP] = client
+			client.setVirtualIP(srcIP)
+		}
+		if keyPeer != "" && s.peerClients[keyPeer] != client {
+			s.peerClients[keyPeer] = client
+		}
+		s.clientsMu.Unlock()
+
+		if !exists {
+			s.publish(adminrpc.EventConnected, client)
+		} else if roamed {
+			s.publish(adminrpc.EventRoamed, client)
+		}
+		if s.mesh && (!exists || roamed) && client.p2p.Load() {
+			go s.joinMesh(client)
+		}
+		client.lastSeen.Store(time.Now().UnixNano())
+		if !client.allowUpload(len(packet)) {
+			metricRateLimitUp.Inc()
+			return
+		}
+		client.rxPackets.Add(1)
+		client.rxBytes.Add(uint64(len(packet)))
+	}
+
+	if logging.DebugEnabled() {
+		logTUN.Debug("Writing packet from client to TUN", "bytes", len(packet), "remote", remoteAddr)
+	}
+
+	if c := s.capture.Load(); c != nil {
+		c.Inner(packet)
+	}
+	if s.flows != nil {
+		s.flows.Record(packet, false)
+	}
+
+	if len(s.tunWriters) == 0 {
+		s.writeTun(0, packet)
+		return
+	}
+	// Пакеты одного потока попадают в одну очередь, поэтому их порядок сохраняется.
+	// Буфер пачки будет переиспользован, поэтому пакет копируем в буфер из пула,
+	// который writeTunQueue возвращает после записи
+	packets := s.tunWriters[internal.FlowHash(packet)%uint32(len(s.tunWriters))]
+	packets.Push(append(bufpool.GetDatagram()[:0], packet...), s.done)
+}
+
+// writeTunQueue записывает в очередь TUN пакеты, которые раздает handleClientPacket.
+// Все, что успело накопиться в очереди, записывается одной пачкой
+func (s *Server) writeTunQueue(queue int, packets *pktqueue.Queue[[]byte]) {
+	defer s.wg.Done()
+
+	batch := make([][]byte, 0, transport.BatchSize)
+	for {
+		packet, ok := packets.Pop(s.done)
+		if !ok {
+			return
+		}
+		batch = append(batch[:0], packet)
+		for len(batch) < cap(batch) {
+			packet, ok := packets.TryPop()
+			if !ok {
+				break
+			}
+			batch = append(batch, packet)
+		}
+
+		s.writeTunBatch(queue, batch)
+		for i, packet := range batch {
+			bufpool.PutDatagram(packet)
+			batch[i] = nil
+		}
+	}
+}
+
+// writeTunBatch записывает пачку пакетов в очередь TUN. Пакет, который не удалось
+// записать, пропускается, остальные записываются
+func (s *Server) writeTunBatch(queue int, packets [][]byte) {
+	size := 0
+	for _, packet := range packets {
+		size += len(packet)
+	}
+	span := tracing.PacketSpan("tun.write", size)
+	defer span.End()
+
+	for len(packets) > 0 {
+		n, err := s.tun.WriteQueueBatch(queue, packets)
+		for _, packet := range packets[:n] {
+			metricTunPacketsOut.Inc()
+			metricTunBytesOut.Add(uint64(len(packet)))
+		}
+		if n > 0 && s.tunErrors.Load() != 0 {
+			s.tunErrors.Store(0)
+		}
+		if err == nil {
+			return
+		}
+		logTUN.Error("Failed to write packet to TUN", logging.Err(err))
+		s.tunErrors.Add(1)
+		packets = packets[n+1:]
+	}
+}
+
+// writeTun записывает пакет в очередь TUN
+func (s *Server) writeTun(queue int, packet []byte) {
+	span := tracing.PacketSpan("tun.write", len(packet))
+	_, err := s.tun.WriteQueue(queue, packet)
+	span.End()
+	if err != nil {
+		logTUN.Error("Failed to write packet to TUN", logging.Err(err))
+		s.tunErrors.Add(1)
+	} else {
+		if s.tunErrors.Load() != 0 {
+			s.tunErrors.Store(0)
+		}
+		metricTunPacketsOut.Inc()
+		metricTunBytesOut.Add(uint64(len(packet)))
 	}
 }
 
@@ -276,14 +1631,28 @@ func (s *Server) Stop() error {
 
 	var errs []error
 
+	s.stopStreams()
+	if s.dnsForwarder != nil {
+		s.dnsForwarder.Close()
+	}
+
 	if s.transport != nil {
+		s.notifyDisconnect()
+
 		if err := s.transport.Close(); err != nil {
 			errs = append(errs, err)
 		}
 	}
+	if s.tun != nil {
+		s.tun.InterruptReads()
+	}
 
 	s.wg.Wait()
 
+	if c := s.capture.Load(); c != nil {
+		s.stopCapture(c)
+	}
+
 	// Восстанавливаем сетевые настройки
 	if s.networkManager != nil {
 		if err := s.networkManager.Cleanup(); err != nil {
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"time"
+
+	"myvpn/internal/auth"
+	"myvpn/internal/compress"
+	"myvpn/internal/flowexport"
+	"myvpn/internal/peerdb"
+	"myvpn/internal/pktqueue"
+	"myvpn/internal/porthop"
+	"myvpn/internal/transport"
+)
+
+const (
+	// DefaultIdleTimeout время без пакетов от клиента, после которого сессия удаляется
+	DefaultIdleTimeout = 5 * time.Minute
+	// IdleCheckInterval период проверки неактивных сессий
+	IdleCheckInterval = 30 * time.Second
+	// RTTProbeInterval период keepalive, которыми сервер измеряет RTT и потери клиентов
+	RTTProbeInterval = 10 * time.Second
+	// RejectRetryAfter через сколько клиенту, получившему отказ, стоит повторить попытку
+	RejectRetryAfter = 30 * time.Second
+	// DefaultWSSPath путь WebSocket обработчика по умолчанию
+	DefaultWSSPath = "/vpn"
+	// PathTimeout время без пакетов с пути клиента с bonding, после которого пакеты
+	// к клиенту перестают по нему отправляться
+	PathTimeout = 15 * time.Second
+	// DefaultStateFile файл состояния сервера с добавленными цепочками и таблицами firewall
+	DefaultStateFile = "/run/myvpn.state"
+)
+
+// Config параметры VPN сервера
+type Config struct {
+	// ListenAddr адреса UDP сокетов через запятую (host:port,host:port). Первый - основной:
+	// на него перенаправляются порты port hopping и пакеты TCP, WebSocket и KCP транспортов
+	ListenAddr string
+	// Key ключ шифрования пира DefaultPeer (32 байта)
+	Key []byte
+	// DNSServers передаются клиентам при подключении (может быть пустым)
+	DNSServers []string
+	// DNSForward вышестоящие серверы встроенного DNS прокси на адресах сервера внутри VPN
+	// (host:port или https:// URL для DNS over HTTPS; пусто - прокси выключен). Если
+	// DNSServers пуст, клиентам передается адрес прокси
+	DNSForward []string
+	// DNSCache сколько ответов DNS прокси хранит в кэше (0 - без кэша)
+	DNSCache int
+	// IdleTimeout время неактивности, после которого сессия клиента удаляется. 0 - не удалять
+	IdleTimeout time.Duration
+	// MaxClients максимальное число одновременных сессий. 0 - без ограничения
+	MaxClients int
+	// DefaultLimit лимит скорости для каждого клиента, если для его пира не задан свой
+	DefaultLimit RateLimit
+	// PeerLimits лимиты скорости по именам пиров
+	PeerLimits map[string]RateLimit
+	// TUNQueues число очередей TUN (IFF_MULTI_QUEUE). 0 или 1 - одна очередь
+	TUNQueues int
+	// IOURing пакетный ввод-вывод UDP сокета и TUN через io_uring (-io-engine=uring)
+	IOURing bool
+	// TUNOffload IFF_VNET_HDR с TSO и checksum offload на TUN (-tun-offload)
+	TUNOffload bool
+	// TUNFD дескриптор TUN, который открыл и настроил другой процесс (0 - открыть
+	// /dev/net/tun самому). С ним TUNQueues и TUNOffload не используются (-tun-fd)
+	TUNFD int
+	// Netstack вместо TUN: TCP и UDP клиентов завершаются в userspace стеке, и сервер
+	// открывает вместо них обычные сокеты. IP forwarding, NAT, правила firewall и права
+	// root не нужны; ICMP не пересылается, Firewall и StateFile не используются (-netstack)
+	Netstack bool
+	// ListenShards число UDP сокетов на ListenAddr (SO_REUSEPORT), у каждого своя
+	// горутина чтения. 0 или 1 - один сокет
+	ListenShards int
+	// QueueSize размер очередей между чтением TUN и отправкой в сокет и между чтением
+	// сокета и записью в TUN, в пакетах. 0 - по умолчанию
+	QueueSize int
+	// QueuePolicy что делать с пакетом, когда очередь не успевает разгружаться
+	QueuePolicy pktqueue.Policy
+	// Shaping очереди к клиентам по клиентам с классами interactive/bulk вместо общей
+	// очереди; лимит скорости к клиенту задерживает пакеты, а не отбрасывает (-shaping)
+	Shaping bool
+	// MSSClamp MSS clamping TCP соединений через туннель: MSSClampPMTU (по MTU маршрута),
+	// фиксированный MSS для IPv4 (для IPv6 на 20 меньше) или MSSClampOff
+	MSSClamp int
+	// Firewall чем настраивать NAT и правила фильтрации: FirewallAuto, FirewallIptables,
+	// FirewallNftables или FirewallNone (правила настраивает CNI, MSSClamp не действует)
+	Firewall string
+	// StateFile файл, в который записываются цепочки iptables и таблицы nftables сервера:
+	// после аварийного завершения следующий запуск удаляет их (пусто - выключено)
+	StateFile string
+	// P2P знакомить клиентов, запросивших прямой обмен, когда сервер пересылает пакеты
+	// между ними: клиенты получают внешние адреса друг друга и пробивают NAT (-p2p)
+	P2P bool
+	// Mesh знакомить клиента с прямым обменом со всеми такими клиентами сразу при
+	// подключении, а не при первом пересланном пакете. Включает P2P (-mesh)
+	Mesh bool
+	// Compression предпочтительный кодек для сжатия пакетов к клиентам. CodecNone - auto:
+	// первый кодек, который поддерживает клиент
+	Compression compress.Codec
+	// DisableCompression выключает сжатие: сервер не сжимает пакеты и не предлагает кодеки клиентам,
+	// поэтому и они отправляют пакеты без сжатия
+	DisableCompression bool
+	// CryptoWorkers число горутин, параллельно шифрующих и расшифровывающих пачки пакетов.
+	// 0 или 1 - в горутинах чтения и отправки
+	CryptoWorkers int
+	// Obfuscation выравнивание размеров пакетов к клиентам и пакеты-пустышки
+	Obfuscation transport.Obfuscation
+	// DSCP внешних датаграмм: 1-63 - фиксированное значение, transport.DSCPCopy - копия
+	// DSCP внутреннего пакета, 0 - не менять
+	DSCP int
+	// PortHop диапазон портов, которые перенаправляются на ListenAddr для клиентов
+	// с port hopping (нулевой - выключено)
+	PortHop porthop.Range
+	// TCPListen адрес TCP порта для клиентов, у которых заблокирован UDP (пустая строка - выключено)
+	TCPListen string
+	// TCPTLS принимать TCP клиентов через TLS с сертификатом WSSCert и ключом WSSKey
+	TCPTLS bool
+	// WSSListen адрес HTTPS сервера для клиентов через WebSocket (пустая строка - выключено)
+	WSSListen string
+	// WSSCert и WSSKey пути к TLS сертификату и ключу WebSocket сервера
+	WSSCert string
+	WSSKey  string
+	// ClientCA путь к PEM сертификатам центров, которыми подписаны сертификаты клиентов.
+	// Если задан, TLS транспорты (WSS и TCP с TLS) принимают только клиентов с таким сертификатом,
+	// а имя из сертификата должно совпадать с пиром сессии (пустая строка - без сертификатов)
+	ClientCA string
+	// WSSPath путь WebSocket обработчика (пустая строка - DefaultWSSPath)
+	WSSPath string
+	// Fallback сайт для соединений не клиентов на WSS и TCP портах: URL http(s)://, на который
+	// проксируются запросы, или каталог с файлами (пустая строка - WSS отвечает 404,
+	// а TCP соединение закрывается)
+	Fallback string
+	// KCPListen UDP адрес для клиентов через KCP (пустая строка - выключено).
+	// Должен отличаться от ListenAddr
+	KCPListen string
+	// GRPCListen адрес gRPC сервера (HTTP/2 с TLS, сертификат WSSCert) для клиентов за CDN
+	// и L7 балансировщиками (пустая строка - выключено)
+	GRPCListen string
+	// GRPCService имя сервиса gRPC (пустая строка - transport.DefaultGRPCService)
+	GRPCService string
+	// DNSTunnelListen UDP адрес авторитетного DNS сервера зоны DNSTunnelZone для клиентов
+	// через DNS туннель (обычно :53, пустая строка - выключено)
+	DNSTunnelListen string
+	// DNSTunnelZone зона DNS туннеля, NS записи которой указывают на этот сервер
+	DNSTunnelZone string
+	// KCPFEC параметры FEC для KCP, должны совпадать с клиентскими
+	KCPFEC transport.FEC
+	// MinProtocolVersion минимальная версия протокола клиента (internal.ProtocolVersion).
+	// Клиентам старее сервер отказывает. 0 - принимать всех, в т.ч. клиентов без согласования версий
+	MinProtocolVersion uint8
+	// PushRoutes сети (CIDR), которые клиенты направляют в VPN вместо всего трафика,
+	// если не заданы свои маршруты. Пустой список - клиенты решают сами
+	PushRoutes []string
+	// PushMTU MTU TUN интерфейса клиентов (0 - клиенты выбирают MTU сами)
+	PushMTU int
+	// CookieThreshold число пакетов неизвестных сессий в секунду, выше которого сервер
+	// расшифровывает их только с cookie, подтверждающим адрес отправителя (0 - выключено)
+	CookieThreshold int
+	// HandshakeRate число пакетов неизвестных сессий в секунду, которое сервер принимает
+	// с одного IP адреса (0 - без ограничения)
+	HandshakeRate float64
+	// PrivateKey закрытый ключ X25519 сервера для пиров с открытыми ключами (nil - их нет)
+	PrivateKey []byte
+	// Peers пиры с открытыми ключами и разрешенными им адресами внутри VPN
+	Peers []PeerConfig
+	// TOTPSecrets секреты TOTP пиров в base32. Пир с секретом должен прислать одноразовый код
+	// в запросе конфигурации (второй фактор к ключу)
+	TOTPSecrets map[string]string
+	// TOTPFile файл, в который admin API сохраняет выданные секреты TOTP (пустая строка - не сохранять)
+	TOTPFile string
+	// PeerDB база пиров: пиры из нее загружаются при запуске, а пиры, добавленные, отключенные
+	// и отозванные через admin API, сохраняются в ней (nil - пиры API хранятся только в памяти)
+	PeerDB *peerdb.DB
+	// Auth внешняя проверка имени и пароля клиентов (RADIUS, LDAP; nil - не требуется).
+	// Если backend реализует auth.Accounter, ему отправляются записи учета сессий
+	Auth auth.Backend
+	// Reload загружает параметры заново для перезагрузки без перезапуска (Server.Reload,
+	// SIGHUP, admin API). nil - перезагрузка недоступна
+	Reload func() (ReloadConfig, error)
+	// FlowExport учет внутренних потоков клиентов и их экспорт по NetFlow v9 или IPFIX
+	// (nil - выключен)
+	FlowExport *flowexport.Exporter
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// ServeControl принимает запросы admin API на Unix сокете path. Токен не нужен:
+// доступ ограничен правами на файл сокета (только владелец, обычно root).
+// Блокируется до ошибки приема соединений
+func (s *Server) ServeControl(path string) error {
+	// Сокет, оставшийся после аварийного завершения, мешает bind
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	defer os.Remove(path)
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to restrict control socket permissions: %w", err)
+	}
+
+	logAdmin.Info("Control socket listening", "path", path)
+	return http.Serve(ln, s.apiMux())
+}
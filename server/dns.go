@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"myvpn/internal/dnsfwd"
+)
+
+// DNSForwardPort порт DNS прокси на адресах сервера внутри VPN
+const DNSForwardPort = "53"
+
+// startDNSForwarder запускает DNS прокси на IPv4 и IPv6 адресах TUN (без IPv6 - только на IPv4)
+func (s *Server) startDNSForwarder() error {
+	if s.dnsForwarder == nil {
+		return nil
+	}
+	addrs := []string{TUNAddress}
+	if s.tun.HasIPv6() {
+		addrs = append(addrs, TUNAddress6)
+	}
+	for _, addr := range addrs {
+		ip, _, _ := net.ParseCIDR(addr)
+		listen := net.JoinHostPort(ip.String(), DNSForwardPort)
+		if err := s.dnsForwarder.Listen(listen); err != nil {
+			s.dnsForwarder.Close()
+			return fmt.Errorf("failed to start DNS forwarder: %w", err)
+		}
+		logServer.Info("DNS forwarder listening", "addr", listen)
+	}
+	return nil
+}
+
+// clientDNS возвращает DNS серверы для клиентов: заданные в конфигурации, а если их нет
+// и запущен DNS прокси - адрес сервера внутри VPN. Требует s.configMu
+func (s *Server) clientDNS() []string {
+	if len(s.dnsServers) == 0 && s.dnsForwarder != nil {
+		ip, _, _ := net.ParseCIDR(TUNAddress)
+		return []string{ip.String()}
+	}
+	return s.dnsServers
+}
+
+// newDNSForwarder создает DNS прокси по параметрам сервера (nil - прокси выключен)
+func newDNSForwarder(cfg Config) (*dnsfwd.Forwarder, error) {
+	if len(cfg.DNSForward) == 0 {
+		return nil, nil
+	}
+	f, err := dnsfwd.New(dnsfwd.Options{Upstreams: cfg.DNSForward, CacheSize: cfg.DNSCache})
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS forwarder configuration: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"myvpn/adminrpc"
+)
+
+// eventBuffer размер буфера подписчика. Медленный подписчик теряет события, а не тормозит сервер
+const eventBuffer = 64
+
+// eventHub рассылает события сессий подписчикам (gRPC WatchSessions)
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan adminrpc.SessionEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan adminrpc.SessionEvent]struct{})}
+}
+
+// Subscribe подписывается на события сессий. Возвращенную функцию нужно вызвать для отписки
+func (s *Server) Subscribe() (<-chan adminrpc.SessionEvent, func()) {
+	ch := make(chan adminrpc.SessionEvent, eventBuffer)
+	s.events.mu.Lock()
+	s.events.subscribers[ch] = struct{}{}
+	s.events.mu.Unlock()
+
+	return ch, func() {
+		s.events.mu.Lock()
+		delete(s.events.subscribers, ch)
+		s.events.mu.Unlock()
+	}
+}
+
+// publish отправляет событие всем подписчикам
+func (s *Server) publish(eventType string, client *Client) {
+	event := adminrpc.SessionEvent{
+		Type:   eventType,
+		Time:   time.Now(),
+		Client: s.clientInfo(client),
+	}
+	s.account(eventType, client.sessionID, event.Client)
+
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	for ch := range s.events.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+
+	"myvpn/internal/logging"
+)
+
+// newFallbackHandler создает обработчик сайта, который отвечает на соединения не клиентов
+// VPN: адрес http(s):// - запросы проксируются на этот сайт, путь к каталогу - файлы
+// из каталога. Зондирующий сервер видит обычный HTTPS сайт, а не отказ или обрыв
+func newFallbackHandler(target string) (http.Handler, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid fallback URL %q", target)
+		}
+		return &httputil.ReverseProxy{
+			// Заголовки X-Forwarded не добавляются, Host - имя сайта
+			Rewrite: func(r *httputil.ProxyRequest) { r.SetURL(u) },
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logTransport.Debug("Fallback site request failed", "url", r.URL, logging.Err(err))
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		}, nil
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fallback: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fallback %s is neither a URL nor a directory", target)
+	}
+	return http.FileServer(http.Dir(target)), nil
+}
+
+// webSocketOrFallback передает обработчику WebSocket только запросы на открытие WebSocket,
+// а остальные запросы на тот же путь - сайту
+func webSocketOrFallback(ws, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			ws.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"myvpn/adminrpc"
+	"myvpn/internal/peerdb"
+	"myvpn/internal/totp"
+)
+
+// grpcService реализует adminrpc.Service поверх методов Server
+type grpcService struct {
+	s *Server
+}
+
+// GRPCServer возвращает gRPC сервер с сервисом управления.
+// Каждый вызов должен передавать метаданные "authorization: Bearer <token>"
+func (s *Server) GRPCServer(token string) *grpc.Server {
+	authorize := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, auth := range md.Get("authorization") {
+			if strings.HasPrefix(auth, "Bearer ") &&
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1 {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	adminrpc.Register(gs, &grpcService{s: s})
+	return gs
+}
+
+func (g *grpcService) Status(ctx context.Context, req *adminrpc.Empty) (*adminrpc.Status, error) {
+	st := g.s.Status()
+	return &st, nil
+}
+
+func (g *grpcService) ListClients(ctx context.Context, req *adminrpc.Empty) (*adminrpc.ListClientsResponse, error) {
+	return &adminrpc.ListClientsResponse{Clients: g.s.Clients()}, nil
+}
+
+func (g *grpcService) DisconnectClient(ctx context.Context, req *adminrpc.DisconnectClientRequest) (*adminrpc.Empty, error) {
+	sessionID, err := strconv.ParseUint(req.SessionID, 16, 64)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid session id")
+	}
+	if req.BanSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid ban duration")
+	}
+	if !g.s.KickClient(sessionID, req.Reason, time.Duration(req.BanSeconds)*time.Second) {
+		return nil, status.Error(codes.NotFound, "session not found")
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+func (g *grpcService) ListBans(ctx context.Context, req *adminrpc.Empty) (*adminrpc.ListBansResponse, error) {
+	return &adminrpc.ListBansResponse{Bans: g.s.Bans()}, nil
+}
+
+func (g *grpcService) LiftBan(ctx context.Context, req *adminrpc.PeerRequest) (*adminrpc.Empty, error) {
+	if !g.s.LiftBan(req.Name) {
+		return nil, status.Error(codes.NotFound, "peer is not banned")
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+func (g *grpcService) ListPeers(ctx context.Context, req *adminrpc.Empty) (*adminrpc.ListPeersResponse, error) {
+	return &adminrpc.ListPeersResponse{Peers: g.s.Peers()}, nil
+}
+
+func (g *grpcService) AddPeer(ctx context.Context, req *adminrpc.AddPeerRequest) (*adminrpc.AddPeerResponse, error) {
+	key, err := parsePeerKey(req.Key)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := g.s.AddPeer(req.Name, key); err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	return &adminrpc.AddPeerResponse{Name: req.Name, Key: hex.EncodeToString(key)}, nil
+}
+
+func (g *grpcService) RevokePeer(ctx context.Context, req *adminrpc.RevokePeerRequest) (*adminrpc.Empty, error) {
+	ok, err := g.s.RevokePeer(req.Name)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "peer not found")
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+func (g *grpcService) GetPeer(ctx context.Context, req *adminrpc.PeerRequest) (*adminrpc.PeerRecord, error) {
+	record, err := g.s.PeerRecord(req.Name)
+	if err != nil {
+		return nil, peerStatusError(err)
+	}
+	return &record, nil
+}
+
+func (g *grpcService) DisablePeer(ctx context.Context, req *adminrpc.PeerRequest) (*adminrpc.Empty, error) {
+	if err := g.s.DisablePeer(req.Name); err != nil {
+		return nil, peerStatusError(err)
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+func (g *grpcService) EnablePeer(ctx context.Context, req *adminrpc.PeerRequest) (*adminrpc.Empty, error) {
+	if err := g.s.EnablePeer(req.Name); err != nil {
+		return nil, peerStatusError(err)
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+// peerStatusError переводит ошибку операции с пиром в код gRPC
+func peerStatusError(err error) error {
+	switch {
+	case errors.Is(err, peerdb.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, peerdb.ErrRevoked):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errNoPeerDB):
+		return status.Error(codes.Unimplemented, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (g *grpcService) SetPeerLimit(ctx context.Context, req *adminrpc.SetPeerLimitRequest) (*adminrpc.Empty, error) {
+	if !g.s.SetPeerLimit(req.Name, req.Limit) {
+		return nil, status.Error(codes.NotFound, "peer not found")
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+func (g *grpcService) EnablePeerTOTP(ctx context.Context, req *adminrpc.PeerTOTPRequest) (*adminrpc.PeerTOTPResponse, error) {
+	if !g.s.keyring.Has(req.Name) {
+		return nil, status.Error(codes.NotFound, "peer not found")
+	}
+	secret, err := g.s.EnableTOTP(req.Name)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &adminrpc.PeerTOTPResponse{Secret: secret, URI: totp.URI(TOTPIssuer, req.Name, secret)}, nil
+}
+
+func (g *grpcService) DisablePeerTOTP(ctx context.Context, req *adminrpc.PeerTOTPRequest) (*adminrpc.Empty, error) {
+	ok, err := g.s.DisableTOTP(req.Name)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "TOTP is not enabled for this peer")
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+func (g *grpcService) ReloadConfig(ctx context.Context, req *adminrpc.ReloadConfigRequest) (*adminrpc.ReloadConfigResponse, error) {
+	if req.DNS == nil {
+		changes, err := g.s.Reload()
+		if errors.Is(err, errNoReload) {
+			return nil, status.Error(codes.Unimplemented, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return &adminrpc.ReloadConfigResponse{Changes: changes}, nil
+	}
+	for _, server := range req.DNS {
+		if net.ParseIP(server) == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid DNS server %q", server)
+		}
+	}
+	g.s.SetDNSServers(req.DNS)
+	return &adminrpc.ReloadConfigResponse{Changes: []string{"dns: " + formatList(req.DNS)}}, nil
+}
+
+func (g *grpcService) StartCapture(ctx context.Context, req *adminrpc.StartCaptureRequest) (*adminrpc.Empty, error) {
+	if req.LimitBytes < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	if err := g.s.StartCapture(req.Path, req.LimitBytes, req.Outer); err != nil {
+		if errors.Is(err, errCaptureRunning) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &adminrpc.Empty{}, nil
+}
+
+func (g *grpcService) StopCapture(ctx context.Context, req *adminrpc.Empty) (*adminrpc.CaptureStatus, error) {
+	st, err := g.s.StopCapture()
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &st, nil
+}
+
+func (g *grpcService) GetCapture(ctx context.Context, req *adminrpc.Empty) (*adminrpc.CaptureStatus, error) {
+	st, ok := g.s.Capture()
+	if !ok {
+		return nil, status.Error(codes.NotFound, errNoCapture.Error())
+	}
+	return &st, nil
+}
+
+func (g *grpcService) WatchSessions(req *adminrpc.Empty, stream grpc.ServerStreamingServer[adminrpc.SessionEvent]) error {
+	events, unsubscribe := g.s.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if err := stream.Send(&event); err != nil {
+				return err
+			}
+		}
+	}
+}
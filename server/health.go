@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// healthErrorLimit число ошибок ввода-вывода подряд, после которого TUN или UDP сокет
+	// считается неработающим
+	healthErrorLimit = 10
+	// ActivityTimeout время без единого пакета от клиентов, после которого сервер
+	// с подключенными клиентами не готов: клиенты шлют keepalive чаще, значит, пакеты
+	// до сервера не доходят
+	ActivityTimeout = 90 * time.Second
+)
+
+// HealthCheck результат одной проверки
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Health результат проверки состояния: Status "ok" или "fail"
+type Health struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Liveness проверяет, что путь данных не сломан: TUN интерфейс поднят и читается,
+// UDP сокет открыт и читается
+func (s *Server) Liveness() Health {
+	return newHealth(s.checkTUN(), s.checkTransport())
+}
+
+// Readiness дополнительно к Liveness проверяет, что сервер запущен, не останавливается
+// и получает пакеты, если к нему подключены клиенты
+func (s *Server) Readiness() Health {
+	return newHealth(s.checkRunning(), s.checkTUN(), s.checkTransport(), s.checkActivity())
+}
+
+// HealthHandler HTTP обработчик /healthz и /readyz: 200, если все проверки прошли, иначе 503.
+// Не требует токена, чтобы его могли вызывать балансировщики и Kubernetes
+func (s *Server) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, s.Liveness())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, s.Readiness())
+	})
+	return mux
+}
+
+// Err возвращает nil, если все проверки прошли, иначе ошибку с первой неудачной проверкой
+func (h Health) Err() error {
+	for _, c := range h.Checks {
+		if !c.OK {
+			return fmt.Errorf("%s: %s", c.Name, c.Detail)
+		}
+	}
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, h Health) {
+	status := http.StatusOK
+	if h.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
+
+func newHealth(checks ...HealthCheck) Health {
+	h := Health{Status: "ok", Checks: checks}
+	for _, c := range checks {
+		if !c.OK {
+			h.Status = "fail"
+		}
+	}
+	return h
+}
+
+func (s *Server) checkRunning() HealthCheck {
+	c := HealthCheck{Name: "running"}
+	select {
+	case <-s.done:
+		c.Detail = "server is stopping"
+		return c
+	default:
+	}
+	if s.startTime.IsZero() {
+		c.Detail = "server is not started"
+		return c
+	}
+	c.OK = true
+	return c
+}
+
+func (s *Server) checkTUN() HealthCheck {
+	c := HealthCheck{Name: "tun"}
+	var iface *net.Interface
+	var err error
+	if !s.tun.Userspace() {
+		iface, err = net.InterfaceByName(s.tun.Name())
+	}
+	switch {
+	case err != nil:
+		c.Detail = fmt.Sprintf("interface %s: %v", s.tun.Name(), err)
+	case iface != nil && iface.Flags&net.FlagUp == 0:
+		c.Detail = fmt.Sprintf("interface %s is down", s.tun.Name())
+	case s.tunErrors.Load() >= healthErrorLimit:
+		c.Detail = fmt.Sprintf("%d consecutive I/O errors", s.tunErrors.Load())
+	default:
+		c.OK = true
+	}
+	return c
+}
+
+func (s *Server) checkTransport() HealthCheck {
+	c := HealthCheck{Name: "transport"}
+	switch {
+	case s.transport == nil || s.transport.Closed():
+		c.Detail = "UDP socket is closed"
+	case s.udpErrors.Load() >= healthErrorLimit:
+		c.Detail = fmt.Sprintf("%d consecutive UDP read errors", s.udpErrors.Load())
+	default:
+		c.OK = true
+	}
+	return c
+}
+
+func (s *Server) checkActivity() HealthCheck {
+	c := HealthCheck{Name: "activity"}
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+	if clients == 0 || s.transport == nil {
+		c.OK = true
+		return c
+	}
+	idle := time.Since(s.transport.LastReceive())
+	if idle > ActivityTimeout {
+		c.Detail = fmt.Sprintf("no packets from %d connected clients for %v", clients, idle.Round(time.Second))
+		return c
+	}
+	c.OK = true
+	return c
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"myvpn/adminrpc"
+	"myvpn/internal"
+	"myvpn/internal/logging"
+)
+
+// Ban запрет новых сессий пира до времени Until
+type Ban = adminrpc.Ban
+
+// KickReason причина отключения по умолчанию, которую видит клиент
+const KickReason = "disconnected by administrator"
+
+// KickClient разрывает сессию клиента: отправляет ему сообщение об отключении, освобождает
+// его адреса и, если ban > 0, на это время запрещает новые сессии с ключом его пира.
+// Возвращает false, если сессия не найдена
+func (s *Server) KickClient(sessionID uint64, reason string, ban time.Duration) bool {
+	s.clientsMu.RLock()
+	client, ok := s.clients[sessionID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return false
+	}
+	if reason == "" {
+		reason = KickReason
+	}
+	if ban > 0 {
+		s.BanPeer(client.peer, ban)
+	}
+
+	// Без сообщения клиент заметил бы отключение только по тишине и сразу переподключился бы.
+	// RetryAfter округляется вверх, чтобы клиент не пришел раньше окончания бана
+	notice := internal.Reject{Reason: reason, RetryAfter: int((ban + time.Second - 1) / time.Second)}
+	if msg, err := internal.EncodeControl(internal.ControlDisconnect, notice); err == nil {
+		if err := s.transport.WriteControl(msg, client.RemoteAddr(), sessionID); err != nil {
+			logTransport.Debug("Failed to send disconnect", "remote", client.RemoteAddr(), logging.Err(err))
+		}
+	}
+	if !s.DisconnectClient(sessionID) {
+		return false
+	}
+	if ban > 0 {
+		logAdmin.Info("Client kicked", "remote", client.RemoteAddr(), logging.Session(sessionID), "peer", client.peer, "ban", ban)
+	} else {
+		logAdmin.Info("Client kicked", "remote", client.RemoteAddr(), logging.Session(sessionID))
+	}
+	return true
+}
+
+// BanPeer запрещает новые сессии с ключом пира на время d. Уже подтвержденные сессии
+// пира продолжают работать
+func (s *Server) BanPeer(peer string, d time.Duration) {
+	s.configMu.Lock()
+	s.bans[peer] = time.Now().Add(d)
+	s.configMu.Unlock()
+}
+
+// LiftBan снимает бан пира. Возвращает false, если пир не забанен
+func (s *Server) LiftBan(peer string) bool {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	until, ok := s.bans[peer]
+	delete(s.bans, peer)
+	if ok && time.Now().Before(until) {
+		logAdmin.Info("Peer ban lifted", "peer", peer)
+		return true
+	}
+	return false
+}
+
+// Bans возвращает действующие баны. Истекшие удаляются
+func (s *Server) Bans() []Ban {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	now := time.Now()
+	bans := make([]Ban, 0, len(s.bans))
+	for peer, until := range s.bans {
+		if now.After(until) {
+			delete(s.bans, peer)
+			continue
+		}
+		bans = append(bans, Ban{Peer: peer, Until: until})
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Peer < bans[j].Peer })
+	return bans
+}
+
+// banRemaining возвращает, сколько еще действует бан пира (0 - пир не забанен)
+func (s *Server) banRemaining(peer string) time.Duration {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	if until, ok := s.bans[peer]; ok {
+		return max(time.Until(until), 0)
+	}
+	return 0
+}
+
+// checkBan отказывает новой сессии забаненного пира
+func (s *Server) checkBan(sessionID uint64) *internal.Reject {
+	peer, _ := s.keyring.SessionPeer(sessionID)
+	remaining := s.banRemaining(peer)
+	if remaining == 0 || s.sessionVerified(sessionID) {
+		return nil
+	}
+	return &internal.Reject{
+		Reason:     KickReason,
+		RetryAfter: int((remaining + time.Second - 1) / time.Second),
+	}
+}
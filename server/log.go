@@ -0,0 +1,15 @@
+package server
+
+import "myvpn/internal/logging"
+
+// Логгеры подсистем сервера
+var (
+	logServer    = logging.For("server")
+	logTransport = logging.For(logging.Transport)
+	logTUN       = logging.For(logging.TUN)
+	logCrypto    = logging.For(logging.Crypto)
+	logNet       = logging.For(logging.NetMgr)
+	logAuth      = logging.For("auth")
+	logAdmin     = logging.For("admin")
+	logNetstack  = logging.For("netstack")
+)
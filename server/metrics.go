@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"myvpn/internal/metrics"
+	"myvpn/internal/transport"
+)
+
+// Метрики сервера
+var (
+	metricTunPacketsIn   = metrics.NewCounter("myvpn_server_tun_packets_read_total", "IP packets read from TUN (traffic towards clients)")
+	metricTunBytesIn     = metrics.NewCounter("myvpn_server_tun_bytes_read_total", "Bytes read from TUN (traffic towards clients)")
+	metricTunPacketsOut  = metrics.NewCounter("myvpn_server_tun_packets_written_total", "IP packets written to TUN (traffic from clients)")
+	metricTunBytesOut    = metrics.NewCounter("myvpn_server_tun_bytes_written_total", "Bytes written to TUN (traffic from clients)")
+	metricUnknownDest    = metrics.NewCounter("myvpn_server_unknown_destination_drops_total", "Packets from TUN dropped because no client owns the destination IP")
+	metricRejected       = metrics.NewCounter("myvpn_server_rejected_sessions_total", "Sessions rejected because the client limit is reached or their protocol version is too old")
+	metricRateLimitUp    = metrics.NewCounter("myvpn_server_rate_limited_upload_packets_total", "Packets from clients dropped by the per-client rate limit")
+	metricRateLimitDown  = metrics.NewCounter("myvpn_server_rate_limited_download_packets_total", "Packets towards clients dropped by the per-client rate limit")
+	metricQueueDropUp    = metrics.NewCounter("myvpn_server_queue_upload_drops_total", "Packets from clients dropped by the TUN write queue policy")
+	metricQueueDropDown  = metrics.NewCounter("myvpn_server_queue_download_drops_total", "Packets towards clients dropped by the send queue policy")
+	metricCompressIn     = metrics.NewCounter("myvpn_compression_input_bytes_total", "Bytes passed to the compressor")
+	metricCompressOut    = metrics.NewCounter("myvpn_compression_output_bytes_total", "Bytes produced by the compressor (uncompressed packets counted as is)")
+	metricDecompressFail = metrics.NewCounter("myvpn_compression_decompress_failures_total", "Packets dropped because decompression failed")
+	metricSpoofed        = metrics.NewCounter("myvpn_server_spoofed_source_drops_total", "Packets from clients dropped because the source IP differs from the assigned address")
+	metricTOTPFailures   = metrics.NewCounter("myvpn_server_totp_failures_total", "Config requests rejected because of an invalid TOTP code")
+	metricCertMismatch   = metrics.NewCounter("myvpn_server_cert_mismatch_drops_total", "Packets dropped because the client certificate of a TLS transport belongs to another peer")
+)
+
+// Метрики внешней аутентификации
+var (
+	metricAuthFailures       = metrics.NewCounter("myvpn_server_auth_failures_total", "Config requests rejected by the external authentication backend or because it was unavailable")
+	metricAccountingFailures = metrics.NewCounter("myvpn_server_accounting_failures_total", "Accounting records the external backend did not acknowledge")
+)
+
+// Метрики прямого обмена между клиентами (P2P)
+var (
+	metricPeerIntroductions = metrics.NewCounter("myvpn_server_peer_introductions_total", "Pairs of clients introduced to each other for peer-to-peer packet exchange")
+)
+
+// Метрики режима -netstack
+var (
+	metricNetstackTCP          = metrics.NewCounter("myvpn_server_netstack_tcp_connections_total", "TCP connections of clients opened as server sockets in netstack mode")
+	metricNetstackUDP          = metrics.NewCounter("myvpn_server_netstack_udp_flows_total", "UDP flows of clients opened as server sockets in netstack mode")
+	metricNetstackDialFailures = metrics.NewCounter("myvpn_server_netstack_dial_failures_total", "Connections and UDP flows of clients the server could not open in netstack mode")
+)
+
+func init() {
+	metrics.NewGaugeFunc("myvpn_compression_ratio", "Compressed to original size ratio of sent packets (lower is better)", func() float64 {
+		in := metricCompressIn.Load()
+		if in == 0 {
+			return 1
+		}
+		return float64(metricCompressOut.Load()) / float64(in)
+	})
+}
+
+// writeMetrics пишет метрики клиентов сервера
+func (s *Server) writeMetrics(w io.Writer) {
+	s.clientsMu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMu.RUnlock()
+
+	metrics.WriteHeader(w, "myvpn_server_active_clients", "Number of client sessions", "gauge")
+	fmt.Fprintf(w, "myvpn_server_active_clients %d\n", len(clients))
+
+	perClient := []struct {
+		name  string
+		help  string
+		value func(*Client) uint64
+	}{
+		{"myvpn_client_rx_packets_total", "Packets received from the client", func(c *Client) uint64 { return c.rxPackets.Load() }},
+		{"myvpn_client_rx_bytes_total", "Bytes received from the client (after decompression)", func(c *Client) uint64 { return c.rxBytes.Load() }},
+		{"myvpn_client_tx_packets_total", "Packets sent to the client", func(c *Client) uint64 { return c.txPackets.Load() }},
+		{"myvpn_client_tx_bytes_total", "Bytes sent to the client (before compression)", func(c *Client) uint64 { return c.txBytes.Load() }},
+		{"myvpn_client_decrypt_errors_total", "Packets of the session that failed authentication", func(c *Client) uint64 { return c.authErrors.Load() }},
+	}
+	for _, m := range perClient {
+		metrics.WriteHeader(w, m.name, m.help, "counter")
+		for _, client := range clients {
+			labels := metrics.Labels("session", fmt.Sprintf("%016x", client.sessionID), "ip", client.VirtualIP())
+			fmt.Fprintf(w, "%s%s %d\n", m.name, labels, m.value(client))
+		}
+	}
+
+	// Время в секундах Unix, как у node_exporter: возраст считается в запросе PromQL (time() - ...)
+	timestamps := []struct {
+		name  string
+		help  string
+		value func(*Client) int64
+	}{
+		{"myvpn_client_last_seen_timestamp_seconds", "Time of the last packet from the client", func(c *Client) int64 { return c.lastSeen.Load() }},
+		{"myvpn_client_last_handshake_timestamp_seconds", "Time of the last config request of the session", func(c *Client) int64 { return c.handshake.Load() }},
+	}
+	for _, m := range timestamps {
+		metrics.WriteHeader(w, m.name, m.help, "gauge")
+		for _, client := range clients {
+			labels := metrics.Labels("session", fmt.Sprintf("%016x", client.sessionID), "ip", client.VirtualIP())
+			fmt.Fprintf(w, "%s%s %.3f\n", m.name, labels, float64(m.value(client))/1e9)
+		}
+	}
+
+	// RTT и потери есть только у сессий, которые уже ответили на keepalive сервера
+	rtts := make(map[*Client]transport.RTTStats, len(clients))
+	for _, client := range clients {
+		if rtt, ok := s.transport.RTT(client.sessionID); ok && rtt.Samples > 0 {
+			rtts[client] = rtt
+		}
+	}
+	quality := []struct {
+		name  string
+		help  string
+		value func(transport.RTTStats) float64
+	}{
+		{"myvpn_client_rtt_seconds", "Smoothed round-trip time of the session measured with keepalives", func(r transport.RTTStats) float64 { return r.RTT.Seconds() }},
+		{"myvpn_client_jitter_seconds", "Mean deviation of the session round-trip time", func(r transport.RTTStats) float64 { return r.Jitter.Seconds() }},
+		{"myvpn_client_loss_ratio", "Share of recent keepalives the client did not answer", func(r transport.RTTStats) float64 { return r.Loss }},
+	}
+	for _, m := range quality {
+		metrics.WriteHeader(w, m.name, m.help, "gauge")
+		for _, client := range clients {
+			rtt, ok := rtts[client]
+			if !ok {
+				continue
+			}
+			labels := metrics.Labels("session", fmt.Sprintf("%016x", client.sessionID), "ip", client.VirtualIP())
+			fmt.Fprintf(w, "%s%s %g\n", m.name, labels, m.value(rtt))
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"myvpn/internal"
+	"myvpn/internal/logging"
+	"myvpn/internal/netstack"
+	"myvpn/internal/socks5"
+)
+
+const (
+	// NetstackInterfaceName имя "интерфейса" в режиме -netstack для логов и API
+	NetstackInterfaceName = "netstack"
+	// netstackDialTimeout время на соединение с адресом назначения клиента
+	netstackDialTimeout = 10 * time.Second
+	// netstackUDPIdle через сколько без датаграмм в обе стороны закрывается сокет UDP потока
+	netstackUDPIdle = 2 * time.Minute
+	// netstackMaxUDPFlows предел одновременных UDP потоков всех клиентов
+	netstackMaxUDPFlows = 4096
+	// netstackQueue пакетов к клиентам, ожидающих чтения
+	netstackQueue = 1024
+)
+
+// netstackExcluded VPN подсети: адрес сервера в них есть только у TUN, а пакеты
+// между клиентами возвращаются в сервер, не попадая в стек
+var netstackExcluded = []netip.Prefix{
+	netip.MustParsePrefix(VPNNetwork),
+	netip.MustParsePrefix(VPNNetwork6),
+}
+
+// netstackDevice заменяет TUN и ядро в режиме -netstack: TCP соединения и UDP потоки
+// клиентов завершаются в userspace стеке, а сервер открывает вместо них обычные сокеты.
+// IP forwarding, NAT и правила firewall не нужны, ICMP не пересылается
+type netstackDevice struct {
+	stack   *netstack.Stack
+	dialer  net.Dialer
+	udpIdle time.Duration   // простой, после которого закрывается UDP поток: netstackUDPIdle
+	ctx     context.Context // отменяется в Close: прерывает соединения с адресами назначения
+	cancel  context.CancelFunc
+
+	packets       chan []byte // пакеты к клиентам: от стека и между клиентами
+	interrupted   chan struct{}
+	interruptOnce sync.Once
+
+	mu     sync.Mutex
+	udp    map[udpFlowKey]*udpFlow
+	conns  map[net.Conn]struct{} // соединения клиентов и назначения: закрываются в Close
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// udpFlowKey адреса UDP потока со стороны клиента
+type udpFlowKey struct {
+	src, dst netip.AddrPort
+}
+
+// udpFlow UDP поток клиента: сокет стека на адресе назначения и сокет сервера к нему
+type udpFlow struct {
+	client net.Conn
+	conn   *net.UDPConn
+	last   atomic.Int64 // время последней датаграммы (UnixNano)
+}
+
+// NewNetstackTUN создает TUN без интерфейса в системе: пакеты клиентов принимает
+// userspace стек, и права root, IP forwarding и NAT не нужны (-netstack)
+func NewNetstackTUN() *TUN {
+	return &TUN{name: NetstackInterfaceName, netstack: newNetstackDevice()}
+}
+
+func newNetstackDevice() *netstackDevice {
+	d := &netstackDevice{
+		udpIdle:     netstackUDPIdle,
+		packets:     make(chan []byte, netstackQueue),
+		interrupted: make(chan struct{}),
+		udp:         make(map[udpFlowKey]*udpFlow),
+		conns:       make(map[net.Conn]struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.stack = netstack.New(netstack.Options{
+		Write:      d.output,
+		MTU:        TUNMTU,
+		TCPForward: d.forwardTCP,
+		UDPForward: d.forwardUDP,
+	})
+	return d
+}
+
+// read отдает следующий пакет к клиентам
+func (d *netstackDevice) read(packet []byte) (int, error) {
+	select {
+	case p := <-d.packets:
+		return copy(packet, p), nil
+	case <-d.interrupted:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// write принимает пакет клиента: пакет другому клиенту возвращается серверу, остальные
+// получает стек
+func (d *netstackDevice) write(packet []byte) (int, error) {
+	if dst, ok := internal.PacketDestIP(packet); ok {
+		addr, _ := netip.AddrFromSlice(dst)
+		addr = addr.Unmap()
+		for _, prefix := range netstackExcluded {
+			if prefix.Contains(addr) && addr != prefix.Addr().Next() {
+				d.output(packet)
+				return len(packet), nil
+			}
+		}
+	}
+	d.stack.Deliver(packet)
+	return len(packet), nil
+}
+
+// output ставит копию пакета в очередь к клиентам, ожидая места в ней
+func (d *netstackDevice) output(packet []byte) {
+	select {
+	case d.packets <- append([]byte(nil), packet...):
+	case <-d.interrupted:
+	}
+}
+
+// interrupt прерывает ожидающие и будущие чтения
+func (d *netstackDevice) interrupt() {
+	d.interruptOnce.Do(func() { close(d.interrupted) })
+}
+
+// Close разрывает соединения и UDP потоки клиентов и ждет завершения их горутин
+func (d *netstackDevice) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	for conn := range d.conns {
+		conn.Close()
+	}
+	for _, flow := range d.udp {
+		flow.client.Close()
+		flow.conn.Close()
+	}
+	d.mu.Unlock()
+	d.cancel()
+	d.interrupt()
+	d.stack.Close()
+	d.wg.Wait()
+	return nil
+}
+
+// forwardable сообщает, можно ли открыть сокет к адресу назначения клиента. Loopback,
+// link-local, multicast и VPN подсети ядро не пересылало бы из TUN
+func forwardable(addr netip.Addr) bool {
+	if addr.Is4In6() || !addr.IsGlobalUnicast() {
+		return false
+	}
+	for _, prefix := range netstackExcluded {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// begin регистрирует горутину соединения или потока. false - устройство закрыто
+func (d *netstackDevice) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	d.wg.Add(1)
+	return true
+}
+
+// track запоминает соединения, чтобы закрыть их в Close. false - устройство уже закрыто
+func (d *netstackDevice) track(conns ...net.Conn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	for _, conn := range conns {
+		d.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (d *netstackDevice) untrack(conns ...net.Conn) {
+	d.mu.Lock()
+	for _, conn := range conns {
+		delete(d.conns, conn)
+	}
+	d.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// forwardTCP открывает соединение с адресом назначения клиента и только потом принимает
+// соединение клиента: недоступный адрес клиент видит как RST
+func (d *netstackDevice) forwardTCP(req *netstack.TCPRequest) {
+	dst := req.LocalAddr()
+	if !forwardable(dst.Addr()) || !d.begin() {
+		req.Reject()
+		return
+	}
+	defer d.wg.Done()
+
+	ctx, cancel := context.WithTimeout(d.ctx, netstackDialTimeout)
+	defer cancel()
+	target, err := d.dialer.DialContext(ctx, "tcp", dst.String())
+	if err != nil {
+		metricNetstackDialFailures.Inc()
+		logNetstack.Debug("Connect failed", "src", req.RemoteAddr(), "dst", dst, logging.Err(err))
+		req.Reject()
+		return
+	}
+	conn, err := req.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	cancel()
+	if !d.track(conn, target) {
+		conn.Close()
+		target.Close()
+		return
+	}
+	defer d.untrack(conn, target)
+	metricNetstackTCP.Inc()
+	socks5.Relay(conn, target)
+}
+
+// forwardUDP открывает для нового UDP потока клиента сокет к адресу назначения. Датаграммы
+// потока, начиная с этой, стек передает сокету потока. Сверх netstackMaxUDPFlows
+// потоков датаграммы отбрасываются
+func (d *netstackDevice) forwardUDP(req *netstack.UDPRequest) {
+	src, dst := req.RemoteAddr(), req.LocalAddr()
+	if !forwardable(dst.Addr()) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || len(d.udp) >= netstackMaxUDPFlows {
+		return
+	}
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(dst))
+	if err != nil {
+		metricNetstackDialFailures.Inc()
+		logNetstack.Debug("UDP socket failed", "src", src, "dst", dst, logging.Err(err))
+		return
+	}
+	client, err := req.Accept()
+	if err != nil {
+		conn.Close()
+		logNetstack.Debug("UDP flow failed", "src", src, "dst", dst, logging.Err(err))
+		return
+	}
+	key := udpFlowKey{src: src, dst: dst}
+	flow := &udpFlow{client: client, conn: conn}
+	flow.last.Store(time.Now().UnixNano())
+	d.udp[key] = flow
+	d.wg.Add(2)
+	go d.uploadUDP(flow)
+	go d.relayUDP(key, flow)
+	metricNetstackUDP.Inc()
+}
+
+// uploadUDP отправляет датаграммы клиента с сокета его потока, пока поток не закрыт
+func (d *netstackDevice) uploadUDP(flow *udpFlow) {
+	defer d.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, err := flow.client.Read(buf)
+		if err != nil {
+			return
+		}
+		flow.last.Store(time.Now().UnixNano())
+		flow.conn.Write(buf[:n])
+	}
+}
+
+// relayUDP передает ответы адреса назначения клиенту, пока поток не простаивает
+// udpIdle, и закрывает поток
+func (d *netstackDevice) relayUDP(key udpFlowKey, flow *udpFlow) {
+	defer d.wg.Done()
+	defer func() {
+		d.mu.Lock()
+		delete(d.udp, key)
+		d.mu.Unlock()
+		flow.client.Close()
+		flow.conn.Close()
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		flow.conn.SetReadDeadline(time.Now().Add(d.udpIdle))
+		n, err := flow.conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			switch {
+			case errors.As(err, &ne) && ne.Timeout():
+				if time.Since(time.Unix(0, flow.last.Load())) < d.udpIdle {
+					continue
+				}
+				return
+			case errors.Is(err, syscall.ECONNREFUSED):
+				// ICMP port unreachable на прошлую датаграмму
+				continue
+			}
+			return
+		}
+		flow.last.Store(time.Now().UnixNano())
+		flow.client.Write(buf[:n])
+	}
+}
+
+// checkNetstackConfig отклоняет параметры, которым нужен TUN интерфейс или правила ядра
+func checkNetstackConfig(cfg Config) error {
+	switch {
+	case cfg.TUNFD > 0:
+		return errors.New("netstack mode cannot be used with a pre-opened TUN descriptor")
+	case cfg.TUNQueues > 1:
+		return errors.New("netstack mode cannot be used with multiple TUN queues")
+	case cfg.TUNOffload:
+		return errors.New("netstack mode cannot be used with TUN offload")
+	case cfg.IOURing:
+		return errors.New("netstack mode cannot be used with io_uring")
+	case cfg.PortHop.Enabled():
+		return errors.New("port hopping requires firewall rules and cannot be used in netstack mode")
+	case len(cfg.DNSForward) > 0:
+		return errors.New("the DNS forwarder listens on TUN addresses and cannot be used in netstack mode")
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"myvpn/internal/netstack"
+)
+
+// newTestNetstack создает устройство -netstack и стек клиента с адресом из VPN подсети,
+// связанный с ним, как через туннель
+func newTestNetstack(t *testing.T) (*netstackDevice, *netstack.Stack) {
+	t.Helper()
+	d := newNetstackDevice()
+	client := netstack.New(netstack.Options{
+		Write: func(packet []byte) { d.write(packet) },
+		Addr4: netip.MustParseAddr("10.0.0.2"),
+		Addr6: netip.MustParseAddr("fd00::2"),
+	})
+	t.Cleanup(func() {
+		d.Close()
+		client.Close()
+	})
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := d.read(buf)
+			if err != nil {
+				return
+			}
+			client.Deliver(buf[:n])
+		}
+	}()
+	return d, client
+}
+
+// hostAddr адрес IPv4 этой машины, к которому сервер открывает сокеты. Loopback не годится:
+// пакеты клиентов на него отбрасывает и стек, и forwardable
+func hostAddr(t *testing.T) netip.Addr {
+	t.Helper()
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if prefix, err := netip.ParsePrefix(a.String()); err == nil {
+			if addr := prefix.Addr(); addr.Is4() && forwardable(addr) {
+				return addr
+			}
+		}
+	}
+	t.Skip("no IPv4 address outside loopback and the VPN subnet")
+	return netip.Addr{}
+}
+
+// udpEchoServer запускает UDP сокет на hostAddr, который возвращает датаграммы отправителю
+func udpEchoServer(t *testing.T) netip.AddrPort {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(hostAddr(t), 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// udpExchange отправляет датаграмму и сообщает, пришла ли она обратно за timeout
+func udpExchange(t *testing.T, conn net.Conn, msg string, timeout time.Duration) bool {
+	t.Helper()
+	conn.Write([]byte(msg))
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return false
+	}
+	if string(buf[:n]) != msg {
+		t.Fatalf("echo %q, want %q", buf[:n], msg)
+	}
+	return true
+}
+
+func (d *netstackDevice) udpFlows() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.udp)
+}
+
+func TestForwardable(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"198.51.100.7":     true,
+		"192.168.1.1":      true,
+		"2001:db8::1":      true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"169.254.1.1":      false,
+		"fe80::1":          false,
+		"224.0.0.251":      false,
+		"ff02::fb":         false,
+		"255.255.255.255":  false,
+		"0.0.0.0":          false,
+		"::ffff:192.0.2.1": false,
+		"10.0.0.5":         false,
+		"fd00::5":          false,
+	} {
+		if got := forwardable(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("forwardable(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// TestNetstackTCP проверяет, что соединение клиента на адрес вне сервера принимается
+// и связывается с соединением сервера к этому адресу
+func TestNetstackTCP(t *testing.T) {
+	_, client := newTestNetstack(t)
+	ln, err := net.Listen("tcp", netip.AddrPortFrom(hostAddr(t), 0).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.DialTCP(ctx, ln.Addr().(*net.TCPAddr).AddrPort())
+	if err != nil {
+		t.Fatalf("DialTCP: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	data := bytes.Repeat([]byte("through the server netstack "), 4096)
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("echo differs from the data sent")
+	}
+}
+
+// TestNetstackTCPRejected проверяет, что соединения на закрытый порт и на адреса,
+// которые не пропускает forwardable, клиент получает как RST
+func TestNetstackTCPRejected(t *testing.T) {
+	closedLn, err := net.Listen("tcp", netip.AddrPortFrom(hostAddr(t), 0).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := closedLn.Addr().(*net.TCPAddr).AddrPort()
+	closedLn.Close()
+
+	for _, tc := range []struct {
+		name string
+		dst  netip.AddrPort
+	}{
+		{"server VPN address", netip.MustParseAddrPort("10.0.0.1:80")},
+		{"link-local", netip.MustParseAddrPort("169.254.1.1:80")},
+		{"closed port", closed},
+	} {
+		_, client := newTestNetstack(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		conn, err := client.DialTCP(ctx, tc.dst)
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s: DialTCP %v succeeded", tc.name, tc.dst)
+		} else if ctx.Err() != nil {
+			t.Errorf("%s: DialTCP %v waited for the timeout instead of RST", tc.name, tc.dst)
+		}
+		cancel()
+	}
+}
+
+// TestNetstackUDPExpiry проверяет UDP поток: ответы приходят клиенту, а после простоя
+// udpIdle поток закрывается
+func TestNetstackUDPExpiry(t *testing.T) {
+	d, client := newTestNetstack(t)
+	d.udpIdle = 200 * time.Millisecond
+	echo := udpEchoServer(t)
+
+	conn, err := client.DialUDP(context.Background(), echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, msg := range []string{"first", "second"} {
+		if !udpExchange(t, conn, msg, 5*time.Second) {
+			t.Fatalf("no echo for %q", msg)
+		}
+	}
+	if n := d.udpFlows(); n != 1 {
+		t.Fatalf("%d UDP flows, want 1", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for d.udpFlows() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle UDP flow not closed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Следующая датаграмма открывает новый поток
+	if !udpExchange(t, conn, "after expiry", 5*time.Second) {
+		t.Fatal("no echo after the flow expired")
+	}
+}
+
+// TestNetstackUDPFlowCap проверяет, что сверх netstackMaxUDPFlows потоков датаграммы
+// новых потоков отбрасываются
+func TestNetstackUDPFlowCap(t *testing.T) {
+	d, client := newTestNetstack(t)
+	echo := udpEchoServer(t)
+	// Занятые места - потоки без сокетов: они удаляются до Close
+	d.mu.Lock()
+	for i := range netstackMaxUDPFlows - 1 {
+		d.udp[udpFlowKey{src: netip.AddrPortFrom(netip.MustParseAddr("10.0.0.9"), uint16(i+1))}] = &udpFlow{}
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		for key, flow := range d.udp {
+			if flow.client == nil {
+				delete(d.udp, key)
+			}
+		}
+		d.mu.Unlock()
+	}()
+
+	last, err := client.DialUDP(context.Background(), echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer last.Close()
+	if !udpExchange(t, last, "last flow", 5*time.Second) {
+		t.Fatal("last flow under the cap refused")
+	}
+	over, err := client.DialUDP(context.Background(), echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer over.Close()
+	if udpExchange(t, over, "over the cap", 500*time.Millisecond) {
+		t.Fatal("flow created over netstackMaxUDPFlows")
+	}
+}
@@ -2,81 +2,223 @@ package server
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
+
+	"myvpn/internal/logging"
+	"myvpn/internal/netlink"
+	"myvpn/internal/netstate"
+	"myvpn/internal/porthop"
 )
 
 const (
 	// VPNNetwork VPN подсеть
 	VPNNetwork = "10.0.0.0/24"
+	// VPNNetwork6 IPv6 VPN подсеть (ULA)
+	VPNNetwork6 = "fd00::/64"
+
+	ipForwardPath  = "/proc/sys/net/ipv4/ip_forward"
+	ip6ForwardPath = "/proc/sys/net/ipv6/conf/all/forwarding"
+)
+
+// Значения MSS clamping
+const (
+	// MSSClampOff MSS TCP соединений не меняется
+	MSSClampOff = 0
+	// MSSClampPMTU MSS ограничивается по MTU маршрута пакета (TCPMSS --clamp-mss-to-pmtu)
+	MSSClampPMTU = -1
+	// MinMSS и MaxMSS допустимый диапазон фиксированного MSS
+	MinMSS = 536
+	MaxMSS = 1460
 )
 
+// ParseMSSClamp разбирает значение -mss-clamp: off, pmtu или MSS в байтах
+func ParseMSSClamp(s string) (int, error) {
+	switch s {
+	case "off":
+		return MSSClampOff, nil
+	case "pmtu":
+		return MSSClampPMTU, nil
+	}
+	mss, err := strconv.Atoi(s)
+	if err != nil || mss < MinMSS || mss > MaxMSS {
+		return 0, fmt.Errorf("invalid MSS clamp %q (off, pmtu or %d-%d)", s, MinMSS, MaxMSS)
+	}
+	return mss, nil
+}
+
+// Цепочки iptables сервера. Все правила сервера находятся в них, а во встроенных цепочках
+// есть только переходы: при остановке цепочки удаляются целиком
+const (
+	// ChainForward правила FORWARD (в таблицах filter и mangle)
+	ChainForward = "VPNTURBO-FORWARD"
+	// ChainNAT правила POSTROUTING таблицы nat
+	ChainNAT = "VPNTURBO-NAT"
+	// ChainPortHop правила PREROUTING таблицы nat (port hopping)
+	ChainPortHop = "VPNTURBO-PORTHOP"
+)
+
+// iptablesChain цепочка сервера и встроенная цепочка, из которой в нее переходят
+type iptablesChain struct {
+	ipv6   bool
+	table  string
+	name   string
+	parent string
+	insert bool // переход в начале встроенной цепочки, раньше чужих правил DROP
+}
+
+// stateChain описывает цепочку для файла состояния
+func (c iptablesChain) stateChain() netstate.Chain {
+	return netstate.Chain{IPv6: c.ipv6, Table: c.table, Name: c.name, Parent: c.parent}
+}
+
+// iptablesChains все цепочки сервера
+var iptablesChains = []iptablesChain{
+	{table: "filter", name: ChainForward, parent: "FORWARD", insert: true},
+	{table: "mangle", name: ChainForward, parent: "FORWARD"},
+	{table: "nat", name: ChainNAT, parent: "POSTROUTING"},
+	{table: "nat", name: ChainPortHop, parent: "PREROUTING"},
+}
+
 // NetworkManager управляет сетевыми настройками сервера
 type NetworkManager struct {
-	tunInterface      string
-	externalInterface string
-	vpnNetwork        string
-	ipForwardingWasOn bool
-	rulesAdded        []iptablesRule
+	tunInterface       string
+	externalInterface  string
+	externalInterface6 string
+	vpnNetwork         string
+	vpnNetwork6        string
+	ipForwardingWasOn  bool
+	ip6ForwardingWasOn bool
+	chainsAdded        []iptablesChain
+	mssClamp           int    // MSSClampOff, MSSClampPMTU или фиксированный MSS
+	firewall           string // FirewallIptables, FirewallNftables или FirewallNone
+	state              *netstate.Store
 }
 
+// iptablesRule правило в цепочке сервера
 type iptablesRule struct {
+	ipv6  bool
 	table string
 	chain string
 	args  []string
 }
 
-// NewNetworkManager создает новый менеджер сетевых настроек
-func NewNetworkManager(tunInterface string) (*NetworkManager, error) {
+// NewNetworkManager создает новый менеджер сетевых настроек. firewall - чем настраивать
+// NAT и правила фильтрации (FirewallAuto, FirewallIptables, FirewallNftables или FirewallNone)
+func NewNetworkManager(tunInterface, firewall string) (*NetworkManager, error) {
+	firewall, err := selectFirewall(firewall)
+	if err != nil {
+		return nil, err
+	}
+
 	// Определяем внешний интерфейс
-	externalIF, err := getExternalInterface()
+	externalIF, err := getExternalInterface(false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get external interface: %w", err)
 	}
 
+	// IPv6 необязателен: без default route IPv6 трафик клиентов просто не выходит наружу
+	externalIF6, err := getExternalInterface(true)
+	if err != nil {
+		logNet.Warn("No IPv6 default route, IPv6 NAT disabled", logging.Err(err))
+		externalIF6 = ""
+	}
+
 	return &NetworkManager{
-		tunInterface:      tunInterface,
-		externalInterface: externalIF,
-		vpnNetwork:        VPNNetwork,
-		rulesAdded:        make([]iptablesRule, 0),
+		tunInterface:       tunInterface,
+		externalInterface:  externalIF,
+		externalInterface6: externalIF6,
+		vpnNetwork:         VPNNetwork,
+		vpnNetwork6:        VPNNetwork6,
+		firewall:           firewall,
 	}, nil
 }
 
-// Setup настраивает IP forwarding, NAT и firewall правила
+// Setup настраивает IP forwarding, NAT и firewall правила. Без менеджера (nil в режиме
+// -netstack) ничего не делает
 func (nm *NetworkManager) Setup() error {
+	if nm == nil {
+		return nil
+	}
 	// 1. Включаем IP forwarding
 	if err := nm.enableIPForwarding(); err != nil {
 		return fmt.Errorf("failed to enable IP forwarding: %w", err)
 	}
 
-	// 2. Настраиваем NAT (MASQUERADE)
+	if nm.firewall == FirewallNftables || nm.firewall == FirewallNone {
+		if nm.externalInterface6 != "" {
+			if err := nm.enableIPv6Forwarding(); err != nil {
+				return fmt.Errorf("failed to enable IPv6 forwarding: %w", err)
+			}
+		}
+	}
+	if nm.firewall == FirewallNone {
+		logNet.Info("Network configured: IP forwarding enabled, NAT and filter rules left to the host", "firewall", nm.firewall)
+		return nil
+	}
+	if nm.firewall == FirewallNftables {
+		if err := nm.setupNftables(); err != nil {
+			return fmt.Errorf("failed to setup nftables: %w", err)
+		}
+		logNet.Info("Network configured: IP forwarding enabled", "nat_interface", nm.externalInterface, "firewall", nm.firewall)
+		return nil
+	}
+
+	// 2. Удаляем цепочки, оставшиеся от аварийно завершенного запуска
+	removeStaleChains()
+
+	// 3. Настраиваем NAT (MASQUERADE)
 	if err := nm.setupNAT(); err != nil {
 		return fmt.Errorf("failed to setup NAT: %w", err)
 	}
 
-	// 3. Настраиваем FORWARD правила
+	// 4. Настраиваем FORWARD правила
 	if err := nm.setupForwardRules(); err != nil {
 		return fmt.Errorf("failed to setup forward rules: %w", err)
 	}
 
-	log.Printf("✓ Network configured: IP forwarding enabled, NAT via %s", nm.externalInterface)
+	// 5. То же самое для IPv6 (NAT66), если есть внешний IPv6 интерфейс
+	if nm.externalInterface6 != "" {
+		if err := nm.setupIPv6(); err != nil {
+			return fmt.Errorf("failed to setup IPv6: %w", err)
+		}
+	}
+
+	// 6. MSS clamping для TCP соединений через туннель
+	if err := nm.setupMSSClamp(); err != nil {
+		return fmt.Errorf("failed to setup MSS clamping: %w", err)
+	}
+
+	logNet.Info("Network configured: IP forwarding enabled", "nat_interface", nm.externalInterface, "firewall", nm.firewall)
 	return nil
 }
 
-// Cleanup восстанавливает сетевые настройки
+// Cleanup восстанавливает сетевые настройки (nil - нечего восстанавливать)
 func (nm *NetworkManager) Cleanup() error {
+	if nm == nil {
+		return nil
+	}
 	var errs []error
 
-	// Удаляем добавленные правила в обратном порядке
-	for i := len(nm.rulesAdded) - 1; i >= 0; i-- {
-		rule := nm.rulesAdded[i]
-		if err := nm.deleteIptablesRule(rule); err != nil {
+	if nm.firewall == FirewallNftables {
+		if err := nm.cleanupNftables(); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
+	// Удаляем цепочки сервера вместе с переходами в них
+	for i := len(nm.chainsAdded) - 1; i >= 0; i-- {
+		if err := removeChain(nm.chainsAdded[i]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		nm.state.RemoveChain(nm.chainsAdded[i].stateChain())
+	}
+	nm.chainsAdded = nil
+
 	// Восстанавливаем IP forwarding если был выключен
 	if !nm.ipForwardingWasOn {
 		if err := nm.disableIPForwarding(); err != nil {
@@ -84,18 +226,28 @@ func (nm *NetworkManager) Cleanup() error {
 		}
 	}
 
+	if nm.externalInterface6 != "" && !nm.ip6ForwardingWasOn {
+		if err := os.WriteFile(ip6ForwardPath, []byte("0"), 0644); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := nm.state.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("errors during cleanup: %v", errs)
 	}
 
-	log.Println("✓ Network settings restored")
+	logNet.Info("Network settings restored")
 	return nil
 }
 
 // enableIPForwarding включает IP forwarding
 func (nm *NetworkManager) enableIPForwarding() error {
 	// Проверяем текущее состояние
-	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	data, err := os.ReadFile(ipForwardPath)
 	if err != nil {
 		return err
 	}
@@ -104,25 +256,25 @@ func (nm *NetworkManager) enableIPForwarding() error {
 	nm.ipForwardingWasOn = currentValue == "1"
 
 	if nm.ipForwardingWasOn {
-		log.Println("✓ IP forwarding already enabled")
+		logNet.Debug("IP forwarding already enabled")
 		return nil
 	}
 
 	// Включаем IP forwarding
-	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+	if err := os.WriteFile(ipForwardPath, []byte("1"), 0644); err != nil {
 		return err
 	}
 
-	log.Println("✓ IP forwarding enabled")
+	logNet.Debug("IP forwarding enabled")
 	return nil
 }
 
 // disableIPForwarding выключает IP forwarding
 func (nm *NetworkManager) disableIPForwarding() error {
-	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("0"), 0644); err != nil {
+	if err := os.WriteFile(ipForwardPath, []byte("0"), 0644); err != nil {
 		return err
 	}
-	log.Println("✓ IP forwarding disabled")
+	logNet.Debug("IP forwarding disabled")
 	return nil
 }
 
@@ -130,133 +282,236 @@ func (nm *NetworkManager) disableIPForwarding() error {
 func (nm *NetworkManager) setupNAT() error {
 	rule := iptablesRule{
 		table: "nat",
-		chain: "POSTROUTING",
+		chain: ChainNAT,
 		args:  []string{"-s", nm.vpnNetwork, "-o", nm.externalInterface, "-j", "MASQUERADE"},
 	}
-
-	// Проверяем, существует ли уже правило
-	if nm.iptablesRuleExists(rule) {
-		log.Println("✓ NAT rule already exists")
-		return nil
-	}
-
-	// Добавляем правило
-	if err := nm.addIptablesRule(rule); err != nil {
+	if err := nm.appendRule(rule); err != nil {
 		return err
 	}
 
-	nm.rulesAdded = append(nm.rulesAdded, rule)
-	log.Println("✓ NAT rule added")
+	logNet.Debug("NAT rule added")
 	return nil
 }
 
 // setupForwardRules настраивает FORWARD правила
 func (nm *NetworkManager) setupForwardRules() error {
-	// Правило для исходящего трафика из VPN
-	outRule := iptablesRule{
-		table: "filter",
-		chain: "FORWARD",
-		args:  []string{"-s", nm.vpnNetwork, "-j", "ACCEPT"},
+	rules := []iptablesRule{
+		// Исходящий трафик из VPN
+		{table: "filter", chain: ChainForward, args: []string{"-s", nm.vpnNetwork, "-j", "ACCEPT"}},
+		// Входящий трафик в VPN
+		{table: "filter", chain: ChainForward, args: []string{"-d", nm.vpnNetwork, "-j", "ACCEPT"}},
+	}
+	for _, rule := range rules {
+		if err := nm.appendRule(rule); err != nil {
+			return err
+		}
 	}
 
-	if !nm.iptablesRuleExists(outRule) {
-		if err := nm.insertIptablesRule(outRule); err != nil {
+	logNet.Debug("FORWARD rules added")
+	return nil
+}
+
+// enableIPv6Forwarding включает IPv6 forwarding
+func (nm *NetworkManager) enableIPv6Forwarding() error {
+	data, err := os.ReadFile(ip6ForwardPath)
+	if err != nil {
+		return err
+	}
+	nm.ip6ForwardingWasOn = strings.TrimSpace(string(data)) == "1"
+	if !nm.ip6ForwardingWasOn {
+		if err := os.WriteFile(ip6ForwardPath, []byte("1"), 0644); err != nil {
 			return err
 		}
-		nm.rulesAdded = append(nm.rulesAdded, outRule)
-		log.Println("✓ FORWARD rule (outgoing) added")
-	} else {
-		log.Println("✓ FORWARD rule (outgoing) already exists")
+		logNet.Debug("IPv6 forwarding enabled")
+	}
+	return nil
+}
+
+// setupIPv6 включает IPv6 forwarding и добавляет NAT66/FORWARD правила через ip6tables
+func (nm *NetworkManager) setupIPv6() error {
+	if err := nm.enableIPv6Forwarding(); err != nil {
+		return err
 	}
 
-	// Правило для входящего трафика в VPN
-	inRule := iptablesRule{
-		table: "filter",
-		chain: "FORWARD",
-		args:  []string{"-d", nm.vpnNetwork, "-j", "ACCEPT"},
+	rules := []iptablesRule{
+		{ipv6: true, table: "nat", chain: ChainNAT, args: []string{"-s", nm.vpnNetwork6, "-o", nm.externalInterface6, "-j", "MASQUERADE"}},
+		{ipv6: true, table: "filter", chain: ChainForward, args: []string{"-s", nm.vpnNetwork6, "-j", "ACCEPT"}},
+		{ipv6: true, table: "filter", chain: ChainForward, args: []string{"-d", nm.vpnNetwork6, "-j", "ACCEPT"}},
 	}
 
-	if !nm.iptablesRuleExists(inRule) {
-		if err := nm.insertIptablesRule(inRule); err != nil {
+	for _, rule := range rules {
+		if err := nm.appendRule(rule); err != nil {
 			return err
 		}
-		nm.rulesAdded = append(nm.rulesAdded, inRule)
-		log.Println("✓ FORWARD rule (incoming) added")
-	} else {
-		log.Println("✓ FORWARD rule (incoming) already exists")
 	}
 
+	logNet.Info("IPv6 NAT66 configured", "nat_interface", nm.externalInterface6)
 	return nil
 }
 
-// iptablesRuleExists проверяет существование правила
-func (nm *NetworkManager) iptablesRuleExists(rule iptablesRule) bool {
-	args := []string{"-t", rule.table, "-C", rule.chain}
-	args = append(args, rule.args...)
+// setupMSSClamp уменьшает MSS в SYN пакетах TCP соединений, проходящих через TUN.
+// MTU туннеля меньше MTU внешнего интерфейса, и без этого сервер в интернете выбрал бы
+// сегменты, которые не помещаются в туннель. Если ICMP "fragmentation needed" где-то
+// отбрасывается (PMTU blackhole), такие соединения зависают после рукопожатия
+func (nm *NetworkManager) setupMSSClamp() error {
+	if nm.mssClamp == MSSClampOff {
+		return nil
+	}
+	target := []string{"-j", "TCPMSS", "--clamp-mss-to-pmtu"}
+	if nm.mssClamp > 0 {
+		target = []string{"-j", "TCPMSS", "--set-mss", strconv.Itoa(nm.mssClamp)}
+	}
 
-	cmd := exec.Command("iptables", args...)
-	return cmd.Run() == nil
+	var rules []iptablesRule
+	families := []bool{false}
+	if nm.externalInterface6 != "" {
+		families = append(families, true)
+	}
+	for _, ipv6 := range families {
+		for _, dir := range []string{"-o", "-i"} {
+			mss := target
+			if ipv6 && nm.mssClamp > 0 {
+				// Заголовок IPv6 на 20 байт больше IPv4
+				mss = []string{"-j", "TCPMSS", "--set-mss", strconv.Itoa(nm.mssClamp - 20)}
+			}
+			args := append([]string{dir, nm.tunInterface, "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN"}, mss...)
+			rules = append(rules, iptablesRule{ipv6: ipv6, table: "mangle", chain: ChainForward, args: args})
+		}
+	}
+
+	for _, rule := range rules {
+		if err := nm.appendRule(rule); err != nil {
+			return err
+		}
+	}
+
+	logNet.Debug("MSS clamping configured", "mss", strings.Join(target[2:], " "))
+	return nil
 }
 
-// addIptablesRule добавляет правило iptables (в конец цепочки)
-func (nm *NetworkManager) addIptablesRule(rule iptablesRule) error {
-	args := []string{"-t", rule.table, "-A", rule.chain}
-	args = append(args, rule.args...)
+// SetupPortHop перенаправляет UDP пакеты на порты диапазона ports на порт listenPort,
+// чтобы клиенты с port hopping попадали в единственный сокет сервера.
+// Ответы уходят с того порта, на который пришел пакет (conntrack)
+func (nm *NetworkManager) SetupPortHop(ports porthop.Range, listenPort int) error {
+	if nm.firewall == FirewallNftables {
+		if err := nm.setupPortHopNftables(ports, listenPort); err != nil {
+			return err
+		}
+		logNet.Info("Port hopping configured", "ports", fmt.Sprintf("%d-%d", ports.First, ports.Last), "listen_port", listenPort)
+		return nil
+	}
+
+	rules := []iptablesRule{
+		{table: "nat", chain: ChainPortHop, args: []string{"-p", "udp", "--dport", ports.String(), "-j", "REDIRECT", "--to-ports", strconv.Itoa(listenPort)}},
+	}
+	if nm.externalInterface6 != "" {
+		rules = append(rules, iptablesRule{ipv6: true, table: "nat", chain: ChainPortHop, args: rules[0].args})
+	}
 
-	cmd := exec.Command("iptables", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("iptables error: %s", string(output))
+	for _, rule := range rules {
+		if err := nm.appendRule(rule); err != nil {
+			return err
+		}
 	}
+
+	logNet.Info("Port hopping configured", "ports", fmt.Sprintf("%d-%d", ports.First, ports.Last), "listen_port", listenPort)
 	return nil
 }
 
-// insertIptablesRule вставляет правило iptables (в начало цепочки)
-func (nm *NetworkManager) insertIptablesRule(rule iptablesRule) error {
-	args := []string{"-t", rule.table, "-I", rule.chain}
-	args = append(args, rule.args...)
+// appendRule добавляет правило в конец цепочки сервера, создавая цепочку при первом правиле
+func (nm *NetworkManager) appendRule(rule iptablesRule) error {
+	if err := nm.ensureChain(rule.ipv6, rule.table, rule.chain); err != nil {
+		return err
+	}
+	args := append([]string{"-t", rule.table, "-A", rule.chain}, rule.args...)
+	return runIptables(rule.ipv6, args...)
+}
 
-	cmd := exec.Command("iptables", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("iptables error: %s", string(output))
+// ensureChain создает цепочку сервера name в таблице table и переход в нее
+// из встроенной цепочки
+func (nm *NetworkManager) ensureChain(ipv6 bool, table, name string) error {
+	for _, c := range nm.chainsAdded {
+		if c.ipv6 == ipv6 && c.table == table && c.name == name {
+			return nil
+		}
+	}
+	i := slices.IndexFunc(iptablesChains, func(c iptablesChain) bool { return c.table == table && c.name == name })
+	if i < 0 {
+		return fmt.Errorf("unknown chain %s in table %s", name, table)
 	}
+	chain := iptablesChains[i]
+	chain.ipv6 = ipv6
+
+	if err := runIptables(ipv6, "-t", table, "-N", name); err != nil {
+		return err
+	}
+	action := "-A"
+	if chain.insert {
+		action = "-I"
+	}
+	if err := runIptables(ipv6, "-t", table, action, chain.parent, "-j", name); err != nil {
+		runIptables(ipv6, "-t", table, "-X", name)
+		return err
+	}
+	nm.chainsAdded = append(nm.chainsAdded, chain)
+	nm.state.AddChain(chain.stateChain())
 	return nil
 }
 
-// deleteIptablesRule удаляет правило iptables
-func (nm *NetworkManager) deleteIptablesRule(rule iptablesRule) error {
-	args := []string{"-t", rule.table, "-D", rule.chain}
-	args = append(args, rule.args...)
+// removeChain удаляет переходы в цепочку сервера, ее правила и саму цепочку
+func removeChain(chain iptablesChain) error {
+	// Переходов может быть несколько, если сервер несколько раз завершался аварийно
+	for {
+		if err := runIptables(chain.ipv6, "-t", chain.table, "-D", chain.parent, "-j", chain.name); err != nil {
+			break
+		}
+	}
+	if err := runIptables(chain.ipv6, "-t", chain.table, "-F", chain.name); err != nil {
+		return err
+	}
+	return runIptables(chain.ipv6, "-t", chain.table, "-X", chain.name)
+}
 
-	cmd := exec.Command("iptables", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		// Игнорируем ошибки если правило не существует
-		if !strings.Contains(string(output), "does a matching rule exist") {
-			return fmt.Errorf("iptables delete error: %s", string(output))
+// removeStaleChains удаляет цепочки сервера, оставшиеся после аварийного завершения
+// предыдущего запуска (их правила ссылались бы на старые интерфейсы и порты)
+func removeStaleChains() {
+	for _, ipv6 := range []bool{false, true} {
+		for _, chain := range iptablesChains {
+			chain.ipv6 = ipv6
+			if runIptables(ipv6, "-t", chain.table, "-S", chain.name) != nil {
+				continue
+			}
+			logNet.Warn("Removing stale iptables chain from a previous run", "chain", chain.name, "table", chain.table, "ipv6", ipv6)
+			if err := removeChain(chain); err != nil {
+				logNet.Warn("Failed to remove stale iptables chain", "chain", chain.name, logging.Err(err))
+			}
 		}
 	}
+}
+
+// runIptables запускает iptables (или ip6tables для IPv6)
+func runIptables(ipv6 bool, args ...string) error {
+	command := "iptables"
+	if ipv6 {
+		command = "ip6tables"
+	}
+	if output, err := exec.Command(command, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s error: %s", command, strings.TrimSpace(string(output)))
+	}
 	return nil
 }
 
-// getExternalInterface определяет внешний интерфейс
-func getExternalInterface() (string, error) {
-	cmd := exec.Command("ip", "route", "show", "default")
-	output, err := cmd.Output()
+// getExternalInterface определяет внешний интерфейс (IPv4 или IPv6 default route)
+func getExternalInterface(ipv6 bool) (string, error) {
+	routes, err := netlink.DefaultRoutes(ipv6)
 	if err != nil {
 		return "", err
 	}
-
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(lines) == 0 || lines[0] == "" {
+	if len(routes) == 0 {
 		return "", fmt.Errorf("no default route found")
 	}
-
-	// Парсим строку вида "default via 192.168.1.1 dev eth0"
-	parts := strings.Fields(lines[0])
-	for i, part := range parts {
-		if part == "dev" && i+1 < len(parts) {
-			return parts[i+1], nil
-		}
+	if routes[0].Dev == "" {
+		return "", fmt.Errorf("default route without interface: %s", routes[0])
 	}
-
-	return "", fmt.Errorf("failed to parse default route: %s", lines[0])
+	return routes[0].Dev, nil
 }
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"myvpn/internal/nft"
+	"myvpn/internal/porthop"
+)
+
+// Способы настройки NAT и правил фильтрации
+const (
+	// FirewallAuto iptables, если утилита установлена, иначе nftables
+	FirewallAuto = "auto"
+	// FirewallIptables правила в стандартных цепочках iptables и ip6tables
+	FirewallIptables = "iptables"
+	// FirewallNftables правила в отдельной таблице nftables NftTable
+	FirewallNftables = "nftables"
+	// FirewallNone без NAT и правил фильтрации: их уже настроил CNI кластера или хост,
+	// сервер только включает IP forwarding
+	FirewallNone = "none"
+)
+
+// NftTable таблица nftables (семейство inet) со всеми правилами сервера
+const NftTable = "myvpn"
+
+// selectFirewall возвращает способ настройки правил для значения -firewall
+func selectFirewall(name string) (string, error) {
+	switch name {
+	case FirewallIptables, FirewallNftables, FirewallNone:
+		return name, nil
+	case "", FirewallAuto:
+		if _, err := exec.LookPath("iptables"); err == nil {
+			return FirewallIptables, nil
+		}
+		if _, err := exec.LookPath("nft"); err == nil {
+			return FirewallNftables, nil
+		}
+		return FirewallIptables, nil
+	}
+	return "", fmt.Errorf("unknown firewall %q (expected auto, iptables, nftables or none)", name)
+}
+
+// setupNftables создает таблицу NftTable с правилами NAT, FORWARD и MSS clamping.
+// Таблица пересоздается одной транзакцией `nft -f`: остатки от предыдущего запуска
+// исчезают, а частично примененных правил не бывает. Правило accept в своей таблице
+// не отменяет drop в чужих (firewalld, iptables-nft), их нужно настроить отдельно
+func (nm *NetworkManager) setupNftables() error {
+	ipv6 := nm.externalInterface6 != ""
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "add table inet %s\ndelete table inet %s\n", NftTable, NftTable)
+	fmt.Fprintf(&b, "table inet %s {\n", NftTable)
+
+	b.WriteString("\tchain postrouting {\n\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tip saddr %s oifname %q masquerade\n", nm.vpnNetwork, nm.externalInterface)
+	if ipv6 {
+		fmt.Fprintf(&b, "\t\tip6 saddr %s oifname %q masquerade\n", nm.vpnNetwork6, nm.externalInterface6)
+	}
+	b.WriteString("\t}\n")
+
+	b.WriteString("\tchain forward {\n\t\ttype filter hook forward priority filter; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tip saddr %s accept\n\t\tip daddr %s accept\n", nm.vpnNetwork, nm.vpnNetwork)
+	if ipv6 {
+		fmt.Fprintf(&b, "\t\tip6 saddr %s accept\n\t\tip6 daddr %s accept\n", nm.vpnNetwork6, nm.vpnNetwork6)
+	}
+	b.WriteString("\t}\n")
+
+	if nm.mssClamp != MSSClampOff {
+		b.WriteString("\tchain mss {\n\t\ttype filter hook forward priority mangle; policy accept;\n")
+		for _, dir := range []string{"oifname", "iifname"} {
+			match := fmt.Sprintf("%s %q tcp flags & (syn | rst) == syn", dir, nm.tunInterface)
+			if nm.mssClamp == MSSClampPMTU {
+				fmt.Fprintf(&b, "\t\t%s tcp option maxseg size set rt mtu\n", match)
+				continue
+			}
+			// Как TCPMSS --set-mss: MSS только уменьшается. Заголовок IPv6 на 20 байт больше
+			fmt.Fprintf(&b, "\t\tmeta nfproto ipv4 %s tcp option maxseg size > %d tcp option maxseg size set %d\n", match, nm.mssClamp, nm.mssClamp)
+			if ipv6 {
+				fmt.Fprintf(&b, "\t\tmeta nfproto ipv6 %s tcp option maxseg size > %d tcp option maxseg size set %d\n", match, nm.mssClamp-20, nm.mssClamp-20)
+			}
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+
+	if err := runNft(b.String()); err != nil {
+		return err
+	}
+	nm.state.AddNftTable(NftTable)
+	logNet.Debug("nftables rules added", "table", "inet "+NftTable)
+	return nil
+}
+
+// setupPortHopNftables добавляет в таблицу NftTable перенаправление диапазона портов
+func (nm *NetworkManager) setupPortHopNftables(ports porthop.Range, listenPort int) error {
+	match := fmt.Sprintf("udp dport %d-%d", ports.First, ports.Last)
+	if nm.externalInterface6 == "" {
+		match = "meta nfproto ipv4 " + match
+	}
+	script := fmt.Sprintf("add chain inet %s prerouting { type nat hook prerouting priority dstnat; policy accept; }\n", NftTable) +
+		fmt.Sprintf("flush chain inet %s prerouting\n", NftTable) +
+		fmt.Sprintf("add rule inet %s prerouting %s redirect to :%d\n", NftTable, match, listenPort)
+	return runNft(script)
+}
+
+// cleanupNftables удаляет таблицу NftTable. Таблицы, которой уже нет, не считается ошибкой
+func (nm *NetworkManager) cleanupNftables() error {
+	if err := nft.DeleteTable(NftTable); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	nm.state.RemoveNftTable(NftTable)
+	return nil
+}
+
+// runNft применяет скрипт nft одной транзакцией
+func runNft(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft error: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
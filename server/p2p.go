@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/rand"
+	mrand "math/rand/v2"
+	"net/netip"
+	"time"
+
+	"myvpn/internal"
+	"myvpn/internal/logging"
+)
+
+// PeerIntroduceInterval через сколько сервер снова знакомит пару клиентов, пакеты которой
+// все еще идут через него (NAT не пробит или прямой путь пропал)
+const PeerIntroduceInterval = 30 * time.Second
+
+// peerPair пара клиентов, которых сервер познакомил (a < b)
+type peerPair struct {
+	a, b uint64
+}
+
+// introduction когда и с какими внешними адресами пара была познакомлена
+type introduction struct {
+	at      time.Time
+	addrs   [2]netip.AddrPort
+	session uint64 // session ID пары у клиентов
+}
+
+// meet знакомит клиентов, если их еще не знакомил, знакомил давно или у одного из них
+// сменился адрес. Вызывается для каждого пакета, который сервер переслал от from к to,
+// а в режиме mesh еще и при подключении клиента
+func (s *Server) meet(from, to *Client) {
+	pair := peerPair{from.sessionID, to.sessionID}
+	first, second := from, to
+	if pair.a > pair.b {
+		pair.a, pair.b = pair.b, pair.a
+		first, second = to, from
+	}
+	addrs := [2]netip.AddrPort{first.RemoteAddr().AddrPort(), second.RemoteAddr().AddrPort()}
+
+	now := time.Now()
+	session := mrand.Uint64()
+	s.introMu.Lock()
+	intro, ok := s.introductions[pair]
+	due := !ok || now.Sub(intro.at) >= PeerIntroduceInterval || intro.addrs != addrs
+	if due {
+		s.introductions[pair] = introduction{at: now, addrs: addrs, session: session}
+	}
+	s.introMu.Unlock()
+
+	if due {
+		s.introduce(from, to, session)
+	}
+}
+
+// joinMesh знакомит подключившегося или сменившего адрес клиента со всеми клиентами
+// с прямым обменом
+func (s *Server) joinMesh(client *Client) {
+	s.clientsMu.RLock()
+	others := make([]*Client, 0, len(s.clients))
+	for _, other := range s.clients {
+		if other != client && other.p2p.Load() {
+			others = append(others, other)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	for _, other := range others {
+		s.meet(client, other)
+	}
+}
+
+// introduce отправляет обоим клиентам внешний адрес другого и новый ключ пары. Инициатор
+// пары - клиент from
+func (s *Server) introduce(from, to *Client, session uint64) {
+	key := make([]byte, internal.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		logServer.Error("Failed to generate peer key", logging.Err(err))
+		return
+	}
+
+	s.clientsMu.RLock()
+	fromIPs, toIPs := s.clientIPs(from), s.clientIPs(to)
+	s.clientsMu.RUnlock()
+
+	metricPeerIntroductions.Inc()
+	logServer.Debug("Introducing clients for peer-to-peer", "from", from.VirtualIP(), "remote", from.RemoteAddr(),
+		"to", to.VirtualIP(), "peer_remote", to.RemoteAddr())
+	s.sendPeer(from, internal.PeerEndpoint{IPs: toIPs, Endpoint: to.RemoteAddr().String(), Session: session, Key: key, Initiator: true})
+	s.sendPeer(to, internal.PeerEndpoint{IPs: fromIPs, Endpoint: from.RemoteAddr().String(), Session: session, Key: key})
+}
+
+// sendPeer отправляет клиенту сведения о другом клиенте
+func (s *Server) sendPeer(client *Client, peer internal.PeerEndpoint) {
+	msg, err := internal.EncodeControl(internal.ControlPeer, peer)
+	if err != nil {
+		logServer.Error("Failed to encode peer endpoint", logging.Err(err))
+		return
+	}
+	if err := s.transport.WriteControl(msg, client.RemoteAddr(), client.sessionID); err != nil {
+		logTransport.Debug("Failed to send peer endpoint", "remote", client.RemoteAddr(), logging.Err(err))
+	}
+}
+
+// clientIPs возвращает адреса клиента внутри VPN: назначенные сервером или адрес,
+// с которого клиент отправляет пакеты. Требует s.clientsMu
+func (s *Server) clientIPs(client *Client) []string {
+	params := s.params[client.sessionID]
+	if params.ip == "" {
+		return []string{client.VirtualIP()}
+	}
+	ips := []string{params.ip}
+	if params.ip6 != "" {
+		ips = append(ips, params.ip6)
+	}
+	return ips
+}
+
+// forgetIntroductions удаляет пары удаленного клиента и сообщает другим клиентам пар,
+// что прямой обмен с ним закончен. Требует s.clientsMu
+func (s *Server) forgetIntroductions(sessionID uint64) {
+	s.introMu.Lock()
+	defer s.introMu.Unlock()
+	for pair, intro := range s.introductions {
+		if pair.a != sessionID && pair.b != sessionID {
+			continue
+		}
+		delete(s.introductions, pair)
+		other := pair.a
+		if other == sessionID {
+			other = pair.b
+		}
+		if client := s.clients[other]; client != nil {
+			s.sendPeer(client, internal.PeerEndpoint{Session: intro.session, Gone: true})
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"myvpn/adminrpc"
+	"myvpn/internal"
+	"myvpn/internal/peerdb"
+)
+
+// PeerRecord сведения о пире для admin API
+type PeerRecord = adminrpc.PeerRecord
+
+// errNoPeerDB операция требует базы пиров
+var errNoPeerDB = errors.New("peer database is not configured (-peer-db)")
+
+// dbPeers разбирает пиров из базы. Активные пиры с общим ключом возвращаются готовыми
+// для keyring, пиры с открытым ключом - в виде PeerConfig, включая отключенные: их ключ
+// выводится заранее, чтобы пира можно было включить без перезапуска. disabled - имена
+// отключенных пиров, чьи ключи не принимаются
+func dbPeers(db *peerdb.DB) (shared map[string]*internal.Crypto, public []PeerConfig, disabled map[string]bool, err error) {
+	if db == nil {
+		return nil, nil, nil, nil
+	}
+	records, err := db.List()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	shared = make(map[string]*internal.Crypto)
+	disabled = make(map[string]bool)
+	for _, p := range records {
+		switch {
+		case p.Status == peerdb.StatusRevoked:
+			continue
+		case p.Status == peerdb.StatusDisabled:
+			disabled[p.Name] = true
+		}
+		if p.PublicKey != "" {
+			public = append(public, PeerConfig{Name: p.Name, PublicKey: p.PublicKey, AllowedIPs: p.AllowedIPs, Certificate: p.Certificate})
+			continue
+		}
+		if p.Status == peerdb.StatusActive {
+			crypto, err := sharedKeyCrypto(p)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			shared[p.Name] = crypto
+		}
+	}
+	return shared, public, disabled, nil
+}
+
+// sharedKeyCrypto создает шифр пира с общим ключом из записи базы
+func sharedKeyCrypto(p peerdb.Peer) (*internal.Crypto, error) {
+	key, err := hex.DecodeString(p.Key)
+	if err != nil || len(key) != internal.KeySize {
+		return nil, fmt.Errorf("peer %q: invalid key in peer database", p.Name)
+	}
+	return internal.NewCrypto(key)
+}
+
+// PeerRecord возвращает сведения о пире: из базы, если он там есть, иначе о пире
+// из конфигурации, ключ которого принимает сервер
+func (s *Server) PeerRecord(name string) (PeerRecord, error) {
+	if s.peerDB != nil {
+		p, err := s.peerDB.Get(name)
+		if err == nil {
+			return PeerRecord{
+				Name:       p.Name,
+				PublicKey:  p.PublicKey,
+				AllowedIPs: p.AllowedIPs,
+				Status:     p.Status,
+				Created:    p.Created,
+				Updated:    p.Updated,
+			}, nil
+		}
+		if !errors.Is(err, peerdb.ErrNotFound) {
+			return PeerRecord{}, err
+		}
+	}
+	if !s.keyring.Has(name) {
+		return PeerRecord{}, peerdb.ErrNotFound
+	}
+	return PeerRecord{Name: name, Status: peerdb.StatusActive}, nil
+}
+
+// DisablePeer временно перестает принимать ключ пира из базы и разрывает его сессии
+func (s *Server) DisablePeer(name string) error {
+	if s.peerDB == nil {
+		return errNoPeerDB
+	}
+	if _, err := s.peerDB.SetStatus(name, peerdb.StatusDisabled); err != nil {
+		return err
+	}
+	sessions, _ := s.keyring.Remove(name)
+	for _, sessionID := range sessions {
+		s.DisconnectClient(sessionID)
+	}
+	logAdmin.Info("Peer disabled", "peer", name, "sessions_closed", len(sessions))
+	return nil
+}
+
+// EnablePeer снова принимает ключ отключенного пира из базы
+func (s *Server) EnablePeer(name string) error {
+	if s.peerDB == nil {
+		return errNoPeerDB
+	}
+	p, err := s.peerDB.Get(name)
+	if err != nil {
+		return err
+	}
+	if p.Status == peerdb.StatusRevoked {
+		return peerdb.ErrRevoked
+	}
+
+	var crypto *internal.Crypto
+	if p.PublicKey != "" {
+		kp := s.keyPeer(name)
+		if kp == nil {
+			return fmt.Errorf("peer %q is not loaded, restart the server", name)
+		}
+		crypto = kp.crypto
+	} else if crypto, err = sharedKeyCrypto(p); err != nil {
+		return err
+	}
+
+	if _, err := s.peerDB.SetStatus(name, peerdb.StatusActive); err != nil {
+		return err
+	}
+	if !s.keyring.Has(name) {
+		s.keyring.Add(name, crypto)
+	}
+	logAdmin.Info("Peer enabled", "peer", name)
+	return nil
+}
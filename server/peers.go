@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+
+	"myvpn/internal"
+	"myvpn/internal/logging"
+	"myvpn/internal/netlink"
+)
+
+// PeerConfig пир, который подключается со своим ключом X25519. Ключ сессии пира выводится
+// из закрытого ключа сервера и открытого ключа пира (internal.StaticKey)
+type PeerConfig struct {
+	// Name имя пира в admin API и лимитах (пусто - начало открытого ключа)
+	Name string `json:"name"`
+	// PublicKey открытый ключ X25519 пира в base64 или hex
+	PublicKey string `json:"public_key"`
+	// AllowedIPs сети (CIDR), адреса из которых пир может использовать внутри VPN.
+	// Пакеты пира с другим адресом источника отбрасываются. Пакеты к адресам из этих сетей
+	// сервер отправляет пиру, а другие сессии не могут их использовать. Первые адреса /32 и /128
+	// из списка сервер назначает пиру, когда тот просит адрес (-ip auto)
+	AllowedIPs []string `json:"allowed_ips"`
+	// Certificate имя из сертификата клиента (Common Name), с которым пир может подключаться
+	// через TLS транспорты с проверкой сертификатов (пусто - совпадает с Name)
+	Certificate string `json:"certificate"`
+}
+
+// LoadPeers загружает список пиров из JSON файла (массив PeerConfig)
+func LoadPeers(path string) ([]PeerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peers file: %w", err)
+	}
+	var peers []PeerConfig
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("failed to parse peers file %s: %w", path, err)
+	}
+	return peers, nil
+}
+
+// keyPeer пир с открытым ключом после разбора конфигурации
+type keyPeer struct {
+	crypto      *internal.Crypto
+	publicKey   string // открытый ключ пира (internal.FormatKey)
+	allowed     []*net.IPNet
+	ip, ip6     net.IP // адреса, которые сервер назначает пиру (nil - нет подходящих)
+	certificate string // имя из сертификата клиента для TLS транспортов
+}
+
+// loadKeyPeers выводит ключи пиров и разбирает их AllowedIPs
+func loadKeyPeers(privateKey []byte, peers []PeerConfig) (map[string]*keyPeer, error) {
+	if len(peers) == 0 {
+		return nil, nil
+	}
+	if privateKey == nil {
+		return nil, errors.New("public-key peers require the server private key")
+	}
+
+	result := make(map[string]*keyPeer, len(peers))
+	for _, cfg := range peers {
+		public, err := internal.ParseKey(cfg.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("peer %q: %w", cfg.Name, err)
+		}
+		name := cfg.Name
+		if name == "" {
+			name = internal.FormatKey(public)[:8]
+		}
+		if _, ok := result[name]; ok || name == DefaultPeer {
+			return nil, fmt.Errorf("duplicate peer name %q", name)
+		}
+
+		key, err := internal.StaticKey(privateKey, public, true)
+		if err != nil {
+			return nil, fmt.Errorf("peer %q: %w", name, err)
+		}
+		crypto, err := internal.NewCrypto(key)
+		if err != nil {
+			return nil, fmt.Errorf("peer %q: %w", name, err)
+		}
+
+		peer := &keyPeer{crypto: crypto, publicKey: internal.FormatKey(public), certificate: cfg.Certificate}
+		for _, cidr := range cfg.AllowedIPs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("peer %q: invalid allowed IP %q: %w", name, cidr, err)
+			}
+			peer.allowed = append(peer.allowed, network)
+
+			ones, bits := network.Mask.Size()
+			switch {
+			case ones == 32 && bits == 32 && peer.ip == nil:
+				peer.ip = network.IP
+			case ones == 128 && bits == 128 && peer.ip6 == nil:
+				peer.ip6 = network.IP
+			}
+		}
+		result[name] = peer
+	}
+	return result, nil
+}
+
+// keyPeerSet пиры с открытыми ключами. Набор не меняется после создания: перезагрузка
+// конфигурации заменяет его целиком, поэтому путь данных читает его без блокировок
+type keyPeerSet struct {
+	peers  map[string]*keyPeer
+	routes []peerRoute // AllowedIPs всех пиров, от длинных префиксов к коротким
+}
+
+// peerRoute сеть из AllowedIPs пира
+type peerRoute struct {
+	prefix netip.Prefix
+	peer   string
+}
+
+func newKeyPeerSet(peers map[string]*keyPeer) *keyPeerSet {
+	set := &keyPeerSet{peers: peers}
+	for name, peer := range peers {
+		for _, network := range peer.allowed {
+			ip, _ := netip.AddrFromSlice(network.IP)
+			ones, _ := network.Mask.Size()
+			set.routes = append(set.routes, peerRoute{prefix: netip.PrefixFrom(ip.Unmap(), ones), peer: name})
+		}
+	}
+	slices.SortStableFunc(set.routes, func(a, b peerRoute) int {
+		return b.prefix.Bits() - a.prefix.Bits()
+	})
+	return set
+}
+
+// owner возвращает пира, в AllowedIPs которого ip попадает самым длинным префиксом
+// (пусто - ни в чьи). Пакеты к ip идут этому пиру, и только он может отправлять от ip
+func (set *keyPeerSet) owner(ip net.IP) string {
+	if len(set.routes) == 0 {
+		return ""
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ""
+	}
+	addr = addr.Unmap()
+	for _, route := range set.routes {
+		if route.prefix.Contains(addr) {
+			return route.peer
+		}
+	}
+	return ""
+}
+
+// reserved сообщает, что адрес IPv4 ip или IPv6 ip6 (может быть nil) попадает в AllowedIPs
+// пира: пул не выдает такие адреса другим сессиям
+func (set *keyPeerSet) reserved(ip, ip6 net.IP) bool {
+	return set.owner(ip) != "" || ip6 != nil && set.owner(ip6) != ""
+}
+
+// subnets возвращает сети из AllowedIPs пиров, которых нет в VPN подсетях: ядру нужны
+// маршруты к ним через TUN
+func (set *keyPeerSet) subnets() map[netip.Prefix]bool {
+	result := make(map[netip.Prefix]bool)
+	for _, route := range set.routes {
+		inside := false
+		for _, vpn := range netstackExcluded {
+			if vpn.Bits() <= route.prefix.Bits() && vpn.Contains(route.prefix.Addr()) {
+				inside = true
+			}
+		}
+		if !inside {
+			result[route.prefix.Masked()] = true
+		}
+	}
+	return result
+}
+
+// syncPeerRoutes направляет в TUN сети из AllowedIPs пиров next и убирает маршруты сетей,
+// которые были только у old (nil при запуске). Маршруты исчезают вместе с TUN, поэтому
+// при остановке не удаляются. Userspace стеку маршруты не нужны
+func (s *Server) syncPeerRoutes(old, next *keyPeerSet) {
+	if s.tun.Userspace() {
+		return
+	}
+	current := next.subnets()
+	if old != nil {
+		for prefix := range old.subnets() {
+			if current[prefix] {
+				continue
+			}
+			if err := netlink.RouteDel(netlink.Route{Dst: prefix, Dev: s.tun.Name()}); err != nil {
+				logNet.Debug("Failed to remove peer route", "route", prefix, logging.Err(err))
+			}
+		}
+	}
+	for prefix := range current {
+		if prefix.Addr().Is6() && !s.tun.HasIPv6() {
+			continue
+		}
+		err := netlink.RouteAdd(netlink.Route{Dst: prefix, Dev: s.tun.Name()})
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			logNet.Warn("Failed to route peer network to TUN", "route", prefix, logging.Err(err))
+		}
+	}
+}
+
+// keyPeer возвращает пира с открытым ключом (nil - нет такого)
+func (s *Server) keyPeer(name string) *keyPeer {
+	return s.keyPeers.Load().peers[name]
+}
+
+// logPublicKey выводит открытый ключ сервера, который нужен клиентам с -private-key
+func logPublicKey(privateKey []byte) error {
+	public, err := internal.PublicKey(privateKey)
+	if err != nil {
+		return err
+	}
+	logCrypto.Info("Server public key", "key", internal.FormatKey(public))
+	return nil
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"myvpn/internal"
+	"myvpn/internal/transport"
+)
+
+// newTestPeerServer создает сервер -netstack с общим ключом и пиром office с открытым
+// ключом. Возвращает Crypto пира: его пакетами сессия привязывается к пиру
+func newTestPeerServer(t *testing.T, allowedIPs ...string) (*Server, *internal.Crypto) {
+	t.Helper()
+	serverKey, err := internal.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := internal.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerPublic, _ := internal.PublicKey(peerKey)
+	s, err := NewServer(Config{
+		ListenAddr: "127.0.0.1:0",
+		Netstack:   true,
+		Key:        make([]byte, internal.KeySize),
+		PrivateKey: serverKey,
+		Peers:      []PeerConfig{{Name: "office", PublicKey: internal.FormatKey(peerPublic), AllowedIPs: allowedIPs}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.tun.Close() })
+
+	serverPublic, _ := internal.PublicKey(serverKey)
+	key, err := internal.StaticKey(peerKey, serverPublic, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crypto, err := internal.NewCrypto(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, crypto
+}
+
+// bindSession привязывает сессию к ключу crypto, как первый расшифрованный пакет
+func bindSession(t *testing.T, s *Server, sessionID uint64, crypto *internal.Crypto) {
+	t.Helper()
+	aad := make([]byte, 18)
+	binary.BigEndian.PutUint64(aad[1:], sessionID)
+	nonce := make([]byte, internal.NonceSize)
+	sealed, err := crypto.Seal(nil, nonce, []byte("hello"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.keyring.Open(nil, nonce, sealed, aad); err != nil {
+		t.Fatalf("session %d: %v", sessionID, err)
+	}
+}
+
+// ipv4Packet заголовок IPv4 пакета UDP от src к dst
+func ipv4Packet(src, dst string) []byte {
+	packet := make([]byte, 20)
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], 20)
+	packet[8] = 64
+	packet[9] = 17
+	copy(packet[12:], net.ParseIP(src).To4())
+	copy(packet[16:], net.ParseIP(dst).To4())
+	return packet
+}
+
+// sendFrom передает серверу пакет сессии от адреса src
+func sendFrom(s *Server, sessionID uint64, src string) {
+	s.handleClientPacket(&transport.Packet{
+		Data:      ipv4Packet(src, "198.51.100.1"),
+		Addr:      &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(sessionID)), Port: 40000},
+		SessionID: sessionID,
+	})
+}
+
+// routedSession возвращает сессию, которой сервер отправил пакет из TUN к dst (0 - никому)
+func routedSession(t *testing.T, s *Server, dst string) uint64 {
+	t.Helper()
+	s.forwardFromTun(ipv4Packet("198.51.100.1", dst))
+	p, ok := s.outgoing.TryPop()
+	if !ok {
+		return 0
+	}
+	return p.SessionID
+}
+
+// TestKeyPeerAddressTakeover проверяет, что сессия с общим ключом не может отправлять
+// пакеты от адресов из AllowedIPs пира и перехватить ответы ему, а пакеты к хостам сети
+// пира идут пиру
+func TestKeyPeerAddressTakeover(t *testing.T) {
+	s, peerCrypto := newTestPeerServer(t, "10.0.0.200/32", "192.168.50.0/24", "192.168.50.128/25")
+	const peerSession, sharedSession = 1, 2
+	bindSession(t, s, peerSession, peerCrypto)
+	shared, _ := internal.NewCrypto(make([]byte, internal.KeySize))
+	bindSession(t, s, sharedSession, shared)
+
+	sendFrom(s, peerSession, "10.0.0.200")
+	sendFrom(s, peerSession, "192.168.50.7")
+	sendFrom(s, sharedSession, "10.0.0.5")
+	for _, src := range []string{"10.0.0.200", "192.168.50.7", "192.168.50.200"} {
+		sendFrom(s, sharedSession, src)
+	}
+
+	s.clientsMu.RLock()
+	for _, ip := range []string{"10.0.0.200", "192.168.50.7"} {
+		if c := s.clientsByIP[ip]; c == nil || c.sessionID != peerSession {
+			t.Errorf("%s mapped to %v, want the peer session", ip, c)
+		}
+	}
+	if c := s.clientsByIP["192.168.50.200"]; c != nil {
+		t.Errorf("192.168.50.200 learned from session %d", c.sessionID)
+	}
+	s.clientsMu.RUnlock()
+
+	for dst, want := range map[string]uint64{
+		"10.0.0.200":     peerSession,
+		"192.168.50.7":   peerSession,
+		"192.168.50.200": peerSession, // хост сети пира, от которого пакетов еще не было
+		"10.0.0.5":       sharedSession,
+		"10.0.0.6":       0,
+	} {
+		if got := routedSession(t, s, dst); got != want {
+			t.Errorf("packet to %s sent to session %d, want %d", dst, got, want)
+		}
+	}
+
+	// Пир не может отправлять от адресов вне своих AllowedIPs
+	sendFrom(s, peerSession, "10.0.0.5")
+	if got := routedSession(t, s, "10.0.0.5"); got != sharedSession {
+		t.Errorf("packet to 10.0.0.5 sent to session %d after a spoofed peer packet", got)
+	}
+}
+
+// TestLeaseSkipsPeerNetworks проверяет, что пул не выдает клиентам адреса из AllowedIPs
+// пиров: ни IPv4, ни IPv6, ни из сетей длиннее /32 и /128
+func TestLeaseSkipsPeerNetworks(t *testing.T) {
+	s, _ := newTestPeerServer(t, "10.0.0.2/32", "10.0.0.4/30", "fd00::3/128")
+	if !s.tun.HasIPv6() {
+		t.Skip("TUN without IPv6")
+	}
+	lease, err := s.leaseAddress(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lease.ip.String(); got != "10.0.0.8" {
+		t.Fatalf("leased %s (%s), want 10.0.0.8 after the peer networks", got, lease.ip6)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// addressLease адреса, выданные сессии
+type addressLease struct {
+	ip    net.IP // IPv4 адрес клиента
+	ip6   net.IP // IPv6 адрес клиента с тем же номером хоста
+	since time.Time
+}
+
+// addressPool раздает клиентам виртуальные адреса из подсети VPN. Аренда привязана
+// к session ID, поэтому клиент сохраняет адрес после переподключения и смены сети
+type addressPool struct {
+	mu      sync.Mutex
+	network *net.IPNet
+	prefix6 *net.IPNet
+	leases  map[uint64]*addressLease
+	used    map[string]uint64 // IPv4 адрес -> session ID
+}
+
+// newAddressPool создает пул адресов подсетей network и network6. Пустая network6 -
+// клиенты получают только IPv4 адреса
+func newAddressPool(network, network6 string) (*addressPool, error) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VPN network: %w", err)
+	}
+	var ipNet6 *net.IPNet
+	if network6 != "" {
+		if _, ipNet6, err = net.ParseCIDR(network6); err != nil {
+			return nil, fmt.Errorf("invalid VPN IPv6 network: %w", err)
+		}
+	}
+	return &addressPool{
+		network: ipNet,
+		prefix6: ipNet6,
+		leases:  make(map[uint64]*addressLease),
+		used:    make(map[string]uint64),
+	}, nil
+}
+
+// lease возвращает адреса сессии, выдавая новые при первом запросе. taken сообщает,
+// заняты ли адреса IPv4 ip или IPv6 ip6 (nil без IPv6): пиром или клиентом, который
+// назначил адрес себе сам (-ip на клиенте)
+func (p *addressPool) lease(sessionID uint64, taken func(ip, ip6 net.IP) bool) (*addressLease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.leases[sessionID]; ok {
+		return l, nil
+	}
+
+	ones, bits := p.network.Mask.Size()
+	hosts := 1<<(bits-ones) - 1 // без адреса сети и broadcast
+	// Первый адрес подсети занимает сервер (TUNAddress)
+	for host := 2; host < hosts; host++ {
+		ip := hostIP(p.network.IP, host)
+		var ip6 net.IP
+		if p.prefix6 != nil {
+			ip6 = hostIP(p.prefix6.IP, host)
+		}
+		if _, ok := p.used[ip.String()]; ok || taken(ip, ip6) {
+			continue
+		}
+		l := &addressLease{ip: ip, ip6: ip6, since: time.Now()}
+		p.leases[sessionID] = l
+		p.used[ip.String()] = sessionID
+		return l, nil
+	}
+	return nil, errors.New("address pool exhausted")
+}
+
+// assign закрепляет за сессией заданные адреса (ip6 может быть nil)
+func (p *addressPool) assign(sessionID uint64, ip, ip6 net.IP) (*addressLease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.leases[sessionID]; ok {
+		return l, nil
+	}
+	if owner, ok := p.used[ip.String()]; ok && owner != sessionID {
+		return nil, fmt.Errorf("address %s is in use by another session", ip)
+	}
+	if p.prefix6 == nil {
+		ip6 = nil
+	}
+	l := &addressLease{ip: ip, ip6: ip6, since: time.Now()}
+	p.leases[sessionID] = l
+	p.used[ip.String()] = sessionID
+	return l, nil
+}
+
+// release возвращает адреса сессии в пул
+func (p *addressPool) release(sessionID uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.leases[sessionID]; ok {
+		delete(p.used, l.ip.String())
+		delete(p.leases, sessionID)
+	}
+}
+
+// reclaim освобождает адреса сессий, которые получили их раньше deadline,
+// но так и не стали клиентами (active возвращает false)
+func (p *addressPool) reclaim(active func(sessionID uint64) bool, deadline time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for sessionID, l := range p.leases {
+		if l.since.Before(deadline) && !active(sessionID) {
+			delete(p.used, l.ip.String())
+			delete(p.leases, sessionID)
+		}
+	}
+}
+
+// prefixes возвращает длины префиксов подсетей IPv4 и IPv6
+func (p *addressPool) prefixes() (int, int) {
+	ones, _ := p.network.Mask.Size()
+	if p.prefix6 == nil {
+		return ones, 0
+	}
+	ones6, _ := p.prefix6.Mask.Size()
+	return ones, ones6
+}
+
+// FreePeerAddress выбирает адреса для нового пира с открытым ключом: IPv4 из VPNNetwork
+// и IPv6 с тем же номером хоста из VPNNetwork6. Хосты перебираются с конца подсети,
+// а пул выдает адреса с начала, поэтому до заполнения подсети они не пересекаются.
+// used - адреса IPv4, уже закрепленные за пирами
+func FreePeerAddress(used map[string]bool) (ip, ip6 net.IP, err error) {
+	_, network, err := net.ParseCIDR(VPNNetwork)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, network6, err := net.ParseCIDR(VPNNetwork6)
+	if err != nil {
+		return nil, nil, err
+	}
+	ones, bits := network.Mask.Size()
+	// Без broadcast, адреса сети и адреса сервера
+	for host := 1<<(bits-ones) - 2; host >= 2; host-- {
+		ip := hostIP(network.IP, host)
+		if !used[ip.String()] {
+			return ip, hostIP(network6.IP, host), nil
+		}
+	}
+	return nil, nil, errors.New("no free addresses left in " + VPNNetwork)
+}
+
+// hostIP возвращает адрес с номером хоста host в подсети с адресом base
+func hostIP(base net.IP, host int) net.IP {
+	ip := make(net.IP, len(base))
+	copy(ip, base)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for i := len(ip) - 1; i >= 0 && host > 0; i-- {
+		sum := int(ip[i]) + host&0xff
+		ip[i] = byte(sum)
+		host = host>>8 + sum>>8
+	}
+	return ip
+}
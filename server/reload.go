@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"myvpn/internal/ratelimit"
+)
+
+// ReloadConfig параметры сервера, которые меняются без перезапуска и без разрыва сессий
+type ReloadConfig struct {
+	// DNSServers, PushRoutes и PushMTU передаются клиентам при следующем запросе конфигурации
+	DNSServers []string
+	PushRoutes []string
+	PushMTU    int
+	// IdleTimeout время неактивности, после которого сессия удаляется. 0 - не удалять
+	IdleTimeout time.Duration
+	// MaxClients максимальное число сессий. Уже подключенные клиенты сверх лимита не отключаются
+	MaxClients int
+	// DefaultLimit и PeerLimits лимиты скорости, применяются к подключенным клиентам сразу.
+	// Лимиты, заданные через admin API, заменяются
+	DefaultLimit RateLimit
+	PeerLimits   map[string]RateLimit
+	// Peers пиры с открытыми ключами (Config.Peers). Пиры из базы не меняются
+	Peers []PeerConfig
+}
+
+// errNoReload сервер создан без Config.Reload
+var errNoReload = errors.New("configuration reload is not available")
+
+// peerPlan изменения пиров из конфигурации, проверенные до применения
+type peerPlan struct {
+	next    map[string]*keyPeer // новый набор пиров с открытыми ключами
+	names   map[string]bool     // имена пиров из конфигурации
+	added   []string
+	removed []string
+	rekeyed []string // сменился ключ или назначаемый адрес: сессии пира разрываются
+	updated []string // сменились только AllowedIPs или сертификат: сессии сохраняются
+}
+
+// Reload загружает конфигурацию заново (Config.Reload) и применяет изменения, как ApplyConfig
+func (s *Server) Reload() ([]string, error) {
+	if s.reload == nil {
+		return nil, errNoReload
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	cfg, err := s.reload()
+	if err != nil {
+		return nil, err
+	}
+	return s.applyConfig(cfg)
+}
+
+// ApplyConfig применяет новые параметры и возвращает описание изменений. Меняется только то,
+// что отличается от текущих параметров: сессии пиров, у которых не изменились ключ и адрес,
+// продолжают работать. Если параметры неверны, не меняется ничего
+func (s *Server) ApplyConfig(cfg ReloadConfig) ([]string, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.applyConfig(cfg)
+}
+
+// applyConfig применяет параметры. Требует s.reloadMu
+func (s *Server) applyConfig(cfg ReloadConfig) ([]string, error) {
+	for _, cidr := range cfg.PushRoutes {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid pushed route %q: %w", cidr, err)
+		}
+	}
+	plan, err := s.planPeers(cfg.Peers)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	s.configMu.Lock()
+	if !equalStrings(s.dnsServers, cfg.DNSServers) {
+		s.dnsServers = cfg.DNSServers
+		changes = append(changes, "dns: "+formatList(cfg.DNSServers))
+	}
+	if !equalStrings(s.pushRoutes, cfg.PushRoutes) {
+		s.pushRoutes = cfg.PushRoutes
+		changes = append(changes, "push routes: "+formatList(cfg.PushRoutes))
+	}
+	if s.pushMTU != cfg.PushMTU {
+		s.pushMTU = cfg.PushMTU
+		changes = append(changes, fmt.Sprintf("push MTU: %d", cfg.PushMTU))
+	}
+	oldDefault, oldLimits := s.defaultLimit, s.peerLimits
+	s.defaultLimit = cfg.DefaultLimit
+	s.peerLimits = make(map[string]RateLimit, len(cfg.PeerLimits))
+	for name, limit := range cfg.PeerLimits {
+		s.peerLimits[name] = limit
+	}
+	s.configMu.Unlock()
+
+	if oldDefault != cfg.DefaultLimit {
+		changes = append(changes, "default rate limit: "+formatLimit(cfg.DefaultLimit))
+	}
+	var limitChanges []string
+	for name, limit := range cfg.PeerLimits {
+		if old, ok := oldLimits[name]; !ok || old != limit {
+			limitChanges = append(limitChanges, fmt.Sprintf("rate limit of peer %q: %s", name, formatLimit(limit)))
+		}
+	}
+	for name := range oldLimits {
+		if _, ok := cfg.PeerLimits[name]; !ok {
+			limitChanges = append(limitChanges, fmt.Sprintf("rate limit of peer %q: default", name))
+		}
+	}
+	sort.Strings(limitChanges)
+	changes = append(changes, limitChanges...)
+	// Новые лимиты сразу действуют для подключенных клиентов, у которых они изменились
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		old, ok := oldLimits[client.peer]
+		if !ok {
+			old = oldDefault
+		}
+		if limit := s.limitFor(client.peer); limit != old {
+			client.setLimit(limit)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	if old := time.Duration(s.idleTimeout.Swap(int64(cfg.IdleTimeout))); old != cfg.IdleTimeout {
+		changes = append(changes, fmt.Sprintf("idle timeout: %s", cfg.IdleTimeout))
+	}
+	if old := s.maxClients.Swap(int64(cfg.MaxClients)); old != int64(cfg.MaxClients) {
+		changes = append(changes, fmt.Sprintf("max clients: %d", cfg.MaxClients))
+	}
+
+	changes = append(changes, s.applyPeers(plan)...)
+	if len(changes) == 0 {
+		logAdmin.Info("Configuration reloaded, nothing changed")
+	} else {
+		logAdmin.Info("Configuration reloaded", "changes", changes)
+	}
+	return changes, nil
+}
+
+// planPeers сравнивает пиров из конфигурации с текущими. Пиры из базы остаются как есть,
+// а пир из конфигурации не может занять имя пира из базы или admin API
+func (s *Server) planPeers(peers []PeerConfig) (*peerPlan, error) {
+	loaded, err := loadKeyPeers(s.privateKey, peers)
+	if err != nil {
+		return nil, err
+	}
+	current := s.keyPeers.Load().peers
+	plan := &peerPlan{next: make(map[string]*keyPeer), names: make(map[string]bool, len(loaded))}
+	for name, peer := range current {
+		if !s.filePeers[name] {
+			plan.next[name] = peer
+		}
+	}
+	for name, peer := range loaded {
+		old := current[name]
+		if !s.filePeers[name] && (old != nil || s.keyring.Has(name)) {
+			return nil, fmt.Errorf("peer %q: name is taken by a peer from the database or admin API", name)
+		}
+		switch {
+		case old == nil:
+			plan.added = append(plan.added, name)
+		case old.publicKey != peer.publicKey || !old.ip.Equal(peer.ip) || !old.ip6.Equal(peer.ip6):
+			plan.rekeyed = append(plan.rekeyed, name)
+		case old.certificate != peer.certificate || formatNetworks(old.allowed) != formatNetworks(peer.allowed):
+			// Ключ в keyring остается прежним
+			peer.crypto = old.crypto
+			plan.updated = append(plan.updated, name)
+		default:
+			peer = old
+		}
+		plan.next[name] = peer
+		plan.names[name] = true
+	}
+	for name := range s.filePeers {
+		if !plan.names[name] {
+			plan.removed = append(plan.removed, name)
+		}
+	}
+	for _, names := range [][]string{plan.added, plan.removed, plan.rekeyed, plan.updated} {
+		sort.Strings(names)
+	}
+	return plan, nil
+}
+
+// applyPeers применяет проверенные изменения пиров. Ключи убираются из keyring до замены
+// набора пиров, а добавляются после, чтобы новая сессия не осталась без AllowedIPs
+func (s *Server) applyPeers(plan *peerPlan) []string {
+	var changes []string
+	closed := make(map[string]int)
+	for _, name := range append(plan.removed, plan.rekeyed...) {
+		sessions, _ := s.keyring.Remove(name)
+		for _, sessionID := range sessions {
+			s.DisconnectClient(sessionID)
+		}
+		closed[name] = len(sessions)
+	}
+	next := newKeyPeerSet(plan.next)
+	s.syncPeerRoutes(s.keyPeers.Swap(next), next)
+	s.filePeers = plan.names
+	for _, name := range append(plan.added, plan.rekeyed...) {
+		s.keyring.Add(name, plan.next[name].crypto)
+	}
+
+	for _, name := range plan.added {
+		changes = append(changes, fmt.Sprintf("peer %q added", name))
+	}
+	for _, name := range plan.removed {
+		changes = append(changes, fmt.Sprintf("peer %q removed (%d sessions closed)", name, closed[name]))
+	}
+	for _, name := range plan.rekeyed {
+		changes = append(changes, fmt.Sprintf("peer %q key or address changed (%d sessions closed)", name, closed[name]))
+	}
+	for _, name := range plan.updated {
+		changes = append(changes, fmt.Sprintf("peer %q allowed IPs or certificate changed", name))
+	}
+	return changes
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// formatList выводит список через запятую, пустой - как "none"
+func formatList(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ",")
+}
+
+func formatLimit(limit RateLimit) string {
+	return ratelimit.FormatRate(limit.Up) + "/" + ratelimit.FormatRate(limit.Down)
+}
+
+func formatNetworks(networks []*net.IPNet) string {
+	items := make([]string, len(networks))
+	for i, network := range networks {
+		items[i] = network.String()
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"myvpn/internal/transport"
+)
+
+// StatsVersion версия схемы /stats.json. Поля только добавляются; при несовместимом
+// изменении версия увеличивается
+const StatsVersion = 1
+
+// statsWindow самый длинный интервал усреднения скорости в секундах
+const statsWindow = 60
+
+// Rate скорость за интервал
+type Rate struct {
+	BytesPerSecond   float64 `json:"bytes_per_second"`
+	PacketsPerSecond float64 `json:"packets_per_second"`
+}
+
+// Rates скорость за последние 1, 10 и 60 секунд. Сразу после запуска интервал
+// короче: скорость считается за прошедшее время
+type Rates struct {
+	Last1s  Rate `json:"1s"`
+	Last10s Rate `json:"10s"`
+	Last60s Rate `json:"60s"`
+}
+
+// Throughput скорость внутреннего трафика: RX - от клиентов, TX - к клиентам
+type Throughput struct {
+	RX Rates `json:"rx"`
+	TX Rates `json:"tx"`
+}
+
+// Totals трафик с запуска сервера
+type Totals struct {
+	RXBytes   uint64 `json:"rx_bytes"`
+	RXPackets uint64 `json:"rx_packets"`
+	TXBytes   uint64 `json:"tx_bytes"`
+	TXPackets uint64 `json:"tx_packets"`
+}
+
+// Drops отброшенные пакеты с запуска сервера по причинам
+type Drops struct {
+	UnknownDestination  uint64 `json:"unknown_destination"`
+	RateLimitedUpload   uint64 `json:"rate_limited_upload"`
+	RateLimitedDownload uint64 `json:"rate_limited_download"`
+	SpoofedSource       uint64 `json:"spoofed_source"`
+	DecompressFailures  uint64 `json:"decompress_failures"`
+	DecryptFailures     uint64 `json:"decrypt_failures"`
+	Replay              uint64 `json:"replay"`
+	Malformed           uint64 `json:"malformed"`
+	ReassemblyTimeouts  uint64 `json:"reassembly_timeouts"`
+	HandshakeLimited    uint64 `json:"handshake_rate_limited"`
+	QueueUpload         uint64 `json:"queue_upload"`
+	QueueDownload       uint64 `json:"queue_download"`
+}
+
+// Stats сводка состояния сервера для дашбордов (/stats.json)
+type Stats struct {
+	Version       int          `json:"version"`
+	Time          time.Time    `json:"time"`
+	UptimeSeconds float64      `json:"uptime_seconds"`
+	Sessions      int          `json:"sessions"`
+	Throughput    Throughput   `json:"throughput"`
+	Totals        Totals       `json:"totals"`
+	Drops         Drops        `json:"drops"`
+	Clients       []ClientInfo `json:"clients"`
+}
+
+// trafficSample значения счетчиков трафика в момент времени
+type trafficSample struct {
+	at     time.Time
+	totals Totals
+}
+
+// trafficSampler раз в секунду запоминает счетчики трафика за последние statsWindow секунд
+type trafficSampler struct {
+	mu      sync.Mutex
+	samples [statsWindow + 1]trafficSample
+	count   int // число снятых значений
+}
+
+func (r *trafficSampler) add(sample trafficSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.count%len(r.samples)] = sample
+	r.count++
+}
+
+// rates считает скорость за каждый интервал: от значения, снятого на интервал раньше
+// последнего, до now
+func (r *trafficSampler) rates(now trafficSample) Throughput {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var t Throughput
+	for _, period := range []struct {
+		seconds int
+		rx, tx  *Rate
+	}{
+		{1, &t.RX.Last1s, &t.TX.Last1s},
+		{10, &t.RX.Last10s, &t.TX.Last10s},
+		{statsWindow, &t.RX.Last60s, &t.TX.Last60s},
+	} {
+		if r.count == 0 {
+			break
+		}
+		back := min(period.seconds+1, r.count)
+		from := r.samples[(r.count-back)%len(r.samples)]
+		elapsed := now.at.Sub(from.at).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		*period.rx = Rate{
+			BytesPerSecond:   float64(now.totals.RXBytes-from.totals.RXBytes) / elapsed,
+			PacketsPerSecond: float64(now.totals.RXPackets-from.totals.RXPackets) / elapsed,
+		}
+		*period.tx = Rate{
+			BytesPerSecond:   float64(now.totals.TXBytes-from.totals.TXBytes) / elapsed,
+			PacketsPerSecond: float64(now.totals.TXPackets-from.totals.TXPackets) / elapsed,
+		}
+	}
+	return t
+}
+
+// trafficNow снимает текущие значения счетчиков трафика
+func trafficNow() trafficSample {
+	return trafficSample{
+		at: time.Now(),
+		totals: Totals{
+			RXBytes:   metricTunBytesOut.Load(),
+			RXPackets: metricTunPacketsOut.Load(),
+			TXBytes:   metricTunBytesIn.Load(),
+			TXPackets: metricTunPacketsIn.Load(),
+		},
+	}
+}
+
+// sampleTraffic раз в секунду запоминает счетчики трафика для расчета скорости
+func (s *Server) sampleTraffic() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	s.traffic.add(trafficNow())
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.traffic.add(trafficNow())
+		}
+	}
+}
+
+// Stats возвращает сводку состояния сервера
+func (s *Server) Stats() Stats {
+	now := trafficNow()
+	clients := s.Clients()
+	dropped := transport.Dropped()
+	return Stats{
+		Version:       StatsVersion,
+		Time:          now.at,
+		UptimeSeconds: now.at.Sub(s.startTime).Seconds(),
+		Sessions:      len(clients),
+		Throughput:    s.traffic.rates(now),
+		Totals:        now.totals,
+		Drops: Drops{
+			UnknownDestination:  metricUnknownDest.Load(),
+			RateLimitedUpload:   metricRateLimitUp.Load(),
+			RateLimitedDownload: metricRateLimitDown.Load(),
+			SpoofedSource:       metricSpoofed.Load(),
+			DecompressFailures:  metricDecompressFail.Load(),
+			DecryptFailures:     dropped.DecryptFailures,
+			Replay:              dropped.Replay,
+			Malformed:           dropped.Malformed,
+			ReassemblyTimeouts:  dropped.ReassemblyTimeouts,
+			HandshakeLimited:    dropped.HandshakeLimited,
+			QueueUpload:         metricQueueDropUp.Load(),
+			QueueDownload:       metricQueueDropDown.Load(),
+		},
+		Clients: clients,
+	}
+}
+
+// StatsHandler HTTP обработчик /stats.json. Как и /metrics, не требует токена
+func (s *Server) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Stats())
+	})
+}
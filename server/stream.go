@@ -0,0 +1,208 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"myvpn/internal/logging"
+	"myvpn/internal/transport"
+)
+
+// streamConfig адреса и настройки запасных транспортов (TCP, WebSocket, KCP, gRPC и DNS)
+type streamConfig struct {
+	tcp      string
+	tcpTLS   bool
+	wss      string
+	cert     string
+	key      string
+	clientCA string
+	path     string
+	fallback string
+	kcp      string
+	kcpFEC   transport.FEC
+	grpc     string
+	grpcName string
+	dns      string
+	dnsZone  string
+}
+
+// startStreams запускает прием клиентов через TCP, WebSocket, KCP, gRPC и DNS. Их датаграммы
+// пересылаются на UDP сокет сервера, поэтому дальше они обрабатываются как обычные
+func (s *Server) startStreams() error {
+	if s.streams.tcp == "" && s.streams.wss == "" && s.streams.kcp == "" && s.streams.grpc == "" && s.streams.dns == "" {
+		return nil
+	}
+	target, err := transport.StreamTarget(s.listenAddrs[0])
+	if err != nil {
+		return fmt.Errorf("failed to resolve listen address: %w", err)
+	}
+
+	var fallback http.Handler
+	if s.streams.fallback != "" {
+		if fallback, err = newFallbackHandler(s.streams.fallback); err != nil {
+			return err
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if s.streams.wss != "" || s.streams.tcpTLS || s.streams.grpc != "" {
+		if tlsConfig, err = s.streamTLS(); err != nil {
+			return err
+		}
+	}
+
+	if s.streams.tcp != "" {
+		ln, err := net.Listen("tcp", s.streams.tcp)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.streams.tcp, err)
+		}
+		if s.streams.tcpTLS {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		s.tcpListener = ln
+		go transport.ServeTCP(ln, target, s.identities, fallback)
+		if s.streams.tcpTLS {
+			logTransport.Info("VPN server listening", "addr", s.streams.tcp, "transport", "tcp", "tls", true)
+		} else {
+			logTransport.Info("VPN server listening", "addr", s.streams.tcp, "transport", "tcp")
+		}
+	}
+
+	if s.streams.wss != "" {
+		ln, err := net.Listen("tcp", s.streams.wss)
+		if err != nil {
+			s.stopStreams()
+			return fmt.Errorf("failed to listen on %s: %w", s.streams.wss, err)
+		}
+		path := s.streams.path
+		if path == "" {
+			path = DefaultWSSPath
+		}
+		mux := http.NewServeMux()
+		ws := transport.WebSocketHandler(target, s.identities)
+		if fallback != nil {
+			mux.Handle("/", fallback)
+			ws = webSocketOrFallback(ws, fallback)
+		}
+		mux.Handle(path, ws)
+		s.wssServer = &http.Server{Handler: mux, TLSConfig: tlsConfig}
+		go func() {
+			if err := s.wssServer.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+				logTransport.Error("WebSocket server failed", logging.Err(err))
+			}
+		}()
+		logTransport.Info("VPN server listening", "addr", s.streams.wss+path, "transport", "wss")
+	}
+
+	if s.streams.kcp != "" {
+		ln, err := transport.ListenKCP(s.streams.kcp, s.streams.kcpFEC)
+		if err != nil {
+			s.stopStreams()
+			return err
+		}
+		s.kcpListener = ln
+		go transport.ServeKCP(ln, target)
+		if s.streams.kcpFEC.Enabled() {
+			logTransport.Info("VPN server listening", "addr", s.streams.kcp, "transport", "kcp", "fec", s.streams.kcpFEC.String())
+		} else {
+			logTransport.Info("VPN server listening", "addr", s.streams.kcp, "transport", "kcp")
+		}
+	}
+
+	if s.streams.grpc != "" {
+		ln, err := net.Listen("tcp", s.streams.grpc)
+		if err != nil {
+			s.stopStreams()
+			return fmt.Errorf("failed to listen on %s: %w", s.streams.grpc, err)
+		}
+		s.grpcServer = transport.NewGRPCServer(tlsConfig, s.streams.grpcName, target, s.identities)
+		go func() {
+			if err := s.grpcServer.Serve(ln); err != nil {
+				logTransport.Error("gRPC server failed", logging.Err(err))
+			}
+		}()
+		logTransport.Info("VPN server listening", "addr", s.streams.grpc, "transport", "grpc")
+	}
+
+	if s.streams.dns != "" {
+		addr, err := net.ResolveUDPAddr("udp", s.streams.dns)
+		if err != nil {
+			s.stopStreams()
+			return fmt.Errorf("invalid DNS tunnel address: %w", err)
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			s.stopStreams()
+			return fmt.Errorf("failed to listen on %s: %w", s.streams.dns, err)
+		}
+		s.dnsConn = conn
+		go transport.ServeDNS(conn, s.streams.dnsZone, target, s.handshakeRate)
+		logTransport.Info("VPN server listening", "addr", s.streams.dns, "transport", "dns", "zone", s.streams.dnsZone)
+	}
+	return nil
+}
+
+// streamTLS загружает сертификат TLS транспортов и, если задан центр сертификации клиентов,
+// требует от клиентов подписанный им сертификат
+func (s *Server) streamTLS() (*tls.Config, error) {
+	if s.streams.cert == "" || s.streams.key == "" {
+		return nil, errors.New("TLS listeners require a certificate and key")
+	}
+	cert, err := tls.LoadX509KeyPair(s.streams.cert, s.streams.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if s.streams.clientCA != "" {
+		if config.ClientCAs, err = transport.LoadClientCAs(s.streams.clientCA); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// certMismatch сообщает, что пакет сессии пришел через TLS соединение, сертификат которого
+// выдан не пиру сессии. Пир должен подключаться со своим сертификатом: имя из сертификата
+// совпадает с именем пира или с Certificate из его конфигурации. Сессии общего ключа
+// (DefaultPeer) принимаются с любым сертификатом, подписанным центром
+func (s *Server) certMismatch(addr *net.UDPAddr, sessionID uint64) bool {
+	identity, ok := s.identities.Lookup(addr)
+	if !ok {
+		return false
+	}
+	peer, _ := s.keyring.SessionPeer(sessionID)
+	if peer == DefaultPeer {
+		return false
+	}
+	expected := peer
+	if kp := s.keyPeer(peer); kp != nil && kp.certificate != "" {
+		expected = kp.certificate
+	}
+	return identity != expected
+}
+
+// stopStreams закрывает TCP, WebSocket, KCP, gRPC listeners и сокет DNS туннеля. Уже принятые
+// соединения закрываются по простою (transport.StreamIdleTimeout)
+func (s *Server) stopStreams() {
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+	if s.wssServer != nil {
+		s.wssServer.Close()
+	}
+	if s.kcpListener != nil {
+		s.kcpListener.Close()
+	}
+	if s.grpcServer != nil {
+		// Stop закрывает и принятые соединения: их потоки не ждут простоя, как у TCP
+		s.grpcServer.Stop()
+	}
+	if s.dnsConn != nil {
+		// Сессии DNS туннеля завершаются вместе с сокетом
+		s.dnsConn.Close()
+	}
+}
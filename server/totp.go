@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"myvpn/internal"
+	"myvpn/internal/logging"
+	"myvpn/internal/ratelimit"
+	"myvpn/internal/totp"
+)
+
+const (
+	// TOTPIssuer название сервиса в приложении-аутентификаторе
+	TOTPIssuer = "myvpn"
+
+	// totpAttempts сколько кодов в минуту проверяется для одного пира,
+	// чтобы код нельзя было подобрать, зная ключ
+	totpAttempts = 5
+)
+
+// totpState секреты TOTP пиров. Пиру с секретом сервер выдает конфигурацию
+// только по запросу с верным кодом
+type totpState struct {
+	secrets  map[string][]byte // пир -> секрет (защищено configMu)
+	encoded  map[string]string // те же секреты в base32 для сохранения в файл
+	lastStep map[string]uint64 // последний принятый шаг пира: код нельзя использовать дважды
+	file     string
+	attempts *ratelimit.Keyed[string]
+}
+
+// LoadTOTPSecrets загружает секреты TOTP пиров из JSON файла {"пир": "секрет в base32"}.
+// Отсутствующий файл - пустой список: его создаст admin API при выдаче первого секрета
+func LoadTOTPSecrets(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TOTP secrets file: %w", err)
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse TOTP secrets file %s: %w", path, err)
+	}
+	return secrets, nil
+}
+
+// newTOTPState проверяет секреты из конфигурации
+func newTOTPState(secrets map[string]string, file string) (*totpState, error) {
+	st := &totpState{
+		secrets:  make(map[string][]byte, len(secrets)),
+		encoded:  make(map[string]string, len(secrets)),
+		lastStep: make(map[string]uint64),
+		file:     file,
+		attempts: ratelimit.NewKeyed[string](totpAttempts/60.0, totpAttempts),
+	}
+	for peer, secret := range secrets {
+		key, err := totp.ParseSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("peer %q: %w", peer, err)
+		}
+		st.secrets[peer] = key
+		st.encoded[peer] = secret
+	}
+	return st, nil
+}
+
+// totpRequired сообщает, нужен ли пиру код TOTP
+func (s *Server) totpRequired(peer string) bool {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	_, ok := s.totp.secrets[peer]
+	return ok
+}
+
+// verifyTOTP проверяет код TOTP из запроса конфигурации сессии. Пиру без секрета код не нужен,
+// как и сессии, которая уже подтвердила код (переподключение, роуминг)
+func (s *Server) verifyTOTP(sessionID uint64, code string) *internal.Reject {
+	peer, _ := s.keyring.SessionPeer(sessionID)
+	if !s.totpRequired(peer) {
+		return nil
+	}
+	if s.sessionVerified(sessionID) {
+		return nil
+	}
+
+	reject := &internal.Reject{TOTP: true, RetryAfter: int(RejectRetryAfter / time.Second)}
+	if code == "" {
+		reject.Reason = "TOTP code required"
+		return reject
+	}
+	if !s.totp.attempts.Allow(peer) {
+		reject.Reason = "too many TOTP attempts, try again later"
+		return reject
+	}
+
+	s.configMu.Lock()
+	step, ok := totp.Verify(s.totp.secrets[peer], code, time.Now(), s.totp.lastStep[peer])
+	if ok {
+		s.totp.lastStep[peer] = step
+	}
+	s.configMu.Unlock()
+	if !ok {
+		metricTOTPFailures.Inc()
+		logAuth.Warn("Invalid TOTP code", "peer", peer, logging.Session(sessionID))
+		reject.Reason = "invalid TOTP code"
+		return reject
+	}
+	logAuth.Info("Peer passed TOTP verification", "peer", peer, logging.Session(sessionID))
+	return nil
+}
+
+// sessionVerified сообщает, что сессия уже получила конфигурацию, т.е. прошла TOTP
+// и внешнюю аутентификацию, если они требуются
+func (s *Server) sessionVerified(sessionID uint64) bool {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	_, ok := s.params[sessionID]
+	return ok
+}
+
+// EnableTOTP создает пиру новый секрет TOTP и возвращает его. Следующие сессии пира
+// получат конфигурацию только с кодом, уже подключенные продолжают работать
+func (s *Server) EnableTOTP(name string) (string, error) {
+	if !s.keyring.Has(name) {
+		return "", fmt.Errorf("peer %q not found", name)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	key, err := totp.ParseSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.totp.secrets[name] = key
+	s.totp.encoded[name] = secret
+	delete(s.totp.lastStep, name)
+	if err := s.saveTOTPSecrets(); err != nil {
+		return "", err
+	}
+	logAuth.Info("TOTP enabled", "peer", name)
+	return secret, nil
+}
+
+// DisableTOTP удаляет секрет TOTP пира. Возвращает false, если секрета не было
+func (s *Server) DisableTOTP(name string) (bool, error) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if _, ok := s.totp.secrets[name]; !ok {
+		return false, nil
+	}
+	delete(s.totp.secrets, name)
+	delete(s.totp.encoded, name)
+	delete(s.totp.lastStep, name)
+	if err := s.saveTOTPSecrets(); err != nil {
+		return true, err
+	}
+	logAuth.Info("TOTP disabled", "peer", name)
+	return true, nil
+}
+
+// saveTOTPSecrets записывает секреты в файл, если он задан. Файл заменяется атомарно,
+// чтобы сбой при записи не оставил сервер без секретов. Вызывается под configMu
+func (s *Server) saveTOTPSecrets() error {
+	if s.totp.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.totp.encoded, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.totp.file), ".totp-*")
+	if err != nil {
+		return fmt.Errorf("failed to save TOTP secrets: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save TOTP secrets: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save TOTP secrets: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.totp.file); err != nil {
+		return fmt.Errorf("failed to save TOTP secrets: %w", err)
+	}
+	return nil
+}
@@ -2,12 +2,19 @@ package server
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
-	"os/exec"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"myvpn/internal"
+	"myvpn/internal/logging"
+	"myvpn/internal/netlink"
+	"myvpn/internal/transport"
+	"myvpn/internal/uring"
+	"myvpn/internal/vnethdr"
 
 	"golang.org/x/sys/unix"
 )
@@ -15,6 +22,10 @@ import (
 const (
 	// TUNInterfaceName имя TUN интерфейса
 	TUNInterfaceName = "myvpn0"
+	// TUNAddress IPv4 адрес сервера внутри VPN подсети
+	TUNAddress = "10.0.0.1/24"
+	// TUNAddress6 IPv6 адрес сервера внутри VPN подсети
+	TUNAddress6 = "fd00::1/64"
 )
 
 // TUNMTU использует константу из internal пакета
@@ -22,61 +33,178 @@ var TUNMTU = internal.TUNMTU
 
 // TUN представляет TUN интерфейс
 type TUN struct {
-	file *os.File
-	name string
+	files []*os.File // по одному дескриптору на очередь
+	rings []*uring.Conn
+	name  string
+
+	// С offload (IFF_VNET_HDR) у каждой очереди свой разборщик сегментов
+	// и RawConn для записи пакетов с заголовком
+	vnet []*vnethdr.Reader
+	raws []syscall.RawConn
+
+	// netstack userspace стек вместо интерфейса (NewNetstackTUN): одна очередь без файлов
+	netstack *netstackDevice
+
+	// noIPv6 - на интерфейсе нет TUNAddress6 (IPv6 выключен в ядре), сервер работает только по IPv4
+	noIPv6 bool
+}
+
+// NewTUN создает новый TUN интерфейс. При queues > 1 устройство открывается с IFF_MULTI_QUEUE
+// и каждая очередь получает свой файловый дескриптор. offload включает IFF_VNET_HDR,
+// TSO и checksum offload: ядро отдает большие TCP сегменты, которые раскладываются на пакеты
+// при чтении
+func NewTUN(name string, queues int, offload bool) (*TUN, error) {
+	if queues < 1 || queues > internal.MaxTUNQueues {
+		return nil, fmt.Errorf("invalid number of TUN queues: %d (1-%d)", queues, internal.MaxTUNQueues)
+	}
+
+	tun := &TUN{}
+	for i := 0; i < queues; i++ {
+		file, actualName, err := openQueue(name, queues > 1, offload)
+		if err != nil {
+			tun.Close()
+			return nil, err
+		}
+		if offload {
+			raw, err := file.SyscallConn()
+			if err != nil {
+				file.Close()
+				tun.Close()
+				return nil, err
+			}
+			tun.vnet = append(tun.vnet, vnethdr.NewReader())
+			tun.raws = append(tun.raws, raw)
+		}
+		// Остальные очереди привязываем к интерфейсу, который создала первая
+		// (ядро могло подставить номер в шаблон имени)
+		tun.name = actualName
+		name = actualName
+		tun.files = append(tun.files, file)
+	}
+
+	// Настраиваем интерфейс
+	if err := tun.setup(); err != nil {
+		tun.Close()
+		return nil, fmt.Errorf("failed to setup TUN interface: %w", err)
+	}
+
+	return tun, nil
+}
+
+// NewTUNFromFD использует TUN дескриптор fd, который открыл и настроил другой процесс
+// (привилегированная обертка): адреса TUNAddress и TUNAddress6, MTU и состояние интерфейса
+// задает он. Offload включается, если дескриптор открыт с IFF_VNET_HDR
+func NewTUNFromFD(fd int) (*TUN, error) {
+	file, name, offload, err := adoptFD(fd)
+	if err != nil {
+		return nil, err
+	}
+	tun := &TUN{files: []*os.File{file}, name: name, noIPv6: !hasAddress(name, TUNAddress6)}
+	if offload {
+		raw, err := file.SyscallConn()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		tun.vnet = []*vnethdr.Reader{vnethdr.NewReader()}
+		tun.raws = []syscall.RawConn{raw}
+	}
+	return tun, nil
+}
+
+// adoptFD проверяет, что fd - дескриптор TUN без заголовка packet info, и возвращает
+// его файл, имя интерфейса и признак IFF_VNET_HDR
+func adoptFD(fd int) (*os.File, string, bool, error) {
+	var ifreq [unix.IFNAMSIZ + 64]byte
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(fd),
+		uintptr(unix.TUNGETIFF),
+		uintptr(unsafe.Pointer(&ifreq[0])),
+	)
+	if errno != 0 {
+		return nil, "", false, fmt.Errorf("descriptor %d is not a TUN device: %v", fd, errno)
+	}
+	flags := *(*uint16)(unsafe.Pointer(&ifreq[unix.IFNAMSIZ]))
+	if flags&unix.IFF_TUN == 0 || flags&unix.IFF_NO_PI == 0 {
+		return nil, "", false, fmt.Errorf("descriptor %d must be a TUN device opened with IFF_TUN|IFF_NO_PI", fd)
+	}
+	offload := flags&unix.IFF_VNET_HDR != 0
+	if offload {
+		if err := unix.IoctlSetInt(fd, unix.TUNSETOFFLOAD, vnethdr.Offload); err != nil {
+			return nil, "", false, fmt.Errorf("failed to enable TUN offload: %w", err)
+		}
+	}
+	// Неблокирующий дескриптор os.NewFile регистрирует в poller'е: без этого Close
+	// не прерывал бы чтение
+	if err := unix.SetNonblock(fd, true); err != nil {
+		return nil, "", false, fmt.Errorf("failed to set TUN descriptor non-blocking: %w", err)
+	}
+	unix.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), "tun-fd"), getInterfaceName(ifreq), offload, nil
 }
 
-// NewTUN создает новый TUN интерфейс
-func NewTUN(name string) (*TUN, error) {
+// openQueue открывает /dev/net/tun и привязывает дескриптор к интерфейсу name.
+// Дескриптор открывается не через os.OpenFile: Go регистрирует файл в poller'е при открытии,
+// а до TUNSETIFF ядро не сообщает о готовности дескриптора, и ожидание чтения
+// (RawConn, io_uring) никогда бы не просыпалось. os.NewFile регистрирует уже настроенный
+// неблокирующий дескриптор
+func openQueue(name string, multiQueue, offload bool) (*os.File, string, error) {
 	// Открываем файл устройства TUN
-	file, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open TUN device: %w", err)
+		return nil, "", fmt.Errorf("failed to open TUN device: %w", err)
 	}
 
 	// Настраиваем TUN интерфейс
-	ifreq, err := createInterfaceRequest(name)
+	ifreq, err := createInterfaceRequest(name, multiQueue, offload)
 	if err != nil {
-		file.Close()
-		return nil, err
+		unix.Close(fd)
+		return nil, "", err
 	}
 
 	// Выполняем ioctl для создания интерфейса
 	_, _, errno := syscall.Syscall(
 		syscall.SYS_IOCTL,
-		file.Fd(),
+		uintptr(fd),
 		uintptr(unix.TUNSETIFF),
 		uintptr(unsafe.Pointer(&ifreq[0])),
 	)
 
 	if errno != 0 {
-		file.Close()
-		return nil, fmt.Errorf("failed to create TUN interface: %v", errno)
+		unix.Close(fd)
+		return nil, "", fmt.Errorf("failed to create TUN interface: %v", errno)
 	}
 
-	// Получаем реальное имя интерфейса
-	actualName := getInterfaceName(ifreq)
-
-	tun := &TUN{
-		file: file,
-		name: actualName,
+	if offload {
+		if err := unix.IoctlSetInt(fd, unix.TUNSETOFFLOAD, vnethdr.Offload); err != nil {
+			unix.Close(fd)
+			return nil, "", fmt.Errorf("failed to enable TUN offload: %w", err)
+		}
 	}
 
-	// Настраиваем интерфейс
-	if err := tun.setup(); err != nil {
-		tun.Close()
-		return nil, fmt.Errorf("failed to setup TUN interface: %w", err)
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, "", fmt.Errorf("failed to set TUN descriptor non-blocking: %w", err)
 	}
 
-	return tun, nil
+	// Получаем реальное имя интерфейса
+	return os.NewFile(uintptr(fd), "/dev/net/tun"), getInterfaceName(ifreq), nil
 }
 
 // createInterfaceRequest создает структуру ifreq для ioctl
-func createInterfaceRequest(name string) ([unix.IFNAMSIZ + 64]byte, error) {
+func createInterfaceRequest(name string, multiQueue, vnetHdr bool) ([unix.IFNAMSIZ + 64]byte, error) {
 	var ifr [unix.IFNAMSIZ + 64]byte
 	copy(ifr[:], name)
 	// Устанавливаем флаг IFF_TUN (без IFF_NO_PI для получения чистых IP пакетов)
-	*(*uint16)(unsafe.Pointer(&ifr[unix.IFNAMSIZ])) = unix.IFF_TUN | unix.IFF_NO_PI
+	flags := uint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if multiQueue {
+		flags |= unix.IFF_MULTI_QUEUE
+	}
+	if vnetHdr {
+		flags |= unix.IFF_VNET_HDR
+	}
+	*(*uint16)(unsafe.Pointer(&ifr[unix.IFNAMSIZ])) = flags
 	return ifr, nil
 }
 
@@ -96,34 +224,177 @@ func getInterfaceName(ifr [unix.IFNAMSIZ + 64]byte) string {
 // setup настраивает TUN интерфейс (IP адрес, MTU, поднимает интерфейс)
 func (t *TUN) setup() error {
 	// Настраиваем IP адрес интерфейса (10.0.0.1/24)
-	cmd := exec.Command("ip", "addr", "add", "10.0.0.1/24", "dev", t.name)
-	if err := cmd.Run(); err != nil {
+	if err := netlink.AddrAdd(t.name, netip.MustParsePrefix(TUNAddress)); err != nil {
 		return fmt.Errorf("failed to set IP address: %w", err)
 	}
 
+	// Настраиваем IPv6 адрес (без DAD: на TUN нет соседей, DAD только задерживает адрес).
+	// На хостах с ipv6.disable=1 адрес не назначить - тогда сервер работает только по IPv4
+	if err := netlink.AddrAdd(t.name, netip.MustParsePrefix(TUNAddress6)); err != nil {
+		logTUN.Warn("Failed to set IPv6 address, running IPv4-only", "name", t.name, logging.Err(err))
+		t.noIPv6 = true
+	}
+
 	// Устанавливаем MTU
-	cmd = exec.Command("ip", "link", "set", "dev", t.name, "mtu", fmt.Sprintf("%d", internal.TUNMTU))
-	if err := cmd.Run(); err != nil {
+	if err := netlink.LinkSetMTU(t.name, internal.TUNMTU); err != nil {
 		return fmt.Errorf("failed to set MTU: %w", err)
 	}
 
 	// Поднимаем интерфейс
-	cmd = exec.Command("ip", "link", "set", "dev", t.name, "up")
-	if err := cmd.Run(); err != nil {
+	if err := netlink.LinkSetUp(t.name); err != nil {
 		return fmt.Errorf("failed to bring interface up: %w", err)
 	}
 
 	return nil
 }
 
-// Read читает IP пакет из TUN интерфейса
+// HasIPv6 сообщает, назначен ли интерфейсу IPv6 адрес TUNAddress6
+func (t *TUN) HasIPv6() bool {
+	return !t.noIPv6
+}
+
+// hasAddress проверяет, назначен ли интерфейсу name адрес prefix
+func hasAddress(name, prefix string) bool {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return false
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	want := netip.MustParsePrefix(prefix).Addr()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipNet.IP); ok && ip.Unmap() == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read читает IP пакет из первой очереди TUN интерфейса
 func (t *TUN) Read(packet []byte) (int, error) {
-	return t.file.Read(packet)
+	return t.ReadQueue(0, packet)
 }
 
-// Write записывает IP пакет в TUN интерфейс
+// Write записывает IP пакет в первую очередь TUN интерфейса
 func (t *TUN) Write(packet []byte) (int, error) {
-	return t.file.Write(packet)
+	return t.WriteQueue(0, packet)
+}
+
+// Queues возвращает число очередей
+func (t *TUN) Queues() int {
+	if t.netstack != nil {
+		return 1
+	}
+	return len(t.files)
+}
+
+// ReadQueue читает IP пакет из очереди queue
+func (t *TUN) ReadQueue(queue int, packet []byte) (int, error) {
+	if t.netstack != nil {
+		return t.netstack.read(packet)
+	}
+	if t.vnet != nil {
+		return t.vnet[queue].ReadPacket(t.files[queue], packet)
+	}
+	return t.files[queue].Read(packet)
+}
+
+// WriteQueue записывает IP пакет в очередь queue
+func (t *TUN) WriteQueue(queue int, packet []byte) (int, error) {
+	if t.netstack != nil {
+		return t.netstack.write(packet)
+	}
+	if t.vnet != nil {
+		return t.writeVnet(queue, packet)
+	}
+	return t.files[queue].Write(packet)
+}
+
+// writeVnet записывает пакет с заголовком virtio-net, ожидая готовности очереди в poller'е
+func (t *TUN) writeVnet(queue int, packet []byte) (int, error) {
+	var n int
+	var opErr error
+	err := t.raws[queue].Write(func(fd uintptr) bool {
+		n, opErr = vnethdr.Write(int(fd), packet)
+		return opErr != unix.EAGAIN
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, opErr
+}
+
+// Offload сообщает, включен ли offload (IFF_VNET_HDR): тогда одно чтение из очереди
+// может дать пачку пакетов
+func (t *TUN) Offload() bool {
+	return t.vnet != nil
+}
+
+// UseIOURing переводит ReadQueueBatch и WriteQueueBatch на io_uring: пачка пакетов
+// очереди читается или записывается одним io_uring_enter
+func (t *TUN) UseIOURing() error {
+	for _, file := range t.files {
+		raw, err := file.SyscallConn()
+		if err != nil {
+			return err
+		}
+		ring, err := uring.NewConn(raw, uint32(transport.BatchSize))
+		if err != nil {
+			return fmt.Errorf("failed to set up io_uring: %w", err)
+		}
+		t.rings = append(t.rings, ring)
+	}
+	return nil
+}
+
+// ReadQueueBatch читает пакеты из очереди queue в bufs, длины пакетов записываются в sizes.
+// С offload читает один сегмент и раскладывает его на пакеты (io_uring для чтения
+// не используется), без offload и io_uring читает один пакет. Возвращает число прочитанных пакетов
+func (t *TUN) ReadQueueBatch(queue int, bufs [][]byte, sizes []int) (int, error) {
+	if t.vnet != nil {
+		return t.vnet[queue].ReadBatch(t.files[queue], bufs, sizes)
+	}
+	if t.rings == nil {
+		n, err := t.ReadQueue(queue, bufs[0])
+		if err != nil {
+			return 0, err
+		}
+		sizes[0] = n
+		return 1, nil
+	}
+	return t.rings[queue].Read(bufs, sizes)
+}
+
+// WriteQueueBatch записывает пакеты в очередь queue. Без io_uring пишет по одному.
+// Возвращает число записанных пакетов; при ошибке следующий за ними пакет не записан
+func (t *TUN) WriteQueueBatch(queue int, packets [][]byte) (int, error) {
+	if t.rings == nil {
+		for i, packet := range packets {
+			if _, err := t.WriteQueue(queue, packet); err != nil {
+				return i, err
+			}
+		}
+		return len(packets), nil
+	}
+	written := 0
+	for written < len(packets) {
+		var n int
+		var err error
+		if t.vnet != nil {
+			n, err = t.rings[queue].WritePrefixed(vnethdr.Header(), packets[written:])
+		} else {
+			n, err = t.rings[queue].Write(packets[written:])
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
 }
 
 // Name возвращает имя интерфейса
@@ -131,15 +402,45 @@ func (t *TUN) Name() string {
 	return t.name
 }
 
-// Close закрывает TUN интерфейс
+// Userspace сообщает, что интерфейса в системе нет: пакеты принимает userspace стек
+func (t *TUN) Userspace() bool {
+	return t.netstack != nil
+}
+
+// InterruptReads прерывает ожидающие и будущие чтения всех очередей ошибкой
+// os.ErrDeadlineExceeded, не закрывая дескрипторы: без входящих пакетов читающие
+// горутины иначе не заметили бы остановку сервера
+func (t *TUN) InterruptReads() {
+	if t.netstack != nil {
+		t.netstack.interrupt()
+	}
+	for _, file := range t.files {
+		file.SetReadDeadline(time.Now())
+	}
+}
+
+// Close закрывает все очереди TUN интерфейса
 func (t *TUN) Close() error {
-	if t.file != nil {
-		return t.file.Close()
+	if t.netstack != nil {
+		return t.netstack.Close()
 	}
-	return nil
+	var firstErr error
+	for _, file := range t.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, ring := range t.rings {
+		ring.Close()
+	}
+	return firstErr
 }
 
-// File возвращает файловый дескриптор для использования в select/poll
+// File возвращает файловый дескриптор первой очереди для использования в select/poll
+// (nil без интерфейса в системе)
 func (t *TUN) File() *os.File {
-	return t.file
+	if len(t.files) == 0 {
+		return nil
+	}
+	return t.files[0]
 }